@@ -0,0 +1,184 @@
+package agents
+
+import (
+	"Hippocampus/src/client"
+	"Hippocampus/src/storage"
+	"fmt"
+	"log"
+	"time"
+)
+
+// Stats counts the agents a manager holds and what happened to them since
+// it was created
+type Stats struct {
+	Loaded    int           // Agents in memory
+	Loading   int           // Agents being loaded or restored
+	MaxAgents int           // 0 means no limit
+	IdleEvict time.Duration // 0 means idle agents are kept
+	Created   int64         // Agents registered, new or loaded from disk
+	Dropped   int64         // Agents deleted, including idle in-memory ones
+	Evicted   int64         // Idle agents unloaded or dropped
+	Rejected  int64         // Agents refused by WithMaxAgents
+}
+
+func (m *Manager) Stats() Stats {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return Stats{
+		Loaded:    len(m.clients),
+		Loading:   len(m.loading),
+		MaxAgents: m.maxAgents,
+		IdleEvict: m.idleTimeout,
+		Created:   m.created.Load(),
+		Dropped:   m.dropCount.Load(),
+		Evicted:   m.evicted.Load(),
+		Rejected:  m.rejected.Load(),
+	}
+}
+
+// touchLocked restarts an agent's idle time. The read lock is enough.
+func (m *Manager) touchLocked(agentID string) {
+	if used := m.lastUsed[agentID]; used != nil {
+		used.Store(time.Now().UnixNano())
+	}
+}
+
+// checkLimitLocked returns ErrTooManyAgents if another agent would exceed
+// WithMaxAgents
+func (m *Manager) checkLimitLocked() error {
+	if m.maxAgents > 0 && len(m.clients)+len(m.loading) >= m.maxAgents {
+		m.rejected.Add(1)
+		return fmt.Errorf("%w (max %d)", ErrTooManyAgents, m.maxAgents)
+	}
+	return nil
+}
+
+// EvictIdle evicts every agent not fetched with Get or GetOrCreate for the
+// WithIdleEviction time, returning their IDs. Agents in the data directory
+// are saved and unloaded, to be loaded again on next use; in-memory agents
+// are dropped with their memories, as when their TTL expires. Agents in
+// other storage, such as files attached from elsewhere, are kept since they
+// couldn't be found again. An agent whose save fails is logged and kept.
+//
+// Saving happens before the manager's lock is taken, so other agents aren't
+// held up by the disk; an agent used meanwhile is kept, as is one whose
+// storage changed, e.g. with client.MigrateStorage.
+func (m *Manager) EvictIdle() []string {
+	if m.idleTimeout <= 0 {
+		return nil
+	}
+	cutoff := time.Now().Add(-m.idleTimeout).UnixNano()
+
+	idle := m.idleClients(cutoff)
+	for agentID, a := range idle {
+		if _, ok := a.storage.(*storage.FileStorage); !ok {
+			continue
+		}
+		if err := a.client.Flush(); err != nil {
+			log.Printf("Failed to save idle agent %s, keeping it loaded: %v", agentID, err)
+			delete(idle, agentID)
+		}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var evicted []string
+	for agentID, a := range idle {
+		c := a.client
+		if m.clients[agentID] != c || c.CurrentStorage() != a.storage {
+			continue
+		}
+		if used := m.lastUsed[agentID]; used != nil && used.Load() > cutoff {
+			continue
+		}
+
+		switch a.storage.(type) {
+		case *storage.MemoryStorage:
+			if _, err := m.dropLocked(agentID); err != nil {
+				log.Printf("Failed to evict agent %s: %v", agentID, err)
+				continue
+			}
+		case *storage.FileStorage:
+			// Saves anything written between the flush above and the lock
+			if err := c.Flush(); err != nil {
+				log.Printf("Failed to save idle agent %s, keeping it loaded: %v", agentID, err)
+				continue
+			}
+			delete(m.clients, agentID)
+			delete(m.lastUsed, agentID)
+		}
+
+		m.evicted.Add(1)
+		evicted = append(evicted, agentID)
+		if m.onEvict != nil {
+			m.onEvict(agentID)
+		}
+	}
+	return evicted
+}
+
+// idleAgent is an eviction candidate with the storage it was judged by
+type idleAgent struct {
+	client  *client.Client
+	storage storage.Storage
+}
+
+// idleClients returns the agents unused since cutoff that EvictIdle can
+// evict: in-memory agents and agents stored in the data directory
+func (m *Manager) idleClients(cutoff int64) map[string]idleAgent {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	idle := make(map[string]idleAgent)
+	for agentID, c := range m.clients {
+		if used := m.lastUsed[agentID]; used != nil && used.Load() > cutoff {
+			continue
+		}
+		switch st := c.CurrentStorage().(type) {
+		case *storage.MemoryStorage:
+			idle[agentID] = idleAgent{c, st}
+		case *storage.FileStorage:
+			path, err := m.Path(agentID)
+			if m.dataDir != "" && err == nil && absPath(st.Path()) == absPath(path) {
+				idle[agentID] = idleAgent{c, st}
+			}
+		}
+	}
+	return idle
+}
+
+// StartEviction runs EvictIdle in the background until StopEviction, if
+// WithIdleEviction is set. It checks a quarter of the idle time apart,
+// between once a second and once a minute.
+func (m *Manager) StartEviction() {
+	if m.idleTimeout <= 0 || m.evictStop != nil {
+		return
+	}
+	interval := min(max(m.idleTimeout/4, time.Second), time.Minute)
+	m.evictStop = make(chan struct{})
+	m.evictDone = make(chan struct{})
+	go func() {
+		defer close(m.evictDone)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-m.evictStop:
+				return
+			case <-ticker.C:
+				m.EvictIdle()
+			}
+		}
+	}()
+}
+
+// StopEviction stops the background eviction, waiting for a pass in progress
+func (m *Manager) StopEviction() {
+	if m.evictStop == nil {
+		return
+	}
+	close(m.evictStop)
+	<-m.evictDone
+	m.evictStop = nil
+}
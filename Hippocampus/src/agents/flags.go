@@ -0,0 +1,40 @@
+package agents
+
+import (
+	"flag"
+	"fmt"
+	"time"
+)
+
+// Flags are the agent limit flags shared by the Redis and HTTP servers
+type Flags struct {
+	MaxAgents int
+	IdleEvict time.Duration
+}
+
+// RegisterFlags adds -max-agents and -idle-evict to fs
+func RegisterFlags(fs *flag.FlagSet) *Flags {
+	f := &Flags{}
+	fs.IntVar(&f.MaxAgents, "max-agents", 0, "Most agents loaded at once; more are refused (0 means no limit)")
+	fs.DurationVar(&f.IdleEvict, "idle-evict", 0, "Unload data-directory agents and drop in-memory agents unused for this long (0 keeps them)")
+	return f
+}
+
+// Options returns the manager options the flags describe
+func (f *Flags) Options() ([]Option, error) {
+	if f.MaxAgents < 0 || f.IdleEvict < 0 {
+		return nil, fmt.Errorf("-max-agents and -idle-evict must be non-negative")
+	}
+	return []Option{WithMaxAgents(f.MaxAgents), WithIdleEviction(f.IdleEvict)}, nil
+}
+
+func (f *Flags) String() string {
+	limit := "no agent limit"
+	if f.MaxAgents > 0 {
+		limit = fmt.Sprintf("at most %d agents loaded", f.MaxAgents)
+	}
+	if f.IdleEvict > 0 {
+		return fmt.Sprintf("%s, evicting agents idle for %s", limit, f.IdleEvict)
+	}
+	return limit
+}
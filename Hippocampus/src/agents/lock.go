@@ -0,0 +1,36 @@
+package agents
+
+import "sync"
+
+// agentLock is one agent's LockAgent mutex, kept while anyone holds or waits
+// for it
+type agentLock struct {
+	mu   sync.Mutex
+	refs int
+}
+
+// LockAgent locks an agent for a sequence of client calls that must not
+// interleave with another caller's, returning the unlock function. It is
+// independent of the manager's lock and of the agent being loaded, and
+// other agents are unaffected.
+func (m *Manager) LockAgent(agentID string) (unlock func()) {
+	m.locksMu.Lock()
+	l := m.agentLocks[agentID]
+	if l == nil {
+		l = &agentLock{}
+		m.agentLocks[agentID] = l
+	}
+	l.refs++
+	m.locksMu.Unlock()
+
+	l.mu.Lock()
+	return func() {
+		l.mu.Unlock()
+
+		m.locksMu.Lock()
+		defer m.locksMu.Unlock()
+		if l.refs--; l.refs == 0 {
+			delete(m.agentLocks, agentID)
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package agents
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestLockAgentSerializesOneAgent(t *testing.T) {
+	m := newTestManager(t)
+
+	var holders, maxHolders atomic.Int32
+	var wg sync.WaitGroup
+	for range 20 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			unlock := m.LockAgent("alice")
+			defer unlock()
+
+			n := holders.Add(1)
+			if n > maxHolders.Load() {
+				maxHolders.Store(n)
+			}
+			time.Sleep(time.Millisecond)
+			holders.Add(-1)
+		}()
+	}
+	wg.Wait()
+
+	if maxHolders.Load() != 1 {
+		t.Fatalf("%d callers held alice's lock at once", maxHolders.Load())
+	}
+	m.locksMu.Lock()
+	defer m.locksMu.Unlock()
+	if len(m.agentLocks) != 0 {
+		t.Fatalf("%d agent locks kept after release", len(m.agentLocks))
+	}
+}
+
+func TestLockAgentLeavesOthersFree(t *testing.T) {
+	m := newTestManager(t)
+	unlock := m.LockAgent("alice")
+	defer unlock()
+
+	done := make(chan struct{})
+	go func() {
+		m.LockAgent("bob")()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("locking bob waited for alice")
+	}
+}
@@ -0,0 +1,818 @@
+// Package agents keeps the client of every agent a server holds, in memory
+// or in a file per agent in a data directory, creating and loading them on
+// first use. The Redis and HTTP servers share it.
+package agents
+
+import (
+	"Hippocampus/src/client"
+	"Hippocampus/src/embedding"
+	"Hippocampus/src/storage"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// FileExt is the extension of agent files in a data directory
+const FileExt = ".bin"
+
+// LoadingError is returned for an agent that is being loaded or restored by
+// another caller
+type LoadingError struct {
+	Agent string
+}
+
+func (e *LoadingError) Error() string {
+	return fmt.Sprintf("agent %s is loading", e.Agent)
+}
+
+// ErrTooManyAgents is returned for a new or unloaded agent once WithMaxAgents
+// agents are loaded
+var ErrTooManyAgents = errors.New("too many agents loaded")
+
+// ErrAgentExists is returned by Install for an agent that already exists
+var ErrAgentExists = errors.New("agent already exists")
+
+// InvalidIDError is returned for an agent ID that can't name a file
+type InvalidIDError struct {
+	Agent string
+}
+
+func (e *InvalidIDError) Error() string {
+	return fmt.Sprintf("agent ID %q can't be used as a file name in the data directory", e.Agent)
+}
+
+// Manager holds one client per agent. Every method locks for itself;
+// changes to several agents that must happen at once, such as DeleteMany or
+// Install, are methods of their own, and Update runs a caller's change to
+// one agent under the lock.
+//
+// The manager's lock guards the set of agents only, and is held briefly so
+// that one agent's slow operation never stalls commands for another. Clients
+// lock themselves, so calls on one agent may run concurrently; a caller
+// whose sequence of calls must run alone, such as a check followed by a
+// write, takes LockAgent.
+type Manager struct {
+	mu      sync.RWMutex
+	clients map[string]*client.Client
+	loading map[string]bool // Agents being loaded or restored, see StartLoading
+
+	locksMu    sync.Mutex
+	agentLocks map[string]*agentLock // See LockAgent
+
+	embedder    embedding.EmbeddingService
+	embedderFor func(agentID string) embedding.EmbeddingService // Optional, see WithEmbedderFor
+	storageFor  func(agentID string) (storage.Storage, error)   // Optional, see WithStorageFor
+	dataDir     string                                          // Agents are stored here when set, otherwise in memory
+
+	onCreate func(agentID string)                             // Called once an agent is registered
+	onDrop   func(agentID string)                             // Called once an agent is dropped
+	onEvict  func(agentID string)                             // Called once an idle agent is evicted
+	onChange []func(agentID string, event client.ChangeEvent) // See WithChangeHook
+
+	maxAgents   int                      // 0 means no limit, see WithMaxAgents
+	idleTimeout time.Duration            // 0 disables eviction, see WithIdleEviction
+	lastUsed    map[string]*atomic.Int64 // Unix nanoseconds, set under the read lock
+	evictStop   chan struct{}
+	evictDone   chan struct{}
+
+	created, dropCount, evicted, rejected atomic.Int64 // See Stats
+
+	savedMu    sync.Mutex
+	savedFiles map[string]bool // Absolute paths SaveAll wrote or LoadSaved read; the only files SaveAll removes
+}
+
+// Option configures a Manager
+type Option func(*Manager)
+
+// WithDataDir stores each agent in dir/<agent_id>.bin instead of in memory.
+// Agents already in dir are loaded on first use.
+func WithDataDir(dir string) Option {
+	return func(m *Manager) {
+		m.dataDir = dir
+	}
+}
+
+// WithEmbedderFor picks the embedder of each new client, in place of the
+// manager's one
+func WithEmbedderFor(embedderFor func(agentID string) embedding.EmbeddingService) Option {
+	return func(m *Manager) {
+		m.embedderFor = embedderFor
+	}
+}
+
+// WithStorageFor picks the storage of each new agent that isn't kept in the
+// data directory, in place of the default in-memory storage
+func WithStorageFor(storageFor func(agentID string) (storage.Storage, error)) Option {
+	return func(m *Manager) {
+		m.storageFor = storageFor
+	}
+}
+
+// WithMaxAgents caps the agents loaded at once: beyond n, creating or
+// loading another returns ErrTooManyAgents. Agents registered directly with
+// Install aren't refused but count towards the cap.
+func WithMaxAgents(n int) Option {
+	return func(m *Manager) {
+		m.maxAgents = n
+	}
+}
+
+// WithIdleEviction evicts agents not fetched for idle, see EvictIdle. The
+// check runs between StartEviction and StopEviction.
+func WithIdleEviction(idle time.Duration) Option {
+	return func(m *Manager) {
+		m.idleTimeout = idle
+	}
+}
+
+// WithEvictHook calls onEvict after an idle agent is evicted, under the
+// manager's lock
+func WithEvictHook(onEvict func(agentID string)) Option {
+	return func(m *Manager) {
+		m.onEvict = onEvict
+	}
+}
+
+// WithHooks calls onCreate after an agent is registered and onDrop after it
+// is dropped, under the manager's lock. Either may be nil.
+func WithHooks(onCreate, onDrop func(agentID string)) Option {
+	return func(m *Manager) {
+		m.onCreate = onCreate
+		m.onDrop = onDrop
+	}
+}
+
+// WithChangeHook registers hook with client.OnChange on every agent's
+// client, so it sees each change to any agent. Dropping an agent is reported
+// as an OpReset. It may be given more than once.
+func WithChangeHook(hook func(agentID string, event client.ChangeEvent)) Option {
+	return func(m *Manager) {
+		m.onChange = append(m.onChange, hook)
+	}
+}
+
+func NewManager(embedder embedding.EmbeddingService, opts ...Option) *Manager {
+	m := &Manager{
+		clients:  make(map[string]*client.Client),
+		loading:  make(map[string]bool),
+		lastUsed: make(map[string]*atomic.Int64),
+
+		agentLocks: make(map[string]*agentLock),
+		embedder:   embedder,
+		savedFiles: make(map[string]bool),
+	}
+	for _, opt := range opts {
+		opt(m)
+	}
+	return m
+}
+
+// Embedder returns the embedder new agents use unless WithEmbedderFor picks
+// another
+func (m *Manager) Embedder() embedding.EmbeddingService {
+	return m.embedder
+}
+
+// DataDir returns the data directory, or "" if agents are kept in memory
+func (m *Manager) DataDir() string {
+	return m.dataDir
+}
+
+// FilePath returns the file of an agent in dir
+func FilePath(dir, agentID string) (string, error) {
+	if agentID == "" || agentID == "." || agentID == ".." || strings.ContainsAny(agentID, "/\\\x00") {
+		return "", &InvalidIDError{Agent: agentID}
+	}
+	return filepath.Join(dir, agentID+FileExt), nil
+}
+
+// Path returns the data directory file of an agent
+func (m *Manager) Path(agentID string) (string, error) {
+	return FilePath(m.dataDir, agentID)
+}
+
+// OnDisk reports whether an agent has a file in the data directory
+func (m *Manager) OnDisk(agentID string) bool {
+	if m.dataDir == "" {
+		return false
+	}
+	path, err := m.Path(agentID)
+	if err != nil {
+		return false
+	}
+	_, err = os.Stat(path)
+	return err == nil
+}
+
+// DiskAgent is an agent file found in a directory
+type DiskAgent struct {
+	ID       string
+	Modified time.Time
+}
+
+// Files lists the agent files in dir; a missing dir holds none
+func Files(dir string) ([]DiskAgent, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	agents := make([]DiskAgent, 0, len(entries))
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, FileExt) {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue // Removed since ReadDir
+		}
+		agents = append(agents, DiskAgent{
+			ID:       strings.TrimSuffix(name, FileExt),
+			Modified: info.ModTime(),
+		})
+	}
+	return agents, nil
+}
+
+// DiskAgents lists the agents stored in the data directory
+func (m *Manager) DiskAgents() ([]DiskAgent, error) {
+	if m.dataDir == "" {
+		return nil, nil
+	}
+	return Files(m.dataDir)
+}
+
+// Len returns the number of agents loaded
+func (m *Manager) Len() int {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return len(m.clients)
+}
+
+// Range calls fn for every loaded agent until it returns false, holding the
+// read lock
+func (m *Manager) Range(fn func(agentID string, c *client.Client) bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	for agentID, c := range m.clients {
+		if !fn(agentID, c) {
+			return
+		}
+	}
+}
+
+// Loaded returns an agent's client if it is loaded, without loading it
+func (m *Manager) Loaded(agentID string) (*client.Client, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	c, ok := m.clients[agentID]
+	return c, ok
+}
+
+// addLocked registers c as an agent's client
+func (m *Manager) addLocked(agentID string, c *client.Client) {
+	m.clients[agentID] = c
+	used := &atomic.Int64{}
+	used.Store(time.Now().UnixNano())
+	m.lastUsed[agentID] = used
+	m.created.Add(1)
+	for _, hook := range m.onChange {
+		c.OnChange(func(event client.ChangeEvent) { hook(agentID, event) })
+	}
+	if m.onCreate != nil {
+		m.onCreate(agentID)
+	}
+}
+
+// All returns every agent: loaded ones plus those only on disk
+func (m *Manager) All() ([]string, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.allLocked()
+}
+
+// RangeAll calls fn for every agent, loaded or only on disk, until it
+// returns an error, which RangeAll returns. c is nil for agents not loaded.
+// The read lock is held throughout, so no agent is added or dropped
+// meanwhile.
+func (m *Manager) RangeAll(fn func(agentID string, c *client.Client) error) error {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	agentIDs, err := m.allLocked()
+	if err != nil {
+		return err
+	}
+	for _, agentID := range agentIDs {
+		if err := fn(agentID, m.clients[agentID]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *Manager) allLocked() ([]string, error) {
+	onDisk, err := m.DiskAgents()
+	if err != nil {
+		return nil, err
+	}
+
+	agentIDs := make([]string, 0, len(m.clients)+len(onDisk))
+	for agentID := range m.clients {
+		agentIDs = append(agentIDs, agentID)
+	}
+	for _, a := range onDisk {
+		if _, loaded := m.clients[a.ID]; !loaded {
+			agentIDs = append(agentIDs, a.ID)
+		}
+	}
+	return agentIDs, nil
+}
+
+// Get returns an existing agent, loading it from the data directory if it
+// isn't in memory yet. It returns a *LoadingError while another caller is
+// loading the same agent, and ctx's error if ctx is done before a load
+// starts. Fetching an agent restarts its idle time.
+func (m *Manager) Get(ctx context.Context, agentID string) (*client.Client, bool, error) {
+	m.mu.RLock()
+	c, exists := m.clients[agentID]
+	if exists {
+		m.touchLocked(agentID)
+	}
+	m.mu.RUnlock()
+
+	if exists {
+		return c, true, nil
+	}
+	if !m.OnDisk(agentID) {
+		return nil, false, nil
+	}
+	return m.Load(ctx, agentID)
+}
+
+// Load reads an agent from the data directory and registers it. The load
+// runs without holding the lock; other callers get a *LoadingError until it
+// finishes. A load is not interrupted once started, but ctx's error is
+// returned if ctx is done before.
+func (m *Manager) Load(ctx context.Context, agentID string) (*client.Client, bool, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, false, err
+	}
+
+	m.mu.Lock()
+	if c, exists := m.clients[agentID]; exists {
+		m.mu.Unlock()
+		return c, true, nil
+	}
+	if m.loading[agentID] {
+		m.mu.Unlock()
+		return nil, false, &LoadingError{Agent: agentID}
+	}
+	if err := m.checkLimitLocked(); err != nil {
+		m.mu.Unlock()
+		return nil, false, err
+	}
+	m.loading[agentID] = true
+	m.mu.Unlock()
+
+	c, err := m.NewClient(agentID)
+	if err == nil {
+		err = c.Reload()
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.loading, agentID)
+
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to load agent %s: %w", agentID, err)
+	}
+	m.addLocked(agentID, c)
+	return c, true, nil
+}
+
+// GetOrCreate returns an agent, creating it if it doesn't exist
+func (m *Manager) GetOrCreate(ctx context.Context, agentID string) (*client.Client, error) {
+	c, exists, err := m.Get(ctx, agentID)
+	if err != nil || exists {
+		return c, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// Double-check after acquiring write lock
+	if c, exists := m.clients[agentID]; exists {
+		return c, nil
+	}
+	if m.loading[agentID] {
+		return nil, &LoadingError{Agent: agentID}
+	}
+	if err := m.checkLimitLocked(); err != nil {
+		return nil, err
+	}
+
+	c, err = m.NewClient(agentID)
+	if err != nil {
+		return nil, err
+	}
+	m.addLocked(agentID, c)
+	return c, nil
+}
+
+// Install registers c, a client from NewClient, as an agent's client, e.g.
+// for an agent restored from a dump. An agent that exists, loaded or only on
+// disk, is dropped first if replace is set; otherwise Install returns
+// ErrAgentExists. commit, if not nil, runs once the old agent is gone and
+// before c is registered, e.g. to write c's file; if it fails, c isn't
+// registered and its error is returned.
+func (m *Manager) Install(agentID string, c *client.Client, replace bool, commit func() error) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, loaded := m.clients[agentID]; loaded || m.OnDisk(agentID) {
+		if !replace {
+			return fmt.Errorf("%w: %s", ErrAgentExists, agentID)
+		}
+		if _, err := m.dropLocked(agentID); err != nil {
+			return err
+		}
+	}
+	if commit != nil {
+		if err := commit(); err != nil {
+			return err
+		}
+	}
+	m.addLocked(agentID, c)
+	return nil
+}
+
+// Update runs fn on a loaded agent's client under the manager's lock, so no
+// agent is added or dropped, and no other Update runs, until fn returns.
+// Other calls on the client are not held up. It reports whether the agent
+// was loaded; fn isn't called if not.
+func (m *Manager) Update(agentID string, fn func(c *client.Client) error) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	c, loaded := m.clients[agentID]
+	if !loaded {
+		return false, nil
+	}
+	return true, fn(c)
+}
+
+// NewClient creates an agent client, not yet registered. Its storage is the
+// agent's file when a data directory is set, otherwise the one WithStorageFor
+// picks, or in memory.
+func (m *Manager) NewClient(agentID string) (*client.Client, error) {
+	embedder := m.embedder
+	if m.embedderFor != nil {
+		embedder = m.embedderFor(agentID)
+	}
+
+	var c *client.Client
+	var err error
+	if m.dataDir != "" {
+		path, pathErr := m.Path(agentID)
+		if pathErr != nil {
+			return nil, pathErr
+		}
+		c, err = client.NewWithFileStorage(path, embedder)
+	} else if m.storageFor != nil {
+		st, stErr := m.storageFor(agentID)
+		if stErr != nil {
+			return nil, stErr
+		}
+		c, err = client.NewWithStorage(st, embedder)
+	} else {
+		c, err = client.New(embedder)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	c.SetVerbose(false) // Servers print nothing per command
+	return c, nil
+}
+
+// StartLoading marks an agent as being loaded, returning false if it
+// already is. Until FinishLoading, Get and GetOrCreate return a
+// *LoadingError for it.
+func (m *Manager) StartLoading(agentID string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.loading[agentID] {
+		return false
+	}
+	m.loading[agentID] = true
+	return true
+}
+
+func (m *Manager) FinishLoading(agentID string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.loading, agentID)
+}
+
+func (m *Manager) IsLoading(agentID string) bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.loading[agentID]
+}
+
+// Loading returns the agents being loaded
+func (m *Manager) Loading() []string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	agentIDs := make([]string, 0, len(m.loading))
+	for agentID := range m.loading {
+		agentIDs = append(agentIDs, agentID)
+	}
+	return agentIDs
+}
+
+// Delete removes an agent, expiring in-memory data and deleting the file of
+// persistent agents. It reports whether the agent existed.
+func (m *Manager) Delete(agentID string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.dropLocked(agentID)
+}
+
+// DeleteMany is Delete for several agents at once, so none of them can be
+// recreated halfway through. It returns how many existed, stopping at the
+// first error.
+func (m *Manager) DeleteMany(agentIDs []string) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	deleted := 0
+	for _, agentID := range agentIDs {
+		ok, err := m.dropLocked(agentID)
+		if err != nil {
+			return deleted, err
+		}
+		if ok {
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
+// DeleteAll deletes every agent, loaded or only on disk. check, if not nil,
+// is called for each first, with a nil client for agents not loaded; if it
+// returns an error nothing is deleted and the error is returned. An agent
+// that fails to delete doesn't stop the rest; the first error is returned.
+func (m *Manager) DeleteAll(check func(agentID string, c *client.Client) error) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	agentIDs, err := m.allLocked()
+	if err != nil {
+		return err
+	}
+	if check != nil {
+		for _, agentID := range agentIDs {
+			if err := check(agentID, m.clients[agentID]); err != nil {
+				return err
+			}
+		}
+	}
+
+	var firstErr error
+	for _, agentID := range agentIDs {
+		if _, err := m.dropLocked(agentID); err != nil {
+			log.Printf("Failed to delete agent %s: %v", agentID, err)
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	return firstErr
+}
+
+// dropLocked is Delete for callers holding the lock
+func (m *Manager) dropLocked(agentID string) (bool, error) {
+	c, exists := m.clients[agentID]
+	if !exists {
+		if !m.OnDisk(agentID) {
+			return false, nil
+		}
+		// Stored but never loaded: delete the file directly
+		path, _ := m.Path(agentID)
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return false, fmt.Errorf("failed to delete %s: %w", path, err)
+		}
+		m.dropped(agentID)
+		return true, nil
+	}
+
+	switch st := c.CurrentStorage().(type) {
+	case *storage.MemoryStorage:
+		// Release the stored tree now rather than waiting for the TTL
+		st.Expire()
+	case *storage.FileStorage:
+		if err := os.Remove(st.Path()); err != nil && !os.IsNotExist(err) {
+			return false, fmt.Errorf("failed to delete %s: %w", st.Path(), err)
+		}
+	}
+
+	delete(m.clients, agentID)
+	delete(m.lastUsed, agentID)
+	m.dropped(agentID)
+	return true, nil
+}
+
+// dropped runs the hooks for a dropped agent
+func (m *Manager) dropped(agentID string) {
+	m.dropCount.Add(1)
+	if m.onDrop != nil {
+		m.onDrop(agentID)
+	}
+	if len(m.onChange) > 0 {
+		event := client.ChangeEvent{Op: client.OpReset, Time: time.Now()}
+		for _, hook := range m.onChange {
+			hook(agentID, event)
+		}
+	}
+}
+
+// Flush saves every loaded agent stored in a file, in the data directory or
+// not, with unsaved changes to it. The writes happen outside the manager's
+// lock, so agents can be created meanwhile.
+func (m *Manager) Flush() error {
+	m.mu.RLock()
+	clients := make(map[string]*client.Client, len(m.clients))
+	for agentID, c := range m.clients {
+		clients[agentID] = c
+	}
+	m.mu.RUnlock()
+
+	var firstErr error
+	for agentID, c := range clients {
+		if _, inMemory := c.CurrentStorage().(*storage.MemoryStorage); inMemory {
+			continue
+		}
+		if err := c.Flush(); err != nil {
+			log.Printf("Failed to save agent %s: %v", agentID, err)
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	return firstErr
+}
+
+// SaveAll writes every in-memory agent to dir/<agent_id>.bin, and removes
+// the files there of agents that no longer exist or hold nothing, so they
+// don't come back on the next LoadSaved. Agents stored in the data directory
+// are already on disk and skipped. A failed agent is logged and the rest are
+// still saved; the first error is returned.
+//
+// Only files this manager wrote or loaded are removed. The last save of an
+// agent that fails to save now is kept, as are files LoadSaved couldn't read
+// and files it never saw.
+func (m *Manager) SaveAll(dir string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create persistence directory: %w", err)
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	m.savedMu.Lock()
+	defer m.savedMu.Unlock()
+
+	var firstErr error
+	failed := make(map[string]bool)
+	fail := func(agentID string, err error) {
+		log.Printf("Failed to save agent %s: %v", agentID, err)
+		failed[agentID] = true
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	saved := make(map[string]bool, len(m.clients))
+	inUse := make(map[string]bool) // Files agents are stored in, see client.MigrateStorage
+	for agentID, c := range m.clients {
+		st := c.CurrentStorage()
+		ms, ok := st.(*storage.MemoryStorage)
+		if !ok {
+			if fs, ok := st.(*storage.FileStorage); ok {
+				inUse[absPath(fs.Path())] = true
+			}
+			continue
+		}
+		path, err := FilePath(dir, agentID)
+		if err != nil {
+			fail(agentID, err)
+			continue
+		}
+		if err := c.Flush(); err != nil {
+			fail(agentID, err)
+			continue
+		}
+		if n, err := c.Count(); err != nil || n == 0 {
+			continue
+		}
+
+		// Through a temporary file, so a failed write keeps the last save
+		if err := ms.ExportToFile(path + ".tmp"); err != nil {
+			fail(agentID, err)
+			continue
+		}
+		if err := os.Rename(path+".tmp", path); err != nil {
+			fail(agentID, err)
+			continue
+		}
+		saved[agentID] = true
+		m.savedFiles[absPath(path)] = true
+	}
+
+	files, err := Files(dir)
+	if err != nil && firstErr == nil {
+		firstErr = err
+	}
+	for _, f := range files {
+		path := filepath.Join(dir, f.ID+FileExt)
+		abs := absPath(path)
+		if saved[f.ID] || failed[f.ID] || inUse[abs] || !m.savedFiles[abs] {
+			continue
+		}
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			fail(f.ID, err)
+			continue
+		}
+		delete(m.savedFiles, abs)
+	}
+
+	log.Printf("Saved %d agents to %s", len(saved), dir)
+	return firstErr
+}
+
+// absPath makes path absolute for comparisons, leaving it as it is if that
+// fails
+func absPath(path string) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		return abs
+	}
+	return path
+}
+
+// LoadSaved loads the agents written by SaveAll into memory, expiring after
+// ttl. A file that fails to load is logged and skipped, and SaveAll leaves it
+// in place.
+func (m *Manager) LoadSaved(dir string, ttl time.Duration) error {
+	files, err := Files(dir)
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	loaded := 0
+	for _, f := range files {
+		path := filepath.Join(dir, f.ID+FileExt)
+		tree, err := storage.NewFileStorage(path).Load()
+		if err != nil {
+			log.Printf("Failed to load saved agent %s: %v", f.ID, err)
+			continue
+		}
+
+		embedder := m.embedder
+		if m.embedderFor != nil {
+			embedder = m.embedderFor(f.ID)
+		}
+		c, err := client.New(embedder)
+		if err != nil {
+			log.Printf("Failed to load saved agent %s: %v", f.ID, err)
+			continue
+		}
+		c.Storage = storage.NewMemoryStorageFromTree(tree, ttl)
+		c.SetVerbose(false)
+
+		m.addLocked(f.ID, c)
+		m.savedMu.Lock()
+		m.savedFiles[absPath(path)] = true
+		m.savedMu.Unlock()
+		loaded++
+	}
+
+	log.Printf("Loaded %d saved agents from %s", loaded, dir)
+	return nil
+}
@@ -0,0 +1,634 @@
+package agents
+
+import (
+	"Hippocampus/src/client"
+	"Hippocampus/src/embedding"
+	"Hippocampus/src/storage"
+	"Hippocampus/src/types"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+var ctx = context.Background()
+
+func newTestManager(t *testing.T, opts ...Option) *Manager {
+	t.Helper()
+	return NewManager(embedding.NewMockEmbedder(), opts...)
+}
+
+// insert stores memories in an agent, creating it if needed
+func insert(t *testing.T, m *Manager, agentID string, keys ...string) *client.Client {
+	t.Helper()
+	c, err := m.GetOrCreate(ctx, agentID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range keys {
+		if err := c.Insert(key, "memory "+key+" of "+agentID); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return c
+}
+
+// fileCount returns how many memories an agent file holds
+func fileCount(t *testing.T, path string) int {
+	t.Helper()
+	tree, err := storage.NewFileStorage(path).Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tree.Len()
+}
+
+func TestSaveAllKeepsLastSaveWhenSaveFails(t *testing.T) {
+	dir := t.TempDir()
+	m := newTestManager(t)
+	insert(t, m, "alice", "a1")
+	if err := m.SaveAll(dir); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "alice"+FileExt)
+
+	// A directory where the temporary file goes makes the next save fail
+	insert(t, m, "alice", "a2")
+	if err := os.Mkdir(path+".tmp", 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.SaveAll(dir); err == nil {
+		t.Fatal("SaveAll succeeded with the temporary file blocked")
+	}
+
+	if got := fileCount(t, path); got != 1 {
+		t.Fatalf("last save holds %d memories, want the 1 saved before", got)
+	}
+}
+
+func TestSaveAllKeepsUnreadableFiles(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "broken"+FileExt)
+	if err := os.WriteFile(path, []byte("not a tree"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	m := newTestManager(t)
+	if err := m.LoadSaved(dir, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if _, exists, _ := m.Get(ctx, "broken"); exists {
+		t.Fatal("unreadable file loaded")
+	}
+	if err := m.SaveAll(dir); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Fatalf("unreadable file after SaveAll: %v", err)
+	}
+}
+
+func TestSaveAllKeepsUnknownFiles(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "other"+FileExt)
+	if err := storage.NewFileStorage(path).Save(types.NewTree()); err != nil {
+		t.Fatal(err)
+	}
+
+	m := newTestManager(t)
+	insert(t, m, "alice", "a1")
+	if err := m.SaveAll(dir); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Fatalf("file of an agent the manager never saw: %v", err)
+	}
+}
+
+func TestSaveAllRemovesDroppedAgents(t *testing.T) {
+	dir := t.TempDir()
+	m := newTestManager(t)
+	insert(t, m, "alice", "a1")
+	insert(t, m, "bob", "b1")
+	if err := m.SaveAll(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	// Saved, then loaded by another manager as after a restart
+	m = newTestManager(t)
+	if err := m.LoadSaved(dir, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Delete("bob"); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.SaveAll(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := fileCount(t, filepath.Join(dir, "alice"+FileExt)); got != 1 {
+		t.Errorf("alice holds %d memories, want 1", got)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "bob"+FileExt)); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("dropped agent's file: %v, want it removed", err)
+	}
+}
+
+func TestEvictIdleKeepsAgentsMigratedToFiles(t *testing.T) {
+	m := newTestManager(t, WithIdleEviction(time.Nanosecond))
+	c := insert(t, m, "alice", "k")
+
+	// The read lock lets EvictIdle pick alice as an idle in-memory agent but
+	// holds it back from evicting until alice is moved to a file, as HAGENT
+	// ATTACH does
+	m.mu.RLock()
+	done := make(chan []string)
+	go func() { done <- m.EvictIdle() }()
+	time.Sleep(100 * time.Millisecond)
+	if err := c.MigrateStorage(storage.NewFileStorage(filepath.Join(t.TempDir(), "alice"+FileExt))); err != nil {
+		t.Fatal(err)
+	}
+	m.mu.RUnlock()
+
+	if evicted := <-done; len(evicted) != 0 {
+		t.Fatalf("evicted %v after it moved to a file", evicted)
+	}
+	if _, ok := m.Loaded("alice"); !ok {
+		t.Fatal("alice unloaded")
+	}
+}
+
+func TestEvictIdleConcurrentWithMigration(t *testing.T) {
+	dir := t.TempDir()
+	m := newTestManager(t, WithIdleEviction(time.Nanosecond))
+	for i := range 8 {
+		insert(t, m, fmt.Sprintf("agent%d", i), "k")
+	}
+
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+	for i := range 8 {
+		agentID := fmt.Sprintf("agent%d", i)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			path := filepath.Join(dir, agentID+FileExt)
+			for n := 0; ; n++ {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				c, err := m.GetOrCreate(ctx, agentID)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				c.Insert(fmt.Sprintf("k%d", n), "text")
+				m.Update(agentID, func(current *client.Client) error {
+					if current != c {
+						return nil
+					}
+					var st storage.Storage = storage.NewMemoryStorage()
+					if _, ok := c.CurrentStorage().(*storage.MemoryStorage); ok {
+						st = storage.NewFileStorage(path)
+						os.Remove(path)
+					} else {
+						c.Flush()
+					}
+					if err := c.MigrateStorage(st); err != nil {
+						t.Error(err)
+					}
+					return nil
+				})
+			}
+		}()
+	}
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+				m.EvictIdle()
+			}
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+				m.Flush()
+				m.Stats()
+			}
+		}
+	}()
+
+	time.Sleep(500 * time.Millisecond)
+	close(stop)
+	wg.Wait()
+}
+
+func TestGetOrCreate(t *testing.T) {
+	var created []string
+	m := newTestManager(t, WithHooks(func(agentID string) { created = append(created, agentID) }, nil))
+
+	if c, exists, err := m.Get(ctx, "alice"); c != nil || exists || err != nil {
+		t.Fatalf("Get of a new agent = %v, %t, %v", c, exists, err)
+	}
+	c1 := insert(t, m, "alice", "a1")
+	c2, err := m.GetOrCreate(ctx, "alice")
+	if err != nil || c2 != c1 {
+		t.Fatalf("second GetOrCreate = %p, %v; want the first client %p", c2, err, c1)
+	}
+	c3, exists, err := m.Get(ctx, "alice")
+	if c3 != c1 || !exists || err != nil {
+		t.Fatalf("Get = %p, %t, %v", c3, exists, err)
+	}
+
+	if len(created) != 1 || m.Len() != 1 || m.Stats().Created != 1 {
+		t.Fatalf("created hook %v, Len %d, stats %+v; want one agent", created, m.Len(), m.Stats())
+	}
+}
+
+func TestGetCanceled(t *testing.T) {
+	dir := t.TempDir()
+	saved := newTestManager(t, WithDataDir(dir))
+	insert(t, saved, "alice", "a1")
+	if err := saved.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	m := newTestManager(t, WithDataDir(dir))
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, _, err := m.Get(canceled, "alice"); !errors.Is(err, context.Canceled) {
+		t.Fatalf("Get with a canceled context = %v", err)
+	}
+	if _, ok := m.Loaded("alice"); ok {
+		t.Fatal("alice loaded")
+	}
+}
+
+func TestInstall(t *testing.T) {
+	m := newTestManager(t)
+	old := insert(t, m, "alice", "a1")
+
+	c, err := m.NewClient("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	committed := false
+	commit := func() error { committed = true; return nil }
+	if err := m.Install("alice", c, false, commit); !errors.Is(err, ErrAgentExists) || committed {
+		t.Fatalf("Install without replace = %v, committed %t", err, committed)
+	}
+	if current, _ := m.Loaded("alice"); current != old {
+		t.Fatal("alice replaced without replace")
+	}
+
+	if err := m.Install("alice", c, true, func() error { return errors.New("disk full") }); err == nil {
+		t.Fatal("Install ignored commit's error")
+	}
+	if _, ok := m.Loaded("alice"); ok {
+		t.Fatal("alice installed though commit failed")
+	}
+
+	if err := m.Install("alice", c, true, commit); err != nil || !committed {
+		t.Fatalf("Install = %v, committed %t", err, committed)
+	}
+	if current, _ := m.Loaded("alice"); current != c {
+		t.Fatal("alice not installed")
+	}
+}
+
+func TestUpdate(t *testing.T) {
+	m := newTestManager(t)
+	c := insert(t, m, "alice", "a1")
+
+	var got *client.Client
+	loaded, err := m.Update("alice", func(current *client.Client) error { got = current; return nil })
+	if !loaded || err != nil || got != c {
+		t.Fatalf("Update = %t, %v, called with %p; want %p", loaded, err, got, c)
+	}
+
+	failed := errors.New("failed")
+	if _, err := m.Update("alice", func(*client.Client) error { return failed }); err != failed {
+		t.Fatalf("Update = %v, want fn's error", err)
+	}
+
+	called := false
+	loaded, err = m.Update("bob", func(*client.Client) error { called = true; return nil })
+	if loaded || err != nil || called {
+		t.Fatalf("Update of a missing agent = %t, %v, called %t", loaded, err, called)
+	}
+}
+
+func TestDeleteMany(t *testing.T) {
+	m := newTestManager(t)
+	insert(t, m, "alice", "a1")
+	insert(t, m, "bob", "b1")
+
+	n, err := m.DeleteMany([]string{"alice", "carol", "bob"})
+	if n != 2 || err != nil || m.Len() != 0 {
+		t.Fatalf("DeleteMany = %d, %v; Len %d", n, err, m.Len())
+	}
+}
+
+func TestDeleteAll(t *testing.T) {
+	dir := t.TempDir()
+	saved := newTestManager(t, WithDataDir(dir))
+	insert(t, saved, "alice", "a1")
+	if err := saved.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	m := newTestManager(t, WithDataDir(dir))
+	insert(t, m, "bob", "b1")
+
+	var checked []string
+	refuse := errors.New("refused")
+	err := m.DeleteAll(func(agentID string, c *client.Client) error {
+		checked = append(checked, agentID)
+		if agentID == "alice" && c != nil {
+			t.Errorf("alice, only on disk, checked with a client")
+		}
+		return refuse
+	})
+	if err != refuse || len(checked) != 1 || !m.OnDisk("alice") || m.Len() != 1 {
+		t.Fatalf("refused DeleteAll = %v after checking %v; alice on disk %t, Len %d", err, checked, m.OnDisk("alice"), m.Len())
+	}
+
+	if err := m.DeleteAll(nil); err != nil {
+		t.Fatal(err)
+	}
+	if all, err := m.All(); len(all) != 0 || err != nil {
+		t.Fatalf("All after DeleteAll = %v, %v", all, err)
+	}
+}
+
+func TestGetOrCreateConcurrent(t *testing.T) {
+	m := newTestManager(t)
+
+	clients := make([]*client.Client, 50)
+	var wg sync.WaitGroup
+	for i := range clients {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c, err := m.GetOrCreate(ctx, "alice")
+			if err != nil {
+				t.Error(err)
+			}
+			clients[i] = c
+		}()
+	}
+	wg.Wait()
+
+	for _, c := range clients {
+		if c != clients[0] {
+			t.Fatal("concurrent GetOrCreate returned different clients")
+		}
+	}
+	if created := m.Stats().Created; created != 1 {
+		t.Fatalf("created %d agents, want 1", created)
+	}
+}
+
+func TestMaxAgents(t *testing.T) {
+	m := newTestManager(t, WithMaxAgents(2))
+	insert(t, m, "alice")
+	insert(t, m, "bob")
+
+	if _, err := m.GetOrCreate(ctx, "carol"); !errors.Is(err, ErrTooManyAgents) {
+		t.Fatalf("third agent: %v, want ErrTooManyAgents", err)
+	}
+	// Existing agents are still served
+	if _, err := m.GetOrCreate(ctx, "alice"); err != nil {
+		t.Fatal(err)
+	}
+	if st := m.Stats(); st.Rejected != 1 || st.Loaded != 2 || st.MaxAgents != 2 {
+		t.Fatalf("stats %+v", st)
+	}
+
+	if _, err := m.Delete("bob"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.GetOrCreate(ctx, "carol"); err != nil {
+		t.Fatalf("agent after a delete freed a slot: %v", err)
+	}
+}
+
+func TestDelete(t *testing.T) {
+	var dropped []string
+	var resets []string
+	m := newTestManager(t,
+		WithHooks(nil, func(agentID string) { dropped = append(dropped, agentID) }),
+		WithChangeHook(func(agentID string, event client.ChangeEvent) {
+			if event.Op == client.OpReset {
+				resets = append(resets, agentID)
+			}
+		}))
+	insert(t, m, "alice", "a1")
+
+	if existed, err := m.Delete("alice"); !existed || err != nil {
+		t.Fatalf("Delete = %t, %v", existed, err)
+	}
+	if existed, err := m.Delete("alice"); existed || err != nil {
+		t.Fatalf("second Delete = %t, %v, want false", existed, err)
+	}
+	if _, exists, _ := m.Get(ctx, "alice"); exists {
+		t.Fatal("deleted agent still exists")
+	}
+	if len(dropped) != 1 || len(resets) != 1 || m.Stats().Dropped != 1 {
+		t.Fatalf("drop hook %v, reset events %v, stats %+v; want one drop", dropped, resets, m.Stats())
+	}
+
+	// A new agent under the same ID starts empty
+	c := insert(t, m, "alice")
+	if n, _ := c.Count(); n != 0 {
+		t.Fatalf("recreated agent holds %d memories", n)
+	}
+}
+
+func TestDataDir(t *testing.T) {
+	dir := t.TempDir()
+	m := newTestManager(t, WithDataDir(dir))
+	insert(t, m, "alice", "a1", "a2")
+	if err := m.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	// A new manager loads the agent from its file on first use
+	m2 := newTestManager(t, WithDataDir(dir))
+	if m2.Len() != 0 || !m2.OnDisk("alice") || m2.OnDisk("bob") {
+		t.Fatalf("Len %d, alice on disk %t, bob on disk %t", m2.Len(), m2.OnDisk("alice"), m2.OnDisk("bob"))
+	}
+	all, err := m2.All()
+	if err != nil || len(all) != 1 || all[0] != "alice" {
+		t.Fatalf("All = %v, %v", all, err)
+	}
+	c, exists, err := m2.Get(ctx, "alice")
+	if err != nil || !exists {
+		t.Fatalf("Get = %t, %v", exists, err)
+	}
+	if n, _ := c.Count(); n != 2 {
+		t.Fatalf("loaded agent holds %d memories, want 2", n)
+	}
+
+	// Deleting it removes the file
+	if _, err := m2.Delete("alice"); err != nil {
+		t.Fatal(err)
+	}
+	if m2.OnDisk("alice") {
+		t.Fatal("file of a deleted agent remains")
+	}
+}
+
+func TestInvalidAgentID(t *testing.T) {
+	m := newTestManager(t, WithDataDir(t.TempDir()))
+	for _, agentID := range []string{"", "..", "a/b", `a\b`} {
+		var invalid *InvalidIDError
+		if _, err := m.GetOrCreate(ctx, agentID); !errors.As(err, &invalid) {
+			t.Errorf("GetOrCreate(%q) = %v, want an InvalidIDError", agentID, err)
+		}
+	}
+}
+
+func TestLoadingAgent(t *testing.T) {
+	m := newTestManager(t)
+	if !m.StartLoading("alice") || m.StartLoading("alice") {
+		t.Fatal("StartLoading should succeed only once")
+	}
+
+	var loading *LoadingError
+	if _, err := m.GetOrCreate(ctx, "alice"); !errors.As(err, &loading) || loading.Agent != "alice" {
+		t.Fatalf("GetOrCreate while loading = %v, want a LoadingError", err)
+	}
+	if !m.IsLoading("alice") || m.Stats().Loading != 1 {
+		t.Fatal("agent not reported as loading")
+	}
+
+	m.FinishLoading("alice")
+	if _, err := m.GetOrCreate(ctx, "alice"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestStorageAndEmbedderFor(t *testing.T) {
+	dir := t.TempDir()
+	special := embedding.NewMockEmbedder()
+	m := newTestManager(t,
+		WithStorageFor(func(agentID string) (storage.Storage, error) {
+			if agentID == "bad" {
+				return nil, errors.New("no storage for bad")
+			}
+			return storage.NewFileStorage(filepath.Join(dir, agentID+".tree")), nil
+		}),
+		WithEmbedderFor(func(agentID string) embedding.EmbeddingService {
+			if agentID == "special" {
+				return special
+			}
+			return embedding.NewMockEmbedder()
+		}))
+
+	c := insert(t, m, "alice", "a1")
+	if fs, ok := c.CurrentStorage().(*storage.FileStorage); !ok || fs.Path() != filepath.Join(dir, "alice.tree") {
+		t.Fatalf("alice stored in %T", c.CurrentStorage())
+	}
+	if c := insert(t, m, "special"); c.Embedder != special {
+		t.Fatal("WithEmbedderFor's embedder not used")
+	}
+	if _, err := m.GetOrCreate(ctx, "bad"); err == nil {
+		t.Fatal("storage error not returned")
+	}
+}
+
+func TestRange(t *testing.T) {
+	m := newTestManager(t)
+	for i := range 5 {
+		insert(t, m, fmt.Sprintf("agent%d", i))
+	}
+
+	seen := 0
+	m.Range(func(agentID string, c *client.Client) bool {
+		seen++
+		return true
+	})
+	if seen != 5 {
+		t.Fatalf("Range visited %d agents, want 5", seen)
+	}
+
+	seen = 0
+	m.Range(func(agentID string, c *client.Client) bool {
+		seen++
+		return seen < 2
+	})
+	if seen != 2 {
+		t.Fatalf("Range visited %d agents after fn returned false, want 2", seen)
+	}
+}
+
+func TestEvictIdle(t *testing.T) {
+	dir := t.TempDir()
+	var evictedHook []string
+	m := newTestManager(t, WithDataDir(dir), WithIdleEviction(50*time.Millisecond),
+		WithEvictHook(func(agentID string) { evictedHook = append(evictedHook, agentID) }))
+
+	insert(t, m, "idle", "i1")
+	insert(t, m, "busy", "b1")
+	time.Sleep(60 * time.Millisecond)
+	if _, _, err := m.Get(ctx, "busy"); err != nil {
+		t.Fatal(err)
+	}
+
+	evicted := m.EvictIdle()
+	if len(evicted) != 1 || evicted[0] != "idle" || len(evictedHook) != 1 {
+		t.Fatalf("evicted %v (hook %v), want only idle", evicted, evictedHook)
+	}
+	if st := m.Stats(); st.Loaded != 1 || st.Evicted != 1 || st.Dropped != 0 {
+		t.Fatalf("stats %+v", st)
+	}
+
+	// An agent in the data directory is saved, so nothing is lost
+	c, exists, err := m.Get(ctx, "idle")
+	if err != nil || !exists {
+		t.Fatalf("Get after eviction = %t, %v", exists, err)
+	}
+	if n, _ := c.Count(); n != 1 {
+		t.Fatalf("reloaded agent holds %d memories, want 1", n)
+	}
+}
+
+func TestEvictIdleDropsInMemoryAgents(t *testing.T) {
+	m := newTestManager(t, WithIdleEviction(time.Nanosecond))
+	insert(t, m, "alice", "a1")
+	time.Sleep(time.Millisecond)
+
+	if evicted := m.EvictIdle(); len(evicted) != 1 {
+		t.Fatalf("evicted %v, want alice", evicted)
+	}
+	if _, exists, _ := m.Get(ctx, "alice"); exists {
+		t.Fatal("idle in-memory agent kept")
+	}
+	if st := m.Stats(); st.Evicted != 1 || st.Dropped != 1 {
+		t.Fatalf("stats %+v, want one eviction that drops", st)
+	}
+}
+
+func TestEvictionDisabled(t *testing.T) {
+	m := newTestManager(t)
+	insert(t, m, "alice")
+	if evicted := m.EvictIdle(); evicted != nil {
+		t.Fatalf("EvictIdle without WithIdleEviction evicted %v", evicted)
+	}
+}
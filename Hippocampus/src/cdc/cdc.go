@@ -0,0 +1,440 @@
+// Package cdc writes a change-data-capture log: every change to any agent's
+// memories, in order, each with a sequence number one higher than the last.
+// Downstream indexes can rebuild from it and resume from the last sequence
+// number they applied.
+//
+// The log is a directory of segment files. The active one is the log path
+// itself; once it grows past MaxSize it is renamed to path.<first seq>, with
+// the sequence number zero-padded to 20 digits so names sort in order, and a
+// new active segment is started. Only the Keep newest rotated segments are
+// kept, if Keep is set.
+//
+// A segment is the magic number, then records: the length of the JSON
+// payload and its CRC-32 (IEEE), both little-endian uint32s, then the JSON
+// of a Record. A record cut short by a crash is cut off when the log is next
+// opened.
+package cdc
+
+import (
+	"Hippocampus/src/client"
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// segmentMagic is "HIPC" read as a little-endian uint32; it starts a segment
+const segmentMagic uint32 = 0x43504948
+
+// maxRecordSize bounds the payload length read, so a damaged length can't
+// cause a huge allocation
+const maxRecordSize = 64 << 20
+
+const (
+	defaultMaxSize       = 64 << 20
+	defaultFsyncInterval = time.Second
+	writeBufferSize      = 64 << 10
+)
+
+// errTorn reports a record cut short
+var errTorn = errors.New("cdc: record cut short")
+
+// Record is one change. Text is the new text of inserts and updates. A reset
+// means every memory of the agent was replaced (Clear, Restore) or deleted
+// with the agent (DEL, FLUSHALL).
+type Record struct {
+	Seq   uint64          `json:"seq"`
+	Time  time.Time       `json:"ts"`
+	Agent string          `json:"agent"`
+	Op    client.ChangeOp `json:"op"`
+	Key   string          `json:"key,omitempty"`
+	Text  string          `json:"text,omitempty"`
+}
+
+// Options configure a Writer. Zero values take the defaults.
+type Options struct {
+	MaxSize int64 // Bytes of the active segment before it is rotated
+	Keep    int   // Rotated segments kept; 0 keeps all
+
+	// FsyncInterval is how often buffered records are written and synced.
+	// A crash loses at most this much of the log. Negative syncs after every
+	// record, making each change wait for the disk.
+	FsyncInterval time.Duration
+}
+
+// Stats describe a Writer
+type Stats struct {
+	Seq      uint64 // Last sequence number written
+	Records  int64  // Records written since opening
+	Bytes    int64  // Size of the active segment, buffered records included
+	Rotated  int64  // Segments rotated since opening
+	Failures int64  // Failed writes, syncs or rotations
+}
+
+// Writer appends records to a log. It is safe for concurrent use.
+type Writer struct {
+	path string
+	opts Options
+
+	mu     sync.Mutex
+	f      *os.File
+	w      *bufio.Writer
+	size   int64
+	seq    uint64
+	stats  Stats
+	err    error // Sticky write error, reported once and then retried
+	closed bool
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// Open opens the log at path for appending, continuing its sequence numbers
+func Open(path string, opts Options) (*Writer, error) {
+	if opts.MaxSize <= 0 {
+		opts.MaxSize = defaultMaxSize
+	}
+	if opts.FsyncInterval == 0 {
+		opts.FsyncInterval = defaultFsyncInterval
+	}
+
+	if dir := filepath.Dir(path); dir != "" {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return nil, err
+		}
+	}
+
+	wr := &Writer{path: path, opts: opts}
+
+	// The last sequence number is in the active segment, or if that is
+	// empty, in the newest rotated one
+	last, size, err := lastSeq(path, true)
+	if err != nil {
+		return nil, err
+	}
+	if size == 0 {
+		segments, err := Segments(path)
+		if err != nil {
+			return nil, err
+		}
+		if n := len(segments); n > 1 {
+			if last, _, err = lastSeq(segments[n-2].Path, false); err != nil {
+				return nil, err
+			}
+		}
+	}
+	wr.seq = last
+
+	if err := wr.openActive(); err != nil {
+		return nil, err
+	}
+
+	if opts.FsyncInterval > 0 {
+		wr.stop = make(chan struct{})
+		wr.done = make(chan struct{})
+		go wr.syncLoop()
+	}
+	return wr, nil
+}
+
+// openActive opens the active segment, writing the magic number if it is new
+func (wr *Writer) openActive() error {
+	f, err := os.OpenFile(wr.path, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	wr.f = f
+	wr.w = bufio.NewWriterSize(f, writeBufferSize)
+	wr.size = info.Size()
+	if wr.size == 0 {
+		binary.Write(wr.w, binary.LittleEndian, segmentMagic)
+		wr.size = 4
+	}
+	return nil
+}
+
+// Append writes a change to agentID as the next record. It doesn't wait for
+// the disk unless FsyncInterval is negative.
+func (wr *Writer) Append(agentID string, event client.ChangeEvent) error {
+	wr.mu.Lock()
+	defer wr.mu.Unlock()
+
+	if wr.closed {
+		return errors.New("cdc: log closed")
+	}
+
+	record := Record{
+		Seq:   wr.seq + 1,
+		Time:  event.Time,
+		Agent: agentID,
+		Op:    event.Op,
+		Key:   event.Key,
+		Text:  event.Value,
+	}
+	payload, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	if wr.size > 4 && wr.size+8+int64(len(payload)) > wr.opts.MaxSize {
+		if err := wr.rotateLocked(); err != nil {
+			wr.stats.Failures++
+			return fmt.Errorf("cdc: rotating %s: %w", wr.path, err)
+		}
+	}
+
+	var header [8]byte
+	binary.LittleEndian.PutUint32(header[0:4], uint32(len(payload)))
+	binary.LittleEndian.PutUint32(header[4:8], crc32.ChecksumIEEE(payload))
+	wr.w.Write(header[:])
+	wr.w.Write(payload)
+
+	wr.seq = record.Seq
+	wr.size += int64(len(header) + len(payload))
+	wr.stats.Records++
+
+	if wr.opts.FsyncInterval < 0 {
+		return wr.syncLocked()
+	}
+	return nil
+}
+
+// Hook returns Append as a change hook for agents.WithChangeHook, logging
+// failures
+func (wr *Writer) Hook() func(agentID string, event client.ChangeEvent) {
+	return func(agentID string, event client.ChangeEvent) {
+		if err := wr.Append(agentID, event); err != nil {
+			log.Printf("CDC log: %v", err)
+		}
+	}
+}
+
+// syncLocked writes buffered records and syncs the active segment. After a
+// failure the buffer keeps the records, so the next sync retries them.
+func (wr *Writer) syncLocked() error {
+	err := wr.w.Flush()
+	if err == nil {
+		err = wr.f.Sync()
+	}
+	if err != nil {
+		wr.stats.Failures++
+		if wr.err == nil {
+			log.Printf("CDC log: writing %s failed: %v", wr.path, err)
+		}
+		wr.err = err
+		return fmt.Errorf("cdc: writing %s: %w", wr.path, err)
+	}
+	wr.err = nil
+	return nil
+}
+
+func (wr *Writer) syncLoop() {
+	defer close(wr.done)
+
+	ticker := time.NewTicker(wr.opts.FsyncInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-wr.stop:
+			return
+		case <-ticker.C:
+			wr.mu.Lock()
+			if wr.w.Buffered() > 0 || wr.err != nil {
+				wr.syncLocked()
+			}
+			wr.mu.Unlock()
+		}
+	}
+}
+
+// rotateLocked syncs and renames the active segment after the first sequence
+// number it holds, starts a new one and prunes old segments
+func (wr *Writer) rotateLocked() error {
+	if err := wr.syncLocked(); err != nil {
+		return err
+	}
+	first, err := firstSeq(wr.path)
+	if err != nil {
+		return err
+	}
+	if err := wr.f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(wr.path, segmentPath(wr.path, first)); err != nil {
+		wr.openActive() // Carry on appending to the same segment
+		return err
+	}
+	if err := wr.openActive(); err != nil {
+		return err
+	}
+	wr.stats.Rotated++
+
+	if wr.opts.Keep > 0 {
+		segments, err := Segments(wr.path)
+		if err != nil {
+			return err
+		}
+		rotated := segments[:len(segments)-1]
+		for len(rotated) > wr.opts.Keep {
+			if err := os.Remove(rotated[0].Path); err != nil {
+				return err
+			}
+			rotated = rotated[1:]
+		}
+	}
+	return nil
+}
+
+// Stats returns the writer's counters
+func (wr *Writer) Stats() Stats {
+	wr.mu.Lock()
+	defer wr.mu.Unlock()
+	stats := wr.stats
+	stats.Seq = wr.seq
+	stats.Bytes = wr.size
+	return stats
+}
+
+// Path returns the active segment's path
+func (wr *Writer) Path() string {
+	return wr.path
+}
+
+// Close writes and syncs the buffered records and closes the log
+func (wr *Writer) Close() error {
+	wr.mu.Lock()
+	if wr.closed {
+		wr.mu.Unlock()
+		return nil
+	}
+	wr.closed = true
+	wr.mu.Unlock()
+
+	if wr.stop != nil {
+		close(wr.stop)
+		<-wr.done
+	}
+
+	wr.mu.Lock()
+	defer wr.mu.Unlock()
+	err := wr.syncLocked()
+	if closeErr := wr.f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// Segment is one file of a log
+type Segment struct {
+	Path     string
+	FirstSeq uint64 // 0 for the active segment, whose first record isn't in its name
+}
+
+// segmentPath names a rotated segment after its first sequence number
+func segmentPath(path string, first uint64) string {
+	return fmt.Sprintf("%s.%020d", path, first)
+}
+
+// Segments returns the segments of the log at path, oldest first, the active
+// one last
+func Segments(path string) ([]Segment, error) {
+	matches, err := filepath.Glob(path + ".*")
+	if err != nil {
+		return nil, err
+	}
+
+	var segments []Segment
+	for _, match := range matches {
+		suffix := strings.TrimPrefix(match, path+".")
+		if len(suffix) != 20 {
+			continue
+		}
+		first, err := strconv.ParseUint(suffix, 10, 64)
+		if err != nil {
+			continue
+		}
+		segments = append(segments, Segment{Path: match, FirstSeq: first})
+	}
+	sort.Slice(segments, func(i, j int) bool { return segments[i].FirstSeq < segments[j].FirstSeq })
+	return append(segments, Segment{Path: path}), nil
+}
+
+// lastSeq returns the sequence number of a segment's last complete record
+// and the segment's size. With truncate, a torn record at the end is cut
+// off. A missing segment is empty.
+func lastSeq(path string, truncate bool) (uint64, int64, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return 0, 0, nil
+	}
+	if err != nil {
+		return 0, 0, err
+	}
+	defer f.Close()
+
+	r := newSegmentReader(f)
+	if err := r.readMagic(); err != nil {
+		if errors.Is(err, io.EOF) || errors.Is(err, errTorn) {
+			return 0, 0, nil
+		}
+		return 0, 0, fmt.Errorf("%s: %w", path, err)
+	}
+
+	var last uint64
+	for {
+		record, err := r.next()
+		if err == io.EOF {
+			break
+		}
+		if errors.Is(err, errTorn) {
+			if !truncate {
+				break
+			}
+			log.Printf("CDC log: cutting off a record cut short at byte %d of %s", r.offset, path)
+			if err := os.Truncate(path, r.offset); err != nil {
+				return 0, 0, err
+			}
+			return last, r.offset, nil
+		}
+		if err != nil {
+			return 0, 0, fmt.Errorf("%s: %w", path, err)
+		}
+		last = record.Seq
+	}
+	return last, r.offset, nil
+}
+
+// firstSeq returns the sequence number of a segment's first record
+func firstSeq(path string) (uint64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	r := newSegmentReader(f)
+	if err := r.readMagic(); err != nil {
+		return 0, fmt.Errorf("%s: %w", path, err)
+	}
+	record, err := r.next()
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", path, err)
+	}
+	return record.Seq, nil
+}
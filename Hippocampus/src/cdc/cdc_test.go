@@ -0,0 +1,269 @@
+package cdc
+
+import (
+	"Hippocampus/src/client"
+	"Hippocampus/src/embedding"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+// openLog opens a log in a new directory, syncing after every record
+func openLog(t *testing.T, opts Options) *Writer {
+	t.Helper()
+	if opts.FsyncInterval == 0 {
+		opts.FsyncInterval = -1
+	}
+	wr, err := Open(filepath.Join(t.TempDir(), "changes.log"), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { wr.Close() })
+	return wr
+}
+
+// readAll returns the records of the log at path from seq on
+func readAll(t *testing.T, path string, from uint64) []Record {
+	t.Helper()
+	var records []Record
+	err := Tail(context.Background(), path, TailOptions{FromSeq: from}, func(r *Record) error {
+		records = append(records, *r)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return records
+}
+
+// describe renders records as "seq op key text" for comparison
+func describe(records []Record) []string {
+	var lines []string
+	for _, r := range records {
+		lines = append(lines, fmt.Sprintf("%d %s %s %s", r.Seq, r.Op, r.Key, r.Text))
+	}
+	return lines
+}
+
+// appendN appends n inserts to agent, keyed k1 to kn
+func appendN(t *testing.T, wr *Writer, agent string, n int) {
+	t.Helper()
+	for i := 1; i <= n; i++ {
+		key := fmt.Sprintf("k%d", i)
+		if err := wr.Append(agent, client.ChangeEvent{Op: client.OpInsert, Key: key, Value: "text " + key}); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestRecordsFollowClientChanges(t *testing.T) {
+	wr := openLog(t, Options{})
+	c, err := client.New(embedding.NewMockEmbedder())
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.SetVerbose(false)
+	c.OnChange(func(e client.ChangeEvent) { wr.Hook()("alice", e) })
+
+	for _, step := range []func() error{
+		func() error { return c.Insert("k1", "tea") },
+		func() error { return c.Insert("k2", "coffee") },
+		func() error { return c.Insert("k1", "green tea") },
+		func() error { _, err := c.Delete("k2"); return err },
+		func() error { c.Clear(); return nil },
+		func() error { return c.Insert("k3", "water") },
+	} {
+		if err := step(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	want := []string{
+		"1 insert k1 tea",
+		"2 insert k2 coffee",
+		"3 update k1 green tea",
+		"4 delete k2 ",
+		"5 reset  ",
+		"6 insert k3 water",
+	}
+	records := readAll(t, wr.Path(), 0)
+	if got := describe(records); !slices.Equal(got, want) {
+		t.Fatalf("records\n%q\nwant\n%q", got, want)
+	}
+	for _, r := range records {
+		if r.Agent != "alice" || r.Time.IsZero() {
+			t.Fatalf("record %+v", r)
+		}
+	}
+
+	// Resuming after the last applied record returns only what followed
+	if got := describe(readAll(t, wr.Path(), 4)); !slices.Equal(got, want[3:]) {
+		t.Fatalf("from 4: %q", got)
+	}
+	if got := readAll(t, wr.Path(), 7); len(got) != 0 {
+		t.Fatalf("from past the end: %q", describe(got))
+	}
+	if stats := wr.Stats(); stats.Seq != 6 || stats.Records != 6 || stats.Failures != 0 {
+		t.Fatalf("stats %+v", stats)
+	}
+}
+
+func TestReopenContinuesSequence(t *testing.T) {
+	wr := openLog(t, Options{})
+	appendN(t, wr, "alice", 3)
+	if err := wr.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	wr, err := Open(wr.Path(), Options{FsyncInterval: -1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer wr.Close()
+	if err := wr.Append("bob", client.ChangeEvent{Op: client.OpDelete, Key: "k1"}); err != nil {
+		t.Fatal(err)
+	}
+	records := readAll(t, wr.Path(), 0)
+	if len(records) != 4 || records[3].Seq != 4 || records[3].Agent != "bob" {
+		t.Fatalf("records after reopening %q", describe(records))
+	}
+}
+
+func TestTornRecordCutOff(t *testing.T) {
+	wr := openLog(t, Options{})
+	appendN(t, wr, "alice", 2)
+	wr.Close()
+
+	// A crash mid-write leaves part of a record
+	size := fileSize(t, wr.Path())
+	f, err := os.OpenFile(wr.Path(), os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte{40, 0, 0, 0, 1, 2, 3, 4, '{', '"'})
+	f.Close()
+
+	// Readers stop before it
+	if records := readAll(t, wr.Path(), 0); len(records) != 2 {
+		t.Fatalf("read %q past a torn record", describe(records))
+	}
+
+	// Opening cuts it off and numbering carries on
+	wr, err = Open(wr.Path(), Options{FsyncInterval: -1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer wr.Close()
+	if got := fileSize(t, wr.Path()); got != size {
+		t.Fatalf("segment is %d bytes after reopening, want %d", got, size)
+	}
+	appendN(t, wr, "alice", 1)
+	if got := describe(readAll(t, wr.Path(), 0)); len(got) != 3 || got[2] != "3 insert k1 text k1" {
+		t.Fatalf("records %q", got)
+	}
+}
+
+func fileSize(t *testing.T, path string) int64 {
+	t.Helper()
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return info.Size()
+}
+
+func TestRotation(t *testing.T) {
+	// Room for a handful of records per segment
+	wr := openLog(t, Options{MaxSize: 400, Keep: 2})
+	appendN(t, wr, "alice", 30)
+
+	segments, err := Segments(wr.Path())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(segments) != 3 {
+		t.Fatalf("%d segments, want 2 rotated and the active one", len(segments))
+	}
+	if stats := wr.Stats(); stats.Rotated < 3 || stats.Seq != 30 {
+		t.Fatalf("stats %+v", stats)
+	}
+
+	// Reading from the start begins at the oldest kept record, and every
+	// record after it follows in order
+	records := readAll(t, wr.Path(), 0)
+	if first := records[0].Seq; first != segments[0].FirstSeq {
+		t.Fatalf("first record %d, want %d", first, segments[0].FirstSeq)
+	}
+	for i, r := range records {
+		if r.Seq != records[0].Seq+uint64(i) {
+			t.Fatalf("record %d has seq %d after %d", i, r.Seq, records[0].Seq)
+		}
+	}
+	if last := records[len(records)-1].Seq; last != 30 {
+		t.Fatalf("last record %d, want 30", last)
+	}
+
+	// Resuming inside a rotated segment starts at the record asked for
+	from := segments[1].FirstSeq + 1
+	if got := readAll(t, wr.Path(), from); got[0].Seq != from || got[len(got)-1].Seq != 30 {
+		t.Fatalf("from %d: %q", from, describe(got))
+	}
+
+	// Reopening finds the last sequence number in a rotated segment when
+	// the active one is empty
+	wr.Close()
+	wr, err = Open(wr.Path(), Options{FsyncInterval: -1, MaxSize: 400})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer wr.Close()
+	if seq := wr.Stats().Seq; seq != 30 {
+		t.Fatalf("reopened at seq %d, want 30", seq)
+	}
+}
+
+func TestTailFollow(t *testing.T) {
+	wr := openLog(t, Options{MaxSize: 400})
+	appendN(t, wr, "alice", 2)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	got := make(chan uint64, 100)
+	done := make(chan error, 1)
+	go func() {
+		done <- Tail(ctx, wr.Path(), TailOptions{FromSeq: 2, Follow: true, PollInterval: 5 * time.Millisecond}, func(r *Record) error {
+			got <- r.Seq
+			return nil
+		})
+	}()
+
+	// Records written while following arrive in order, across rotations
+	appendN(t, wr, "alice", 20)
+	for want := uint64(2); want <= 22; want++ {
+		select {
+		case seq := <-got:
+			if seq != want {
+				t.Fatalf("followed seq %d, want %d", seq, want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for seq %d", want)
+		}
+	}
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAppendAfterClose(t *testing.T) {
+	wr := openLog(t, Options{})
+	wr.Close()
+	if err := wr.Append("alice", client.ChangeEvent{Op: client.OpInsert, Key: "k"}); err == nil {
+		t.Fatal("Append succeeded on a closed log")
+	}
+}
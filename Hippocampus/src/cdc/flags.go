@@ -0,0 +1,58 @@
+package cdc
+
+import (
+	"flag"
+	"fmt"
+	"time"
+)
+
+// Flags are the CDC log flags shared by the Redis and HTTP servers
+type Flags struct {
+	Path          string
+	FsyncInterval time.Duration
+	MaxSizeMB     int
+	Keep          int
+}
+
+// RegisterFlags adds -cdc-log, -cdc-fsync-interval, -cdc-max-size-mb and
+// -cdc-keep to fs
+func RegisterFlags(fs *flag.FlagSet) *Flags {
+	f := &Flags{}
+	fs.StringVar(&f.Path, "cdc-log", "", "Append every memory change, with a sequence number, to this change log")
+	fs.DurationVar(&f.FsyncInterval, "cdc-fsync-interval", defaultFsyncInterval, "How often the change log is synced to disk; a crash loses at most this much (0 syncs every change, slowing writes)")
+	fs.IntVar(&f.MaxSizeMB, "cdc-max-size-mb", defaultMaxSize>>20, "Rotate the change log once it reaches this many MB")
+	fs.IntVar(&f.Keep, "cdc-keep", 0, "Rotated change log files kept (0 keeps all)")
+	return f
+}
+
+// New opens the log the flags describe, or returns nil if -cdc-log is unset
+func (f *Flags) New() (*Writer, error) {
+	if f.Path == "" {
+		return nil, nil
+	}
+	if f.FsyncInterval < 0 || f.MaxSizeMB < 1 || f.Keep < 0 {
+		return nil, fmt.Errorf("-cdc-fsync-interval and -cdc-keep must be non-negative and -cdc-max-size-mb positive")
+	}
+
+	interval := f.FsyncInterval
+	if interval == 0 {
+		interval = -1 // Options treats 0 as the default
+	}
+	w, err := Open(f.Path, Options{
+		MaxSize:       int64(f.MaxSizeMB) << 20,
+		Keep:          f.Keep,
+		FsyncInterval: interval,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open change log: %w", err)
+	}
+	return w, nil
+}
+
+func (f *Flags) String() string {
+	sync := "every change"
+	if f.FsyncInterval > 0 {
+		sync = "every " + f.FsyncInterval.String()
+	}
+	return fmt.Sprintf("change log %s (synced %s, rotated at %dMB)", f.Path, sync, f.MaxSizeMB)
+}
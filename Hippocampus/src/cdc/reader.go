@@ -0,0 +1,256 @@
+package cdc
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"time"
+)
+
+// segmentReader reads the records of one segment
+type segmentReader struct {
+	r      *bufio.Reader
+	offset int64 // End of the last complete record
+}
+
+func newSegmentReader(r io.Reader) *segmentReader {
+	return &segmentReader{r: bufio.NewReader(r)}
+}
+
+func (sr *segmentReader) readMagic() error {
+	var magic uint32
+	if err := binary.Read(sr.r, binary.LittleEndian, &magic); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return errTorn
+		}
+		return err
+	}
+	if magic != segmentMagic {
+		return fmt.Errorf("not a CDC log segment")
+	}
+	sr.offset = 4
+	return nil
+}
+
+// next returns the next record, io.EOF at a clean end or errTorn at a record
+// cut short
+func (sr *segmentReader) next() (*Record, error) {
+	var header [8]byte
+	if _, err := io.ReadFull(sr.r, header[:]); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return nil, errTorn
+		}
+		return nil, err
+	}
+	length := binary.LittleEndian.Uint32(header[0:4])
+	if length > maxRecordSize {
+		return nil, fmt.Errorf("invalid record length %d at byte %d", length, sr.offset)
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(sr.r, payload); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil, errTorn
+		}
+		return nil, err
+	}
+	if crc32.ChecksumIEEE(payload) != binary.LittleEndian.Uint32(header[4:8]) {
+		return nil, fmt.Errorf("checksum mismatch in record at byte %d", sr.offset)
+	}
+
+	var record Record
+	if err := json.Unmarshal(payload, &record); err != nil {
+		return nil, fmt.Errorf("invalid record at byte %d: %w", sr.offset, err)
+	}
+	sr.offset += int64(len(header)) + int64(length)
+	return &record, nil
+}
+
+// TailOptions control Tail
+type TailOptions struct {
+	FromSeq      uint64        // First sequence number passed to fn; 0 starts at the oldest record
+	Follow       bool          // Wait for new records instead of returning at the end
+	PollInterval time.Duration // How often to look for new records when following
+}
+
+// Tail calls fn with every record of the log at path from opts.FromSeq on,
+// in order, crossing rotated segments. It returns at the end of the log, or
+// with Follow once ctx is done, or at the first error fn returns.
+func Tail(ctx context.Context, path string, opts TailOptions, fn func(*Record) error) error {
+	if opts.PollInterval <= 0 {
+		opts.PollInterval = 500 * time.Millisecond
+	}
+
+	segments, err := Segments(path)
+	if err != nil {
+		return err
+	}
+	// Start at the last rotated segment that can hold FromSeq
+	start := 0
+	for i, seg := range segments[:len(segments)-1] {
+		if seg.FirstSeq <= opts.FromSeq {
+			start = i
+		}
+	}
+
+	next := opts.FromSeq
+	for _, seg := range segments[start : len(segments)-1] {
+		if next, err = tailSegment(seg.Path, next, fn); err != nil {
+			return err
+		}
+	}
+	if !opts.Follow {
+		_, err := tailSegment(path, next, fn)
+		return err
+	}
+	return follow(ctx, path, next, opts.PollInterval, fn)
+}
+
+// tailSegment passes the records of a segment numbered from on to fn and
+// returns the sequence number wanted next. A missing segment is empty; one
+// ending in a record cut short ends before it.
+func tailSegment(path string, from uint64, fn func(*Record) error) (uint64, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return from, nil
+	}
+	if err != nil {
+		return from, err
+	}
+	defer f.Close()
+
+	r := newSegmentReader(f)
+	if err := r.readMagic(); err != nil {
+		if err == io.EOF || errors.Is(err, errTorn) {
+			return from, nil
+		}
+		return from, fmt.Errorf("%s: %w", path, err)
+	}
+	for {
+		record, err := r.next()
+		if err == io.EOF || errors.Is(err, errTorn) {
+			return from, nil
+		}
+		if err != nil {
+			return from, fmt.Errorf("%s: %w", path, err)
+		}
+		if record.Seq < from {
+			continue
+		}
+		if err := fn(record); err != nil {
+			return from, err
+		}
+		from = record.Seq + 1
+	}
+}
+
+// follow reads the active segment as it grows. When the writer rotates it,
+// the rest of the renamed segment is read before the new one.
+func follow(ctx context.Context, path string, next uint64, poll time.Duration, fn func(*Record) error) error {
+	var (
+		f    *os.File
+		info os.FileInfo
+		r    *segmentReader
+	)
+	defer func() {
+		if f != nil {
+			f.Close()
+		}
+	}()
+
+	for {
+		if f == nil {
+			var err error
+			if f, err = os.Open(path); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+			if f != nil {
+				if info, err = f.Stat(); err != nil {
+					return err
+				}
+				r = newSegmentReader(f)
+			}
+		}
+
+		if f != nil {
+			// The writer syncs a segment before renaming it, so once it has
+			// been renamed, reading to the end reads all of it
+			current, err := os.Stat(path)
+			rotated := err == nil && !os.SameFile(info, current)
+
+			if next, err = drain(f, r, next, fn); err != nil {
+				return fmt.Errorf("%s: %w", path, err)
+			}
+			if rotated {
+				f.Close()
+				f = nil
+
+				// The writer may have rotated again since; read any segments
+				// passed over before the active one
+				for {
+					skipped := segmentPath(path, next)
+					if _, err := os.Stat(skipped); err != nil {
+						break
+					}
+					before := next
+					if next, err = tailSegment(skipped, next, fn); err != nil {
+						return err
+					}
+					if next == before {
+						break // Nothing readable in it
+					}
+				}
+				continue
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(poll):
+		}
+	}
+}
+
+// drain passes the complete records from r's position to fn, then leaves r
+// at the end of the last one, to continue once more is written
+func drain(f *os.File, r *segmentReader, next uint64, fn func(*Record) error) (uint64, error) {
+	if r.offset == 0 {
+		if err := r.readMagic(); err != nil {
+			if err == io.EOF || errors.Is(err, errTorn) {
+				return next, rewind(f, r)
+			}
+			return next, err
+		}
+	}
+	for {
+		record, err := r.next()
+		if err == io.EOF || errors.Is(err, errTorn) {
+			return next, rewind(f, r)
+		}
+		if err != nil {
+			return next, err
+		}
+		if record.Seq < next {
+			continue
+		}
+		if err := fn(record); err != nil {
+			return next, err
+		}
+		next = record.Seq + 1
+	}
+}
+
+// rewind moves back to the end of the last complete record
+func rewind(f *os.File, r *segmentReader) error {
+	if _, err := f.Seek(r.offset, io.SeekStart); err != nil {
+		return err
+	}
+	r.r.Reset(f)
+	return nil
+}
@@ -0,0 +1,173 @@
+package client
+
+import (
+	"Hippocampus/src/embedding"
+	hippotypes "Hippocampus/src/types"
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// chunkKeySep separates a logical key from its chunk number: "key:chunk:N"
+const chunkKeySep = ":chunk:"
+
+// ChunkedResult is the best-scoring chunk of one logical entry
+type ChunkedResult struct {
+	Key   string // Logical key, without the chunk suffix
+	Chunk int    // Chunk number of the best match, 0 for unchunked entries
+	Text  string // Text of the best matching chunk
+	Score float32
+}
+
+// SetChunking makes InsertCSV split texts longer than chunkSize bytes into
+// overlapping chunks. A chunkSize of 0 disables chunking.
+func (client *Client) SetChunking(chunkSize, overlap int) {
+	client.chunkSize = chunkSize
+	client.chunkOverlap = overlap
+}
+
+// InsertChunked splits text into overlapping windows of at most chunkSize bytes
+// and inserts each as "key:chunk:N". Texts that fit in one chunk are inserted
+// under the plain key. Each chunk replaces the one stored under its key;
+// chunks whose text is unchanged are skipped.
+func (client *Client) InsertChunked(key, text string, chunkSize, overlap int) (chunksInserted int, err error) {
+	if chunkSize <= 0 {
+		return 0, fmt.Errorf("chunk size must be positive, got %d", chunkSize)
+	}
+	if overlap < 0 || overlap >= chunkSize {
+		return 0, fmt.Errorf("overlap must be in [0, %d), got %d", chunkSize, overlap)
+	}
+
+	if len(text) <= chunkSize {
+		if err := client.Insert(key, text); err != nil {
+			return 0, err
+		}
+		return 1, nil
+	}
+
+	for i, chunk := range chunkText(text, chunkSize, overlap) {
+		chunkKey := key + chunkKeySep + strconv.Itoa(i)
+		if err := client.Insert(chunkKey, chunk); err != nil {
+			if errors.Is(err, hippotypes.ErrDuplicateKey) {
+				continue
+			}
+			return chunksInserted, err
+		}
+		chunksInserted++
+	}
+
+	return chunksInserted, nil
+}
+
+// SearchChunked searches like Search but groups chunk hits by their logical
+// key, returning the best-scoring chunk for each of up to topK entries
+func (client *Client) SearchChunked(text string, epsilon float32, threshold float32, topK int) ([]ChunkedResult, error) {
+	ctx := context.Background()
+
+	var embeddingArray [512]float32
+	if err := embedding.GetEmbeddingInto(ctx, client.embedder(), text, &embeddingArray); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrEmbedding, err)
+	}
+
+	tree, err := client.getTree()
+	if err != nil {
+		return nil, fmt.Errorf("tree loading error: %w", err)
+	}
+
+	// Every chunk of an entry may match, so fetch all candidates and group them
+	scored := tree.SearchScored(embeddingArray, hippotypes.SearchOptions{
+		Epsilon:   epsilon,
+		Threshold: threshold,
+		TopK:      tree.Len(),
+	})
+
+	results := make([]ChunkedResult, 0, topK)
+	seen := make(map[string]bool)
+	for _, hit := range scored {
+		if len(results) >= topK {
+			break
+		}
+
+		// Hits are closest first, so the first chunk seen per key is the best
+		baseKey, chunk := splitChunkKey(hit.Node.ID)
+		if seen[baseKey] {
+			continue
+		}
+		seen[baseKey] = true
+
+		results = append(results, ChunkedResult{
+			Key:   baseKey,
+			Chunk: chunk,
+			Text:  hit.Node.Value,
+			Score: hit.Score,
+		})
+	}
+
+	if client.verbose {
+		client.logf("\nFound %d entries (top %d, threshold %.2f):\n", len(results), topK, threshold)
+		for _, r := range results {
+			client.logf("  [%s#%d %.3f] %s\n", r.Key, r.Chunk, r.Score, r.Text)
+		}
+	}
+
+	return results, nil
+}
+
+// chunkText splits text into windows of at most chunkSize bytes that overlap
+// by roughly overlap bytes. Boundaries are moved back to UTF-8 rune starts so
+// no multi-byte character is split.
+func chunkText(text string, chunkSize, overlap int) []string {
+	var chunks []string
+
+	start := 0
+	for start < len(text) {
+		end := start + chunkSize
+		if end >= len(text) {
+			chunks = append(chunks, text[start:])
+			break
+		}
+
+		for end > start && !utf8.RuneStart(text[end]) {
+			end--
+		}
+		if end == start {
+			// chunkSize is smaller than a single rune; take the whole rune
+			_, size := utf8.DecodeRuneInString(text[start:])
+			end = start + size
+		}
+		chunks = append(chunks, text[start:end])
+		if end == len(text) {
+			break
+		}
+
+		next := end - overlap
+		for next > start && !utf8.RuneStart(text[next]) {
+			next--
+		}
+		// Drop the overlap if the next chunk couldn't reach past this one
+		_, size := utf8.DecodeRuneInString(text[end:])
+		if next <= start || end+size > next+chunkSize {
+			next = end
+		}
+		start = next
+	}
+
+	return chunks
+}
+
+// splitChunkKey splits "key:chunk:N" into its logical key and chunk number
+func splitChunkKey(id string) (string, int) {
+	i := strings.LastIndex(id, chunkKeySep)
+	if i < 0 {
+		return id, 0
+	}
+
+	n, err := strconv.Atoi(id[i+len(chunkKeySep):])
+	if err != nil {
+		return id, 0
+	}
+	return id[:i], n
+}
@@ -0,0 +1,171 @@
+package client
+
+import (
+	"Hippocampus/src/embedding"
+	"slices"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestChunkText(t *testing.T) {
+	tests := []struct {
+		name      string
+		text      string
+		chunkSize int
+		overlap   int
+		want      []string
+	}{
+		{"no overlap", "aaaabbbbcc", 4, 0, []string{"aaaa", "bbbb", "cc"}},
+		{"overlap", "abcdefghij", 4, 2, []string{"abcd", "cdef", "efgh", "ghij"}},
+		{"last chunk exactly full", "abcdefgh", 4, 0, []string{"abcd", "efgh"}},
+		{"fits in one chunk", "abc", 4, 1, []string{"abc"}},
+		// "é" is 2 bytes and "世" 3, so byte boundaries fall inside them
+		{"end moved back to a rune start", "aébc", 2, 0, []string{"a", "é", "bc"}},
+		{"overlap moved back to a rune start", "ab世cd", 5, 2, []string{"ab世", "世cd"}},
+		{"overlap dropped when it leaves no room for the next rune", "ağ一", 4, 1, []string{"ağ", "一"}},
+		{"chunk smaller than a rune", "世界", 2, 0, []string{"世", "界"}},
+		{"chunk smaller than a rune, with overlap", "世界", 2, 1, []string{"世", "界"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := chunkText(tt.text, tt.chunkSize, tt.overlap)
+			if !slices.Equal(got, tt.want) {
+				t.Fatalf("chunkText(%q, %d, %d) = %q, want %q", tt.text, tt.chunkSize, tt.overlap, got, tt.want)
+			}
+		})
+	}
+}
+
+// Whatever the sizes, chunks are valid UTF-8 within the size, each starts
+// inside the one before, overlapping it by no more than overlap moved back
+// to a rune start, and together they cover the whole text
+func TestChunkTextCoversText(t *testing.T) {
+	// No rune repeats, so each chunk is found at its own offset: 1-, 2- and
+	// 3-byte runes
+	var b strings.Builder
+	for r := rune(0x60); r < 0x120; r++ {
+		b.WriteRune(r)
+	}
+	for r := rune(0x4E00); r < 0x4E40; r++ {
+		b.WriteRune(r)
+	}
+	text := b.String()
+
+	for chunkSize := 4; chunkSize <= 40; chunkSize += 3 {
+		for _, overlap := range []int{0, 1, chunkSize / 3, chunkSize - 1} {
+			end := 0
+			for i, chunk := range chunkText(text, chunkSize, overlap) {
+				start := strings.Index(text, chunk)
+				if !utf8.ValidString(chunk) || len(chunk) > chunkSize || start < 0 {
+					t.Fatalf("size %d overlap %d: chunk %d is %q", chunkSize, overlap, i, chunk)
+				}
+				if start > end || (i > 0 && start+len(chunk) <= end) {
+					t.Fatalf("size %d overlap %d: chunk %d leaves a gap or adds nothing", chunkSize, overlap, i)
+				}
+				if end-start >= overlap+utf8.UTFMax {
+					t.Fatalf("size %d overlap %d: chunk %d overlaps the one before by %d bytes", chunkSize, overlap, i, end-start)
+				}
+				end = start + len(chunk)
+			}
+			if end != len(text) {
+				t.Fatalf("size %d overlap %d: chunks cover %d of %d bytes", chunkSize, overlap, end, len(text))
+			}
+		}
+	}
+}
+
+func TestSplitChunkKey(t *testing.T) {
+	tests := []struct {
+		id    string
+		key   string
+		chunk int
+	}{
+		{"doc:chunk:3", "doc", 3},
+		{"a:chunk:1:chunk:2", "a:chunk:1", 2},
+		{"doc", "doc", 0},
+		{"doc:chunk:x", "doc:chunk:x", 0},
+		{"user:42", "user:42", 0},
+	}
+	for _, tt := range tests {
+		if key, chunk := splitChunkKey(tt.id); key != tt.key || chunk != tt.chunk {
+			t.Errorf("splitChunkKey(%q) = %q, %d, want %q, %d", tt.id, key, chunk, tt.key, tt.chunk)
+		}
+	}
+}
+
+func TestInsertChunked(t *testing.T) {
+	c := newTestClient(t)
+	c.SetVerbose(false)
+
+	n, err := c.InsertChunked("doc", "aaaabbbbcc", 4, 0)
+	if err != nil || n != 3 {
+		t.Fatalf("InsertChunked = %d, %v, want 3 chunks", n, err)
+	}
+	if n, err := c.InsertChunked("short", "abc", 4, 0); err != nil || n != 1 {
+		t.Fatalf("InsertChunked of a short text = %d, %v", n, err)
+	}
+	keys, err := c.Keys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"doc:chunk:0", "doc:chunk:1", "doc:chunk:2", "short"}
+	if !slices.Equal(keys, want) {
+		t.Fatalf("keys %q, want %q", keys, want)
+	}
+	if node, _, _ := c.Get("doc:chunk:1"); node.Value != "bbbb" {
+		t.Fatalf("chunk 1 holds %q", node.Value)
+	}
+
+	// Unchanged chunks are skipped when the text is inserted again
+	if n, err := c.InsertChunked("doc", "aaaabbbbcc", 4, 0); err != nil || n != 0 {
+		t.Fatalf("reinserting = %d, %v, want nothing inserted", n, err)
+	}
+
+	for _, tt := range []struct{ chunkSize, overlap int }{{0, 0}, {-1, 0}, {4, 4}, {4, -1}} {
+		if _, err := c.InsertChunked("bad", "text", tt.chunkSize, tt.overlap); err == nil {
+			t.Errorf("InsertChunked with size %d overlap %d succeeded", tt.chunkSize, tt.overlap)
+		}
+	}
+}
+
+func TestSearchChunkedGroupsByKey(t *testing.T) {
+	embedder := embedding.NewMockEmbedder()
+	c, err := New(embedder)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.SetVerbose(false)
+
+	// Two of doc's chunks match, the second best; other sits in between
+	for text, v := range map[string]float32{
+		"aaaa": 0.03, "bbbb": 0.01, "cc": 0.5,
+		"other": 0.02, "query": 0,
+	} {
+		embedder.SetResponse(text, at(v))
+	}
+	if _, err := c.InsertChunked("doc", "aaaabbbbcc", 4, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Insert("other", "other"); err != nil {
+		t.Fatal(err)
+	}
+
+	results, err := c.SearchChunked("query", DefaultEpsilon, DefaultThreshold, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 2 {
+		t.Fatalf("SearchChunked = %+v, want one result per entry", results)
+	}
+	if r := results[0]; r.Key != "doc" || r.Chunk != 1 || r.Text != "bbbb" {
+		t.Fatalf("best result %+v, want doc's chunk 1", r)
+	}
+	if r := results[1]; r.Key != "other" || r.Chunk != 0 || r.Score > results[0].Score {
+		t.Fatalf("second result %+v", r)
+	}
+
+	if results, err := c.SearchChunked("query", DefaultEpsilon, DefaultThreshold, 1); err != nil || len(results) != 1 || results[0].Key != "doc" {
+		t.Fatalf("SearchChunked top 1 = %+v, %v", results, err)
+	}
+}
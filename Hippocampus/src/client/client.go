@@ -4,50 +4,143 @@ import (
 	"Hippocampus/src/embedding"
 	"Hippocampus/src/storage"
 	hippotypes "Hippocampus/src/types"
+	"bytes"
 	"context"
 	"encoding/csv"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"log"
+	"math"
 	"os"
+	"sync"
 	"time"
 )
 
+// ErrEmbedding wraps failures of the embedding service, so callers can tell
+// them apart from storage and input errors
+var ErrEmbedding = errors.New("embedding error")
+
+// ErrModelMismatch is returned by CheckModelVersion when embeddings come from
+// a different embedding model than the client's
+var ErrModelMismatch = errors.New("embedding model mismatch")
+
+// ErrStorageNotEmpty is returned by MigrateStorage when both the client and
+// the new storage hold memories
+var ErrStorageNotEmpty = errors.New("storage already holds memories")
+
+// ErrKeyNotFound is returned by SearchSimilarTo when no memory is stored
+// under the key
+var ErrKeyNotFound = errors.New("no memory under key")
+
+// ErrInvalidMetadata is returned when metadata stored with a memory isn't a
+// JSON object
+var ErrInvalidMetadata = errors.New("metadata must be a JSON object")
+
+// ErrDimensionMismatch is returned by SetEmbedder when the new embedder's
+// vectors don't have the tree's 512 dimensions
+var ErrDimensionMismatch = embedding.ErrDimensionMismatch
+
 type Client struct {
-	Storage   storage.Storage
-	Embedder  embedding.EmbeddingService
+	Storage  storage.Storage            // Read with CurrentStorage and replace with MigrateStorage once the client is in use
+	Embedder embedding.EmbeddingService // Replace with SetEmbedder once the client is in use
+
+	embedderMu sync.RWMutex // Guards Embedder against SetEmbedder
+	storageMu  sync.RWMutex // Guards Storage against MigrateStorage
 
 	// In-memory cache
+	cacheMu    sync.Mutex // Guards cachedTree and dirty
 	cachedTree *hippotypes.Tree
 	dirty      bool
+	modified   time.Time // Last change to the cached tree
 	verbose    bool
+	logOut     io.Writer // Verbose output; nil means stdout
+
+	// Persist the search index on Flush when the storage supports it
+	saveIndex bool
+
+	// Chunking applied by InsertCSV; chunkSize 0 disables it
+	chunkSize    int
+	chunkOverlap int
+
+	// How Merge resolves keys stored in both clients
+	mergePolicy MergePolicy
+
+	// Embedding model the stored embeddings come from; empty if unknown
+	modelVersion string
+
+	changeHooks changeHooks // Called by OnChange after every change
+}
+
+// Option configures a Client at construction
+type Option func(*Client)
+
+// WithInitialTree seeds the client with t in place of loading from storage.
+// The tree counts as unsaved, so the next Flush writes it to storage.
+func WithInitialTree(t *hippotypes.Tree) Option {
+	return func(client *Client) {
+		client.cachedTree = t
+		client.dirty = true
+		client.modified = time.Now()
+	}
 }
 
 // New creates a new client with in-memory storage
-func New(embedder embedding.EmbeddingService) (c *Client, err error) {
-	return &Client{
+func New(embedder embedding.EmbeddingService, opts ...Option) (c *Client, err error) {
+	c = &Client{
 		Storage:    storage.NewMemoryStorage(),
 		Embedder:   embedder,
 		cachedTree: nil,
 		dirty:      false,
 		verbose:    true,
-	}, nil
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c, nil
 }
 
 // NewWithFileStorage creates a client with file-based storage (for backward compatibility)
-func NewWithFileStorage(binaryPath string, embedder embedding.EmbeddingService) (c *Client, err error) {
-	return &Client{
+func NewWithFileStorage(binaryPath string, embedder embedding.EmbeddingService, opts ...Option) (c *Client, err error) {
+	c = &Client{
 		Storage:    storage.NewFileStorage(binaryPath),
 		Embedder:   embedder,
 		cachedTree: nil,
 		dirty:      false,
 		verbose:    true,
-	}, nil
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c, nil
+}
+
+// NewWithStorage creates a client on any storage backend, e.g.
+// storage.NewAppendOnlyFileStorage
+func NewWithStorage(s storage.Storage, embedder embedding.EmbeddingService, opts ...Option) (c *Client, err error) {
+	c = &Client{
+		Storage:    s,
+		Embedder:   embedder,
+		cachedTree: nil,
+		dirty:      false,
+		verbose:    true,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c, nil
 }
 
 // getTree returns the in-memory tree, loading from storage if needed
 func (client *Client) getTree() (*hippotypes.Tree, error) {
+	client.cacheMu.Lock()
+	defer client.cacheMu.Unlock()
+
+	// Loading under the lock means concurrent first calls share one tree
+	// instead of each caching its own snapshot and losing the other's writes
 	if client.cachedTree == nil {
-		tree, err := client.Storage.Load()
+		tree, err := client.CurrentStorage().Load()
 		if err != nil {
 			return nil, err
 		}
@@ -56,10 +149,155 @@ func (client *Client) getTree() (*hippotypes.Tree, error) {
 	return client.cachedTree, nil
 }
 
+// deleter is implemented by storages with a say in deletes and overwrites;
+// storage.AppendOnlyFileStorage refuses them with storage.ErrImmutable
+type deleter interface {
+	Delete(id string) error
+}
+
+// checkDelete asks the storage whether the memory under key may be deleted
+// or overwritten
+func (client *Client) checkDelete(key string) error {
+	if d, ok := client.CurrentStorage().(deleter); ok {
+		return d.Delete(key)
+	}
+	return nil
+}
+
+// indexSaver is implemented by storages that can persist the search index
+// alongside the tree (see storage.FileStorage.SaveWithIndex)
+type indexSaver interface {
+	SaveWithIndex(t *hippotypes.Tree) error
+}
+
 // Flush writes the cached tree to storage if dirty
 func (client *Client) Flush() error {
+	client.cacheMu.Lock()
+	defer client.cacheMu.Unlock()
+	return client.flushLocked()
+}
+
+// Reload flushes the cached tree, drops it and loads it back from storage
+func (client *Client) Reload() error {
+	client.cacheMu.Lock()
+	err := client.flushLocked()
+	if err == nil {
+		client.cachedTree = nil
+	}
+	client.cacheMu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	_, err = client.getTree()
+	return err
+}
+
+// rotator is implemented by storages that can archive their contents and
+// start over empty (see storage.FileStorage.Rotate)
+type rotator interface {
+	Rotate(archiveDir string) error
+}
+
+// Rotate flushes the cached tree, has the storage archive it into archiveDir
+// and continues with an empty tree. Only storages with a Rotate method, such
+// as FileStorage, support it.
+func (client *Client) Rotate(archiveDir string) error {
+	st := client.CurrentStorage()
+	r, ok := st.(rotator)
+	if !ok {
+		return fmt.Errorf("storage %T does not support rotation", st)
+	}
+
+	client.cacheMu.Lock()
+	defer client.cacheMu.Unlock()
+
+	if err := client.flushLocked(); err != nil {
+		return err
+	}
+	if err := r.Rotate(archiveDir); err != nil {
+		return err
+	}
+	client.cachedTree = hippotypes.NewTree()
+	client.dirty = false
+	client.modified = time.Now()
+	return nil
+}
+
+// CurrentStorage returns the storage, which MigrateStorage may swap at any
+// time. Code outside the client reads the storage through it rather than the
+// Storage field.
+func (client *Client) CurrentStorage() storage.Storage {
+	client.storageMu.RLock()
+	defer client.storageMu.RUnlock()
+	return client.Storage
+}
+
+// MigrateStorage moves the client onto s, e.g. to persist an in-memory
+// client to a file. The client's memories are saved to s, replacing what s
+// held; if the client holds none, it takes on s's memories instead. When both
+// hold memories, ErrStorageNotEmpty is returned and nothing changes, as it
+// does if saving to s fails. The old storage is left as it was.
+func (client *Client) MigrateStorage(s storage.Storage) error {
+	adopted, err := client.migrateStorage(s)
+	if adopted {
+		client.emitChange(OpReset, "", "")
+	}
+	return err
+}
+
+// migrateStorage is MigrateStorage, reporting whether the client took on s's
+// memories
+func (client *Client) migrateStorage(s storage.Storage) (bool, error) {
+	client.cacheMu.Lock()
+	defer client.cacheMu.Unlock()
+
+	tree := client.cachedTree
+	if tree == nil {
+		var err error
+		if tree, err = client.CurrentStorage().Load(); err != nil {
+			return false, fmt.Errorf("tree loading error: %w", err)
+		}
+	}
+
+	existing, err := s.Load()
+	if err != nil {
+		return false, fmt.Errorf("tree loading error: %w", err)
+	}
+	adopted := false
+	switch {
+	case tree.Len() == 0:
+		adopted = existing.Len() > 0
+		tree = existing
+		client.dirty = false
+		client.modified = time.Now()
+	case existing.Len() > 0:
+		return false, fmt.Errorf("%w: it holds %d memories and the client %d", ErrStorageNotEmpty, existing.Len(), tree.Len())
+	default:
+		if err := s.Save(tree); err != nil {
+			return false, err
+		}
+		client.dirty = false
+	}
+
+	client.storageMu.Lock()
+	client.Storage = s
+	client.storageMu.Unlock()
+
+	client.cachedTree = tree
+	return adopted, nil
+}
+
+// flushLocked saves the cached tree if dirty. Callers must hold cacheMu.
+func (client *Client) flushLocked() error {
 	if client.dirty && client.cachedTree != nil {
-		if err := client.Storage.Save(client.cachedTree); err != nil {
+		st := client.CurrentStorage()
+		save := st.Save
+		if is, ok := st.(indexSaver); ok && client.saveIndex {
+			save = is.SaveWithIndex
+		}
+
+		if err := save(client.cachedTree); err != nil {
 			return err
 		}
 		client.dirty = false
@@ -67,20 +305,128 @@ func (client *Client) Flush() error {
 	return nil
 }
 
+// SetModelVersion records which embedding model produced the client's
+// embeddings, so CheckModelVersion can refuse vectors from another one
+func (client *Client) SetModelVersion(version string) {
+	client.modelVersion = version
+}
+
+// ModelVersion returns the version set by SetModelVersion
+func (client *Client) ModelVersion() string {
+	return client.modelVersion
+}
+
+// CheckModelVersion returns ErrModelMismatch if the client and version both
+// name an embedding model and they differ. Callers check it before storing
+// embeddings computed elsewhere, e.g. with InsertRaw.
+func (client *Client) CheckModelVersion(version string) error {
+	if client.modelVersion != "" && version != "" && version != client.modelVersion {
+		return fmt.Errorf("%w: embeddings from %q, client uses %q", ErrModelMismatch, version, client.modelVersion)
+	}
+	return nil
+}
+
+// embedder returns the current embedder, which SetEmbedder may swap at any time
+func (client *Client) embedder() embedding.EmbeddingService {
+	client.embedderMu.RLock()
+	defer client.embedderMu.RUnlock()
+	return client.Embedder
+}
+
+// SetEmbedder replaces the embedder used by later inserts and searches, e.g.
+// to move to an upgraded model without restarting. e is first asked for an
+// embedding; if it fails, or returns other than 512 dimensions
+// (ErrDimensionMismatch), the embedder is left unchanged. Stored nodes keep
+// the embeddings of the old embedder, so a change of model is logged as a
+// warning: searches compare new embeddings with old ones until the memories
+// are re-inserted.
+func (client *Client) SetEmbedder(e embedding.EmbeddingService) error {
+	probe, err := embedding.GetEmbedding(context.Background(), e, "dimension probe")
+	if err != nil {
+		if errors.Is(err, ErrDimensionMismatch) {
+			return err
+		}
+		return fmt.Errorf("%w: %w", ErrEmbedding, err)
+	}
+	if len(probe) != 512 {
+		return fmt.Errorf("%w: new embedder returns %d dimensions, the tree stores 512", ErrDimensionMismatch, len(probe))
+	}
+
+	client.embedderMu.Lock()
+	old := client.Embedder
+	client.Embedder = e
+	client.embedderMu.Unlock()
+
+	from, to := embedding.ModelName(old), embedding.ModelName(e)
+	if from != to {
+		if client.modelVersion != "" {
+			from = client.modelVersion
+		}
+		log.Printf("WARNING: embedding model changed from %q to %q; memories stored earlier keep their %q embeddings", from, to, from)
+	}
+	return nil
+}
+
+// Prefetch embeds texts ahead of a burst of inserts, storing the embeddings
+// in the client's embedding cache without inserting them, so inserting the
+// same texts then finds them cached. The embedder must be an
+// embedding.CachedEmbedder. Texts missing from the cache are embedded in one
+// batch; when some fail the rest stay cached, and the error counts the
+// failures.
+func (client *Client) Prefetch(ctx context.Context, texts []string) error {
+	cache, ok := client.embedder().(*embedding.CachedEmbedder)
+	if !ok {
+		return fmt.Errorf("prefetch needs a cached embedder, the client has %T", client.embedder())
+	}
+
+	_, errs := embedding.GetEmbeddings(ctx, cache, texts)
+	failed := 0
+	var first error
+	for _, err := range errs {
+		if err != nil {
+			if first == nil {
+				first = err
+			}
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%w: %d of %d texts failed to embed, the first with: %w", ErrEmbedding, failed, len(texts), first)
+	}
+	return nil
+}
+
+// SetSaveIndex makes Flush also persist the search index (a .idx file for
+// FileStorage) so the next load can skip rebuilding it
+func (client *Client) SetSaveIndex(saveIndex bool) {
+	client.saveIndex = saveIndex
+}
+
+// Insert embeds text and stores it under key, replacing the memory already
+// stored there. Storing the same text under the key again returns
+// types.ErrDuplicateKey.
 func (client *Client) Insert(key, text string) error {
+	return client.InsertWithMetadata(key, text, "")
+}
+
+// InsertWithMetadata is Insert also storing metadata, a JSON object or "" for
+// none, which Get and searches return with the memory
+func (client *Client) InsertWithMetadata(key, text, metadata string) error {
+	metadata, err := compactMetadata(metadata)
+	if err != nil {
+		return err
+	}
 	ctx := context.Background()
 
 	// Time embedding generation
 	embedStart := time.Now()
-	embeddingSlice, err := embedding.GetEmbedding(ctx, client.Embedder, text)
+	var embeddingArray [512]float32
+	err = embedding.GetEmbeddingInto(ctx, client.embedder(), text, &embeddingArray)
 	embedDuration := time.Since(embedStart)
 	if err != nil {
-		return fmt.Errorf("embedding error: %w", err)
+		return fmt.Errorf("%w: %w", ErrEmbedding, err)
 	}
 
-	var embeddingArray [512]float32
-	copy(embeddingArray[:], embeddingSlice)
-
 	// Time tree loading
 	loadStart := time.Now()
 	tree, err := client.getTree()
@@ -91,13 +437,16 @@ func (client *Client) Insert(key, text string) error {
 
 	// Time pure insert operation
 	insertStart := time.Now()
-	tree.Insert(embeddingArray, text)
+	if err := client.upsert(tree, hippotypes.Node{Key: embeddingArray, ID: key, Value: text, Metadata: metadata}); err != nil {
+		return err
+	}
 	insertDuration := time.Since(insertStart)
-	client.dirty = true
 
-	// Time storage flush (if needed)
+	// Time storage flush (if needed). Other inserters may hold the tree's
+	// lock, so count the nodes through it
+	nodes := tree.Len()
 	var flushDuration time.Duration
-	if len(tree.Nodes) % 100 == 0 {
+	if nodes%100 == 0 {
 		flushStart := time.Now()
 		if err := client.Flush(); err != nil {
 			return fmt.Errorf("flush error: %w", err)
@@ -106,8 +455,8 @@ func (client *Client) Insert(key, text string) error {
 	}
 
 	if client.verbose {
-		fmt.Printf("Successfully inserted %s (total nodes: %d)\n", key, len(tree.Nodes))
-		fmt.Printf("TIMING:EMBED:%.3f:LOAD:%.3f:INSERT:%.3f:FLUSH:%.3f\n",
+		client.logf("Successfully inserted %s (total nodes: %d)\n", key, nodes)
+		client.logf("TIMING:EMBED:%.3f:LOAD:%.3f:INSERT:%.3f:FLUSH:%.3f\n",
 			embedDuration.Seconds()*1000,
 			loadDuration.Seconds()*1000,
 			insertDuration.Seconds()*1000,
@@ -116,20 +465,115 @@ func (client *Client) Insert(key, text string) error {
 	return nil
 }
 
+// InsertRaw is InsertEmbedding for an embedding held in a slice, e.g. one
+// decoded from an export, which must have 512 dimensions
+func (client *Client) InsertRaw(id, text string, embedding []float32) error {
+	if len(embedding) != 512 {
+		return fmt.Errorf("embedding has %d dimensions, expected 512", len(embedding))
+	}
+	return client.InsertEmbedding(id, text, [512]float32(embedding))
+}
+
+// InsertEmbedding stores text under key with an embedding computed earlier,
+// e.g. by an export, without calling the embedder
+func (client *Client) InsertEmbedding(key, text string, embedding [512]float32) error {
+	return client.InsertNode(hippotypes.Node{Key: embedding, ID: key, Value: text})
+}
+
+// InsertNode stores a node as given, embedding and metadata included,
+// without calling the embedder. Like Insert it replaces the memory stored
+// under the node's ID.
+func (client *Client) InsertNode(node hippotypes.Node) error {
+	metadata, err := compactMetadata(node.Metadata)
+	if err != nil {
+		return err
+	}
+	node.Metadata = metadata
+
+	tree, err := client.getTree()
+	if err != nil {
+		return fmt.Errorf("tree loading error: %w", err)
+	}
+
+	return client.upsert(tree, node)
+}
+
+// upsert stores node in tree, replacing a different memory stored under its
+// key, which storages that never overwrite refuse. The same memory again
+// returns ErrDuplicateKey.
+func (client *Client) upsert(tree *hippotypes.Tree, node hippotypes.Node) error {
+	if existing, found := tree.GetID(node.ID); found && hippotypes.NodeHash(&existing) != hippotypes.NodeHash(&node) {
+		if err := client.checkDelete(node.ID); err != nil {
+			return err
+		}
+	}
+
+	replaced, err := tree.UpsertNode(node)
+	if err != nil {
+		return fmt.Errorf("insert error for %s: %w", node.ID, err)
+	}
+	client.markDirty()
+	if replaced {
+		client.emitChange(OpUpdate, node.ID, node.Value)
+	} else {
+		client.emitChange(OpInsert, node.ID, node.Value)
+	}
+	return nil
+}
+
+// compactMetadata checks that metadata is a JSON object, or empty, and
+// returns it without insignificant whitespace
+func compactMetadata(metadata string) (string, error) {
+	if metadata == "" {
+		return "", nil
+	}
+	var buf bytes.Buffer
+	if err := json.Compact(&buf, []byte(metadata)); err != nil {
+		return "", fmt.Errorf("%w: %w", ErrInvalidMetadata, err)
+	}
+	if buf.Len() == 0 || buf.Bytes()[0] != '{' {
+		return "", ErrInvalidMetadata
+	}
+	return buf.String(), nil
+}
+
 func (client *Client) Search(text string, epsilon float32, threshold float32, topK int) ([]string, error) {
+	return client.SearchWithOptions(text, hippotypes.SearchOptions{
+		Epsilon:   epsilon,
+		Threshold: threshold,
+		TopK:      topK,
+	})
+}
+
+// SearchWithOptions searches using full SearchOptions, e.g. per-dimension epsilons
+// calibrated with EstimateDimensionVariances
+func (client *Client) SearchWithOptions(text string, opts hippotypes.SearchOptions) ([]string, error) {
+	results, err := client.SearchScored(text, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	values := make([]string, len(results))
+	for i := range results {
+		values[i] = results[i].Node.Value
+	}
+	return values, nil
+}
+
+// SearchScored is SearchWithOptions returning whole nodes with their scores, closest first
+func (client *Client) SearchScored(text string, opts hippotypes.SearchOptions) ([]hippotypes.ScoredNode, error) {
 	ctx := context.Background()
 
 	// Time embedding generation
 	embedStart := time.Now()
-	embeddingSlice, err := embedding.GetEmbedding(ctx, client.Embedder, text)
+	embeddingArray := hippotypes.GetKeyArray()
+	defer hippotypes.PutKeyArray(embeddingArray)
+	err := embedding.GetEmbeddingInto(ctx, client.embedder(), text, embeddingArray)
 	embedDuration := time.Since(embedStart)
 	if err != nil {
-		return nil, fmt.Errorf("embedding error: %w", err)
+		return nil, fmt.Errorf("%w: %w", ErrEmbedding, err)
 	}
 
-	var embeddingArray [512]float32
-	copy(embeddingArray[:], embeddingSlice)
-
 	// Time tree loading
 	loadStart := time.Now()
 	tree, err := client.getTree()
@@ -140,53 +584,584 @@ func (client *Client) Search(text string, epsilon float32, threshold float32, to
 
 	// Time pure search operation
 	searchStart := time.Now()
-	results := tree.Search(embeddingArray, epsilon, threshold, topK)
+	results := tree.SearchScored(*embeddingArray, opts)
 	searchDuration := time.Since(searchStart)
 
-	values := make([]string, len(results))
-	for i, node := range results {
-		values[i] = node.Value
+	if client.verbose {
+		client.logf("\nFound %d results (top %d, threshold %.2f):\n", len(results), opts.TopK, opts.Threshold)
+		for i := range results {
+			client.logf("  %s\n", results[i].Node.Value)
+		}
+		client.logf("TIMING:EMBED:%.3f:LOAD:%.6f:SEARCH:%.6f\n",
+			embedDuration.Seconds()*1000,
+			loadDuration.Seconds()*1000,
+			searchDuration.Seconds()*1000)
+	}
+
+	return results, nil
+}
+
+// SearchRadius returns every memory within Euclidean distance radius of the
+// text's embedding, closest first, with no top-K cap
+func (client *Client) SearchRadius(text string, radius float32) ([]hippotypes.ScoredNode, error) {
+	return client.SearchRadiusMetric(text, radius, hippotypes.Euclidean)
+}
+
+// SearchRadiusMetric is SearchRadius measuring distance with metric
+func (client *Client) SearchRadiusMetric(text string, radius float32, metric hippotypes.Metric) ([]hippotypes.ScoredNode, error) {
+	ctx := context.Background()
+
+	embedStart := time.Now()
+	embeddingArray := hippotypes.GetKeyArray()
+	defer hippotypes.PutKeyArray(embeddingArray)
+	err := embedding.GetEmbeddingInto(ctx, client.embedder(), text, embeddingArray)
+	embedDuration := time.Since(embedStart)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrEmbedding, err)
 	}
 
+	loadStart := time.Now()
+	tree, err := client.getTree()
+	loadDuration := time.Since(loadStart)
+	if err != nil {
+		return nil, fmt.Errorf("tree loading error: %w", err)
+	}
+
+	searchStart := time.Now()
+	results := tree.SearchRadiusScored(*embeddingArray, radius, metric)
+	searchDuration := time.Since(searchStart)
+
 	if client.verbose {
-		fmt.Printf("\nFound %d results (top %d, threshold %.2f):\n", len(results), topK, threshold)
-		for _, value := range values {
-			fmt.Printf("  %s\n", value)
+		client.logf("\nFound %d results within %s radius %g:\n", len(results), metric, radius)
+		for i := range results {
+			client.logf("  %s\n", results[i].Node.Value)
 		}
-		fmt.Printf("TIMING:EMBED:%.3f:LOAD:%.6f:SEARCH:%.6f\n",
+		client.logf("TIMING:EMBED:%.3f:LOAD:%.6f:SEARCH:%.6f\n",
 			embedDuration.Seconds()*1000,
 			loadDuration.Seconds()*1000,
 			searchDuration.Seconds()*1000)
 	}
 
-	return values, nil
+	return results, nil
+}
+
+// FindNearest returns the single memory closest to the text's embedding, or
+// nil if there are none. It is exact, with no epsilon or threshold to miss
+// the match, and cheaper than SearchScored with a TopK of 1. Score is
+// 1/(1+Distance): 1 for an identical embedding, falling towards 0.
+func (client *Client) FindNearest(text string) (*hippotypes.ScoredNode, error) {
+	ctx := context.Background()
+
+	embedStart := time.Now()
+	embeddingArray := hippotypes.GetKeyArray()
+	defer hippotypes.PutKeyArray(embeddingArray)
+	err := embedding.GetEmbeddingInto(ctx, client.embedder(), text, embeddingArray)
+	embedDuration := time.Since(embedStart)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrEmbedding, err)
+	}
+
+	loadStart := time.Now()
+	tree, err := client.getTree()
+	loadDuration := time.Since(loadStart)
+	if err != nil {
+		return nil, fmt.Errorf("tree loading error: %w", err)
+	}
+
+	searchStart := time.Now()
+	node, distance := tree.NearestNeighbor(*embeddingArray, hippotypes.Euclidean)
+	searchDuration := time.Since(searchStart)
+
+	var result *hippotypes.ScoredNode
+	if node != nil {
+		result = &hippotypes.ScoredNode{Node: *node, Distance: distance, Score: 1 / (1 + distance)}
+	}
+
+	if client.verbose {
+		if result != nil {
+			client.logf("\nNearest memory (distance %g):\n  %s\n", distance, node.Value)
+		} else {
+			client.logf("\nNo memories\n")
+		}
+		client.logf("TIMING:EMBED:%.3f:LOAD:%.6f:SEARCH:%.6f\n",
+			embedDuration.Seconds()*1000,
+			loadDuration.Seconds()*1000,
+			searchDuration.Seconds()*1000)
+	}
+
+	return result, nil
+}
+
+// SearchByEmbedding is SearchScored for a query already embedded, e.g. by a
+// re-ranking pipeline that caches vectors; the embedder is not called
+func (client *Client) SearchByEmbedding(embedding []float32, epsilon float32, threshold float32, topK int) ([]hippotypes.ScoredNode, error) {
+	if len(embedding) != 512 {
+		return nil, fmt.Errorf("embedding has %d dimensions, expected 512", len(embedding))
+	}
+
+	tree, err := client.getTree()
+	if err != nil {
+		return nil, fmt.Errorf("tree loading error: %w", err)
+	}
+
+	return tree.SearchScored([512]float32(embedding), hippotypes.SearchOptions{
+		Epsilon:   epsilon,
+		Threshold: threshold,
+		TopK:      topK,
+	}), nil
+}
+
+// Defaults SearchSimilarTo searches with, the same as the servers' and CLI's
+const (
+	DefaultEpsilon   = 0.3
+	DefaultThreshold = 0.5
+)
+
+// SearchSimilarTo is SearchSimilarToWithOptions with DefaultEpsilon and
+// DefaultThreshold
+func (client *Client) SearchSimilarTo(existingKey string, topK int) ([]hippotypes.ScoredNode, error) {
+	return client.SearchSimilarToWithOptions(existingKey, DefaultEpsilon, DefaultThreshold, topK)
+}
+
+// SearchSimilarToWithOptions returns the topK memories closest to the one
+// stored under existingKey, closest first and leaving it out. Its stored
+// embedding is looked up by key and searched with as SearchByEmbedding
+// does, so the embedder is not called and scores compare with Search's.
+func (client *Client) SearchSimilarToWithOptions(existingKey string, epsilon, threshold float32, topK int) ([]hippotypes.ScoredNode, error) {
+	tree, err := client.getTree()
+	if err != nil {
+		return nil, fmt.Errorf("tree loading error: %w", err)
+	}
+	source, found := tree.GetID(existingKey)
+	if !found {
+		return nil, fmt.Errorf("%w %q", ErrKeyNotFound, existingKey)
+	}
+
+	// The source is its own closest hit, so ask for one more
+	results, err := client.SearchByEmbedding(source.Key[:], epsilon, threshold, topK+1)
+	if err != nil {
+		return nil, err
+	}
+	similar := results[:0]
+	for _, r := range results {
+		if r.Node.ID != existingKey {
+			similar = append(similar, r)
+		}
+	}
+	if len(similar) > topK {
+		similar = similar[:topK]
+	}
+	return similar, nil
+}
+
+// EstimateDimensionVariances embeds the sample texts and returns the standard
+// deviation of each dimension. Scaled by a constant, the result is suitable for
+// SearchOptions.DimensionEpsilons.
+func (client *Client) EstimateDimensionVariances(sampleTexts []string) ([512]float32, error) {
+	var stddevs [512]float32
+	if len(sampleTexts) < 2 {
+		return stddevs, fmt.Errorf("need at least 2 sample texts, got %d", len(sampleTexts))
+	}
+
+	ctx := context.Background()
+
+	// Welford's online algorithm keeps this numerically stable in one pass
+	var mean, m2 [512]float64
+	for n, text := range sampleTexts {
+		embeddingSlice, err := embedding.GetEmbedding(ctx, client.embedder(), text)
+		if err != nil {
+			return stddevs, fmt.Errorf("%w: %w", ErrEmbedding, err)
+		}
+
+		count := float64(n + 1)
+		for dim := 0; dim < 512 && dim < len(embeddingSlice); dim++ {
+			value := float64(embeddingSlice[dim])
+			delta := value - mean[dim]
+			mean[dim] += delta / count
+			m2[dim] += delta * (value - mean[dim])
+		}
+	}
+
+	for dim := 0; dim < 512; dim++ {
+		stddevs[dim] = float32(math.Sqrt(m2[dim] / float64(len(sampleTexts)-1)))
+	}
+
+	return stddevs, nil
 }
 
 func (client *Client) InsertCSV(csvFilename string) error {
+	_, err := client.InsertCSVStats(csvFilename)
+	return err
+}
+
+// CSVStats counts the outcome of a bulk CSV insert
+type CSVStats struct {
+	Inserted   int // Memories stored; with chunking, each chunk counts
+	Duplicates int // Rows skipped because the key already held the embedding
+}
+
+// InsertCSVStats is InsertCSV reporting what was inserted. On error the
+// stats cover the rows handled before it.
+func (client *Client) InsertCSVStats(csvFilename string) (CSVStats, error) {
+	return client.InsertCSVWithOptions(csvFilename, CSVOptions{})
+}
+
+// CSVOptions configures InsertCSVWithOptions
+type CSVOptions struct {
+	ResumeFrom int // Rows to skip because an earlier run already stored them
+	FlushEvery int // Flush after every this many rows; 0 flushes only at the end
+
+	// OnRow is called after each row with the number of rows handled so
+	// far, counting skipped ones, and OnFlush after each successful flush
+	// with the number of rows now stored
+	OnRow   func(rows int)
+	OnFlush func(rows int)
+}
+
+// InsertCSVWithOptions is InsertCSVStats with periodic flushes and resuming.
+// A row counted by OnFlush is on disk, so a rerun with that count as
+// ResumeFrom neither skips nor repeats rows.
+func (client *Client) InsertCSVWithOptions(csvFilename string, opts CSVOptions) (CSVStats, error) {
+	var stats CSVStats
+
 	file, err := os.Open(csvFilename)
 	if err != nil {
-		return fmt.Errorf("Error opening file: %v", err)
+		return stats, fmt.Errorf("Error opening file: %v", err)
 	}
 	defer file.Close()
 
 	reader := csv.NewReader(file)
 
-	for {
+	flush := func(rows int) error {
+		if err := client.Flush(); err != nil {
+			return err
+		}
+		if opts.OnFlush != nil {
+			opts.OnFlush(rows)
+		}
+		return nil
+	}
+
+	rows := 0
+	for ; ; rows++ {
+		if opts.OnRow != nil && rows > 0 {
+			opts.OnRow(rows)
+		}
+		if opts.FlushEvery > 0 && rows > opts.ResumeFrom && rows%opts.FlushEvery == 0 {
+			if err := flush(rows); err != nil {
+				return stats, err
+			}
+		}
+
 		record, err := reader.Read()
 		if err != nil {
-			if err == io.EOF{
+			if err == io.EOF {
 				break
 			}
-			return fmt.Errorf("Error in reading line: %v", err)
+			return stats, fmt.Errorf("Error in reading line: %v", err)
+		}
+		if rows < opts.ResumeFrom {
+			continue
 		}
 
-		if err := client.Insert(record[0], record[1]); err != nil {
-			return err
+		inserted := 1
+		if client.chunkSize > 0 {
+			inserted, err = client.InsertChunked(record[0], record[1], client.chunkSize, client.chunkOverlap)
+		} else {
+			err = client.Insert(record[0], record[1])
+		}
+		if err != nil {
+			// Repeated rows in a bulk import are skipped, not fatal
+			if errors.Is(err, hippotypes.ErrDuplicateKey) {
+				stats.Duplicates++
+				continue
+			}
+			return stats, err
 		}
+		stats.Inserted += inserted
 	}
 
 	// Flush after bulk insert
-	return client.Flush()
+	return stats, flush(rows)
+}
+
+// Count returns the number of stored memories
+func (client *Client) Count() (int, error) {
+	tree, err := client.getTree()
+	if err != nil {
+		return 0, err
+	}
+	return tree.Len(), nil
+}
+
+// Keys returns the keys of every stored memory, in insertion order
+func (client *Client) Keys() ([]string, error) {
+	tree, err := client.getTree()
+	if err != nil {
+		return nil, fmt.Errorf("tree loading error: %w", err)
+	}
+	return tree.IDs(), nil
+}
+
+// nodeIterator is implemented by storages that can visit their nodes
+// without loading them into one tree (see storage.ShardedFileStorage.Each)
+type nodeIterator interface {
+	Each(fn func(n *hippotypes.Node) error) error
+}
+
+// Iterate calls fn for every stored memory, stopping at and returning the
+// first error fn returns. Nothing is collected into a slice, and before the
+// tree is loaded a sharded storage is read one shard at a time. embedding is
+// only valid during the call; copy it to keep it. fn must not write to the
+// client.
+func (client *Client) Iterate(fn func(id, value string, embedding []float32) error) error {
+	visit := func(n *hippotypes.Node) error { return fn(n.ID, n.Value, n.Key[:]) }
+
+	client.cacheMu.Lock()
+	tree := client.cachedTree
+	client.cacheMu.Unlock()
+
+	if tree == nil {
+		if it, ok := client.CurrentStorage().(nodeIterator); ok {
+			return it.Each(visit)
+		}
+		var err error
+		if tree, err = client.getTree(); err != nil {
+			return fmt.Errorf("tree loading error: %w", err)
+		}
+	}
+	return tree.Each(visit)
+}
+
+// Get returns the memory stored under key
+func (client *Client) Get(key string) (hippotypes.Node, bool, error) {
+	tree, err := client.getTree()
+	if err != nil {
+		return hippotypes.Node{}, false, fmt.Errorf("tree loading error: %w", err)
+	}
+
+	node, ok := tree.GetID(key)
+	return node, ok, nil
+}
+
+// Exists reports whether a memory is stored under key. Unlike Get it
+// copies nothing, and the scan stops at the first match.
+func (client *Client) Exists(key string) (bool, error) {
+	tree, err := client.getTree()
+	if err != nil {
+		return false, fmt.Errorf("tree loading error: %w", err)
+	}
+	return tree.HasID(key), nil
+}
+
+// Delete removes the memory stored under key, reporting whether it existed
+func (client *Client) Delete(key string) (bool, error) {
+	tree, err := client.getTree()
+	if err != nil {
+		return false, fmt.Errorf("tree loading error: %w", err)
+	}
+	if tree.FindID(key) < 0 {
+		return false, nil
+	}
+	if err := client.checkDelete(key); err != nil {
+		return false, err
+	}
+
+	if !tree.RemoveID(key) {
+		return false, nil
+	}
+	client.markDirty()
+	client.emitChange(OpDelete, key, "")
+	return true, nil
+}
+
+// DeleteMany removes the memories stored under keys in one pass over the
+// tree, returning how many existed. It is much faster than calling Delete
+// for each of hundreds of keys.
+func (client *Client) DeleteMany(keys []string) (int, error) {
+	tree, err := client.getTree()
+	if err != nil {
+		return 0, fmt.Errorf("tree loading error: %w", err)
+	}
+
+	for _, key := range keys {
+		if tree.FindID(key) < 0 {
+			continue
+		}
+		if err := client.checkDelete(key); err != nil {
+			return 0, err
+		}
+	}
+
+	deleted := tree.RemoveIDs(keys)
+	if len(deleted) > 0 {
+		client.markDirty()
+	}
+	for _, key := range deleted {
+		client.emitChange(OpDelete, key, "")
+	}
+	return len(deleted), nil
+}
+
+// Update replaces the text stored under key, re-embedding it, and reports
+// whether key existed. Nothing is inserted for a missing key.
+func (client *Client) Update(key, text string) (bool, error) {
+	if err := client.checkDelete(key); err != nil {
+		return false, err
+	}
+
+	var embeddingArray [512]float32
+	if err := embedding.GetEmbeddingInto(context.Background(), client.embedder(), text, &embeddingArray); err != nil {
+		return false, fmt.Errorf("%w: %w", ErrEmbedding, err)
+	}
+
+	tree, err := client.getTree()
+	if err != nil {
+		return false, fmt.Errorf("tree loading error: %w", err)
+	}
+
+	found, err := tree.ReplaceID(key, embeddingArray, text)
+	if err != nil {
+		return found, fmt.Errorf("update error for %s: %w", key, err)
+	}
+	if found {
+		client.markDirty()
+		client.emitChange(OpUpdate, key, text)
+	}
+	return found, nil
+}
+
+// Clear removes every memory. The empty tree reaches storage on the next Flush.
+func (client *Client) Clear() {
+	client.cacheMu.Lock()
+	client.cachedTree = hippotypes.NewTree()
+	client.dirty = true
+	client.modified = time.Now()
+	client.cacheMu.Unlock()
+	client.emitChange(OpReset, "", "")
+}
+
+// Snapshot writes the current in-memory tree to w in the storage binary format,
+// independent of the configured storage backend
+func (client *Client) Snapshot(w io.Writer) error {
+	tree, err := client.getTree()
+	if err != nil {
+		return fmt.Errorf("tree loading error: %w", err)
+	}
+
+	return storage.WriteTree(w, tree.DeepCopy())
+}
+
+// Restore replaces the in-memory tree with one read from r. The restored tree
+// reaches the storage backend on the next Flush.
+func (client *Client) Restore(r io.Reader) error {
+	if err := client.checkDelete(""); err != nil {
+		return err
+	}
+
+	tree, err := storage.ReadTree(r)
+	if err != nil {
+		return fmt.Errorf("restore error: %w", err)
+	}
+
+	tree.RebuildIndex()
+
+	client.cacheMu.Lock()
+	client.cachedTree = tree
+	client.dirty = true
+	client.modified = time.Now()
+	client.cacheMu.Unlock()
+	client.emitChange(OpReset, "", "")
+	return nil
+}
+
+// markDirty records that the cached tree has changes not yet in storage
+func (client *Client) markDirty() {
+	client.cacheMu.Lock()
+	client.dirty = true
+	client.modified = time.Now()
+	client.cacheMu.Unlock()
+}
+
+// LastModified returns when the memories last changed through this client,
+// or the zero time if they haven't
+func (client *Client) LastModified() time.Time {
+	client.cacheMu.Lock()
+	defer client.cacheMu.Unlock()
+	return client.modified
+}
+
+// MemoryUsage estimates the bytes held by the loaded tree
+func (client *Client) MemoryUsage() (int64, error) {
+	tree, err := client.getTree()
+	if err != nil {
+		return 0, err
+	}
+	return tree.MemoryUsage(), nil
+}
+
+// WarmUp loads the tree, builds its search index and runs one search against
+// a stored embedding, so the first real query doesn't pay for the load or
+// the index build. The embedder is not called.
+func (client *Client) WarmUp(ctx context.Context) error {
+	tree, err := client.getTree()
+	if err != nil {
+		return fmt.Errorf("tree loading error: %w", err)
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	tree.EnsureIndexed()
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if node, ok := tree.First(); ok {
+		tree.SearchScored(node.Key, hippotypes.SearchOptions{Epsilon: 0.3, Threshold: 0.5, TopK: 1})
+	}
+	return nil
+}
+
+// Stats summarizes the loaded tree
+type Stats struct {
+	Nodes        int
+	Duplicates   int // Inserts rejected as duplicates since the tree was loaded
+	IndexEntries int
+	MemoryBytes  int64
+	LastModified time.Time // Zero until the tree is changed through this client
+}
+
+// Stats loads the tree if needed and reports its size
+func (client *Client) Stats() (Stats, error) {
+	tree, err := client.getTree()
+	if err != nil {
+		return Stats{}, fmt.Errorf("tree loading error: %w", err)
+	}
+	// Report the tree as it is once searchable
+	tree.EnsureIndexed()
+
+	return Stats{
+		Nodes:        tree.Len(),
+		Duplicates:   tree.DuplicateCount(),
+		IndexEntries: tree.IndexEntries(),
+		MemoryBytes:  tree.MemoryUsage(),
+		LastModified: client.LastModified(),
+	}, nil
+}
+
+// SetLogOutput sends verbose output (results, TIMING lines) to w instead of
+// stdout, e.g. stderr when stdout carries machine-readable output
+func (client *Client) SetLogOutput(w io.Writer) {
+	client.logOut = w
+}
+
+func (client *Client) logf(format string, args ...any) {
+	w := client.logOut
+	if w == nil {
+		w = os.Stdout
+	}
+	fmt.Fprintf(w, format, args...)
 }
 
 // SetVerbose controls logging output
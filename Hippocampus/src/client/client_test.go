@@ -0,0 +1,320 @@
+package client
+
+import (
+	"Hippocampus/src/embedding"
+	"Hippocampus/src/storage"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+// inserters is how many goroutines the concurrent insert test and benchmark
+// run, one per core on a typical server
+const inserters = 8
+
+func newTestClient(t testing.TB) *Client {
+	t.Helper()
+	c, err := New(embedding.NewMockEmbedder())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Verbose, so the node count in the log line is read too
+	c.SetLogOutput(io.Discard)
+	return c
+}
+
+// Run with -race: Insert must count the tree's nodes under its lock while
+// other inserters append to it
+func TestConcurrentInserts(t *testing.T) {
+	c := newTestClient(t)
+
+	const perInserter = 150
+	var wg sync.WaitGroup
+	for w := 0; w < inserters; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < perInserter; i++ {
+				key := fmt.Sprintf("w%d-%d", w, i)
+				if err := c.Insert(key, "memory "+key); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	stats, err := c.Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Nodes != inserters*perInserter {
+		t.Fatalf("tree holds %d nodes, want %d", stats.Nodes, inserters*perInserter)
+	}
+}
+
+// benchmarkInsertParallel times inserters goroutines inserting into a tree
+// with a built index, so each insert patches the index in place. With
+// serialize set, each tree insert holds it, as every insert did before the
+// index locks were sharded. Embeddings are computed outside it either way.
+func benchmarkInsertParallel(b *testing.B, serialize sync.Locker) {
+	c := newTestClient(b)
+	c.SetVerbose(false)
+	for i := 0; i < 1000; i++ {
+		key := fmt.Sprintf("seed%d", i)
+		if err := c.Insert(key, "memory "+key); err != nil {
+			b.Fatal(err)
+		}
+	}
+	tree, err := c.getTree()
+	if err != nil {
+		b.Fatal(err)
+	}
+	tree.RebuildIndex()
+
+	var next atomic.Int64
+	var wg sync.WaitGroup
+	b.ResetTimer()
+	for w := 0; w < inserters; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				i := next.Add(1) - 1
+				if i >= int64(b.N) {
+					return
+				}
+				key := fmt.Sprintf("key%d", i)
+				e, err := c.embedder().GetEmbedding(context.Background(), "memory "+key)
+				if err != nil {
+					b.Error(err)
+					return
+				}
+				serialize.Lock()
+				err = c.InsertRaw(key, "memory "+key, e)
+				serialize.Unlock()
+				if err != nil {
+					b.Error(err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	b.StopTimer()
+
+	if entries := tree.IndexEntries(); entries != 512*(1000+b.N) {
+		b.Fatalf("index holds %d entries, want %d: inserts didn't patch it", entries, 512*(1000+b.N))
+	}
+}
+
+// noLock serializes nothing
+type noLock struct{}
+
+func (noLock) Lock()   {}
+func (noLock) Unlock() {}
+
+func BenchmarkInsertParallel8(b *testing.B) {
+	benchmarkInsertParallel(b, noLock{})
+}
+
+// BenchmarkInsertParallel8GlobalMutex is the baseline for
+// BenchmarkInsertParallel8: the same inserts queueing on one mutex
+func BenchmarkInsertParallel8GlobalMutex(b *testing.B) {
+	benchmarkInsertParallel(b, &sync.Mutex{})
+}
+
+// at returns an embedding with value v in dimension 0 and the rest zero
+func at(v float32) []float32 {
+	e := make([]float32, 512)
+	e[0] = v
+	return e
+}
+
+func TestSearchSimilarTo(t *testing.T) {
+	embedder := embedding.NewMockEmbedder()
+	c, err := New(embedder)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.SetVerbose(false)
+	for key, v := range map[string]float32{"source": 0, "near": 0.01, "mid": 0.02, "far": 0.5} {
+		embedder.SetResponse(key, at(v))
+		if err := c.Insert(key, key); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	results, err := c.SearchSimilarTo("source", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 2 || results[0].Node.ID != "near" || results[1].Node.ID != "mid" {
+		t.Fatalf("SearchSimilarTo = %v, want near and mid", results)
+	}
+
+	// Scores are those of a search from the same embedding
+	direct, err := c.SearchByEmbedding(at(0), DefaultEpsilon, DefaultThreshold, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(direct) != 3 || direct[1].Score != results[0].Score || direct[2].Score != results[1].Score {
+		t.Fatalf("SearchByEmbedding = %v, scores differ from %v", direct, results)
+	}
+
+	if _, err := c.SearchSimilarTo("missing", 2); !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("err = %v for a missing key, want ErrKeyNotFound", err)
+	}
+}
+
+// nodesIn loads the tree file at path and returns how many nodes it holds
+func nodesIn(t *testing.T, path string) int {
+	t.Helper()
+	tree, err := storage.NewFileStorage(path).Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return len(tree.Nodes)
+}
+
+func TestRotate(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "tree.bin")
+	archiveDir := filepath.Join(dir, "archives")
+
+	c, err := NewWithFileStorage(path, embedding.NewMockEmbedder())
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.SetVerbose(false)
+	insert := func(from, to int) {
+		t.Helper()
+		for i := from; i < to; i++ {
+			key := fmt.Sprintf("key%d", i)
+			if err := c.Insert(key, "memory "+key); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	insert(0, 10)
+	if err := c.Rotate(archiveDir); err != nil {
+		t.Fatal(err)
+	}
+	insert(10, 15)
+	if err := c.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	if n := nodesIn(t, path); n != 5 {
+		t.Fatalf("main file holds %d nodes after the rotation, want 5", n)
+	}
+	archives, err := filepath.Glob(filepath.Join(archiveDir, "tree_*.bin"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(archives) != 1 {
+		t.Fatalf("archives %q, want one", archives)
+	}
+	if n := nodesIn(t, archives[0]); n != 10 {
+		t.Fatalf("archive holds %d nodes, want 10", n)
+	}
+	if _, found, err := c.Get("key3"); err != nil || found {
+		t.Fatalf("Get(key3) after the rotation = %t, %v, want it archived", found, err)
+	}
+}
+
+func TestIterateCount(t *testing.T) {
+	dir := t.TempDir()
+	sharded := func() *Client {
+		t.Helper()
+		c, err := NewWithStorage(storage.NewShardedFileStorage(dir, 4), embedding.NewMockEmbedder())
+		if err != nil {
+			t.Fatal(err)
+		}
+		c.SetVerbose(false)
+		return c
+	}
+
+	c := sharded()
+	for i := 0; i < 50; i++ {
+		key := fmt.Sprintf("key%d", i)
+		if err := c.Insert(key, "memory "+key); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := c.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	// A new client reads the shards one at a time; c has its tree loaded
+	for _, tt := range []struct {
+		name string
+		c    *Client
+	}{{"unloaded", sharded()}, {"loaded", c}} {
+		t.Run(tt.name, func(t *testing.T) {
+			seen := make(map[string]bool)
+			err := tt.c.Iterate(func(id, value string, embedding []float32) error {
+				if value != "memory "+id || len(embedding) != 512 {
+					return fmt.Errorf("node %q: value %q, %d dimensions", id, value, len(embedding))
+				}
+				seen[id] = true
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			count, err := tt.c.Count()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(seen) != count || count != 50 {
+				t.Fatalf("Iterate visited %d memories, Count = %d, want 50", len(seen), count)
+			}
+		})
+	}
+
+	stop := errors.New("stop")
+	visited := 0
+	err := sharded().Iterate(func(id, value string, embedding []float32) error {
+		if visited++; visited == 3 {
+			return stop
+		}
+		return nil
+	})
+	if err != stop || visited != 3 {
+		t.Fatalf("Iterate = %v after %d calls, want it to stop at the third", err, visited)
+	}
+}
+
+// sliceEmbedder returns embeddings of dims dimensions through GetEmbedding
+// alone, as external providers do
+type sliceEmbedder struct {
+	dims int
+}
+
+func (e sliceEmbedder) GetEmbedding(ctx context.Context, text string) ([]float32, error) {
+	return make([]float32, e.dims), nil
+}
+
+func TestEmbeddingDimensionsChecked(t *testing.T) {
+	for _, dims := range []int{384, 1536} {
+		c, err := New(sliceEmbedder{dims})
+		if err != nil {
+			t.Fatal(err)
+		}
+		c.SetVerbose(false)
+		if err := c.Insert("k", "text"); !errors.Is(err, ErrDimensionMismatch) {
+			t.Errorf("Insert with %d dimensions = %v, want ErrDimensionMismatch", dims, err)
+		}
+		if _, err := c.Search("text", DefaultEpsilon, DefaultThreshold, 5); !errors.Is(err, ErrDimensionMismatch) {
+			t.Errorf("Search with %d dimensions = %v, want ErrDimensionMismatch", dims, err)
+		}
+	}
+}
@@ -0,0 +1,158 @@
+package client
+
+import (
+	"Hippocampus/src/storage"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// CSVCheckOptions configures CheckCSV
+type CSVCheckOptions struct {
+	ResumeFrom   int // Rows to skip, as in CSVOptions
+	MaxTextBytes int // Unchunked texts longer than this are problems; 0 disables the check
+	MaxProblems  int // Problems listed in the result; the rest are only counted
+}
+
+// CSVProblem is a row that would not import cleanly
+type CSVProblem struct {
+	Line   int    `json:"line"` // Line the row starts on, from 1
+	Key    string `json:"key,omitempty"`
+	Reason string `json:"reason"`
+}
+
+// CSVCheck is what CheckCSV found in a CSV file
+type CSVCheck struct {
+	Rows           int          `json:"rows"`            // Rows checked, after ResumeFrom
+	Problems       int          `json:"problems"`        // Rows with at least one problem
+	FirstProblems  []CSVProblem `json:"first_problems"`  // Up to MaxProblems of them, in file order
+	EmbeddingCalls int          `json:"embedding_calls"` // Calls the import would make; chunked rows need one per chunk
+	AddedBytes     int64        `json:"added_bytes"`     // Bytes the new nodes would add to the database file
+}
+
+// Clean reports whether the file would import without problems
+func (c CSVCheck) Clean() bool {
+	return c.Problems == 0
+}
+
+// CheckCSV reads a CSV file the way InsertCSVWithOptions would, without
+// embedding or storing anything. It reports malformed rows, rows with too
+// few or inconsistent columns, empty keys and texts, texts over
+// MaxTextBytes, and keys repeated in the file or already stored, along with
+// the embedding calls and file growth an import would cost. Embeddings that
+// duplicate stored ones can't be known without embedding, so they count as
+// new.
+func (client *Client) CheckCSV(csvFilename string, opts CSVCheckOptions) (CSVCheck, error) {
+	var check CSVCheck
+
+	file, err := os.Open(csvFilename)
+	if err != nil {
+		return check, fmt.Errorf("Error opening file: %v", err)
+	}
+	defer file.Close()
+
+	// Stored keys, by logical key so "key" clashes with "key:chunk:N"
+	keys, err := client.Keys()
+	if err != nil {
+		return check, fmt.Errorf("tree loading error: %w", err)
+	}
+	stored := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		baseKey, _ := splitChunkKey(key)
+		stored[baseKey] = true
+	}
+	seen := make(map[string]int) // Key to the line it first appeared on
+
+	problem := func(line int, key, reason string) {
+		if len(check.FirstProblems) < opts.MaxProblems {
+			check.FirstProblems = append(check.FirstProblems, CSVProblem{Line: line, Key: key, Reason: reason})
+		}
+	}
+
+	// Column counts are checked here rather than by the reader, so a bad
+	// row is reported and the check goes on
+	reader := csv.NewReader(file)
+	reader.FieldsPerRecord = -1
+	fields := 0
+
+	for rows := 0; ; rows++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			var parseErr *csv.ParseError
+			if !errors.As(err, &parseErr) {
+				return check, fmt.Errorf("Error in reading line: %v", err)
+			}
+			if rows >= opts.ResumeFrom {
+				check.Rows++
+				check.Problems++
+				problem(parseErr.StartLine, "", parseErr.Err.Error())
+			}
+			continue
+		}
+
+		// The import takes its column count from the first row
+		if rows == 0 {
+			fields = len(record)
+		}
+		if rows < opts.ResumeFrom {
+			continue
+		}
+		check.Rows++
+
+		line, _ := reader.FieldPos(0)
+		var reasons []string
+		if len(record) < 2 {
+			reasons = append(reasons, fmt.Sprintf("%d columns, need key and text", len(record)))
+		} else if len(record) != fields {
+			reasons = append(reasons, fmt.Sprintf("%d columns, the first row has %d", len(record), fields))
+		}
+
+		key, text := record[0], ""
+		if len(record) > 1 {
+			text = record[1]
+		}
+		if strings.TrimSpace(key) == "" {
+			reasons = append(reasons, "empty key")
+		} else if first, ok := seen[key]; ok {
+			reasons = append(reasons, "key repeats line "+strconv.Itoa(first))
+		} else {
+			seen[key] = line
+			if stored[key] {
+				reasons = append(reasons, "key already stored")
+			}
+		}
+		if len(record) > 1 && strings.TrimSpace(text) == "" {
+			reasons = append(reasons, "empty text")
+		}
+
+		chunked := client.chunkSize > 0 && len(text) > client.chunkSize
+		if opts.MaxTextBytes > 0 && !chunked && len(text) > opts.MaxTextBytes {
+			reasons = append(reasons, fmt.Sprintf("text is %d bytes, over %d", len(text), opts.MaxTextBytes))
+		}
+
+		if len(reasons) > 0 {
+			check.Problems++
+			problem(line, key, strings.Join(reasons, "; "))
+			continue
+		}
+
+		if !chunked {
+			check.EmbeddingCalls++
+			check.AddedBytes += storage.EncodedNodeSize(key, text, "")
+			continue
+		}
+		for i, chunk := range chunkText(text, client.chunkSize, client.chunkOverlap) {
+			check.EmbeddingCalls++
+			check.AddedBytes += storage.EncodedNodeSize(key+chunkKeySep+strconv.Itoa(i), chunk, "")
+		}
+	}
+
+	return check, nil
+}
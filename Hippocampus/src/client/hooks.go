@@ -0,0 +1,68 @@
+package client
+
+import (
+	"sync"
+	"time"
+)
+
+// ChangeOp is the kind of change a ChangeEvent reports
+type ChangeOp string
+
+const (
+	OpInsert ChangeOp = "insert"
+	OpUpdate ChangeOp = "update" // Update, or Insert or Merge replacing a memory
+	OpDelete ChangeOp = "delete"
+	OpReset  ChangeOp = "reset" // Clear or Restore replaced every memory
+)
+
+// ChangeEvent describes a change to the memories made through the client,
+// for OnChange hooks
+type ChangeEvent struct {
+	Op    ChangeOp  `json:"op"`
+	Key   string    `json:"key,omitempty"`
+	Value string    `json:"text,omitempty"` // New text of inserts and updates
+	Time  time.Time `json:"ts"`
+}
+
+// changeHooks holds the registered OnChange hooks
+type changeHooks struct {
+	mu    sync.RWMutex
+	next  int
+	hooks map[int]func(ChangeEvent)
+}
+
+// OnChange registers a hook called after every change made through the
+// client, returning a function that removes it. Hooks run on the goroutine
+// making the change, so they should hand events off rather than block.
+func (client *Client) OnChange(hook func(ChangeEvent)) (remove func()) {
+	h := &client.changeHooks
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.hooks == nil {
+		h.hooks = make(map[int]func(ChangeEvent))
+	}
+	id := h.next
+	h.next++
+	h.hooks[id] = hook
+
+	return func() {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		delete(h.hooks, id)
+	}
+}
+
+// emitChange passes a change to the hooks
+func (client *Client) emitChange(op ChangeOp, key, value string) {
+	h := &client.changeHooks
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	if len(h.hooks) == 0 {
+		return
+	}
+
+	event := ChangeEvent{Op: op, Key: key, Value: value, Time: time.Now()}
+	for _, hook := range h.hooks {
+		hook(event)
+	}
+}
@@ -0,0 +1,116 @@
+package client
+
+import (
+	hippotypes "Hippocampus/src/types"
+	"errors"
+	"fmt"
+)
+
+// MergePolicy decides what Merge does with a key stored in both clients
+type MergePolicy int
+
+const (
+	KeepSelf           MergePolicy = iota // Keep this client's memory
+	KeepOther                             // Replace it with the other client's
+	KeepBothWithSuffix                    // Add the other's under "key~N"
+)
+
+func (p MergePolicy) String() string {
+	switch p {
+	case KeepSelf:
+		return "keep-self"
+	case KeepOther:
+		return "keep-other"
+	case KeepBothWithSuffix:
+		return "keep-both"
+	}
+	return fmt.Sprintf("MergePolicy(%d)", int(p))
+}
+
+// SetMergePolicy sets how Merge resolves keys stored in both clients. The
+// default is KeepSelf.
+func (client *Client) SetMergePolicy(policy MergePolicy) {
+	client.mergePolicy = policy
+}
+
+// Merge copies the memories of other into this client, matching them by key.
+// Keys only other has are inserted; keys both have follow the merge policy.
+// Memories whose embedding is already stored under another key are skipped,
+// as Insert would. Embeddings are copied, so no embedder is needed. Returns
+// the number of memories added; with KeepOther, replacements aren't counted.
+func (client *Client) Merge(other *Client) (nodesAdded int, err error) {
+	if other == client {
+		return 0, nil
+	}
+
+	tree, err := client.getTree()
+	if err != nil {
+		return 0, fmt.Errorf("tree loading error: %w", err)
+	}
+	otherTree, err := other.getTree()
+	if err != nil {
+		return 0, fmt.Errorf("tree loading error: %w", err)
+	}
+
+	// Looking keys up in the tree scans it, so keep a set of them
+	ids := make(map[string]bool)
+	for _, id := range tree.IDs() {
+		ids[id] = true
+	}
+
+	changed := false
+	for _, node := range otherTree.DeepCopy().Nodes {
+		if ids[node.ID] && node.ID != "" {
+			existing, _ := tree.GetID(node.ID)
+			if existing.Key == node.Key && existing.Value == node.Value {
+				continue
+			}
+
+			switch client.mergePolicy {
+			case KeepSelf:
+				continue
+			case KeepOther:
+				if err := client.checkDelete(node.ID); err != nil {
+					return nodesAdded, err
+				}
+				if _, err := tree.ReplaceNode(node); err != nil {
+					if errors.Is(err, hippotypes.ErrDuplicateKey) {
+						continue
+					}
+					return nodesAdded, err
+				}
+				changed = true
+				client.emitChange(OpUpdate, node.ID, node.Value)
+				continue
+			case KeepBothWithSuffix:
+				node.ID = freeSuffixedID(ids, node.ID)
+			}
+		}
+
+		if err := tree.InsertNode(node); err != nil {
+			if errors.Is(err, hippotypes.ErrDuplicateKey) {
+				continue
+			}
+			return nodesAdded, err
+		}
+		ids[node.ID] = true
+		nodesAdded++
+		changed = true
+		client.emitChange(OpInsert, node.ID, node.Value)
+	}
+
+	if changed {
+		client.markDirty()
+	}
+	return nodesAdded, nil
+}
+
+// freeSuffixedID returns the first of "id~2", "id~3", ... not in ids
+func freeSuffixedID(ids map[string]bool, id string) string {
+	for n := 2; ; n++ {
+		candidate := fmt.Sprintf("%s~%d", id, n)
+		if !ids[candidate] {
+			return candidate
+		}
+	}
+}
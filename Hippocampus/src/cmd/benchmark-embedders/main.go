@@ -0,0 +1,454 @@
+package main
+
+import (
+	"Hippocampus/src/embedding"
+	"Hippocampus/src/vectorfile"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// hippocampus-benchmark-embedders compares embedding services on the same
+// corpus, to help choose between them:
+//
+//	hippocampus-benchmark-embedders -corpus memories.csv -providers mock,local,openai
+//
+// Every text is embedded by each provider, timing each call. Embeddings from
+// different models live in different spaces, so the cosine similarity of two
+// providers' embeddings of a text is only a rough proxy for agreement; the
+// neighbor overlap on the held-out queries says more about whether searches
+// would return the same memories.
+func main() {
+	corpus := flag.String("corpus", "", "CSV of key,text rows (or one text per row) to embed")
+	providers := flag.String("providers", "mock,local", "comma-separated embedders to compare: mock, local, openai, ollama, bedrock")
+	queries := flag.Int("queries", 20, "rows from the end of the corpus held out as queries for the recall comparison")
+	topK := flag.Int("top-k", 10, "neighbors compared per query")
+	workers := flag.Int("workers", 1, "calls in flight per provider (1 measures unloaded latency)")
+	timeout := flag.Duration("timeout", 30*time.Second, "timeout of each embedding call")
+	saveDir := flag.String("save-dir", "", "write each provider's embeddings as <provider>.npy, with corpus.jsonl, to this directory")
+	asJSON := flag.Bool("json", false, "print the report as JSON")
+	var urls, models providerValues
+	flag.Var(&urls, "url", "provider=URL, in place of the provider's default service URL (repeatable)")
+	flag.Var(&models, "model", "provider=model, in place of the provider's default model (repeatable)")
+	apiKeyEnv := flag.String("api-key-env", "", "environment variable holding the OpenAI API key (default OPENAI_API_KEY)")
+	flag.Parse()
+
+	if *corpus == "" {
+		log.Fatal("-corpus is required")
+	}
+	if *queries < 0 || *topK < 1 || *workers < 1 {
+		log.Fatal("-queries must be non-negative and -top-k and -workers positive")
+	}
+	names := splitProviders(*providers)
+	if len(names) == 0 {
+		log.Fatal("-providers names no embedder")
+	}
+
+	texts, err := readCorpus(*corpus)
+	if err != nil {
+		log.Fatalf("Failed to read %s: %v", *corpus, err)
+	}
+	if len(texts) == 0 {
+		log.Fatalf("%s holds no texts", *corpus)
+	}
+	if *queries >= len(texts) {
+		log.Fatalf("-queries %d leaves no corpus to search among %d texts", *queries, len(texts))
+	}
+
+	var runs []*providerRun
+	for _, name := range names {
+		f := embedding.Flags{Embedder: name, URL: urls[name], Model: models[name], APIKeyEnv: *apiKeyEnv}
+		embedder, err := f.New()
+		if err != nil {
+			log.Fatalf("%s: %v", name, err)
+		}
+		log.Printf("Embedding %d texts with %s", len(texts), &f)
+		runs = append(runs, embedAll(name, embedder, texts, *workers, *timeout))
+	}
+
+	report := compare(runs, len(texts), *queries, *topK)
+	if *saveDir != "" {
+		if err := save(*saveDir, runs, texts); err != nil {
+			log.Fatalf("Failed to save embeddings: %v", err)
+		}
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(report)
+		return
+	}
+	printReport(os.Stdout, report)
+}
+
+// providerValues collects provider=value flags
+type providerValues map[string]string
+
+func (p *providerValues) String() string {
+	var parts []string
+	for k, v := range *p {
+		parts = append(parts, k+"="+v)
+	}
+	slices.Sort(parts)
+	return strings.Join(parts, ",")
+}
+
+func (p *providerValues) Set(s string) error {
+	name, value, ok := strings.Cut(s, "=")
+	if !ok || name == "" {
+		return fmt.Errorf("expected provider=value, got %q", s)
+	}
+	if *p == nil {
+		*p = make(providerValues)
+	}
+	(*p)[name] = value
+	return nil
+}
+
+func splitProviders(s string) []string {
+	var names []string
+	for _, name := range strings.Split(s, ",") {
+		if name = strings.TrimSpace(name); name != "" && !slices.Contains(names, name) {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// readCorpus reads the texts of a CSV: the second column of key,text rows,
+// or the only column
+func readCorpus(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	r := csv.NewReader(f)
+	r.FieldsPerRecord = -1
+	var texts []string
+	for {
+		row, err := r.Read()
+		if errors.Is(err, io.EOF) {
+			return texts, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		text := row[len(row)-1]
+		if len(row) > 1 {
+			text = row[1]
+		}
+		if text = strings.TrimSpace(text); text != "" {
+			texts = append(texts, text)
+		}
+	}
+}
+
+// providerRun is one provider's embeddings of the corpus, nil where a call
+// failed, and how long each call took
+type providerRun struct {
+	name       string
+	embeddings [][]float32
+	latencies  []time.Duration
+	errors     int
+	firstErr   error
+	elapsed    time.Duration
+}
+
+// embedAll embeds every text with workers calls in flight
+func embedAll(name string, embedder embedding.EmbeddingService, texts []string, workers int, timeout time.Duration) *providerRun {
+	run := &providerRun{name: name, embeddings: make([][]float32, len(texts))}
+	latencies := make([]time.Duration, len(texts))
+	errs := make([]error, len(texts))
+
+	start := time.Now()
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				ctx, cancel := context.WithTimeout(context.Background(), timeout)
+				callStart := time.Now()
+				emb, err := embedder.GetEmbedding(ctx, texts[i])
+				latencies[i] = time.Since(callStart)
+				cancel()
+				if err == nil && len(emb) != 512 {
+					err = fmt.Errorf("%w: expected 512 dimensions, got %d", embedding.ErrDimensionMismatch, len(emb))
+				}
+				if err != nil {
+					errs[i] = err
+					continue
+				}
+				run.embeddings[i] = emb
+			}
+		}()
+	}
+	for i := range texts {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+	run.elapsed = time.Since(start)
+
+	for i, err := range errs {
+		if err != nil {
+			run.errors++
+			if run.firstErr == nil {
+				run.firstErr = fmt.Errorf("text %d: %w", i, err)
+			}
+			continue
+		}
+		run.latencies = append(run.latencies, latencies[i])
+	}
+	if run.firstErr != nil {
+		log.Printf("%s: %d of %d calls failed, first: %v", name, run.errors, len(texts), run.firstErr)
+	}
+	return run
+}
+
+// latencyStats summarizes a provider's successful calls
+type latencyStats struct {
+	Provider  string  `json:"provider"`
+	Calls     int     `json:"calls"`
+	Errors    int     `json:"errors"`
+	P50ms     float64 `json:"p50_ms"`
+	P95ms     float64 `json:"p95_ms"`
+	P99ms     float64 `json:"p99_ms"`
+	Maxms     float64 `json:"max_ms"`
+	TextsPerS float64 `json:"texts_per_sec"`
+}
+
+// pairStats compares two providers on the texts both embedded
+type pairStats struct {
+	A             string  `json:"a"`
+	B             string  `json:"b"`
+	Texts         int     `json:"texts"`
+	MeanCosine    float64 `json:"mean_cosine"`
+	Queries       int     `json:"queries"`
+	RecallAtK     float64 `json:"recall_at_k"`
+	Top1Agreement float64 `json:"top1_agreement"`
+}
+
+type benchmarkReport struct {
+	Texts     int            `json:"texts"`
+	Queries   int            `json:"queries"`
+	TopK      int            `json:"top_k"`
+	Latencies []latencyStats `json:"latencies"`
+	Pairs     []pairStats    `json:"pairs,omitempty"`
+}
+
+// compare computes the latency of each provider and, for each pair, the
+// mean cosine similarity of their embeddings of the same text and how far
+// their nearest neighbors agree for the held-out queries. Texts a provider
+// failed to embed are left out of its comparisons.
+func compare(runs []*providerRun, texts, queries, topK int) benchmarkReport {
+	report := benchmarkReport{Texts: texts, Queries: queries, TopK: topK}
+	for _, run := range runs {
+		report.Latencies = append(report.Latencies, latencies(run))
+	}
+
+	docs := texts - queries
+	for a := 0; a < len(runs); a++ {
+		for b := a + 1; b < len(runs); b++ {
+			ra, rb := runs[a], runs[b]
+			p := pairStats{A: ra.name, B: rb.name}
+
+			var sum float64
+			for i := range ra.embeddings {
+				if ra.embeddings[i] == nil || rb.embeddings[i] == nil {
+					continue
+				}
+				sum += cosine(ra.embeddings[i], rb.embeddings[i])
+				p.Texts++
+			}
+			if p.Texts > 0 {
+				p.MeanCosine = sum / float64(p.Texts)
+			}
+
+			// Both providers search the same documents: those both embedded
+			var shared []int
+			for i := 0; i < docs; i++ {
+				if ra.embeddings[i] != nil && rb.embeddings[i] != nil {
+					shared = append(shared, i)
+				}
+			}
+			k := min(topK, len(shared))
+			var recall, top1 float64
+			for q := docs; q < texts && k > 0; q++ {
+				if ra.embeddings[q] == nil || rb.embeddings[q] == nil {
+					continue
+				}
+				na := nearest(ra.embeddings, shared, ra.embeddings[q], k)
+				nb := nearest(rb.embeddings, shared, rb.embeddings[q], k)
+				overlap := 0
+				for _, i := range na {
+					if slices.Contains(nb, i) {
+						overlap++
+					}
+				}
+				recall += float64(overlap) / float64(k)
+				if na[0] == nb[0] {
+					top1++
+				}
+				p.Queries++
+			}
+			if p.Queries > 0 {
+				p.RecallAtK = recall / float64(p.Queries)
+				p.Top1Agreement = top1 / float64(p.Queries)
+			}
+			report.Pairs = append(report.Pairs, p)
+		}
+	}
+	return report
+}
+
+func latencies(run *providerRun) latencyStats {
+	st := latencyStats{Provider: run.name, Calls: len(run.latencies), Errors: run.errors}
+	if len(run.latencies) == 0 {
+		return st
+	}
+	sorted := slices.Clone(run.latencies)
+	slices.Sort(sorted)
+	ms := func(d time.Duration) float64 { return float64(d.Microseconds()) / 1000 }
+	st.P50ms = ms(percentile(sorted, 0.50))
+	st.P95ms = ms(percentile(sorted, 0.95))
+	st.P99ms = ms(percentile(sorted, 0.99))
+	st.Maxms = ms(sorted[len(sorted)-1])
+	if run.elapsed > 0 {
+		st.TextsPerS = float64(len(run.latencies)) / run.elapsed.Seconds()
+	}
+	return st
+}
+
+// percentile returns the p-th quantile of sorted latencies, nearest rank
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	i := int(math.Ceil(p*float64(len(sorted)))) - 1
+	return sorted[max(i, 0)]
+}
+
+func cosine(a, b []float32) float64 {
+	var dot, na, nb float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+		na += float64(a[i]) * float64(a[i])
+		nb += float64(b[i]) * float64(b[i])
+	}
+	if na == 0 || nb == 0 {
+		return 0
+	}
+	return dot / math.Sqrt(na*nb)
+}
+
+// nearest returns the k candidates most cosine-similar to query, closest
+// first. Ties go to the earlier text, so both providers break them alike.
+func nearest(embeddings [][]float32, candidates []int, query []float32, k int) []int {
+	type hit struct {
+		i   int
+		sim float64
+	}
+	hits := make([]hit, len(candidates))
+	for n, i := range candidates {
+		hits[n] = hit{i, cosine(embeddings[i], query)}
+	}
+	slices.SortStableFunc(hits, func(a, b hit) int {
+		switch {
+		case a.sim > b.sim:
+			return -1
+		case a.sim < b.sim:
+			return 1
+		}
+		return 0
+	})
+	ids := make([]int, k)
+	for n := range ids {
+		ids[n] = hits[n].i
+	}
+	return ids
+}
+
+// save writes each provider's embeddings as a .npy array, zero rows for
+// texts it failed to embed, and the texts as a JSONL sidecar, ready for
+// import-vectors or numpy
+func save(dir string, runs []*providerRun, texts []string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	metas := make([]vectorfile.Meta, len(texts))
+	for i, text := range texts {
+		metas[i] = vectorfile.Meta{ID: fmt.Sprintf("text-%d", i), Text: text}
+	}
+	if err := writeFile(filepath.Join(dir, "corpus.jsonl"), func(w io.Writer) error {
+		return vectorfile.WriteMeta(w, metas)
+	}); err != nil {
+		return err
+	}
+
+	for _, run := range runs {
+		v := &vectorfile.Vectors{Dim: 512, Data: make([]float32, len(texts)*512)}
+		for i, emb := range run.embeddings {
+			copy(v.Row(i), emb)
+		}
+		if err := writeFile(filepath.Join(dir, run.name+".npy"), func(w io.Writer) error {
+			return vectorfile.WriteNPY(w, v)
+		}); err != nil {
+			return err
+		}
+	}
+	log.Printf("Saved embeddings and corpus.jsonl to %s", dir)
+	return nil
+}
+
+func writeFile(path string, write func(io.Writer) error) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := write(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func printReport(w io.Writer, r benchmarkReport) {
+	fmt.Fprintf(w, "%d texts, %d held out as queries, top %d neighbors compared\n\n", r.Texts, r.Queries, r.TopK)
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "PROVIDER\tCALLS\tERRORS\tP50\tP95\tP99\tMAX\tTEXTS/S")
+	for _, l := range r.Latencies {
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%.1fms\t%.1fms\t%.1fms\t%.1fms\t%.1f\n",
+			l.Provider, l.Calls, l.Errors, l.P50ms, l.P95ms, l.P99ms, l.Maxms, l.TextsPerS)
+	}
+	tw.Flush()
+
+	if len(r.Pairs) == 0 {
+		return
+	}
+	fmt.Fprintln(w)
+	tw = tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "PAIR\tTEXTS\tMEAN COSINE\tQUERIES\tRECALL@%d\tTOP-1 AGREE\n", r.TopK)
+	for _, p := range r.Pairs {
+		fmt.Fprintf(tw, "%s / %s\t%d\t%.3f\t%d\t%.3f\t%.3f\n",
+			p.A, p.B, p.Texts, p.MeanCosine, p.Queries, p.RecallAtK, p.Top1Agreement)
+	}
+	tw.Flush()
+	fmt.Fprintln(w, "\nMean cosine compares embedding spaces directly and is only meaningful between")
+	fmt.Fprintln(w, "related models. Recall is the share of each query's neighbors both providers return.")
+}
@@ -0,0 +1,418 @@
+package main
+
+import (
+	"Hippocampus/src/client"
+	"Hippocampus/src/types"
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"text/tabwriter"
+	"time"
+)
+
+// benchOptions configures the bench command
+type benchOptions struct {
+	inserts  int
+	searches int
+	workers  int
+	texts    []string // Sample texts; nil generates synthetic ones
+	search   types.SearchOptions
+	seed     int64
+}
+
+// opStats are the results of one benchmarked operation
+type opStats struct {
+	Count     int     `json:"count"`
+	Errors    int     `json:"errors"`
+	Skipped   int     `json:"duplicates,omitempty"`
+	OpsPerSec float64 `json:"ops_per_sec"`
+	P50ms     float64 `json:"p50_ms"`
+	P90ms     float64 `json:"p90_ms"`
+	P99ms     float64 `json:"p99_ms"`
+	Maxms     float64 `json:"max_ms"`
+}
+
+// benchReport is the bench command output
+type benchReport struct {
+	Binary   string  `json:"binary"`
+	Nodes    int     `json:"nodes"`
+	Workers  int     `json:"workers"`
+	Inserts  opStats `json:"inserts"`
+	Searches opStats `json:"searches"`
+	FlushMs  float64 `json:"flush_ms"`
+}
+
+var benchWords = []string{
+	"customer", "order", "refund", "billing", "account", "password", "shipping",
+	"delivery", "invoice", "subscription", "upgrade", "cancel", "payment",
+	"card", "address", "support", "ticket", "late", "damaged", "missing",
+	"discount", "renewal", "login", "email", "phone", "plan", "trial",
+}
+
+// benchText returns the i-th insert text: a sample line, made unique once
+// the samples run out, or a synthetic sentence
+func benchText(i int, opts benchOptions, rng *rand.Rand) string {
+	if len(opts.texts) > 0 {
+		text := opts.texts[i%len(opts.texts)]
+		if i >= len(opts.texts) {
+			text = fmt.Sprintf("%s (%d)", text, i/len(opts.texts))
+		}
+		return text
+	}
+
+	words := make([]string, 6+rng.Intn(6))
+	for w := range words {
+		words[w] = benchWords[rng.Intn(len(benchWords))]
+	}
+	return fmt.Sprintf("memory %d: %s", i, strings.Join(words, " "))
+}
+
+// runBench inserts and then searches through c with opts.workers goroutines,
+// timing every call. Inserts go through Client.Insert, so embedding and the
+// client's periodic flushes are part of the numbers.
+func runBench(c *client.Client, opts benchOptions) (benchReport, error) {
+	report := benchReport{Workers: opts.workers}
+	rng := rand.New(rand.NewSource(opts.seed))
+
+	texts := make([]string, opts.inserts)
+	for i := range texts {
+		texts[i] = benchText(i, opts, rng)
+	}
+	report.Inserts = benchOp(opts.inserts, opts.workers, func(i int) (bool, error) {
+		err := c.Insert(fmt.Sprintf("bench-%d", i), texts[i])
+		if errors.Is(err, types.ErrDuplicateKey) {
+			return false, nil
+		}
+		return err == nil, err
+	})
+
+	flushStart := time.Now()
+	if err := c.Flush(); err != nil {
+		return report, fmt.Errorf("flush: %w", err)
+	}
+	report.FlushMs = durationMs(time.Since(flushStart))
+
+	// Queries are drawn from what was inserted, or the samples if nothing was
+	queries := texts
+	if len(queries) == 0 {
+		queries = opts.texts
+	}
+	if len(queries) == 0 && opts.searches > 0 {
+		queries = []string{benchText(0, opts, rng)}
+	}
+	picks := make([]int, opts.searches)
+	for i := range picks {
+		picks[i] = rng.Intn(len(queries))
+	}
+	report.Searches = benchOp(opts.searches, opts.workers, func(i int) (bool, error) {
+		_, err := c.SearchScored(queries[picks[i]], opts.search)
+		return true, err
+	})
+
+	count, err := c.Count()
+	if err != nil {
+		return report, err
+	}
+	report.Nodes = count
+	return report, nil
+}
+
+// treeBenchRow is one run of bench -tree
+type treeBenchRow struct {
+	Locking string  `json:"locking"` // "sharded" or "global"
+	Workers int     `json:"workers"`
+	Inserts opStats `json:"inserts"`
+	Speedup float64 `json:"speedup"` // Throughput over the same locking with one worker
+}
+
+// runTreeBench times Tree.InsertNode on copies of an indexed tree of base
+// random nodes, with one and with workers goroutines. Each is run with the
+// tree's own sharded locking and again behind one mutex, as inserts were
+// serialized before the index was sharded. Embedding and storage are left
+// out, so only the tree is measured.
+func runTreeBench(base, inserts, workers int, seed int64) []treeBenchRow {
+	rng := rand.New(rand.NewSource(seed))
+	seeded := randomTree(base, rng)
+	nodes := make([]types.Node, inserts)
+	for i := range nodes {
+		nodes[i] = randomNode(fmt.Sprintf("bench-%d", i), rng)
+	}
+
+	workerCounts := []int{1}
+	if workers > 1 {
+		workerCounts = append(workerCounts, workers)
+	}
+
+	var rows []treeBenchRow
+	for _, locking := range []string{"sharded", "global"} {
+		var single float64
+		for _, n := range workerCounts {
+			tree := seeded.DeepCopy()
+			var global sync.Mutex
+			stats := benchOp(inserts, n, func(i int) (bool, error) {
+				if locking == "global" {
+					global.Lock()
+					defer global.Unlock()
+				}
+				err := tree.InsertNode(nodes[i])
+				if errors.Is(err, types.ErrDuplicateKey) {
+					return false, nil
+				}
+				return err == nil, err
+			})
+
+			if n == 1 {
+				single = stats.OpsPerSec
+			}
+			row := treeBenchRow{Locking: locking, Workers: n, Inserts: stats}
+			if single > 0 {
+				row.Speedup = stats.OpsPerSec / single
+			}
+			rows = append(rows, row)
+		}
+	}
+	return rows
+}
+
+// randomNode returns a node with a standard normal embedding
+func randomNode(id string, rng *rand.Rand) types.Node {
+	n := types.Node{ID: id, Value: id}
+	for dim := range n.Key {
+		n.Key[dim] = float32(rng.NormFloat64())
+	}
+	return n
+}
+
+// randomTree returns an indexed tree of n random nodes
+func randomTree(n int, rng *rand.Rand) *types.Tree {
+	tree := types.NewTree()
+	for i := 0; i < n; i++ {
+		tree.InsertNode(randomNode(fmt.Sprintf("base-%d", i), rng))
+	}
+	tree.EnsureIndexed()
+	return tree
+}
+
+// nearestBenchRow is one method timed by bench -nearest
+type nearestBenchRow struct {
+	Method   string  `json:"method"`
+	Searches opStats `json:"searches"`
+	Found    int     `json:"found"`   // Searches returning a node
+	Speedup  float64 `json:"speedup"` // Throughput over Search with top-k 1
+}
+
+// runNearestBench times Tree.NearestNeighbor against SearchScored with a
+// TopK of 1 on the same indexed tree of base random nodes. Each query is a
+// tree node moved slightly, so both methods have a match to find.
+func runNearestBench(base, searches, workers int, opts types.SearchOptions, seed int64) []nearestBenchRow {
+	rng := rand.New(rand.NewSource(seed))
+	tree := randomTree(base, rng)
+	if tree.Len() == 0 {
+		return nil
+	}
+	queries := make([][512]float32, searches)
+	for i := range queries {
+		node := tree.Nodes[rng.Intn(tree.Len())]
+		for dim := range queries[i] {
+			queries[i][dim] = node.Key[dim] + float32(rng.NormFloat64()*0.01)
+		}
+	}
+	opts.TopK = 1
+
+	var found atomic.Int64
+	search := benchOp(searches, workers, func(i int) (bool, error) {
+		if len(tree.SearchScored(queries[i], opts)) > 0 {
+			found.Add(1)
+		}
+		return true, nil
+	})
+	rows := []nearestBenchRow{{Method: "search top-k 1", Searches: search, Found: int(found.Load()), Speedup: 1}}
+
+	found.Store(0)
+	nearest := benchOp(searches, workers, func(i int) (bool, error) {
+		if node, _ := tree.NearestNeighbor(queries[i], types.Euclidean); node != nil {
+			found.Add(1)
+		}
+		return true, nil
+	})
+	row := nearestBenchRow{Method: "nearest neighbor", Searches: nearest, Found: int(found.Load())}
+	if search.OpsPerSec > 0 {
+		row.Speedup = nearest.OpsPerSec / search.OpsPerSec
+	}
+	return append(rows, row)
+}
+
+// printNearestBench prints the bench -nearest results as a table or JSON
+func printNearestBench(rows []nearestBenchRow, base int, asJSON bool) {
+	if asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(rows)
+		return
+	}
+
+	fmt.Printf("Single nearest match in an indexed tree of %d nodes\n\n", base)
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(w, "METHOD\tSEARCHES\tFOUND\tOPS/S\tP50 MS\tP99 MS\tSPEEDUP\t")
+	for _, row := range rows {
+		fmt.Fprintf(w, "%s\t%d\t%d\t%.0f\t%.3f\t%.3f\t%.2fx\t\n",
+			row.Method, row.Searches.Count, row.Found, row.Searches.OpsPerSec, row.Searches.P50ms, row.Searches.P99ms, row.Speedup)
+	}
+	w.Flush()
+}
+
+// printTreeBench prints the bench -tree results as a table or JSON
+func printTreeBench(rows []treeBenchRow, base int, asJSON bool) {
+	if asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(rows)
+		return
+	}
+
+	fmt.Printf("Tree inserts into an indexed tree of %d nodes\n\n", base)
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(w, "LOCKING\tWORKERS\tOPS/S\tP50 MS\tP99 MS\tSPEEDUP\t")
+	for _, row := range rows {
+		fmt.Fprintf(w, "%s\t%d\t%.0f\t%.3f\t%.3f\t%.2fx\t\n",
+			row.Locking, row.Workers, row.Inserts.OpsPerSec, row.Inserts.P50ms, row.Inserts.P99ms, row.Speedup)
+	}
+	w.Flush()
+}
+
+// benchOp calls op(0..n-1) from workers goroutines and summarizes the
+// latencies. op reports false for calls skipped without error.
+func benchOp(n, workers int, op func(i int) (bool, error)) opStats {
+	latencies := make([]time.Duration, n)
+	var next, errCount, skipped atomic.Int64
+	var firstErr sync.Once
+
+	start := time.Now()
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				i := int(next.Add(1)) - 1
+				if i >= n {
+					return
+				}
+				opStart := time.Now()
+				done, err := op(i)
+				latencies[i] = time.Since(opStart)
+				switch {
+				case err != nil:
+					errCount.Add(1)
+					firstErr.Do(func() { fmt.Fprintf(os.Stderr, "bench: %v\n", err) })
+				case !done:
+					skipped.Add(1)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+
+	stats := opStats{Count: n, Errors: int(errCount.Load()), Skipped: int(skipped.Load())}
+	if n == 0 {
+		return stats
+	}
+	stats.OpsPerSec = float64(n) / elapsed.Seconds()
+
+	slices.Sort(latencies)
+	percentile := func(p float64) float64 {
+		return durationMs(latencies[int(p*float64(n-1))])
+	}
+	stats.P50ms = percentile(0.50)
+	stats.P90ms = percentile(0.90)
+	stats.P99ms = percentile(0.99)
+	stats.Maxms = durationMs(latencies[n-1])
+	return stats
+}
+
+func durationMs(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
+
+// readSampleTexts returns the non-empty lines of path
+func readSampleTexts(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var texts []string
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1<<20)
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); line != "" {
+			texts = append(texts, line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(texts) == 0 {
+		return nil, fmt.Errorf("%s has no lines", path)
+	}
+	return texts, nil
+}
+
+// copyFile copies src to dst; a missing src leaves dst empty
+func copyFile(dst, src string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// printBenchReport prints the bench report as a table or JSON
+func printBenchReport(report benchReport, asJSON bool) {
+	if asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(report)
+		return
+	}
+
+	fmt.Printf("Database: %s (%d nodes, %d workers)\n\n", report.Binary, report.Nodes, report.Workers)
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(w, "OPERATION\tCOUNT\tERRORS\tOPS/S\tP50 MS\tP90 MS\tP99 MS\tMAX MS\t")
+	for _, row := range []struct {
+		name string
+		s    opStats
+	}{{"insert", report.Inserts}, {"search", report.Searches}} {
+		fmt.Fprintf(w, "%s\t%d\t%d\t%.0f\t%.3f\t%.3f\t%.3f\t%.3f\t\n",
+			row.name, row.s.Count, row.s.Errors, row.s.OpsPerSec, row.s.P50ms, row.s.P90ms, row.s.P99ms, row.s.Maxms)
+	}
+	w.Flush()
+
+	if report.Inserts.Skipped > 0 {
+		fmt.Printf("\n%d inserts skipped as duplicates\n", report.Inserts.Skipped)
+	}
+	fmt.Printf("\nFinal flush: %.3f ms\n", report.FlushMs)
+}
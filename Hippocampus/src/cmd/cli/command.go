@@ -0,0 +1,337 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"text/tabwriter"
+)
+
+// command is a subcommand of the CLI
+type command struct {
+	name    string
+	aliases []string // Other names it runs under, e.g. --version
+	words   []string // Words required after the name, e.g. "show" for config
+	usage   []string // Synopsis lines, after "hippocampus"
+	summary string   // One line for the command list
+
+	// setup registers the command's flags and returns what runs once they
+	// are parsed. Commands that parse their arguments themselves set run
+	// instead, passing parseFlags to whatever parses them.
+	setup func(fs *flag.FlagSet) func()
+	run   func(args []string)
+
+	// raw leaves the flags as given, without settings from the environment
+	// and config file
+	raw bool
+}
+
+// commands lists every command in the order of the usage. It is filled by
+// init since help refers back to it.
+var commands []*command
+
+func init() {
+	commands = []*command{
+		{name: "insert", setup: insertCommand,
+			usage:   []string{"insert -binary tree.bin -key <id> -text <text>"},
+			summary: "Store a single memory with a key"},
+		{name: "search", setup: searchCommand,
+			usage: []string{
+				"search -binary tree.bin -text <text> -epsilon 0.3 -threshold 0.5 -top-k 5",
+				"search -binary a.bin -binary b.bin | -binary-glob 'data/*.bin' -text <text>",
+			},
+			summary: "Search for similar memories"},
+		{name: "search-radius", setup: searchRadiusCommand,
+			usage:   []string{"search-radius -binary tree.bin -text <text> -radius 0.1 [-metric euclidean|cosine] [-json]"},
+			summary: "List every memory within a distance of the text, without a top-k cap"},
+		{name: "get", setup: getCommand,
+			usage:   []string{"get -binary tree.bin -key <id> [-json]"},
+			summary: "Print the memory stored under a key"},
+		{name: "delete", setup: deleteCommand,
+			usage:   []string{"delete -binary tree.bin -key <id>"},
+			summary: "Remove the memory stored under a key"},
+		{name: "insert-csv", setup: insertCSVCommand,
+			usage:   []string{"insert-csv -binary tree.bin -csv <file.csv> [-resume-from N] [-dry-run]"},
+			summary: "Bulk insert from CSV file"},
+		{name: "insert-stdin", setup: insertStdinCommand,
+			usage:   []string{"insert-stdin -binary tree.bin [-key-prefix note-] [-tsv] < notes.txt"},
+			summary: "Insert one memory per line of stdin"},
+		{name: "snapshot", setup: snapshotCommand,
+			usage:   []string{"snapshot -binary tree.bin -out backup.bin"},
+			summary: "Write a point-in-time backup of the database"},
+		{name: "restore", setup: restoreCommand,
+			usage:   []string{"restore -binary tree.bin -from backup.bin"},
+			summary: "Replace the database with a backup"},
+		{name: "restore-snapshot", setup: restoreSnapshotCommand,
+			usage:   []string{"restore-snapshot -snapshot-dir snapshots/ | -snapshot-s3 bucket/prefix -data-dir agents/ [-set latest] [-replace]"},
+			summary: "Rebuild a server's data directory from a scheduled snapshot"},
+		{name: "cdc", words: []string{"tail"}, setup: cdcTailCommand,
+			usage:   []string{"cdc tail -log changes.log [-from-seq N] [-follow]"},
+			summary: "Print a server's change log as JSON lines, from a sequence number on"},
+		{name: "shard", setup: shardCommand,
+			usage:   []string{"shard -binary tree.bin -shards 8 -out-dir shards/"},
+			summary: "Split the database into multiple shard files"},
+		{name: "diff", setup: diffCommand,
+			usage:   []string{"diff -a a.bin -b b.bin [-format table|json]"},
+			summary: "Compare two database files by embedding"},
+		{name: "merge", setup: mergeCommand,
+			usage:   []string{"merge -a a.bin -b b.bin -out merged.bin [-policy keep-newer]"},
+			summary: "Combine two databases, matching memories by key"},
+		{name: "embed", setup: embedCommand,
+			usage:   []string{"embed -text <text> [-summary]"},
+			summary: "Print the embedding of a text as JSON"},
+		{name: "prefetch", setup: prefetchCommand,
+			usage:   []string{"prefetch -binary tree.bin -text-file texts.txt"},
+			summary: "Embed texts ahead of inserting them, caching the embeddings"},
+		{name: "similarity", setup: similarityCommand,
+			usage:   []string{"similarity -a <text> -b <text>"},
+			summary: "Print the cosine similarity of two texts' embeddings"},
+		{name: "migrate", setup: migrateCommand,
+			usage:   []string{"migrate -in old.bin -out new.bin | -in-place"},
+			summary: "Upgrade a database file to the newest format"},
+		{name: "compact", setup: compactCommand,
+			usage:   []string{"compact -binary tree.bin"},
+			summary: "Rewrite the database without duplicates, with a fresh index"},
+		{name: "watch", setup: watchCommand,
+			usage:   []string{"watch -binary tree.bin -file notes.jsonl [-raw] [-key-prefix note-]"},
+			summary: "Tail a file and insert each new line as a memory"},
+		{name: "rotate", setup: rotateCommand,
+			usage:   []string{"rotate -binary tree.bin -archive-dir archive/"},
+			summary: "Archive the database into a directory and start an empty one"},
+		{name: "verify", setup: verifyCommand,
+			usage:   []string{"verify -binary tree.bin [-json]"},
+			summary: "Check a database file for corruption without changing it"},
+		{name: "repair", setup: repairCommand,
+			usage:   []string{"repair -binary tree.bin -out fixed.bin"},
+			summary: "Recover the readable nodes of a damaged file into a new one"},
+		{name: "export", setup: exportCommand,
+			usage:   []string{"export -binary tree.bin [-format jsonl|csv] [-with-embeddings] [-out file]"},
+			summary: "Dump every memory as JSONL or CSV"},
+		{name: "import", setup: importCommand,
+			usage:   []string{"import -binary tree.bin -in file [-format jsonl]"},
+			summary: "Insert memories from an export, reusing stored embeddings"},
+		{name: "import-vectors", setup: importVectorsCommand,
+			usage:   []string{"import-vectors -binary tree.bin -format faiss-flat|npy -index vectors.faiss -meta meta.jsonl"},
+			summary: "Insert vectors from a FAISS flat index or .npy array, with IDs and text from a JSONL sidecar"},
+		{name: "export-vectors", setup: exportVectorsCommand,
+			usage:   []string{"export-vectors -binary tree.bin -format faiss-flat|npy -out vectors.faiss [-meta meta.jsonl] [-metric l2|ip]"},
+			summary: "Write every embedding as a FAISS flat index or .npy array, with a JSONL sidecar"},
+		{name: "stats", setup: statsCommand,
+			usage:   []string{"stats -binary tree.bin [-json]"},
+			summary: "Print size and format details of a database file"},
+		{name: "repl", setup: replCommand,
+			usage:   []string{"repl -binary tree.bin"},
+			summary: "Interactive session with the database kept loaded"},
+		{name: "serve", run: serveCommand,
+			usage:   []string{"serve -addr :6379 [-data-dir agents/] [server flags]"},
+			summary: "Run the Redis protocol server (same flags as hippocampus-server)"},
+		{name: "serve-http", run: serveHTTPCommand,
+			usage:   []string{"serve-http -addr :8081 [-data-dir agents/]"},
+			summary: "Run the JSON REST API server"},
+		{name: "serve-mcp", run: serveMCPCommand,
+			usage:   []string{"serve-mcp [-binary tree.bin | -agent <id> -data-dir agents/] [-sse-addr localhost:8082]"},
+			summary: "Serve memories as MCP tools over stdio, or HTTP+SSE"},
+		{name: "bench", setup: benchCommand,
+			usage:   []string{"bench -binary tree.bin -inserts 10000 -searches 1000 -workers 4 [-json]"},
+			summary: "Measure insert and search throughput and latency"},
+		{name: "config", words: []string{"show"}, setup: configShowCommand, raw: true,
+			usage:   []string{"config show [-config file]"},
+			summary: "Print the effective settings and where each came from"},
+		{name: "version", aliases: []string{"-version", "--version"}, setup: versionCommand,
+			usage:   []string{"version [-json]"},
+			summary: "Print the version, commit, build date and file formats"},
+	}
+}
+
+// findCommand returns the command named name, or nil
+func findCommand(name string) *command {
+	for _, c := range commands {
+		if c.name == name {
+			return c
+		}
+		for _, alias := range c.aliases {
+			if alias == name {
+				return c
+			}
+		}
+	}
+	return nil
+}
+
+// title is the command as shown in the command list, e.g. "config show"
+func (c *command) title() string {
+	return strings.Join(append([]string{c.name}, c.words...), " ")
+}
+
+// execute runs the command with the arguments after its name
+func (c *command) execute(args []string) {
+	if c.run != nil {
+		c.run(args)
+		return
+	}
+
+	for _, word := range c.words {
+		if len(args) == 0 || args[0] != word {
+			log.Fatalf("usage: hippocampus %s [flags]\nRun 'hippocampus help %s' for usage", c.title(), c.name)
+		}
+		args = args[1:]
+	}
+
+	fs := flag.NewFlagSet(c.name, flag.ContinueOnError)
+	action := c.setup(fs)
+	if c.raw {
+		parseArgs(fs, args)
+	} else {
+		parseFlags(fs, args)
+	}
+	action()
+}
+
+// printCommandHelp prints the synopsis of fs's command and its flags to fs's
+// output
+func printCommandHelp(fs *flag.FlagSet) {
+	w := fs.Output()
+	if c := findCommand(fs.Name()); c != nil {
+		fmt.Fprintf(w, "%s\n\nUsage:\n", c.summary)
+		for _, line := range c.usage {
+			fmt.Fprintf(w, "  hippocampus %s\n", line)
+		}
+		fmt.Fprintln(w)
+	}
+	fmt.Fprintln(w, "Flags:")
+	fs.PrintDefaults()
+}
+
+// helpCommand prints the help of the named command, or the general usage
+func helpCommand(args []string) {
+	if len(args) == 0 {
+		printUsage(os.Stdout)
+		return
+	}
+
+	c := findCommand(args[0])
+	if c == nil {
+		log.Fatalf("unknown command: %s\nRun 'hippocampus help' for the list of commands", args[0])
+	}
+	if c.run != nil {
+		// Its flags are only known to its parser, which prints them for -h
+		c.run([]string{"-h"})
+		return
+	}
+
+	fs := flag.NewFlagSet(c.name, flag.ContinueOnError)
+	c.setup(fs)
+	if fs.Lookup("config") == nil {
+		fs.String("config", "", "config file (default: ~/.config/hippocampus/config.yaml)")
+	}
+	fs.SetOutput(os.Stdout)
+	printCommandHelp(fs)
+}
+
+// globalFlagNames are the flags that may come before the command name, e.g.
+// "hippocampus -binary work.bin stats". They are passed on to the command,
+// so only commands taking them accept them.
+var globalFlagNames = []string{"binary", "embedder", "embed-url", "embed-model", "embed-api-key-env", "mock", "append-only", "config"}
+
+// splitGlobalFlags separates the flags before the command name from the
+// rest of args, which starts with the command name
+func splitGlobalFlags(args []string) (global, rest []string, err error) {
+	fs := flag.NewFlagSet("hippocampus", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	for _, name := range globalFlagNames {
+		if name == "mock" || name == "append-only" {
+			fs.Bool(name, false, "")
+		} else {
+			fs.String(name, "", "")
+		}
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, nil, err
+	}
+	rest = fs.Args()
+	return args[:len(args)-len(rest)], rest, nil
+}
+
+// printUsage prints the general usage, with the commands from commands
+func printUsage(w io.Writer) {
+	fmt.Fprintln(w, "Hippocampus CLI - AI Agent Memory Database (Local Version)")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Usage:")
+	for _, c := range commands {
+		for _, line := range c.usage {
+			fmt.Fprintf(w, "  hippocampus %s\n", line)
+		}
+	}
+	fmt.Fprintln(w, "  hippocampus help <command>")
+
+	// Each section is aligned on its longest name, leaving two spaces
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw)
+	fmt.Fprintln(tw, "Commands:")
+	for _, c := range commands {
+		fmt.Fprintf(tw, "  %s\t%s\n", c.title(), c.summary)
+	}
+	tw.Flush()
+
+	fmt.Fprintln(tw)
+	fmt.Fprintln(tw, "Global Flags:")
+	fmt.Fprintln(tw, "  -binary\tDatabase file path (default: tree.bin)")
+	fmt.Fprintln(tw, "  -embedder\tmock, local, openai, ollama or bedrock (default: local)")
+	fmt.Fprintln(tw, "  -embed-url\tEmbedding service URL (default depends on -embedder)")
+	fmt.Fprintln(tw, "  -embed-model\tEmbedding model (openai, ollama, bedrock)")
+	fmt.Fprintln(tw, "  -embed-api-key-env\tVariable holding the API key (default: OPENAI_API_KEY)")
+	fmt.Fprintln(tw, "  -chunk-size\tSplit long texts into overlapping chunks (insert, insert-csv)")
+	fmt.Fprintln(tw, "  -append-only\tTreat the database as an append-only log (insert, search, get, delete, ...)")
+	fmt.Fprintln(tw, "  -config\tConfig file (default: ~/.config/hippocampus/config.yaml)")
+	tw.Flush()
+
+	fmt.Fprintln(tw)
+	fmt.Fprintln(tw, "Environment (overridden by flags, overrides the config file):")
+	fmt.Fprintln(tw, "  HIPPO_BINARY\tDatabase file path (config: binary)")
+	fmt.Fprintln(tw, "  HIPPO_EMBED_URL\tEmbedding service URL (config: embed_url)")
+	fmt.Fprintln(tw, "  HIPPO_EMBEDDER\tmock, local, openai, ollama or bedrock (config: embedder)")
+	fmt.Fprintln(tw, "  HIPPO_EMBED_MODEL\tEmbedding model (config: embed_model)")
+	tw.Flush()
+}
+
+// runCLI runs the command in args, os.Args without the program name
+func runCLI(args []string) {
+	if len(args) == 0 {
+		printUsage(os.Stdout)
+		os.Exit(1)
+	}
+
+	// Flags before the command name are passed on to it. Anything else
+	// starting with a dash, such as --version, is taken as the name.
+	global, rest, err := splitGlobalFlags(args)
+	if err == flag.ErrHelp {
+		printUsage(os.Stdout)
+		return
+	}
+	if err != nil {
+		global, rest = nil, args
+	}
+	if len(rest) == 0 {
+		log.Fatalf("no command after %s\nRun 'hippocampus help' for usage", strings.Join(global, " "))
+	}
+
+	if rest[0] == "help" {
+		helpCommand(rest[1:])
+		return
+	}
+	c := findCommand(rest[0])
+	if c == nil {
+		log.Fatalf("unknown command: %s\nRun 'hippocampus' with no arguments for usage", rest[0])
+	}
+
+	args = rest[1:]
+	if len(global) > 0 {
+		// After any words, e.g. "config show"
+		n := min(len(c.words), len(args))
+		args = append(append(append([]string{}, args[:n]...), global...), args[n:]...)
+	}
+	c.execute(args)
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+)
+
+// setting is an option that can also come from the environment or the
+// config file. Precedence is flag > environment > config file > default.
+type setting struct {
+	Key  string // Config file key, also the name shown by config show
+	Flag string // Flag the value is applied to
+	Env  string
+}
+
+var settings = []setting{
+	{Key: "binary", Flag: "binary", Env: "HIPPO_BINARY"},
+	{Key: "embedder", Flag: "embedder", Env: "HIPPO_EMBEDDER"},
+	{Key: "embed_url", Flag: "embed-url", Env: "HIPPO_EMBED_URL"},
+	{Key: "embed_model", Flag: "embed-model", Env: "HIPPO_EMBED_MODEL"},
+	{Key: "agent", Flag: "agent", Env: "HIPPO_AGENT"},
+}
+
+// resolved is the effective value of a setting and where it came from
+type resolved struct {
+	Setting setting
+	Value   string // Flag value
+	Source  string // "flag", "env HIPPO_...", "config <path>" or "default"
+}
+
+// defaultConfigPath returns ~/.config/hippocampus/config.yaml, honouring
+// XDG_CONFIG_HOME, or "" if no config directory is known
+func defaultConfigPath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "hippocampus", "config.yaml")
+}
+
+// parseFlags parses args into fs with an added -config flag, then fills the
+// settings not given on the command line from the environment and the config
+// file. Every subcommand parses its flags through here.
+func parseFlags(fs *flag.FlagSet, args []string) {
+	configPath := parseArgs(fs, args)
+
+	values, err := resolveSettings(fs, configPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, r := range values {
+		if r.Source == "flag" || r.Source == "default" {
+			continue
+		}
+		if err := fs.Set(r.Setting.Flag, r.Value); err != nil {
+			log.Fatalf("%s from %s: %v", r.Setting.Key, r.Source, err)
+		}
+	}
+}
+
+// parseArgs parses args into fs, adding -config if fs lacks it, and returns
+// the -config path. -h prints the command's help to stdout and exits; a bad
+// flag exits with status 2 and an error naming the command.
+func parseArgs(fs *flag.FlagSet, args []string) string {
+	if fs.Lookup("config") == nil {
+		fs.String("config", "", "config file (default: ~/.config/hippocampus/config.yaml)")
+	}
+
+	// The flag package would print the whole usage on every error
+	fs.Init(fs.Name(), flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	err := fs.Parse(args)
+	fs.SetOutput(os.Stderr)
+	if err == flag.ErrHelp {
+		fs.SetOutput(os.Stdout)
+		printCommandHelp(fs)
+		os.Exit(0)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "hippocampus %s: %v\nRun 'hippocampus help %s' for usage\n", fs.Name(), err, fs.Name())
+		os.Exit(2)
+	}
+
+	return fs.Lookup("config").Value.String()
+}
+
+// resolveSettings returns the effective value of every setting fs has a flag
+// for. An explicit configPath must exist; the default one may be missing.
+func resolveSettings(fs *flag.FlagSet, configPath string) ([]resolved, error) {
+	explicit := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { explicit[f.Name] = true })
+
+	var config map[string]string
+	if configPath != "" {
+		var err error
+		if config, err = loadConfig(configPath); err != nil {
+			return nil, err
+		}
+	} else if configPath = defaultConfigPath(); configPath != "" {
+		var err error
+		if config, err = loadConfig(configPath); err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+
+	var values []resolved
+	for _, s := range settings {
+		f := fs.Lookup(s.Flag)
+		if f == nil {
+			continue
+		}
+
+		r := resolved{Setting: s, Value: f.Value.String(), Source: "default"}
+		var raw string
+		switch {
+		case explicit[s.Flag]:
+			r.Source = "flag"
+		case os.Getenv(s.Env) != "":
+			raw, r.Source = os.Getenv(s.Env), "env "+s.Env
+		case config[s.Key] != "":
+			raw, r.Source = config[s.Key], "config "+configPath
+		}
+
+		if raw != "" {
+			r.Value = raw
+		}
+		values = append(values, r)
+	}
+
+	return values, nil
+}
+
+// loadConfig reads a config file of "key: value" lines, the flat subset of
+// YAML the settings need. Blank lines and # comments are ignored and values
+// may be quoted.
+func loadConfig(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	config, err := parseConfig(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return config, nil
+}
+
+func parseConfig(r io.Reader) (map[string]string, error) {
+	known := make(map[string]bool, len(settings))
+	for _, s := range settings {
+		known[s.Key] = true
+	}
+
+	config := make(map[string]string)
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		key, value, ok := strings.Cut(text, ":")
+		if !ok {
+			return nil, fmt.Errorf("line %d: expected \"key: value\"", line)
+		}
+		key = strings.TrimSpace(key)
+		if !known[key] {
+			return nil, fmt.Errorf("line %d: unknown setting %q", line, key)
+		}
+
+		value = strings.TrimSpace(value)
+		if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+			value = value[1 : len(value)-1]
+		} else if i := strings.Index(value, " #"); i >= 0 {
+			value = strings.TrimSpace(value[:i])
+		}
+		config[key] = value
+	}
+
+	return config, scanner.Err()
+}
+
+// printConfig prints the config show table
+func printConfig(values []resolved) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "SETTING\tVALUE\tSOURCE")
+	for _, r := range values {
+		fmt.Fprintf(w, "%s\t%s\t%s\n", r.Setting.Key, r.Value, r.Source)
+	}
+	w.Flush()
+}
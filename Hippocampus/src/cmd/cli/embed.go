@@ -0,0 +1,122 @@
+package main
+
+import (
+	"Hippocampus/src/embedding"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"strings"
+)
+
+// newEmbedder builds the embedder selected by a subcommand's flags, exiting
+// if the selection is invalid
+func newEmbedder(f *embedding.Flags) embedding.EmbeddingService {
+	embedder, err := f.New()
+	if err != nil {
+		log.Fatalf("Failed to create embedder: %v", err)
+	}
+	return embedder
+}
+
+// maxCachedEmbeddings bounds the embeddings prefetch keeps for a database,
+// about 2KB each
+const maxCachedEmbeddings = 50000
+
+// embedCachePath is where prefetch leaves embeddings for the database at binary
+func embedCachePath(binary string) string {
+	return binary + ".embcache"
+}
+
+// newCachedEmbedder is newEmbedder behind a cache holding the embeddings
+// prefetched for binary, if any. A cache from another model is ignored.
+func newCachedEmbedder(f *embedding.Flags, binary string) *embedding.CachedEmbedder {
+	cache := embedding.NewCachedEmbedder(newEmbedder(f), maxCachedEmbeddings)
+	if err := cache.LoadFile(embedCachePath(binary)); err != nil && !os.IsNotExist(err) {
+		fmt.Fprintf(os.Stderr, "Ignoring prefetched embeddings: %v\n", err)
+	}
+	return cache
+}
+
+// readTextFile returns the non-empty lines of path, as texts to embed
+func readTextFile(path string) ([]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var texts []string
+	for _, line := range strings.Split(string(data), "\n") {
+		if line = strings.TrimRight(line, "\r"); strings.TrimSpace(line) != "" {
+			texts = append(texts, line)
+		}
+	}
+	return texts, nil
+}
+
+// embeddingSummary is the embed -summary output
+type embeddingSummary struct {
+	Dimensions int     `json:"dimensions"`
+	Norm       float64 `json:"norm"`
+	Min        float32 `json:"min"`
+	Max        float32 `json:"max"`
+	NonFinite  int     `json:"non_finite"` // NaN or infinite values
+}
+
+func summarizeEmbedding(vec []float32) embeddingSummary {
+	summary := embeddingSummary{Dimensions: len(vec)}
+	var sum float64
+	for i, v := range vec {
+		if math.IsNaN(float64(v)) || math.IsInf(float64(v), 0) {
+			summary.NonFinite++
+			continue
+		}
+		sum += float64(v) * float64(v)
+		if i == 0 || v < summary.Min {
+			summary.Min = v
+		}
+		if i == 0 || v > summary.Max {
+			summary.Max = v
+		}
+	}
+	summary.Norm = math.Sqrt(sum)
+	return summary
+}
+
+// cosineSimilarity returns the cosine of the angle between a and b, or an
+// error if their lengths differ or either is all zeros
+func cosineSimilarity(a, b []float32) (float64, error) {
+	if len(a) != len(b) {
+		return 0, fmt.Errorf("embeddings have %d and %d dimensions", len(a), len(b))
+	}
+
+	var dot, normA, normB float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+		normA += float64(a[i]) * float64(a[i])
+		normB += float64(b[i]) * float64(b[i])
+	}
+	if normA == 0 || normB == 0 {
+		return 0, fmt.Errorf("an embedding is all zeros")
+	}
+	return dot / (math.Sqrt(normA) * math.Sqrt(normB)), nil
+}
+
+// printEmbedding prints vec as a JSON array, or its summary
+func printEmbedding(vec []float32, summary bool) {
+	enc := json.NewEncoder(os.Stdout)
+	if summary {
+		enc.SetIndent("", "  ")
+		enc.Encode(summarizeEmbedding(vec))
+		return
+	}
+	enc.Encode(vec)
+}
+
+// embeddingFailed prints an embedding service error as it was returned,
+// without log's timestamp or any wrapping, and exits
+func embeddingFailed(err error) {
+	fmt.Fprintln(os.Stderr, err)
+	os.Exit(1)
+}
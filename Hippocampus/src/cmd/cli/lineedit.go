@@ -0,0 +1,229 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// errInterrupted is returned by readLine when the user presses Ctrl-C
+var errInterrupted = errors.New("interrupted")
+
+// maxHistory is how many lines the REPL keeps in its history file
+const maxHistory = 500
+
+// lineEditor reads REPL input. On a terminal it supports cursor movement,
+// Ctrl-A/E/U/K/W and history on the up and down arrows; otherwise (pipes,
+// unsupported platforms) it reads plain lines.
+type lineEditor struct {
+	in          *bufio.Reader
+	out         io.Writer
+	interactive bool
+
+	history     []string
+	historyPath string
+}
+
+func newLineEditor(historyPath string) *lineEditor {
+	le := &lineEditor{
+		in:          bufio.NewReader(os.Stdin),
+		out:         os.Stdout,
+		interactive: isTerminal(int(os.Stdin.Fd())),
+		historyPath: historyPath,
+	}
+	le.loadHistory()
+	return le
+}
+
+func (le *lineEditor) loadHistory() {
+	if le.historyPath == "" {
+		return
+	}
+	data, err := os.ReadFile(le.historyPath)
+	if err != nil {
+		return
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if line != "" {
+			le.history = append(le.history, line)
+		}
+	}
+}
+
+// saveHistory writes the most recent lines to the history file
+func (le *lineEditor) saveHistory() error {
+	if le.historyPath == "" {
+		return nil
+	}
+	history := le.history
+	if len(history) > maxHistory {
+		history = history[len(history)-maxHistory:]
+	}
+	return os.WriteFile(le.historyPath, []byte(strings.Join(history, "\n")+"\n"), 0600)
+}
+
+func (le *lineEditor) addHistory(line string) {
+	if line == "" || (len(le.history) > 0 && le.history[len(le.history)-1] == line) {
+		return
+	}
+	le.history = append(le.history, line)
+}
+
+// readLine prints prompt and returns the next line without its newline. It
+// returns io.EOF on end of input or Ctrl-D on an empty line.
+func (le *lineEditor) readLine(prompt string) (string, error) {
+	if le.interactive {
+		if restore, err := makeRaw(int(os.Stdin.Fd())); err == nil {
+			defer restore()
+			line, err := le.edit(prompt)
+			fmt.Fprint(le.out, "\r\n")
+			if err == nil {
+				le.addHistory(line)
+			}
+			return line, err
+		}
+	}
+
+	fmt.Fprint(le.out, prompt)
+	line, err := le.in.ReadString('\n')
+	if err != nil && (err != io.EOF || line == "") {
+		return "", err
+	}
+	line = strings.TrimRight(line, "\r\n")
+	le.addHistory(line)
+	return line, nil
+}
+
+// edit runs the raw-mode editing loop for one line
+func (le *lineEditor) edit(prompt string) (string, error) {
+	var line []rune
+	pos := 0
+	histPos := len(le.history) // len(history) is the line being typed
+	var draft []rune           // The line being typed while browsing history
+
+	redraw := func() {
+		fmt.Fprintf(le.out, "\r%s%s\x1b[K", prompt, string(line))
+		if back := len(line) - pos; back > 0 {
+			fmt.Fprintf(le.out, "\x1b[%dD", back)
+		}
+	}
+	showHistory := func(i int) {
+		if histPos == len(le.history) {
+			draft = line
+		}
+		histPos = i
+		if i == len(le.history) {
+			line = draft
+		} else {
+			line = []rune(le.history[i])
+		}
+		pos = len(line)
+	}
+	redraw()
+
+	for {
+		r, _, err := le.in.ReadRune()
+		if err != nil {
+			return "", err
+		}
+
+		switch r {
+		case '\r', '\n':
+			return string(line), nil
+		case 3: // Ctrl-C
+			fmt.Fprint(le.out, "^C")
+			return "", errInterrupted
+		case 4: // Ctrl-D
+			if len(line) == 0 {
+				return "", io.EOF
+			}
+			if pos < len(line) {
+				line = append(line[:pos], line[pos+1:]...)
+			}
+		case 1: // Ctrl-A
+			pos = 0
+		case 5: // Ctrl-E
+			pos = len(line)
+		case 21: // Ctrl-U
+			line = append([]rune{}, line[pos:]...)
+			pos = 0
+		case 11: // Ctrl-K
+			line = line[:pos]
+		case 23: // Ctrl-W deletes the previous word
+			start := pos
+			for start > 0 && line[start-1] == ' ' {
+				start--
+			}
+			for start > 0 && line[start-1] != ' ' {
+				start--
+			}
+			line = append(line[:start], line[pos:]...)
+			pos = start
+		case 127, 8: // Backspace
+			if pos > 0 {
+				line = append(line[:pos-1], line[pos:]...)
+				pos--
+			}
+		case 27: // Escape sequence: arrows, Home/End, Delete
+			seq := le.readEscape()
+			switch seq {
+			case "[A": // Up
+				if histPos > 0 {
+					showHistory(histPos - 1)
+				}
+			case "[B": // Down
+				if histPos < len(le.history) {
+					showHistory(histPos + 1)
+				}
+			case "[C":
+				if pos < len(line) {
+					pos++
+				}
+			case "[D":
+				if pos > 0 {
+					pos--
+				}
+			case "[H", "[1~", "OH":
+				pos = 0
+			case "[F", "[4~", "OF":
+				pos = len(line)
+			case "[3~":
+				if pos < len(line) {
+					line = append(line[:pos], line[pos+1:]...)
+				}
+			}
+		default:
+			if r < 32 {
+				continue
+			}
+			line = append(line[:pos], append([]rune{r}, line[pos:]...)...)
+			pos++
+		}
+		redraw()
+	}
+}
+
+// readEscape reads the rest of an escape sequence after ESC, e.g. "[A"
+func (le *lineEditor) readEscape() string {
+	first, _, err := le.in.ReadRune()
+	if err != nil || (first != '[' && first != 'O') {
+		return ""
+	}
+
+	var sb strings.Builder
+	sb.WriteRune(first)
+	for {
+		r, _, err := le.in.ReadRune()
+		if err != nil {
+			return ""
+		}
+		sb.WriteRune(r)
+		// Sequences end with a letter or '~'
+		if (r >= 'A' && r <= 'Z') || (r >= 'a' && r <= 'z') || r == '~' {
+			return sb.String()
+		}
+	}
+}
@@ -1,132 +1,1551 @@
 package main
 
 import (
+	"Hippocampus/src/cdc"
 	"Hippocampus/src/client"
 	"Hippocampus/src/embedding"
+	"Hippocampus/src/serve"
+	"Hippocampus/src/snapshot"
+	"Hippocampus/src/storage"
+	"Hippocampus/src/types"
+	"Hippocampus/src/vectorfile"
+	hippoversion "Hippocampus/src/version"
+	"context"
+	"encoding/json"
 	"flag"
 	"fmt"
+	"io"
 	"log"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
 )
 
 func main() {
-	if len(os.Args) < 2 {
-		fmt.Println("Hippocampus CLI - AI Agent Memory Database (Local Version)")
-		fmt.Println()
-		fmt.Println("Usage:")
-		fmt.Println("  hippocampus insert -binary tree.bin -key <id> -text <text>")
-		fmt.Println("  hippocampus search -binary tree.bin -text <text> -epsilon 0.3 -threshold 0.5 -top-k 5")
-		fmt.Println("  hippocampus insert-csv -binary tree.bin -csv <file.csv>")
-		fmt.Println()
-		fmt.Println("Commands:")
-		fmt.Println("  insert        Store a single memory with a key")
-		fmt.Println("  search        Search for similar memories")
-		fmt.Println("  insert-csv    Bulk insert from CSV file")
-		fmt.Println()
-		fmt.Println("Global Flags:")
-		fmt.Println("  -binary       Database file path (default: tree.bin)")
-		fmt.Println("  -mock         Use mock embedder (default: true)")
-		fmt.Println("  -embed-url    Embedding service URL (default: http://localhost:8080)")
-		os.Exit(1)
-	}
+	runCLI(os.Args[1:])
+}
+
+func versionCommand(fs *flag.FlagSet) func() {
+	asJSON := fs.Bool("json", false, "print the version details as JSON")
 
-	command := os.Args[1]
+	return func() {
+		printVersion(*asJSON)
+	}
+}
 
-	switch command {
-	case "insert":
-		insertCmd := flag.NewFlagSet("insert", flag.ExitOnError)
-		binary := insertCmd.String("binary", "tree.bin", "database file")
-		useMock := insertCmd.Bool("mock", true, "use mock embedder")
-		embedURL := insertCmd.String("embed-url", "http://localhost:8080", "embedding service URL")
-		key := insertCmd.String("key", "", "key/identifier for the text")
-		text := insertCmd.String("text", "", "text to embed and store")
-		insertCmd.Parse(os.Args[2:])
+func insertCommand(fs *flag.FlagSet) func() {
+	binary := fs.String("binary", "tree.bin", "database file")
+	appendOnly := fs.Bool("append-only", false, appendOnlyUsage)
+	embedFlags := embedding.RegisterFlags(fs)
+	key := fs.String("key", "", "key/identifier for the text")
+	text := fs.String("text", "", "text to embed and store")
+	chunkSize := fs.Int("chunk-size", 0, "split texts longer than this many bytes into chunks (0 disables)")
+	chunkOverlap := fs.Int("chunk-overlap", 64, "bytes of overlap between consecutive chunks")
+	saveIndex := fs.Bool("save-index", false, "also write a .idx file so later loads skip the index rebuild")
+	asJSON := fs.Bool("json", false, "print a JSON summary on stdout; diagnostics go to stderr")
 
+	return func() {
 		if *key == "" || *text == "" {
 			log.Fatal("both -key and -text are required")
 		}
 
-		var embedder embedding.EmbeddingService
-		if *useMock {
-			embedder = embedding.NewMockEmbedder()
+		c, err := openDatabase(*binary, *appendOnly, newCachedEmbedder(embedFlags, *binary))
+		if err != nil {
+			log.Fatalf("Failed to create client: %v", err)
+		}
+		c.SetSaveIndex(*saveIndex)
+		if *asJSON {
+			c.SetLogOutput(os.Stderr)
+		}
+
+		inserted := 1
+		if *chunkSize > 0 {
+			inserted, err = c.InsertChunked(*key, *text, *chunkSize, *chunkOverlap)
+			if err == nil && !*asJSON {
+				fmt.Printf("Inserted %d chunks for %s\n", inserted, *key)
+			}
 		} else {
-			embedder = embedding.NewLocalEmbedder(*embedURL)
+			err = c.Insert(*key, *text)
+		}
+		if err == nil {
+			err = c.Flush()
+		}
+
+		if *asJSON {
+			if err != nil {
+				inserted = 0
+			}
+			printInsertSummary(inserted, 0, err)
+		} else if err != nil {
+			log.Fatalf("Insert failed: %v", err)
+		}
+	}
+}
+
+func searchCommand(fs *flag.FlagSet) func() {
+	binaries := newPathList("tree.bin")
+	fs.Var(binaries, "binary", "database file; repeat to search several (default tree.bin)")
+	binaryGlob := fs.String("binary-glob", "", "also search every database file matching this pattern, e.g. 'data/*.bin'")
+	appendOnly := fs.Bool("append-only", false, appendOnlyUsage)
+	embedFlags := embedding.RegisterFlags(fs)
+	text := fs.String("text", "", "text to search for")
+	epsilon := fs.Float64("epsilon", 0.3, "search radius (per-dimension bounding box)")
+	threshold := fs.Float64("threshold", 0.5, "similarity threshold (0.0-1.0, higher = stricter)")
+	topK := fs.Int("top-k", 5, "maximum number of results to return")
+	chunked := fs.Bool("chunked", false, "group chunk hits by their logical key")
+	asJSON := fs.Bool("json", false, "print results as a JSON array on stdout; diagnostics go to stderr")
+	failEmpty := fs.Bool("fail-empty", false, "exit with status 1 when nothing matches")
+
+	return func() {
+		if *text == "" {
+			log.Fatal("-text is required")
+		}
+		sources, err := searchSources(binaries, *binaryGlob)
+		if err != nil {
+			log.Fatal(err)
 		}
 
-		c, err := client.NewWithFileStorage(*binary, embedder)
+		if len(sources) > 1 {
+			if *chunked {
+				log.Fatal("-chunked searches a single -binary")
+			}
+			if *appendOnly {
+				log.Fatal("-append-only searches a single -binary")
+			}
+			hits, err := searchFiles(newEmbedder(embedFlags), sources, *text, float32(*epsilon), float32(*threshold), *topK)
+			if err != nil {
+				log.Fatalf("Search failed: %v", err)
+			}
+			if *asJSON {
+				json.NewEncoder(os.Stdout).Encode(hits)
+			} else {
+				printSourceHits(hits)
+			}
+			if *failEmpty && len(hits) == 0 {
+				os.Exit(1)
+			}
+			return
+		}
+
+		c, err := openDatabase(sources[0], *appendOnly, newEmbedder(embedFlags))
 		if err != nil {
 			log.Fatalf("Failed to create client: %v", err)
 		}
+		if *asJSON {
+			c.SetLogOutput(os.Stderr)
+		}
 
-		if err := c.Insert(*key, *text); err != nil {
-			log.Fatalf("Insert failed: %v", err)
+		// Hits for -json; the client prints the human-readable form itself
+		hits := []searchHit{}
+		if *chunked {
+			results, err := c.SearchChunked(*text, float32(*epsilon), float32(*threshold), *topK)
+			if err != nil {
+				log.Fatalf("Search failed: %v", err)
+			}
+			for _, r := range results {
+				hits = append(hits, searchHit{Key: r.Key, Value: r.Text, Score: r.Score, Chunk: &r.Chunk})
+			}
+		} else {
+			results, err := c.SearchScored(*text, types.SearchOptions{
+				Epsilon:   float32(*epsilon),
+				Threshold: float32(*threshold),
+				TopK:      *topK,
+			})
+			if err != nil {
+				log.Fatalf("Search failed: %v", err)
+			}
+			for _, r := range results {
+				hits = append(hits, searchHit{Key: r.Node.ID, Value: r.Node.Value, Score: r.Score})
+			}
 		}
 
-		if err := c.Flush(); err != nil {
-			log.Fatalf("Flush failed: %v", err)
+		if *asJSON {
+			json.NewEncoder(os.Stdout).Encode(hits)
+		}
+		if *failEmpty && len(hits) == 0 {
+			os.Exit(1)
 		}
+	}
+}
 
-	case "search":
-		searchCmd := flag.NewFlagSet("search", flag.ExitOnError)
-		binary := searchCmd.String("binary", "tree.bin", "database file")
-		useMock := searchCmd.Bool("mock", true, "use mock embedder")
-		embedURL := searchCmd.String("embed-url", "http://localhost:8080", "embedding service URL")
-		text := searchCmd.String("text", "", "text to search for")
-		epsilon := searchCmd.Float64("epsilon", 0.3, "search radius (per-dimension bounding box)")
-		threshold := searchCmd.Float64("threshold", 0.5, "similarity threshold (0.0-1.0, higher = stricter)")
-		topK := searchCmd.Int("top-k", 5, "maximum number of results to return")
-		searchCmd.Parse(os.Args[2:])
+func searchRadiusCommand(fs *flag.FlagSet) func() {
+	binary := fs.String("binary", "tree.bin", "database file")
+	appendOnly := fs.Bool("append-only", false, appendOnlyUsage)
+	embedFlags := embedding.RegisterFlags(fs)
+	text := fs.String("text", "", "text to search for")
+	radius := fs.Float64("radius", 0.1, "maximum distance from the text's embedding")
+	metricName := fs.String("metric", "euclidean", "distance metric: euclidean or cosine")
+	asJSON := fs.Bool("json", false, "print results as a JSON array on stdout; diagnostics go to stderr")
+	failEmpty := fs.Bool("fail-empty", false, "exit with status 1 when nothing matches")
 
+	return func() {
 		if *text == "" {
 			log.Fatal("-text is required")
 		}
+		if *radius < 0 {
+			log.Fatal("-radius must not be negative")
+		}
+		metric, err := types.ParseMetric(*metricName)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		c, err := openDatabase(*binary, *appendOnly, newEmbedder(embedFlags))
+		if err != nil {
+			log.Fatalf("Failed to create client: %v", err)
+		}
+		if *asJSON {
+			c.SetLogOutput(os.Stderr)
+		}
 
-		var embedder embedding.EmbeddingService
-		if *useMock {
-			embedder = embedding.NewMockEmbedder()
+		results, err := c.SearchRadiusMetric(*text, float32(*radius), metric)
+		if err != nil {
+			log.Fatalf("Search failed: %v", err)
+		}
+		if *asJSON {
+			hits := make([]searchHit, len(results))
+			for i, r := range results {
+				hits[i] = searchHit{Key: r.Node.ID, Value: r.Node.Value, Score: r.Score}
+			}
+			json.NewEncoder(os.Stdout).Encode(hits)
+		}
+		if *failEmpty && len(results) == 0 {
+			os.Exit(1)
+		}
+	}
+}
+
+func getCommand(fs *flag.FlagSet) func() {
+	binary := fs.String("binary", "tree.bin", "database file")
+	appendOnly := fs.Bool("append-only", false, appendOnlyUsage)
+	key := fs.String("key", "", "key of the memory")
+	asJSON := fs.Bool("json", false, "print the memory as JSON")
+
+	return func() {
+		if *key == "" {
+			log.Fatal("-key is required")
+		}
+
+		// No embedder needed: nothing is embedded
+		c, err := openDatabase(*binary, *appendOnly, nil)
+		if err != nil {
+			log.Fatalf("Failed to create client: %v", err)
+		}
+
+		node, found, err := c.Get(*key)
+		if err != nil {
+			log.Fatalf("Get failed: %v", err)
+		}
+		if !found {
+			fmt.Fprintf(os.Stderr, "key not found: %s\n", *key)
+			os.Exit(1)
+		}
+
+		if *asJSON {
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+			enc.Encode(struct {
+				Key       string    `json:"key"`
+				Value     string    `json:"value"`
+				Embedding []float32 `json:"embedding"`
+			}{node.ID, node.Value, node.Key[:]})
 		} else {
-			embedder = embedding.NewLocalEmbedder(*embedURL)
+			fmt.Println(node.Value)
+		}
+	}
+}
+
+func deleteCommand(fs *flag.FlagSet) func() {
+	binary := fs.String("binary", "tree.bin", "database file")
+	appendOnly := fs.Bool("append-only", false, appendOnlyUsage)
+	key := fs.String("key", "", "key of the memory to remove")
+
+	return func() {
+		if *key == "" {
+			log.Fatal("-key is required")
 		}
 
-		c, err := client.NewWithFileStorage(*binary, embedder)
+		// No embedder needed: nothing is embedded
+		c, err := openDatabase(*binary, *appendOnly, nil)
 		if err != nil {
 			log.Fatalf("Failed to create client: %v", err)
 		}
 
-		_, err = c.Search(*text, float32(*epsilon), float32(*threshold), *topK)
+		deleted, err := c.Delete(*key)
 		if err != nil {
-			log.Fatalf("Search failed: %v", err)
+			log.Fatalf("Delete failed: %v", err)
+		}
+		if !deleted {
+			fmt.Fprintf(os.Stderr, "key not found: %s\n", *key)
+			os.Exit(1)
+		}
+		if err := c.Flush(); err != nil {
+			log.Fatalf("Flush failed: %v", err)
 		}
 
-	case "insert-csv":
-		csvCmd := flag.NewFlagSet("insert-csv", flag.ExitOnError)
-		binary := csvCmd.String("binary", "tree.bin", "database file")
-		useMock := csvCmd.Bool("mock", true, "use mock embedder")
-		embedURL := csvCmd.String("embed-url", "http://localhost:8080", "embedding service URL")
-		csvFile := csvCmd.String("csv", "", "csv file path")
-		csvCmd.Parse(os.Args[2:])
+		count, err := c.Count()
+		if err != nil {
+			log.Fatalf("Count failed: %v", err)
+		}
+		fmt.Printf("Deleted %s (%d memories left)\n", *key, count)
+	}
+}
 
+func insertCSVCommand(fs *flag.FlagSet) func() {
+	binary := fs.String("binary", "tree.bin", "database file")
+	appendOnly := fs.Bool("append-only", false, appendOnlyUsage)
+	embedFlags := embedding.RegisterFlags(fs)
+	csvFile := fs.String("csv", "", "csv file path")
+	chunkSize := fs.Int("chunk-size", 0, "split texts longer than this many bytes into chunks (0 disables)")
+	chunkOverlap := fs.Int("chunk-overlap", 64, "bytes of overlap between consecutive chunks")
+	saveIndex := fs.Bool("save-index", false, "also write a .idx file so later loads skip the index rebuild")
+	asJSON := fs.Bool("json", false, "print a JSON summary on stdout; diagnostics go to stderr")
+	resumeFrom := fs.Int("resume-from", -1, "skip this many rows (default: resume from <csv>.progress, if any)")
+	flushEvery := fs.Int("flush-every", 1000, "flush and checkpoint after every this many rows")
+	showProgress := fs.Bool("progress", true, "show rows done, rate and ETA on stderr instead of per-row output")
+	dryRun := fs.Bool("dry-run", false, "check every row and estimate the cost, without embedding or writing anything")
+	maxText := fs.Int("max-text", 32768, "with -dry-run, report unchunked texts longer than this many bytes (0 disables)")
+	maxProblems := fs.Int("max-problems", 20, "with -dry-run, problem rows to list")
+
+	return func() {
 		if *csvFile == "" {
 			log.Fatalf("-csv is required")
 		}
+		if *flushEvery < 1 {
+			log.Fatal("-flush-every must be at least 1")
+		}
 
-		var embedder embedding.EmbeddingService
-		if *useMock {
-			embedder = embedding.NewMockEmbedder()
-		} else {
-			embedder = embedding.NewLocalEmbedder(*embedURL)
+		// Rows before the checkpoint are already in the database; rerunning
+		// the same command picks up after them
+		binaryPath := absPath(*binary)
+		if *resumeFrom < 0 {
+			rows, err := loadCheckpoint(*csvFile, binaryPath)
+			if err != nil {
+				log.Fatalf("Failed to read checkpoint: %v", err)
+			}
+			*resumeFrom = rows
+		}
+		if *resumeFrom > 0 {
+			fmt.Fprintf(os.Stderr, "Resuming after row %d\n", *resumeFrom)
 		}
 
-		c, err := client.NewWithFileStorage(*binary, embedder)
+		if *dryRun {
+			// Nothing is embedded, so no embedder is needed
+			c, err := openDatabase(*binary, *appendOnly, nil)
+			if err != nil {
+				log.Fatalf("Failed to create client: %v", err)
+			}
+			c.SetChunking(*chunkSize, *chunkOverlap)
+
+			check, err := c.CheckCSV(*csvFile, client.CSVCheckOptions{
+				ResumeFrom:   *resumeFrom,
+				MaxTextBytes: *maxText,
+				MaxProblems:  *maxProblems,
+			})
+			if err != nil {
+				log.Fatalf("CSV check failed: %v", err)
+			}
+
+			// A missing database counts as empty
+			var size int64
+			if *appendOnly {
+				info, err := os.Stat(*binary)
+				if err != nil && !os.IsNotExist(err) {
+					log.Fatalf("Failed to stat %s: %v", *binary, err)
+				}
+				if err == nil {
+					size = info.Size()
+				}
+			} else {
+				fileStat, err := storage.NewFileStorage(*binary).Stat()
+				if err != nil && !os.IsNotExist(err) {
+					log.Fatalf("Failed to stat %s: %v", *binary, err)
+				}
+				size = fileStat.Size
+			}
+			printCSVCheck(*csvFile, check, size, *asJSON)
+			if !check.Clean() {
+				os.Exit(1)
+			}
+			return
+		}
+
+		c, err := openDatabase(*binary, *appendOnly, newCachedEmbedder(embedFlags, *binary))
 		if err != nil {
 			log.Fatalf("Failed to create client: %v", err)
 		}
 
-		if err := c.InsertCSV(*csvFile); err != nil {
+		c.SetChunking(*chunkSize, *chunkOverlap)
+		c.SetSaveIndex(*saveIndex)
+		if *asJSON {
+			c.SetLogOutput(os.Stderr)
+		}
+
+		opts := client.CSVOptions{
+			ResumeFrom: *resumeFrom,
+			FlushEvery: *flushEvery,
+			OnFlush: func(rows int) {
+				if err := saveCheckpoint(*csvFile, binaryPath, rows); err != nil {
+					log.Fatalf("Failed to write checkpoint: %v", err)
+				}
+			},
+		}
+		var bar *progress
+		if *showProgress {
+			// Without a total the line shows no ETA; read errors surface in the insert
+			total, _ := countCSVRows(*csvFile)
+			bar = newProgress(total, *resumeFrom)
+			opts.OnRow = bar.update
+			c.SetVerbose(false)
+		}
+
+		stats, err := c.InsertCSVWithOptions(*csvFile, opts)
+		if bar != nil {
+			bar.done()
+		}
+		if err == nil {
+			// Finished: a rerun should start from the top again
+			os.Remove(checkpointPath(*csvFile))
+		}
+		if *asJSON {
+			printInsertSummary(stats.Inserted, stats.Duplicates, err)
+		} else if err != nil {
 			log.Fatalf("CSV insert failed: %v", err)
 		}
+	}
+}
+
+func insertStdinCommand(fs *flag.FlagSet) func() {
+	binary := fs.String("binary", "tree.bin", "database file")
+	appendOnly := fs.Bool("append-only", false, appendOnlyUsage)
+	embedFlags := embedding.RegisterFlags(fs)
+	keyPrefix := fs.String("key-prefix", "", "prefix for every key")
+	keyMode := fs.String("key-mode", "line", "generated keys: line (line number) or hash (of the text)")
+	tsv := fs.Bool("tsv", false, "lines are key<TAB>text instead of plain text")
+	batchSize := fs.Int("batch-size", 32, "lines embedded concurrently")
+	maxLine := fs.Int("max-line", 0, "lines longer than this many bytes are rejected, or chunked with -chunk-long (0 disables)")
+	chunkLong := fs.Bool("chunk-long", false, "chunk lines longer than -max-line instead of rejecting them")
+	chunkOverlap := fs.Int("chunk-overlap", 64, "bytes of overlap between consecutive chunks")
+	saveIndex := fs.Bool("save-index", false, "also write a .idx file so later loads skip the index rebuild")
+	asJSON := fs.Bool("json", false, "print a JSON summary on stdout; diagnostics go to stderr")
 
-	default:
-		log.Fatalf("unknown command: %s\nRun 'hippocampus' with no arguments for usage", command)
+	return func() {
+		if *keyMode != "line" && *keyMode != "hash" {
+			log.Fatalf("unknown -key-mode %q (expected line or hash)", *keyMode)
+		}
+		if *batchSize < 1 {
+			log.Fatal("-batch-size must be at least 1")
+		}
+		if *chunkLong && *maxLine <= 0 {
+			log.Fatal("-chunk-long needs -max-line")
+		}
+
+		c, err := openDatabase(*binary, *appendOnly, newCachedEmbedder(embedFlags, *binary))
+		if err != nil {
+			log.Fatalf("Failed to create client: %v", err)
+		}
+		c.SetVerbose(false)
+		c.SetSaveIndex(*saveIndex)
+
+		stats, err := insertStdin(c, os.Stdin, stdinOptions{
+			tsv:       *tsv,
+			keyPrefix: *keyPrefix,
+			keyMode:   *keyMode,
+			batchSize: *batchSize,
+			maxLine:   *maxLine,
+			chunkLong: *chunkLong,
+			overlap:   *chunkOverlap,
+		})
+		// Whatever was inserted before an error is kept
+		if flushErr := c.Flush(); err == nil {
+			err = flushErr
+		}
+
+		if *asJSON {
+			printInsertSummary(stats.inserted, stats.duplicates, err)
+		} else if err != nil {
+			log.Fatalf("Insert failed after %d memories: %v", stats.inserted, err)
+		} else {
+			fmt.Printf("Inserted %d memories into %s (%d duplicates, %d rejected)\n",
+				stats.inserted, *binary, stats.duplicates, stats.rejected)
+		}
+	}
+}
+
+func snapshotCommand(fs *flag.FlagSet) func() {
+	binary := fs.String("binary", "tree.bin", "database file")
+	out := fs.String("out", "", "backup file to write")
+
+	return func() {
+		if *out == "" {
+			log.Fatal("-out is required")
+		}
+
+		// No embedder needed: nothing is embedded
+		c, err := client.NewWithFileStorage(*binary, nil)
+		if err != nil {
+			log.Fatalf("Failed to create client: %v", err)
+		}
+
+		f, err := os.Create(*out)
+		if err != nil {
+			log.Fatalf("Failed to create %s: %v", *out, err)
+		}
+
+		if err := c.Snapshot(f); err != nil {
+			f.Close()
+			log.Fatalf("Snapshot failed: %v", err)
+		}
+		if err := f.Close(); err != nil {
+			log.Fatalf("Snapshot failed: %v", err)
+		}
+
+		fmt.Printf("Snapshot of %s written to %s\n", *binary, *out)
+	}
+}
+
+func restoreCommand(fs *flag.FlagSet) func() {
+	binary := fs.String("binary", "tree.bin", "database file")
+	from := fs.String("from", "", "backup file to restore")
+
+	return func() {
+		if *from == "" {
+			log.Fatal("-from is required")
+		}
+
+		c, err := client.NewWithFileStorage(*binary, nil)
+		if err != nil {
+			log.Fatalf("Failed to create client: %v", err)
+		}
+
+		f, err := os.Open(*from)
+		if err != nil {
+			log.Fatalf("Failed to open %s: %v", *from, err)
+		}
+		defer f.Close()
+
+		if err := c.Restore(f); err != nil {
+			log.Fatalf("Restore failed: %v", err)
+		}
+
+		if err := c.Flush(); err != nil {
+			log.Fatalf("Flush failed: %v", err)
+		}
+
+		fmt.Printf("Restored %s from %s\n", *binary, *from)
+	}
+}
+
+func restoreSnapshotCommand(fs *flag.FlagSet) func() {
+	target := snapshot.RegisterTargetFlags(fs)
+	dataDir := fs.String("data-dir", "", "server data directory to rebuild (the server must be stopped)")
+	set := fs.String("set", snapshot.Latest, "snapshot set to restore, or latest")
+	list := fs.Bool("list", false, "list the snapshot sets, newest first, and exit")
+	replace := fs.Bool("replace", false, "overwrite a data directory that already holds agents")
+
+	return func() {
+		store, err := target.Store()
+		if err != nil {
+			log.Fatal(err)
+		}
+		if store == nil {
+			log.Fatal("-snapshot-dir or -snapshot-s3 is required")
+		}
+		ctx := context.Background()
+
+		if *list {
+			sets, err := snapshot.Sets(ctx, store)
+			if err != nil {
+				log.Fatalf("Failed to list snapshots: %v", err)
+			}
+			for _, s := range sets {
+				fmt.Println(s)
+			}
+			return
+		}
+
+		if *dataDir == "" {
+			log.Fatal("-data-dir is required")
+		}
+		manifest, err := snapshot.Restore(ctx, store, *set, *dataDir, *replace)
+		if err != nil {
+			log.Fatalf("Restore failed: %v", err)
+		}
+		fmt.Printf("Restored %d agents to %s from snapshot %s\n", len(manifest.Agents), *dataDir, manifest.Set)
+	}
+}
+
+func cdcTailCommand(fs *flag.FlagSet) func() {
+	path := fs.String("log", "", "change log written by a server's -cdc-log")
+	fromSeq := fs.Uint64("from-seq", 0, "first sequence number to print (default: the oldest kept)")
+	follow := fs.Bool("follow", false, "keep printing changes as they are written, until interrupted")
+
+	return func() {
+		if *path == "" {
+			log.Fatal("-log is required")
+		}
+
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+
+		enc := json.NewEncoder(os.Stdout)
+		err := cdc.Tail(ctx, *path, cdc.TailOptions{FromSeq: *fromSeq, Follow: *follow}, func(r *cdc.Record) error {
+			return enc.Encode(r)
+		})
+		if err != nil {
+			log.Fatalf("Reading %s failed: %v", *path, err)
+		}
+	}
+}
+
+func shardCommand(fs *flag.FlagSet) func() {
+	binary := fs.String("binary", "tree.bin", "database file")
+	shards := fs.Int("shards", 8, "number of shard files")
+	outDir := fs.String("out-dir", "shards", "directory for shard_N.bin files")
+
+	return func() {
+		if *shards < 1 {
+			log.Fatal("-shards must be at least 1")
+		}
+
+		tree, err := storage.NewFileStorage(*binary).Load()
+		if err != nil {
+			log.Fatalf("Failed to load %s: %v", *binary, err)
+		}
+
+		if err := storage.NewShardedFileStorage(*outDir, *shards).Save(tree); err != nil {
+			log.Fatalf("Sharding failed: %v", err)
+		}
+
+		fmt.Printf("Split %d nodes from %s into %d shards in %s\n", len(tree.Nodes), *binary, *shards, *outDir)
+	}
+}
+
+func diffCommand(fs *flag.FlagSet) func() {
+	a := fs.String("a", "", "first database file")
+	b := fs.String("b", "", "second database file")
+	format := fs.String("format", "table", "output format: table or json")
+
+	return func() {
+		if *a == "" || *b == "" {
+			log.Fatal("both -a and -b are required")
+		}
+		if *format != "table" && *format != "json" {
+			log.Fatalf("unknown -format %q (expected table or json)", *format)
+		}
+
+		treeA, err := storage.NewFileStorage(*a).Load()
+		if err != nil {
+			log.Fatalf("Failed to load %s: %v", *a, err)
+		}
+		treeB, err := storage.NewFileStorage(*b).Load()
+		if err != nil {
+			log.Fatalf("Failed to load %s: %v", *b, err)
+		}
+
+		printDiff(storage.Diff(treeA, treeB), *a, *b, *format)
+	}
+}
+
+func migrateCommand(fs *flag.FlagSet) func() {
+	in := fs.String("in", "", "database file to migrate; its format is read from the header")
+	out := fs.String("out", "", "file to write in the newest format")
+	inPlace := fs.Bool("in-place", false, "migrate -in in place instead of writing -out")
+	withIndex := fs.Bool("index", true, "also write a .idx file so loads skip the index rebuild")
+
+	return func() {
+		if *in == "" {
+			log.Fatal("-in is required")
+		}
+		switch {
+		case *inPlace && *out != "" && absPath(*out) != absPath(*in):
+			log.Fatal("-in-place and -out are mutually exclusive")
+		case *inPlace:
+			*out = *in
+		case *out == "":
+			log.Fatal("-out is required (or -in-place to replace -in)")
+		case absPath(*out) == absPath(*in):
+			log.Fatal("-out is the input file; pass -in-place to migrate it in place")
+		}
+
+		report, err := storage.MigrateFile(*in, *out, 0, storage.CurrentFormatVersion, *withIndex)
+		if err != nil {
+			log.Fatalf("Migrate failed: %v", err)
+		}
+		if report.Unchanged {
+			fmt.Printf("%s is already format v%d with nothing to add (%d nodes)\n", *in, report.ToVersion, report.Nodes)
+			return
+		}
+		fmt.Printf("Migrated %d nodes from %s (v%d) to %s (v%d)\n", report.Nodes, *in, report.FromVersion, *out, report.ToVersion)
+		for _, feature := range report.Added {
+			fmt.Printf("  added: %s\n", feature)
+		}
+	}
+}
+
+func prefetchCommand(fs *flag.FlagSet) func() {
+	binary := fs.String("binary", "tree.bin", "database the embeddings are for")
+	embedFlags := embedding.RegisterFlags(fs)
+	textFile := fs.String("text-file", "", "file with one text per line")
+
+	return func() {
+		if *textFile == "" {
+			log.Fatal("-text-file is required")
+		}
+		texts, err := readTextFile(*textFile)
+		if err != nil {
+			log.Fatalf("Failed to read texts: %v", err)
+		}
+
+		// Nothing is inserted, so the database itself is never loaded
+		cache := newCachedEmbedder(embedFlags, *binary)
+		c, err := client.NewWithFileStorage(*binary, cache)
+		if err != nil {
+			log.Fatalf("Failed to create client: %v", err)
+		}
+
+		// Keep what was embedded even when some texts failed
+		prefetchErr := c.Prefetch(context.Background(), texts)
+		if err := cache.SaveFile(embedCachePath(*binary)); err != nil {
+			log.Fatalf("Failed to save %s: %v", embedCachePath(*binary), err)
+		}
+		stats := cache.Stats()
+		fmt.Printf("Prefetched %d texts (%d already cached); %s holds %d embeddings\n",
+			len(texts), stats.Hits, embedCachePath(*binary), stats.Entries)
+		if prefetchErr != nil {
+			log.Fatalf("Prefetch incomplete: %v", prefetchErr)
+		}
+	}
+}
+
+func embedCommand(fs *flag.FlagSet) func() {
+	embedFlags := embedding.RegisterFlags(fs)
+	text := fs.String("text", "", "text to embed")
+	summary := fs.Bool("summary", false, "print only the dimensions, norm and value range")
+
+	return func() {
+		if *text == "" {
+			log.Fatal("-text is required")
+		}
+
+		vec, err := newEmbedder(embedFlags).GetEmbedding(context.Background(), *text)
+		if err != nil {
+			embeddingFailed(err)
+		}
+		printEmbedding(vec, *summary)
+	}
+}
+
+func similarityCommand(fs *flag.FlagSet) func() {
+	embedFlags := embedding.RegisterFlags(fs)
+	a := fs.String("a", "", "first text")
+	b := fs.String("b", "", "second text")
+
+	return func() {
+		if *a == "" || *b == "" {
+			log.Fatal("both -a and -b are required")
+		}
+
+		embedder := newEmbedder(embedFlags)
+		vecA, err := embedder.GetEmbedding(context.Background(), *a)
+		if err != nil {
+			embeddingFailed(err)
+		}
+		vecB, err := embedder.GetEmbedding(context.Background(), *b)
+		if err != nil {
+			embeddingFailed(err)
+		}
+
+		similarity, err := cosineSimilarity(vecA, vecB)
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("%.6f\n", similarity)
+	}
+}
+
+func compactCommand(fs *flag.FlagSet) func() {
+	binary := fs.String("binary", "tree.bin", "database file")
+
+	return func() {
+		report, err := storage.Compact(*binary)
+		if err != nil {
+			log.Fatalf("Compact failed: %v", err)
+		}
+		fmt.Printf("Compacted %s: %d nodes, %d duplicates dropped, %d -> %d bytes\n",
+			*binary, report.Nodes, report.Duplicates, report.SizeBefore, report.SizeAfter)
+		if report.Upgraded {
+			fmt.Printf("Upgraded to format v%d\n", storage.CurrentFormatVersion)
+		}
+	}
+}
+
+func watchCommand(fs *flag.FlagSet) func() {
+	file := fs.String("file", "", "file to tail, one memory per line")
+	binary := fs.String("binary", "tree.bin", "database file")
+	embedFlags := embedding.RegisterFlags(fs)
+	raw := fs.Bool("raw", false, "lines are plain text with generated keys instead of {\"key\",\"text\"} JSON")
+	keyPrefix := fs.String("key-prefix", "", "prefix for every key")
+	batchSize := fs.Int("batch-size", 32, "lines embedded concurrently")
+	poll := fs.Duration("poll", time.Second, "how often to check the file for new lines")
+	flushInterval := fs.Duration("flush-interval", 10*time.Second, "how often to flush the database and record the offset")
+	saveIndex := fs.Bool("save-index", false, "also write a .idx file so later loads skip the index rebuild")
+
+	return func() {
+		if *file == "" {
+			log.Fatal("-file is required")
+		}
+		if *batchSize < 1 {
+			log.Fatal("-batch-size must be at least 1")
+		}
+		if *poll <= 0 || *flushInterval <= 0 {
+			log.Fatal("-poll and -flush-interval must be positive")
+		}
+
+		c, err := client.NewWithFileStorage(*binary, newCachedEmbedder(embedFlags, *binary))
+		if err != nil {
+			log.Fatalf("Failed to create client: %v", err)
+		}
+		c.SetVerbose(false)
+		c.SetSaveIndex(*saveIndex)
+
+		// Ctrl-C flushes and records the offset before exiting
+		stop := make(chan os.Signal, 1)
+		signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+
+		log.Printf("Watching %s into %s", *file, *binary)
+		stats, err := watchFile(c, *file, absPath(*binary), watchOptions{
+			raw:           *raw,
+			keyPrefix:     *keyPrefix,
+			batchSize:     *batchSize,
+			poll:          *poll,
+			flushInterval: *flushInterval,
+		}, stop)
+		if err != nil {
+			log.Fatalf("Watch failed after %d memories: %v", stats.inserted, err)
+		}
+		fmt.Printf("Inserted %d memories into %s (%d duplicates)\n", stats.inserted, *binary, stats.duplicates)
+	}
+}
+
+func rotateCommand(fs *flag.FlagSet) func() {
+	binary := fs.String("binary", "tree.bin", "database file")
+	archiveDir := fs.String("archive-dir", "", "directory to move the current file into")
+
+	return func() {
+		if *archiveDir == "" {
+			log.Fatal("-archive-dir is required")
+		}
+
+		// No embedder needed: nothing is embedded
+		c, err := client.NewWithFileStorage(*binary, nil)
+		if err != nil {
+			log.Fatalf("Failed to create client: %v", err)
+		}
+		c.SetVerbose(false)
+		nodes, err := c.Count()
+		if err != nil {
+			log.Fatalf("Failed to load %s: %v", *binary, err)
+		}
+		if err := c.Rotate(*archiveDir); err != nil {
+			log.Fatalf("Rotate failed: %v", err)
+		}
+		fmt.Printf("Archived %d memories from %s into %s; %s is now empty\n", nodes, *binary, *archiveDir, *binary)
+	}
+}
+
+func verifyCommand(fs *flag.FlagSet) func() {
+	binary := fs.String("binary", "tree.bin", "database file")
+	asJSON := fs.Bool("json", false, "print the report as JSON")
+
+	return func() {
+		report, err := storage.Verify(*binary)
+		if err != nil {
+			log.Fatalf("Verify failed: %v", err)
+		}
+		printVerify(*binary, report, *asJSON)
+		if !report.OK() {
+			os.Exit(1)
+		}
+	}
+}
+
+func repairCommand(fs *flag.FlagSet) func() {
+	binary := fs.String("binary", "tree.bin", "damaged database file (left unchanged)")
+	out := fs.String("out", "", "file to write the recovered nodes to")
+
+	return func() {
+		if *out == "" {
+			log.Fatal("-out is required")
+		}
+		if absPath(*out) == absPath(*binary) {
+			log.Fatal("-out must differ from -binary; the damaged file is kept")
+		}
+
+		tree, report, err := storage.Salvage(*binary)
+		if err != nil {
+			log.Fatalf("Repair failed: %v", err)
+		}
+		if err := storage.NewFileStorage(*out).Save(tree); err != nil {
+			log.Fatalf("Failed to write %s: %v", *out, err)
+		}
+
+		fmt.Printf("Salvaged %d of %d declared nodes into %s\n", report.Salvaged, report.NodeCount, *out)
+		if report.Dropped > 0 {
+			fmt.Printf("Dropped %d nodes with a bad or duplicate embedding\n", report.Dropped)
+		}
+		if report.StoppedBy != "" {
+			fmt.Printf("Stopped at %s\n", report.StoppedBy)
+		}
+	}
+}
+
+func mergeCommand(fs *flag.FlagSet) func() {
+	a := fs.String("a", "", "first database file")
+	b := fs.String("b", "", "second database file, merged into the first")
+	out := fs.String("out", "", "file to write the merged database to")
+	policy := fs.String("policy", "keep-newer", "for keys in both: keep-a, keep-b, keep-both (b's under key~N) or keep-newer (from the later-modified file)")
+
+	return func() {
+		if *a == "" || *b == "" || *out == "" {
+			log.Fatal("-a, -b and -out are required")
+		}
+		mergePolicy, err := parseMergePolicy(*policy, *a, *b)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		treeA, err := storage.NewFileStorage(*a).Load()
+		if err != nil {
+			log.Fatalf("Failed to load %s: %v", *a, err)
+		}
+		// Seeded with a's tree, the client writes the result to -out on Flush
+		merged, err := client.NewWithFileStorage(*out, nil, client.WithInitialTree(treeA))
+		if err != nil {
+			log.Fatalf("Failed to create client: %v", err)
+		}
+		other, err := client.NewWithFileStorage(*b, nil)
+		if err != nil {
+			log.Fatalf("Failed to create client: %v", err)
+		}
+
+		merged.SetMergePolicy(mergePolicy)
+		added, err := merged.Merge(other)
+		if err != nil {
+			log.Fatalf("Merge failed: %v", err)
+		}
+		if err := merged.Flush(); err != nil {
+			log.Fatalf("Failed to write %s: %v", *out, err)
+		}
+
+		count, err := merged.Count()
+		if err != nil {
+			log.Fatalf("Count failed: %v", err)
+		}
+		fmt.Printf("Merged %s into %s (%s): %d memories added, %d in %s\n", *b, *a, *policy, added, count, *out)
+	}
+}
+
+func exportCommand(fs *flag.FlagSet) func() {
+	binary := fs.String("binary", "tree.bin", "database file")
+	format := fs.String("format", "jsonl", "output format: jsonl or csv")
+	withEmbeddings := fs.Bool("with-embeddings", false, "include each memory's embedding")
+	out := fs.String("out", "", "file to write (default: stdout)")
+	modelVersion := fs.String("model-version", "", "tag exported embeddings with the model that produced them (jsonl)")
+
+	return func() {
+		if *format != "jsonl" && *format != "csv" {
+			log.Fatalf("unknown -format %q (expected jsonl or csv)", *format)
+		}
+
+		tree, err := storage.NewFileStorage(*binary).Load()
+		if err != nil {
+			log.Fatalf("Failed to load %s: %v", *binary, err)
+		}
+
+		w := os.Stdout
+		if *out != "" {
+			w, err = os.Create(*out)
+			if err != nil {
+				log.Fatalf("Failed to create %s: %v", *out, err)
+			}
+		}
+
+		if err := exportTree(w, tree, *format, *withEmbeddings, *modelVersion); err != nil {
+			log.Fatalf("Export failed: %v", err)
+		}
+		if *out != "" {
+			if err := w.Close(); err != nil {
+				log.Fatalf("Export failed: %v", err)
+			}
+			fmt.Printf("Exported %d memories from %s to %s\n", len(tree.Nodes), *binary, *out)
+		}
+	}
+}
+
+func importCommand(fs *flag.FlagSet) func() {
+	binary := fs.String("binary", "tree.bin", "database file")
+	appendOnly := fs.Bool("append-only", false, appendOnlyUsage)
+	in := fs.String("in", "", "file to import")
+	format := fs.String("format", "jsonl", "input format: jsonl")
+	modelVersion := fs.String("model-version", "", "refuse embeddings tagged with a different model")
+	embedFlags := embedding.RegisterFlags(fs)
+
+	return func() {
+		if *in == "" {
+			log.Fatal("-in is required")
+		}
+		if *format != "jsonl" {
+			log.Fatalf("unknown -format %q (only jsonl can be imported)", *format)
+		}
+
+		// Only records exported without -with-embeddings reach the embedder
+		c, err := openDatabase(*binary, *appendOnly, newEmbedder(embedFlags))
+		if err != nil {
+			log.Fatalf("Failed to create client: %v", err)
+		}
+		c.SetModelVersion(*modelVersion)
+
+		f, err := os.Open(*in)
+		if err != nil {
+			log.Fatalf("Failed to open %s: %v", *in, err)
+		}
+		defer f.Close()
+
+		imported, skipped, err := importJSONL(c, f)
+		if err != nil {
+			log.Fatalf("Import failed after %d memories: %v", imported, err)
+		}
+		if err := c.Flush(); err != nil {
+			log.Fatalf("Flush failed: %v", err)
+		}
+
+		fmt.Printf("Imported %d memories into %s (%d duplicates skipped)\n", imported, *binary, skipped)
+	}
+}
+
+func importVectorsCommand(fs *flag.FlagSet) func() {
+	binary := fs.String("binary", "tree.bin", "database file")
+	appendOnly := fs.Bool("append-only", false, appendOnlyUsage)
+	format := fs.String("format", "faiss-flat", "input format: faiss-flat or npy")
+	index := fs.String("index", "", "FAISS index or .npy file to import")
+	meta := fs.String("meta", "", "JSONL sidecar with the id and text of each vector, in order")
+
+	return func() {
+		if *index == "" || *meta == "" {
+			log.Fatal("-index and -meta are required")
+		}
+
+		// Everything is checked before the database is touched
+		v, metas, err := readVectors(*format, *index, *meta)
+		if err != nil {
+			log.Fatalf("Import failed: %v", err)
+		}
+
+		// No embedder needed: the vectors are stored as given
+		c, err := openDatabase(*binary, *appendOnly, nil)
+		if err != nil {
+			log.Fatalf("Failed to create client: %v", err)
+		}
+
+		imported, skipped, err := importVectors(c, v, metas)
+		if err != nil {
+			log.Fatalf("Import failed after %d vectors: %v", imported, err)
+		}
+		if err := c.Flush(); err != nil {
+			log.Fatalf("Flush failed: %v", err)
+		}
+
+		fmt.Printf("Imported %d vectors into %s (%d duplicates skipped)\n", imported, *binary, skipped)
+	}
+}
+
+func exportVectorsCommand(fs *flag.FlagSet) func() {
+	binary := fs.String("binary", "tree.bin", "database file")
+	format := fs.String("format", "faiss-flat", "output format: faiss-flat or npy")
+	out := fs.String("out", "", "file to write the vectors to")
+	meta := fs.String("meta", "", "JSONL sidecar to write (default: -out with .jsonl in place of its extension)")
+	metric := fs.String("metric", "l2", "FAISS metric: l2 or ip (faiss-flat)")
+
+	return func() {
+		if *out == "" {
+			log.Fatal("-out is required")
+		}
+		var m vectorfile.Metric
+		switch *metric {
+		case "l2":
+			m = vectorfile.MetricL2
+		case "ip":
+			m = vectorfile.MetricInnerProduct
+		default:
+			log.Fatalf("unknown -metric %q (expected l2 or ip)", *metric)
+		}
+		if *format != "faiss-flat" && *format != "npy" {
+			log.Fatalf("unknown -format %q (expected faiss-flat or npy)", *format)
+		}
+		if *meta == "" {
+			*meta = strings.TrimSuffix(*out, filepath.Ext(*out)) + ".jsonl"
+		}
+		if *meta == *out {
+			log.Fatal("-meta must differ from -out")
+		}
+
+		tree, err := storage.NewFileStorage(*binary).Load()
+		if err != nil {
+			log.Fatalf("Failed to load %s: %v", *binary, err)
+		}
+		v, metas := treeVectors(tree)
+
+		write := func(path string, fn func(io.Writer) error) {
+			f, err := os.Create(path)
+			if err != nil {
+				log.Fatalf("Failed to create %s: %v", path, err)
+			}
+			if err := fn(f); err != nil {
+				f.Close()
+				log.Fatalf("Export failed: %v", err)
+			}
+			if err := f.Close(); err != nil {
+				log.Fatalf("Export failed: %v", err)
+			}
+		}
+		write(*out, func(w io.Writer) error {
+			if *format == "npy" {
+				return vectorfile.WriteNPY(w, v)
+			}
+			return vectorfile.WriteFAISSFlat(w, v, m)
+		})
+		write(*meta, func(w io.Writer) error { return vectorfile.WriteMeta(w, metas) })
+
+		fmt.Printf("Exported %d vectors from %s to %s (sidecar %s)\n", v.Len(), *binary, *out, *meta)
+	}
+}
+
+func statsCommand(fs *flag.FlagSet) func() {
+	binary := fs.String("binary", "tree.bin", "database file")
+	asJSON := fs.Bool("json", false, "print the stats as JSON")
+
+	return func() {
+		fs := storage.NewFileStorage(*binary)
+		fileStat, err := fs.Stat()
+		if err != nil {
+			if os.IsNotExist(err) {
+				fmt.Fprintf(os.Stderr, "no database file at %s\n", *binary)
+				os.Exit(2)
+			}
+			log.Fatalf("Failed to read %s: %v", *binary, err)
+		}
+
+		// No embedder needed: nothing is embedded
+		c, err := client.NewWithFileStorage(*binary, nil)
+		if err != nil {
+			log.Fatalf("Failed to create client: %v", err)
+		}
+		treeStats, err := c.Stats()
+		if err != nil {
+			log.Fatalf("Failed to load %s: %v", *binary, err)
+		}
+
+		printStats(*binary, fileStat, treeStats, *asJSON)
+	}
+}
+
+func replCommand(fs *flag.FlagSet) func() {
+	binary := fs.String("binary", "tree.bin", "database file")
+	appendOnly := fs.Bool("append-only", false, appendOnlyUsage)
+	embedFlags := embedding.RegisterFlags(fs)
+
+	return func() {
+		c, err := openDatabase(*binary, *appendOnly, newEmbedder(embedFlags))
+		if err != nil {
+			log.Fatalf("Failed to create client: %v", err)
+		}
+		if err := runREPL(*binary, c); err != nil {
+			log.Fatalf("REPL failed: %v", err)
+		}
+	}
+}
+
+func benchCommand(fs *flag.FlagSet) func() {
+	binary := fs.String("binary", "tree.bin", "database to start from; a copy is used so it is left unchanged")
+	embedFlags := embedding.RegisterFlags(fs)
+	inserts := fs.Int("inserts", 1000, "number of inserts")
+	searches := fs.Int("searches", 1000, "number of searches")
+	workers := fs.Int("workers", 4, "concurrent workers")
+	sample := fs.String("sample", "", "take texts from the lines of this file instead of generating them")
+	epsilon := fs.Float64("epsilon", 0.3, "search epsilon")
+	threshold := fs.Float64("threshold", 0.5, "search similarity threshold")
+	topK := fs.Int("top-k", 5, "search result count")
+	seed := fs.Int64("seed", 1, "random seed for texts and queries")
+	keep := fs.Bool("keep", false, "keep the benchmark database instead of deleting it")
+	asJSON := fs.Bool("json", false, "print the report as JSON")
+	treeOnly := fs.Bool("tree", false, "time tree inserts of random embeddings alone, sharded locking against one mutex")
+	treeBase := fs.Int("tree-base", 10000, "with -tree or -nearest, nodes in the indexed tree")
+	nearest := fs.Bool("nearest", false, "time single nearest-match searches of random embeddings, NearestNeighbor against search with top-k 1")
+
+	return func() {
+		if *workers < 1 {
+			log.Fatal("-workers must be at least 1")
+		}
+		if *inserts < 0 || *searches < 0 {
+			log.Fatal("-inserts and -searches can't be negative")
+		}
+
+		if *treeOnly || *nearest {
+			if *treeBase < 0 {
+				log.Fatal("-tree-base can't be negative")
+			}
+			if *nearest {
+				search := types.SearchOptions{Epsilon: float32(*epsilon), Threshold: float32(*threshold)}
+				printNearestBench(runNearestBench(*treeBase, *searches, *workers, search, *seed), *treeBase, *asJSON)
+				return
+			}
+			printTreeBench(runTreeBench(*treeBase, *inserts, *workers, *seed), *treeBase, *asJSON)
+			return
+		}
+
+		opts := benchOptions{
+			inserts:  *inserts,
+			searches: *searches,
+			workers:  *workers,
+			search:   types.SearchOptions{Epsilon: float32(*epsilon), Threshold: float32(*threshold), TopK: *topK},
+			seed:     *seed,
+		}
+		if *sample != "" {
+			texts, err := readSampleTexts(*sample)
+			if err != nil {
+				log.Fatalf("Failed to read samples: %v", err)
+			}
+			opts.texts = texts
+		}
+
+		tmp, err := os.CreateTemp("", "hippocampus-bench-*.bin")
+		if err != nil {
+			log.Fatalf("Failed to create benchmark database: %v", err)
+		}
+		tmp.Close()
+		if !*keep {
+			defer os.Remove(tmp.Name())
+		}
+		if err := copyFile(tmp.Name(), *binary); err != nil {
+			log.Fatalf("Failed to copy %s: %v", *binary, err)
+		}
+
+		c, err := client.NewWithFileStorage(tmp.Name(), newEmbedder(embedFlags))
+		if err != nil {
+			log.Fatalf("Failed to create client: %v", err)
+		}
+		c.SetVerbose(false)
+
+		report, err := runBench(c, opts)
+		report.Binary = tmp.Name()
+		if err != nil {
+			log.Fatalf("Benchmark failed: %v", err)
+		}
+		printBenchReport(report, *asJSON)
+		if *keep && !*asJSON {
+			fmt.Printf("Kept %s\n", tmp.Name())
+		}
+	}
+}
+
+// appendOnlyUsage describes -append-only, taken by the commands that read or
+// write memories through a client
+const appendOnlyUsage = "the database is an append-only log: memories are never deleted or overwritten"
+
+// openDatabase returns a client on the database at path, read as an
+// append-only log with appendOnly
+func openDatabase(path string, appendOnly bool, embedder embedding.EmbeddingService) (*client.Client, error) {
+	if appendOnly {
+		return client.NewWithStorage(storage.NewAppendOnlyFileStorage(path), embedder)
+	}
+	return client.NewWithFileStorage(path, embedder)
+}
+
+func serveCommand(args []string) {
+	if err := serve.RunWithParser("serve", args, parseFlags); err != nil {
+		log.Fatalf("Server error: %v", err)
+	}
+}
+
+func serveHTTPCommand(args []string) {
+	if err := serve.RunHTTPWithParser("serve-http", args, parseFlags); err != nil {
+		log.Fatalf("Server error: %v", err)
+	}
+}
+
+func serveMCPCommand(args []string) {
+	if err := serve.RunMCPWithParser("serve-mcp", args, parseFlags); err != nil {
+		log.Fatalf("MCP server error: %v", err)
+	}
+}
+
+// configShowCommand prints the settings as resolved, so it parses its flags
+// without filling them from the environment and config file
+func configShowCommand(fs *flag.FlagSet) func() {
+	fs.String("binary", "tree.bin", "database file")
+	embedding.RegisterFlags(fs)
+
+	return func() {
+		values, err := resolveSettings(fs, fs.Lookup("config").Value.String())
+		if err != nil {
+			log.Fatal(err)
+		}
+		printConfig(values)
+	}
+}
+
+// searchHit is one result in search -json output
+type searchHit struct {
+	Key    string  `json:"key"`
+	Value  string  `json:"value"`
+	Score  float32 `json:"score"`
+	Chunk  *int    `json:"chunk,omitempty"`  // Only with -chunked
+	Source string  `json:"source,omitempty"` // Only when searching several files
+}
+
+// printInsertSummary prints the -json summary of insert and insert-csv,
+// exiting with status 1 if err is set
+func printInsertSummary(inserted, duplicates int, err error) {
+	summary := struct {
+		Inserted   int    `json:"inserted"`
+		Duplicates int    `json:"duplicates"`
+		Errors     int    `json:"errors"`
+		Error      string `json:"error,omitempty"`
+	}{Inserted: inserted, Duplicates: duplicates}
+	if err != nil {
+		summary.Errors = 1
+		summary.Error = err.Error()
+	}
+
+	json.NewEncoder(os.Stdout).Encode(summary)
+	if err != nil {
+		os.Exit(1)
+	}
+}
+
+// printCSVCheck prints the insert-csv -dry-run output. fileSize is the
+// current size of the database file.
+func printCSVCheck(path string, check client.CSVCheck, fileSize int64, asJSON bool) {
+	if asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(struct {
+			Path string `json:"path"`
+			client.CSVCheck
+			FileSize          int64 `json:"file_size"`
+			EstimatedFileSize int64 `json:"estimated_file_size"`
+			Clean             bool  `json:"clean"`
+		}{path, check, fileSize, fileSize + check.AddedBytes, check.Clean()})
+		return
+	}
+
+	fmt.Printf("Dry run of %s: %d rows, %d with problems\n", path, check.Rows, check.Problems)
+	for _, p := range check.FirstProblems {
+		if p.Key != "" {
+			fmt.Printf("  line %d (key %q): %s\n", p.Line, p.Key, p.Reason)
+		} else {
+			fmt.Printf("  line %d: %s\n", p.Line, p.Reason)
+		}
+	}
+	if more := check.Problems - len(check.FirstProblems); more > 0 {
+		fmt.Printf("  ... and %d more\n", more)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "Embedding calls:\t%d\n", check.EmbeddingCalls)
+	fmt.Fprintf(w, "File size:\t%d bytes now, about %d after import\n", fileSize, fileSize+check.AddedBytes)
+	w.Flush()
+
+	if check.Clean() {
+		fmt.Println("The file would import cleanly; nothing was embedded or written.")
+	} else {
+		fmt.Println("The file would not import cleanly; nothing was embedded or written.")
+	}
+}
+
+// writerOrUnknown describes the writer recorded in a file header, which
+// files older than format version 3 lack
+func writerOrUnknown(writer string) string {
+	if writer == "" {
+		return "unknown (not recorded before format v3)"
+	}
+	return writer
+}
+
+// printVersion prints the version command output
+func printVersion(asJSON bool) {
+	build := hippoversion.Get()
+	formats := make([]string, len(storage.SupportedFormatVersions))
+	for i, v := range storage.SupportedFormatVersions {
+		formats[i] = fmt.Sprintf("v%d", v)
+	}
+
+	if asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(struct {
+			hippoversion.Info
+			FormatVersions []uint32 `json:"format_versions"`
+			FormatWritten  uint32   `json:"format_written"`
+		}{build, storage.SupportedFormatVersions, storage.CurrentFormatVersion})
+		return
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "hippocampus %s\n", build.Version)
+	fmt.Fprintf(w, "Commit:\t%s\n", build.Commit)
+	fmt.Fprintf(w, "Built:\t%s\n", build.BuildDate)
+	fmt.Fprintf(w, "Go:\t%s\n", build.GoVersion)
+	fmt.Fprintf(w, "File formats:\t%s (writes v%d)\n", strings.Join(formats, ", "), storage.CurrentFormatVersion)
+	w.Flush()
+}
+
+// printStats prints the stats command output. Nodes carry no timestamps, so
+// the file's modification time is the only one available.
+func printStats(path string, fileStat storage.FileStat, treeStats client.Stats, asJSON bool) {
+	if asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(struct {
+			Path          string    `json:"path"`
+			Nodes         int       `json:"nodes"`
+			FileSize      int64     `json:"file_size"`
+			Dimensions    int       `json:"dimensions"`
+			FormatVersion uint32    `json:"format_version"`
+			Writer        string    `json:"writer,omitempty"`
+			IndexEntries  int       `json:"index_entries"`
+			IndexFileSize int64     `json:"index_file_size"`
+			MemoryBytes   int64     `json:"memory_bytes"`
+			Modified      time.Time `json:"modified"`
+		}{path, treeStats.Nodes, fileStat.Size, 512, fileStat.Version, fileStat.Writer,
+			treeStats.IndexEntries, fileStat.IndexSize, treeStats.MemoryBytes, fileStat.ModTime})
+		return
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "File:\t%s\n", path)
+	fmt.Fprintf(w, "Nodes:\t%d\n", treeStats.Nodes)
+	fmt.Fprintf(w, "File size:\t%d bytes\n", fileStat.Size)
+	fmt.Fprintf(w, "Dimensions:\t%d\n", 512)
+	fmt.Fprintf(w, "Format version:\t%d\n", fileStat.Version)
+	fmt.Fprintf(w, "Written by:\t%s\n", writerOrUnknown(fileStat.Writer))
+	fmt.Fprintf(w, "Index entries:\t%d (512 x %d)\n", treeStats.IndexEntries, treeStats.Nodes)
+	if fileStat.IndexSize > 0 {
+		fmt.Fprintf(w, "Index file:\t%d bytes\n", fileStat.IndexSize)
+	} else {
+		fmt.Fprintf(w, "Index file:\tnone (built on first search)\n")
+	}
+	fmt.Fprintf(w, "Memory when loaded:\t~%d bytes\n", treeStats.MemoryBytes)
+	fmt.Fprintf(w, "Modified:\t%s\n", fileStat.ModTime.Format(time.RFC3339))
+	w.Flush()
+}
+
+// parseMergePolicy maps the merge -policy flag to a client.MergePolicy, with
+// a as the merging client. keep-newer picks the file modified last, since
+// memories carry no timestamps of their own.
+func parseMergePolicy(policy, a, b string) (client.MergePolicy, error) {
+	switch policy {
+	case "keep-a":
+		return client.KeepSelf, nil
+	case "keep-b":
+		return client.KeepOther, nil
+	case "keep-both":
+		return client.KeepBothWithSuffix, nil
+	case "keep-newer":
+		infoA, err := os.Stat(a)
+		if err != nil {
+			return 0, err
+		}
+		infoB, err := os.Stat(b)
+		if err != nil {
+			return 0, err
+		}
+		if infoB.ModTime().After(infoA.ModTime()) {
+			return client.KeepOther, nil
+		}
+		return client.KeepSelf, nil
+	}
+	return 0, fmt.Errorf("unknown -policy %q (expected keep-a, keep-b, keep-both or keep-newer)", policy)
+}
+
+// printVerify prints the verify command output
+func printVerify(path string, report storage.VerifyReport, asJSON bool) {
+	if asJSON {
+		problems := report.Problems
+		if problems == nil {
+			problems = []string{}
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(struct {
+			Path          string   `json:"path"`
+			OK            bool     `json:"ok"`
+			FormatVersion uint32   `json:"format_version"`
+			Writer        string   `json:"writer,omitempty"`
+			NodeCount     int64    `json:"node_count"`
+			NodesRead     int      `json:"nodes_read"`
+			Index         string   `json:"index"`
+			Problems      []string `json:"problems"`
+		}{path, report.OK(), report.Version, report.Writer, report.NodeCount, report.NodesRead, report.Index, problems})
+		return
+	}
+
+	fmt.Printf("%s: format v%d written by %s, %d nodes declared, %d read, index %s\n",
+		path, report.Version, writerOrUnknown(report.Writer), report.NodeCount, report.NodesRead, report.Index)
+	for _, problem := range report.Problems {
+		fmt.Printf("  %s\n", problem)
+	}
+	if report.OK() {
+		fmt.Println("OK")
+	} else {
+		fmt.Printf("%d problems found\n", len(report.Problems))
+	}
+}
+
+// diffNode is a node in diff -format json output; embeddings are left out
+type diffNode struct {
+	ID    string `json:"id"`
+	Value string `json:"value"`
+}
+
+func printDiff(diff storage.DiffResult, a, b, format string) {
+	if format == "json" {
+		toJSON := func(nodes []types.Node) []diffNode {
+			out := make([]diffNode, len(nodes))
+			for i, n := range nodes {
+				out[i] = diffNode{ID: n.ID, Value: n.Value}
+			}
+			return out
+		}
+
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(struct {
+			OnlyInA []diffNode `json:"only_in_a"`
+			OnlyInB []diffNode `json:"only_in_b"`
+			InBoth  int        `json:"in_both"`
+		}{toJSON(diff.OnlyInA), toJSON(diff.OnlyInB), diff.InBoth})
+		return
+	}
+
+	fmt.Printf("In both: %d\n", diff.InBoth)
+	fmt.Printf("Only in %s: %d\n", a, len(diff.OnlyInA))
+	fmt.Printf("Only in %s: %d\n", b, len(diff.OnlyInB))
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	if len(diff.OnlyInA)+len(diff.OnlyInB) > 0 {
+		fmt.Fprintln(w, "\nSIDE\tID\tVALUE")
+	}
+	for _, n := range diff.OnlyInA {
+		fmt.Fprintf(w, "<\t%s\t%s\n", n.ID, n.Value)
+	}
+	for _, n := range diff.OnlyInB {
+		fmt.Fprintf(w, ">\t%s\t%s\n", n.ID, n.Value)
 	}
+	w.Flush()
 }
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+// The test binary runs as the CLI when re-executed by hippocampus, so each
+// command gets a process of its own, as it calls os.Exit and log.Fatal
+func TestMain(m *testing.M) {
+	if os.Getenv("HIPPO_CLI_TEST") == "1" {
+		runCLI(os.Args[1:])
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+// timings matches the numbers of the TIMING lines, which change every run
+var timings = regexp.MustCompile(`(?m)^(TIMING:.*)$`)
+var timingNumber = regexp.MustCompile(`[0-9.]+`)
+
+// hippocampus runs the CLI in dir with args, returning its standard output
+// and exit code. HOME is dir, so no config file is read.
+func hippocampus(t *testing.T, dir string, args ...string) (string, int) {
+	t.Helper()
+	cmd := exec.Command(os.Args[0], args...)
+	cmd.Dir = dir
+	cmd.Env = []string{"HIPPO_CLI_TEST=1", "HOME=" + dir, "PATH=" + os.Getenv("PATH")}
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	err := cmd.Run()
+	code := 0
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		code = exitErr.ExitCode()
+	} else if err != nil {
+		t.Fatal(err)
+	}
+	if code != 0 {
+		t.Logf("hippocampus %s: %s", strings.Join(args, " "), stderr.String())
+	}
+	out := timings.ReplaceAllStringFunc(stdout.String(), func(line string) string {
+		return timingNumber.ReplaceAllString(line, "N")
+	})
+	return out, code
+}
+
+// checkGolden compares got with testdata/name.golden, or writes it there
+// with -update
+func checkGolden(t *testing.T, name, got string) {
+	t.Helper()
+	path := filepath.Join("testdata", name+".golden")
+	if *update {
+		if err := os.WriteFile(path, []byte(got), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != string(want) {
+		t.Errorf("output differs from %s (rerun with -update if intended):\n%s", path, got)
+	}
+}
+
+func TestHelp(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		args []string
+	}{
+		{"help", []string{"help"}},
+		{"help-insert", []string{"help", "insert"}},
+		{"help-cdc-tail", []string{"help", "cdc"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			out, code := hippocampus(t, t.TempDir(), tc.args...)
+			if code != 0 {
+				t.Fatalf("exit code %d", code)
+			}
+			checkGolden(t, tc.name, out)
+		})
+	}
+}
+
+func TestHelpAlignsCommands(t *testing.T) {
+	out, _ := hippocampus(t, t.TempDir(), "help")
+	for _, c := range commands {
+		if !strings.Contains(out, "  "+c.title()+"  ") {
+			t.Errorf("%q isn't followed by a gap in the command list", c.title())
+		}
+	}
+}
+
+func TestInsertSearchGetDelete(t *testing.T) {
+	dir := t.TempDir()
+	var transcript strings.Builder
+	run := func(wantCode int, args ...string) {
+		t.Helper()
+		out, code := hippocampus(t, dir, args...)
+		if code != wantCode {
+			t.Fatalf("hippocampus %s: exit code %d, want %d", strings.Join(args, " "), code, wantCode)
+		}
+		transcript.WriteString("$ hippocampus " + strings.Join(args, " ") + "\n" + out)
+	}
+
+	run(0, "insert", "-embedder", "mock", "-key", "cat", "-text", "the cat sat on the mat")
+	run(0, "insert", "-embedder", "mock", "-key", "dog", "-text", "dogs bark at night")
+	run(0, "get", "-key", "cat")
+	run(0, "search", "-embedder", "mock", "-text", "the cat sat on the mat", "-top-k", "1", "-json")
+	run(0, "delete", "-key", "cat")
+	run(1, "get", "-key", "cat")
+	run(0, "get", "-key", "dog")
+
+	checkGolden(t, "insert-search-get-delete", transcript.String())
+}
+
+func TestExportImportRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	run := func(args ...string) string {
+		t.Helper()
+		out, code := hippocampus(t, dir, args...)
+		if code != 0 {
+			t.Fatalf("hippocampus %s: exit code %d", strings.Join(args, " "), code)
+		}
+		return out
+	}
+
+	for key, text := range map[string]string{
+		"cat":    "the cat sat on the mat",
+		"dog":    "dogs bark at night",
+		"tea":    "the user prefers green tea",
+		"perth":  "the user lives in Perth",
+		"bike":   "rides a bike to work",
+		"coffee": "never drinks coffee after noon",
+	} {
+		run("insert", "-binary", "a.bin", "-embedder", "mock", "-key", key, "-text", text)
+	}
+	run("export", "-binary", "a.bin", "-with-embeddings", "-out", "a.jsonl")
+	// Nothing listens at the embed URL, so the stored embeddings must be used
+	run("import", "-binary", "b.bin", "-in", "a.jsonl", "-embed-url", "http://127.0.0.1:1")
+
+	for _, query := range []string{"the cat sat on the mat", "tea or coffee", "where does the user live"} {
+		search := func(binary string) string {
+			return run("search", "-binary", binary, "-embedder", "mock", "-text", query,
+				"-top-k", "6", "-epsilon", "1", "-threshold", "0", "-json")
+		}
+		a, b := search("a.bin"), search("b.bin")
+		if n := strings.Count(a, `"key":`); n != 6 {
+			t.Fatalf("search %q found %d memories, want all 6:\n%s", query, n, a)
+		}
+		if a != b {
+			t.Errorf("search %q differs after the round trip:\nexported:\n%s\nimported:\n%s", query, a, b)
+		}
+	}
+
+	run("export", "-binary", "b.bin", "-with-embeddings", "-out", "b.jsonl")
+	a, err := os.ReadFile(filepath.Join(dir, "a.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(filepath.Join(dir, "b.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(a, b) {
+		t.Errorf("exporting the imported file gives another dump:\n%s\nwant:\n%s", b, a)
+	}
+}
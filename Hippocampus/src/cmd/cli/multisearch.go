@@ -0,0 +1,135 @@
+package main
+
+import (
+	"Hippocampus/src/client"
+	"Hippocampus/src/embedding"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// pathList is a flag that may be repeated, e.g. -binary a.bin -binary b.bin.
+// The first use replaces the default.
+type pathList struct {
+	paths []string
+	set   bool
+}
+
+func newPathList(def string) *pathList {
+	return &pathList{paths: []string{def}}
+}
+
+func (p *pathList) String() string {
+	if p == nil {
+		return ""
+	}
+	return strings.Join(p.paths, ",")
+}
+
+func (p *pathList) Set(s string) error {
+	if !p.set {
+		p.paths, p.set = nil, true
+	}
+	p.paths = append(p.paths, s)
+	return nil
+}
+
+// searchSources returns the database files a search covers: every -binary,
+// then the matches of -binary-glob, without repeats. The default -binary is
+// dropped when only a glob is given.
+func searchSources(binaries *pathList, glob string) ([]string, error) {
+	var sources []string
+	if binaries.set || glob == "" {
+		sources = append(sources, binaries.paths...)
+	}
+	if glob != "" {
+		matches, err := filepath.Glob(glob)
+		if err != nil {
+			return nil, fmt.Errorf("invalid -binary-glob: %w", err)
+		}
+		if len(matches) == 0 {
+			return nil, fmt.Errorf("-binary-glob %s matches no files", glob)
+		}
+		sources = append(sources, matches...)
+	}
+
+	var unique []string
+	for _, source := range sources {
+		if !slices.Contains(unique, source) {
+			unique = append(unique, source)
+		}
+	}
+	return unique, nil
+}
+
+// searchFiles embeds text once and searches every source file with it. Files
+// are loaded and searched in parallel; one that fails to load is reported on
+// stderr and left out. The hits of all files are merged by score, best
+// first, and cut to topK.
+func searchFiles(embedder embedding.EmbeddingService, sources []string, text string, epsilon, threshold float32, topK int) ([]searchHit, error) {
+	query, err := embedding.GetEmbedding(context.Background(), embedder, text)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", client.ErrEmbedding, err)
+	}
+
+	perSource := make([][]searchHit, len(sources))
+	errs := make([]error, len(sources))
+	var wg sync.WaitGroup
+	for i, source := range sources {
+		wg.Add(1)
+		go func(i int, source string) {
+			defer wg.Done()
+
+			// The query is already embedded, so the clients need no embedder
+			c, err := client.NewWithFileStorage(source, nil)
+			if err != nil {
+				errs[i] = err
+				return
+			}
+			results, err := c.SearchByEmbedding(query, epsilon, threshold, topK)
+			if err != nil {
+				errs[i] = err
+				return
+			}
+			for _, r := range results {
+				perSource[i] = append(perSource[i], searchHit{Key: r.Node.ID, Value: r.Node.Value, Score: r.Score, Source: source})
+			}
+		}(i, source)
+	}
+	wg.Wait()
+
+	hits := []searchHit{}
+	failed := 0
+	for i, source := range sources {
+		if errs[i] != nil {
+			fmt.Fprintf(os.Stderr, "Warning: skipping %s: %v\n", source, errs[i])
+			failed++
+			continue
+		}
+		hits = append(hits, perSource[i]...)
+	}
+	if failed == len(sources) {
+		return nil, fmt.Errorf("no database could be searched")
+	}
+
+	// Stable, so equal scores keep the order the sources were given in
+	sort.SliceStable(hits, func(a, b int) bool { return hits[a].Score > hits[b].Score })
+	if topK > 0 && len(hits) > topK {
+		hits = hits[:topK]
+	}
+	return hits, nil
+}
+
+// printSourceHits prints the results of a multi-file search, one per line
+// with the file each came from
+func printSourceHits(hits []searchHit) {
+	fmt.Printf("\nFound %d results:\n", len(hits))
+	for _, h := range hits {
+		fmt.Printf("  [%.3f] %s: %s\n", h.Score, h.Source, h.Value)
+	}
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// csvCheckpoint is the <csv>.progress file of insert-csv: the rows of the
+// CSV already flushed to the database
+type csvCheckpoint struct {
+	Binary string `json:"binary"` // Absolute path of the database the rows went to
+	Rows   int    `json:"rows"`
+}
+
+// checkpointPath returns the checkpoint file used for csvFile
+func checkpointPath(csvFile string) string {
+	return csvFile + ".progress"
+}
+
+// loadCheckpoint returns the rows recorded for binary in the checkpoint of
+// csvFile, or 0 if there is none. A checkpoint written for another
+// database is ignored.
+func loadCheckpoint(csvFile, binary string) (int, error) {
+	data, err := os.ReadFile(checkpointPath(csvFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, nil
+		}
+		return 0, err
+	}
+
+	var cp csvCheckpoint
+	if err := json.Unmarshal(data, &cp); err != nil {
+		return 0, fmt.Errorf("%s: %w", checkpointPath(csvFile), err)
+	}
+	if cp.Binary != binary {
+		fmt.Fprintf(os.Stderr, "Ignoring %s: it records progress into %s\n", checkpointPath(csvFile), cp.Binary)
+		return 0, nil
+	}
+	return cp.Rows, nil
+}
+
+// saveCheckpoint records that rows of csvFile are stored in binary. The file
+// is replaced by a rename so a crash never leaves it half written.
+func saveCheckpoint(csvFile, binary string, rows int) error {
+	data, err := json.Marshal(csvCheckpoint{Binary: binary, Rows: rows})
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(checkpointPath(csvFile), append(data, '\n'))
+}
+
+// writeFileAtomic replaces path with data through a temporary file and a
+// rename, so readers never see it half written
+func writeFileAtomic(path string, data []byte) error {
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// absPath returns path made absolute, or path itself if that fails
+func absPath(path string) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		return abs
+	}
+	return path
+}
+
+// countCSVRows returns the number of records in a CSV file. Malformed rows
+// are left for the insert to report at the row they occur.
+func countCSVRows(path string) (int, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	reader := csv.NewReader(f)
+	reader.ReuseRecord = true
+	reader.FieldsPerRecord = -1
+	rows := 0
+	for {
+		if _, err := reader.Read(); err != nil {
+			if err == io.EOF {
+				return rows, nil
+			}
+			return rows, err
+		}
+		rows++
+	}
+}
+
+// progress prints a "done/total, rate, ETA" line to stderr, redrawn in place
+// at most every progressInterval
+type progress struct {
+	total   int // 0 if unknown
+	skipped int // Rows resumed past; they don't count towards the rate
+	start   time.Time
+	last    time.Time
+}
+
+const progressInterval = 200 * time.Millisecond
+
+func newProgress(total, skipped int) *progress {
+	return &progress{total: total, skipped: skipped, start: time.Now()}
+}
+
+// update redraws the line for rows handled so far
+func (p *progress) update(rows int) {
+	now := time.Now()
+	if now.Sub(p.last) < progressInterval && rows != p.total {
+		return
+	}
+	p.last = now
+
+	line := fmt.Sprintf("%d rows", rows)
+	if p.total > 0 {
+		line = fmt.Sprintf("%d/%d rows", rows, p.total)
+	}
+	if elapsed := now.Sub(p.start).Seconds(); elapsed > 0 && rows > p.skipped {
+		rate := float64(rows-p.skipped) / elapsed
+		line += fmt.Sprintf(", %.0f rows/s", rate)
+		if p.total >= rows {
+			eta := time.Duration(float64(p.total-rows) / rate * float64(time.Second))
+			line += fmt.Sprintf(", ETA %s", eta.Round(time.Second))
+		}
+	}
+	// Pad so a shorter line fully covers the previous one
+	fmt.Fprintf(os.Stderr, "\r%-60s", line)
+}
+
+// done ends the progress line
+func (p *progress) done() {
+	fmt.Fprintln(os.Stderr)
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"Hippocampus/src/client"
+	"Hippocampus/src/types"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const replHelp = `Commands:
+  insert <key> "<text>"                    Store a memory
+  search "<query>" [-k N] [-epsilon E] [-threshold T]
+                                           Search (defaults: -k 5 -epsilon 0.3 -threshold 0.5)
+  get <key>                                Print the memory stored under key
+  delete <key>                             Remove the memory stored under key
+  stats                                    Show counts and memory usage
+  save                                     Write changes to the database file
+  help                                     Show this help
+  quit                                     Save and exit (also Ctrl-D)`
+
+// repl keeps one database loaded and runs commands typed at a prompt
+type repl struct {
+	binary string
+	c      *client.Client
+	dirty  bool // Changes not yet saved
+}
+
+// runREPL runs the interactive session until quit or end of input
+func runREPL(binary string, c *client.Client) error {
+	c.SetVerbose(false)
+	r := &repl{binary: binary, c: c}
+
+	count, err := c.Count()
+	if err != nil {
+		return fmt.Errorf("failed to load %s: %w", binary, err)
+	}
+	fmt.Printf("Loaded %d memories from %s. Type help for commands.\n", count, binary)
+
+	historyPath := ""
+	if home, err := os.UserHomeDir(); err == nil {
+		historyPath = filepath.Join(home, ".hippocampus_history")
+	}
+	editor := newLineEditor(historyPath)
+	defer editor.saveHistory()
+
+	for {
+		line, err := editor.readLine("hippocampus> ")
+		if errors.Is(err, errInterrupted) {
+			continue
+		}
+		if err == io.EOF {
+			return r.save()
+		}
+		if err != nil {
+			return err
+		}
+
+		args, err := splitArgs(line)
+		if err != nil {
+			fmt.Printf("error: %v\n", err)
+			continue
+		}
+		if len(args) == 0 {
+			continue
+		}
+
+		if args[0] == "quit" || args[0] == "exit" {
+			return r.save()
+		}
+		// Errors are reported and the session continues
+		if err := r.exec(args); err != nil {
+			fmt.Printf("error: %v\n", err)
+		}
+	}
+}
+
+func (r *repl) exec(args []string) error {
+	switch strings.ToLower(args[0]) {
+	case "help":
+		fmt.Println(replHelp)
+
+	case "insert":
+		if len(args) != 3 {
+			return errors.New(`usage: insert <key> "<text>"`)
+		}
+		if err := r.c.Insert(args[1], args[2]); err != nil {
+			return err
+		}
+		r.dirty = true
+		fmt.Printf("Inserted %s\n", args[1])
+
+	case "search":
+		return r.search(args[1:])
+
+	case "get":
+		if len(args) != 2 {
+			return errors.New("usage: get <key>")
+		}
+		node, found, err := r.c.Get(args[1])
+		if err != nil {
+			return err
+		}
+		if !found {
+			return fmt.Errorf("key not found: %s", args[1])
+		}
+		fmt.Println(node.Value)
+
+	case "delete":
+		if len(args) != 2 {
+			return errors.New("usage: delete <key>")
+		}
+		deleted, err := r.c.Delete(args[1])
+		if err != nil {
+			return err
+		}
+		if !deleted {
+			return fmt.Errorf("key not found: %s", args[1])
+		}
+		r.dirty = true
+		fmt.Printf("Deleted %s\n", args[1])
+
+	case "stats":
+		treeStats, err := r.c.Stats()
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Memories: %d\n", treeStats.Nodes)
+		fmt.Printf("Index entries: %d\n", treeStats.IndexEntries)
+		fmt.Printf("Memory when loaded: ~%d bytes\n", treeStats.MemoryBytes)
+		if r.dirty {
+			fmt.Println("Unsaved changes: yes")
+		}
+
+	case "save":
+		if err := r.save(); err != nil {
+			return err
+		}
+		fmt.Printf("Saved %s\n", r.binary)
+
+	default:
+		return fmt.Errorf("unknown command %q (type help)", args[0])
+	}
+	return nil
+}
+
+// search parses the search command's own flags after the query
+func (r *repl) search(args []string) error {
+	fs := flag.NewFlagSet("search", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	topK := fs.Int("k", 5, "")
+	epsilon := fs.Float64("epsilon", 0.3, "")
+	threshold := fs.Float64("threshold", 0.5, "")
+
+	if len(args) == 0 {
+		return errors.New(`usage: search "<query>" [-k N] [-epsilon E] [-threshold T]`)
+	}
+	query := args[0]
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+	if fs.NArg() > 0 {
+		return fmt.Errorf("unexpected argument %q (quote multi-word queries)", fs.Arg(0))
+	}
+
+	results, err := r.c.SearchScored(query, types.SearchOptions{
+		Epsilon:   float32(*epsilon),
+		Threshold: float32(*threshold),
+		TopK:      *topK,
+	})
+	if err != nil {
+		return err
+	}
+	if len(results) == 0 {
+		fmt.Println("No results")
+		return nil
+	}
+	for i, res := range results {
+		fmt.Printf("%2d. [%.3f] %s: %s\n", i+1, res.Score, res.Node.ID, res.Node.Value)
+	}
+	return nil
+}
+
+func (r *repl) save() error {
+	if err := r.c.Flush(); err != nil {
+		return err
+	}
+	r.dirty = false
+	return nil
+}
+
+// splitArgs splits a REPL line into words. Single or double quotes group
+// words; inside double quotes a backslash escapes the next character.
+func splitArgs(line string) ([]string, error) {
+	var args []string
+	var word strings.Builder
+	inWord := false
+	var quote rune
+
+	runes := []rune(line)
+	for i := 0; i < len(runes); i++ {
+		ch := runes[i]
+		switch {
+		case quote != 0:
+			if ch == quote {
+				quote = 0
+			} else if ch == '\\' && quote == '"' && i+1 < len(runes) {
+				i++
+				word.WriteRune(runes[i])
+			} else {
+				word.WriteRune(ch)
+			}
+		case ch == '"' || ch == '\'':
+			quote = ch
+			inWord = true
+		case ch == ' ' || ch == '\t':
+			if inWord {
+				args = append(args, word.String())
+				word.Reset()
+				inWord = false
+			}
+		default:
+			word.WriteRune(ch)
+			inWord = true
+		}
+	}
+
+	if quote != 0 {
+		return nil, errors.New("unterminated quote")
+	}
+	if inWord {
+		args = append(args, word.String())
+	}
+	return args, nil
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"Hippocampus/src/client"
+	"Hippocampus/src/embedding"
+	"Hippocampus/src/types"
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// stdinOptions configures insert-stdin
+type stdinOptions struct {
+	tsv       bool   // Lines are key<TAB>text
+	keyPrefix string // Prepended to generated keys
+	keyMode   string // "line" (line number) or "hash" (of the text)
+	batchSize int    // Lines embedded concurrently
+	maxLine   int    // Longer lines are chunked or rejected; 0 disables the check
+	chunkLong bool   // Chunk long lines instead of rejecting them
+	overlap   int    // Chunk overlap in bytes
+}
+
+// stdinStats counts the outcome of insert-stdin
+type stdinStats struct {
+	inserted   int
+	duplicates int
+	rejected   int
+}
+
+// stdinLine is one memory read from the input
+type stdinLine struct {
+	number int
+	key    string
+	text   string
+	embed  [512]float32
+	err    error
+}
+
+// insertStdin inserts one memory per non-empty line of r. Lines are embedded
+// a batch at a time with one goroutine per line; the caller flushes.
+func insertStdin(c *client.Client, r io.Reader, opts stdinOptions) (stdinStats, error) {
+	var stats stdinStats
+	br := bufio.NewReader(r)
+	batch := make([]stdinLine, 0, opts.batchSize)
+
+	flushBatch := func() error {
+		embedBatch(c.Embedder, batch)
+		for i := range batch {
+			line := &batch[i]
+			err := line.err
+			if err == nil {
+				err = c.InsertEmbedding(line.key, line.text, line.embed)
+			}
+			if err != nil {
+				if errors.Is(err, types.ErrDuplicateKey) {
+					stats.duplicates++
+					continue
+				}
+				return fmt.Errorf("line %d: %w", line.number, err)
+			}
+			stats.inserted++
+		}
+		batch = batch[:0]
+		fmt.Fprintf(os.Stderr, "\rInserted %d memories", stats.inserted)
+		return nil
+	}
+
+	for number := 1; ; number++ {
+		raw, readErr := br.ReadString('\n')
+		if readErr != nil && readErr != io.EOF {
+			return stats, readErr
+		}
+
+		text := strings.TrimRight(raw, "\r\n")
+		if strings.TrimSpace(text) != "" {
+			key, text, err := stdinKey(text, number, opts)
+			if err != nil {
+				return stats, fmt.Errorf("line %d: %w", number, err)
+			}
+
+			switch {
+			case opts.maxLine <= 0 || len(text) <= opts.maxLine:
+				batch = append(batch, stdinLine{number: number, key: key, text: text})
+			case opts.chunkLong:
+				// Chunks are embedded one by one through the client
+				chunks, err := c.InsertChunked(key, text, opts.maxLine, opts.overlap)
+				if err != nil {
+					return stats, fmt.Errorf("line %d: %w", number, err)
+				}
+				stats.inserted += chunks
+			default:
+				fmt.Fprintf(os.Stderr, "\nline %d: %d bytes exceeds -max-line %d, skipped\n", number, len(text), opts.maxLine)
+				stats.rejected++
+			}
+		}
+
+		if len(batch) == opts.batchSize || (readErr == io.EOF && len(batch) > 0) {
+			if err := flushBatch(); err != nil {
+				return stats, err
+			}
+		}
+		if readErr == io.EOF {
+			break
+		}
+	}
+
+	fmt.Fprintln(os.Stderr)
+	return stats, nil
+}
+
+// stdinKey returns the key and text of an input line
+func stdinKey(line string, number int, opts stdinOptions) (key, text string, err error) {
+	text = line
+	if opts.tsv {
+		var ok bool
+		key, text, ok = strings.Cut(line, "\t")
+		if !ok || key == "" {
+			return "", "", errors.New("expected key<TAB>text")
+		}
+		return opts.keyPrefix + key, text, nil
+	}
+
+	if opts.keyMode == "hash" {
+		sum := sha256.Sum256([]byte(text))
+		return opts.keyPrefix + hex.EncodeToString(sum[:6]), text, nil
+	}
+	return opts.keyPrefix + strconv.Itoa(number), text, nil
+}
+
+// embedBatch embeds every line of batch concurrently, recording failures per line
+func embedBatch(embedder embedding.EmbeddingService, batch []stdinLine) {
+	ctx := context.Background()
+	var wg sync.WaitGroup
+	for i := range batch {
+		wg.Add(1)
+		go func(line *stdinLine) {
+			defer wg.Done()
+			if err := embedding.GetEmbeddingInto(ctx, embedder, line.text, &line.embed); err != nil {
+				line.err = fmt.Errorf("%w: %w", client.ErrEmbedding, err)
+			}
+		}(&batch[i])
+	}
+	wg.Wait()
+}
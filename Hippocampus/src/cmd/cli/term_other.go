@@ -0,0 +1,16 @@
+//go:build !linux
+
+package main
+
+import "errors"
+
+// Line editing is only implemented for Linux terminals; elsewhere the REPL
+// reads plain lines
+
+func makeRaw(fd int) (restore func(), err error) {
+	return nil, errors.New("raw terminal mode not supported on this platform")
+}
+
+func isTerminal(fd int) bool {
+	return false
+}
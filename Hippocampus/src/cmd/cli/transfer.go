@@ -0,0 +1,224 @@
+package main
+
+import (
+	"Hippocampus/src/client"
+	"Hippocampus/src/types"
+	"Hippocampus/src/vectorfile"
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// exportRecord is one memory in export/import JSONL
+type exportRecord struct {
+	Key       string          `json:"key"`
+	Value     string          `json:"value"`
+	Metadata  json.RawMessage `json:"metadata,omitempty"`
+	Embedding []float32       `json:"embedding,omitempty"`
+	Model     string          `json:"model,omitempty"` // Model the embedding came from, if known
+}
+
+// exportTree writes every node of tree to w as JSONL or CSV. Embeddings are
+// written with enough precision to be read back bit for bit; in JSONL they
+// are tagged with model, if set. Only JSONL carries metadata.
+func exportTree(w io.Writer, tree *types.Tree, format string, withEmbeddings bool, model string) error {
+	bw := bufio.NewWriter(w)
+
+	switch format {
+	case "jsonl":
+		enc := json.NewEncoder(bw)
+		for i := range tree.Nodes {
+			n := &tree.Nodes[i]
+			record := exportRecord{Key: n.ID, Value: n.Value}
+			if n.Metadata != "" {
+				record.Metadata = json.RawMessage(n.Metadata)
+			}
+			if withEmbeddings {
+				record.Embedding = n.Key[:]
+				record.Model = model
+			}
+			if err := enc.Encode(record); err != nil {
+				return err
+			}
+		}
+
+	case "csv":
+		cw := csv.NewWriter(bw)
+		header := []string{"key", "value"}
+		if withEmbeddings {
+			header = append(header, "embedding")
+		}
+		if err := cw.Write(header); err != nil {
+			return err
+		}
+
+		for i := range tree.Nodes {
+			n := &tree.Nodes[i]
+			row := []string{n.ID, n.Value}
+			if withEmbeddings {
+				// Space separated, so the embedding stays a single column
+				values := make([]string, len(n.Key))
+				for d, v := range n.Key {
+					values[d] = strconv.FormatFloat(float64(v), 'g', -1, 32)
+				}
+				row = append(row, strings.Join(values, " "))
+			}
+			if err := cw.Write(row); err != nil {
+				return err
+			}
+		}
+		cw.Flush()
+		if err := cw.Error(); err != nil {
+			return err
+		}
+
+	default:
+		return fmt.Errorf("unknown format %q (expected jsonl or csv)", format)
+	}
+
+	return bw.Flush()
+}
+
+// importJSONL inserts the records read from r. Records carrying an embedding
+// are stored as is, unless tagged with a model other than the client's; the
+// rest are embedded by the client's embedder. A record replaces the memory
+// stored under its key, unless it holds the same text, when it is skipped.
+func importJSONL(c *client.Client, r io.Reader) (imported, skipped int, err error) {
+	dec := json.NewDecoder(bufio.NewReader(r))
+
+	for line := 1; ; line++ {
+		var record exportRecord
+		if err := dec.Decode(&record); err != nil {
+			if err == io.EOF {
+				break
+			}
+			return imported, skipped, fmt.Errorf("record %d: %w", line, err)
+		}
+
+		metadata := string(record.Metadata)
+		if metadata == "null" {
+			metadata = ""
+		}
+		if len(record.Embedding) == 0 {
+			err = c.InsertWithMetadata(record.Key, record.Value, metadata)
+		} else if len(record.Embedding) != vectorDims {
+			err = fmt.Errorf("embedding has %d dimensions, expected %d", len(record.Embedding), vectorDims)
+		} else if err = c.CheckModelVersion(record.Model); err == nil {
+			err = c.InsertNode(types.Node{
+				Key:      [vectorDims]float32(record.Embedding),
+				ID:       record.Key,
+				Value:    record.Value,
+				Metadata: metadata,
+			})
+		}
+
+		if err != nil {
+			if errors.Is(err, types.ErrDuplicateKey) {
+				skipped++
+				continue
+			}
+			return imported, skipped, fmt.Errorf("record %d: %w", line, err)
+		}
+		imported++
+	}
+
+	return imported, skipped, nil
+}
+
+// vectorDims is the dimension of every embedding in a tree
+const vectorDims = 512
+
+// readVectors reads a FAISS flat index or .npy array and its sidecar,
+// checking them against each other and the tree's dimensions before
+// anything is written. Sidecar lines without an ID take the vector's FAISS
+// ID, if the index has them.
+func readVectors(format, indexPath, metaPath string) (*vectorfile.Vectors, []vectorfile.Meta, error) {
+	f, err := os.Open(indexPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+
+	var v *vectorfile.Vectors
+	switch format {
+	case "faiss-flat":
+		var metric vectorfile.Metric
+		if v, metric, err = vectorfile.ReadFAISS(f); err == nil && metric != vectorfile.MetricL2 {
+			log.Printf("Note: %s uses %s; searches here compare by Euclidean distance or cosine similarity", indexPath, metric)
+		}
+	case "npy":
+		v, err = vectorfile.ReadNPY(f)
+	default:
+		return nil, nil, fmt.Errorf("unknown -format %q (expected faiss-flat or npy)", format)
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s: %w", indexPath, err)
+	}
+	if v.Dim != vectorDims {
+		return nil, nil, fmt.Errorf("%s holds %d-dimensional vectors; the tree stores %d", indexPath, v.Dim, vectorDims)
+	}
+
+	mf, err := os.Open(metaPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer mf.Close()
+	metas, err := vectorfile.ReadMeta(mf)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s: %w", metaPath, err)
+	}
+	if len(metas) != v.Len() {
+		return nil, nil, fmt.Errorf("%s has %d lines for %d vectors", metaPath, len(metas), v.Len())
+	}
+
+	seen := make(map[string]int, len(metas))
+	for i := range metas {
+		if metas[i].ID == "" && v.IDs != nil {
+			metas[i].ID = strconv.FormatInt(v.IDs[i], 10)
+		}
+		if metas[i].ID == "" {
+			return nil, nil, fmt.Errorf("%s line %d has no id", metaPath, i+1)
+		}
+		if first, ok := seen[metas[i].ID]; ok {
+			return nil, nil, fmt.Errorf("%s lines %d and %d share the id %q", metaPath, first+1, i+1, metas[i].ID)
+		}
+		seen[metas[i].ID] = i
+	}
+	return v, metas, nil
+}
+
+// importVectors inserts each vector with its sidecar line, replacing the
+// memory stored under its key and skipping vectors already stored there
+func importVectors(c *client.Client, v *vectorfile.Vectors, metas []vectorfile.Meta) (imported, skipped int, err error) {
+	for i, m := range metas {
+		if err := c.InsertRaw(m.ID, m.Text, v.Row(i)); err != nil {
+			if errors.Is(err, types.ErrDuplicateKey) {
+				skipped++
+				continue
+			}
+			return imported, skipped, fmt.Errorf("vector %d (%s): %w", i, m.ID, err)
+		}
+		imported++
+	}
+	return imported, skipped, nil
+}
+
+// treeVectors returns the embeddings of a tree's nodes and their sidecar
+// lines, in node order
+func treeVectors(tree *types.Tree) (*vectorfile.Vectors, []vectorfile.Meta) {
+	v := &vectorfile.Vectors{Dim: vectorDims, Data: make([]float32, 0, len(tree.Nodes)*vectorDims)}
+	metas := make([]vectorfile.Meta, len(tree.Nodes))
+	for i := range tree.Nodes {
+		n := &tree.Nodes[i]
+		v.Data = append(v.Data, n.Key[:]...)
+		metas[i] = vectorfile.Meta{ID: n.ID, Text: n.Value}
+	}
+	return v, metas
+}
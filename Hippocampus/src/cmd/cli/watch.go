@@ -0,0 +1,317 @@
+package main
+
+import (
+	"Hippocampus/src/client"
+	"Hippocampus/src/types"
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"time"
+)
+
+// watchOptions configures watch
+type watchOptions struct {
+	raw           bool   // Lines are plain text instead of {"key","text"} JSON
+	keyPrefix     string // Prepended to every key
+	batchSize     int    // Lines embedded concurrently
+	poll          time.Duration
+	flushInterval time.Duration
+}
+
+// watchOffset is the <file>.offset file of watch: how far into the watched
+// file the lines are flushed to the database
+type watchOffset struct {
+	Binary string `json:"binary"` // Absolute path of the database the lines went to
+	Offset int64  `json:"offset"`
+}
+
+// watchRecord is a line of the watched file without -raw
+type watchRecord struct {
+	Key  string `json:"key"`
+	Text string `json:"text"`
+}
+
+func offsetPath(file string) string {
+	return file + ".offset"
+}
+
+// loadOffset returns the offset recorded for binary in the offset file of
+// file, or 0 if there is none. An offset recorded for another database is
+// ignored.
+func loadOffset(file, binary string) (int64, error) {
+	data, err := os.ReadFile(offsetPath(file))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, nil
+		}
+		return 0, err
+	}
+
+	var wo watchOffset
+	if err := json.Unmarshal(data, &wo); err != nil {
+		return 0, fmt.Errorf("%s: %w", offsetPath(file), err)
+	}
+	if wo.Binary != binary {
+		fmt.Fprintf(os.Stderr, "Ignoring %s: it records progress into %s\n", offsetPath(file), wo.Binary)
+		return 0, nil
+	}
+	return wo.Offset, nil
+}
+
+func saveOffset(file, binary string, offset int64) error {
+	data, err := json.Marshal(watchOffset{Binary: binary, Offset: offset})
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(offsetPath(file), append(data, '\n'))
+}
+
+// watcher inserts the lines appended to a file. offset only moves past a
+// line once it is inserted, or skipped as unparsable, so a line that failed
+// to embed is retried, with backoff.
+type watcher struct {
+	c      *client.Client
+	path   string
+	opts   watchOptions
+	f      *os.File
+	info   os.FileInfo // Of f, to notice the path being replaced
+	offset int64
+	stats  stdinStats
+
+	// After an embedding failure, polls wait until retryAt, backing off
+	// up to maxRetryDelay while failures continue
+	retryAt    time.Time
+	retryDelay time.Duration
+}
+
+const maxRetryDelay = 30 * time.Second
+
+// open opens the watched path and positions the watcher at offset, or at
+// the start if the file is now shorter than that
+func (w *watcher) open(offset int64) error {
+	f, err := os.Open(w.path)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	if offset > info.Size() {
+		log.Printf("%s is shorter than the recorded offset %d, reading from the start", w.path, offset)
+		offset = 0
+	}
+	w.f, w.info, w.offset = f, info, offset
+	return nil
+}
+
+// poll inserts the complete lines appended since the last poll, then checks
+// whether the file was truncated or replaced by a new one
+func (w *watcher) poll() error {
+	if time.Now().Before(w.retryAt) {
+		return nil
+	}
+	if err := w.readLines(); err != nil {
+		return err
+	}
+
+	info, err := os.Stat(w.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			// Rotated away and not yet recreated; keep the old file open
+			return nil
+		}
+		return err
+	}
+	if !os.SameFile(info, w.info) {
+		// The old file was read to its end above. Lines its writer appends
+		// after this point are missed, as with tail -F.
+		log.Printf("%s was replaced, following the new file", w.path)
+		w.f.Close()
+		return w.open(0)
+	}
+	if info.Size() < w.offset {
+		log.Printf("%s was truncated, reading from the start", w.path)
+		w.offset = 0
+	}
+	return nil
+}
+
+// readLines reads the complete lines from offset to the end of the file,
+// inserting them a batch at a time. A partial last line is left until its
+// newline arrives.
+func (w *watcher) readLines() error {
+	if _, err := w.f.Seek(w.offset, io.SeekStart); err != nil {
+		return err
+	}
+	br := bufio.NewReader(w.f)
+
+	batch := make([]stdinLine, 0, w.opts.batchSize)
+	starts := make([]int64, 0, w.opts.batchSize) // Offset of each batched line
+	pos := w.offset
+	for {
+		raw, err := br.ReadString('\n')
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		start := pos
+		pos += int64(len(raw))
+
+		key, text, ok := w.parse(strings.TrimRight(raw, "\r\n"), start)
+		if ok {
+			batch = append(batch, stdinLine{key: key, text: text})
+			starts = append(starts, start)
+		}
+		if len(batch) == w.opts.batchSize {
+			if done, err := w.insertBatch(batch, starts, pos); err != nil || !done {
+				return err
+			}
+			batch, starts = batch[:0], starts[:0]
+		} else if len(batch) == 0 {
+			// Nothing pending: skipped lines count as done
+			w.offset = pos
+		}
+	}
+
+	if len(batch) > 0 {
+		_, err := w.insertBatch(batch, starts, pos)
+		return err
+	}
+	return nil
+}
+
+// parse returns the key and text of a line, or false if it is skipped
+func (w *watcher) parse(line string, offset int64) (key, text string, ok bool) {
+	if strings.TrimSpace(line) == "" {
+		return "", "", false
+	}
+
+	text = line
+	if !w.opts.raw {
+		var record watchRecord
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			log.Printf("offset %d: skipped, not a JSON object: %v", offset, err)
+			return "", "", false
+		}
+		if record.Text == "" {
+			log.Printf("offset %d: skipped, no \"text\"", offset)
+			return "", "", false
+		}
+		if record.Key != "" {
+			return w.opts.keyPrefix + record.Key, record.Text, true
+		}
+		text = record.Text
+	}
+
+	// Keys generated from the text stay the same when a line is read again
+	key, text, _ = stdinKey(text, 0, stdinOptions{keyPrefix: w.opts.keyPrefix, keyMode: "hash"})
+	return key, text, true
+}
+
+// insertBatch embeds and inserts batch, whose lines start at starts and end
+// at end. On an embedding failure it returns false with offset left at the
+// failed line; lines after it that were inserted show up as duplicates when
+// they are read again.
+func (w *watcher) insertBatch(batch []stdinLine, starts []int64, end int64) (bool, error) {
+	embedBatch(w.c.Embedder, batch)
+	failed := -1
+	for i := range batch {
+		line := &batch[i]
+		if line.err != nil {
+			if failed < 0 {
+				failed = i
+			}
+			continue
+		}
+		if err := w.c.InsertEmbedding(line.key, line.text, line.embed); err != nil {
+			if errors.Is(err, types.ErrDuplicateKey) {
+				w.stats.duplicates++
+				continue
+			}
+			return false, fmt.Errorf("offset %d: %w", starts[i], err)
+		}
+		w.stats.inserted++
+	}
+
+	if failed >= 0 {
+		w.offset = starts[failed]
+		w.retryDelay = min(max(2*w.retryDelay, w.opts.poll), maxRetryDelay)
+		w.retryAt = time.Now().Add(w.retryDelay)
+		log.Printf("offset %d: %v; retrying in %s", starts[failed], batch[failed].err, w.retryDelay)
+		return false, nil
+	}
+	w.offset = end
+	w.retryDelay = 0
+	return true, nil
+}
+
+// watchFile tails path into c until stop receives, flushing every
+// flushInterval and at the end. The offset of the lines flushed is recorded
+// next to path, so a restart resumes where the last flush left off.
+func watchFile(c *client.Client, path, binary string, opts watchOptions, stop <-chan os.Signal) (stdinStats, error) {
+	offset, err := loadOffset(path, binary)
+	if err != nil {
+		return stdinStats{}, err
+	}
+
+	w := &watcher{c: c, path: path, opts: opts}
+	if err := w.open(offset); err != nil {
+		return stdinStats{}, err
+	}
+	defer func() { w.f.Close() }()
+	if w.offset > 0 {
+		log.Printf("Resuming %s at offset %d", path, w.offset)
+	}
+
+	saved, reported := w.offset, 0
+	flush := func() error {
+		if err := c.Flush(); err != nil {
+			return err
+		}
+		if w.offset != saved {
+			if err := saveOffset(path, binary, w.offset); err != nil {
+				return err
+			}
+			saved = w.offset
+		}
+		if w.stats.inserted != reported {
+			log.Printf("Inserted %d memories (%d duplicates), offset %d", w.stats.inserted, w.stats.duplicates, w.offset)
+			reported = w.stats.inserted
+		}
+		return nil
+	}
+
+	pollTicker := time.NewTicker(opts.poll)
+	defer pollTicker.Stop()
+	flushTicker := time.NewTicker(opts.flushInterval)
+	defer flushTicker.Stop()
+
+	for {
+		if err := w.poll(); err != nil {
+			// Keep what was inserted before the error
+			if flushErr := flush(); flushErr != nil {
+				log.Printf("Flush failed: %v", flushErr)
+			}
+			return w.stats, err
+		}
+
+		select {
+		case <-stop:
+			return w.stats, flush()
+		case <-flushTicker.C:
+			if err := flush(); err != nil {
+				return w.stats, err
+			}
+		case <-pollTicker.C:
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"Hippocampus/src/storage"
+	"flag"
+	"fmt"
+	"log"
+	"strings"
+)
+
+// hippocampus-migrate converts a database file between binary format versions:
+//
+//	hippocampus-migrate -from v1 -to v4 -in old.bin -out new.bin
+func main() {
+	from := flag.String("from", "v1", "format of the input file: v1 (headerless), v2, v3 or v4")
+	to := flag.String("to", "v4", "format to write: v1, v2, v3 or v4")
+	in := flag.String("in", "", "input database file")
+	out := flag.String("out", "", "output database file (may equal -in to migrate in place)")
+	flag.Parse()
+
+	if *in == "" || *out == "" {
+		log.Fatal("both -in and -out are required")
+	}
+
+	fromVersion, err := parseVersion(*from)
+	if err != nil {
+		log.Fatalf("invalid -from: %v", err)
+	}
+	toVersion, err := parseVersion(*to)
+	if err != nil {
+		log.Fatalf("invalid -to: %v", err)
+	}
+
+	if toVersion < storage.FormatV2 && fromVersion >= storage.FormatV2 {
+		log.Printf("warning: format v%d has no node IDs; keys will be dropped and read back as the node text", toVersion)
+	}
+	if toVersion < storage.FormatV4 && fromVersion >= storage.FormatV4 {
+		log.Printf("warning: format v%d has no node metadata; it will be dropped", toVersion)
+	}
+
+	report, err := storage.MigrateFile(*in, *out, fromVersion, toVersion, false)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	fmt.Printf("Migrated %d nodes from %s (%s) to %s (%s)\n", report.Nodes, *in, *from, *out, *to)
+}
+
+func parseVersion(s string) (uint32, error) {
+	switch strings.ToLower(s) {
+	case "v1", "1":
+		return storage.FormatV1, nil
+	case "v2", "2":
+		return storage.FormatV2, nil
+	case "v3", "3":
+		return storage.FormatV3, nil
+	case "v4", "4":
+		return storage.FormatV4, nil
+	default:
+		return 0, fmt.Errorf("unknown format %q (expected v1, v2, v3 or v4)", s)
+	}
+}
@@ -0,0 +1,112 @@
+package embedding
+
+import (
+	"Hippocampus/src/awsauth"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"time"
+)
+
+// BedrockEmbedder calls an Amazon Titan text embedding model on Bedrock,
+// asking for 512 dimensions. Requests are signed with AWS Signature Version
+// 4 using the credentials of the standard AWS environment variables.
+type BedrockEmbedder struct {
+	Endpoint        string // e.g. https://bedrock-runtime.us-east-1.amazonaws.com
+	Region          string
+	Model           string
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string // Only for temporary credentials
+	HTTPClient      *http.Client
+}
+
+// NewBedrockEmbedder reads credentials from AWS_ACCESS_KEY_ID,
+// AWS_SECRET_ACCESS_KEY and AWS_SESSION_TOKEN, and the region from
+// AWS_REGION or AWS_DEFAULT_REGION (default us-east-1). An empty endpoint
+// means the region's bedrock-runtime endpoint.
+func NewBedrockEmbedder(endpoint, model string) (*BedrockEmbedder, error) {
+	be := &BedrockEmbedder{
+		Endpoint:        endpoint,
+		Region:          os.Getenv("AWS_REGION"),
+		Model:           model,
+		AccessKeyID:     os.Getenv("AWS_ACCESS_KEY_ID"),
+		SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		SessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+		HTTPClient:      &http.Client{},
+	}
+	if be.Region == "" {
+		be.Region = os.Getenv("AWS_DEFAULT_REGION")
+	}
+	if be.Region == "" {
+		be.Region = "us-east-1"
+	}
+	if be.Endpoint == "" {
+		be.Endpoint = "https://bedrock-runtime." + be.Region + ".amazonaws.com"
+	}
+	if be.AccessKeyID == "" || be.SecretAccessKey == "" {
+		return nil, errors.New("bedrock embedder needs AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY")
+	}
+	return be, nil
+}
+
+func (be *BedrockEmbedder) GetEmbedding(ctx context.Context, text string) ([]float32, error) {
+	body, err := json.Marshal(struct {
+		InputText  string `json:"inputText"`
+		Dimensions int    `json:"dimensions"`
+		Normalize  bool   `json:"normalize"`
+	}{text, 512, true})
+	if err != nil {
+		return nil, fmt.Errorf("marshal error: %w", err)
+	}
+
+	path := "/model/" + awsauth.URIEncode(be.Model) + "/invoke"
+	req, err := http.NewRequestWithContext(ctx, "POST", be.Endpoint+path, bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("request creation error: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+	be.sign(req, body, time.Now())
+
+	resp, err := be.HTTPClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("http request error: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("embedding service error: status %d, body: %s", resp.StatusCode, string(bodyBytes))
+	}
+
+	var response struct {
+		Embedding []float32 `json:"embedding"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return nil, fmt.Errorf("unmarshal error: %w", err)
+	}
+	if err := checkDimensions(response.Embedding, be.Model); err != nil {
+		return nil, err
+	}
+	return response.Embedding, nil
+}
+
+func (be *BedrockEmbedder) ModelName() string {
+	return be.Model
+}
+
+// sign adds the Signature Version 4 headers for the bedrock service to req
+func (be *BedrockEmbedder) sign(req *http.Request, body []byte, now time.Time) {
+	creds := awsauth.Credentials{
+		AccessKeyID:     be.AccessKeyID,
+		SecretAccessKey: be.SecretAccessKey,
+		SessionToken:    be.SessionToken,
+	}
+	awsauth.Sign(req, body, "bedrock", be.Region, creds, now)
+}
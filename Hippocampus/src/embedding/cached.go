@@ -0,0 +1,244 @@
+package embedding
+
+import (
+	"container/list"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+)
+
+// BatchEmbedder is implemented by embedders that can embed several texts in
+// one call
+type BatchEmbedder interface {
+	GetEmbeddings(ctx context.Context, texts []string) ([][]float32, []error)
+}
+
+// batchConcurrency bounds the calls GetEmbeddings makes at once to embedders
+// without a batch call
+const batchConcurrency = 8
+
+// GetEmbeddings embeds every text, returning the embeddings and errors by
+// index. Embedders without a batch call get one call per text, a few at a
+// time.
+func GetEmbeddings(ctx context.Context, embedder EmbeddingService, texts []string) ([][]float32, []error) {
+	if be, ok := embedder.(BatchEmbedder); ok {
+		return be.GetEmbeddings(ctx, texts)
+	}
+
+	embeddings := make([][]float32, len(texts))
+	errs := make([]error, len(texts))
+	slots := make(chan struct{}, batchConcurrency)
+	var wg sync.WaitGroup
+	for i, text := range texts {
+		wg.Add(1)
+		slots <- struct{}{}
+		go func(i int, text string) {
+			defer wg.Done()
+			defer func() { <-slots }()
+			embeddings[i], errs[i] = embedder.GetEmbedding(ctx, text)
+		}(i, text)
+	}
+	wg.Wait()
+	return embeddings, errs
+}
+
+// CachedEmbedder remembers the embeddings of up to maxEntries texts, least
+// recently used first out, so repeated texts skip the embedding service.
+// Embeddings are deterministic per model, so a cache is only valid for the
+// model it was filled by.
+type CachedEmbedder struct {
+	inner      EmbeddingService
+	maxEntries int
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	order   *list.List // Front is the most recently used
+
+	hits   atomic.Int64
+	misses atomic.Int64
+}
+
+// cacheEntry is an element of CachedEmbedder.order, and of the file
+// written by SaveFile
+type cacheEntry struct {
+	Text      string    `json:"text"`
+	Embedding []float32 `json:"embedding"`
+}
+
+func NewCachedEmbedder(inner EmbeddingService, maxEntries int) *CachedEmbedder {
+	if maxEntries < 1 {
+		maxEntries = 1
+	}
+	return &CachedEmbedder{
+		inner:      inner,
+		maxEntries: maxEntries,
+		entries:    make(map[string]*list.Element),
+		order:      list.New(),
+	}
+}
+
+func (ce *CachedEmbedder) GetEmbedding(ctx context.Context, text string) ([]float32, error) {
+	if embedding, ok := ce.get(text); ok {
+		ce.hits.Add(1)
+		return embedding, nil
+	}
+	ce.misses.Add(1)
+
+	embedding, err := ce.inner.GetEmbedding(ctx, text)
+	if err != nil {
+		return nil, err
+	}
+	ce.put(text, embedding)
+	return embedding, nil
+}
+
+// GetEmbeddings embeds the texts not in the cache with one batch from the
+// inner embedder, caching the results
+func (ce *CachedEmbedder) GetEmbeddings(ctx context.Context, texts []string) ([][]float32, []error) {
+	embeddings := make([][]float32, len(texts))
+	errs := make([]error, len(texts))
+
+	var missing []string
+	var missingIdx []int
+	for i, text := range texts {
+		if embedding, ok := ce.get(text); ok {
+			ce.hits.Add(1)
+			embeddings[i] = embedding
+			continue
+		}
+		ce.misses.Add(1)
+		missing = append(missing, text)
+		missingIdx = append(missingIdx, i)
+	}
+	if len(missing) == 0 {
+		return embeddings, errs
+	}
+
+	fetched, fetchErrs := GetEmbeddings(ctx, ce.inner, missing)
+	for j, i := range missingIdx {
+		if fetchErrs[j] != nil {
+			errs[i] = fetchErrs[j]
+			continue
+		}
+		ce.put(missing[j], fetched[j])
+		embeddings[i] = fetched[j]
+	}
+	return embeddings, errs
+}
+
+// ModelName names the model of the wrapped embedder
+func (ce *CachedEmbedder) ModelName() string {
+	return ModelName(ce.inner)
+}
+
+// get returns a copy of the cached embedding of text, so callers can't
+// change the cache through it
+func (ce *CachedEmbedder) get(text string) ([]float32, bool) {
+	ce.mu.Lock()
+	defer ce.mu.Unlock()
+
+	elem, ok := ce.entries[text]
+	if !ok {
+		return nil, false
+	}
+	ce.order.MoveToFront(elem)
+	return append([]float32(nil), elem.Value.(*cacheEntry).Embedding...), true
+}
+
+func (ce *CachedEmbedder) put(text string, embedding []float32) {
+	ce.mu.Lock()
+	defer ce.mu.Unlock()
+
+	embedding = append([]float32(nil), embedding...)
+	if elem, ok := ce.entries[text]; ok {
+		elem.Value.(*cacheEntry).Embedding = embedding
+		ce.order.MoveToFront(elem)
+		return
+	}
+
+	ce.entries[text] = ce.order.PushFront(&cacheEntry{Text: text, Embedding: embedding})
+	for ce.order.Len() > ce.maxEntries {
+		oldest := ce.order.Back()
+		ce.order.Remove(oldest)
+		delete(ce.entries, oldest.Value.(*cacheEntry).Text)
+	}
+}
+
+// Len returns the number of cached embeddings
+func (ce *CachedEmbedder) Len() int {
+	ce.mu.Lock()
+	defer ce.mu.Unlock()
+	return ce.order.Len()
+}
+
+// CacheStats counts the lookups of a CachedEmbedder
+type CacheStats struct {
+	Entries int
+	Hits    int64
+	Misses  int64
+}
+
+func (ce *CachedEmbedder) Stats() CacheStats {
+	return CacheStats{
+		Entries: ce.Len(),
+		Hits:    ce.hits.Load(),
+		Misses:  ce.misses.Load(),
+	}
+}
+
+// cacheFile is the JSON file written by SaveFile
+type cacheFile struct {
+	Model   string       `json:"model"`
+	Entries []cacheEntry `json:"entries"`
+}
+
+// SaveFile writes the cached embeddings to path, most recently used first,
+// recording the model they come from
+func (ce *CachedEmbedder) SaveFile(path string) error {
+	ce.mu.Lock()
+	cf := cacheFile{Model: ce.ModelName(), Entries: make([]cacheEntry, 0, ce.order.Len())}
+	for elem := ce.order.Front(); elem != nil; elem = elem.Next() {
+		cf.Entries = append(cf.Entries, *elem.Value.(*cacheEntry))
+	}
+	ce.mu.Unlock()
+
+	data, err := json.Marshal(cf)
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// LoadFile adds the embeddings saved by SaveFile to the cache. A file saved
+// for another model is refused, since its embeddings would not match the
+// inner embedder's.
+func (ce *CachedEmbedder) LoadFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	var cf cacheFile
+	if err := json.Unmarshal(data, &cf); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	if model := ce.ModelName(); cf.Model != model {
+		return fmt.Errorf("%s holds embeddings from model %q, not %q", path, cf.Model, model)
+	}
+
+	// Oldest first, so the saved order of use is kept
+	for i := len(cf.Entries) - 1; i >= 0; i-- {
+		if len(cf.Entries[i].Embedding) != 512 {
+			return fmt.Errorf("%w: %s has an embedding of %d dimensions", ErrDimensionMismatch, path, len(cf.Entries[i].Embedding))
+		}
+		ce.put(cf.Entries[i].Text, cf.Entries[i].Embedding)
+	}
+	return nil
+}
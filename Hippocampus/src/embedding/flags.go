@@ -0,0 +1,168 @@
+package embedding
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+)
+
+// Embedder names accepted by -embedder
+const (
+	EmbedderMock    = "mock"
+	EmbedderLocal   = "local"
+	EmbedderOpenAI  = "openai"
+	EmbedderOllama  = "ollama"
+	EmbedderBedrock = "bedrock"
+)
+
+// Flags are the embedder-selection flags shared by the CLI subcommands and
+// the server, so every command takes the same -embedder, -embed-url,
+// -embed-model and -embed-api-key-env
+type Flags struct {
+	Embedder  string
+	URL       string // Empty means the provider's default
+	Model     string // Empty means the provider's default
+	APIKeyEnv string // Empty means the provider's default
+
+	mock mockFlag
+}
+
+// mockFlag is the deprecated -mock boolean. It is kept apart from -embedder
+// so an explicit -mock still wins over HIPPO_EMBEDDER or the config file.
+type mockFlag struct {
+	set   bool
+	value bool
+}
+
+func (m *mockFlag) String() string {
+	if m == nil {
+		return "false"
+	}
+	return strconv.FormatBool(m.value)
+}
+
+func (m *mockFlag) Set(s string) error {
+	v, err := strconv.ParseBool(s)
+	if err != nil {
+		return err
+	}
+	m.set, m.value = true, v
+	return nil
+}
+
+func (m *mockFlag) IsBoolFlag() bool { return true }
+
+// RegisterFlags adds -embedder, -embed-url, -embed-model, -embed-api-key-env
+// and the deprecated -mock to fs
+func RegisterFlags(fs *flag.FlagSet) *Flags {
+	f := &Flags{}
+	fs.StringVar(&f.Embedder, "embedder", EmbedderLocal, "embedder: mock, local, openai, ollama or bedrock")
+	fs.StringVar(&f.URL, "embed-url", "", "embedding service URL (default depends on -embedder)")
+	fs.StringVar(&f.Model, "embed-model", "", "embedding model (openai, ollama and bedrock)")
+	fs.StringVar(&f.APIKeyEnv, "embed-api-key-env", "", "environment variable holding the API key (default OPENAI_API_KEY for openai)")
+	fs.Var(&f.mock, "mock", "deprecated: use -embedder mock or -embedder local")
+	return f
+}
+
+// name returns the selected embedder, applying the deprecated -mock
+func (f *Flags) name() string {
+	if f.mock.set {
+		if f.mock.value {
+			return EmbedderMock
+		}
+		return EmbedderLocal
+	}
+	return f.Embedder
+}
+
+// url returns -embed-url or the provider's default
+func (f *Flags) url() string {
+	if f.URL != "" {
+		return f.URL
+	}
+	switch f.name() {
+	case EmbedderLocal:
+		return "http://localhost:8080"
+	case EmbedderOpenAI:
+		return "https://api.openai.com"
+	case EmbedderOllama:
+		return "http://localhost:11434"
+	}
+	return ""
+}
+
+// model returns -embed-model or the provider's default. Ollama has none:
+// few of its models produce 512 dimensions, so it has to be chosen.
+func (f *Flags) model() string {
+	if f.Model != "" {
+		return f.Model
+	}
+	switch f.name() {
+	case EmbedderOpenAI:
+		return "text-embedding-3-small"
+	case EmbedderBedrock:
+		return "amazon.titan-embed-text-v2:0"
+	}
+	return ""
+}
+
+// NewAt is New with url in place of -embed-url, for an embedder of the same
+// kind and model at another service
+func (f *Flags) NewAt(url string) (EmbeddingService, error) {
+	at := *f
+	at.URL = url
+	return at.New()
+}
+
+// New returns the embedder selected by the parsed flags. The mock embedder
+// is logged loudly, since its vectors are useless for real data.
+func (f *Flags) New() (EmbeddingService, error) {
+	if f.mock.set {
+		log.Printf("-mock is deprecated and will be removed; use -embedder %s", f.name())
+	}
+
+	switch f.name() {
+	case EmbedderMock:
+		log.Printf("WARNING: using the mock embedder. Its embeddings are pseudo-random, so search results are meaningless; do not use it for real data")
+		return NewMockEmbedder(), nil
+	case EmbedderLocal:
+		return NewLocalEmbedder(f.url()), nil
+	case EmbedderOpenAI:
+		keyEnv := f.APIKeyEnv
+		if keyEnv == "" {
+			keyEnv = "OPENAI_API_KEY"
+		}
+		key := os.Getenv(keyEnv)
+		if key == "" {
+			return nil, fmt.Errorf("openai embedder needs an API key in $%s", keyEnv)
+		}
+		return NewOpenAIEmbedder(f.url(), f.model(), key), nil
+	case EmbedderOllama:
+		if f.model() == "" {
+			return nil, fmt.Errorf("ollama embedder needs -embed-model (a model producing 512 dimensions)")
+		}
+		return NewOllamaEmbedder(f.url(), f.model()), nil
+	case EmbedderBedrock:
+		return NewBedrockEmbedder(f.URL, f.model())
+	}
+	return nil, fmt.Errorf("unknown embedder %q (expected mock, local, openai, ollama or bedrock)", f.name())
+}
+
+// String describes the selected embedder for logs
+func (f *Flags) String() string {
+	switch f.name() {
+	case EmbedderMock:
+		return "mock embedder (deterministic pseudo-random embeddings)"
+	case EmbedderLocal:
+		return "local embedding service at " + f.url()
+	case EmbedderOpenAI:
+		return fmt.Sprintf("OpenAI embeddings (%s) at %s", f.model(), f.url())
+	case EmbedderOllama:
+		return fmt.Sprintf("Ollama embeddings (%s) at %s", f.model(), f.url())
+	case EmbedderBedrock:
+		return fmt.Sprintf("Bedrock embeddings (%s)", f.model())
+	}
+	return f.name() + " embedder"
+}
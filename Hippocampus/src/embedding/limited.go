@@ -0,0 +1,154 @@
+package embedding
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrQueueFull is returned by LimitedEmbedder when too many calls are already waiting
+var ErrQueueFull = errors.New("embedding queue full")
+
+// LimitedEmbedder bounds the number of concurrent calls to another
+// EmbeddingService. Calls beyond the limit wait in a queue of bounded depth;
+// calls that would overflow the queue fail fast with ErrQueueFull.
+type LimitedEmbedder struct {
+	inner    EmbeddingService
+	slots    chan struct{}
+	maxQueue int64
+
+	queued    atomic.Int64
+	inFlight  atomic.Int64
+	rejected  atomic.Int64
+	waits     atomic.Int64 // Calls that had to wait for a slot
+	waitNanos atomic.Int64 // Total time spent waiting
+
+	mu      sync.Mutex
+	maxWait time.Duration
+}
+
+// NewLimitedEmbedder allows concurrency simultaneous calls to inner, with up
+// to maxQueue more waiting. A maxQueue of 0 rejects calls as soon as every slot is busy.
+func NewLimitedEmbedder(inner EmbeddingService, concurrency, maxQueue int) *LimitedEmbedder {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	if maxQueue < 0 {
+		maxQueue = 0
+	}
+	return &LimitedEmbedder{
+		inner:    inner,
+		slots:    make(chan struct{}, concurrency),
+		maxQueue: int64(maxQueue),
+	}
+}
+
+func (le *LimitedEmbedder) GetEmbedding(ctx context.Context, text string) ([]float32, error) {
+	if err := le.acquire(ctx); err != nil {
+		return nil, err
+	}
+	defer le.release()
+
+	return le.inner.GetEmbedding(ctx, text)
+}
+
+// ModelName names the model of the wrapped embedder
+func (le *LimitedEmbedder) ModelName() string {
+	return ModelName(le.inner)
+}
+
+// Through returns an embedder calling inner within le's limit, so embedders
+// created after le share its slots, queue and stats
+func (le *LimitedEmbedder) Through(inner EmbeddingService) EmbeddingService {
+	return &sharedLimit{limit: le, inner: inner}
+}
+
+// sharedLimit is an embedder returned by LimitedEmbedder.Through
+type sharedLimit struct {
+	limit *LimitedEmbedder
+	inner EmbeddingService
+}
+
+func (sl *sharedLimit) GetEmbedding(ctx context.Context, text string) ([]float32, error) {
+	if err := sl.limit.acquire(ctx); err != nil {
+		return nil, err
+	}
+	defer sl.limit.release()
+
+	return sl.inner.GetEmbedding(ctx, text)
+}
+
+func (sl *sharedLimit) ModelName() string {
+	return ModelName(sl.inner)
+}
+
+func (le *LimitedEmbedder) acquire(ctx context.Context) error {
+	select {
+	case le.slots <- struct{}{}:
+		le.inFlight.Add(1)
+		return nil
+	default:
+	}
+
+	if le.queued.Add(1) > le.maxQueue {
+		le.queued.Add(-1)
+		le.rejected.Add(1)
+		return ErrQueueFull
+	}
+	defer le.queued.Add(-1)
+
+	start := time.Now()
+	select {
+	case le.slots <- struct{}{}:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	le.inFlight.Add(1)
+
+	wait := time.Since(start)
+	le.waits.Add(1)
+	le.waitNanos.Add(int64(wait))
+	le.mu.Lock()
+	if wait > le.maxWait {
+		le.maxWait = wait
+	}
+	le.mu.Unlock()
+	return nil
+}
+
+func (le *LimitedEmbedder) release() {
+	le.inFlight.Add(-1)
+	<-le.slots
+}
+
+// LimitedEmbedderStats is a snapshot of a LimitedEmbedder's queue
+type LimitedEmbedderStats struct {
+	Concurrency int
+	MaxQueue    int
+	InFlight    int64
+	Queued      int64
+	Rejected    int64
+	Waits       int64         // Calls that waited for a slot
+	AvgWait     time.Duration // Mean wait of the calls that waited
+	MaxWait     time.Duration
+}
+
+func (le *LimitedEmbedder) Stats() LimitedEmbedderStats {
+	stats := LimitedEmbedderStats{
+		Concurrency: cap(le.slots),
+		MaxQueue:    int(le.maxQueue),
+		InFlight:    le.inFlight.Load(),
+		Queued:      le.queued.Load(),
+		Rejected:    le.rejected.Load(),
+		Waits:       le.waits.Load(),
+	}
+	if stats.Waits > 0 {
+		stats.AvgWait = time.Duration(le.waitNanos.Load() / stats.Waits)
+	}
+	le.mu.Lock()
+	stats.MaxWait = le.maxWait
+	le.mu.Unlock()
+	return stats
+}
@@ -0,0 +1,182 @@
+package embedding
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// blockingEmbedder holds every call until release is closed, tracking how
+// many run at once
+type blockingEmbedder struct {
+	started chan string
+	release chan struct{}
+
+	running atomic.Int64
+	peak    atomic.Int64
+}
+
+func newBlockingEmbedder() *blockingEmbedder {
+	return &blockingEmbedder{started: make(chan string, 100), release: make(chan struct{})}
+}
+
+func (be *blockingEmbedder) GetEmbedding(ctx context.Context, text string) ([]float32, error) {
+	n := be.running.Add(1)
+	defer be.running.Add(-1)
+	for {
+		peak := be.peak.Load()
+		if n <= peak || be.peak.CompareAndSwap(peak, n) {
+			break
+		}
+	}
+	be.started <- text
+	<-be.release
+	return make([]float32, 512), nil
+}
+
+// waitStarted waits for n calls to reach the inner embedder
+func (be *blockingEmbedder) waitStarted(t *testing.T, n int) {
+	t.Helper()
+	for range n {
+		select {
+		case <-be.started:
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for a call to start")
+		}
+	}
+}
+
+// waitFor polls cond, which the limiter updates from other goroutines
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestLimitedEmbedderLimits(t *testing.T) {
+	inner := newBlockingEmbedder()
+	le := NewLimitedEmbedder(inner, 2, 3)
+
+	// Two calls run and three wait; the sixth is refused at once
+	var wg sync.WaitGroup
+	errs := make(chan error, 5)
+	for range 5 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := le.GetEmbedding(context.Background(), "text")
+			errs <- err
+		}()
+	}
+	inner.waitStarted(t, 2)
+	waitFor(t, "three queued calls", func() bool { return le.Stats().Queued == 3 })
+
+	if _, err := le.GetEmbedding(context.Background(), "text"); !errors.Is(err, ErrQueueFull) {
+		t.Fatalf("err = %v with the queue full, want ErrQueueFull", err)
+	}
+	stats := le.Stats()
+	if stats.Concurrency != 2 || stats.MaxQueue != 3 || stats.InFlight != 2 || stats.Rejected != 1 {
+		t.Fatalf("stats %+v", stats)
+	}
+
+	close(inner.release)
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if peak := inner.peak.Load(); peak != 2 {
+		t.Fatalf("%d calls ran at once, want at most 2", peak)
+	}
+	stats = le.Stats()
+	if stats.InFlight != 0 || stats.Queued != 0 || stats.Waits != 3 || stats.MaxWait < stats.AvgWait {
+		t.Fatalf("stats %+v after the calls finished", stats)
+	}
+}
+
+func TestLimitedEmbedderWithoutQueue(t *testing.T) {
+	inner := newBlockingEmbedder()
+	le := NewLimitedEmbedder(inner, 0, -1) // Clamped to 1 and 0
+
+	done := make(chan error)
+	go func() {
+		_, err := le.GetEmbedding(context.Background(), "text")
+		done <- err
+	}()
+	inner.waitStarted(t, 1)
+
+	if _, err := le.GetEmbedding(context.Background(), "text"); !errors.Is(err, ErrQueueFull) {
+		t.Fatalf("err = %v with every slot busy, want ErrQueueFull", err)
+	}
+	close(inner.release)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLimitedEmbedderCancel(t *testing.T) {
+	inner := newBlockingEmbedder()
+	le := NewLimitedEmbedder(inner, 1, 5)
+
+	go le.GetEmbedding(context.Background(), "running")
+	inner.waitStarted(t, 1)
+
+	// A waiting call gives up its place in the queue when cancelled
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		_, err := le.GetEmbedding(ctx, "waiting")
+		done <- err
+	}()
+	waitFor(t, "the call to queue", func() bool { return le.Stats().Queued == 1 })
+	cancel()
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Fatalf("err = %v, want context.Canceled", err)
+	}
+
+	// An expired deadline fails the same way
+	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := le.GetEmbedding(ctx, "late"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("err = %v, want context.DeadlineExceeded", err)
+	}
+
+	stats := le.Stats()
+	if stats.Queued != 0 || stats.InFlight != 1 || stats.Waits != 0 {
+		t.Fatalf("stats %+v after cancelled waits", stats)
+	}
+	close(inner.release)
+	waitFor(t, "the running call", func() bool { return le.Stats().InFlight == 0 })
+}
+
+func TestLimitedEmbedderThroughSharesLimit(t *testing.T) {
+	first, second := newBlockingEmbedder(), newBlockingEmbedder()
+	le := NewLimitedEmbedder(first, 1, 0)
+	shared := le.Through(second)
+
+	go le.GetEmbedding(context.Background(), "text")
+	first.waitStarted(t, 1)
+	if _, err := shared.GetEmbedding(context.Background(), "text"); !errors.Is(err, ErrQueueFull) {
+		t.Fatalf("err = %v through a busy limit, want ErrQueueFull", err)
+	}
+	close(first.release)
+	close(second.release)
+	waitFor(t, "the running call", func() bool { return le.Stats().InFlight == 0 })
+
+	if _, err := shared.GetEmbedding(context.Background(), "text"); err != nil {
+		t.Fatal(err)
+	}
+	if ModelName(shared) != ModelName(second) {
+		t.Fatalf("ModelName = %q", ModelName(shared))
+	}
+}
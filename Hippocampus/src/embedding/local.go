@@ -4,9 +4,11 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"sync"
 )
 
 type LocalEmbeddingRequest struct {
@@ -17,11 +19,45 @@ type LocalEmbeddingResponse struct {
 	Embedding []float32 `json:"embedding"`
 }
 
+// ErrDimensionMismatch is returned for embeddings that don't have the 512
+// dimensions a tree stores
+var ErrDimensionMismatch = errors.New("embedding dimension mismatch")
+
 // EmbeddingService interface for flexibility
 type EmbeddingService interface {
 	GetEmbedding(ctx context.Context, text string) ([]float32, error)
 }
 
+// ModelNamer is implemented by embedders that can say which model their
+// embeddings come from
+type ModelNamer interface {
+	ModelName() string
+}
+
+// ModelName names the model behind embedder, or returns "" if it can't say.
+// Embeddings from different models aren't comparable, even at the same
+// dimension.
+func ModelName(embedder EmbeddingService) string {
+	if mn, ok := embedder.(ModelNamer); ok {
+		return mn.ModelName()
+	}
+	return ""
+}
+
+// Ping checks that embedder answers by embedding a short text, failing if
+// it errors or doesn't return 512 dimensions. Paid services charge for the
+// call, so callers probing regularly should cache the result.
+func Ping(ctx context.Context, embedder EmbeddingService) error {
+	embedding, err := embedder.GetEmbedding(ctx, "ping")
+	if err != nil {
+		return err
+	}
+	if len(embedding) != 512 {
+		return fmt.Errorf("%w: expected 512 dimensions, got %d", ErrDimensionMismatch, len(embedding))
+	}
+	return nil
+}
+
 // LocalEmbedder uses a local HTTP embedding service
 type LocalEmbedder struct {
 	ServiceURL string
@@ -68,22 +104,99 @@ func (le *LocalEmbedder) GetEmbedding(ctx context.Context, text string) ([]float
 	}
 
 	if len(response.Embedding) != 512 {
-		return nil, fmt.Errorf("expected 512 dimensions, got %d", len(response.Embedding))
+		return nil, fmt.Errorf("%w: expected 512 dimensions, got %d", ErrDimensionMismatch, len(response.Embedding))
 	}
 
 	return response.Embedding, nil
 }
 
-// Simple mock embedder for testing (generates random-ish embeddings)
-type MockEmbedder struct{}
+// ModelName returns the service URL: the service doesn't report its model,
+// so the URL is what tells two of them apart
+func (le *LocalEmbedder) ModelName() string {
+	return le.ServiceURL
+}
+
+// Simple mock embedder for testing (generates random-ish embeddings).
+// Texts given a response or error with SetResponse or SetError get that
+// instead, so a test can place embeddings exactly.
+type MockEmbedder struct {
+	mu        sync.RWMutex
+	responses map[string][]float32
+	errs      map[string]error
+}
 
 func NewMockEmbedder() *MockEmbedder {
 	return &MockEmbedder{}
 }
 
+// SetResponse makes GetEmbedding return embedding for text. Embeddings of
+// another size than 512 are returned as given, to test mismatches.
+func (me *MockEmbedder) SetResponse(text string, embedding []float32) {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+
+	if me.responses == nil {
+		me.responses = make(map[string][]float32)
+	}
+	me.responses[text] = append([]float32(nil), embedding...)
+	delete(me.errs, text)
+}
+
+// SetError makes GetEmbedding fail with err for text
+func (me *MockEmbedder) SetError(text string, err error) {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+
+	if me.errs == nil {
+		me.errs = make(map[string]error)
+	}
+	me.errs[text] = err
+	delete(me.responses, text)
+}
+
+// registered returns the response or error set for text, if any
+func (me *MockEmbedder) registered(text string) (embedding []float32, found bool, err error) {
+	me.mu.RLock()
+	defer me.mu.RUnlock()
+
+	if err, ok := me.errs[text]; ok {
+		return nil, true, err
+	}
+	if embedding, ok := me.responses[text]; ok {
+		return append([]float32(nil), embedding...), true, nil
+	}
+	return nil, false, nil
+}
+
 func (me *MockEmbedder) GetEmbedding(ctx context.Context, text string) ([]float32, error) {
+	if embedding, ok, err := me.registered(text); ok {
+		return embedding, err
+	}
+
+	embedding := new([512]float32)
+	me.GetEmbeddingInto(ctx, text, embedding)
+	return embedding[:], nil
+}
+
+func (me *MockEmbedder) ModelName() string {
+	return EmbedderMock
+}
+
+// GetEmbeddingInto writes the embedding of text straight into dst, so bulk
+// inserts in tests don't allocate a slice per call
+func (me *MockEmbedder) GetEmbeddingInto(ctx context.Context, text string, dst *[512]float32) error {
+	if embedding, ok, err := me.registered(text); ok {
+		if err != nil {
+			return err
+		}
+		if len(embedding) != 512 {
+			return fmt.Errorf("%w: expected 512 dimensions, got %d", ErrDimensionMismatch, len(embedding))
+		}
+		copy(dst[:], embedding)
+		return nil
+	}
+
 	// Generate deterministic pseudo-random embedding based on text hash
-	embedding := make([]float32, 512)
 	hash := 0
 	for _, c := range text {
 		hash = (hash*31 + int(c)) % 1000000
@@ -91,20 +204,45 @@ func (me *MockEmbedder) GetEmbedding(ctx context.Context, text string) ([]float3
 
 	for i := 0; i < 512; i++ {
 		hash = (hash*1103515245 + 12345) % 1000000
-		embedding[i] = float32(hash) / 1000000.0
+		dst[i] = float32(hash) / 1000000.0
 	}
 
 	// Normalize
 	var sum float32
-	for _, v := range embedding {
+	for _, v := range dst {
 		sum += v * v
 	}
 	norm := float32(1.0) / float32(sum)
-	for i := range embedding {
-		embedding[i] *= norm
+	for i := range dst {
+		dst[i] *= norm
 	}
 
-	return embedding, nil
+	return nil
+}
+
+// IntoEmbedder is implemented by embedders that can fill a caller's array
+// instead of returning a new slice
+type IntoEmbedder interface {
+	GetEmbeddingInto(ctx context.Context, text string, dst *[512]float32) error
+}
+
+// GetEmbeddingInto embeds text into dst, without allocating when the embedder
+// implements IntoEmbedder. Embeddings of another size than 512 are refused
+// with ErrDimensionMismatch.
+func GetEmbeddingInto(ctx context.Context, embedder EmbeddingService, text string, dst *[512]float32) error {
+	if ie, ok := embedder.(IntoEmbedder); ok {
+		return ie.GetEmbeddingInto(ctx, text, dst)
+	}
+
+	embedding, err := embedder.GetEmbedding(ctx, text)
+	if err != nil {
+		return err
+	}
+	if len(embedding) != 512 {
+		return fmt.Errorf("%w: expected 512 dimensions, got %d", ErrDimensionMismatch, len(embedding))
+	}
+	copy(dst[:], embedding)
+	return nil
 }
 
 // GetEmbedding is the main function that external packages call
@@ -0,0 +1,98 @@
+package embedding
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+)
+
+// unit returns a 512-dimension embedding with a single non-zero dimension
+func unit(dim int) []float32 {
+	embedding := make([]float32, 512)
+	embedding[dim] = 1
+	return embedding
+}
+
+func TestMockEmbedderSetResponse(t *testing.T) {
+	ctx := context.Background()
+	me := NewMockEmbedder()
+	fallback, err := me.GetEmbedding(ctx, "tea")
+	if err != nil || len(fallback) != 512 {
+		t.Fatalf("GetEmbedding before SetResponse = %d dims, %v", len(fallback), err)
+	}
+
+	want := unit(3)
+	me.SetResponse("tea", want)
+	want[3] = 2 // The mock keeps its own copy
+
+	got, err := me.GetEmbedding(ctx, "tea")
+	if err != nil || !slices.Equal(got, unit(3)) {
+		t.Fatalf("GetEmbedding = %v, %v, want the set response", got[:4], err)
+	}
+	got[3] = 5 // Nor does it hand out the copy
+	if again, _ := me.GetEmbedding(ctx, "tea"); !slices.Equal(again, unit(3)) {
+		t.Fatal("changing a returned embedding changed the set response")
+	}
+
+	var dst [512]float32
+	if err := me.GetEmbeddingInto(ctx, "tea", &dst); err != nil || !slices.Equal(dst[:], unit(3)) {
+		t.Fatalf("GetEmbeddingInto = %v, %v, want the set response", dst[:4], err)
+	}
+
+	// Other texts still get the hash-based embedding, which is deterministic
+	other, _ := me.GetEmbedding(ctx, "coffee")
+	if otherAgain, _ := NewMockEmbedder().GetEmbedding(ctx, "coffee"); !slices.Equal(other, otherAgain) {
+		t.Fatal("hash-based embeddings differ between mocks")
+	}
+	if slices.Equal(other, fallback) {
+		t.Fatal("two texts got the same hash-based embedding")
+	}
+}
+
+func TestMockEmbedderSetError(t *testing.T) {
+	ctx := context.Background()
+	me := NewMockEmbedder()
+	errDown := errors.New("service down")
+
+	me.SetResponse("tea", unit(1))
+	me.SetError("tea", errDown)
+	if _, err := me.GetEmbedding(ctx, "tea"); !errors.Is(err, errDown) {
+		t.Fatalf("err = %v, want the set error", err)
+	}
+	var dst [512]float32
+	if err := me.GetEmbeddingInto(ctx, "tea", &dst); !errors.Is(err, errDown) {
+		t.Fatalf("GetEmbeddingInto err = %v, want the set error", err)
+	}
+	if _, err := me.GetEmbedding(ctx, "coffee"); err != nil {
+		t.Fatalf("error set for another text: %v", err)
+	}
+
+	// The latest call wins
+	me.SetResponse("tea", unit(2))
+	if got, err := me.GetEmbedding(ctx, "tea"); err != nil || !slices.Equal(got, unit(2)) {
+		t.Fatalf("GetEmbedding after SetResponse = %v", err)
+	}
+}
+
+func TestMockEmbedderWrongSize(t *testing.T) {
+	ctx := context.Background()
+	me := NewMockEmbedder()
+	me.SetResponse("short", []float32{1, 2, 3})
+
+	// Returned as set, so callers' dimension checks can be tested
+	if got, err := me.GetEmbedding(ctx, "short"); err != nil || len(got) != 3 {
+		t.Fatalf("GetEmbedding = %v, %v", got, err)
+	}
+	var dst [512]float32
+	if err := GetEmbeddingInto(ctx, me, "short", &dst); !errors.Is(err, ErrDimensionMismatch) {
+		t.Fatalf("err = %v, want ErrDimensionMismatch", err)
+	}
+	if err := Ping(ctx, me); err != nil {
+		t.Fatalf("Ping of a mock: %v", err)
+	}
+	me.SetResponse("ping", []float32{1})
+	if err := Ping(ctx, me); err == nil {
+		t.Fatal("Ping accepted a 1-dimension embedding")
+	}
+}
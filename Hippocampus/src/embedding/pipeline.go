@@ -0,0 +1,135 @@
+package embedding
+
+import (
+	"context"
+	"math"
+	"strings"
+	"unicode"
+)
+
+// Preprocessor rewrites text before it is embedded
+type Preprocessor func(text string) string
+
+// Postprocessor rewrites an embedding after the service returns it. It may
+// change the embedding in place.
+type Postprocessor func(embedding []float32) []float32
+
+// EmbeddingPipeline builds an embedder that runs text through preprocessors,
+// embeds it with a service, then runs the embedding through postprocessors,
+// each in the order added:
+//
+//	embedder := NewEmbeddingPipeline(service).
+//		WithPreprocessor(NormalizeWhitespace).
+//		WithPreprocessor(TruncateText(2000)).
+//		WithPostProcessor(TruncateDimensions(512)).
+//		WithPostProcessor(L2Normalize).
+//		Build()
+type EmbeddingPipeline struct {
+	service EmbeddingService
+	pre     []Preprocessor
+	post    []Postprocessor
+}
+
+func NewEmbeddingPipeline(service EmbeddingService) *EmbeddingPipeline {
+	return &EmbeddingPipeline{service: service}
+}
+
+func (p *EmbeddingPipeline) WithPreprocessor(pre Preprocessor) *EmbeddingPipeline {
+	p.pre = append(p.pre, pre)
+	return p
+}
+
+func (p *EmbeddingPipeline) WithPostProcessor(post Postprocessor) *EmbeddingPipeline {
+	p.post = append(p.post, post)
+	return p
+}
+
+// Build returns the embedder. Later changes to p don't affect it.
+func (p *EmbeddingPipeline) Build() EmbeddingService {
+	return &pipelineEmbedder{
+		service: p.service,
+		pre:     append([]Preprocessor(nil), p.pre...),
+		post:    append([]Postprocessor(nil), p.post...),
+	}
+}
+
+// pipelineEmbedder is an embedder returned by EmbeddingPipeline.Build
+type pipelineEmbedder struct {
+	service EmbeddingService
+	pre     []Preprocessor
+	post    []Postprocessor
+}
+
+func (pe *pipelineEmbedder) GetEmbedding(ctx context.Context, text string) ([]float32, error) {
+	for _, pre := range pe.pre {
+		text = pre(text)
+	}
+	embedding, err := pe.service.GetEmbedding(ctx, text)
+	if err != nil {
+		return nil, err
+	}
+	for _, post := range pe.post {
+		embedding = post(embedding)
+	}
+	return embedding, nil
+}
+
+// ModelName names the model of the wrapped embedder
+func (pe *pipelineEmbedder) ModelName() string {
+	return ModelName(pe.service)
+}
+
+// TruncateText keeps the first maxRunes characters of text, for services
+// that reject long inputs
+func TruncateText(maxRunes int) Preprocessor {
+	return func(text string) string {
+		n := 0
+		for i := range text {
+			if n == maxRunes {
+				return text[:i]
+			}
+			n++
+		}
+		return text
+	}
+}
+
+// NormalizeWhitespace trims text and collapses each run of whitespace to a
+// single space
+func NormalizeWhitespace(text string) string {
+	return strings.Join(strings.FieldsFunc(text, unicode.IsSpace), " ")
+}
+
+// Lowercase lowercases text, so queries differing only in case embed alike
+func Lowercase(text string) string {
+	return strings.ToLower(text)
+}
+
+// L2Normalize scales an embedding to unit length. A zero vector is left as is.
+func L2Normalize(embedding []float32) []float32 {
+	var sum float64
+	for _, v := range embedding {
+		sum += float64(v) * float64(v)
+	}
+	if sum == 0 {
+		return embedding
+	}
+	scale := float32(1 / math.Sqrt(sum))
+	for i := range embedding {
+		embedding[i] *= scale
+	}
+	return embedding
+}
+
+// TruncateDimensions keeps the first dims dimensions of longer embeddings,
+// e.g. to store a Matryoshka model's 1024-dimension output in the 512 the
+// tree holds. Follow it with L2Normalize if the model's vectors were unit
+// length.
+func TruncateDimensions(dims int) Postprocessor {
+	return func(embedding []float32) []float32 {
+		if len(embedding) > dims {
+			return embedding[:dims]
+		}
+		return embedding
+	}
+}
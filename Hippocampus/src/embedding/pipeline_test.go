@@ -0,0 +1,193 @@
+package embedding
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// recordingEmbedder returns the length of each text in dimension 0 and a
+// 1 in dimension 1, failing texts containing "fail"
+type recordingEmbedder struct {
+	mu    sync.Mutex
+	texts []string
+}
+
+func (re *recordingEmbedder) GetEmbedding(ctx context.Context, text string) ([]float32, error) {
+	re.mu.Lock()
+	re.texts = append(re.texts, text)
+	re.mu.Unlock()
+	if strings.Contains(text, "fail") {
+		return nil, fmt.Errorf("cannot embed %q", text)
+	}
+	embedding := make([]float32, 1024)
+	embedding[0], embedding[1] = float32(len(text)), 1
+	return embedding, nil
+}
+
+func (re *recordingEmbedder) ModelName() string { return "recording" }
+
+func TestPipelineOrder(t *testing.T) {
+	service := &recordingEmbedder{}
+	var steps []string
+	step := func(name string) Preprocessor {
+		return func(text string) string {
+			steps = append(steps, name)
+			return text + "|" + name
+		}
+	}
+	post := func(name string) Postprocessor {
+		return func(embedding []float32) []float32 {
+			steps = append(steps, name)
+			return append(embedding, float32(len(steps)))
+		}
+	}
+
+	embedder := NewEmbeddingPipeline(service).
+		WithPreprocessor(step("pre1")).
+		WithPreprocessor(step("pre2")).
+		WithPostProcessor(post("post1")).
+		WithPostProcessor(post("post2")).
+		Build()
+	got, err := embedder.GetEmbedding(context.Background(), "text")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Preprocessors run in the order added, then the service, then the
+	// postprocessors in order
+	if !slices.Equal(steps, []string{"pre1", "pre2", "post1", "post2"}) {
+		t.Fatalf("steps ran in order %v", steps)
+	}
+	if !slices.Equal(service.texts, []string{"text|pre1|pre2"}) {
+		t.Fatalf("service embedded %q", service.texts)
+	}
+	if !slices.Equal(got[1024:], []float32{3, 4}) {
+		t.Fatalf("postprocessors appended %v, want [3 4]", got[1024:])
+	}
+	if ModelName(embedder) != "recording" {
+		t.Fatalf("ModelName = %q", ModelName(embedder))
+	}
+}
+
+func TestPipelineBuildIsolated(t *testing.T) {
+	service := &recordingEmbedder{}
+	p := NewEmbeddingPipeline(service).WithPreprocessor(Lowercase)
+	embedder := p.Build()
+	p.WithPreprocessor(TruncateText(1))
+
+	if _, err := embedder.GetEmbedding(context.Background(), "ABC"); err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(service.texts, []string{"abc"}) {
+		t.Fatalf("service embedded %q, want the embedder unchanged by later steps", service.texts)
+	}
+}
+
+func TestPipelineErrors(t *testing.T) {
+	service := &recordingEmbedder{}
+	postRan := false
+	embedder := NewEmbeddingPipeline(service).
+		WithPreprocessor(NormalizeWhitespace).
+		WithPostProcessor(func(embedding []float32) []float32 {
+			postRan = true
+			return embedding
+		}).
+		Build()
+
+	got, err := embedder.GetEmbedding(context.Background(), "  please   fail ")
+	if err == nil || got != nil {
+		t.Fatalf("GetEmbedding = %v, %v, want the service's error", got, err)
+	}
+	if !strings.Contains(err.Error(), `"please fail"`) {
+		t.Fatalf("err = %v, want it for the preprocessed text", err)
+	}
+	if postRan {
+		t.Fatal("postprocessor ran after an error")
+	}
+}
+
+func TestPipelineBatch(t *testing.T) {
+	service := &recordingEmbedder{}
+	embedder := NewEmbeddingPipeline(service).
+		WithPreprocessor(TruncateText(4)).
+		WithPostProcessor(TruncateDimensions(512)).
+		Build()
+
+	// More texts than GetEmbeddings runs at once; results and errors stay
+	// at their text's index
+	var texts []string
+	for i := range 3 * batchConcurrency {
+		texts = append(texts, strings.Repeat("x", i%6))
+	}
+	texts[5] = "failing"
+	texts[17] = "fail"
+
+	embeddings, errs := GetEmbeddings(context.Background(), embedder, texts)
+	if len(embeddings) != len(texts) || len(errs) != len(texts) {
+		t.Fatalf("%d embeddings and %d errors for %d texts", len(embeddings), len(errs), len(texts))
+	}
+	for i, text := range texts {
+		if i == 5 || i == 17 {
+			if errs[i] == nil || embeddings[i] != nil {
+				t.Errorf("text %d: err = %v, want an error", i, errs[i])
+			}
+			continue
+		}
+		if errs[i] != nil {
+			t.Fatalf("text %d: %v", i, errs[i])
+		}
+		want := float32(min(len(text), 4))
+		if len(embeddings[i]) != 512 || embeddings[i][0] != want || embeddings[i][1] != 1 {
+			t.Errorf("text %d embedded as %d dims starting %v, want 512 starting [%g 1]", i, len(embeddings[i]), embeddings[i][:2], want)
+		}
+	}
+	if len(service.texts) != len(texts) {
+		t.Fatalf("service called %d times for %d texts", len(service.texts), len(texts))
+	}
+}
+
+func TestProcessors(t *testing.T) {
+	if got := TruncateText(3)("héllo"); got != "hél" {
+		t.Errorf("TruncateText(3) = %q, want runes kept whole", got)
+	}
+	if got := TruncateText(10)("short"); got != "short" {
+		t.Errorf("TruncateText(10) = %q", got)
+	}
+	if got := NormalizeWhitespace(" a \t b\n\nc "); got != "a b c" {
+		t.Errorf("NormalizeWhitespace = %q", got)
+	}
+	if got := Lowercase("MiXeD"); got != "mixed" {
+		t.Errorf("Lowercase = %q", got)
+	}
+
+	normalized := L2Normalize([]float32{3, 4})
+	if math.Abs(float64(normalized[0])-0.6) > 1e-6 || math.Abs(float64(normalized[1])-0.8) > 1e-6 {
+		t.Errorf("L2Normalize = %v, want [0.6 0.8]", normalized)
+	}
+	if got := L2Normalize([]float32{0, 0}); !slices.Equal(got, []float32{0, 0}) {
+		t.Errorf("L2Normalize of zero = %v", got)
+	}
+	if got := TruncateDimensions(2)([]float32{1, 2, 3}); !slices.Equal(got, []float32{1, 2}) {
+		t.Errorf("TruncateDimensions(2) = %v", got)
+	}
+	if got := TruncateDimensions(5)([]float32{1, 2, 3}); len(got) != 3 {
+		t.Errorf("TruncateDimensions(5) = %v", got)
+	}
+}
+
+// The error surfaces unchanged, so callers can match it
+func TestPipelinePassesErrorThrough(t *testing.T) {
+	me := NewMockEmbedder()
+	errDown := errors.New("service down")
+	me.SetError("x", errDown)
+	embedder := NewEmbeddingPipeline(me).WithPreprocessor(TruncateText(1)).Build()
+	if _, err := embedder.GetEmbedding(context.Background(), "xyz"); !errors.Is(err, errDown) {
+		t.Fatalf("err = %v, want the service's error", err)
+	}
+}
@@ -0,0 +1,142 @@
+package embedding
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// postJSON posts payload to url and decodes a 200 response into response.
+// Other statuses are returned as errors carrying the response body.
+func postJSON(ctx context.Context, httpClient *http.Client, url string, header http.Header, payload, response any) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("marshal error: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("request creation error: %w", err)
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("http request error: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("embedding service error: status %d, body: %s", resp.StatusCode, string(bodyBytes))
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(response); err != nil {
+		return fmt.Errorf("unmarshal error: %w", err)
+	}
+	return nil
+}
+
+// checkDimensions rejects embeddings the tree can't store
+func checkDimensions(embedding []float32, model string) error {
+	if len(embedding) != 512 {
+		return fmt.Errorf("%w: expected 512 dimensions, got %d from model %s", ErrDimensionMismatch, len(embedding), model)
+	}
+	return nil
+}
+
+// OpenAIEmbedder calls the OpenAI embeddings API, or a compatible one at
+// another base URL, asking for 512 dimensions
+type OpenAIEmbedder struct {
+	BaseURL    string // e.g. https://api.openai.com
+	Model      string
+	APIKey     string
+	HTTPClient *http.Client
+}
+
+func NewOpenAIEmbedder(baseURL, model, apiKey string) *OpenAIEmbedder {
+	return &OpenAIEmbedder{
+		BaseURL:    baseURL,
+		Model:      model,
+		APIKey:     apiKey,
+		HTTPClient: &http.Client{},
+	}
+}
+
+func (oe *OpenAIEmbedder) GetEmbedding(ctx context.Context, text string) ([]float32, error) {
+	payload := struct {
+		Model      string `json:"model"`
+		Input      string `json:"input"`
+		Dimensions int    `json:"dimensions"`
+	}{oe.Model, text, 512}
+
+	var response struct {
+		Data []struct {
+			Embedding []float32 `json:"embedding"`
+		} `json:"data"`
+	}
+
+	header := http.Header{}
+	header.Set("Authorization", "Bearer "+oe.APIKey)
+	if err := postJSON(ctx, oe.HTTPClient, oe.BaseURL+"/v1/embeddings", header, payload, &response); err != nil {
+		return nil, err
+	}
+	if len(response.Data) == 0 {
+		return nil, fmt.Errorf("embedding service returned no embeddings")
+	}
+
+	embedding := response.Data[0].Embedding
+	if err := checkDimensions(embedding, oe.Model); err != nil {
+		return nil, err
+	}
+	return embedding, nil
+}
+
+func (oe *OpenAIEmbedder) ModelName() string {
+	return oe.Model
+}
+
+// OllamaEmbedder calls a local Ollama server. The model must produce 512
+// dimensional embeddings.
+type OllamaEmbedder struct {
+	ServiceURL string // e.g. http://localhost:11434
+	Model      string
+	HTTPClient *http.Client
+}
+
+func NewOllamaEmbedder(serviceURL, model string) *OllamaEmbedder {
+	return &OllamaEmbedder{
+		ServiceURL: serviceURL,
+		Model:      model,
+		HTTPClient: &http.Client{},
+	}
+}
+
+func (oe *OllamaEmbedder) GetEmbedding(ctx context.Context, text string) ([]float32, error) {
+	payload := struct {
+		Model  string `json:"model"`
+		Prompt string `json:"prompt"`
+	}{oe.Model, text}
+
+	var response struct {
+		Embedding []float32 `json:"embedding"`
+	}
+
+	if err := postJSON(ctx, oe.HTTPClient, oe.ServiceURL+"/api/embeddings", nil, payload, &response); err != nil {
+		return nil, err
+	}
+	if err := checkDimensions(response.Embedding, oe.Model); err != nil {
+		return nil, err
+	}
+	return response.Embedding, nil
+}
+
+func (oe *OllamaEmbedder) ModelName() string {
+	return oe.Model
+}
@@ -0,0 +1,160 @@
+package embedding
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// parseFlags registers the embedder flags on a fresh set and parses args
+func parseFlags(t *testing.T, args ...string) *Flags {
+	t.Helper()
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	f := RegisterFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+// serveEmbedding answers every request with the JSON reply, after check has
+// looked at the request and its decoded body
+func serveEmbedding(t *testing.T, reply any, check func(r *http.Request, body map[string]any)) string {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]any
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		check(r, body)
+		json.NewEncoder(w).Encode(reply)
+	}))
+	t.Cleanup(srv.Close)
+	return srv.URL
+}
+
+func TestFlagsNew(t *testing.T) {
+	t.Setenv("OPENAI_API_KEY", "sk-test")
+	t.Setenv("AWS_ACCESS_KEY_ID", "AKID")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+	t.Setenv("AWS_REGION", "eu-west-1")
+
+	tests := []struct {
+		args      []string
+		want      string // ModelName of the embedder built
+		describes string
+	}{
+		{nil, "http://localhost:8080", "local embedding service at http://localhost:8080"},
+		{[]string{"-mock"}, "mock", "mock embedder (deterministic pseudo-random embeddings)"},
+		{[]string{"-embedder", "mock"}, "mock", "mock embedder (deterministic pseudo-random embeddings)"},
+		{[]string{"-embedder", "openai"}, "text-embedding-3-small", "OpenAI embeddings (text-embedding-3-small) at https://api.openai.com"},
+		{[]string{"-embedder", "ollama", "-embed-model", "m512"}, "m512", "Ollama embeddings (m512) at http://localhost:11434"},
+		{[]string{"-embedder", "bedrock"}, "amazon.titan-embed-text-v2:0", "Bedrock embeddings (amazon.titan-embed-text-v2:0)"},
+	}
+	for _, tt := range tests {
+		f := parseFlags(t, tt.args...)
+		embedder, err := f.New()
+		if err != nil {
+			t.Fatalf("%q: %v", tt.args, err)
+		}
+		if name := ModelName(embedder); name != tt.want {
+			t.Errorf("%q: model %q, want %q", tt.args, name, tt.want)
+		}
+		if s := f.String(); s != tt.describes {
+			t.Errorf("%q: described as %q, want %q", tt.args, s, tt.describes)
+		}
+	}
+
+	if be, err := parseFlags(t, "-embedder", "bedrock").New(); err != nil || be.(*BedrockEmbedder).Endpoint != "https://bedrock-runtime.eu-west-1.amazonaws.com" {
+		t.Fatalf("bedrock endpoint %+v, %v", be, err)
+	}
+}
+
+func TestFlagsNewErrors(t *testing.T) {
+	t.Setenv("MY_KEY", "")
+	t.Setenv("AWS_ACCESS_KEY_ID", "")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "")
+
+	for _, args := range [][]string{
+		{"-embedder", "openai", "-embed-api-key-env", "MY_KEY"},
+		{"-embedder", "ollama"},
+		{"-embedder", "bedrock"},
+		{"-embedder", "word2vec"},
+	} {
+		if _, err := parseFlags(t, args...).New(); err == nil {
+			t.Errorf("%q: New succeeded", args)
+		}
+	}
+}
+
+func TestOpenAIEmbedder(t *testing.T) {
+	want := make([]float32, 512)
+	want[3] = 1
+	url := serveEmbedding(t, map[string]any{"data": []any{map[string]any{"embedding": want}}}, func(r *http.Request, body map[string]any) {
+		if r.URL.Path != "/v1/embeddings" || r.Header.Get("Authorization") != "Bearer sk-test" {
+			t.Errorf("request to %s with Authorization %q", r.URL.Path, r.Header.Get("Authorization"))
+		}
+		if body["model"] != "m" || body["input"] != "hello" || body["dimensions"] != float64(512) {
+			t.Errorf("request body %v", body)
+		}
+	})
+
+	got, err := NewOpenAIEmbedder(url, "m", "sk-test").GetEmbedding(context.Background(), "hello")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 512 || got[3] != 1 {
+		t.Fatalf("embedding %v", got[:4])
+	}
+}
+
+func TestOllamaEmbedder(t *testing.T) {
+	url := serveEmbedding(t, map[string]any{"embedding": make([]float32, 768)}, func(r *http.Request, body map[string]any) {
+		if r.URL.Path != "/api/embeddings" || body["model"] != "m" || body["prompt"] != "hello" {
+			t.Errorf("request to %s with body %v", r.URL.Path, body)
+		}
+	})
+
+	// A model producing other than 512 dimensions is refused
+	_, err := NewOllamaEmbedder(url, "m").GetEmbedding(context.Background(), "hello")
+	if !errors.Is(err, ErrDimensionMismatch) {
+		t.Fatalf("768 dimensions: %v, want ErrDimensionMismatch", err)
+	}
+}
+
+func TestBedrockEmbedder(t *testing.T) {
+	t.Setenv("AWS_ACCESS_KEY_ID", "AKID")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+	t.Setenv("AWS_SESSION_TOKEN", "token")
+	t.Setenv("AWS_REGION", "us-west-2")
+
+	url := serveEmbedding(t, map[string]any{"embedding": make([]float32, 512)}, func(r *http.Request, body map[string]any) {
+		if r.URL.EscapedPath() != "/model/amazon.titan-embed-text-v2%3A0/invoke" {
+			t.Errorf("request to %s", r.URL.EscapedPath())
+		}
+		auth := r.Header.Get("Authorization")
+		if !strings.HasPrefix(auth, "AWS4-HMAC-SHA256 Credential=AKID/") || !strings.Contains(auth, "/us-west-2/bedrock/aws4_request") {
+			t.Errorf("Authorization %q", auth)
+		}
+		if r.Header.Get("X-Amz-Security-Token") != "token" {
+			t.Errorf("session token %q", r.Header.Get("X-Amz-Security-Token"))
+		}
+		if body["inputText"] != "hello" || body["dimensions"] != float64(512) {
+			t.Errorf("request body %v", body)
+		}
+	})
+
+	be, err := NewBedrockEmbedder(url, "amazon.titan-embed-text-v2:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := be.GetEmbedding(context.Background(), "hello"); err != nil {
+		t.Fatal(err)
+	}
+}
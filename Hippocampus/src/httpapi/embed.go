@@ -0,0 +1,80 @@
+package httpapi
+
+import (
+	"Hippocampus/src/embedding"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// maxBatchTexts bounds the texts of one /embed_batch request
+const maxBatchTexts = 1024
+
+// embedBatchRequest and embedBatchResponse are the batch forms of
+// embedding.LocalEmbeddingRequest and LocalEmbeddingResponse
+type embedBatchRequest struct {
+	Texts []string `json:"texts"`
+}
+
+type embedBatchResponse struct {
+	Embeddings [][]float32 `json:"embeddings"`
+}
+
+// embed returns the embedding of a text from the server's embedder, in the
+// shapes of the local embedding service, so the server can be another's
+// -embed-url
+func (s *Server) embed(w http.ResponseWriter, r *http.Request) {
+	var req embedding.LocalEmbeddingRequest
+	if !readJSON(w, r, &req) {
+		return
+	}
+	if req.Text == "" {
+		writeError(w, http.StatusBadRequest, "text is required")
+		return
+	}
+
+	emb, err := s.agents.Embedder().GetEmbedding(r.Context(), req.Text)
+	if err != nil {
+		writeEmbedError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, embedding.LocalEmbeddingResponse{Embedding: emb})
+}
+
+// embedBatch embeds several texts, failing the whole request if any fails
+func (s *Server) embedBatch(w http.ResponseWriter, r *http.Request) {
+	var req embedBatchRequest
+	if !readJSON(w, r, &req) {
+		return
+	}
+	if len(req.Texts) == 0 || len(req.Texts) > maxBatchTexts {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("texts must hold 1 to %d texts", maxBatchTexts))
+		return
+	}
+	for i, text := range req.Texts {
+		if text == "" {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("text %d is empty", i))
+			return
+		}
+	}
+
+	embs, errs := embedding.GetEmbeddings(r.Context(), s.agents.Embedder(), req.Texts)
+	for i, err := range errs {
+		if err != nil {
+			writeEmbedError(w, fmt.Errorf("text %d: %w", i, err))
+			return
+		}
+	}
+	writeJSON(w, http.StatusOK, embedBatchResponse{Embeddings: embs})
+}
+
+// writeEmbedError maps an embedder error to a status: 503 for a full
+// embedding queue, otherwise 502
+func writeEmbedError(w http.ResponseWriter, err error) {
+	if errors.Is(err, embedding.ErrQueueFull) {
+		w.Header().Set("Retry-After", "1")
+		writeError(w, http.StatusServiceUnavailable, err.Error())
+		return
+	}
+	writeError(w, http.StatusBadGateway, err.Error())
+}
@@ -0,0 +1,415 @@
+// Package httpapi serves the agents of an agents.Manager over a JSON REST
+// API, for consumers that don't speak the Redis protocol
+package httpapi
+
+import (
+	"Hippocampus/src/agents"
+	"Hippocampus/src/client"
+	"Hippocampus/src/embedding"
+	"Hippocampus/src/types"
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// maxBodyBytes bounds request bodies
+const maxBodyBytes = 16 << 20
+
+// Search parameters used when a search request omits them, as for agents
+// without an HCONFIG profile on the Redis server
+const (
+	defaultEpsilon   = 0.3
+	defaultThreshold = 0.5
+	defaultTopK      = 5
+)
+
+// Server serves the REST API
+type Server struct {
+	agents *agents.Manager
+	http   *http.Server
+
+	// WebSockets, which http.Server stops tracking once hijacked
+	socketsMu     sync.Mutex
+	sockets       map[*wsConn]struct{}
+	socketsClosed bool           // Set by Shutdown; no new sockets are accepted
+	socketsWG     sync.WaitGroup // Sessions still running
+}
+
+func NewServer(addr string, m *agents.Manager) *Server {
+	s := &Server{agents: m, sockets: make(map[*wsConn]struct{})}
+	s.http = &http.Server{
+		Addr:              addr,
+		Handler:           s.Handler(),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	return s
+}
+
+// Handler returns the API's routes, logging every request
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /healthz", s.healthz)
+	mux.HandleFunc("POST /embed", s.embed)
+	mux.HandleFunc("POST /embed_batch", s.embedBatch)
+	mux.HandleFunc("POST /agents/{id}/memories", s.insert)
+	mux.HandleFunc("GET /agents/{id}/memories/{key}", s.get)
+	mux.HandleFunc("DELETE /agents/{id}/memories/{key}", s.delete)
+	mux.HandleFunc("POST /agents/{id}/search", s.search)
+	mux.HandleFunc("GET /agents/{id}/stats", s.stats)
+	mux.HandleFunc("GET /agents/{id}/storage-stats", s.storageStats)
+	mux.HandleFunc("GET /agents/{id}/ws", s.stream)
+	return logRequests(mux)
+}
+
+// Start serves until Shutdown is called
+func (s *Server) Start() error {
+	log.Printf("HTTP API listening on %s", s.http.Addr)
+	if err := s.http.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return fmt.Errorf("failed to start HTTP server: %w", err)
+	}
+	return nil
+}
+
+// Shutdown stops accepting requests, waits for those in flight until ctx is
+// done, closes WebSockets, stops idle eviction, then saves agents stored in
+// the data directory
+func (s *Server) Shutdown(ctx context.Context) error {
+	err := s.http.Shutdown(ctx)
+	s.closeSockets()
+	s.agents.StopEviction()
+	if flushErr := s.agents.Flush(); err == nil {
+		err = flushErr
+	}
+	return err
+}
+
+// statusRecorder remembers the status a handler wrote, for the request log
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// Hijack takes over the connection for a WebSocket upgrade
+func (r *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, brw, err := http.NewResponseController(r.ResponseWriter).Hijack()
+	if err == nil {
+		r.status = http.StatusSwitchingProtocols
+	}
+	return conn, brw, err
+}
+
+func logRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+		log.Printf("%s %s %d %s", r.Method, r.URL.Path, rec.status, time.Since(start).Round(time.Microsecond))
+	})
+}
+
+// memory is a stored memory in requests and responses. Metadata is any JSON
+// object, stored with the memory and returned by gets and searches.
+type memory struct {
+	Key      string          `json:"key"`
+	Text     string          `json:"text"`
+	Metadata json.RawMessage `json:"metadata,omitempty"`
+}
+
+// newMemory returns the response form of a stored node
+func newMemory(n *types.Node) memory {
+	return memory{Key: n.ID, Text: n.Value, Metadata: rawMetadata(n.Metadata)}
+}
+
+// rawMetadata returns stored metadata for a response, nil if there is none
+func rawMetadata(metadata string) json.RawMessage {
+	if metadata == "" {
+		return nil
+	}
+	return json.RawMessage(metadata)
+}
+
+type searchRequest struct {
+	Query     string   `json:"query"`
+	Epsilon   *float32 `json:"epsilon"`
+	Threshold *float32 `json:"threshold"`
+	TopK      *int     `json:"top_k"`
+}
+
+type searchResult struct {
+	Key      string          `json:"key"`
+	Text     string          `json:"text"`
+	Metadata json.RawMessage `json:"metadata,omitempty"`
+	Score    float32         `json:"score"`
+}
+
+type statsResponse struct {
+	Agent        string     `json:"agent"`
+	Nodes        int        `json:"nodes"`
+	Duplicates   int        `json:"duplicates"`
+	IndexEntries int        `json:"index_entries"`
+	MemoryBytes  int64      `json:"memory_bytes"`
+	LastModified *time.Time `json:"last_modified,omitempty"`
+}
+
+type storageStatsResponse struct {
+	Agent        string     `json:"agent"`
+	Backend      string     `json:"backend"`
+	NodeCount    int64      `json:"node_count"`
+	SizeBytes    int64      `json:"size_bytes"`
+	IsCompressed bool       `json:"is_compressed"`
+	LastModified *time.Time `json:"last_modified,omitempty"`
+}
+
+func (s *Server) healthz(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+func (s *Server) insert(w http.ResponseWriter, r *http.Request) {
+	var m memory
+	if !readJSON(w, r, &m) {
+		return
+	}
+	if m.Key == "" || m.Text == "" {
+		writeError(w, http.StatusBadRequest, "key and text are required")
+		return
+	}
+	metadata := string(m.Metadata)
+	if metadata == "null" {
+		metadata = ""
+	}
+
+	c, err := s.agents.GetOrCreate(r.Context(), r.PathValue("id"))
+	if err != nil {
+		writeClientError(w, err)
+		return
+	}
+	// A memory stored under the key is replaced, which is answered with 200
+	existed, err := c.Exists(m.Key)
+	if err != nil {
+		writeClientError(w, err)
+		return
+	}
+	if err := c.InsertWithMetadata(m.Key, m.Text, metadata); err != nil {
+		writeClientError(w, err)
+		return
+	}
+	status := http.StatusCreated
+	if existed {
+		status = http.StatusOK
+	}
+	writeJSON(w, status, memory{Key: m.Key, Text: m.Text, Metadata: rawMetadata(metadata)})
+}
+
+func (s *Server) get(w http.ResponseWriter, r *http.Request) {
+	c, ok := s.agent(w, r)
+	if !ok {
+		return
+	}
+
+	key := r.PathValue("key")
+	node, found, err := c.Get(key)
+	if err != nil {
+		writeClientError(w, err)
+		return
+	}
+	if !found {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("no memory with key %q", key))
+		return
+	}
+	writeJSON(w, http.StatusOK, newMemory(&node))
+}
+
+func (s *Server) delete(w http.ResponseWriter, r *http.Request) {
+	c, ok := s.agent(w, r)
+	if !ok {
+		return
+	}
+
+	key := r.PathValue("key")
+	deleted, err := c.Delete(key)
+	if err != nil {
+		writeClientError(w, err)
+		return
+	}
+	if !deleted {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("no memory with key %q", key))
+		return
+	}
+	if err := c.Flush(); err != nil {
+		writeClientError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) search(w http.ResponseWriter, r *http.Request) {
+	var req searchRequest
+	if !readJSON(w, r, &req) {
+		return
+	}
+	if req.Query == "" {
+		writeError(w, http.StatusBadRequest, "query is required")
+		return
+	}
+	opts := types.SearchOptions{Epsilon: defaultEpsilon, Threshold: defaultThreshold, TopK: defaultTopK}
+	if req.Epsilon != nil {
+		opts.Epsilon = *req.Epsilon
+	}
+	if req.Threshold != nil {
+		opts.Threshold = *req.Threshold
+	}
+	if req.TopK != nil {
+		opts.TopK = *req.TopK
+	}
+	if opts.Epsilon < 0 || opts.Threshold < 0 || opts.Threshold > 1 || opts.TopK < 0 {
+		writeError(w, http.StatusBadRequest, "epsilon and top_k must be non-negative and threshold in [0, 1]")
+		return
+	}
+
+	c, ok := s.agent(w, r)
+	if !ok {
+		return
+	}
+	results, err := c.SearchScored(req.Query, opts)
+	if err != nil {
+		writeClientError(w, err)
+		return
+	}
+
+	hits := make([]searchResult, len(results))
+	for i, res := range results {
+		hits[i] = searchResult{Key: res.Node.ID, Text: res.Node.Value, Metadata: rawMetadata(res.Node.Metadata), Score: res.Score}
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"results": hits})
+}
+
+func (s *Server) stats(w http.ResponseWriter, r *http.Request) {
+	c, ok := s.agent(w, r)
+	if !ok {
+		return
+	}
+
+	st, err := c.Stats()
+	if err != nil {
+		writeClientError(w, err)
+		return
+	}
+	resp := statsResponse{
+		Agent:        r.PathValue("id"),
+		Nodes:        st.Nodes,
+		Duplicates:   st.Duplicates,
+		IndexEntries: st.IndexEntries,
+		MemoryBytes:  st.MemoryBytes,
+	}
+	if !st.LastModified.IsZero() {
+		resp.LastModified = &st.LastModified
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// storageStats describes what the agent's storage holds, which lags the
+// tree by any changes not yet flushed
+func (s *Server) storageStats(w http.ResponseWriter, r *http.Request) {
+	c, ok := s.agent(w, r)
+	if !ok {
+		return
+	}
+
+	st, err := c.CurrentStorage().Stats(r.Context())
+	if err != nil {
+		writeClientError(w, err)
+		return
+	}
+	resp := storageStatsResponse{
+		Agent:        r.PathValue("id"),
+		Backend:      st.Backend,
+		NodeCount:    st.NodeCount,
+		SizeBytes:    st.SizeBytes,
+		IsCompressed: st.IsCompressed,
+	}
+	if !st.LastModified.IsZero() {
+		resp.LastModified = &st.LastModified
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// agent returns the client of the agent in the path, writing a 404 if there
+// is no such agent
+func (s *Server) agent(w http.ResponseWriter, r *http.Request) (*client.Client, bool) {
+	agentID := r.PathValue("id")
+	c, exists, err := s.agents.Get(r.Context(), agentID)
+	if err != nil {
+		writeClientError(w, err)
+		return nil, false
+	}
+	if !exists {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("no agent %q", agentID))
+		return nil, false
+	}
+	return c, true
+}
+
+// readJSON decodes the request body into v, writing a 400 if it can't
+func readJSON(w http.ResponseWriter, r *http.Request, v any) bool {
+	r.Body = http.MaxBytesReader(w, r.Body, maxBodyBytes)
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			writeError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("request body over %d bytes", tooLarge.Limit))
+			return false
+		}
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid JSON: %v", err))
+		return false
+	}
+	return true
+}
+
+// writeClientError maps an error from the manager or a client to a status:
+// 400 for agent IDs that can't be stored and metadata that isn't an object,
+// 409 when the key already holds the same text (a new text replaces it
+// instead), 422 for embeddings of the
+// wrong size, 502 when the embedding service fails, 503 for agents being
+// loaded, too many agents or a full embedding queue, otherwise 500
+func writeClientError(w http.ResponseWriter, err error) {
+	var loading *agents.LoadingError
+	var invalidID *agents.InvalidIDError
+	switch {
+	case errors.As(err, &invalidID), errors.Is(err, client.ErrInvalidMetadata):
+		writeError(w, http.StatusBadRequest, err.Error())
+	case errors.Is(err, types.ErrDuplicateKey):
+		writeError(w, http.StatusConflict, err.Error())
+	case errors.Is(err, client.ErrDimensionMismatch):
+		writeError(w, http.StatusUnprocessableEntity, err.Error())
+	case errors.Is(err, agents.ErrTooManyAgents):
+		writeError(w, http.StatusServiceUnavailable, err.Error())
+	case errors.Is(err, embedding.ErrQueueFull), errors.As(err, &loading):
+		w.Header().Set("Retry-After", "1")
+		writeError(w, http.StatusServiceUnavailable, err.Error())
+	case errors.Is(err, client.ErrEmbedding):
+		writeError(w, http.StatusBadGateway, err.Error())
+	default:
+		writeError(w, http.StatusInternalServerError, err.Error())
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, map[string]string{"error": msg})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,464 @@
+package httpapi
+
+import (
+	"Hippocampus/src/agents"
+	"Hippocampus/src/embedding"
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestAPI serves the API over httptest on a manager with embedder
+func newTestAPI(t *testing.T, embedder embedding.EmbeddingService, opts ...agents.Option) *httptest.Server {
+	t.Helper()
+	ts := httptest.NewServer(NewServer("", agents.NewManager(embedder, opts...)).Handler())
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+// call sends a request with body encoded as JSON, if not nil, and decodes
+// the JSON response into out, if not nil, returning the status
+func call(t *testing.T, ts *httptest.Server, method, path string, body, out any) int {
+	t.Helper()
+	var r io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r = bytes.NewReader(b)
+	}
+	req, err := http.NewRequest(method, ts.URL+path, r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := ts.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if out != nil {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			t.Fatalf("%s %s: decoding response: %v", method, path, err)
+		}
+	}
+	return resp.StatusCode
+}
+
+// store inserts a memory, failing the test unless it gets 201
+func store(t *testing.T, ts *httptest.Server, agentID string, m map[string]any) {
+	t.Helper()
+	if status := call(t, ts, "POST", "/agents/"+agentID+"/memories", m, nil); status != http.StatusCreated {
+		t.Fatalf("storing %v: status %d, want 201", m["key"], status)
+	}
+}
+
+func TestHealthz(t *testing.T) {
+	ts := newTestAPI(t, embedding.NewMockEmbedder())
+	var body map[string]string
+	if status := call(t, ts, "GET", "/healthz", nil, &body); status != http.StatusOK || body["status"] != "ok" {
+		t.Fatalf("GET /healthz = %d %v", status, body)
+	}
+}
+
+func TestInsertAndGet(t *testing.T) {
+	ts := newTestAPI(t, embedding.NewMockEmbedder())
+
+	var created memory
+	status := call(t, ts, "POST", "/agents/alice/memories", map[string]any{
+		"key":      "pref_1",
+		"text":     "Prefers email notifications",
+		"metadata": map[string]any{"source": "call", "priority": 2},
+	}, &created)
+	if status != http.StatusCreated {
+		t.Fatalf("POST memories = %d, want 201", status)
+	}
+	if created.Key != "pref_1" || string(created.Metadata) != `{"priority":2,"source":"call"}` {
+		t.Fatalf("created %+v", created)
+	}
+
+	var got memory
+	if status := call(t, ts, "GET", "/agents/alice/memories/pref_1", nil, &got); status != http.StatusOK {
+		t.Fatalf("GET memory = %d, want 200", status)
+	}
+	if got.Text != "Prefers email notifications" || string(got.Metadata) != `{"priority":2,"source":"call"}` {
+		t.Fatalf("got %+v", got)
+	}
+}
+
+func TestInsertWithoutMetadata(t *testing.T) {
+	ts := newTestAPI(t, embedding.NewMockEmbedder())
+	store(t, ts, "alice", map[string]any{"key": "k", "text": "no metadata", "metadata": nil})
+
+	var raw map[string]json.RawMessage
+	call(t, ts, "GET", "/agents/alice/memories/k", nil, &raw)
+	if _, ok := raw["metadata"]; ok {
+		t.Fatalf("response has metadata: %s", raw["metadata"])
+	}
+}
+
+func TestInsertRejects(t *testing.T) {
+	ts := newTestAPI(t, embedding.NewMockEmbedder())
+	tests := []struct {
+		name string
+		body any
+		want int
+	}{
+		{"missing key", map[string]any{"text": "t"}, http.StatusBadRequest},
+		{"missing text", map[string]any{"key": "k"}, http.StatusBadRequest},
+		{"metadata not an object", map[string]any{"key": "k", "text": "t", "metadata": []int{1}}, http.StatusBadRequest},
+		{"invalid JSON", "not an object", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var body map[string]string
+			if status := call(t, ts, "POST", "/agents/alice/memories", tt.body, &body); status != tt.want || body["error"] == "" {
+				t.Fatalf("status %d %v, want %d with an error", status, body, tt.want)
+			}
+		})
+	}
+}
+
+func TestInsertDuplicateIsConflict(t *testing.T) {
+	ts := newTestAPI(t, embedding.NewMockEmbedder())
+	m := map[string]any{"key": "k", "text": "same text"}
+	store(t, ts, "alice", m)
+	if status := call(t, ts, "POST", "/agents/alice/memories", m, nil); status != http.StatusConflict {
+		t.Fatalf("storing the same memory again = %d, want 409", status)
+	}
+
+	// The same text under another key is a new memory
+	store(t, ts, "alice", map[string]any{"key": "k2", "text": "same text"})
+}
+
+func TestInsertReplacesKey(t *testing.T) {
+	ts := newTestAPI(t, embedding.NewMockEmbedder())
+	store(t, ts, "alice", map[string]any{"key": "k", "text": "old text", "metadata": map[string]any{"v": 1}})
+	if status := call(t, ts, "POST", "/agents/alice/memories", map[string]any{"key": "k", "text": "new text"}, nil); status != http.StatusOK {
+		t.Fatalf("storing a new text under the key = %d, want 200", status)
+	}
+
+	var got memory
+	call(t, ts, "GET", "/agents/alice/memories/k", nil, &got)
+	if got.Text != "new text" || got.Metadata != nil {
+		t.Fatalf("got %+v, want the new text without the old metadata", got)
+	}
+	var stats statsResponse
+	call(t, ts, "GET", "/agents/alice/stats", nil, &stats)
+	if stats.Nodes != 1 {
+		t.Fatalf("%d memories stored, want 1", stats.Nodes)
+	}
+}
+
+func TestInsertEmbedderErrors(t *testing.T) {
+	embedder := embedding.NewMockEmbedder()
+	embedder.SetResponse("short", make([]float32, 3))
+	embedder.SetError("unreachable", errors.New("connection refused"))
+	ts := newTestAPI(t, embedder)
+
+	tests := []struct {
+		text string
+		want int
+	}{
+		{"short", http.StatusUnprocessableEntity},
+		{"unreachable", http.StatusBadGateway},
+	}
+	for _, tt := range tests {
+		t.Run(tt.text, func(t *testing.T) {
+			status := call(t, ts, "POST", "/agents/alice/memories", map[string]any{"key": "k", "text": tt.text}, nil)
+			if status != tt.want {
+				t.Fatalf("status %d, want %d", status, tt.want)
+			}
+		})
+	}
+}
+
+func TestInsertInvalidAgentID(t *testing.T) {
+	ts := newTestAPI(t, embedding.NewMockEmbedder(), agents.WithDataDir(t.TempDir()))
+	status := call(t, ts, "POST", "/agents/"+url.PathEscape("../escape")+"/memories", map[string]any{"key": "k", "text": "t"}, nil)
+	if status != http.StatusBadRequest {
+		t.Fatalf("status %d, want 400", status)
+	}
+}
+
+func TestInsertTooManyAgents(t *testing.T) {
+	ts := newTestAPI(t, embedding.NewMockEmbedder(), agents.WithMaxAgents(1))
+	store(t, ts, "alice", map[string]any{"key": "k", "text": "t"})
+	if status := call(t, ts, "POST", "/agents/bob/memories", map[string]any{"key": "k", "text": "t"}, nil); status != http.StatusServiceUnavailable {
+		t.Fatalf("status %d, want 503", status)
+	}
+}
+
+func TestGetMissing(t *testing.T) {
+	ts := newTestAPI(t, embedding.NewMockEmbedder())
+	if status := call(t, ts, "GET", "/agents/nobody/memories/k", nil, nil); status != http.StatusNotFound {
+		t.Fatalf("unknown agent = %d, want 404", status)
+	}
+	store(t, ts, "alice", map[string]any{"key": "k", "text": "t"})
+	if status := call(t, ts, "GET", "/agents/alice/memories/other", nil, nil); status != http.StatusNotFound {
+		t.Fatalf("unknown key = %d, want 404", status)
+	}
+}
+
+func TestDelete(t *testing.T) {
+	ts := newTestAPI(t, embedding.NewMockEmbedder())
+	store(t, ts, "alice", map[string]any{"key": "k", "text": "t"})
+
+	if status := call(t, ts, "DELETE", "/agents/alice/memories/k", nil, nil); status != http.StatusNoContent {
+		t.Fatalf("DELETE = %d, want 204", status)
+	}
+	if status := call(t, ts, "GET", "/agents/alice/memories/k", nil, nil); status != http.StatusNotFound {
+		t.Fatalf("GET after DELETE = %d, want 404", status)
+	}
+	if status := call(t, ts, "DELETE", "/agents/alice/memories/k", nil, nil); status != http.StatusNotFound {
+		t.Fatalf("second DELETE = %d, want 404", status)
+	}
+	if status := call(t, ts, "DELETE", "/agents/nobody/memories/k", nil, nil); status != http.StatusNotFound {
+		t.Fatalf("DELETE on unknown agent = %d, want 404", status)
+	}
+}
+
+func TestSearch(t *testing.T) {
+	ts := newTestAPI(t, embedding.NewMockEmbedder())
+	store(t, ts, "alice", map[string]any{"key": "email", "text": "Prefers email notifications", "metadata": map[string]any{"source": "call"}})
+	store(t, ts, "alice", map[string]any{"key": "plan", "text": "On the premium plan"})
+
+	var body struct {
+		Results []searchResult `json:"results"`
+	}
+	// The mock embedder gives the same text the same embedding
+	status := call(t, ts, "POST", "/agents/alice/search", map[string]any{
+		"query": "Prefers email notifications", "epsilon": 10, "threshold": 0, "top_k": 1,
+	}, &body)
+	if status != http.StatusOK {
+		t.Fatalf("search = %d, want 200", status)
+	}
+	if len(body.Results) != 1 {
+		t.Fatalf("got %d results, want 1", len(body.Results))
+	}
+	hit := body.Results[0]
+	if hit.Key != "email" || string(hit.Metadata) != `{"source":"call"}` || hit.Score < 0.99 {
+		t.Fatalf("top hit %+v", hit)
+	}
+}
+
+func TestSearchRejects(t *testing.T) {
+	ts := newTestAPI(t, embedding.NewMockEmbedder())
+	store(t, ts, "alice", map[string]any{"key": "k", "text": "t"})
+	tests := []struct {
+		name  string
+		agent string
+		body  any
+		want  int
+	}{
+		{"missing query", "alice", map[string]any{}, http.StatusBadRequest},
+		{"threshold above 1", "alice", map[string]any{"query": "q", "threshold": 2}, http.StatusBadRequest},
+		{"negative top_k", "alice", map[string]any{"query": "q", "top_k": -1}, http.StatusBadRequest},
+		{"unknown agent", "nobody", map[string]any{"query": "q"}, http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if status := call(t, ts, "POST", "/agents/"+tt.agent+"/search", tt.body, nil); status != tt.want {
+				t.Fatalf("status %d, want %d", status, tt.want)
+			}
+		})
+	}
+}
+
+func TestStats(t *testing.T) {
+	ts := newTestAPI(t, embedding.NewMockEmbedder())
+	store(t, ts, "alice", map[string]any{"key": "a", "text": "first"})
+	store(t, ts, "alice", map[string]any{"key": "b", "text": "second"})
+
+	var st statsResponse
+	if status := call(t, ts, "GET", "/agents/alice/stats", nil, &st); status != http.StatusOK {
+		t.Fatalf("stats = %d, want 200", status)
+	}
+	if st.Agent != "alice" || st.Nodes != 2 || st.LastModified == nil {
+		t.Fatalf("stats %+v", st)
+	}
+	if status := call(t, ts, "GET", "/agents/nobody/stats", nil, nil); status != http.StatusNotFound {
+		t.Fatalf("stats of unknown agent = %d, want 404", status)
+	}
+}
+
+func TestStorageStats(t *testing.T) {
+	ts := newTestAPI(t, embedding.NewMockEmbedder(), agents.WithDataDir(t.TempDir()))
+	store(t, ts, "alice", map[string]any{"key": "a", "text": "first"})
+
+	// DELETE flushes, so the file holds what is left
+	store(t, ts, "alice", map[string]any{"key": "b", "text": "second"})
+	call(t, ts, "DELETE", "/agents/alice/memories/b", nil, nil)
+
+	var st storageStatsResponse
+	if status := call(t, ts, "GET", "/agents/alice/storage-stats", nil, &st); status != http.StatusOK {
+		t.Fatalf("storage-stats = %d, want 200", status)
+	}
+	if st.Backend != "file" || st.NodeCount != 1 || st.SizeBytes == 0 {
+		t.Fatalf("storage stats %+v", st)
+	}
+	if status := call(t, ts, "GET", "/agents/nobody/storage-stats", nil, nil); status != http.StatusNotFound {
+		t.Fatalf("storage-stats of unknown agent = %d, want 404", status)
+	}
+}
+
+func TestEmbed(t *testing.T) {
+	ts := newTestAPI(t, embedding.NewMockEmbedder())
+
+	var one embedding.LocalEmbeddingResponse
+	if status := call(t, ts, "POST", "/embed", map[string]any{"text": "hello"}, &one); status != http.StatusOK || len(one.Embedding) != 512 {
+		t.Fatalf("embed = %d with %d dimensions", status, len(one.Embedding))
+	}
+	if status := call(t, ts, "POST", "/embed", map[string]any{}, nil); status != http.StatusBadRequest {
+		t.Fatalf("embed without text = %d, want 400", status)
+	}
+
+	var batch embedBatchResponse
+	if status := call(t, ts, "POST", "/embed_batch", map[string]any{"texts": []string{"a", "b"}}, &batch); status != http.StatusOK || len(batch.Embeddings) != 2 {
+		t.Fatalf("embed_batch = %d with %d embeddings", status, len(batch.Embeddings))
+	}
+	if status := call(t, ts, "POST", "/embed_batch", map[string]any{"texts": []string{"a", ""}}, nil); status != http.StatusBadRequest {
+		t.Fatalf("embed_batch with an empty text = %d, want 400", status)
+	}
+
+	down := embedding.NewMockEmbedder()
+	down.SetError("hello", errors.New("connection refused"))
+	if status := call(t, newTestAPI(t, down), "POST", "/embed", map[string]any{"text": "hello"}, nil); status != http.StatusBadGateway {
+		t.Fatalf("embed with the embedder down = %d, want 502", status)
+	}
+}
+
+func TestBodyTooLarge(t *testing.T) {
+	ts := newTestAPI(t, embedding.NewMockEmbedder())
+	body := `{"key": "k", "text": "` + strings.Repeat("x", maxBodyBytes) + `"}`
+	resp, err := ts.Client().Post(ts.URL+"/agents/alice/memories", "application/json", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusRequestEntityTooLarge {
+		t.Fatalf("status %d, want 413", resp.StatusCode)
+	}
+}
+
+// dialWS opens a WebSocket to path, returning the connection and a reader
+// positioned after the handshake
+func dialWS(t *testing.T, ts *httptest.Server, path string) (net.Conn, *bufio.Reader) {
+	t.Helper()
+	conn, err := net.Dial("tcp", ts.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	var key [16]byte
+	rand.Read(key[:])
+	fmt.Fprintf(conn, "GET %s HTTP/1.1\r\nHost: test\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n"+
+		"Sec-WebSocket-Key: %s\r\nSec-WebSocket-Version: 13\r\n\r\n", path, base64.StdEncoding.EncodeToString(key[:]))
+
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("upgrade = %d, want 101", resp.StatusCode)
+	}
+	return conn, br
+}
+
+// writeWS sends v as a masked text frame
+func writeWS(t *testing.T, conn net.Conn, v any) {
+	t.Helper()
+	payload, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(payload) > 125 {
+		t.Fatalf("test message of %d bytes needs an extended length", len(payload))
+	}
+	mask := [4]byte{1, 2, 3, 4}
+	frame := append([]byte{0x80 | opText, 0x80 | byte(len(payload))}, mask[:]...)
+	for i, b := range payload {
+		frame = append(frame, b^mask[i%4])
+	}
+	if _, err := conn.Write(frame); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// readWS reads one unfragmented server frame of up to 64KB
+func readWS(t *testing.T, br *bufio.Reader) streamMessage {
+	t.Helper()
+	var header [2]byte
+	if _, err := io.ReadFull(br, header[:]); err != nil {
+		t.Fatal(err)
+	}
+	length := int(header[1] & 0x7F)
+	if length == 126 {
+		var ext [2]byte
+		if _, err := io.ReadFull(br, ext[:]); err != nil {
+			t.Fatal(err)
+		}
+		length = int(binary.BigEndian.Uint16(ext[:]))
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(br, payload); err != nil {
+		t.Fatal(err)
+	}
+	var m streamMessage
+	if err := json.Unmarshal(payload, &m); err != nil {
+		t.Fatalf("frame %q: %v", payload, err)
+	}
+	return m
+}
+
+func TestStreamSearchAndSubscribe(t *testing.T) {
+	ts := newTestAPI(t, embedding.NewMockEmbedder())
+	store(t, ts, "alice", map[string]any{"key": "email", "text": "Prefers email", "metadata": map[string]any{"n": 1}})
+
+	conn, br := dialWS(t, ts, "/agents/alice/ws")
+	writeWS(t, conn, map[string]any{"type": "search", "id": 7, "query": "Prefers email", "epsilon": 10, "threshold": 0, "top_k": 1})
+	if m := readWS(t, br); m.Type != "result" || m.Key != "email" || string(m.Metadata) != `{"n":1}` || string(m.ID) != "7" {
+		t.Fatalf("first message %+v, want the hit", m)
+	}
+	if m := readWS(t, br); m.Type != "done" || m.Count == nil || *m.Count != 1 {
+		t.Fatalf("second message %+v, want done with 1", m)
+	}
+
+	writeWS(t, conn, map[string]any{"type": "subscribe"})
+	if m := readWS(t, br); m.Type != "subscribed" {
+		t.Fatalf("got %+v, want subscribed", m)
+	}
+	store(t, ts, "alice", map[string]any{"key": "plan", "text": "Premium plan"})
+	if m := readWS(t, br); m.Type != "event" || m.Op != "insert" || m.Key != "plan" {
+		t.Fatalf("got %+v, want the insert event", m)
+	}
+
+	writeWS(t, conn, map[string]any{"type": "bogus"})
+	if m := readWS(t, br); m.Type != "error" {
+		t.Fatalf("got %+v, want an error", m)
+	}
+}
+
+func TestStreamUnknownAgent(t *testing.T) {
+	ts := newTestAPI(t, embedding.NewMockEmbedder())
+	if status := call(t, ts, "GET", "/agents/nobody/ws", nil, nil); status != http.StatusNotFound {
+		t.Fatalf("status %d, want 404", status)
+	}
+}
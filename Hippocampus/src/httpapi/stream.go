@@ -0,0 +1,297 @@
+package httpapi
+
+import (
+	"Hippocampus/src/client"
+	"Hippocampus/src/types"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"time"
+)
+
+const (
+	// sendQueueSize is how many messages a socket buffers for its peer
+	sendQueueSize = 256
+	// sendTimeout is how long a search waits for room in a full send queue
+	// before dropping the socket
+	sendTimeout = 5 * time.Second
+)
+
+// streamRequest is a message from the peer. Search parameters left out
+// take the defaults of POST /agents/{id}/search; with radius set, every
+// memory within it is returned instead of the top_k.
+type streamRequest struct {
+	Type      string          `json:"type"` // "search" or "subscribe"
+	ID        json.RawMessage `json:"id,omitempty"`
+	Query     string          `json:"query"`
+	Epsilon   *float32        `json:"epsilon"`
+	Threshold *float32        `json:"threshold"`
+	TopK      *int            `json:"top_k"`
+	Radius    *float32        `json:"radius"`
+	Metric    string          `json:"metric"`
+}
+
+// streamMessage is a message to the peer: a search "result" per hit then
+// "done", "subscribed", an "event" per change, or an "error". ID echoes the
+// request's.
+type streamMessage struct {
+	Type  string          `json:"type"`
+	ID    json.RawMessage `json:"id,omitempty"`
+	Error string          `json:"error,omitempty"`
+	Count *int            `json:"count,omitempty"` // Hits, in "done"
+
+	// Hit of a "result", or memory changed by an "event"
+	Op       client.ChangeOp `json:"op,omitempty"`
+	Key      string          `json:"key,omitempty"`
+	Text     string          `json:"text,omitempty"`
+	Metadata json.RawMessage `json:"metadata,omitempty"` // Of a "result"
+	Score    *float32        `json:"score,omitempty"`
+	Time     *time.Time      `json:"ts,omitempty"`
+}
+
+// socket is a WebSocket session on one agent's client
+type socket struct {
+	ws     *wsConn
+	client *client.Client
+
+	send chan []byte
+	done chan struct{} // Closed when the session ends
+
+	unsubscribe func()
+}
+
+// stream upgrades GET /agents/{id}/ws to a WebSocket
+func (s *Server) stream(w http.ResponseWriter, r *http.Request) {
+	c, ok := s.agent(w, r)
+	if !ok {
+		return
+	}
+	if !s.trackSockets() {
+		writeError(w, http.StatusServiceUnavailable, "server is shutting down")
+		return
+	}
+	ws, err := upgrade(w, r)
+	if err != nil {
+		s.untrackSocket(nil)
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	sock := &socket{
+		ws:     ws,
+		client: c,
+		send:   make(chan []byte, sendQueueSize),
+		done:   make(chan struct{}),
+	}
+	s.addSocket(ws)
+	defer s.untrackSocket(ws)
+
+	go sock.writeLoop()
+	sock.readLoop()
+}
+
+// readLoop handles the peer's messages until it closes or breaks the
+// protocol, then ends the session
+func (sock *socket) readLoop() {
+	defer func() {
+		if sock.unsubscribe != nil {
+			sock.unsubscribe()
+		}
+		close(sock.done)
+		sock.ws.closeWith(closeNormal, "")
+	}()
+
+	for {
+		data, err := sock.ws.readMessage()
+		if err != nil {
+			var ce *closeError
+			if errors.As(err, &ce) {
+				sock.ws.closeWith(ce.code, ce.reason)
+			} else if !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, net.ErrClosed) {
+				log.Printf("WebSocket read failed: %v", err)
+			}
+			return
+		}
+
+		var req streamRequest
+		if err := json.Unmarshal(data, &req); err != nil {
+			sock.reply(streamMessage{Type: "error", Error: fmt.Sprintf("invalid JSON: %v", err)})
+			continue
+		}
+		switch req.Type {
+		case "search":
+			sock.search(req)
+		case "subscribe":
+			sock.subscribe(req)
+		default:
+			sock.reply(streamMessage{Type: "error", ID: req.ID, Error: fmt.Sprintf("unknown message type %q", req.Type)})
+		}
+	}
+}
+
+// writeLoop sends queued messages until the session ends
+func (sock *socket) writeLoop() {
+	for {
+		select {
+		case data := <-sock.send:
+			if err := sock.ws.writeFrame(opText, data); err != nil {
+				sock.ws.closeWith(closeNormal, "")
+				return
+			}
+		case <-sock.done:
+			return
+		}
+	}
+}
+
+// reply queues msg, waiting up to sendTimeout for room; a peer that
+// doesn't make room in time is dropped. Returns false if the session ended.
+func (sock *socket) reply(msg streamMessage) bool {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return false
+	}
+
+	timer := time.NewTimer(sendTimeout)
+	defer timer.Stop()
+	select {
+	case sock.send <- data:
+		return true
+	case <-sock.done:
+		return false
+	case <-timer.C:
+		sock.ws.closeWith(closePolicy, "slow consumer")
+		return false
+	}
+}
+
+// push queues msg without waiting, for events raised by other goroutines.
+// A peer whose queue is full is dropped.
+func (sock *socket) push(msg streamMessage) {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return
+	}
+	select {
+	case <-sock.done:
+	case sock.send <- data:
+	default:
+		// Closing waits for a stuck write to time out; don't hold up the writer
+		go sock.ws.closeWith(closePolicy, "slow consumer")
+	}
+}
+
+// search streams a "result" message per hit, closest first, then "done"
+func (sock *socket) search(req streamRequest) {
+	if req.Query == "" {
+		sock.reply(streamMessage{Type: "error", ID: req.ID, Error: "query is required"})
+		return
+	}
+
+	var results []types.ScoredNode
+	var err error
+	if req.Radius != nil {
+		metric := types.Euclidean
+		if req.Metric != "" {
+			if metric, err = types.ParseMetric(req.Metric); err != nil {
+				sock.reply(streamMessage{Type: "error", ID: req.ID, Error: err.Error()})
+				return
+			}
+		}
+		if *req.Radius < 0 {
+			sock.reply(streamMessage{Type: "error", ID: req.ID, Error: "radius must be non-negative"})
+			return
+		}
+		results, err = sock.client.SearchRadiusMetric(req.Query, *req.Radius, metric)
+	} else {
+		opts := types.SearchOptions{Epsilon: defaultEpsilon, Threshold: defaultThreshold, TopK: defaultTopK}
+		if req.Epsilon != nil {
+			opts.Epsilon = *req.Epsilon
+		}
+		if req.Threshold != nil {
+			opts.Threshold = *req.Threshold
+		}
+		if req.TopK != nil {
+			opts.TopK = *req.TopK
+		}
+		if opts.Epsilon < 0 || opts.Threshold < 0 || opts.Threshold > 1 || opts.TopK < 0 {
+			sock.reply(streamMessage{Type: "error", ID: req.ID, Error: "epsilon and top_k must be non-negative and threshold in [0, 1]"})
+			return
+		}
+		results, err = sock.client.SearchScored(req.Query, opts)
+	}
+	if err != nil {
+		sock.reply(streamMessage{Type: "error", ID: req.ID, Error: err.Error()})
+		return
+	}
+
+	for _, res := range results {
+		score := res.Score
+		hit := streamMessage{Type: "result", ID: req.ID, Key: res.Node.ID, Text: res.Node.Value, Metadata: rawMetadata(res.Node.Metadata), Score: &score}
+		if !sock.reply(hit) {
+			return
+		}
+	}
+	count := len(results)
+	sock.reply(streamMessage{Type: "done", ID: req.ID, Count: &count})
+}
+
+// subscribe pushes an "event" for every later change to the agent
+func (sock *socket) subscribe(req streamRequest) {
+	if sock.unsubscribe != nil {
+		sock.reply(streamMessage{Type: "error", ID: req.ID, Error: "already subscribed"})
+		return
+	}
+	sock.unsubscribe = sock.client.OnChange(func(event client.ChangeEvent) {
+		sock.push(streamMessage{Type: "event", Op: event.Op, Key: event.Key, Text: event.Value, Time: &event.Time})
+	})
+	sock.reply(streamMessage{Type: "subscribed", ID: req.ID})
+}
+
+// trackSockets reserves a place for a new socket, returning false once
+// Shutdown has begun
+func (s *Server) trackSockets() bool {
+	s.socketsMu.Lock()
+	defer s.socketsMu.Unlock()
+	if s.socketsClosed {
+		return false
+	}
+	s.socketsWG.Add(1)
+	return true
+}
+
+// addSocket registers an upgraded socket for closeSockets, closing it at
+// once if Shutdown began during the upgrade
+func (s *Server) addSocket(ws *wsConn) {
+	s.socketsMu.Lock()
+	defer s.socketsMu.Unlock()
+	if s.socketsClosed {
+		go ws.closeWith(closeGoingAway, "server shutting down")
+		return
+	}
+	s.sockets[ws] = struct{}{}
+}
+
+// untrackSocket releases a place reserved by trackSockets
+func (s *Server) untrackSocket(ws *wsConn) {
+	s.socketsMu.Lock()
+	delete(s.sockets, ws)
+	s.socketsMu.Unlock()
+	s.socketsWG.Done()
+}
+
+// closeSockets closes every socket with 1001 Going Away and waits for
+// their sessions to end
+func (s *Server) closeSockets() {
+	s.socketsMu.Lock()
+	s.socketsClosed = true
+	for ws := range s.sockets {
+		go ws.closeWith(closeGoingAway, "server shutting down")
+	}
+	s.socketsMu.Unlock()
+	s.socketsWG.Wait()
+}
@@ -0,0 +1,269 @@
+package httpapi
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The server side of WebSocket (RFC 6455), as much as the API needs:
+// text messages, fragmentation, ping/pong and the close handshake. There
+// are no extensions or subprotocols.
+
+// websocketGUID is appended to the client's key to form the accept header
+const websocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// Frame opcodes
+const (
+	opContinuation = 0x0
+	opText         = 0x1
+	opBinary       = 0x2
+	opClose        = 0x8
+	opPing         = 0x9
+	opPong         = 0xA
+)
+
+// Close codes
+const (
+	closeNormal        = 1000
+	closeGoingAway     = 1001 // Server shutting down
+	closeProtocolError = 1002
+	closeUnsupported   = 1003 // Binary messages
+	closePolicy        = 1008 // Slow consumers
+	closeTooBig        = 1009
+)
+
+const (
+	maxMessageBytes = 1 << 20
+	writeTimeout    = 10 * time.Second
+	closeTimeout    = time.Second
+)
+
+// closeError ends the read loop, carrying the close code to send
+type closeError struct {
+	code   int
+	reason string
+}
+
+func (e *closeError) Error() string {
+	return fmt.Sprintf("websocket closed (%d %s)", e.code, e.reason)
+}
+
+// wsConn is an upgraded connection. Reads happen on one goroutine; writes
+// may come from several and are serialized.
+type wsConn struct {
+	conn net.Conn
+	br   *bufio.Reader
+
+	writeMu sync.Mutex
+	broken  bool // A write failed part way, so no further frame can be sent
+
+	closeOnce sync.Once
+}
+
+// upgrade completes the opening handshake, taking over the connection
+func upgrade(w http.ResponseWriter, r *http.Request) (*wsConn, error) {
+	if !headerContains(r.Header, "Connection", "upgrade") || !headerContains(r.Header, "Upgrade", "websocket") {
+		return nil, errors.New("not a WebSocket upgrade request")
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		return nil, errors.New("unsupported WebSocket version")
+	}
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if key == "" {
+		return nil, errors.New("missing Sec-WebSocket-Key")
+	}
+
+	conn, brw, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		return nil, err
+	}
+
+	sum := sha1.Sum([]byte(key + websocketGUID))
+	brw.WriteString("HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + base64.StdEncoding.EncodeToString(sum[:]) + "\r\n\r\n")
+	if err := brw.Flush(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	conn.SetDeadline(time.Time{})
+	return &wsConn{conn: conn, br: brw.Reader}, nil
+}
+
+// headerContains reports whether a comma-separated header has token,
+// ignoring case
+func headerContains(h http.Header, name, token string) bool {
+	for _, value := range h.Values(name) {
+		for _, part := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(part), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// readMessage returns the next text message, answering pings on the way.
+// It returns a *closeError when the peer closes or breaks the protocol.
+func (c *wsConn) readMessage() ([]byte, error) {
+	var message []byte
+	inMessage := false
+	for {
+		fin, op, payload, err := c.readFrame()
+		if err != nil {
+			return nil, err
+		}
+
+		switch op {
+		case opPing:
+			if err := c.writeFrame(opPong, payload); err != nil {
+				return nil, err
+			}
+			continue
+		case opPong:
+			continue
+		case opClose:
+			code := closeNormal
+			if len(payload) >= 2 {
+				code = int(binary.BigEndian.Uint16(payload))
+			}
+			return nil, &closeError{code: code}
+		case opText, opBinary:
+			if inMessage {
+				return nil, &closeError{code: closeProtocolError, reason: "expected continuation frame"}
+			}
+			if op == opBinary {
+				return nil, &closeError{code: closeUnsupported, reason: "only text messages are supported"}
+			}
+			inMessage = true
+		case opContinuation:
+			if !inMessage {
+				return nil, &closeError{code: closeProtocolError, reason: "unexpected continuation frame"}
+			}
+		default:
+			return nil, &closeError{code: closeProtocolError, reason: fmt.Sprintf("unknown opcode %d", op)}
+		}
+
+		if len(message)+len(payload) > maxMessageBytes {
+			return nil, &closeError{code: closeTooBig, reason: fmt.Sprintf("message over %d bytes", maxMessageBytes)}
+		}
+		message = append(message, payload...)
+		if fin {
+			return message, nil
+		}
+	}
+}
+
+func (c *wsConn) readFrame() (fin bool, op byte, payload []byte, err error) {
+	var header [2]byte
+	if _, err := io.ReadFull(c.br, header[:]); err != nil {
+		return false, 0, nil, err
+	}
+	fin = header[0]&0x80 != 0
+	op = header[0] & 0x0F
+	if header[0]&0x70 != 0 {
+		return false, 0, nil, &closeError{code: closeProtocolError, reason: "reserved bits set"}
+	}
+	if header[1]&0x80 == 0 {
+		return false, 0, nil, &closeError{code: closeProtocolError, reason: "client frames must be masked"}
+	}
+
+	length := uint64(header[1] & 0x7F)
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(c.br, ext[:]); err != nil {
+			return false, 0, nil, err
+		}
+		length = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(c.br, ext[:]); err != nil {
+			return false, 0, nil, err
+		}
+		length = binary.BigEndian.Uint64(ext[:])
+	}
+	if op >= opClose && (length > 125 || !fin) {
+		return false, 0, nil, &closeError{code: closeProtocolError, reason: "invalid control frame"}
+	}
+	if length > maxMessageBytes {
+		return false, 0, nil, &closeError{code: closeTooBig, reason: fmt.Sprintf("message over %d bytes", maxMessageBytes)}
+	}
+
+	var mask [4]byte
+	if _, err := io.ReadFull(c.br, mask[:]); err != nil {
+		return false, 0, nil, err
+	}
+	payload = make([]byte, length)
+	if _, err := io.ReadFull(c.br, payload); err != nil {
+		return false, 0, nil, err
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return fin, op, payload, nil
+}
+
+// writeFrame sends payload as a single unmasked frame
+func (c *wsConn) writeFrame(op byte, payload []byte) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	c.conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+	return c.writeFrameLocked(op, payload)
+}
+
+func (c *wsConn) writeFrameLocked(op byte, payload []byte) error {
+	if c.broken {
+		return net.ErrClosed
+	}
+
+	frame := make([]byte, 0, len(payload)+10)
+	frame = append(frame, 0x80|op)
+	switch n := len(payload); {
+	case n <= 125:
+		frame = append(frame, byte(n))
+	case n <= 0xFFFF:
+		frame = append(frame, 126)
+		frame = binary.BigEndian.AppendUint16(frame, uint16(n))
+	default:
+		frame = append(frame, 127)
+		frame = binary.BigEndian.AppendUint64(frame, uint64(n))
+	}
+	frame = append(frame, payload...)
+
+	if _, err := c.conn.Write(frame); err != nil {
+		c.broken = true
+		return err
+	}
+	return nil
+}
+
+// closeWith sends a close frame with code and closes the connection. A
+// write stuck on a peer that stopped reading is cut short first, in which
+// case the close frame may not get through. Later calls do nothing.
+func (c *wsConn) closeWith(code int, reason string) {
+	c.closeOnce.Do(func() {
+		c.conn.SetWriteDeadline(time.Now())
+		c.writeMu.Lock()
+		c.conn.SetWriteDeadline(time.Now().Add(closeTimeout))
+		payload := binary.BigEndian.AppendUint16(nil, uint16(code))
+		if len(reason) > 123 {
+			reason = reason[:123]
+		}
+		c.writeFrameLocked(opClose, append(payload, reason...))
+		c.writeMu.Unlock()
+		c.conn.Close()
+	})
+}
@@ -0,0 +1,393 @@
+// Package mcp serves a client's memories as Model Context Protocol tools,
+// speaking JSON-RPC 2.0 over a stream such as stdio, so MCP-capable agents
+// can use Hippocampus as their memory
+package mcp
+
+import (
+	"Hippocampus/src/client"
+	"Hippocampus/src/types"
+	"Hippocampus/src/version"
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// protocolVersions are the MCP revisions the server speaks, newest last
+var protocolVersions = []string{"2024-11-05", "2025-03-26", "2025-06-18"}
+
+// Search parameters used when memory_search omits them, as for the HTTP API
+const (
+	defaultEpsilon   = 0.3
+	defaultThreshold = 0.5
+	defaultTopK      = 5
+)
+
+// maxLineBytes bounds a single JSON-RPC message
+const maxLineBytes = 16 << 20
+
+// JSON-RPC error codes
+const (
+	codeParseError     = -32700
+	codeInvalidRequest = -32600
+	codeMethodNotFound = -32601
+	codeInvalidParams  = -32602
+)
+
+// Server answers MCP requests with the memories of one client
+type Server struct {
+	client *client.Client
+	agent  string
+
+	mu  sync.Mutex // Serializes writes to out
+	out io.Writer
+}
+
+// NewServer returns a server for the memories of c, naming agent in its
+// initialize instructions
+func NewServer(c *client.Client, agent string) *Server {
+	return &Server{client: c, agent: agent}
+}
+
+type request struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+}
+
+type response struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  any             `json:"result,omitempty"`
+	Error   *rpcError       `json:"error,omitempty"`
+}
+
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// Tool describes a tool in tools/list and the initialize result
+type Tool struct {
+	Name        string         `json:"name"`
+	Description string         `json:"description"`
+	InputSchema map[string]any `json:"inputSchema"`
+}
+
+type content struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+// toolResult is the result of tools/call. Tool failures are reported here
+// with IsError set, not as JSON-RPC errors, so the model sees them.
+type toolResult struct {
+	Content []content `json:"content"`
+	IsError bool      `json:"isError,omitempty"`
+}
+
+// Serve reads newline-delimited JSON-RPC messages from in and writes the
+// replies to out until in is exhausted. A malformed message gets an error
+// reply; it does not stop the loop.
+func (s *Server) Serve(in io.Reader, out io.Writer) error {
+	s.out = out
+	scanner := bufio.NewScanner(in)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxLineBytes)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		if resp := s.handle(line); resp != nil {
+			if err := s.write(resp); err != nil {
+				return err
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read request: %w", err)
+	}
+	return nil
+}
+
+func (s *Server) write(resp *response) error {
+	data, err := json.Marshal(resp)
+	if err != nil {
+		return fmt.Errorf("failed to encode response: %w", err)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, err := s.out.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("failed to write response: %w", err)
+	}
+	return nil
+}
+
+// handle answers one message, returning nil for notifications
+func (s *Server) handle(line []byte) *response {
+	var req request
+	if err := json.Unmarshal(line, &req); err != nil {
+		return errorResponse(json.RawMessage("null"), codeParseError, fmt.Sprintf("parse error: %v", err))
+	}
+	if req.JSONRPC != "2.0" || req.Method == "" {
+		id := req.ID
+		if id == nil {
+			id = json.RawMessage("null")
+		}
+		return errorResponse(id, codeInvalidRequest, "invalid request")
+	}
+	// Notifications, e.g. notifications/initialized, get no reply
+	if req.ID == nil {
+		return nil
+	}
+
+	var result any
+	var err *rpcError
+	switch req.Method {
+	case "initialize":
+		result, err = s.initialize(req.Params)
+	case "ping":
+		result = struct{}{}
+	case "tools/list":
+		result = map[string]any{"tools": tools()}
+	case "tools/call":
+		result, err = s.callTool(req.Params)
+	default:
+		err = &rpcError{Code: codeMethodNotFound, Message: fmt.Sprintf("method not found: %s", req.Method)}
+	}
+	if err != nil {
+		return &response{JSONRPC: "2.0", ID: req.ID, Error: err}
+	}
+	return &response{JSONRPC: "2.0", ID: req.ID, Result: result}
+}
+
+func errorResponse(id json.RawMessage, code int, msg string) *response {
+	return &response{JSONRPC: "2.0", ID: id, Error: &rpcError{Code: code, Message: msg}}
+}
+
+// initialize agrees on the protocol version: the client's if the server
+// speaks it, otherwise the newest the server speaks. The tool schemas are
+// included so clients need not wait for tools/list.
+func (s *Server) initialize(params json.RawMessage) (any, *rpcError) {
+	var p struct {
+		ProtocolVersion string `json:"protocolVersion"`
+	}
+	if len(params) > 0 {
+		if err := json.Unmarshal(params, &p); err != nil {
+			return nil, &rpcError{Code: codeInvalidParams, Message: fmt.Sprintf("invalid params: %v", err)}
+		}
+	}
+	protocol := protocolVersions[len(protocolVersions)-1]
+	if slices.Contains(protocolVersions, p.ProtocolVersion) {
+		protocol = p.ProtocolVersion
+	}
+
+	return map[string]any{
+		"protocolVersion": protocol,
+		"capabilities":    map[string]any{"tools": map[string]any{"listChanged": false}},
+		"serverInfo":      map[string]string{"name": "hippocampus", "version": version.Get().Version},
+		"instructions":    fmt.Sprintf("Long-term memory for agent %q. Store facts with memory_store and recall them with memory_search.", s.agent),
+		"tools":           tools(),
+	}, nil
+}
+
+func tools() []Tool {
+	str := func(desc string) map[string]any { return map[string]any{"type": "string", "description": desc} }
+	object := func(props map[string]any, required ...string) map[string]any {
+		schema := map[string]any{"type": "object", "properties": props}
+		if len(required) > 0 {
+			schema["required"] = required
+		}
+		return schema
+	}
+
+	return []Tool{
+		{
+			Name:        "memory_store",
+			Description: "Store a memory under a key",
+			InputSchema: object(map[string]any{
+				"key":      str("Unique key of the memory"),
+				"text":     str("Text to remember"),
+				"metadata": map[string]any{"type": "object", "description": "Optional JSON object stored with the memory and returned by memory_search"},
+			}, "key", "text"),
+		},
+		{
+			Name:        "memory_search",
+			Description: "Find the stored memories most similar to a query",
+			InputSchema: object(map[string]any{
+				"query":     str("Text to search for"),
+				"top_k":     map[string]any{"type": "integer", "minimum": 0, "description": "Maximum number of memories returned (default 5)"},
+				"threshold": map[string]any{"type": "number", "minimum": 0, "maximum": 1, "description": "Similarity threshold, higher is stricter (default 0.5)"},
+			}, "query"),
+		},
+		{
+			Name:        "memory_delete",
+			Description: "Delete the memory stored under a key",
+			InputSchema: object(map[string]any{"key": str("Key of the memory")}, "key"),
+		},
+		{
+			Name:        "memory_list",
+			Description: "List the keys of every stored memory",
+			InputSchema: object(map[string]any{}),
+		},
+	}
+}
+
+// callTool runs a tool. Unknown tools and malformed params are protocol
+// errors; everything the tool itself rejects is a tool error.
+func (s *Server) callTool(params json.RawMessage) (any, *rpcError) {
+	var p struct {
+		Name      string          `json:"name"`
+		Arguments json.RawMessage `json:"arguments"`
+	}
+	if err := json.Unmarshal(params, &p); err != nil {
+		return nil, &rpcError{Code: codeInvalidParams, Message: fmt.Sprintf("invalid params: %v", err)}
+	}
+	if len(p.Arguments) == 0 || string(p.Arguments) == "null" {
+		p.Arguments = json.RawMessage("{}")
+	}
+
+	var text string
+	var err error
+	switch p.Name {
+	case "memory_store":
+		text, err = s.store(p.Arguments)
+	case "memory_search":
+		text, err = s.search(p.Arguments)
+	case "memory_delete":
+		text, err = s.delete(p.Arguments)
+	case "memory_list":
+		text, err = s.list()
+	default:
+		return nil, &rpcError{Code: codeInvalidParams, Message: fmt.Sprintf("unknown tool: %s", p.Name)}
+	}
+	if err != nil {
+		log.Printf("MCP %s failed: %v", p.Name, err)
+		return toolResult{Content: []content{{Type: "text", Text: err.Error()}}, IsError: true}, nil
+	}
+	return toolResult{Content: []content{{Type: "text", Text: text}}}, nil
+}
+
+func decodeArgs(args json.RawMessage, v any) error {
+	if err := json.Unmarshal(args, v); err != nil {
+		return fmt.Errorf("invalid arguments: %w", err)
+	}
+	return nil
+}
+
+func (s *Server) store(args json.RawMessage) (string, error) {
+	var a struct {
+		Key      string          `json:"key"`
+		Text     string          `json:"text"`
+		Metadata json.RawMessage `json:"metadata"`
+	}
+	if err := decodeArgs(args, &a); err != nil {
+		return "", err
+	}
+	if a.Key == "" || a.Text == "" {
+		return "", errors.New("key and text are required")
+	}
+	metadata := string(a.Metadata)
+	if metadata == "null" {
+		metadata = ""
+	}
+
+	if err := s.client.InsertWithMetadata(a.Key, a.Text, metadata); err != nil {
+		return "", err
+	}
+	if err := s.client.Flush(); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("Stored memory %q", a.Key), nil
+}
+
+// searchHit is a memory_search result, returned as JSON text
+type searchHit struct {
+	Key      string          `json:"key"`
+	Text     string          `json:"text"`
+	Metadata json.RawMessage `json:"metadata,omitempty"`
+	Score    float32         `json:"score"`
+}
+
+func (s *Server) search(args json.RawMessage) (string, error) {
+	var a struct {
+		Query     string   `json:"query"`
+		TopK      *int     `json:"top_k"`
+		Threshold *float32 `json:"threshold"`
+	}
+	if err := decodeArgs(args, &a); err != nil {
+		return "", err
+	}
+	if a.Query == "" {
+		return "", errors.New("query is required")
+	}
+	opts := types.SearchOptions{Epsilon: defaultEpsilon, Threshold: defaultThreshold, TopK: defaultTopK}
+	if a.TopK != nil {
+		opts.TopK = *a.TopK
+	}
+	if a.Threshold != nil {
+		opts.Threshold = *a.Threshold
+	}
+	if opts.TopK < 0 || opts.Threshold < 0 || opts.Threshold > 1 {
+		return "", errors.New("top_k must be non-negative and threshold in [0, 1]")
+	}
+
+	results, err := s.client.SearchScored(a.Query, opts)
+	if err != nil {
+		return "", err
+	}
+	hits := make([]searchHit, len(results))
+	for i, r := range results {
+		hits[i] = searchHit{Key: r.Node.ID, Text: r.Node.Value, Score: r.Score}
+		if r.Node.Metadata != "" {
+			hits[i].Metadata = json.RawMessage(r.Node.Metadata)
+		}
+	}
+	data, err := json.Marshal(hits)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+func (s *Server) delete(args json.RawMessage) (string, error) {
+	var a struct {
+		Key string `json:"key"`
+	}
+	if err := decodeArgs(args, &a); err != nil {
+		return "", err
+	}
+	if a.Key == "" {
+		return "", errors.New("key is required")
+	}
+
+	deleted, err := s.client.Delete(a.Key)
+	if err != nil {
+		return "", err
+	}
+	if !deleted {
+		return "", fmt.Errorf("no memory with key %q", a.Key)
+	}
+	if err := s.client.Flush(); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("Deleted memory %q", a.Key), nil
+}
+
+func (s *Server) list() (string, error) {
+	keys, err := s.client.Keys()
+	if err != nil {
+		return "", err
+	}
+	if len(keys) == 0 {
+		return "No memories stored", nil
+	}
+	return strings.Join(keys, "\n"), nil
+}
@@ -0,0 +1,274 @@
+package mcp
+
+import (
+	"Hippocampus/src/client"
+	"Hippocampus/src/embedding"
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func newTestServer(t *testing.T) *Server {
+	t.Helper()
+	c, err := client.New(embedding.NewMockEmbedder())
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.SetVerbose(false)
+	return NewServer(c, "test-agent")
+}
+
+// reply is a response as a client decodes it
+type reply struct {
+	ID     json.RawMessage `json:"id"`
+	Result json.RawMessage `json:"result"`
+	Error  *rpcError       `json:"error"`
+}
+
+// toolText returns the text and error flag of a tools/call result
+func (r reply) toolText(t *testing.T) (string, bool) {
+	t.Helper()
+	var res toolResult
+	if err := json.Unmarshal(r.Result, &res); err != nil || len(res.Content) != 1 {
+		t.Fatalf("reply %s is not a tool result: %v", r.Result, err)
+	}
+	return res.Content[0].Text, res.IsError
+}
+
+// session runs Serve over the given messages, one per line, and returns the
+// replies in order
+func session(t *testing.T, s *Server, messages ...string) []reply {
+	t.Helper()
+	var out bytes.Buffer
+	if err := s.Serve(strings.NewReader(strings.Join(messages, "\n")+"\n"), &out); err != nil {
+		t.Fatal(err)
+	}
+
+	var replies []reply
+	for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+		var r reply
+		if err := json.Unmarshal([]byte(line), &r); err != nil {
+			t.Fatalf("reply %q: %v", line, err)
+		}
+		replies = append(replies, r)
+	}
+	return replies
+}
+
+func TestStdioSession(t *testing.T) {
+	replies := session(t, newTestServer(t),
+		`{"jsonrpc":"2.0","id":1,"method":"initialize","params":{"protocolVersion":"2025-03-26","capabilities":{},"clientInfo":{"name":"test","version":"1"}}}`,
+		`{"jsonrpc":"2.0","method":"notifications/initialized"}`,
+		`{"jsonrpc":"2.0","id":2,"method":"tools/list"}`,
+		`{"jsonrpc":"2.0","id":3,"method":"tools/call","params":{"name":"memory_store","arguments":{"key":"pref","text":"Prefers email","metadata":{"source":"call"}}}}`,
+		`{"jsonrpc":"2.0","id":4,"method":"tools/call","params":{"name":"memory_search","arguments":{"query":"Prefers email","threshold":0,"top_k":1}}}`,
+		`{"jsonrpc":"2.0","id":5,"method":"tools/call","params":{"name":"memory_list"}}`,
+		`{"jsonrpc":"2.0","id":6,"method":"tools/call","params":{"name":"memory_delete","arguments":{"key":"pref"}}}`,
+		`{"jsonrpc":"2.0","id":7,"method":"tools/call","params":{"name":"memory_list","arguments":{}}}`,
+		`{"jsonrpc":"2.0","id":8,"method":"ping"}`,
+	)
+	// The notification gets no reply
+	if len(replies) != 8 {
+		t.Fatalf("got %d replies, want 8", len(replies))
+	}
+	for i, r := range replies {
+		if want := string(rune('1' + i)); string(r.ID) != want || r.Error != nil {
+			t.Fatalf("reply %d: id %s, error %v", i, r.ID, r.Error)
+		}
+	}
+
+	var init struct {
+		ProtocolVersion string `json:"protocolVersion"`
+		Tools           []Tool `json:"tools"`
+	}
+	json.Unmarshal(replies[0].Result, &init)
+	if init.ProtocolVersion != "2025-03-26" || len(init.Tools) != 4 {
+		t.Fatalf("initialize: %s", replies[0].Result)
+	}
+	var list struct {
+		Tools []Tool `json:"tools"`
+	}
+	json.Unmarshal(replies[1].Result, &list)
+	if len(list.Tools) != 4 || list.Tools[0].Name != "memory_store" {
+		t.Fatalf("tools/list: %s", replies[1].Result)
+	}
+
+	if text, isError := replies[2].toolText(t); isError {
+		t.Fatalf("memory_store failed: %s", text)
+	}
+	text, _ := replies[3].toolText(t)
+	var hits []searchHit
+	if err := json.Unmarshal([]byte(text), &hits); err != nil {
+		t.Fatalf("memory_search returned %q: %v", text, err)
+	}
+	if len(hits) != 1 || hits[0].Key != "pref" || string(hits[0].Metadata) != `{"source":"call"}` {
+		t.Fatalf("memory_search hits %+v", hits)
+	}
+	if text, _ := replies[4].toolText(t); text != "pref" {
+		t.Fatalf("memory_list = %q, want pref", text)
+	}
+	if text, isError := replies[5].toolText(t); isError {
+		t.Fatalf("memory_delete failed: %s", text)
+	}
+	if text, _ := replies[6].toolText(t); text != "No memories stored" {
+		t.Fatalf("memory_list after delete = %q", text)
+	}
+}
+
+func TestToolErrorsKeepServing(t *testing.T) {
+	replies := session(t, newTestServer(t),
+		`{"jsonrpc":"2.0","id":1,"method":"tools/call","params":{"name":"memory_store","arguments":{"key":"k"}}}`,
+		`{"jsonrpc":"2.0","id":2,"method":"tools/call","params":{"name":"memory_store","arguments":{"key":"k","text":"t","metadata":[1]}}}`,
+		`{"jsonrpc":"2.0","id":3,"method":"tools/call","params":{"name":"memory_delete","arguments":{"key":"missing"}}}`,
+		`{"jsonrpc":"2.0","id":4,"method":"tools/call","params":{"name":"memory_search","arguments":{"query":"q","threshold":2}}}`,
+		`{"jsonrpc":"2.0","id":5,"method":"tools/call","params":{"name":"memory_store","arguments":"not an object"}}`,
+		`{"jsonrpc":"2.0","id":6,"method":"ping"}`,
+	)
+	if len(replies) != 6 {
+		t.Fatalf("got %d replies, want 6", len(replies))
+	}
+	for i, r := range replies[:5] {
+		if text, isError := r.toolText(t); !isError {
+			t.Errorf("reply %d = %q, want a tool error", i+1, text)
+		}
+	}
+	if replies[5].Error != nil {
+		t.Fatalf("ping after tool errors: %v", replies[5].Error)
+	}
+}
+
+func TestProtocolErrors(t *testing.T) {
+	replies := session(t, newTestServer(t),
+		`not json`,
+		`{"jsonrpc":"1.0","id":2,"method":"ping"}`,
+		`{"jsonrpc":"2.0","id":3,"method":"resources/list"}`,
+		`{"jsonrpc":"2.0","id":4,"method":"tools/call","params":{"name":"memory_forget"}}`,
+		`{"jsonrpc":"2.0","id":5,"method":"initialize","params":{"protocolVersion":"1999-01-01"}}`,
+	)
+	want := []struct {
+		id   string
+		code int
+	}{
+		{"null", codeParseError},
+		{"2", codeInvalidRequest},
+		{"3", codeMethodNotFound},
+		{"4", codeInvalidParams},
+	}
+	if len(replies) != 5 {
+		t.Fatalf("got %d replies, want 5", len(replies))
+	}
+	for i, w := range want {
+		r := replies[i]
+		if string(r.ID) != w.id || r.Error == nil || r.Error.Code != w.code {
+			t.Errorf("reply %d: id %s, error %+v, want id %s and code %d", i, r.ID, r.Error, w.id, w.code)
+		}
+	}
+
+	// An unknown protocol version gets the newest the server speaks
+	var init struct {
+		ProtocolVersion string `json:"protocolVersion"`
+	}
+	json.Unmarshal(replies[4].Result, &init)
+	if init.ProtocolVersion != protocolVersions[len(protocolVersions)-1] {
+		t.Fatalf("negotiated %q", init.ProtocolVersion)
+	}
+}
+
+// sseEvent reads the next event from a stream, skipping comments
+func sseEvent(t *testing.T, br *bufio.Reader) (event, data string) {
+	t.Helper()
+	for {
+		line, err := br.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		line = strings.TrimRight(line, "\n")
+		switch {
+		case strings.HasPrefix(line, "event: "):
+			event = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			data = strings.TrimPrefix(line, "data: ")
+		case line == "" && event != "":
+			return event, data
+		}
+	}
+}
+
+func TestSSESession(t *testing.T) {
+	ts := httptest.NewServer(newTestServer(t).SSEHandler())
+	defer ts.Close()
+
+	resp, err := ts.Client().Get(ts.URL + "/sse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("Content-Type %q", ct)
+	}
+	br := bufio.NewReader(resp.Body)
+	event, endpoint := sseEvent(t, br)
+	if event != "endpoint" || !strings.HasPrefix(endpoint, "/messages?sessionId=") {
+		t.Fatalf("first event %s %q, want the endpoint", event, endpoint)
+	}
+
+	post := func(body string) int {
+		t.Helper()
+		resp, err := ts.Client().Post(ts.URL+endpoint, "application/json", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	if status := post(`{"jsonrpc":"2.0","method":"notifications/initialized"}`); status != http.StatusAccepted {
+		t.Fatalf("notification = %d, want 202", status)
+	}
+	if status := post(`{"jsonrpc":"2.0","id":1,"method":"tools/call","params":{"name":"memory_store","arguments":{"key":"k","text":"over sse","metadata":{"n":1}}}}`); status != http.StatusAccepted {
+		t.Fatalf("POST = %d, want 202", status)
+	}
+	event, data := sseEvent(t, br)
+	var r reply
+	if err := json.Unmarshal([]byte(data), &r); err != nil || event != "message" || string(r.ID) != "1" {
+		t.Fatalf("event %s %q, want the reply to 1", event, data)
+	}
+	if text, isError := r.toolText(t); isError {
+		t.Fatalf("memory_store over SSE failed: %s", text)
+	}
+
+	// A malformed message is answered on the stream too
+	post(`not json`)
+	if _, data := sseEvent(t, br); !strings.Contains(data, `"code":-32700`) {
+		t.Fatalf("reply to malformed message %q", data)
+	}
+}
+
+func TestSSERejects(t *testing.T) {
+	ts := httptest.NewServer(newTestServer(t).SSEHandler())
+	defer ts.Close()
+
+	resp, err := ts.Client().Post(ts.URL+"/messages?sessionId=nope", "application/json", strings.NewReader(`{}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("unknown session = %d, want 404", resp.StatusCode)
+	}
+
+	req, _ := http.NewRequest("GET", ts.URL+"/sse", nil)
+	req.Header.Set("Origin", "http://evil.example")
+	resp, err = ts.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("cross-origin stream = %d, want 403", resp.StatusCode)
+	}
+}
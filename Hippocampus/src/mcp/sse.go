@@ -0,0 +1,168 @@
+package mcp
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+const (
+	// sseQueueSize is how many replies a session buffers for its stream
+	sseQueueSize = 64
+	// sseSendTimeout is how long a POST waits for room in a full queue
+	sseSendTimeout = 5 * time.Second
+	// sseKeepAlive is how often an idle stream gets a comment, so proxies
+	// don't close it
+	sseKeepAlive = 30 * time.Second
+)
+
+// sseSession is one client's event stream. Replies to its POSTed messages
+// are queued on events and written by the stream's handler.
+type sseSession struct {
+	events chan []byte
+	done   chan struct{} // Closed when the stream ends
+}
+
+// sseSessions tracks the open streams of an SSE handler by session ID
+type sseSessions struct {
+	mu       sync.Mutex
+	sessions map[string]*sseSession
+}
+
+// SSEHandler serves MCP over the HTTP+SSE transport: GET /sse opens an
+// event stream whose first "endpoint" event names the URL to POST messages
+// to, and replies arrive on the stream as "message" events. Each stream is
+// a session; sessions share the server's client.
+//
+// Requests whose Origin names another host than the request's are refused,
+// against DNS rebinding from web pages; listen on localhost unless clients
+// are remote.
+func (s *Server) SSEHandler() http.Handler {
+	ss := &sseSessions{sessions: make(map[string]*sseSession)}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /sse", func(w http.ResponseWriter, r *http.Request) { s.sseStream(ss, w, r) })
+	mux.HandleFunc("POST /messages", func(w http.ResponseWriter, r *http.Request) { s.sseMessage(ss, w, r) })
+	return checkOrigin(mux)
+}
+
+// checkOrigin refuses cross-origin requests
+func checkOrigin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if origin := r.Header.Get("Origin"); origin != "" {
+			u, err := url.Parse(origin)
+			if err != nil || u.Host != r.Host {
+				http.Error(w, "cross-origin request refused", http.StatusForbidden)
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// sseStream opens a session and streams its replies until the client goes
+func (s *Server) sseStream(ss *sseSessions, w http.ResponseWriter, r *http.Request) {
+	rc := http.NewResponseController(w)
+
+	var raw [16]byte
+	if _, err := rand.Read(raw[:]); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	id := hex.EncodeToString(raw[:])
+	session := &sseSession{events: make(chan []byte, sseQueueSize), done: make(chan struct{})}
+
+	ss.mu.Lock()
+	ss.sessions[id] = session
+	ss.mu.Unlock()
+	defer func() {
+		ss.mu.Lock()
+		delete(ss.sessions, id)
+		ss.mu.Unlock()
+		close(session.done)
+	}()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	if err := writeEvent(w, rc, "endpoint", "/messages?sessionId="+id); err != nil {
+		return
+	}
+	log.Printf("MCP SSE session %s opened from %s", id, r.RemoteAddr)
+
+	keepAlive := time.NewTicker(sseKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		var err error
+		select {
+		case <-r.Context().Done():
+			log.Printf("MCP SSE session %s closed", id)
+			return
+		case data := <-session.events:
+			err = writeEvent(w, rc, "message", string(data))
+		case <-keepAlive.C:
+			if _, err = io.WriteString(w, ": keep-alive\n\n"); err == nil {
+				err = rc.Flush()
+			}
+		}
+		if err != nil {
+			log.Printf("MCP SSE session %s: %v", id, err)
+			return
+		}
+	}
+}
+
+// writeEvent sends one server-sent event. data holds no newlines: it is a
+// path or compact JSON.
+func writeEvent(w io.Writer, rc *http.ResponseController, event, data string) error {
+	if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, data); err != nil {
+		return err
+	}
+	return rc.Flush()
+}
+
+// sseMessage handles a message POSTed to a session, queueing the reply, if
+// any, on its stream. The POST itself is answered 202 Accepted.
+func (s *Server) sseMessage(ss *sseSessions, w http.ResponseWriter, r *http.Request) {
+	ss.mu.Lock()
+	session, ok := ss.sessions[r.URL.Query().Get("sessionId")]
+	ss.mu.Unlock()
+	if !ok {
+		http.Error(w, "unknown session", http.StatusNotFound)
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxLineBytes))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	resp := s.handle(body)
+	if resp == nil {
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+	data, err := json.Marshal(resp)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	timer := time.NewTimer(sseSendTimeout)
+	defer timer.Stop()
+	select {
+	case session.events <- data:
+		w.WriteHeader(http.StatusAccepted)
+	case <-session.done:
+		http.Error(w, "session closed", http.StatusNotFound)
+	case <-timer.C:
+		http.Error(w, "session is not reading its stream", http.StatusServiceUnavailable)
+	}
+}
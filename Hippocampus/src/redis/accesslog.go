@@ -0,0 +1,154 @@
+package redis
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// defaultAccessLogQueue is how many events the access log buffers before dropping
+const defaultAccessLogQueue = 4096
+
+// CommandEvent describes one executed command, for the access log and OnCommand hooks
+type CommandEvent struct {
+	Time       time.Time     `json:"ts"`
+	RemoteAddr string        `json:"addr"`
+	Agent      string        `json:"agent,omitempty"`
+	Command    string        `json:"cmd"`
+	ArgSizes   []int         `json:"arg_sizes"` // Byte length of each argument after the command name
+	Duration   time.Duration `json:"duration_us"`
+	Status     string        `json:"status"` // "OK", or the error code of an error reply
+}
+
+// MarshalJSON writes Duration in microseconds rather than nanoseconds
+func (e CommandEvent) MarshalJSON() ([]byte, error) {
+	type event CommandEvent
+	return json.Marshal(struct {
+		event
+		Duration int64 `json:"duration_us"`
+	}{event(e), e.Duration.Microseconds()})
+}
+
+// OnCommand registers a hook called after every command. Hooks run on the
+// connection's goroutine, so they should hand events off rather than block.
+func (s *RedisServer) OnCommand(hook func(CommandEvent)) {
+	s.hooksMu.Lock()
+	defer s.hooksMu.Unlock()
+	s.hooks = append(s.hooks, hook)
+}
+
+// WithAccessLog writes one JSON line per command to w. Lines are written by a
+// background goroutine from a queue of queueSize events; when the queue is
+// full (e.g. the disk stalls) events are dropped rather than delaying commands.
+func WithAccessLog(w io.Writer, queueSize int) Option {
+	return func(s *RedisServer) {
+		s.accessLog = newAccessLog(w, queueSize)
+	}
+}
+
+// emitCommand passes a finished command to the access log and hooks
+func (s *RedisServer) emitCommand(ci *connInfo, raw [][]byte, agent string, start time.Time, duration time.Duration, response interface{}) {
+	s.hooksMu.RLock()
+	hooks := s.hooks
+	s.hooksMu.RUnlock()
+
+	if s.accessLog == nil && len(hooks) == 0 {
+		return
+	}
+
+	event := CommandEvent{
+		Time:       start,
+		RemoteAddr: ci.addr,
+		Agent:      agent,
+		Duration:   duration,
+		Status:     "OK",
+	}
+	if len(raw) > 0 {
+		event.Command = string(raw[0])
+		event.ArgSizes = make([]int, len(raw)-1)
+		for i, arg := range raw[1:] {
+			event.ArgSizes[i] = len(arg)
+		}
+	}
+	if err, ok := response.(error); ok {
+		event.Status = toReplyError(err).code
+	}
+
+	if s.accessLog != nil {
+		s.accessLog.log(event)
+	}
+	for _, hook := range hooks {
+		hook(event)
+	}
+}
+
+// accessLog writes CommandEvents as JSON lines from a bounded queue
+type accessLog struct {
+	mu     sync.Mutex // Guards closed against sends on the closed queue
+	closed bool
+
+	events  chan CommandEvent
+	done    chan struct{}
+	dropped atomic.Int64
+}
+
+func newAccessLog(w io.Writer, queueSize int) *accessLog {
+	if queueSize < 1 {
+		queueSize = defaultAccessLogQueue
+	}
+	al := &accessLog{
+		events: make(chan CommandEvent, queueSize),
+		done:   make(chan struct{}),
+	}
+	go al.run(w)
+	return al
+}
+
+func (al *accessLog) log(event CommandEvent) {
+	al.mu.Lock()
+	defer al.mu.Unlock()
+
+	if al.closed {
+		return
+	}
+	select {
+	case al.events <- event:
+	default:
+		al.dropped.Add(1)
+	}
+}
+
+func (al *accessLog) run(w io.Writer) {
+	defer close(al.done)
+
+	bw := bufio.NewWriter(w)
+	enc := json.NewEncoder(bw)
+	for event := range al.events {
+		if err := enc.Encode(event); err != nil {
+			log.Printf("Access log write failed: %v", err)
+		}
+		// Flush once the queue is drained rather than after every line
+		if len(al.events) == 0 {
+			bw.Flush()
+		}
+	}
+	bw.Flush()
+}
+
+// Close stops accepting events and waits for queued ones to be written
+func (al *accessLog) Close() {
+	al.mu.Lock()
+	if al.closed {
+		al.mu.Unlock()
+		return
+	}
+	al.closed = true
+	close(al.events)
+	al.mu.Unlock()
+
+	<-al.done
+}
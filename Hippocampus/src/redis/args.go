@@ -0,0 +1,83 @@
+package redis
+
+import (
+	"strings"
+)
+
+// maxRetainedArgBuffer bounds the argument buffer a connection keeps between
+// commands. A buffer grown past it by a large payload is dropped once the
+// command completes instead of being held for the life of the connection.
+const maxRetainedArgBuffer = 1 << 20
+
+// Request limits, Redis's defaults. Lengths beyond them are refused before
+// anything is allocated for them.
+const (
+	// maxMultiBulkLen is the most arguments a command may have
+	maxMultiBulkLen = 1024 * 1024
+
+	// protoMaxBulkLen is the largest argument unless -max-dump-size allows a
+	// larger HRESTORE payload, like Redis's proto-max-bulk-len
+	protoMaxBulkLen = 512 << 20
+
+	// bulkReadChunk is how much of an argument is allocated ahead of the
+	// bytes arriving, so a length the client never sends costs little
+	bulkReadChunk = 1 << 20
+
+	// maxInlineLen is the longest line read: an inline command, or the
+	// header of an array or bulk string, like Redis's PROTO_INLINE_MAX_SIZE
+	maxInlineLen = 64 * 1024
+)
+
+// argBuffer holds the bulk arguments of the command being processed and is
+// reused for the next command on the same connection
+type argBuffer struct {
+	buf []byte
+}
+
+// grow returns the buffer with room for n more bytes
+func (ab *argBuffer) grow(n int) []byte {
+	if cap(ab.buf)-len(ab.buf) < n {
+		// Double so commands with many arguments don't copy on every one
+		grown := make([]byte, len(ab.buf), max(len(ab.buf)+n, 2*cap(ab.buf)))
+		copy(grown, ab.buf)
+		ab.buf = grown
+	}
+	return ab.buf
+}
+
+// release resets the buffer after a command, freeing it if a large payload grew it
+func (ab *argBuffer) release() {
+	if cap(ab.buf) > maxRetainedArgBuffer {
+		ab.buf = nil
+		return
+	}
+	ab.buf = ab.buf[:0]
+}
+
+// rawArgs maps commands to the argument their handler reads as bytes rather
+// than as a string, so a large payload isn't copied a second time. That
+// argument is left empty in the string form of the command.
+var rawArgs = map[string]int{
+	"HINSERT":  2,
+	"HGET":     2,
+	"HRESTORE": 2,
+}
+
+// commandStrings converts the raw arguments to strings, skipping the payload
+// argument of commands listed in rawArgs
+func commandStrings(raw [][]byte) []string {
+	cmd := make([]string, len(raw))
+	skip := -1
+	if len(raw) > 0 {
+		if i, ok := rawArgs[strings.ToUpper(string(raw[0]))]; ok {
+			skip = i
+		}
+	}
+
+	for i, arg := range raw {
+		if i != skip {
+			cmd[i] = string(arg)
+		}
+	}
+	return cmd
+}
@@ -0,0 +1,187 @@
+package redis
+
+import (
+	"Hippocampus/src/client"
+	"Hippocampus/src/embedding"
+	"Hippocampus/src/types"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// defaultBroadcastMaxAgents is the most agents BROADCAST SEARCH searches
+// unless WithBroadcastMaxAgents says otherwise
+const defaultBroadcastMaxAgents = 1000
+
+// SearchResult is one hit of BroadcastSearch
+type SearchResult struct {
+	Key   string  `json:"key"`
+	Text  string  `json:"text"`
+	Score float32 `json:"score"`
+}
+
+// WithBroadcastMaxAgents caps how many agents one BROADCAST SEARCH searches;
+// 0 means every loaded agent
+func WithBroadcastMaxAgents(n int) Option {
+	return func(s *RedisServer) {
+		s.broadcastMaxAgents = n
+	}
+}
+
+// BroadcastSearch searches every loaded agent for query, returning up to
+// topK hits per agent for the agents with any. An agent whose search fails
+// is logged and left out.
+func (s *RedisServer) BroadcastSearch(query string, epsilon, threshold float32, topK int) map[string][]SearchResult {
+	results, _ := s.broadcastSearch(query, types.SearchOptions{Epsilon: epsilon, Threshold: threshold, TopK: topK})
+	return results
+}
+
+// broadcastSearch is BroadcastSearch, also returning the first error when no
+// agent could be searched. Agents are taken from under the manager's lock,
+// which is then released, so the searches don't hold up other commands and
+// don't count as use of the agents for idle eviction. Agents on the server's
+// embedder share one embedding of the query; those with their own from
+// HAGENT SET embed it themselves. Past WithBroadcastMaxAgents, the agents
+// first in ID order are searched.
+func (s *RedisServer) broadcastSearch(query string, opts types.SearchOptions) (map[string][]SearchResult, error) {
+	type target struct {
+		agentID string
+		c       *client.Client
+	}
+	var targets []target
+	s.agents.Range(func(agentID string, c *client.Client) bool {
+		targets = append(targets, target{agentID, c})
+		return true
+	})
+	sort.Slice(targets, func(i, j int) bool { return targets[i].agentID < targets[j].agentID })
+	if s.broadcastMaxAgents > 0 && len(targets) > s.broadcastMaxAgents {
+		targets = targets[:s.broadcastMaxAgents]
+	}
+
+	results := make(map[string][]SearchResult)
+	if len(targets) == 0 {
+		return results, nil
+	}
+
+	var shared []float32
+	var sharedErr error
+	var sharedOnce sync.Once
+	search := func(t target) ([]types.ScoredNode, error) {
+		if _, ok := s.agentEmbedders.get(t.agentID); ok {
+			return t.c.SearchScored(query, opts)
+		}
+		sharedOnce.Do(func() {
+			shared, sharedErr = embedding.GetEmbedding(context.Background(), s.embedder, query)
+			if sharedErr != nil {
+				sharedErr = fmt.Errorf("%w: %w", client.ErrEmbedding, sharedErr)
+			}
+		})
+		if sharedErr != nil {
+			return nil, sharedErr
+		}
+		return t.c.SearchByEmbedding(shared, opts.Epsilon, opts.Threshold, opts.TopK)
+	}
+
+	var mu sync.Mutex
+	var firstErr error
+	failed := 0
+
+	jobs := make(chan target)
+	var wg sync.WaitGroup
+	for range min(len(targets), runtime.NumCPU()) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for t := range jobs {
+				hits, err := search(t)
+
+				mu.Lock()
+				if err != nil {
+					if firstErr == nil {
+						firstErr = err
+					}
+					failed++
+				} else if len(hits) > 0 {
+					if opts.TopK > 0 && len(hits) > opts.TopK {
+						hits = hits[:opts.TopK]
+					}
+					agentHits := make([]SearchResult, len(hits))
+					for i, h := range hits {
+						agentHits[i] = SearchResult{Key: h.Node.ID, Text: h.Node.Value, Score: h.Score}
+					}
+					results[t.agentID] = agentHits
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	for _, t := range targets {
+		jobs <- t
+	}
+	close(jobs)
+	wg.Wait()
+
+	if failed > 0 {
+		log.Printf("BROADCAST SEARCH: %d of %d agents failed, the first with: %v", failed, len(targets), firstErr)
+	}
+	if failed == len(targets) {
+		return nil, firstErr
+	}
+	return results, nil
+}
+
+// processBroadcast handles BROADCAST SEARCH query epsilon threshold topk,
+// replying agent IDs alternating with a JSON array of each agent's hits, the
+// RESP2 form of a map
+func (s *RedisServer) processBroadcast(cmd []string) interface{} {
+	if len(cmd) < 2 || !strings.EqualFold(cmd[1], "SEARCH") {
+		return fmt.Errorf("unknown BROADCAST subcommand (expected SEARCH)")
+	}
+	if len(cmd) != 6 {
+		return errWrongArgs("BROADCAST SEARCH")
+	}
+
+	epsilon, err := strconv.ParseFloat(cmd[3], 32)
+	if err != nil {
+		return fmt.Errorf("invalid epsilon: %v", err)
+	}
+	threshold, err := strconv.ParseFloat(cmd[4], 32)
+	if err != nil {
+		return fmt.Errorf("invalid threshold: %v", err)
+	}
+	topK, err := strconv.Atoi(cmd[5])
+	if err != nil || topK < 1 {
+		return fmt.Errorf("invalid topK: must be a positive integer")
+	}
+
+	results, err := s.broadcastSearch(cmd[2], types.SearchOptions{
+		Epsilon:   float32(epsilon),
+		Threshold: float32(threshold),
+		TopK:      topK,
+	})
+	if err != nil {
+		return err
+	}
+
+	agentIDs := make([]string, 0, len(results))
+	for agentID := range results {
+		agentIDs = append(agentIDs, agentID)
+	}
+	sort.Strings(agentIDs)
+
+	reply := make([]string, 0, 2*len(agentIDs))
+	for _, agentID := range agentIDs {
+		hits, err := json.Marshal(results[agentID])
+		if err != nil {
+			return err
+		}
+		reply = append(reply, agentID, string(hits))
+	}
+	return reply
+}
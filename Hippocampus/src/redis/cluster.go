@@ -0,0 +1,351 @@
+package redis
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// clusterVirtualNodes is how many points each node gets on the hash ring.
+	// More points spread agents more evenly across nodes.
+	clusterVirtualNodes = 128
+
+	clusterPoolSize       = 16               // Idle connections kept per backend node
+	clusterRequestTimeout = 30 * time.Second // Per proxied command, including the reply
+)
+
+// hashRing maps agent IDs to nodes by consistent hashing, so adding a node only
+// moves the agents that land on its new points
+type hashRing struct {
+	points []uint32
+	owners []string // owners[i] owns the arc ending at points[i]
+}
+
+func newHashRing(nodes []string) *hashRing {
+	type point struct {
+		hash uint32
+		node string
+	}
+	points := make([]point, 0, len(nodes)*clusterVirtualNodes)
+	for _, node := range nodes {
+		for i := 0; i < clusterVirtualNodes; i++ {
+			points = append(points, point{agentSlot(fmt.Sprintf("%s#%d", node, i)), node})
+		}
+	}
+	sort.Slice(points, func(i, j int) bool {
+		return points[i].hash < points[j].hash
+	})
+
+	ring := &hashRing{
+		points: make([]uint32, len(points)),
+		owners: make([]string, len(points)),
+	}
+	for i, p := range points {
+		ring.points[i] = p.hash
+		ring.owners[i] = p.node
+	}
+	return ring
+}
+
+// agentSlot is the position of an agent on the ring, reported by CLUSTER KEYSLOT and MOVED
+func agentSlot(agentID string) uint32 {
+	return crc32.ChecksumIEEE([]byte(agentID))
+}
+
+// nodeFor returns the node owning agentID: the first point at or after its slot
+func (r *hashRing) nodeFor(agentID string) string {
+	slot := agentSlot(agentID)
+	i := sort.Search(len(r.points), func(i int) bool {
+		return r.points[i] >= slot
+	})
+	if i == len(r.points) {
+		i = 0 // Wrap around the ring
+	}
+	return r.owners[i]
+}
+
+// share returns the fraction of the ring each node owns
+func (r *hashRing) share() map[string]float64 {
+	shares := make(map[string]float64)
+	for i, p := range r.points {
+		prev := r.points[(i+len(r.points)-1)%len(r.points)]
+		shares[r.owners[i]] += float64(p-prev) / float64(1<<32)
+	}
+	return shares
+}
+
+// cluster routes agent commands to the node owning the agent
+type cluster struct {
+	nodes    []string
+	self     string // This server's own node address, or "" for a pure front-end
+	redirect bool   // Reply MOVED instead of proxying
+	ring     *hashRing
+	pools    map[string]chan *backendConn
+}
+
+// WithCluster shards agents across nodes (host:port). Commands for agents owned
+// by another node are proxied to it, or answered with -MOVED when redirect is
+// set. self is this server's own entry in nodes; its agents are served
+// locally. Leave it empty to run a front-end that owns no agents.
+func WithCluster(nodes []string, self string, redirect bool) Option {
+	return func(s *RedisServer) {
+		c := &cluster{
+			nodes:    nodes,
+			self:     self,
+			redirect: redirect,
+			ring:     newHashRing(nodes),
+			pools:    make(map[string]chan *backendConn, len(nodes)),
+		}
+		for _, node := range nodes {
+			c.pools[node] = make(chan *backendConn, clusterPoolSize)
+		}
+		s.cluster = c
+	}
+}
+
+// clusterAgents returns the agents a command touches, for routing
+func clusterAgents(cmd []string) []string {
+	switch strings.ToUpper(cmd[0]) {
+	case "DEL":
+		return cmd[1:]
+	case "COPY":
+		if len(cmd) > 2 {
+			return cmd[1:3]
+		}
+	case "OBJECT":
+		if len(cmd) > 2 {
+			return cmd[2:3]
+		}
+	case "DEBUG":
+		if len(cmd) > 2 && strings.EqualFold(cmd[1], "OBJECT") {
+			return cmd[2:3]
+		}
+	}
+	if agentID := commandAgent(cmd); agentID != "" {
+		return []string{agentID}
+	}
+	return nil
+}
+
+// route handles a command in cluster mode. It returns handled=false when the
+// command should run locally: server commands, and agents owned by this node.
+func (s *RedisServer) route(cmd []string, raw [][]byte) (response interface{}, handled bool) {
+	c := s.cluster
+	command := strings.ToUpper(cmd[0])
+
+	if command == "CLUSTER" {
+		return c.processClusterCommand(cmd), true
+	}
+	if command == "FLUSHALL" && c.self == "" && !c.redirect {
+		// A front-end flushes every node; a node only flushes itself
+		return c.broadcast(raw), true
+	}
+
+	agents := clusterAgents(cmd)
+	if len(agents) == 0 {
+		return nil, false
+	}
+
+	node := c.ring.nodeFor(agents[0])
+	for _, agentID := range agents[1:] {
+		if c.ring.nodeFor(agentID) != node {
+			if command == "DEL" && !c.redirect {
+				return c.proxyDel(cmd), true
+			}
+			return &replyError{code: codeCrossSlot, msg: "agents in request don't hash to the same node"}, true
+		}
+	}
+
+	if node == c.self {
+		return nil, false
+	}
+	if c.redirect {
+		return &replyError{code: codeMoved, msg: fmt.Sprintf("%d %s", agentSlot(agents[0]), node)}, true
+	}
+	return c.proxy(node, raw), true
+}
+
+// proxyDel splits a multi-agent DEL by node and sums the replies
+func (c *cluster) proxyDel(cmd []string) interface{} {
+	byNode := make(map[string][][]byte)
+	for _, agentID := range cmd[1:] {
+		node := c.ring.nodeFor(agentID)
+		if len(byNode[node]) == 0 {
+			byNode[node] = [][]byte{[]byte("DEL")}
+		}
+		byNode[node] = append(byNode[node], []byte(agentID))
+	}
+
+	total := 0
+	for node, args := range byNode {
+		reply, ok := c.proxy(node, args).(rawReply)
+		if !ok || len(reply) == 0 || reply[0] != ':' {
+			return c.proxy(node, args)
+		}
+		n, err := strconv.Atoi(strings.TrimSpace(string(reply[1:])))
+		if err != nil {
+			return fmt.Errorf("invalid DEL reply from %s", node)
+		}
+		total += n
+	}
+	return total
+}
+
+// broadcast sends a command to every node, returning the first failure
+func (c *cluster) broadcast(raw [][]byte) interface{} {
+	for _, node := range c.nodes {
+		reply := c.proxy(node, raw)
+		if r, ok := reply.(rawReply); !ok || (len(r) > 0 && r[0] == '-') {
+			return reply
+		}
+	}
+	return "OK"
+}
+
+// backendConn is a pooled connection to a cluster node
+type backendConn struct {
+	conn   net.Conn
+	reader *bufio.Reader
+	writer *bufio.Writer
+}
+
+// proxy forwards a command to node and returns its reply unparsed
+func (c *cluster) proxy(node string, raw [][]byte) interface{} {
+	bc, err := c.getConn(node)
+	if err != nil {
+		return fmt.Errorf("cluster node %s unavailable: %v", node, err)
+	}
+
+	bc.conn.SetDeadline(time.Now().Add(clusterRequestTimeout))
+	writeFrame(bc.writer, raw...)
+	err = bc.writer.Flush()
+
+	var reply []byte
+	if err == nil {
+		reply, err = readRawReply(bc.reader, nil)
+	}
+	if err != nil {
+		bc.conn.Close()
+		return fmt.Errorf("cluster node %s failed: %v", node, err)
+	}
+
+	bc.conn.SetDeadline(time.Time{})
+	c.putConn(node, bc)
+	return rawReply(reply)
+}
+
+func (c *cluster) getConn(node string) (*backendConn, error) {
+	select {
+	case bc := <-c.pools[node]:
+		return bc, nil
+	default:
+	}
+
+	conn, err := net.DialTimeout("tcp", node, clusterRequestTimeout)
+	if err != nil {
+		return nil, err
+	}
+	return &backendConn{
+		conn:   conn,
+		reader: bufio.NewReader(conn),
+		writer: bufio.NewWriter(conn),
+	}, nil
+}
+
+func (c *cluster) putConn(node string, bc *backendConn) {
+	select {
+	case c.pools[node] <- bc:
+	default:
+		bc.conn.Close() // Pool is full
+	}
+}
+
+// rawReply is a complete RESP reply from another server, written back verbatim
+type rawReply []byte
+
+// readRawReply reads one RESP reply, appending its bytes to buf
+func readRawReply(reader *bufio.Reader, buf []byte) ([]byte, error) {
+	line, err := reader.ReadBytes('\n')
+	if err != nil {
+		return nil, err
+	}
+	buf = append(buf, line...)
+
+	if len(line) < 3 {
+		return nil, errors.New("malformed reply")
+	}
+
+	switch line[0] {
+	case '+', '-', ':':
+		return buf, nil
+
+	case '$':
+		length, err := strconv.Atoi(strings.TrimSpace(string(line[1:])))
+		if err != nil {
+			return nil, err
+		}
+		if length < 0 {
+			return buf, nil // Null bulk string
+		}
+		start := len(buf)
+		buf = append(buf, make([]byte, length+2)...)
+		if _, err := io.ReadFull(reader, buf[start:]); err != nil {
+			return nil, err
+		}
+		return buf, nil
+
+	case '*':
+		count, err := strconv.Atoi(strings.TrimSpace(string(line[1:])))
+		if err != nil {
+			return nil, err
+		}
+		for i := 0; i < count; i++ {
+			if buf, err = readRawReply(reader, buf); err != nil {
+				return nil, err
+			}
+		}
+		return buf, nil
+
+	default:
+		return nil, fmt.Errorf("unexpected reply type %q", line[0])
+	}
+}
+
+// processClusterCommand handles CLUSTER NODES | KEYSLOT agent_id
+func (c *cluster) processClusterCommand(cmd []string) interface{} {
+	if len(cmd) < 2 {
+		return errWrongArgs("CLUSTER")
+	}
+
+	switch strings.ToUpper(cmd[1]) {
+	case "NODES":
+		// One line per node: id addr flags ring-share
+		shares := c.ring.share()
+		var sb strings.Builder
+		for _, node := range c.nodes {
+			flags := "node"
+			if node == c.self {
+				flags = "myself"
+			}
+			fmt.Fprintf(&sb, "%08x %s %s %.1f%%\n", agentSlot(node), node, flags, shares[node]*100)
+		}
+		return bulkString(sb.String())
+
+	case "KEYSLOT":
+		if len(cmd) != 3 {
+			return errWrongArgs("CLUSTER|KEYSLOT")
+		}
+		return int64(agentSlot(cmd[2]))
+
+	default:
+		return fmt.Errorf("unknown CLUSTER subcommand: %s", cmd[1])
+	}
+}
@@ -0,0 +1,171 @@
+package redis
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// encode returns a reply as it is sent to clients, so replies proxied
+// verbatim from another node compare equal to local ones
+func encode(t *testing.T, s *RedisServer, reply interface{}) string {
+	t.Helper()
+	var buf bytes.Buffer
+	w := bufio.NewWriter(&buf)
+	if err := s.writeResponse(w, reply); err != nil {
+		t.Fatal(err)
+	}
+	w.Flush()
+	return buf.String()
+}
+
+// startCluster starts three nodes and returns them with their addresses
+func startCluster(t *testing.T) ([]*RedisServer, []string) {
+	t.Helper()
+	var nodes []*RedisServer
+	var addrs []string
+	for range 3 {
+		s, addr := startTestServer(t)
+		nodes = append(nodes, s)
+		addrs = append(addrs, addr)
+	}
+	return nodes, addrs
+}
+
+func TestClusterFrontEnd(t *testing.T) {
+	nodes, addrs := startCluster(t)
+	front := newTestServer(t, WithCluster(addrs, "", false))
+	ring := newHashRing(addrs)
+
+	const agentCount = 30
+	for i := range agentCount {
+		agentID := fmt.Sprintf("agent%d", i)
+		if got := encode(t, front, do(front, "HSET", agentID, "k", "memory of "+agentID)); got != "+OK\r\n" {
+			t.Fatalf("HSET %s through the front-end = %q", agentID, got)
+		}
+	}
+
+	// Each agent lives only on the node the ring assigns it
+	used := make(map[string]bool)
+	for i := range agentCount {
+		agentID := fmt.Sprintf("agent%d", i)
+		owner := ring.nodeFor(agentID)
+		used[owner] = true
+		for n, node := range nodes {
+			want := 0
+			if addrs[n] == owner {
+				want = 1
+			}
+			if exists := do(node, "EXISTS", agentID); exists != want {
+				t.Errorf("EXISTS %s on %s = %v, want %d (owner %s)", agentID, addrs[n], exists, want, owner)
+			}
+		}
+	}
+	if len(used) != 3 {
+		t.Fatalf("%d agents landed on %d of 3 nodes", agentCount, len(used))
+	}
+
+	// Searches through the front-end return what the owning node returns
+	for i := range agentCount {
+		agentID := fmt.Sprintf("agent%d", i)
+		owner := nodes[slices.Index(addrs, ring.nodeFor(agentID))]
+		args := []string{"HSEARCH", agentID, "memory of " + agentID, "0.3", "0.5", "5", "WITHSCORES"}
+		got, want := encode(t, front, do(front, args...)), encode(t, owner, do(owner, args...))
+		if got != want || !strings.Contains(got, "memory of "+agentID) {
+			t.Fatalf("HSEARCH %s through the front-end = %q, owner replies %q", agentID, got, want)
+		}
+	}
+
+	// A DEL spanning nodes is split between them and the counts summed
+	if got := encode(t, front, do(front, "DEL", "agent0", "agent1", "agent2", "agent3", "missing")); got != ":4\r\n" {
+		t.Fatalf("multi-node DEL = %q, want :4", got)
+	}
+
+	info, _ := do(front, "CLUSTER", "NODES").(bulkString)
+	for _, addr := range addrs {
+		if !strings.Contains(string(info), addr+" node ") {
+			t.Errorf("CLUSTER NODES lacks %s:\n%s", addr, info)
+		}
+	}
+}
+
+func TestClusterRedirect(t *testing.T) {
+	_, addrs := startCluster(t)
+	front := newTestServer(t, WithCluster(addrs, "", true))
+
+	reply := do(front, "HSET", "alice", "k", "text")
+	if code := replyCode(reply); code != codeMoved {
+		t.Fatalf("HSET in redirect mode = %v, want %s", reply, codeMoved)
+	}
+	want := fmt.Sprintf("%d %s", agentSlot("alice"), newHashRing(addrs).nodeFor("alice"))
+	if !strings.HasSuffix(toReplyError(reply.(error)).Error(), want) {
+		t.Fatalf("MOVED reply %v, want it to name %s", reply, want)
+	}
+
+	// Agents on different nodes can't share a command when redirecting
+	ring := newHashRing(addrs)
+	other := ""
+	for i := 0; other == ""; i++ {
+		if agentID := fmt.Sprintf("agent%d", i); ring.nodeFor(agentID) != ring.nodeFor("alice") {
+			other = agentID
+		}
+	}
+	if code := replyCode(do(front, "DEL", "alice", other)); code != codeCrossSlot {
+		t.Fatalf("cross-node DEL in redirect mode = %v, want %s", code, codeCrossSlot)
+	}
+}
+
+func TestClusterServesOwnAgentsLocally(t *testing.T) {
+	nodes, addrs := startCluster(t)
+	// This server stands in for the first node; agents it owns stay here
+	self := newTestServer(t, WithCluster(addrs, addrs[0], false))
+	ring := newHashRing(addrs)
+
+	var local, remote string
+	for i := 0; local == "" || remote == ""; i++ {
+		agentID := fmt.Sprintf("agent%d", i)
+		if ring.nodeFor(agentID) == addrs[0] {
+			local = agentID
+		} else {
+			remote = agentID
+		}
+	}
+	mustOK(t, self, "HSET", local, "k", "kept locally")
+	mustOK(t, self, "HSET", remote, "k", "proxied")
+
+	if _, exists, _ := self.agents.Get(context.Background(), local); !exists {
+		t.Fatalf("%s, owned by this node, isn't stored here", local)
+	}
+	if do(nodes[0], "EXISTS", local) != 0 {
+		t.Fatalf("%s was proxied although this node owns it", local)
+	}
+	if _, exists, _ := self.agents.Get(context.Background(), remote); exists {
+		t.Fatalf("%s, owned by another node, is stored here", remote)
+	}
+}
+
+func TestHashRingAddingNodeMovesOnlyToIt(t *testing.T) {
+	before := newHashRing([]string{"a:1", "b:1", "c:1"})
+	after := newHashRing([]string{"a:1", "b:1", "c:1", "d:1"})
+
+	moved := 0
+	const agentCount = 10000
+	for i := range agentCount {
+		agentID := fmt.Sprintf("agent%d", i)
+		was, is := before.nodeFor(agentID), after.nodeFor(agentID)
+		if was != is {
+			if is != "d:1" {
+				t.Fatalf("%s moved from %s to %s, not to the new node", agentID, was, is)
+			}
+			moved++
+		}
+	}
+	// The new node takes about a quarter of the agents
+	if moved < agentCount/8 || moved > agentCount*3/8 {
+		t.Fatalf("%d of %d agents moved to the new node", moved, agentCount)
+	}
+}
@@ -0,0 +1,145 @@
+package redis
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// connInfo is the per-connection state tracked for CLIENT LIST / CLIENT KILL
+type connInfo struct {
+	id      int64
+	addr    string
+	conn    net.Conn
+	created time.Time
+
+	replication bool // The stream from our primary; exempt from limits and read-only mode
+
+	mu         sync.Mutex // Guards the fields below, read by CLIENT LIST from other connections
+	name       string
+	lastCmd    string
+	lastActive time.Time
+}
+
+func (ci *connInfo) setName(name string) {
+	ci.mu.Lock()
+	defer ci.mu.Unlock()
+	ci.name = name
+}
+
+func (ci *connInfo) getName() string {
+	ci.mu.Lock()
+	defer ci.mu.Unlock()
+	return ci.name
+}
+
+// maxClientNameLen bounds CLIENT SETNAME names
+const maxClientNameLen = 64
+
+// validateClientName rejects names that would break the space-separated
+// CLIENT LIST format. An empty name clears it, as in Redis.
+func validateClientName(name string) error {
+	if len(name) > maxClientNameLen {
+		return fmt.Errorf("client name is longer than %d characters", maxClientNameLen)
+	}
+	for i := 0; i < len(name); i++ {
+		if name[i] < '!' || name[i] > '~' {
+			return fmt.Errorf("client names cannot contain spaces, newlines or special characters")
+		}
+	}
+	return nil
+}
+
+// touch records the command currently being executed
+func (ci *connInfo) touch(command string) {
+	ci.mu.Lock()
+	defer ci.mu.Unlock()
+	ci.lastCmd = strings.ToLower(command)
+	ci.lastActive = time.Now()
+}
+
+// String formats the connection in the space-separated CLIENT LIST style
+func (ci *connInfo) String() string {
+	ci.mu.Lock()
+	defer ci.mu.Unlock()
+
+	now := time.Now()
+	return fmt.Sprintf("id=%d addr=%s name=%s age=%d idle=%d cmd=%s",
+		ci.id,
+		ci.addr,
+		ci.name,
+		int64(now.Sub(ci.created).Seconds()),
+		int64(now.Sub(ci.lastActive).Seconds()),
+		ci.lastCmd)
+}
+
+// connRegistry tracks live connections
+type connRegistry struct {
+	mu     sync.Mutex
+	conns  map[int64]*connInfo
+	nextID int64
+}
+
+func newConnRegistry() *connRegistry {
+	return &connRegistry{
+		conns: make(map[int64]*connInfo),
+	}
+}
+
+func (r *connRegistry) register(conn net.Conn) *connInfo {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.nextID++
+	now := time.Now()
+	ci := &connInfo{
+		id:         r.nextID,
+		addr:       conn.RemoteAddr().String(),
+		conn:       conn,
+		created:    now,
+		lastActive: now,
+		lastCmd:    "NULL",
+	}
+	r.conns[ci.id] = ci
+	return ci
+}
+
+func (r *connRegistry) unregister(ci *connInfo) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.conns, ci.id)
+}
+
+// list returns all connections ordered by ID
+func (r *connRegistry) list() []*connInfo {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	conns := make([]*connInfo, 0, len(r.conns))
+	for _, ci := range r.conns {
+		conns = append(conns, ci)
+	}
+	sort.Slice(conns, func(i, j int) bool {
+		return conns[i].id < conns[j].id
+	})
+	return conns
+}
+
+// kill closes every connection matching the filter and returns how many were closed.
+// The owning handler goroutine sees the closed conn on its next read or write and exits.
+func (r *connRegistry) kill(match func(ci *connInfo) bool) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	killed := 0
+	for _, ci := range r.conns {
+		if match(ci) {
+			ci.conn.Close()
+			killed++
+		}
+	}
+	return killed
+}
@@ -0,0 +1,105 @@
+package redis
+
+import (
+	"Hippocampus/src/client"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxDebugSleep bounds DEBUG SLEEP so a typo can't park a connection for days
+const maxDebugSleep = time.Hour
+
+// WithDebugCommands enables DEBUG SLEEP | OBJECT | RELOAD. They are meant for
+// testing clients and stay off in production.
+func WithDebugCommands(enabled bool) Option {
+	return func(s *RedisServer) {
+		s.enableDebug = enabled
+	}
+}
+
+// processDebugCommand handles DEBUG SLEEP seconds | OBJECT agent_id | RELOAD
+func (s *RedisServer) processDebugCommand(cmd []string) interface{} {
+	if !s.enableDebug {
+		return fmt.Errorf("DEBUG is disabled (start the server with -enable-debug-commands)")
+	}
+	if len(cmd) < 2 {
+		return errWrongArgs("DEBUG")
+	}
+
+	switch strings.ToUpper(cmd[1]) {
+	case "SLEEP":
+		if len(cmd) != 3 {
+			return errWrongArgs("DEBUG|SLEEP")
+		}
+		seconds, err := strconv.ParseFloat(cmd[2], 64)
+		if err != nil || seconds < 0 {
+			return fmt.Errorf("invalid sleep duration: %s", cmd[2])
+		}
+		d := time.Duration(seconds * float64(time.Second))
+		if d > maxDebugSleep {
+			return fmt.Errorf("sleep duration is longer than %s", maxDebugSleep)
+		}
+		time.Sleep(d)
+		return "OK"
+
+	case "OBJECT":
+		if len(cmd) != 3 {
+			return errWrongArgs("DEBUG|OBJECT")
+		}
+		return s.debugObject(cmd[2])
+
+	case "RELOAD":
+		if len(cmd) != 2 {
+			return errWrongArgs("DEBUG|RELOAD")
+		}
+		var err error
+		s.agents.Range(func(agentID string, c *client.Client) bool {
+			if reloadErr := c.Reload(); reloadErr != nil {
+				err = fmt.Errorf("failed to reload agent %s: %w", agentID, reloadErr)
+				return false
+			}
+			return true
+		})
+		if err != nil {
+			return err
+		}
+		return "OK"
+
+	default:
+		return fmt.Errorf("unknown DEBUG subcommand: %s", cmd[1])
+	}
+}
+
+// debugObject describes an agent in the style of Redis DEBUG OBJECT
+func (s *RedisServer) debugObject(agentID string) interface{} {
+	c, exists, err := s.getClient(agentID)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return fmt.Errorf("no such key")
+	}
+
+	count, err := c.Count()
+	if err != nil {
+		return err
+	}
+	usage, err := c.MemoryUsage()
+	if err != nil {
+		return err
+	}
+
+	encoding := "empty"
+	if count > 0 {
+		encoding = "flat-tree"
+	}
+	var modified int64
+	if t := c.LastModified(); !t.IsZero() {
+		modified = t.Unix()
+	}
+
+	return fmt.Sprintf("Value at:%p refcount:1 encoding:%s nodes:%d memory:%d lru_seconds_idle:%d last_modified:%d",
+		c, encoding, count, usage, int64(s.access.idle(agentID).Seconds()), modified)
+}
@@ -0,0 +1,236 @@
+package redis
+
+import (
+	"Hippocampus/src/agents"
+	"Hippocampus/src/client"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// defaultMaxDumpSize caps HDUMP replies and HRESTORE payloads. The whole blob
+// is held in memory as a single bulk string, so very large agents should be
+// moved with file snapshots instead.
+const defaultMaxDumpSize = 256 << 20
+
+// gzipMagic prefixes compressed HDUMP payloads; HRESTORE detects it automatically
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// Agents with an HCONFIG profile are dumped as an envelope: dumpMagic, a
+// uint32 version, a uint32 length and the profile as JSON, then the tree.
+// Agents without one dump as the bare tree, as before profiles existed.
+const (
+	dumpMagic   = "HDMP"
+	dumpVersion = 1
+
+	// maxProfileSize bounds the profile's declared length, which is read
+	// from the payload before anything is allocated for it
+	maxProfileSize = 64 << 10
+)
+
+func writeDumpHeader(w io.Writer, profile SearchProfile) error {
+	data, err := json.Marshal(profile)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.WriteString(w, dumpMagic); err != nil {
+		return err
+	}
+	if err := binary.Write(w, binary.LittleEndian, uint32(dumpVersion)); err != nil {
+		return err
+	}
+	if err := binary.Write(w, binary.LittleEndian, uint32(len(data))); err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+// readDumpHeader consumes the envelope if present, returning the profile it
+// carried or nil for a bare tree dump
+func readDumpHeader(r *bufio.Reader) (*SearchProfile, error) {
+	magic, err := r.Peek(len(dumpMagic))
+	if err != nil || string(magic) != dumpMagic {
+		// Too short or a bare tree; let the tree reader report problems
+		return nil, nil
+	}
+	r.Discard(len(dumpMagic))
+
+	var version, length uint32
+	if err := binary.Read(r, binary.LittleEndian, &version); err != nil {
+		return nil, err
+	}
+	if version != dumpVersion {
+		return nil, fmt.Errorf("unsupported dump version %d", version)
+	}
+	if err := binary.Read(r, binary.LittleEndian, &length); err != nil {
+		return nil, err
+	}
+	if length > maxProfileSize {
+		return nil, fmt.Errorf("search profile is %d bytes, over the %d byte limit", length, maxProfileSize)
+	}
+
+	data := make([]byte, length)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, err
+	}
+
+	var profile SearchProfile
+	if err := json.Unmarshal(data, &profile); err != nil {
+		return nil, fmt.Errorf("invalid search profile: %v", err)
+	}
+	if err := profile.validate(); err != nil {
+		return nil, fmt.Errorf("invalid search profile: %v", err)
+	}
+	return &profile, nil
+}
+
+// processHDump handles HDUMP agent_id [COMPRESS]
+func (s *RedisServer) processHDump(cmd []string) interface{} {
+	if len(cmd) < 2 {
+		return errWrongArgs("HDUMP")
+	}
+
+	agentID := cmd[1]
+	compress := len(cmd) > 2 && strings.EqualFold(cmd[2], "COMPRESS")
+
+	c, exists, err := s.getClient(agentID)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return nil
+	}
+
+	dump, err := s.dumpAgent(agentID, c, compress)
+	if err != nil {
+		return err
+	}
+
+	if int64(len(dump)) > s.maxDumpSize {
+		return fmt.Errorf("HDUMP of %s is %d bytes, over the %d byte limit", agentID, len(dump), s.maxDumpSize)
+	}
+
+	return bulkString(dump)
+}
+
+// dumpAgent serializes an agent's tree, and its search profile if it has one,
+// in the format HRESTORE accepts
+func (s *RedisServer) dumpAgent(agentID string, c *client.Client, compress bool) ([]byte, error) {
+	var buf bytes.Buffer
+	var w io.Writer = &buf
+	var zw *gzip.Writer
+	if compress {
+		zw = gzip.NewWriter(&buf)
+		w = zw
+	}
+
+	if profile, ok := s.profiles.get(agentID); ok {
+		if err := writeDumpHeader(w, profile); err != nil {
+			return nil, err
+		}
+	}
+	if err := c.Snapshot(w); err != nil {
+		return nil, err
+	}
+	if zw != nil {
+		if err := zw.Close(); err != nil {
+			return nil, err
+		}
+	}
+
+	return buf.Bytes(), nil
+}
+
+// processHRestore handles HRESTORE agent_id blob [REPLACE]. The blob is read
+// from raw so a large payload isn't copied into a string first.
+func (s *RedisServer) processHRestore(cmd []string, raw [][]byte) interface{} {
+	if len(cmd) < 3 {
+		return errWrongArgs("HRESTORE")
+	}
+
+	agentID := cmd[1]
+	blob := raw[2]
+	replace := len(cmd) > 3 && strings.EqualFold(cmd[3], "REPLACE")
+
+	if int64(len(blob)) > s.maxDumpSize {
+		return fmt.Errorf("HRESTORE payload is %d bytes, over the %d byte limit", len(blob), s.maxDumpSize)
+	}
+
+	var r io.Reader = bytes.NewReader(blob)
+	if bytes.HasPrefix(blob, gzipMagic) {
+		zr, err := gzip.NewReader(r)
+		if err != nil {
+			return fmt.Errorf("invalid compressed payload: %v", err)
+		}
+		defer zr.Close()
+		// A small payload may inflate without bound, so the limit applies
+		// to what it decompresses to as well
+		r = &cappedReader{r: zr, remaining: s.maxDumpSize, limit: s.maxDumpSize}
+	}
+
+	// Other commands on the agent get -LOADING until the restore finishes
+	if !s.startLoading(agentID) {
+		return errLoading(agentID)
+	}
+	defer s.finishLoading(agentID)
+
+	// Decode before touching the client map so a bad payload changes nothing
+	c, err := s.newClient(agentID)
+	if err != nil {
+		return err
+	}
+	br := bufio.NewReader(r)
+	profile, err := readDumpHeader(br)
+	if err != nil {
+		return fmt.Errorf("invalid HRESTORE payload: %v", err)
+	}
+	if err := c.Restore(br); err != nil {
+		return fmt.Errorf("invalid HRESTORE payload: %v", err)
+	}
+
+	// Only once any old agent is gone may a file-backed restore be written
+	if err := s.agents.Install(agentID, c, replace, c.Flush); err != nil {
+		if errors.Is(err, agents.ErrAgentExists) {
+			return &replyError{code: codeBusyKey, msg: fmt.Sprintf("agent %s already exists (use REPLACE)", agentID)}
+		}
+		return err
+	}
+	if profile != nil {
+		if err := s.profiles.set(agentID, *profile); err != nil {
+			return err
+		}
+	}
+	return "OK"
+}
+
+// cappedReader reads r, failing once more than limit bytes come out of it
+type cappedReader struct {
+	r         io.Reader
+	remaining int64
+	limit     int64
+}
+
+func (c *cappedReader) Read(p []byte) (int, error) {
+	if c.remaining <= 0 {
+		// Exactly limit bytes is fine, so only fail if there are more
+		var probe [1]byte
+		if n, err := c.r.Read(probe[:]); n == 0 {
+			return 0, err
+		}
+		return 0, fmt.Errorf("decompressed payload is over the %d byte limit", c.limit)
+	}
+	if int64(len(p)) > c.remaining {
+		p = p[:c.remaining]
+	}
+	n, err := c.r.Read(p)
+	c.remaining -= int64(n)
+	return n, err
+}
@@ -0,0 +1,139 @@
+package redis
+
+import (
+	"Hippocampus/src/storage"
+	"Hippocampus/src/types"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// dumpEnvelope builds the profile envelope HDUMP writes, declaring length
+// bytes of profile but holding body
+func dumpEnvelope(length uint32, body string) []byte {
+	var buf bytes.Buffer
+	buf.WriteString(dumpMagic)
+	binary.Write(&buf, binary.LittleEndian, uint32(dumpVersion))
+	binary.Write(&buf, binary.LittleEndian, length)
+	buf.WriteString(body)
+	return buf.Bytes()
+}
+
+func TestReadDumpHeaderRejectsHugeProfile(t *testing.T) {
+	// Declaring 4GB must fail before anything that size is allocated
+	r := bufio.NewReader(bytes.NewReader(dumpEnvelope(0xFFFFFFFF, "")))
+	if _, err := readDumpHeader(r); err == nil || !strings.Contains(err.Error(), "limit") {
+		t.Fatalf("err = %v, want the size limit", err)
+	}
+}
+
+func TestReadDumpHeaderValidatesProfile(t *testing.T) {
+	for _, profile := range []string{
+		`{"epsilon":-1,"threshold":0.5,"top_k":5}`,
+		`{"epsilon":0.3,"threshold":2,"top_k":5}`,
+		`{"epsilon":0.3,"threshold":0.5,"top_k":-1}`,
+	} {
+		r := bufio.NewReader(bytes.NewReader(dumpEnvelope(uint32(len(profile)), profile)))
+		if _, err := readDumpHeader(r); err == nil {
+			t.Errorf("profile %s accepted", profile)
+		}
+	}
+
+	profile := `{"epsilon":0.2,"threshold":0.7,"top_k":3}`
+	got, err := readDumpHeader(bufio.NewReader(bytes.NewReader(dumpEnvelope(uint32(len(profile)), profile))))
+	if err != nil || *got != (SearchProfile{Epsilon: 0.2, Threshold: 0.7, TopK: 3}) {
+		t.Fatalf("readDumpHeader = %+v, %v", got, err)
+	}
+}
+
+func TestHRestoreCapsDecompressedSize(t *testing.T) {
+	const limit = 1 << 20
+	s := newTestServer(t, WithMaxDumpSize(limit))
+
+	// A valid tree of 4MB of identical embeddings compresses to well
+	// under the limit
+	tree := types.NewTree()
+	for i := 0; i < 2000; i++ {
+		tree.InsertNode(types.Node{ID: fmt.Sprintf("k%d", i)})
+	}
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if err := storage.WriteTree(zw, tree); err != nil {
+		t.Fatal(err)
+	}
+	zw.Close()
+	if buf.Len() >= limit {
+		t.Fatalf("compressed payload is %d bytes, want it under the limit", buf.Len())
+	}
+
+	reply := do(s, "HRESTORE", "bomb", buf.String())
+	err, ok := reply.(error)
+	if !ok || !strings.Contains(err.Error(), "limit") {
+		t.Fatalf("HRESTORE = %v, want the decompressed size limit", reply)
+	}
+	if exists := do(s, "EXISTS", "bomb"); exists != 0 {
+		t.Fatal("a refused payload created the agent")
+	}
+}
+
+func TestHDumpHRestoreAcrossServers(t *testing.T) {
+	tests := []struct {
+		name     string
+		compress bool
+		profile  bool
+	}{
+		{"plain", false, false},
+		{"gzip", true, false},
+		{"profile", false, true},
+		{"gzip with profile", true, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src, dst := newTestServer(t), newTestServer(t)
+			mustOK(t, src, "HSET", "alice", "k1", "the cat sat on the mat")
+			mustOK(t, src, "HSET", "alice", "k2", "dogs bark at night")
+			if tt.profile {
+				mustOK(t, src, "HCONFIG", "alice", "SET", "epsilon", "0.2", "threshold", "0.7", "topk", "3")
+			}
+
+			args := []string{"HDUMP", "alice"}
+			if tt.compress {
+				args = append(args, "COMPRESS")
+			}
+			dump, ok := mustOK(t, src, args...).(bulkString)
+			if !ok {
+				t.Fatalf("HDUMP replied %T", dump)
+			}
+			payload := []byte(dump)
+			if got := bytes.HasPrefix(payload, gzipMagic); got != tt.compress {
+				t.Fatalf("payload gzipped = %t, want %t", got, tt.compress)
+			}
+			if !tt.compress {
+				if got := bytes.HasPrefix(payload, []byte(dumpMagic)); got != tt.profile {
+					t.Fatalf("payload has a profile envelope = %t, want %t", got, tt.profile)
+				}
+			}
+
+			mustOK(t, dst, "HRESTORE", "bob", string(dump))
+			keys := mustOK(t, dst, "HKEYS", "bob").([]string)
+			if !slices.Equal(keys, []string{"k1", "k2"}) && !slices.Equal(keys, []string{"k2", "k1"}) {
+				t.Fatalf("restored keys %q", keys)
+			}
+			want := mustOK(t, src, "HCONFIG", "alice", "GET")
+			if got := mustOK(t, dst, "HCONFIG", "bob", "GET"); !slices.Equal(got.([]string), want.([]string)) {
+				t.Fatalf("restored profile %q, want %q", got, want)
+			}
+
+			// Restoring over the agent needs REPLACE
+			if reply := do(dst, "HRESTORE", "bob", string(dump)); asError(reply) == nil || !strings.HasPrefix(asError(reply).Error(), codeBusyKey) {
+				t.Fatalf("HRESTORE over an agent = %v, want %s", reply, codeBusyKey)
+			}
+			mustOK(t, dst, "HRESTORE", "bob", string(dump), "REPLACE")
+		})
+	}
+}
@@ -0,0 +1,73 @@
+package redis
+
+import (
+	"Hippocampus/src/client"
+	"Hippocampus/src/embedding"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Error codes sent as the first word of an error reply, so clients can branch
+// on the failure without matching message text
+const (
+	codeErr       = "ERR"       // Generic failure, bad arguments, unknown command
+	codeWrongType = "WRONGTYPE" // A value has the wrong type, e.g. a JSON field
+	codeEmbedFail = "EMBEDFAIL" // The embedding service failed or is unreachable
+	codeLoading   = "LOADING"   // The agent is still being loaded
+	codeBusyKey   = "BUSYKEY"   // The agent already exists, or is rate limited
+	codeOOM       = "OOM"       // The agent's memory quota is full
+	codeReadOnly  = "READONLY"  // A write was sent to a replica
+	codeBusy      = "BUSY"      // The embedding queue is full; retry later
+	codeMoved     = "MOVED"     // The agent lives on another cluster node
+	codeCrossSlot = "CROSSSLOT" // A command spans agents on different cluster nodes
+)
+
+// replyError is an error reply carrying a Redis error code other than the
+// generic ERR, e.g. "-OOM max memories reached"
+type replyError struct {
+	code string
+	msg  string
+}
+
+func (e *replyError) Error() string {
+	return e.code + " " + e.msg
+}
+
+// errWrongArgs uses Redis's phrasing, e.g. "wrong number of arguments for 'hset' command"
+func errWrongArgs(command string) error {
+	return &replyError{code: codeErr, msg: fmt.Sprintf("wrong number of arguments for '%s' command", strings.ToLower(command))}
+}
+
+func errUnknownCommand(command string) error {
+	return &replyError{code: codeErr, msg: fmt.Sprintf("unknown command '%s'", command)}
+}
+
+func errLoading(agentID string) error {
+	return &replyError{code: codeLoading, msg: fmt.Sprintf("agent %s is being loaded", agentID)}
+}
+
+// toReplyError picks the error code for an error returned by a command.
+// Errors that aren't recognised get the generic ERR code.
+func toReplyError(err error) *replyError {
+	var re *replyError
+	if errors.As(err, &re) {
+		return re
+	}
+
+	if errors.Is(err, embedding.ErrQueueFull) {
+		return &replyError{code: codeBusy, msg: embedding.ErrQueueFull.Error()}
+	}
+
+	if errors.Is(err, client.ErrEmbedding) {
+		return &replyError{code: codeEmbedFail, msg: err.Error()}
+	}
+
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) {
+		return &replyError{code: codeWrongType, msg: err.Error()}
+	}
+
+	return &replyError{code: codeErr, msg: err.Error()}
+}
@@ -0,0 +1,87 @@
+package redis
+
+import (
+	"Hippocampus/src/client"
+	"Hippocampus/src/embedding"
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+// wireReply runs a command and returns its reply as written to the client
+func wireReply(t *testing.T, s *RedisServer, args ...string) string {
+	t.Helper()
+	var buf bytes.Buffer
+	w := bufio.NewWriter(&buf)
+	if err := s.writeResponse(w, do(s, args...)); err != nil {
+		t.Fatal(err)
+	}
+	w.Flush()
+	return buf.String()
+}
+
+func TestErrorReplyCodes(t *testing.T) {
+	embedder := embedding.NewMockEmbedder()
+	embedder.SetError("unembeddable", errors.New("service down"))
+	s := NewRedisServer("", embedder, time.Minute, WithAgentLimits(AgentLimits{MaxNodes: 1}))
+	t.Cleanup(func() { s.Stop() })
+
+	mustOK(t, s, "HSET", "full", "k1", "first memory")
+	mustOK(t, s, "HSET", "restored", "k1", "memory")
+	dump := string(mustOK(t, s, "HDUMP", "restored").(bulkString))
+	if !s.startLoading("loading") {
+		t.Fatal("startLoading failed")
+	}
+	t.Cleanup(func() { s.finishLoading("loading") })
+
+	tests := []struct {
+		name string
+		args []string
+		want string // The whole first line of the reply
+	}{
+		{"wrong number of arguments", []string{"HSET", "a"}, "-ERR wrong number of arguments for 'hset' command"},
+		{"unknown command", []string{"NOPE"}, "-ERR unknown command 'NOPE'"},
+		{"wrong JSON type", []string{"HGET", "full", `{"query": 5}`}, "-WRONGTYPE "},
+		{"embedding failure", []string{"HSET", "a", "k", "unembeddable"}, "-EMBEDFAIL "},
+		{"agent loading", []string{"HSET", "loading", "k", "text"}, "-LOADING agent loading is being loaded"},
+		{"agent exists", []string{"HRESTORE", "restored", dump}, "-BUSYKEY "},
+		{"memory quota", []string{"HSET", "full", "k2", "second memory"}, "-OOM "},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reply := wireReply(t, s, tt.args...)
+			line, _, _ := strings.Cut(reply, "\r\n")
+			if !strings.HasPrefix(line, tt.want) {
+				t.Fatalf("reply %q, want it to start with %q", line, tt.want)
+			}
+		})
+	}
+}
+
+func TestToReplyError(t *testing.T) {
+	var typeErr error = &json.UnmarshalTypeError{Value: "number", Field: "query"}
+	tests := []struct {
+		name string
+		err  error
+		want string
+	}{
+		{"plain error", errors.New("boom"), codeErr},
+		{"reply error", &replyError{code: codeOOM, msg: "full"}, codeOOM},
+		{"wrapped reply error", fmt.Errorf("agent a: %w", errLoading("a")), codeLoading},
+		{"embedding queue full", fmt.Errorf("insert: %w", embedding.ErrQueueFull), codeBusy},
+		{"embedding failure", fmt.Errorf("%w: timeout", client.ErrEmbedding), codeEmbedFail},
+		{"JSON type mismatch", fmt.Errorf("invalid query: %w", typeErr), codeWrongType},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if code := replyCode(tt.err); code != tt.want {
+				t.Fatalf("code %s, want %s", code, tt.want)
+			}
+		})
+	}
+}
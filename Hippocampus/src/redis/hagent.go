@@ -0,0 +1,284 @@
+package redis
+
+import (
+	"Hippocampus/src/client"
+	"Hippocampus/src/embedding"
+	"Hippocampus/src/storage"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// EmbedderFactory builds the embedder for an embed-url set with HAGENT SET
+type EmbedderFactory func(url string) (embedding.EmbeddingService, error)
+
+// WithEmbedderFactory sets how HAGENT SET builds an agent's embedder from its
+// embed-url, e.g. the server's embedder kind and model at another URL.
+// Without it, embed-url names a local embedding service (see
+// embedding.LocalEmbedder).
+func WithEmbedderFactory(f EmbedderFactory) Option {
+	return func(s *RedisServer) {
+		s.embedderFactory = f
+	}
+}
+
+// agentEmbedder is an embedder set for one agent with HAGENT SET
+type agentEmbedder struct {
+	url      string
+	embedder embedding.EmbeddingService
+}
+
+// embedderStore holds the agents whose embedder differs from the server's
+type embedderStore struct {
+	mu        sync.Mutex
+	embedders map[string]agentEmbedder
+}
+
+func newEmbedderStore() *embedderStore {
+	return &embedderStore{
+		embedders: make(map[string]agentEmbedder),
+	}
+}
+
+func (es *embedderStore) get(agentID string) (agentEmbedder, bool) {
+	es.mu.Lock()
+	defer es.mu.Unlock()
+	ae, ok := es.embedders[agentID]
+	return ae, ok
+}
+
+func (es *embedderStore) set(agentID string, ae agentEmbedder) {
+	es.mu.Lock()
+	defer es.mu.Unlock()
+	es.embedders[agentID] = ae
+}
+
+func (es *embedderStore) forget(agentID string) {
+	es.mu.Lock()
+	defer es.mu.Unlock()
+	delete(es.embedders, agentID)
+}
+
+// newAgentEmbedder builds the embedder for url, sharing the server's
+// embedding concurrency limit when one is set
+func (s *RedisServer) newAgentEmbedder(url string) (embedding.EmbeddingService, error) {
+	var e embedding.EmbeddingService
+	if s.embedderFactory != nil {
+		var err error
+		if e, err = s.embedderFactory(url); err != nil {
+			return nil, err
+		}
+	} else {
+		e = embedding.NewLocalEmbedder(url)
+	}
+
+	if s.embedLimit != nil {
+		e = s.embedLimit.Through(e)
+	}
+	return e, nil
+}
+
+// processHAgent handles HAGENT GET agent_id embed-url,
+// HAGENT SET agent_id embed-url <url> | default, HAGENT ATTACH agent_id path
+// and HAGENT DETACH agent_id
+func (s *RedisServer) processHAgent(cmd []string) interface{} {
+	if len(cmd) > 1 {
+		switch strings.ToUpper(cmd[1]) {
+		case "ATTACH":
+			return s.processAttach(cmd)
+		case "DETACH":
+			return s.processDetach(cmd)
+		}
+	}
+
+	if len(cmd) < 4 {
+		return errWrongArgs("HAGENT")
+	}
+
+	agentID := cmd[2]
+	if !strings.EqualFold(cmd[3], "embed-url") {
+		return fmt.Errorf("unknown HAGENT parameter: %s", cmd[3])
+	}
+
+	switch strings.ToUpper(cmd[1]) {
+	case "GET":
+		if ae, ok := s.agentEmbedders.get(agentID); ok {
+			return []string{"embed-url", ae.url}
+		}
+		return []string{"embed-url", "default"}
+
+	case "SET":
+		if len(cmd) != 5 {
+			return errWrongArgs("HAGENT|SET")
+		}
+
+		// "default" goes back to the server's embedder
+		url := cmd[4]
+		e := s.embedder
+		if !strings.EqualFold(url, "default") {
+			var err error
+			if e, err = s.newAgentEmbedder(url); err != nil {
+				return err
+			}
+		}
+
+		c, err := s.getOrCreateClient(agentID)
+		if err != nil {
+			return err
+		}
+		if err := c.SetEmbedder(e); err != nil {
+			return err
+		}
+
+		if e == s.embedder {
+			s.agentEmbedders.forget(agentID)
+		} else {
+			s.agentEmbedders.set(agentID, agentEmbedder{url: url, embedder: e})
+		}
+		return "OK"
+
+	default:
+		return fmt.Errorf("unknown HAGENT subcommand: %s", cmd[1])
+	}
+}
+
+// WithAttachDir lets HAGENT ATTACH store agents in files under dir. Without
+// it HAGENT ATTACH is refused, since it writes files where clients say.
+func WithAttachDir(dir string) Option {
+	return func(s *RedisServer) {
+		s.attachDir = dir
+	}
+}
+
+// attachPath resolves a HAGENT ATTACH path against the attach directory,
+// refusing one outside it
+func (s *RedisServer) attachPath(path string) (string, error) {
+	if s.attachDir == "" {
+		return "", fmt.Errorf("HAGENT ATTACH is disabled (start the server with -attach-dir)")
+	}
+
+	dir, err := filepath.Abs(s.attachDir)
+	if err != nil {
+		return "", err
+	}
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(dir, path)
+	}
+	path = filepath.Clean(path)
+	if rel, err := filepath.Rel(dir, path); err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("path %s is outside the attach directory %s", path, s.attachDir)
+	}
+	return path, nil
+}
+
+// processAttach handles HAGENT ATTACH agent_id path: the agent's memories
+// move to a file, saved there from now on. If the agent has none, the
+// file's are loaded instead.
+func (s *RedisServer) processAttach(cmd []string) interface{} {
+	if len(cmd) != 4 {
+		return errWrongArgs("HAGENT|ATTACH")
+	}
+	if s.dataDir != "" {
+		return fmt.Errorf("agents are already stored in files under -data-dir")
+	}
+	agentID := cmd[2]
+	path, err := s.attachPath(cmd[3])
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	c, err := s.getOrCreateClient(agentID)
+	if err != nil {
+		return err
+	}
+
+	// Under the agents lock, so no command sees the storage change mid-way
+	loaded, err := s.agents.Update(agentID, func(current *client.Client) error {
+		if current != c {
+			return fmt.Errorf("agent %s was deleted", agentID)
+		}
+		if owner, ok := s.attached[path]; ok {
+			if owner != agentID {
+				return fmt.Errorf("%s is attached to agent %s", path, owner)
+			}
+			return nil
+		}
+
+		if err := c.MigrateStorage(storage.NewFileStorage(path)); err != nil {
+			if errors.Is(err, client.ErrStorageNotEmpty) {
+				return fmt.Errorf("%s and agent %s both hold memories (HCLEAR the agent or remove the file first)", path, agentID)
+			}
+			return err
+		}
+
+		s.forgetAttached(agentID)
+		if s.attached == nil {
+			s.attached = make(map[string]string)
+		}
+		s.attached[path] = agentID
+		log.Printf("Agent %s attached to %s", agentID, path)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if !loaded {
+		return fmt.Errorf("agent %s was deleted", agentID)
+	}
+	return "OK"
+}
+
+// processDetach handles HAGENT DETACH agent_id: an attached agent is saved
+// to its file one last time and kept in memory from then on. The file stays,
+// to be attached again.
+func (s *RedisServer) processDetach(cmd []string) interface{} {
+	if len(cmd) != 3 {
+		return errWrongArgs("HAGENT|DETACH")
+	}
+	if s.dataDir != "" {
+		return fmt.Errorf("agents are stored in files under -data-dir and can't be detached")
+	}
+	agentID := cmd[2]
+
+	loaded, err := s.agents.Update(agentID, func(c *client.Client) error {
+		fs, ok := c.CurrentStorage().(*storage.FileStorage)
+		if !ok {
+			return fmt.Errorf("agent %s is not attached to a file", agentID)
+		}
+
+		if err := c.Flush(); err != nil {
+			return err
+		}
+		if err := c.MigrateStorage(storage.NewMemoryStorageWithTTL(s.ttl)); err != nil {
+			return err
+		}
+
+		s.forgetAttached(agentID)
+		log.Printf("Agent %s detached from %s", agentID, fs.Path())
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if !loaded {
+		return fmt.Errorf("agent %s does not exist", agentID)
+	}
+	return "OK"
+}
+
+// forgetAttached drops the file attached to an agent. It runs under the
+// agents lock, inside Manager.Update or the manager's drop hook.
+func (s *RedisServer) forgetAttached(agentID string) {
+	for path, owner := range s.attached {
+		if owner == agentID {
+			delete(s.attached, path)
+		}
+	}
+}
@@ -0,0 +1,203 @@
+package redis
+
+import (
+	"Hippocampus/src/embedding"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// healthState groups the RedisServer fields for the health listener
+type healthState struct {
+	healthAddr    string // Optional, set by WithHealthAddr
+	healthServer  *http.Server
+	shutdownDelay time.Duration // Set by WithShutdownDelay
+	draining      atomic.Bool   // Set once Stop starts; /readyz fails from then on
+
+	embedCheckMu sync.Mutex
+	embedChecked time.Time
+	embedErr     error
+}
+
+const (
+	// embedCheckInterval is how long /readyz reuses the result of pinging
+	// the embedder, so frequent probes don't each pay for an embedding
+	embedCheckInterval = 10 * time.Second
+
+	// embedCheckTimeout bounds one embedder ping
+	embedCheckTimeout = 5 * time.Second
+
+	// livenessTimeout is how long /livez waits for the agent manager's lock
+	livenessTimeout = 5 * time.Second
+)
+
+// WithHealthAddr serves /healthz, /livez and /readyz over HTTP on addr, for
+// container orchestrators and load balancers to probe
+func WithHealthAddr(addr string) Option {
+	return func(s *RedisServer) {
+		s.healthAddr = addr
+	}
+}
+
+// WithShutdownDelay makes Stop keep serving for d with /readyz already
+// failing, so load balancers stop sending clients before the listeners close
+func WithShutdownDelay(d time.Duration) Option {
+	return func(s *RedisServer) {
+		s.shutdownDelay = d
+	}
+}
+
+// startHealth listens on the health address, if one is set. It runs before
+// the Redis listeners open so that a slow startup, such as loading saved
+// agents, is reported as alive but not ready.
+func (s *RedisServer) startHealth() error {
+	if s.healthAddr == "" {
+		return nil
+	}
+	listener, err := net.Listen("tcp", s.healthAddr)
+	if err != nil {
+		return fmt.Errorf("failed to start health listener: %w", err)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /healthz", s.healthz)
+	mux.HandleFunc("GET /livez", s.livez)
+	mux.HandleFunc("GET /readyz", s.readyz)
+
+	s.listenersMu.Lock()
+	s.healthServer = &http.Server{Handler: mux, ReadHeaderTimeout: 5 * time.Second}
+	srv := s.healthServer
+	s.listenersMu.Unlock()
+
+	go func() {
+		if err := srv.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Printf("Health listener stopped: %v", err)
+		}
+	}()
+	log.Printf("Health checks listening on %s", s.healthAddr)
+	return nil
+}
+
+// stopHealthLocked closes the health listener. The caller holds listenersMu.
+func (s *RedisServer) stopHealthLocked() {
+	if s.healthServer != nil {
+		s.healthServer.Close()
+	}
+}
+
+// healthz answers as long as the process does
+func (s *RedisServer) healthz(w http.ResponseWriter, r *http.Request) {
+	writeHealth(w, nil)
+}
+
+// livez fails if the agent manager's lock can't be taken, which would leave
+// every agent command hanging
+func (s *RedisServer) livez(w http.ResponseWriter, r *http.Request) {
+	done := make(chan struct{})
+	go func() {
+		s.agents.Stats()
+		close(done)
+	}()
+
+	checks := map[string]string{"agents": "ok"}
+	select {
+	case <-done:
+	case <-time.After(livenessTimeout):
+		checks["agents"] = fmt.Sprintf("agent manager lock not acquired within %s", livenessTimeout)
+	}
+	writeHealth(w, checks)
+}
+
+// readyz fails while the server can't usefully take commands: before the
+// listeners are open and from the start of Stop, while agents are being
+// preloaded, when the embedder doesn't answer or the data directory isn't
+// writable
+func (s *RedisServer) readyz(w http.ResponseWriter, r *http.Request) {
+	checks := map[string]string{
+		"listener": "ok",
+		"preload":  "ok",
+		"embedder": "ok",
+	}
+
+	if s.draining.Load() {
+		checks["listener"] = "shutting down"
+	} else {
+		s.listenersMu.Lock()
+		bound := len(s.listeners) > 0 && !s.stopped
+		s.listenersMu.Unlock()
+		if !bound {
+			checks["listener"] = "not listening yet"
+		}
+	}
+
+	if !s.Ready() {
+		checks["preload"] = fmt.Sprintf("preloading agents (%d done)", s.preloadDone.Load())
+	}
+
+	if err := s.checkEmbedder(r.Context()); err != nil {
+		checks["embedder"] = err.Error()
+	}
+
+	if s.dataDir != "" {
+		checks["data_dir"] = "ok"
+		if err := checkWritable(s.dataDir); err != nil {
+			checks["data_dir"] = err.Error()
+		}
+	}
+
+	writeHealth(w, checks)
+}
+
+// checkEmbedder pings the server's embedder, reusing the last result for
+// embedCheckInterval
+func (s *RedisServer) checkEmbedder(ctx context.Context) error {
+	s.embedCheckMu.Lock()
+	defer s.embedCheckMu.Unlock()
+
+	if !s.embedChecked.IsZero() && time.Since(s.embedChecked) < embedCheckInterval {
+		return s.embedErr
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, embedCheckTimeout)
+	defer cancel()
+	s.embedErr = embedding.Ping(ctx, s.embedder)
+	s.embedChecked = time.Now()
+	return s.embedErr
+}
+
+// checkWritable creates and removes a file in dir
+func checkWritable(dir string) error {
+	f, err := os.CreateTemp(dir, ".readyz-*")
+	if err != nil {
+		return err
+	}
+	name := f.Name()
+	f.Close()
+	return os.Remove(name)
+}
+
+// writeHealth writes {"status": "ok"} if every check is "ok", and 503 with
+// {"status": "unavailable"} otherwise, listing the checks either way
+func writeHealth(w http.ResponseWriter, checks map[string]string) {
+	status, body := http.StatusOK, map[string]any{"status": "ok"}
+	for _, result := range checks {
+		if result != "ok" {
+			status, body["status"] = http.StatusServiceUnavailable, "unavailable"
+		}
+	}
+	if checks != nil {
+		body["checks"] = checks
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
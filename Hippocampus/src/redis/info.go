@@ -0,0 +1,104 @@
+package redis
+
+import (
+	"Hippocampus/src/storage"
+	hippoversion "Hippocampus/src/version"
+	"fmt"
+	"strings"
+)
+
+// formatVersionList joins the readable file format versions with sep
+func formatVersionList(sep string) string {
+	versions := make([]string, len(storage.SupportedFormatVersions))
+	for i, v := range storage.SupportedFormatVersions {
+		versions[i] = fmt.Sprint(v)
+	}
+	return strings.Join(versions, sep)
+}
+
+// info builds the INFO reply: "# Section" headers followed by field:value lines
+func (s *RedisServer) info() bulkString {
+	var sb strings.Builder
+
+	sb.WriteString("# Server\r\n")
+	build := hippoversion.Get()
+	fmt.Fprintf(&sb, "hippocampus_version:%s\r\n", build.Version)
+	fmt.Fprintf(&sb, "hippocampus_git_commit:%s\r\n", build.Commit)
+	fmt.Fprintf(&sb, "hippocampus_build_date:%s\r\n", build.BuildDate)
+	fmt.Fprintf(&sb, "go_version:%s\r\n", build.GoVersion)
+	fmt.Fprintf(&sb, "file_format_versions:%s\r\n", formatVersionList(","))
+	fmt.Fprintf(&sb, "file_format_written:%d\r\n", storage.CurrentFormatVersion)
+	fmt.Fprintf(&sb, "tcp_addr:%s\r\n", s.addr)
+	fmt.Fprintf(&sb, "ttl_seconds:%d\r\n", int64(s.ttl.Seconds()))
+	if s.accessLog != nil {
+		fmt.Fprintf(&sb, "access_log_dropped:%d\r\n", s.accessLog.dropped.Load())
+	}
+	sb.WriteString("\r\n")
+
+	agentCount := s.agents.Len()
+
+	sb.WriteString("# Clients\r\n")
+	fmt.Fprintf(&sb, "connected_clients:%d\r\n", s.ActiveConnections())
+	fmt.Fprintf(&sb, "maxclients:%d\r\n", s.maxConnections)
+	fmt.Fprintf(&sb, "rejected_connections:%d\r\n", s.rejectedConns.Load())
+	fmt.Fprintf(&sb, "agents:%d\r\n", agentCount)
+	agentStats := s.agents.Stats()
+	fmt.Fprintf(&sb, "agents_max:%d\r\n", agentStats.MaxAgents)
+	fmt.Fprintf(&sb, "agents_rejected:%d\r\n", agentStats.Rejected)
+	fmt.Fprintf(&sb, "agents_evicted:%d\r\n", agentStats.Evicted)
+	fmt.Fprintf(&sb, "agents_idle_evict_seconds:%d\r\n", int64(agentStats.IdleEvict.Seconds()))
+	sb.WriteString("\r\n")
+
+	if s.embedLimit != nil {
+		stats := s.embedLimit.Stats()
+		sb.WriteString("# Embedding\r\n")
+		fmt.Fprintf(&sb, "embed_concurrency:%d\r\n", stats.Concurrency)
+		fmt.Fprintf(&sb, "embed_queue_max:%d\r\n", stats.MaxQueue)
+		fmt.Fprintf(&sb, "embed_in_flight:%d\r\n", stats.InFlight)
+		fmt.Fprintf(&sb, "embed_queued:%d\r\n", stats.Queued)
+		fmt.Fprintf(&sb, "embed_rejected:%d\r\n", stats.Rejected)
+		fmt.Fprintf(&sb, "embed_waits:%d\r\n", stats.Waits)
+		fmt.Fprintf(&sb, "embed_wait_avg_ms:%.3f\r\n", float64(stats.AvgWait.Microseconds())/1000)
+		fmt.Fprintf(&sb, "embed_wait_max_ms:%.3f\r\n", float64(stats.MaxWait.Microseconds())/1000)
+		sb.WriteString("\r\n")
+	}
+
+	if s.webhook != nil {
+		stats := s.webhook.Stats()
+		sb.WriteString("# Webhooks\r\n")
+		fmt.Fprintf(&sb, "webhook_queued:%d\r\n", stats.Queued)
+		fmt.Fprintf(&sb, "webhook_delivered:%d\r\n", stats.Delivered)
+		fmt.Fprintf(&sb, "webhook_failed:%d\r\n", stats.Failed)
+		fmt.Fprintf(&sb, "webhook_dropped:%d\r\n", stats.Dropped)
+		sb.WriteString("\r\n")
+	}
+
+	if s.changeLog != nil {
+		stats := s.changeLog.Stats()
+		sb.WriteString("# CDC\r\n")
+		fmt.Fprintf(&sb, "cdc_seq:%d\r\n", stats.Seq)
+		fmt.Fprintf(&sb, "cdc_records:%d\r\n", stats.Records)
+		fmt.Fprintf(&sb, "cdc_segment_bytes:%d\r\n", stats.Bytes)
+		fmt.Fprintf(&sb, "cdc_rotations:%d\r\n", stats.Rotated)
+		fmt.Fprintf(&sb, "cdc_failures:%d\r\n", stats.Failures)
+		sb.WriteString("\r\n")
+	}
+
+	s.writePersistenceInfo(&sb)
+	s.writeSnapshotInfo(&sb)
+	s.writeReplicationInfo(&sb)
+
+	sb.WriteString("# Cluster\r\n")
+	if s.cluster != nil {
+		sb.WriteString("cluster_enabled:1\r\n")
+		fmt.Fprintf(&sb, "cluster_known_nodes:%d\r\n", len(s.cluster.nodes))
+		fmt.Fprintf(&sb, "cluster_redirect:%t\r\n", s.cluster.redirect)
+	} else {
+		sb.WriteString("cluster_enabled:0\r\n")
+	}
+	sb.WriteString("\r\n")
+
+	s.limits.writeInfo(&sb)
+
+	return bulkString(sb.String())
+}
@@ -0,0 +1,122 @@
+package redis
+
+import (
+	"strconv"
+	"strings"
+)
+
+// protocolError is a malformed request. The server replies with it and then
+// closes the connection, since the stream can't be resynchronised.
+type protocolError struct {
+	msg string
+}
+
+func (e *protocolError) Error() string {
+	return "Protocol error: " + e.msg
+}
+
+// splitInlineArgs tokenizes an inline command the way redis-cli and Redis's
+// sdssplitargs do: arguments are separated by whitespace, "double quotes"
+// support \n \r \t \b \a \\ \" and \xHH escapes, 'single quotes' only support \',
+// and a closing quote must be followed by whitespace or the end of the line.
+func splitInlineArgs(line string) ([]string, error) {
+	args := []string{}
+	i := 0
+
+	for {
+		// Skip separators between arguments
+		for i < len(line) && isInlineSpace(line[i]) {
+			i++
+		}
+		if i >= len(line) {
+			return args, nil
+		}
+
+		var current strings.Builder
+		inDouble, inSingle := false, false
+
+	arg:
+		for {
+			if i >= len(line) {
+				if inDouble || inSingle {
+					return nil, &protocolError{msg: "unbalanced quotes in request"}
+				}
+				break
+			}
+			c := line[i]
+
+			switch {
+			case inDouble:
+				switch {
+				case c == '\\' && i+3 < len(line) && line[i+1] == 'x' && isHexDigit(line[i+2]) && isHexDigit(line[i+3]):
+					b, _ := strconv.ParseUint(line[i+2:i+4], 16, 8)
+					current.WriteByte(byte(b))
+					i += 3
+				case c == '\\' && i+1 < len(line):
+					i++
+					switch line[i] {
+					case 'n':
+						current.WriteByte('\n')
+					case 'r':
+						current.WriteByte('\r')
+					case 't':
+						current.WriteByte('\t')
+					case 'b':
+						current.WriteByte('\b')
+					case 'a':
+						current.WriteByte('\a')
+					default:
+						current.WriteByte(line[i])
+					}
+				case c == '"':
+					// Closing quote must be followed by a space or the end
+					if i+1 < len(line) && !isInlineSpace(line[i+1]) {
+						return nil, &protocolError{msg: "unbalanced quotes in request"}
+					}
+					i++
+					break arg
+				default:
+					current.WriteByte(c)
+				}
+
+			case inSingle:
+				switch {
+				case c == '\\' && i+1 < len(line) && line[i+1] == '\'':
+					i++
+					current.WriteByte('\'')
+				case c == '\'':
+					if i+1 < len(line) && !isInlineSpace(line[i+1]) {
+						return nil, &protocolError{msg: "unbalanced quotes in request"}
+					}
+					i++
+					break arg
+				default:
+					current.WriteByte(c)
+				}
+
+			default:
+				switch {
+				case isInlineSpace(c):
+					break arg
+				case c == '"':
+					inDouble = true
+				case c == '\'':
+					inSingle = true
+				default:
+					current.WriteByte(c)
+				}
+			}
+			i++
+		}
+
+		args = append(args, current.String())
+	}
+}
+
+func isInlineSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\v' || c == '\f'
+}
+
+func isHexDigit(c byte) bool {
+	return (c >= '0' && c <= '9') || (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F')
+}
@@ -0,0 +1,62 @@
+package redis
+
+import (
+	"errors"
+	"slices"
+	"testing"
+)
+
+func TestSplitInlineArgs(t *testing.T) {
+	tests := []struct {
+		name string
+		line string
+		want []string
+	}{
+		{"empty", "", []string{}},
+		{"only spaces", " \t ", []string{}},
+		{"words", "HSET alice k hello", []string{"HSET", "alice", "k", "hello"}},
+		{"runs of whitespace", "  PING \t\v\f hello  ", []string{"PING", "hello"}},
+		{"double quotes", `HSET a k "hello world"`, []string{"HSET", "a", "k", "hello world"}},
+		{"single quotes", `HSET a k 'hello world'`, []string{"HSET", "a", "k", "hello world"}},
+		{"empty quotes", `ECHO "" ''`, []string{"ECHO", "", ""}},
+		{"quote inside a word", `ECHO ab"c d"`, []string{"ECHO", "abc d"}},
+		{"other quote kept", `ECHO "it's" 'say "hi"'`, []string{"ECHO", "it's", `say "hi"`}},
+		{"double-quote escapes", `ECHO "a\nb\rc\td\be\af\\g\"h"`, []string{"ECHO", "a\nb\rc\td\be\af\\g\"h"}},
+		{"unknown escape", `ECHO "\q"`, []string{"ECHO", "q"}},
+		{"hex escapes", `ECHO "\x41\x7a\x00"`, []string{"ECHO", "Az\x00"}},
+		{"incomplete hex escape", `ECHO "\x4g"`, []string{"ECHO", "x4g"}},
+		{"escaped single quote", `ECHO 'it\'s'`, []string{"ECHO", "it's"}},
+		{"backslash in single quotes", `ECHO 'a\nb'`, []string{"ECHO", `a\nb`}},
+		{"backslash outside quotes", `ECHO a\nb`, []string{"ECHO", `a\nb`}},
+		{"quoted argument at the end", `ECHO "x"`, []string{"ECHO", "x"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := splitInlineArgs(tt.line)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Fatalf("splitInlineArgs(%q) = %q, want %q", tt.line, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSplitInlineArgsUnbalancedQuotes(t *testing.T) {
+	for _, line := range []string{
+		`ECHO "hello`,
+		`ECHO 'hello`,
+		`ECHO "hello\"`,
+		`ECHO 'it\'s`,
+		`ECHO "a"b`,
+		`ECHO 'a'b`,
+		`ECHO "\`,
+	} {
+		_, err := splitInlineArgs(line)
+		var protoErr *protocolError
+		if !errors.As(err, &protoErr) {
+			t.Errorf("splitInlineArgs(%q) = %v, want a protocol error", line, err)
+		}
+	}
+}
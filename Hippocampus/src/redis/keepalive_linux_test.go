@@ -0,0 +1,116 @@
+package redis
+
+import (
+	"Hippocampus/src/embedding"
+	"crypto/tls"
+	"net"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// tcpPair returns both ends of a localhost TCP connection, the accepted one
+// first
+func tcpPair(t *testing.T) (server, client *net.TCPConn) {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	c, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close(); s.Close() })
+	return s.(*net.TCPConn), c.(*net.TCPConn)
+}
+
+// keepAliveOptions returns the socket's SO_KEEPALIVE, the seconds before the
+// first probe and between probes, and how many probes go unanswered before
+// the connection is dropped
+func keepAliveOptions(t *testing.T, conn *net.TCPConn) (enabled bool, idle, interval, count int) {
+	t.Helper()
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var sockErr error
+	err = raw.Control(func(fd uintptr) {
+		var on int
+		if on, sockErr = syscall.GetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_KEEPALIVE); sockErr != nil {
+			return
+		}
+		enabled = on != 0
+		if idle, sockErr = syscall.GetsockoptInt(int(fd), syscall.IPPROTO_TCP, syscall.TCP_KEEPIDLE); sockErr != nil {
+			return
+		}
+		if interval, sockErr = syscall.GetsockoptInt(int(fd), syscall.IPPROTO_TCP, syscall.TCP_KEEPINTVL); sockErr != nil {
+			return
+		}
+		count, sockErr = syscall.GetsockoptInt(int(fd), syscall.IPPROTO_TCP, syscall.TCP_KEEPCNT)
+	})
+	if err == nil {
+		err = sockErr
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return enabled, idle, interval, count
+}
+
+// A client that vanishes without a FIN can't be simulated without dropping
+// packets, so these check what the kernel probes it with instead: the time
+// from the last packet until an unanswered connection is dropped must stay
+// within twice the period, probes being at least a second apart.
+func TestKeepAliveSetOnConnections(t *testing.T) {
+	tests := []struct {
+		name    string
+		opts    []Option
+		enabled bool
+		period  int
+	}{
+		{"default", nil, true, int(defaultKeepAlive / time.Second)},
+		{"configured", []Option{WithTCPKeepAlive(6 * time.Second)}, true, 6},
+		{"short", []Option{WithTCPKeepAlive(time.Second)}, true, 1},
+		{"disabled", []Option{WithTCPKeepAlive(0)}, false, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewRedisServer("", embedding.NewMockEmbedder(), time.Minute, tt.opts...)
+			conn, _ := tcpPair(t)
+			s.setKeepAlive(conn)
+
+			enabled, idle, interval, count := keepAliveOptions(t, conn)
+			if enabled != tt.enabled {
+				t.Fatalf("SO_KEEPALIVE = %t, want %t", enabled, tt.enabled)
+			}
+			if !tt.enabled {
+				return
+			}
+			if idle != tt.period {
+				t.Fatalf("first probe after %ds idle, want %ds", idle, tt.period)
+			}
+			limit := tt.period + max(tt.period, keepAliveProbes)
+			if detect := idle + interval*count; detect > limit {
+				t.Fatalf("dead client dropped after %ds (%ds + %d probes every %ds), want at most %ds",
+					detect, idle, count, interval, limit)
+			}
+		})
+	}
+}
+
+func TestKeepAliveSetUnderTLS(t *testing.T) {
+	s := NewRedisServer("", embedding.NewMockEmbedder(), time.Minute, WithTCPKeepAlive(7*time.Second))
+	conn, _ := tcpPair(t)
+	s.setKeepAlive(tls.Server(conn, &tls.Config{}))
+
+	if enabled, idle, _, _ := keepAliveOptions(t, conn); !enabled || idle != 7 {
+		t.Fatalf("TCP connection under TLS: keep-alive %t after %ds", enabled, idle)
+	}
+}
@@ -0,0 +1,226 @@
+package redis
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// AgentLimits bounds how hard a single agent can use the server. Zero values mean unlimited.
+type AgentLimits struct {
+	Rate     float64 // Commands per second (token bucket refill rate)
+	Burst    int     // Bucket size; defaults to max(1, Rate) when zero
+	MaxNodes int     // Maximum memories stored per agent
+}
+
+// String formats limits in the form accepted by ParseAgentLimits
+func (l AgentLimits) String() string {
+	return fmt.Sprintf("rate=%g burst=%d max-nodes=%d", l.Rate, l.burst(), l.MaxNodes)
+}
+
+func (l AgentLimits) burst() int {
+	if l.Burst > 0 {
+		return l.Burst
+	}
+	if l.Rate >= 1 {
+		return int(l.Rate)
+	}
+	return 1
+}
+
+// ParseAgentLimits parses "rate=10 burst=20 max-nodes=5000" (space or comma
+// separated). Omitted fields keep their value from base.
+func ParseAgentLimits(spec string, base AgentLimits) (AgentLimits, error) {
+	limits := base
+	fields := strings.FieldsFunc(spec, func(r rune) bool {
+		return r == ' ' || r == ','
+	})
+
+	for _, field := range fields {
+		name, value, ok := strings.Cut(field, "=")
+		if !ok {
+			return limits, fmt.Errorf("invalid limit %q, expected name=value", field)
+		}
+
+		var err error
+		switch strings.ToLower(name) {
+		case "rate":
+			limits.Rate, err = strconv.ParseFloat(value, 64)
+		case "burst":
+			limits.Burst, err = strconv.Atoi(value)
+		case "max-nodes":
+			limits.MaxNodes, err = strconv.Atoi(value)
+		default:
+			return limits, fmt.Errorf("unknown limit %q (expected rate, burst or max-nodes)", name)
+		}
+		if err != nil {
+			return limits, fmt.Errorf("invalid %s: %v", name, err)
+		}
+	}
+
+	return limits, nil
+}
+
+// tokenBucket is a standard token bucket refilled continuously at rate tokens/sec
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func (b *tokenBucket) allow(now time.Time, rate float64, burst int) bool {
+	b.tokens += now.Sub(b.last).Seconds() * rate
+	if b.tokens > float64(burst) {
+		b.tokens = float64(burst)
+	}
+	b.last = now
+
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// agentCounters counts rejected commands for INFO
+type agentCounters struct {
+	rateRejected  int64
+	quotaRejected int64
+}
+
+// limiter enforces AgentLimits, with optional per-agent overrides
+type limiter struct {
+	mu        sync.Mutex
+	defaults  AgentLimits
+	overrides map[string]AgentLimits
+	buckets   map[string]*tokenBucket
+	counters  map[string]*agentCounters
+}
+
+func newLimiter(defaults AgentLimits) *limiter {
+	return &limiter{
+		defaults:  defaults,
+		overrides: make(map[string]AgentLimits),
+		buckets:   make(map[string]*tokenBucket),
+		counters:  make(map[string]*agentCounters),
+	}
+}
+
+// limitsFor returns the effective limits for an agent
+func (l *limiter) limitsFor(agentID string) AgentLimits {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.limitsForLocked(agentID)
+}
+
+func (l *limiter) limitsForLocked(agentID string) AgentLimits {
+	if limits, ok := l.overrides[agentID]; ok {
+		return limits
+	}
+	return l.defaults
+}
+
+// setOverride installs per-agent limits, replacing the agent's token bucket
+func (l *limiter) setOverride(agentID string, limits AgentLimits) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.overrides[agentID] = limits
+	delete(l.buckets, agentID)
+}
+
+// clearOverride reverts an agent to the default limits
+func (l *limiter) clearOverride(agentID string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.overrides, agentID)
+	delete(l.buckets, agentID)
+}
+
+func (l *limiter) countersLocked(agentID string) *agentCounters {
+	c, ok := l.counters[agentID]
+	if !ok {
+		c = &agentCounters{}
+		l.counters[agentID] = c
+	}
+	return c
+}
+
+// allowCommand takes a token from the agent's bucket, or returns a BUSYKEY error
+func (l *limiter) allowCommand(agentID string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	limits := l.limitsForLocked(agentID)
+	if limits.Rate <= 0 {
+		return nil
+	}
+
+	now := time.Now()
+	bucket, ok := l.buckets[agentID]
+	if !ok {
+		bucket = &tokenBucket{tokens: float64(limits.burst()), last: now}
+		l.buckets[agentID] = bucket
+	}
+
+	if !bucket.allow(now, limits.Rate, limits.burst()) {
+		l.countersLocked(agentID).rateRejected++
+		return &replyError{code: codeBusyKey, msg: fmt.Sprintf("rate limit exceeded for agent %s (%g commands/sec)", agentID, limits.Rate)}
+	}
+	return nil
+}
+
+// allowWrite returns an OOM error if the agent already stores MaxNodes memories
+func (l *limiter) allowWrite(agentID string, nodeCount int) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	limits := l.limitsForLocked(agentID)
+	if limits.MaxNodes <= 0 || nodeCount < limits.MaxNodes {
+		return nil
+	}
+
+	l.countersLocked(agentID).quotaRejected++
+	return &replyError{code: codeOOM, msg: fmt.Sprintf("max memories reached for agent %s (limit %d)", agentID, limits.MaxNodes)}
+}
+
+// forget drops the bucket and counters of a deleted agent. Overrides are kept
+// so limits still apply if the agent comes back.
+func (l *limiter) forget(agentID string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.buckets, agentID)
+	delete(l.counters, agentID)
+}
+
+// writeInfo appends the limits section of INFO
+func (l *limiter) writeInfo(sb *strings.Builder) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var rateTotal, quotaTotal int64
+	for _, c := range l.counters {
+		rateTotal += c.rateRejected
+		quotaTotal += c.quotaRejected
+	}
+
+	sb.WriteString("# Limits\r\n")
+	fmt.Fprintf(sb, "agent_rate_limit:%g\r\n", l.defaults.Rate)
+	fmt.Fprintf(sb, "agent_rate_burst:%d\r\n", l.defaults.burst())
+	fmt.Fprintf(sb, "agent_max_nodes:%d\r\n", l.defaults.MaxNodes)
+	fmt.Fprintf(sb, "agent_limit_overrides:%d\r\n", len(l.overrides))
+	fmt.Fprintf(sb, "rejected_rate_total:%d\r\n", rateTotal)
+	fmt.Fprintf(sb, "rejected_quota_total:%d\r\n", quotaTotal)
+
+	agentIDs := make([]string, 0, len(l.counters))
+	for agentID := range l.counters {
+		agentIDs = append(agentIDs, agentID)
+	}
+	sort.Strings(agentIDs)
+
+	for _, agentID := range agentIDs {
+		c := l.counters[agentID]
+		fmt.Fprintf(sb, "limited_%s:rate_rejected=%d,quota_rejected=%d\r\n", agentID, c.rateRejected, c.quotaRejected)
+	}
+}
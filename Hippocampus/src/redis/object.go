@@ -0,0 +1,147 @@
+package redis
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// freqWindow is how far back OBJECT FREQ counts accesses, in one-second buckets
+const freqWindow = 60
+
+// agentAccess records when an agent was last used and how often recently
+type agentAccess struct {
+	last    time.Time
+	counts  [freqWindow]int64 // Accesses per second, indexed by unix second % freqWindow
+	seconds [freqWindow]int64 // Unix second each bucket currently counts
+}
+
+// accessTracker tracks inserts and searches per agent for OBJECT IDLETIME / FREQ
+type accessTracker struct {
+	mu     sync.Mutex
+	agents map[string]*agentAccess
+}
+
+func newAccessTracker() *accessTracker {
+	return &accessTracker{
+		agents: make(map[string]*agentAccess),
+	}
+}
+
+func (t *accessTracker) getLocked(agentID string, now time.Time) *agentAccess {
+	a, ok := t.agents[agentID]
+	if !ok {
+		a = &agentAccess{last: now}
+		t.agents[agentID] = a
+	}
+	return a
+}
+
+// created starts the idle clock of a new agent without counting an access
+func (t *accessTracker) created(agentID string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.getLocked(agentID, time.Now())
+}
+
+// record counts an insert or search
+func (t *accessTracker) record(agentID string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now()
+	a := t.getLocked(agentID, now)
+	a.last = now
+
+	sec := now.Unix()
+	slot := sec % freqWindow
+	if a.seconds[slot] != sec {
+		// Bucket last counted a second that has left the window
+		a.seconds[slot] = sec
+		a.counts[slot] = 0
+	}
+	a.counts[slot]++
+}
+
+// idle returns the time since the agent's last insert or search
+func (t *accessTracker) idle(agentID string) time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	a, ok := t.agents[agentID]
+	if !ok {
+		return 0
+	}
+	return time.Since(a.last)
+}
+
+// freq returns the number of inserts and searches in the last minute
+func (t *accessTracker) freq(agentID string) int64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	a, ok := t.agents[agentID]
+	if !ok {
+		return 0
+	}
+
+	now := time.Now().Unix()
+	var total int64
+	for i := range a.counts {
+		if now-a.seconds[i] < freqWindow {
+			total += a.counts[i]
+		}
+	}
+	return total
+}
+
+func (t *accessTracker) forget(agentID string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.agents, agentID)
+}
+
+// processObjectCommand handles OBJECT ENCODING|IDLETIME|FREQ agent_id. Like
+// Redis, a missing agent replies with nil.
+func (s *RedisServer) processObjectCommand(cmd []string) interface{} {
+	if len(cmd) < 3 {
+		return errWrongArgs("OBJECT")
+	}
+
+	agentID := cmd[2]
+	c, exists, err := s.getClient(agentID)
+	if err != nil {
+		return err
+	}
+
+	switch strings.ToUpper(cmd[1]) {
+	case "ENCODING":
+		if !exists {
+			return nil
+		}
+		count, err := c.Count()
+		if err != nil {
+			return err
+		}
+		if count == 0 {
+			return bulkString("empty")
+		}
+		return bulkString(fmt.Sprintf("flat-tree-%d-nodes", count))
+
+	case "IDLETIME":
+		if !exists {
+			return nil
+		}
+		return int64(s.access.idle(agentID).Seconds())
+
+	case "FREQ":
+		if !exists {
+			return nil
+		}
+		return s.access.freq(agentID)
+
+	default:
+		return fmt.Errorf("unknown OBJECT subcommand: %s", cmd[1])
+	}
+}
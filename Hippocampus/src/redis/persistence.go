@@ -0,0 +1,230 @@
+package redis
+
+import (
+	"Hippocampus/src/agents"
+	"Hippocampus/src/client"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// preloadProgressInterval is how often a running preload logs its progress
+const preloadProgressInterval = 5 * time.Second
+
+// PreloadPolicy decides which agents in the data directory are loaded at startup
+type PreloadPolicy struct {
+	All    bool // Load every agent
+	Recent int  // Load the N most recently modified agents; ignored when All is set
+}
+
+// String formats the policy in the form accepted by ParsePreloadPolicy
+func (p PreloadPolicy) String() string {
+	switch {
+	case p.All:
+		return "all"
+	case p.Recent > 0:
+		return fmt.Sprintf("recent=%d", p.Recent)
+	default:
+		return "lazy"
+	}
+}
+
+// ParsePreloadPolicy parses "lazy", "all" or "recent=N"
+func ParsePreloadPolicy(spec string) (PreloadPolicy, error) {
+	switch {
+	case spec == "" || spec == "lazy":
+		return PreloadPolicy{}, nil
+	case spec == "all":
+		return PreloadPolicy{All: true}, nil
+	case strings.HasPrefix(spec, "recent="):
+		n, err := strconv.Atoi(strings.TrimPrefix(spec, "recent="))
+		if err != nil || n < 1 {
+			return PreloadPolicy{}, fmt.Errorf("invalid preload policy %q, expected recent=N with N >= 1", spec)
+		}
+		return PreloadPolicy{Recent: n}, nil
+	default:
+		return PreloadPolicy{}, fmt.Errorf("invalid preload policy %q (expected lazy, all or recent=N)", spec)
+	}
+}
+
+// WithDataDir stores each agent in dir/<agent_id>.bin instead of in memory.
+// Agents already in dir are loaded on first use, or at startup per WithPreload.
+func WithDataDir(dir string) Option {
+	return func(s *RedisServer) {
+		s.dataDir = dir
+	}
+}
+
+// WithPreload sets which agents in the data directory are loaded at startup
+func WithPreload(policy PreloadPolicy) Option {
+	return func(s *RedisServer) {
+		s.preload = policy
+	}
+}
+
+// WithPersistenceDir saves the in-memory agents to dir/<agent_id>.bin when
+// the server stops, and loads them back when it starts
+func WithPersistenceDir(dir string) Option {
+	return func(s *RedisServer) {
+		s.persistenceDir = dir
+	}
+}
+
+// loadingReply turns the manager's loading errors into -LOADING replies
+func loadingReply(err error) error {
+	var le *agents.LoadingError
+	if errors.As(err, &le) {
+		return errLoading(le.Agent)
+	}
+	return err
+}
+
+// onDisk reports whether an agent has a file in the data directory
+func (s *RedisServer) onDisk(agentID string) bool {
+	return s.agents.OnDisk(agentID)
+}
+
+// getClient returns an existing agent, loading it from the data directory if
+// it isn't in memory yet. It returns a LOADING error while another connection
+// is loading the same agent.
+func (s *RedisServer) getClient(agentID string) (*client.Client, bool, error) {
+	c, exists, err := s.agents.Get(context.Background(), agentID)
+	return c, exists, loadingReply(err)
+}
+
+// loadAgent reads an agent from the data directory and registers it. Other
+// commands on the agent get -LOADING until it finishes.
+func (s *RedisServer) loadAgent(agentID string) (*client.Client, bool, error) {
+	c, exists, err := s.agents.Load(context.Background(), agentID)
+	return c, exists, loadingReply(err)
+}
+
+// flushAgents saves every loaded agent with unsaved changes to its file
+func (s *RedisServer) flushAgents() error {
+	return s.agents.Flush()
+}
+
+// SaveAllAgents writes every in-memory agent to dir/<agent_id>.bin, and
+// removes the files there of agents that no longer exist or hold nothing, so
+// they don't come back on the next start. Agents stored in the data directory
+// are already on disk and skipped. A failed agent is logged and the rest are
+// still saved; the first error is returned.
+func (s *RedisServer) SaveAllAgents(dir string) error {
+	return s.agents.SaveAll(dir)
+}
+
+// preloadAgents loads and warms up the agents selected by the preload policy
+// with a pool of workers. The server is not Ready until it returns.
+func (s *RedisServer) preloadAgents() {
+	defer s.preloading.Store(false)
+
+	onDisk, err := s.agents.DiskAgents()
+	if err != nil {
+		log.Printf("Preload failed to list %s: %v", s.dataDir, err)
+		return
+	}
+
+	if !s.preload.All {
+		sort.Slice(onDisk, func(i, j int) bool {
+			return onDisk[i].Modified.After(onDisk[j].Modified)
+		})
+		if len(onDisk) > s.preload.Recent {
+			onDisk = onDisk[:s.preload.Recent]
+		}
+	}
+
+	s.preloadTotal.Store(int64(len(onDisk)))
+	log.Printf("Preloading %d agents from %s (%s)", len(onDisk), s.dataDir, s.preload)
+	start := time.Now()
+
+	ids := make(chan string)
+	var wg sync.WaitGroup
+	for i := 0; i < runtime.NumCPU(); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for agentID := range ids {
+				// An agent already loaded or loading by a command is skipped
+				c, _, err := s.loadAgent(agentID)
+				if err != nil && !isLoadingError(err) {
+					log.Printf("Preload: %v", err)
+				}
+				if err == nil {
+					if err := c.WarmUp(context.Background()); err != nil {
+						log.Printf("Preload: failed to warm up agent %s: %v", agentID, err)
+					}
+				}
+				s.preloadDone.Add(1)
+			}
+		}()
+	}
+
+	progress := time.NewTicker(preloadProgressInterval)
+	defer progress.Stop()
+
+	for _, a := range onDisk {
+		select {
+		case ids <- a.ID:
+		case <-progress.C:
+			log.Printf("Preloaded %d/%d agents", s.preloadDone.Load(), len(onDisk))
+			ids <- a.ID
+		}
+	}
+	close(ids)
+	wg.Wait()
+
+	log.Printf("Preloaded %d agents in %s", len(onDisk), time.Since(start).Round(time.Millisecond))
+}
+
+// Ready reports whether startup work is done: false while agents are being
+// preloaded and warmed up, true otherwise. Commands are served either way;
+// an agent still being loaded answers -LOADING.
+func (s *RedisServer) Ready() bool {
+	return !s.preloading.Load()
+}
+
+func isLoadingError(err error) bool {
+	re, ok := err.(*replyError)
+	return ok && re.code == codeLoading
+}
+
+// writePersistenceInfo appends the persistence section of INFO
+func (s *RedisServer) writePersistenceInfo(sb *strings.Builder) {
+	loading := s.agents.Loading()
+	sort.Strings(loading)
+
+	loadingFlag := 0
+	if s.preloading.Load() {
+		loadingFlag = 1
+	}
+
+	sb.WriteString("# Persistence\r\n")
+	fmt.Fprintf(sb, "data_dir:%s\r\n", s.dataDir)
+	fmt.Fprintf(sb, "loading:%d\r\n", loadingFlag)
+	fmt.Fprintf(sb, "preload:%s\r\n", s.preload)
+	fmt.Fprintf(sb, "preload_loaded:%d\r\n", s.preloadDone.Load())
+	fmt.Fprintf(sb, "preload_total:%d\r\n", s.preloadTotal.Load())
+	fmt.Fprintf(sb, "loading_agents:%d\r\n", len(loading))
+	for _, agentID := range loading {
+		fmt.Fprintf(sb, "loading_%s:1\r\n", agentID)
+	}
+	sb.WriteString("\r\n")
+}
+
+// persistenceState groups the RedisServer fields for the data directory
+type persistenceState struct {
+	dataDir        string // Agents are stored here when set, otherwise in memory
+	persistenceDir string // In-memory agents are saved here on Stop and loaded on Start
+	preload        PreloadPolicy
+	preloadTotal   atomic.Int64
+	preloadDone    atomic.Int64
+	preloading     atomic.Bool // Set while preloadAgents runs; see Ready
+}
@@ -0,0 +1,243 @@
+package redis
+
+import (
+	"Hippocampus/src/agents"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// SearchProfile holds the search parameters used when HSEARCH or HGET omit them
+type SearchProfile struct {
+	Epsilon   float32 `json:"epsilon"`
+	Threshold float32 `json:"threshold"`
+	TopK      int     `json:"top_k"`
+}
+
+// defaultSearchProfile applies to agents without an HCONFIG profile
+var defaultSearchProfile = SearchProfile{Epsilon: 0.3, Threshold: 0.5, TopK: 5}
+
+// set parses a parameter name and value, e.g. ("threshold", "0.7")
+func (p *SearchProfile) set(name, value string) error {
+	switch strings.ToLower(name) {
+	case "epsilon":
+		v, err := strconv.ParseFloat(value, 32)
+		if err != nil || !validEpsilon(float32(v)) {
+			return fmt.Errorf("invalid epsilon %q, expected a non-negative number", value)
+		}
+		p.Epsilon = float32(v)
+	case "threshold":
+		v, err := strconv.ParseFloat(value, 32)
+		if err != nil || !validThreshold(float32(v)) {
+			return fmt.Errorf("invalid threshold %q, expected a number in [0, 1]", value)
+		}
+		p.Threshold = float32(v)
+	case "topk":
+		v, err := strconv.Atoi(value)
+		if err != nil || v < 0 {
+			return fmt.Errorf("invalid topk %q, expected a non-negative integer", value)
+		}
+		p.TopK = v
+	default:
+		return fmt.Errorf("unknown search parameter %q (expected epsilon, threshold or topk)", name)
+	}
+	return nil
+}
+
+// validate applies the rules of set to a profile decoded as a whole, e.g.
+// from an HRESTORE payload or the data directory
+func (p SearchProfile) validate() error {
+	switch {
+	case !validEpsilon(p.Epsilon):
+		return fmt.Errorf("invalid epsilon %v, expected a non-negative number", p.Epsilon)
+	case !validThreshold(p.Threshold):
+		return fmt.Errorf("invalid threshold %v, expected a number in [0, 1]", p.Threshold)
+	case p.TopK < 0:
+		return fmt.Errorf("invalid topk %d, expected a non-negative integer", p.TopK)
+	}
+	return nil
+}
+
+func validEpsilon(v float32) bool {
+	return v >= 0
+}
+
+func validThreshold(v float32) bool {
+	return v >= 0 && v <= 1
+}
+
+// reply formats the profile as CONFIG GET style name/value pairs
+func (p SearchProfile) reply() []string {
+	return []string{
+		"epsilon", strconv.FormatFloat(float64(p.Epsilon), 'g', -1, 32),
+		"threshold", strconv.FormatFloat(float64(p.Threshold), 'g', -1, 32),
+		"topk", strconv.Itoa(p.TopK),
+	}
+}
+
+// profileExt is the extension of profile files in the data directory, kept
+// next to the agent's .bin file
+const profileExt = ".profile.json"
+
+// profileStore holds per-agent search profiles set with HCONFIG. With a data
+// directory each profile is also written to dir/<agent_id>.profile.json, and
+// read back the first time the agent is loaded or its profile is needed.
+type profileStore struct {
+	mu       sync.Mutex
+	profiles map[string]SearchProfile
+	dir      string          // Data directory, "" to keep profiles in memory
+	read     map[string]bool // Agents whose profile file has been read
+}
+
+func newProfileStore() *profileStore {
+	return &profileStore{
+		profiles: make(map[string]SearchProfile),
+		read:     make(map[string]bool),
+	}
+}
+
+// path returns the profile file of an agent
+func (ps *profileStore) path(agentID string) (string, error) {
+	path, err := agents.FilePath(ps.dir, agentID)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(path, agents.FileExt) + profileExt, nil
+}
+
+// load reads an agent's profile file, once; it runs when the agent is loaded
+func (ps *profileStore) load(agentID string) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	ps.loadLocked(agentID)
+}
+
+func (ps *profileStore) loadLocked(agentID string) {
+	if ps.dir == "" || ps.read[agentID] {
+		return
+	}
+	ps.read[agentID] = true
+	if _, set := ps.profiles[agentID]; set {
+		return
+	}
+
+	path, err := ps.path(agentID)
+	if err != nil {
+		return
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("Failed to read search profile of %s: %v", agentID, err)
+		}
+		return
+	}
+	var p SearchProfile
+	err = json.Unmarshal(data, &p)
+	if err == nil {
+		err = p.validate()
+	}
+	if err != nil {
+		log.Printf("Ignoring search profile of %s in %s: %v", agentID, path, err)
+		return
+	}
+	ps.profiles[agentID] = p
+}
+
+// get returns the agent's profile and whether one was set explicitly
+func (ps *profileStore) get(agentID string) (SearchProfile, bool) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	ps.loadLocked(agentID)
+	if p, ok := ps.profiles[agentID]; ok {
+		return p, true
+	}
+	return defaultSearchProfile, false
+}
+
+// set stores an agent's profile, writing it to the data directory if there is one
+func (ps *profileStore) set(agentID string, p SearchProfile) error {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	if ps.dir != "" {
+		path, err := ps.path(agentID)
+		if err != nil {
+			return err
+		}
+		data, err := json.Marshal(p)
+		if err != nil {
+			return err
+		}
+		// Through a temporary file, so a failed write keeps the old profile
+		if err := os.WriteFile(path+".tmp", data, 0644); err != nil {
+			return fmt.Errorf("failed to save search profile: %w", err)
+		}
+		if err := os.Rename(path+".tmp", path); err != nil {
+			os.Remove(path + ".tmp")
+			return fmt.Errorf("failed to save search profile: %w", err)
+		}
+		ps.read[agentID] = true
+	}
+	ps.profiles[agentID] = p
+	return nil
+}
+
+// forget drops an agent's profile, deleting its file
+func (ps *profileStore) forget(agentID string) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	delete(ps.profiles, agentID)
+	delete(ps.read, agentID)
+	if ps.dir == "" {
+		return
+	}
+	if path, err := ps.path(agentID); err == nil {
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			log.Printf("Failed to delete search profile of %s: %v", agentID, err)
+		}
+	}
+}
+
+// processHConfig handles HCONFIG agent_id GET | SET name value [name value ...]
+func (s *RedisServer) processHConfig(cmd []string) interface{} {
+	if len(cmd) < 3 {
+		return errWrongArgs("HCONFIG")
+	}
+
+	agentID := cmd[1]
+
+	switch strings.ToUpper(cmd[2]) {
+	case "GET":
+		profile, _ := s.profiles.get(agentID)
+		return profile.reply()
+
+	case "SET":
+		pairs := cmd[3:]
+		if len(pairs) == 0 || len(pairs)%2 != 0 {
+			return errWrongArgs("HCONFIG|SET")
+		}
+
+		// Parameters not given keep their current value
+		profile, _ := s.profiles.get(agentID)
+		for i := 0; i < len(pairs); i += 2 {
+			if err := profile.set(pairs[i], pairs[i+1]); err != nil {
+				return err
+			}
+		}
+		if err := s.profiles.set(agentID, profile); err != nil {
+			return err
+		}
+		return "OK"
+
+	default:
+		return fmt.Errorf("unknown HCONFIG subcommand: %s", cmd[2])
+	}
+}
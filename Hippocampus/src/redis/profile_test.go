@@ -0,0 +1,55 @@
+package redis
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestProfilesPersistInDataDir(t *testing.T) {
+	dir := t.TempDir()
+	s := newTestServer(t, WithDataDir(dir))
+	mustOK(t, s, "HSET", "alice", "k", "text")
+	mustOK(t, s, "HCONFIG", "alice", "SET", "threshold", "0.8", "topk", "3")
+	s.Stop()
+
+	if _, err := os.Stat(filepath.Join(dir, "alice"+profileExt)); err != nil {
+		t.Fatalf("profile not written: %v", err)
+	}
+
+	// A new server reads the profile when the agent loads
+	s = newTestServer(t, WithDataDir(dir))
+	if _, _, err := s.getClient("alice"); err != nil {
+		t.Fatal(err)
+	}
+	if p, ok := s.profiles.profiles["alice"]; !ok || p.Threshold != 0.8 || p.TopK != 3 {
+		t.Fatalf("profile after loading alice = %+v, %v", p, ok)
+	}
+	want := []string{"epsilon", "0.3", "threshold", "0.8", "topk", "3"}
+	if got := mustOK(t, s, "HCONFIG", "alice", "GET"); !slices.Equal(got.([]string), want) {
+		t.Fatalf("HCONFIG GET = %v, want %v", got, want)
+	}
+
+	// Deleting the agent deletes its profile
+	mustOK(t, s, "DEL", "alice")
+	if _, err := os.Stat(filepath.Join(dir, "alice"+profileExt)); !os.IsNotExist(err) {
+		t.Fatalf("profile left behind: %v", err)
+	}
+	s.Stop()
+
+	s = newTestServer(t, WithDataDir(dir))
+	if _, set := s.profiles.get("alice"); set {
+		t.Fatal("deleted profile came back")
+	}
+}
+
+func TestInvalidProfileFileIgnored(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "alice"+profileExt), []byte(`{"threshold":5}`), 0644)
+
+	s := newTestServer(t, WithDataDir(dir))
+	if p, set := s.profiles.get("alice"); set || p != defaultSearchProfile {
+		t.Fatalf("profile = %+v, %v, want the default", p, set)
+	}
+}
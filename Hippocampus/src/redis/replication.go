@@ -0,0 +1,363 @@
+package redis
+
+import (
+	"Hippocampus/src/client"
+	"bufio"
+	"fmt"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Replication protocol
+//
+// A replica connects to its primary and sends HSYNC. From then on the
+// connection carries only primary-to-replica frames, each a RESP array of bulk
+// strings in the same form as a client command:
+//
+//	FULLSYNC <agent_count>              replica drops every local agent
+//	HRESTORE <agent_id> <dump> REPLACE  one per agent, as produced by HDUMP
+//	<write command>                     every successful write, in execution order
+//	PING                                heartbeat every replicaHeartbeat
+//
+// A full sync is taken while writes are paused, so the stream that follows
+// starts exactly where the snapshot ends. If the connection drops, or the
+// replica falls more than replicaFeedQueue commands behind, the replica
+// reconnects and starts over with a full sync. Write commands are re-executed
+// on the replica, so it must use the same embedding service as the primary.
+
+const (
+	replicaFeedQueue  = 10000            // Commands buffered per replica before it is dropped
+	replicaHeartbeat  = 10 * time.Second // PING interval on an idle stream
+	replicaTimeout    = 3 * replicaHeartbeat
+	replicaRetryDelay = time.Second
+)
+
+// replicaFeed is the queue of write commands for one connected replica
+type replicaFeed struct {
+	ch chan [][]byte
+}
+
+// WithReplicaOf starts the server as a read-only replica of the primary at addr (host:port)
+func WithReplicaOf(addr string) Option {
+	return func(s *RedisServer) {
+		s.primaryAddr = addr
+	}
+}
+
+// isWriteCommand reports whether cmd modifies agent data, i.e. is rejected on
+// replicas and streamed from primaries
+func isWriteCommand(cmd []string) bool {
+	switch strings.ToUpper(cmd[0]) {
+	case "HSET", "HINSERT", "HDEL", "HCLEAR", "DEL", "FLUSHALL", "COPY", "HRESTORE":
+		return true
+	case "HCONFIG":
+		return len(cmd) > 2 && strings.EqualFold(cmd[2], "SET")
+	case "HAGENT":
+		return len(cmd) > 1 && strings.EqualFold(cmd[1], "SET")
+	}
+	return false
+}
+
+// propagate queues a successful write for every connected replica. A replica
+// whose queue is full is disconnected and will resync.
+func (s *RedisServer) propagate(raw [][]byte) {
+	s.replicasMu.Lock()
+	defer s.replicasMu.Unlock()
+
+	if len(s.replicas) == 0 {
+		return
+	}
+
+	// raw aliases the connection's read buffer, so queue a copy
+	cmd := make([][]byte, len(raw))
+	for i, arg := range raw {
+		cmd[i] = append([]byte(nil), arg...)
+	}
+
+	for feed := range s.replicas {
+		select {
+		case feed.ch <- cmd:
+		default:
+			log.Printf("Replica fell %d commands behind, disconnecting it", replicaFeedQueue)
+			delete(s.replicas, feed)
+			close(feed.ch)
+		}
+	}
+}
+
+// serveReplica turns a connection that sent HSYNC into a replication stream
+func (s *RedisServer) serveReplica(ci *connInfo, writer *bufio.Writer) {
+	if s.isReplica() {
+		s.writeResponse(writer, fmt.Errorf("this server is itself a replica; replicate from its primary"))
+		writer.Flush()
+		return
+	}
+
+	feed := &replicaFeed{ch: make(chan [][]byte, replicaFeedQueue)}
+
+	// Pause writes so the snapshot and the start of the stream line up
+	s.replMu.Lock()
+	dumps, err := s.snapshotAgents()
+	if err == nil {
+		s.replicasMu.Lock()
+		s.replicas[feed] = struct{}{}
+		s.replicasMu.Unlock()
+	}
+	s.replMu.Unlock()
+
+	if err != nil {
+		s.writeResponse(writer, err)
+		writer.Flush()
+		return
+	}
+
+	defer func() {
+		s.replicasMu.Lock()
+		delete(s.replicas, feed)
+		s.replicasMu.Unlock()
+	}()
+
+	log.Printf("Replica %s connected, sending %d agents", ci.addr, len(dumps))
+
+	writeFrame(writer, []byte("FULLSYNC"), []byte(strconv.Itoa(len(dumps))))
+	for agentID, dump := range dumps {
+		writeFrame(writer, []byte("HRESTORE"), []byte(agentID), dump, []byte("REPLACE"))
+	}
+	if err := writer.Flush(); err != nil {
+		return
+	}
+
+	heartbeat := time.NewTicker(replicaHeartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case cmd, ok := <-feed.ch:
+			if !ok {
+				return // Dropped for falling behind
+			}
+			writeFrame(writer, cmd...)
+			// Batch flushes while commands are queued
+			if len(feed.ch) > 0 {
+				continue
+			}
+		case <-heartbeat.C:
+			writeFrame(writer, []byte("PING"))
+		}
+
+		if err := writer.Flush(); err != nil {
+			log.Printf("Replica %s disconnected: %v", ci.addr, err)
+			return
+		}
+	}
+}
+
+// snapshotAgents dumps every agent. Callers hold replMu so no write is in flight.
+func (s *RedisServer) snapshotAgents() (map[string][]byte, error) {
+	dumps := make(map[string][]byte)
+	err := s.agents.RangeAll(func(agentID string, c *client.Client) error {
+		if c == nil {
+			// Read agents still on disk without registering them
+			var err error
+			if c, err = s.newClient(agentID); err != nil {
+				return err
+			}
+		}
+		dump, err := s.dumpAgent(agentID, c, false)
+		if err != nil {
+			return fmt.Errorf("failed to snapshot agent %s: %w", agentID, err)
+		}
+		dumps[agentID] = dump
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return dumps, nil
+}
+
+// writeFrame writes args as a RESP array of bulk strings
+func writeFrame(writer *bufio.Writer, args ...[]byte) {
+	fmt.Fprintf(writer, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(writer, "$%d\r\n", len(arg))
+		writer.Write(arg)
+		writer.WriteString("\r\n")
+	}
+}
+
+// isReplica reports whether the server is following a primary
+func (s *RedisServer) isReplica() bool {
+	s.primaryMu.Lock()
+	defer s.primaryMu.Unlock()
+	return s.primaryAddr != ""
+}
+
+// setPrimary starts replicating from addr, or stops replicating when addr is
+// empty. Data received so far is kept when replication stops.
+func (s *RedisServer) setPrimary(addr string) {
+	s.primaryMu.Lock()
+	defer s.primaryMu.Unlock()
+
+	if s.replStop != nil {
+		close(s.replStop)
+		s.replStop = nil
+	}
+	s.primaryAddr = addr
+	s.primaryLinkUp.Store(false)
+
+	if addr != "" {
+		s.replStop = make(chan struct{})
+		go s.replicate(addr, s.replStop)
+	}
+}
+
+// replicate follows the primary until stop is closed, reconnecting after failures
+func (s *RedisServer) replicate(addr string, stop chan struct{}) {
+	for {
+		err := s.syncFromPrimary(addr, stop)
+		s.primaryLinkUp.Store(false)
+
+		select {
+		case <-stop:
+			return
+		default:
+		}
+
+		log.Printf("Replication from %s interrupted: %v; retrying", addr, err)
+		select {
+		case <-stop:
+			return
+		case <-time.After(replicaRetryDelay):
+		}
+	}
+}
+
+// syncFromPrimary runs one replication connection: a full sync, then the write stream
+func (s *RedisServer) syncFromPrimary(addr string, stop chan struct{}) error {
+	conn, err := net.DialTimeout("tcp", addr, replicaTimeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	// Unblock the read below when replication is stopped
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-stop:
+			conn.Close()
+		case <-done:
+		}
+	}()
+
+	writer := bufio.NewWriter(conn)
+	writeFrame(writer, []byte("HSYNC"))
+	if err := writer.Flush(); err != nil {
+		return err
+	}
+
+	reader := bufio.NewReader(conn)
+	ci := &connInfo{addr: addr, replication: true}
+	var args argBuffer
+
+	for {
+		conn.SetReadDeadline(time.Now().Add(replicaTimeout))
+		raw, err := s.readCommand(reader, &args)
+		if err != nil {
+			return err
+		}
+		if len(raw) == 0 {
+			continue
+		}
+
+		cmd := commandStrings(raw)
+		if strings.HasPrefix(cmd[0], "-") {
+			// The primary refused HSYNC with an error reply
+			return fmt.Errorf("primary refused sync: %s", strings.Join(cmd, " ")[1:])
+		}
+
+		switch strings.ToUpper(cmd[0]) {
+		case "FULLSYNC":
+			s.dropAllAgents()
+			s.primaryLinkUp.Store(true)
+			log.Printf("Full sync from %s started", addr)
+		case "PING":
+		default:
+			if err, ok := s.processCommand(ci, cmd, raw).(error); ok {
+				log.Printf("Applying replicated %s failed: %v", cmd[0], err)
+			}
+		}
+		args.release()
+	}
+}
+
+// dropAllAgents removes every agent before a full sync
+func (s *RedisServer) dropAllAgents() {
+	if err := s.agents.DeleteAll(nil); err != nil {
+		log.Printf("Failed to drop agents for full sync: %v", err)
+	}
+}
+
+// processReplicaOf handles REPLICAOF host port | REPLICAOF NO ONE
+func (s *RedisServer) processReplicaOf(cmd []string) interface{} {
+	if len(cmd) != 3 {
+		return errWrongArgs("REPLICAOF")
+	}
+
+	if strings.EqualFold(cmd[1], "NO") && strings.EqualFold(cmd[2], "ONE") {
+		s.setPrimary("")
+		return "OK"
+	}
+
+	if _, err := strconv.Atoi(cmd[2]); err != nil {
+		return fmt.Errorf("invalid primary port: %s", cmd[2])
+	}
+	s.setPrimary(net.JoinHostPort(cmd[1], cmd[2]))
+	return "OK"
+}
+
+// writeReplicationInfo appends the replication section of INFO
+func (s *RedisServer) writeReplicationInfo(sb *strings.Builder) {
+	s.primaryMu.Lock()
+	primary := s.primaryAddr
+	s.primaryMu.Unlock()
+
+	sb.WriteString("# Replication\r\n")
+	if primary != "" {
+		linkStatus := "down"
+		if s.primaryLinkUp.Load() {
+			linkStatus = "up"
+		}
+		// Field names follow Redis so existing tooling can read them
+		sb.WriteString("role:slave\r\n")
+		fmt.Fprintf(sb, "master_host:%s\r\n", primary)
+		fmt.Fprintf(sb, "master_link_status:%s\r\n", linkStatus)
+	} else {
+		sb.WriteString("role:master\r\n")
+	}
+
+	s.replicasMu.Lock()
+	fmt.Fprintf(sb, "connected_slaves:%d\r\n", len(s.replicas))
+	s.replicasMu.Unlock()
+	sb.WriteString("\r\n")
+}
+
+// replicationState groups the RedisServer fields used by replication
+type replicationState struct {
+	replMu sync.RWMutex // Held for reading by writes, for writing while a full sync snapshots
+
+	replicasMu sync.Mutex
+	replicas   map[*replicaFeed]struct{}
+
+	primaryMu     sync.Mutex
+	primaryAddr   string        // Primary being followed, empty on a primary
+	replStop      chan struct{} // Closed to stop following the primary
+	primaryLinkUp atomic.Bool
+}
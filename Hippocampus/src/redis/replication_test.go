@@ -0,0 +1,185 @@
+package redis
+
+import (
+	"Hippocampus/src/embedding"
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// startTestServer starts a server listening on a free localhost port and
+// returns it with its address
+func startTestServer(t *testing.T, opts ...Option) (*RedisServer, string) {
+	t.Helper()
+	s := NewRedisServer("127.0.0.1:0", embedding.NewMockEmbedder(), time.Minute, opts...)
+	go s.Start()
+	t.Cleanup(func() {
+		// Stop leaves a replica following its primary
+		s.setPrimary("")
+		s.Stop()
+	})
+
+	var addr string
+	waitFor(t, "the server to listen", func() bool {
+		s.listenersMu.Lock()
+		defer s.listenersMu.Unlock()
+		if len(s.listeners) == 0 {
+			return false
+		}
+		addr = s.listeners[0].Addr().String()
+		return true
+	})
+	return s, addr
+}
+
+// waitFor polls cond until it holds, failing the test after 5 seconds
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); !cond(); time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+	}
+}
+
+// keys returns an agent's sorted keys
+func keys(s *RedisServer, agentID string) []string {
+	k, _ := do(s, "HKEYS", agentID).([]string)
+	k = slices.Clone(k)
+	slices.Sort(k)
+	return k
+}
+
+// waitForKeys waits until an agent holds exactly want
+func waitForKeys(t *testing.T, s *RedisServer, agentID string, want ...string) {
+	t.Helper()
+	slices.Sort(want)
+	waitFor(t, fmt.Sprintf("%s to hold %v (has %v)", agentID, want, keys(s, agentID)), func() bool {
+		return slices.Equal(keys(s, agentID), want)
+	})
+}
+
+// replicaFeeds returns the primary's connected replica feeds
+func replicaFeeds(s *RedisServer) []*replicaFeed {
+	s.replicasMu.Lock()
+	defer s.replicasMu.Unlock()
+	var feeds []*replicaFeed
+	for feed := range s.replicas {
+		feeds = append(feeds, feed)
+	}
+	return feeds
+}
+
+func TestReplicaFullSyncAndStream(t *testing.T) {
+	primary, addr := startTestServer(t)
+	mustOK(t, primary, "HSET", "alice", "a1", "alice likes tea")
+	mustOK(t, primary, "HSET", "alice", "a2", "alice lives in Perth")
+	mustOK(t, primary, "HSET", "bob", "b1", "bob prefers email")
+
+	replica, _ := startTestServer(t, WithReplicaOf(addr))
+
+	// Full sync brings over what the primary held before the replica connected
+	waitForKeys(t, replica, "alice", "a1", "a2")
+	waitForKeys(t, replica, "bob", "b1")
+
+	// Later writes arrive over the stream, in order
+	mustOK(t, primary, "HSET", "alice", "a3", "alice has a cat")
+	mustOK(t, primary, "HDEL", "alice", "a1")
+	mustOK(t, primary, "DEL", "bob")
+	mustOK(t, primary, "HSET", "carol", "c1", "carol is new")
+	waitForKeys(t, replica, "alice", "a2", "a3")
+	waitForKeys(t, replica, "carol", "c1")
+	if got := do(replica, "EXISTS", "bob"); got != 0 {
+		t.Fatalf("EXISTS bob on replica = %v after DEL on the primary", got)
+	}
+
+	// Searches on the replica find the primary's memories
+	got, ok := do(replica, "HSEARCH", "alice", "alice has a cat", "0.3", "0.5", "1").([]string)
+	if !ok || len(got) != 1 || got[0] != "alice has a cat" {
+		t.Fatalf("HSEARCH on replica = %v", do(replica, "HSEARCH", "alice", "alice has a cat", "0.3", "0.5", "1"))
+	}
+	want := do(primary, "HSEARCH", "alice", "alice lives", "0.3", "0", "5", "WITHSCORES")
+	if got := do(replica, "HSEARCH", "alice", "alice lives", "0.3", "0", "5", "WITHSCORES"); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("replica search = %v, primary = %v", got, want)
+	}
+
+	// The replica refuses writes of its own
+	if code := replyCode(do(replica, "HSET", "alice", "x", "local write")); code != codeReadOnly {
+		t.Fatalf("HSET on replica replied %q, want %s", code, codeReadOnly)
+	}
+	info, _ := do(replica, "INFO").(bulkString)
+	if !strings.Contains(string(info), "master_link_status:up") {
+		t.Fatalf("replica INFO:\n%s", info)
+	}
+}
+
+func TestFullSyncDropsReplicaOnlyAgents(t *testing.T) {
+	primary, addr := startTestServer(t)
+	mustOK(t, primary, "HSET", "alice", "a1", "from the primary")
+
+	// Agents the replica held before following the primary are dropped
+	replica, _ := startTestServer(t)
+	mustOK(t, replica, "HSET", "stale", "s1", "only on the replica")
+	mustOK(t, replica, "HSET", "alice", "old", "replica's own alice")
+
+	host, port, _ := strings.Cut(addr, ":")
+	mustOK(t, replica, "REPLICAOF", host, port)
+	waitForKeys(t, replica, "alice", "a1")
+	if got := do(replica, "EXISTS", "stale"); got != 0 {
+		t.Fatalf("EXISTS stale = %v after full sync, want 0", got)
+	}
+
+	// REPLICAOF NO ONE keeps the synced data and allows writes again
+	mustOK(t, replica, "REPLICAOF", "NO", "ONE")
+	mustOK(t, replica, "HSET", "alice", "a2", "written after promotion")
+	waitForKeys(t, replica, "alice", "a1", "a2")
+}
+
+func TestReplicaResyncsAfterFeedOverflow(t *testing.T) {
+	primary, addr := startTestServer(t)
+	mustOK(t, primary, "HSET", "alice", "a1", "before the overflow")
+
+	replica, _ := startTestServer(t, WithReplicaOf(addr))
+	waitForKeys(t, replica, "alice", "a1")
+	feeds := replicaFeeds(primary)
+	if len(feeds) != 1 {
+		t.Fatalf("primary has %d replica feeds, want 1", len(feeds))
+	}
+	feed := feeds[0]
+
+	// Fill the replica's queue faster than the stream drains it until a
+	// propagated write finds it full and drops the replica
+	ping := [][]byte{[]byte("PING")}
+	waitFor(t, "the primary to drop the replica", func() bool {
+		// A dropped feed is closed under replicasMu, so only fill it while
+		// it is still registered
+		primary.replicasMu.Lock()
+		if _, ok := primary.replicas[feed]; ok {
+			for full := false; !full; {
+				select {
+				case feed.ch <- ping:
+				default:
+					full = true
+				}
+			}
+		}
+		primary.replicasMu.Unlock()
+		primary.propagate(ping)
+		return !slices.Contains(replicaFeeds(primary), feed)
+	})
+
+	// This write is not streamed to the dropped replica; it arrives with
+	// the full sync the replica starts when it reconnects
+	mustOK(t, primary, "HSET", "alice", "a2", "missed by the stream")
+	waitForKeys(t, replica, "alice", "a1", "a2")
+	waitFor(t, "the replica to reconnect", func() bool {
+		feeds := replicaFeeds(primary)
+		return len(feeds) == 1 && feeds[0] != feed
+	})
+
+	// And the new stream carries writes again
+	mustOK(t, primary, "HSET", "alice", "a3", "after the resync")
+	waitForKeys(t, replica, "alice", "a1", "a2", "a3")
+}
@@ -1,72 +1,433 @@
 package redis
 
 import (
+	"Hippocampus/src/agents"
+	"Hippocampus/src/cdc"
 	"Hippocampus/src/client"
 	"Hippocampus/src/embedding"
+	"Hippocampus/src/snapshot"
+	"Hippocampus/src/storage"
+	"Hippocampus/src/types"
+	"Hippocampus/src/webhook"
 	"bufio"
+	"context"
+	"crypto/tls"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"log"
 	"net"
+	"os"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
 // RedisServer implements a subset of Redis protocol for Hippocampus
 type RedisServer struct {
 	addr      string
-	listener  net.Listener
-	clients   map[string]*client.Client
-	clientsMu sync.RWMutex
+	listeners []net.Listener
+	agents    *agents.Manager // Agent clients, also loaded or restored ones
 	embedder  embedding.EmbeddingService
 	ttl       time.Duration
+	slowlog   *SlowLog
+	conns     *connRegistry
+	access    *accessTracker
+	profiles  *profileStore
+
+	agentEmbedders  *embedderStore  // Embedders set with HAGENT SET
+	embedderFactory EmbedderFactory // Optional, set by WithEmbedderFactory
+
+	attachDir string            // HAGENT ATTACH files go here; empty disables it
+	attached  map[string]string // Attached file -> agent, see forgetAttached
+
+	enableFlushAll bool // Allow FLUSHALL to delete persistent agent files
+	enableDebug    bool // Allow DEBUG commands, set by WithDebugCommands
+	limits         *limiter
+
+	maxDumpSize int64 // Largest HDUMP reply / HRESTORE payload in bytes
+
+	broadcastMaxAgents int // Most agents BROADCAST SEARCH searches; 0 means all
+
+	maxConnections int64         // Connections beyond this are refused; 0 means unlimited
+	keepAlive      time.Duration // TCP keep-alive probe period; <= 0 disables keep-alive
+	activeConns    atomic.Int64
+	rejectedConns  atomic.Int64
+
+	tlsConfig *tls.Config // Serve TCP over TLS when set
+
+	embedConcurrency int                        // Concurrent embedding calls; 0 means unlimited
+	embedQueue       int                        // Embedding calls allowed to wait for a slot
+	embedLimit       *embedding.LimitedEmbedder // Wraps embedder when embedConcurrency is set
+
+	replicationState
+	persistenceState
+	snapshotState
+	healthState
+
+	cluster *cluster // Optional, set by WithCluster
+
+	accessLog *accessLog          // Optional, set by WithAccessLog
+	webhook   *webhook.Dispatcher // Optional, set by WithWebhook
+	changeLog *cdc.Writer         // Optional, set by WithChangeLog
+	hooksMu   sync.RWMutex
+	hooks     []func(CommandEvent)
+
+	managerOpts []agents.Option // Extra agent manager options, see WithManagerOptions
+
+	unixSocket     string      // Optional Unix domain socket path
+	unixSocketPerm os.FileMode // Permissions applied to the socket file
+
+	listenersMu sync.Mutex
+	stopped     bool
+}
+
+const (
+	// defaultMaxConnections is the connection limit unless WithMaxConnections says otherwise
+	defaultMaxConnections = 1000
+
+	// defaultKeepAlive is the TCP keep-alive period unless WithTCPKeepAlive says otherwise
+	defaultKeepAlive = 60 * time.Second
+
+	// keepAliveProbes is how many unanswered keep-alive probes drop a client
+	keepAliveProbes = 3
+)
+
+// bulkString is written as a RESP bulk string, allowing newlines unlike simple strings
+type bulkString string
+
+// Option configures optional RedisServer behaviour
+type Option func(*RedisServer)
+
+// WithSlowLog records commands slower than threshold into a ring buffer of maxLen entries
+func WithSlowLog(threshold time.Duration, maxLen int) Option {
+	return func(s *RedisServer) {
+		s.slowlog = NewSlowLog(threshold, maxLen)
+	}
+}
+
+// WithFlushAll allows FLUSHALL to delete the files of persistent agents
+func WithFlushAll(enabled bool) Option {
+	return func(s *RedisServer) {
+		s.enableFlushAll = enabled
+	}
 }
 
-func NewRedisServer(addr string, embedder embedding.EmbeddingService, ttl time.Duration) *RedisServer {
-	return &RedisServer{
+// WithAgentLimits sets the default per-agent rate limit and write quota
+func WithAgentLimits(limits AgentLimits) Option {
+	return func(s *RedisServer) {
+		s.limits = newLimiter(limits)
+	}
+}
+
+// WithUnixSocket additionally listens on a Unix domain socket at path. Pass an
+// empty addr to NewRedisServer to listen only on the socket.
+func WithUnixSocket(path string, perm os.FileMode) Option {
+	return func(s *RedisServer) {
+		s.unixSocket = path
+		s.unixSocketPerm = perm
+	}
+}
+
+// WithMaxDumpSize caps HDUMP replies and HRESTORE payloads at maxBytes
+func WithMaxDumpSize(maxBytes int64) Option {
+	return func(s *RedisServer) {
+		s.maxDumpSize = maxBytes
+	}
+}
+
+// WithMaxConnections refuses new connections once n are open, so a connection
+// storm can't exhaust file descriptors. Zero means unlimited.
+func WithMaxConnections(n int) Option {
+	return func(s *RedisServer) {
+		s.maxConnections = int64(n)
+	}
+}
+
+// WithEmbedConcurrency caps concurrent embedding calls across all agents at n,
+// with up to queue more waiting. Commands beyond that fail with -BUSY. Zero n
+// means unlimited.
+func WithEmbedConcurrency(n, queue int) Option {
+	return func(s *RedisServer) {
+		s.embedConcurrency = n
+		s.embedQueue = queue
+	}
+}
+
+// WithTCPKeepAlive sets the TCP keep-alive period used to detect dead clients.
+// Zero or negative disables keep-alive.
+func WithTCPKeepAlive(interval time.Duration) Option {
+	return func(s *RedisServer) {
+		s.keepAlive = interval
+	}
+}
+
+// WithWebhook passes every change to an agent's memories to d. The caller
+// closes d once the server has stopped.
+func WithWebhook(d *webhook.Dispatcher) Option {
+	return func(s *RedisServer) {
+		s.webhook = d
+	}
+}
+
+// WithChangeLog appends every change to any agent to w. The caller closes w
+// after Stop.
+func WithChangeLog(w *cdc.Writer) Option {
+	return func(s *RedisServer) {
+		s.changeLog = w
+	}
+}
+
+// WithManagerOptions configures the agent manager further, e.g. with
+// agents.WithMaxAgents or agents.WithIdleEviction
+func WithManagerOptions(opts ...agents.Option) Option {
+	return func(s *RedisServer) {
+		s.managerOpts = append(s.managerOpts, opts...)
+	}
+}
+
+func NewRedisServer(addr string, embedder embedding.EmbeddingService, ttl time.Duration, opts ...Option) *RedisServer {
+	s := &RedisServer{
 		addr:     addr,
-		clients:  make(map[string]*client.Client),
 		embedder: embedder,
 		ttl:      ttl,
+		slowlog:  NewSlowLog(10*time.Millisecond, 128),
+		conns:    newConnRegistry(),
+		access:   newAccessTracker(),
+		profiles: newProfileStore(),
+		limits:   newLimiter(AgentLimits{}),
+
+		agentEmbedders: newEmbedderStore(),
+
+		maxDumpSize:    defaultMaxDumpSize,
+		maxConnections: defaultMaxConnections,
+		keepAlive:      defaultKeepAlive,
+
+		broadcastMaxAgents: defaultBroadcastMaxAgents,
+	}
+
+	s.replicas = make(map[*replicaFeed]struct{})
+
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	s.profiles.dir = s.dataDir
+
+	if s.embedConcurrency > 0 {
+		s.embedLimit = embedding.NewLimitedEmbedder(s.embedder, s.embedConcurrency, s.embedQueue)
+		s.embedder = s.embedLimit
+	}
+
+	managerOpts := []agents.Option{
+		agents.WithDataDir(s.dataDir),
+		agents.WithEmbedderFor(s.embedderFor),
+		agents.WithHooks(s.agentCreated, s.forgetAgent),
+		// New in-memory agents expire after -ttl like saved ones
+		agents.WithStorageFor(func(string) (storage.Storage, error) {
+			return storage.NewMemoryStorageWithTTL(s.ttl), nil
+		}),
 	}
+	if s.webhook != nil {
+		managerOpts = append(managerOpts, agents.WithChangeHook(s.webhook.Notify))
+	}
+	if s.changeLog != nil {
+		managerOpts = append(managerOpts, agents.WithChangeHook(s.changeLog.Hook()))
+	}
+	managerOpts = append(managerOpts, s.managerOpts...)
+	s.agents = agents.NewManager(s.embedder, managerOpts...)
+
+	if s.snapshotStore != nil {
+		s.snapshots = snapshot.NewScheduler(s.agents, s.snapshotStore, s.snapshotConfig)
+	}
+
+	return s
 }
 
+// Start listens on the TCP address and/or Unix socket and serves connections
+// until Stop is called
 func (s *RedisServer) Start() error {
-	listener, err := net.Listen("tcp", s.addr)
-	if err != nil {
-		return fmt.Errorf("failed to start Redis server: %w", err)
+	if s.addr == "" && s.unixSocket == "" {
+		return fmt.Errorf("failed to start Redis server: no TCP address or Unix socket configured")
+	}
+
+	if s.dataDir != "" && s.persistenceDir != "" {
+		return fmt.Errorf("failed to start Redis server: a persistence directory needs in-memory agents, not a data directory")
+	}
+
+	if s.dataDir != "" {
+		if err := os.MkdirAll(s.dataDir, 0755); err != nil {
+			return fmt.Errorf("failed to create data directory: %w", err)
+		}
+	}
+
+	if err := s.startHealth(); err != nil {
+		return err
+	}
+
+	if s.persistenceDir != "" {
+		if err := s.agents.LoadSaved(s.persistenceDir, s.ttl); err != nil {
+			return fmt.Errorf("failed to load saved agents: %w", err)
+		}
+	}
+
+	if s.addr != "" {
+		listener, err := net.Listen("tcp", s.addr)
+		if err != nil {
+			s.Stop()
+			return fmt.Errorf("failed to start Redis server: %w", err)
+		}
+		if s.tlsConfig != nil {
+			listener = tls.NewListener(listener, s.tlsConfig)
+		}
+		s.addListener(listener)
+		log.Printf("Redis-compatible server listening on %s (tls=%t)", s.addr, s.tlsConfig != nil)
+	}
+
+	if s.unixSocket != "" {
+		// A socket file left behind by a crashed server would make Listen fail
+		os.Remove(s.unixSocket)
+
+		listener, err := net.Listen("unix", s.unixSocket)
+		if err != nil {
+			s.Stop()
+			return fmt.Errorf("failed to listen on Unix socket: %w", err)
+		}
+		s.addListener(listener)
+
+		if err := os.Chmod(s.unixSocket, s.unixSocketPerm); err != nil {
+			s.Stop()
+			return fmt.Errorf("failed to set Unix socket permissions: %w", err)
+		}
+		log.Printf("Redis-compatible server listening on unix:%s", s.unixSocket)
+	}
+
+	if s.primaryAddr != "" {
+		log.Printf("Replicating from %s (read-only)", s.primaryAddr)
+		s.setPrimary(s.primaryAddr)
+	}
+
+	// Agents being preloaded answer -LOADING until they are ready
+	if s.dataDir != "" && (s.preload.All || s.preload.Recent > 0) {
+		s.preloading.Store(true)
+		go s.preloadAgents()
+	}
+
+	s.startSnapshots()
+	s.agents.StartEviction()
+
+	s.listenersMu.Lock()
+	listeners := append([]net.Listener(nil), s.listeners...)
+	s.listenersMu.Unlock()
+
+	var wg sync.WaitGroup
+	for _, listener := range listeners {
+		wg.Add(1)
+		go func(l net.Listener) {
+			defer wg.Done()
+			s.acceptLoop(l)
+		}(listener)
 	}
+	wg.Wait()
 
-	s.listener = listener
-	log.Printf("Redis-compatible server listening on %s", s.addr)
+	return nil
+}
+
+func (s *RedisServer) addListener(l net.Listener) {
+	s.listenersMu.Lock()
+	defer s.listenersMu.Unlock()
+	s.listeners = append(s.listeners, l)
+}
 
+func (s *RedisServer) acceptLoop(listener net.Listener) {
 	for {
 		conn, err := listener.Accept()
 		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
 			log.Printf("Error accepting connection: %v", err)
 			continue
 		}
 
+		if active := s.activeConns.Add(1); s.maxConnections > 0 && active > s.maxConnections {
+			conn.Write([]byte("-ERR max clients reached\r\n"))
+			conn.Close()
+			s.activeConns.Add(-1)
+			s.rejectedConns.Add(1)
+			continue
+		}
+
 		go s.handleConnection(conn)
 	}
 }
 
+// ActiveConnections returns the number of open client connections
+func (s *RedisServer) ActiveConnections() int64 {
+	return s.activeConns.Load()
+}
+
 func (s *RedisServer) handleConnection(conn net.Conn) {
+	defer s.activeConns.Add(-1)
 	defer conn.Close()
 
+	s.setKeepAlive(conn)
+
+	// Complete the TLS handshake up front so clients with a missing or
+	// untrusted certificate are dropped before any command is read
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		tlsConn.SetDeadline(time.Now().Add(tlsHandshakeTimeout))
+		if err := tlsConn.Handshake(); err != nil {
+			log.Printf("TLS handshake with %s failed: %v", conn.RemoteAddr(), err)
+			return
+		}
+		tlsConn.SetDeadline(time.Time{})
+	}
+
+	ci := s.conns.register(conn)
+	defer s.conns.unregister(ci)
+
 	reader := bufio.NewReader(conn)
 	writer := bufio.NewWriter(conn)
+	var args argBuffer
 
 	for {
-		// Read Redis protocol commands
-		cmd, err := s.readCommand(reader)
+		// Read Redis protocol commands. raw aliases args and is only valid
+		// until the next command is read.
+		raw, err := s.readCommand(reader, &args)
 		if err != nil {
+			// Tell the client why before dropping it, like Redis does
+			var protoErr *protocolError
+			if errors.As(err, &protoErr) {
+				s.writeResponse(writer, err)
+				writer.Flush()
+			}
 			return
 		}
 
-		response := s.processCommand(cmd)
+		cmd := commandStrings(raw)
+		if len(cmd) > 0 {
+			ci.touch(cmd[0])
+
+			if strings.EqualFold(cmd[0], "HSYNC") {
+				// The connection now belongs to a replica
+				s.serveReplica(ci, writer)
+				return
+			}
+		}
+
+		start := time.Now()
+		response := s.processCommand(ci, cmd, raw)
+		duration := time.Since(start)
+		if len(cmd) > 0 && !strings.EqualFold(cmd[0], "SLOWLOG") {
+			s.slowlog.Record(raw, commandAgent(cmd), conn.RemoteAddr().String(), duration)
+		}
+		s.emitCommand(ci, raw, commandAgent(cmd), start, duration, response)
+		args.release()
+
 		if err := s.writeResponse(writer, response); err != nil {
 			return
 		}
@@ -75,9 +436,40 @@ func (s *RedisServer) handleConnection(conn net.Conn) {
 	}
 }
 
-func (s *RedisServer) readCommand(reader *bufio.Reader) ([]string, error) {
+// setKeepAlive applies the keep-alive setting to TCP connections, so clients
+// that vanish without closing the connection are detected and dropped
+func (s *RedisServer) setKeepAlive(conn net.Conn) {
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		conn = tlsConn.NetConn()
+	}
+	tcpConn, ok := conn.(*net.TCPConn)
+	if !ok {
+		return // Unix socket
+	}
+
+	if s.keepAlive <= 0 {
+		tcpConn.SetKeepAlive(false)
+		return
+	}
+	// Probed after the period idle, then every third of it, as Redis does,
+	// so a vanished client is dropped within about twice the period rather
+	// than the kernel's default of minutes after it
+	tcpConn.SetKeepAliveConfig(net.KeepAliveConfig{
+		Enable:   true,
+		Idle:     s.keepAlive,
+		Interval: max(s.keepAlive/keepAliveProbes, time.Second),
+		Count:    keepAliveProbes,
+	})
+}
+
+// maxBulkLen is the largest bulk string readCommand accepts
+func (s *RedisServer) maxBulkLen() int {
+	return max(protoMaxBulkLen, int(s.maxDumpSize))
+}
+
+func (s *RedisServer) readCommand(reader *bufio.Reader, ab *argBuffer) ([][]byte, error) {
 	// Simple RESP (Redis Serialization Protocol) parser
-	line, err := reader.ReadString('\n')
+	line, err := readLine(reader, "too big inline request")
 	if err != nil {
 		return nil, err
 	}
@@ -87,45 +479,92 @@ func (s *RedisServer) readCommand(reader *bufio.Reader) ([]string, error) {
 	// Handle array format (*n\r\n)
 	if strings.HasPrefix(line, "*") {
 		count, err := strconv.Atoi(line[1:])
-		if err != nil {
-			return nil, err
+		if err != nil || count < 0 || count > maxMultiBulkLen {
+			return nil, &protocolError{msg: "invalid multibulk length"}
 		}
 
-		args := make([]string, count)
+		// Bulk strings are read back to back into ab and sliced out once
+		// all are read, since growing the buffer may move it
+		ends := make([]int, count)
 		for i := 0; i < count; i++ {
 			// Read bulk string length
-			line, err = reader.ReadString('\n')
+			line, err = readLine(reader, "too big bulk count string")
 			if err != nil {
 				return nil, err
 			}
 
 			line = strings.TrimSpace(line)
 			if !strings.HasPrefix(line, "$") {
-				return nil, fmt.Errorf("expected bulk string")
+				return nil, &protocolError{msg: fmt.Sprintf("expected '$', got %q", line)}
 			}
 
 			length, err := strconv.Atoi(line[1:])
-			if err != nil {
-				return nil, err
+			if err != nil || length < 0 || length > s.maxBulkLen() {
+				return nil, &protocolError{msg: "invalid bulk length"}
 			}
 
-			// Read bulk string content
-			buf := make([]byte, length)
-			if _, err := reader.Read(buf); err != nil {
-				return nil, err
+			// Read bulk string content a chunk at a time, growing the
+			// buffer only as the bytes arrive; a single Read may return
+			// less than asked for large payloads
+			for remaining := length; remaining > 0; {
+				n := min(remaining, bulkReadChunk)
+				buf := ab.grow(n)
+				start := len(buf)
+				buf = buf[:start+n]
+				if _, err := io.ReadFull(reader, buf[start:]); err != nil {
+					return nil, err
+				}
+				ab.buf = buf
+				remaining -= n
 			}
+			ends[i] = len(ab.buf)
 
-			args[i] = string(buf)
-
-			// Read trailing \r\n
-			reader.ReadString('\n')
+			// The bulk string must end in \r\n, or its length was wrong
+			var crlf [2]byte
+			if _, err := io.ReadFull(reader, crlf[:]); err != nil {
+				return nil, err
+			}
+			if crlf != [2]byte{'\r', '\n'} {
+				return nil, &protocolError{msg: "expected CRLF after bulk string"}
+			}
 		}
 
+		args := make([][]byte, count)
+		start := 0
+		for i, end := range ends {
+			args[i] = ab.buf[start:end:end]
+			start = end
+		}
 		return args, nil
 	}
 
-	// Handle inline commands (space-separated)
-	return strings.Fields(line), nil
+	// Handle inline commands (space-separated, with optional quoting)
+	fields, err := splitInlineArgs(line)
+	if err != nil {
+		return nil, err
+	}
+	args := make([][]byte, len(fields))
+	for i, field := range fields {
+		args[i] = []byte(field)
+	}
+	return args, nil
+}
+
+// readLine reads up to and including the next \n. A line longer than
+// maxInlineLen is a protocol error with message msg, so a client sending no
+// newline can't grow it without bound.
+func readLine(reader *bufio.Reader, msg string) (string, error) {
+	var line []byte
+	for {
+		chunk, err := reader.ReadSlice('\n')
+		if len(line)+len(chunk) > maxInlineLen {
+			return "", &protocolError{msg: msg}
+		}
+		line = append(line, chunk...)
+		if err != bufio.ErrBufferFull {
+			return string(line), err
+		}
+	}
 }
 
 func (s *RedisServer) writeResponse(writer *bufio.Writer, response interface{}) error {
@@ -134,9 +573,13 @@ func (s *RedisServer) writeResponse(writer *bufio.Writer, response interface{})
 		// Simple string: +OK\r\n
 		_, err := writer.WriteString(fmt.Sprintf("+%s\r\n", v))
 		return err
+	case bulkString:
+		// Bulk string: $len\r\ndata\r\n
+		_, err := writer.WriteString(fmt.Sprintf("$%d\r\n%s\r\n", len(v), v))
+		return err
 	case error:
-		// Error: -ERR message\r\n
-		_, err := writer.WriteString(fmt.Sprintf("-ERR %s\r\n", v.Error()))
+		// Error: -CODE message\r\n, ERR unless the error maps to a specific code
+		_, err := writer.WriteString(fmt.Sprintf("-%s\r\n", toReplyError(v).Error()))
 		return err
 	case []string:
 		// Array of strings
@@ -145,24 +588,86 @@ func (s *RedisServer) writeResponse(writer *bufio.Writer, response interface{})
 			writer.WriteString(fmt.Sprintf("$%d\r\n%s\r\n", len(s), s))
 		}
 		return nil
+	case []interface{}:
+		// Nested array: each element is written with its own type
+		writer.WriteString(fmt.Sprintf("*%d\r\n", len(v)))
+		for _, elem := range v {
+			if bulk, ok := elem.(string); ok {
+				// Strings inside nested arrays are bulk strings, as in Redis
+				writer.WriteString(fmt.Sprintf("$%d\r\n%s\r\n", len(bulk), bulk))
+				continue
+			}
+			if err := s.writeResponse(writer, elem); err != nil {
+				return err
+			}
+		}
+		return nil
 	case int:
 		// Integer: :number\r\n
 		_, err := writer.WriteString(fmt.Sprintf(":%d\r\n", v))
 		return err
+	case int64:
+		_, err := writer.WriteString(fmt.Sprintf(":%d\r\n", v))
+		return err
 	case nil:
 		// Null: $-1\r\n
 		_, err := writer.WriteString("$-1\r\n")
 		return err
+	case rawReply:
+		// Reply relayed from another cluster node, already encoded
+		_, err := writer.Write(v)
+		return err
 	default:
 		return fmt.Errorf("unknown response type")
 	}
 }
 
-func (s *RedisServer) processCommand(cmd []string) interface{} {
+// processCommand runs one command. raw holds the same arguments as cmd and is
+// the only copy of payloads listed in rawArgs.
+func (s *RedisServer) processCommand(ci *connInfo, cmd []string, raw [][]byte) interface{} {
 	if len(cmd) == 0 {
 		return fmt.Errorf("empty command")
 	}
 
+	if s.cluster != nil && !ci.replication {
+		if response, handled := s.route(cmd, raw); handled {
+			return response
+		}
+	}
+
+	write := isWriteCommand(cmd)
+	if write && !ci.replication && s.isReplica() {
+		return &replyError{code: codeReadOnly, msg: "You can't write against a read only replica."}
+	}
+
+	if agentID := commandAgent(cmd); agentID != "" {
+		if !ci.replication {
+			if err := s.limits.allowCommand(agentID); err != nil {
+				return err
+			}
+		}
+		if s.isLoading(agentID) {
+			return errLoading(agentID)
+		}
+	}
+
+	if !write {
+		return s.execCommand(ci, cmd, raw)
+	}
+
+	// Writes hold replMu so a replica's full sync never sees half of one
+	s.replMu.RLock()
+	defer s.replMu.RUnlock()
+
+	response := s.execCommand(ci, cmd, raw)
+	if _, failed := response.(error); !failed {
+		s.propagate(raw)
+	}
+	return response
+}
+
+// execCommand runs a command once processCommand has admitted it
+func (s *RedisServer) execCommand(ci *connInfo, cmd []string, raw [][]byte) interface{} {
 	command := strings.ToUpper(cmd[0])
 
 	switch command {
@@ -172,7 +677,7 @@ func (s *RedisServer) processCommand(cmd []string) interface{} {
 	case "HSET":
 		// HSET agent_id key text
 		if len(cmd) < 4 {
-			return fmt.Errorf("HSET requires 3 arguments: agent_id key text")
+			return errWrongArgs("HSET")
 		}
 		agentID := cmd[1]
 		key := cmd[2]
@@ -183,61 +688,180 @@ func (s *RedisServer) processCommand(cmd []string) interface{} {
 			return err
 		}
 
-		if err := c.Insert(key, text); err != nil {
+		release, err := s.checkWriteQuota(agentID, c)
+		if err != nil {
 			return err
 		}
+		err = c.Insert(key, text)
+		release()
+		if err != nil {
+			return err
+		}
+		s.access.record(agentID)
 
 		return "OK"
 
 	case "HSEARCH":
-		// HSEARCH agent_id query epsilon threshold topk
-		if len(cmd) < 6 {
-			return fmt.Errorf("HSEARCH requires 5 arguments: agent_id query epsilon threshold topk")
+		// HSEARCH agent_id query [epsilon [threshold [topk]]] [WITHSCORES]
+		// Omitted parameters come from the agent's HCONFIG profile
+		withScores := len(cmd) > 3 && strings.EqualFold(cmd[len(cmd)-1], "WITHSCORES")
+		if withScores {
+			cmd = cmd[:len(cmd)-1]
+		}
+		if len(cmd) < 3 || len(cmd) > 6 {
+			return errWrongArgs("HSEARCH")
 		}
 
 		agentID := cmd[1]
 		query := cmd[2]
-		epsilon, err := strconv.ParseFloat(cmd[3], 32)
+		profile, _ := s.profiles.get(agentID)
+
+		if len(cmd) > 3 {
+			epsilon, err := strconv.ParseFloat(cmd[3], 32)
+			if err != nil {
+				return fmt.Errorf("invalid epsilon: %v", err)
+			}
+			profile.Epsilon = float32(epsilon)
+		}
+		if len(cmd) > 4 {
+			threshold, err := strconv.ParseFloat(cmd[4], 32)
+			if err != nil {
+				return fmt.Errorf("invalid threshold: %v", err)
+			}
+			profile.Threshold = float32(threshold)
+		}
+		if len(cmd) > 5 {
+			topK, err := strconv.Atoi(cmd[5])
+			if err != nil {
+				return fmt.Errorf("invalid topK: %v", err)
+			}
+			profile.TopK = topK
+		}
+
+		c, err := s.getOrCreateClient(agentID)
 		if err != nil {
-			return fmt.Errorf("invalid epsilon: %v", err)
+			return err
+		}
+
+		if !withScores {
+			results, err := c.Search(query, profile.Epsilon, profile.Threshold, profile.TopK)
+			if err != nil {
+				return err
+			}
+			s.access.record(agentID)
+			return results
 		}
-		threshold, err := strconv.ParseFloat(cmd[4], 32)
+
+		results, err := c.SearchScored(query, types.SearchOptions{
+			Epsilon:   profile.Epsilon,
+			Threshold: profile.Threshold,
+			TopK:      profile.TopK,
+		})
 		if err != nil {
-			return fmt.Errorf("invalid threshold: %v", err)
+			return err
+		}
+		s.access.record(agentID)
+
+		// One [key, value, score] array per hit
+		reply := make([]interface{}, len(results))
+		for i, r := range results {
+			reply[i] = []string{r.Node.ID, r.Node.Value, strconv.FormatFloat(float64(r.Score), 'f', -1, 32)}
 		}
-		topK, err := strconv.Atoi(cmd[5])
+		return reply
+
+	case "HKEYS":
+		// HKEYS agent_id
+		if len(cmd) != 2 {
+			return errWrongArgs("HKEYS")
+		}
+
+		c, exists, err := s.getClient(cmd[1])
 		if err != nil {
-			return fmt.Errorf("invalid topK: %v", err)
+			return err
 		}
+		if !exists {
+			return []string{}
+		}
+		keys, err := c.Keys()
+		if err != nil {
+			return err
+		}
+		return keys
 
-		c, err := s.getOrCreateClient(agentID)
+	case "HEXISTS":
+		// HEXISTS agent_id key - returns 1 if the agent has a memory under key
+		if len(cmd) != 3 {
+			return errWrongArgs("HEXISTS")
+		}
+
+		c, exists, err := s.getClient(cmd[1])
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return 0
+		}
+		found, err := c.Exists(cmd[2])
 		if err != nil {
 			return err
 		}
+		if found {
+			return 1
+		}
+		return 0
 
-		results, err := c.Search(query, float32(epsilon), float32(threshold), topK)
+	case "HSIMILAR":
+		// HSIMILAR agent_id key [topk] - the memories closest to the one
+		// under key, as [key, value, score] arrays like HSEARCH WITHSCORES
+		if len(cmd) != 3 && len(cmd) != 4 {
+			return errWrongArgs("HSIMILAR")
+		}
+		agentID := cmd[1]
+		profile, _ := s.profiles.get(agentID)
+		topK := profile.TopK
+		if len(cmd) == 4 {
+			n, err := strconv.Atoi(cmd[3])
+			if err != nil || n < 0 {
+				return fmt.Errorf("invalid topK: %s", cmd[3])
+			}
+			topK = n
+		}
+
+		c, exists, err := s.getClient(agentID)
 		if err != nil {
 			return err
 		}
+		if !exists {
+			return fmt.Errorf("no memory under key %q", cmd[2])
+		}
+		results, err := c.SearchSimilarToWithOptions(cmd[2], profile.Epsilon, profile.Threshold, topK)
+		if err != nil {
+			return err
+		}
+		s.access.record(agentID)
 
-		return results
+		reply := make([]interface{}, len(results))
+		for i, r := range results {
+			reply[i] = []string{r.Node.ID, r.Node.Value, strconv.FormatFloat(float64(r.Score), 'f', -1, 32)}
+		}
+		return reply
 
 	case "HINSERT":
 		// HINSERT agent_id {"key": "k", "text": "t"}
 		if len(cmd) < 3 {
-			return fmt.Errorf("HINSERT requires 2 arguments: agent_id json_data")
+			return errWrongArgs("HINSERT")
 		}
 
 		agentID := cmd[1]
-		jsonData := cmd[2]
+		jsonData := raw[2]
 
 		var data struct {
 			Key  string `json:"key"`
 			Text string `json:"text"`
 		}
 
-		if err := json.Unmarshal([]byte(jsonData), &data); err != nil {
-			return fmt.Errorf("invalid JSON: %v", err)
+		if err := json.Unmarshal(jsonData, &data); err != nil {
+			return fmt.Errorf("invalid JSON: %w", err)
 		}
 
 		c, err := s.getOrCreateClient(agentID)
@@ -245,31 +869,90 @@ func (s *RedisServer) processCommand(cmd []string) interface{} {
 			return err
 		}
 
-		if err := c.Insert(data.Key, data.Text); err != nil {
+		release, err := s.checkWriteQuota(agentID, c)
+		if err != nil {
 			return err
 		}
+		err = c.Insert(data.Key, data.Text)
+		release()
+		if err != nil {
+			return err
+		}
+		s.access.record(agentID)
 
 		return "OK"
 
+	case "HDEL":
+		// HDEL agent_id key [key ...] - removes memories, returns how many existed
+		if len(cmd) < 3 {
+			return errWrongArgs("HDEL")
+		}
+
+		c, exists, err := s.getClient(cmd[1])
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return 0
+		}
+
+		deleted, err := c.DeleteMany(cmd[2:])
+		if err != nil {
+			return err
+		}
+		if deleted == 0 {
+			return 0
+		}
+		if err := c.Flush(); err != nil {
+			return err
+		}
+		return deleted
+
+	case "HCLEAR":
+		// HCLEAR agent_id - removes every memory but keeps the agent and its settings
+		if len(cmd) != 2 {
+			return errWrongArgs("HCLEAR")
+		}
+
+		c, exists, err := s.getClient(cmd[1])
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return "OK"
+		}
+
+		c.Clear()
+		if err := c.Flush(); err != nil {
+			return err
+		}
+		return "OK"
+
 	case "HGET":
 		// HGET agent_id query_json
 		// query_json: {"query": "text", "epsilon": 0.3, "threshold": 0.5, "top_k": 5}
+		// Omitted parameters come from the agent's HCONFIG profile
 		if len(cmd) < 3 {
-			return fmt.Errorf("HGET requires 2 arguments: agent_id query_json")
+			return errWrongArgs("HGET")
 		}
 
 		agentID := cmd[1]
-		queryJSON := cmd[2]
+		queryJSON := raw[2]
 
-		var query struct {
+		profile, _ := s.profiles.get(agentID)
+		query := struct {
 			Query     string  `json:"query"`
 			Epsilon   float32 `json:"epsilon"`
 			Threshold float32 `json:"threshold"`
 			TopK      int     `json:"top_k"`
+		}{
+			Epsilon:   profile.Epsilon,
+			Threshold: profile.Threshold,
+			TopK:      profile.TopK,
 		}
 
-		if err := json.Unmarshal([]byte(queryJSON), &query); err != nil {
-			return fmt.Errorf("invalid JSON: %v", err)
+		if err := json.Unmarshal(queryJSON, &query); err != nil {
+			return fmt.Errorf("invalid JSON: %w", err)
 		}
 
 		c, err := s.getOrCreateClient(agentID)
@@ -281,80 +964,435 @@ func (s *RedisServer) processCommand(cmd []string) interface{} {
 		if err != nil {
 			return err
 		}
+		s.access.record(agentID)
 
 		// Return as JSON array
 		jsonResults, _ := json.Marshal(results)
 		return string(jsonResults)
 
 	case "DEL":
-		// DEL agent_id - deletes/expires an agent's data
+		// DEL agent_id [agent_id ...] - deletes agents' data, returns how many existed
 		if len(cmd) < 2 {
-			return fmt.Errorf("DEL requires 1 argument: agent_id")
+			return errWrongArgs("DEL")
 		}
 
-		agentID := cmd[1]
-		s.clientsMu.Lock()
-		delete(s.clients, agentID)
-		s.clientsMu.Unlock()
-
+		// All at once, so a racing getOrCreateClient can't recreate an agent
+		// halfway through
+		removed, err := s.agents.DeleteMany(cmd[1:])
+		if err != nil {
+			return err
+		}
+		return removed
+
+	case "FLUSHALL":
+		// FLUSHALL - deletes every agent
+		var check func(string, *client.Client) error
+		if !s.enableFlushAll {
+			check = func(agentID string, c *client.Client) error {
+				// An agent not loaded yet is only on disk, so persistent
+				persistent := c == nil
+				if c != nil {
+					_, persistent = c.CurrentStorage().(*storage.FileStorage)
+				}
+				if persistent {
+					return fmt.Errorf("FLUSHALL would delete persistent agent %s; restart with -enable-flushall to allow it", agentID)
+				}
+				return nil
+			}
+		}
+		if err := s.agents.DeleteAll(check); err != nil {
+			return err
+		}
 		return "OK"
 
+	case "COPY":
+		// COPY source_agent_id destination_agent_id - clones an agent's memories
+		if len(cmd) < 3 {
+			return errWrongArgs("COPY")
+		}
+
+		srcID := cmd[1]
+		dstID := cmd[2]
+
+		src, srcExists, err := s.getClient(srcID)
+		if err != nil {
+			return err
+		}
+		_, dstExists, err := s.getClient(dstID)
+		if err != nil {
+			return err
+		}
+		if !srcExists || dstExists {
+			return 0
+		}
+
+		// The copied embeddings need the source's embedder, which the
+		// new client picks up from agentEmbedders
+		if ae, ok := s.agentEmbedders.get(srcID); ok {
+			s.agentEmbedders.set(dstID, ae)
+		}
+		dst, err := s.getOrCreateClient(dstID)
+		if err != nil {
+			s.agentEmbedders.forget(dstID)
+			return err
+		}
+
+		// Copy through the clients rather than their storages: Merge takes
+		// a deep copy of the source under its tree's lock and inserts into
+		// the destination's cached tree, so concurrent writers to either
+		// agent are neither torn nor overwritten by the copy
+		if _, err := dst.Merge(src); err != nil {
+			return err
+		}
+		if err := dst.Flush(); err != nil {
+			return err
+		}
+		if profile, ok := s.profiles.get(srcID); ok {
+			if err := s.profiles.set(dstID, profile); err != nil {
+				return err
+			}
+		}
+
+		return 1
+
 	case "EXISTS":
 		// EXISTS agent_id - check if agent has data
 		if len(cmd) < 2 {
-			return fmt.Errorf("EXISTS requires 1 argument: agent_id")
+			return errWrongArgs("EXISTS")
 		}
 
 		agentID := cmd[1]
-		s.clientsMu.RLock()
-		_, exists := s.clients[agentID]
-		s.clientsMu.RUnlock()
+		_, exists := s.agents.Loaded(agentID)
 
-		if exists {
+		if exists || s.onDisk(agentID) {
 			return 1
 		}
 		return 0
 
+	case "SNAPSHOT":
+		return s.processSnapshot(cmd)
+
+	case "BROADCAST":
+		// BROADCAST SEARCH query epsilon threshold topk
+		return s.processBroadcast(cmd)
+
+	case "SLOWLOG":
+		// SLOWLOG GET [n] | SLOWLOG LEN | SLOWLOG RESET
+		if len(cmd) < 2 {
+			return errWrongArgs("SLOWLOG")
+		}
+
+		switch strings.ToUpper(cmd[1]) {
+		case "GET":
+			count := 10 // Redis default
+			if len(cmd) > 2 {
+				n, err := strconv.Atoi(cmd[2])
+				if err != nil {
+					return fmt.Errorf("invalid count: %v", err)
+				}
+				count = n
+			}
+
+			entries := s.slowlog.Get(count)
+			reply := make([]interface{}, len(entries))
+			for i, e := range entries {
+				reply[i] = []interface{}{
+					e.ID,
+					e.Timestamp.Unix(),
+					e.Duration.Microseconds(),
+					e.Args,
+					e.ClientAddr,
+					e.Agent,
+				}
+			}
+			return reply
+
+		case "LEN":
+			return s.slowlog.Len()
+
+		case "RESET":
+			s.slowlog.Reset()
+			return "OK"
+
+		default:
+			return fmt.Errorf("unknown SLOWLOG subcommand: %s", cmd[1])
+		}
+
+	case "CLIENT":
+		return s.processClientCommand(ci, cmd)
+
+	case "HDUMP":
+		return s.processHDump(cmd)
+
+	case "HRESTORE":
+		return s.processHRestore(cmd, raw)
+
+	case "CONFIG":
+		return s.processConfigCommand(cmd)
+
+	case "OBJECT":
+		return s.processObjectCommand(cmd)
+
+	case "HCONFIG":
+		return s.processHConfig(cmd)
+
+	case "HAGENT":
+		return s.processHAgent(cmd)
+
+	case "REPLICAOF":
+		return s.processReplicaOf(cmd)
+
+	case "DEBUG":
+		return s.processDebugCommand(cmd)
+
+	case "CLUSTER":
+		// Clustered servers answer CLUSTER in route
+		return fmt.Errorf("this server has cluster support disabled")
+
 	case "INFO":
-		return "Hippocampus Redis Server v1.0"
+		return s.info()
 
 	default:
-		return fmt.Errorf("unknown command: %s", command)
+		return errUnknownCommand(cmd[0])
 	}
 }
 
-func (s *RedisServer) getOrCreateClient(agentID string) (*client.Client, error) {
-	s.clientsMu.RLock()
-	c, exists := s.clients[agentID]
-	s.clientsMu.RUnlock()
+// checkWriteQuota rejects writes once an agent stores its maximum number of
+// memories. When the agent has a limit it returns holding the agent's lock,
+// so concurrent writes can't all pass the check and overshoot it, and the
+// caller calls release once it has written. Agents without a limit aren't
+// locked, leaving their inserts and embedding calls to run in parallel.
+func (s *RedisServer) checkWriteQuota(agentID string, c *client.Client) (release func(), err error) {
+	if s.limits.limitsFor(agentID).MaxNodes <= 0 {
+		return func() {}, nil
+	}
 
-	if exists {
-		return c, nil
+	release = s.agents.LockAgent(agentID)
+	count, err := c.Count()
+	if err == nil {
+		err = s.limits.allowWrite(agentID, count)
+	}
+	if err != nil {
+		release()
+		return nil, err
 	}
+	return release, nil
+}
 
-	// Create new client with in-memory storage
-	s.clientsMu.Lock()
-	defer s.clientsMu.Unlock()
+// processConfigCommand handles CONFIG GET | SET agent-limit:<agent_id>
+func (s *RedisServer) processConfigCommand(cmd []string) interface{} {
+	if len(cmd) < 3 {
+		return errWrongArgs("CONFIG")
+	}
 
-	// Double-check after acquiring write lock
-	if c, exists := s.clients[agentID]; exists {
-		return c, nil
+	param := cmd[2]
+	agentID, ok := strings.CutPrefix(param, "agent-limit:")
+	if !ok || agentID == "" {
+		return fmt.Errorf("unsupported CONFIG parameter: %s", param)
 	}
 
-	newClient, err := client.New(s.embedder)
-	if err != nil {
-		return nil, err
+	switch strings.ToUpper(cmd[1]) {
+	case "GET":
+		return []string{param, s.limits.limitsFor(agentID).String()}
+
+	case "SET":
+		// CONFIG SET agent-limit:<id> "rate=10 burst=20 max-nodes=5000" | default
+		if len(cmd) < 4 {
+			return errWrongArgs("CONFIG|SET")
+		}
+
+		if strings.EqualFold(cmd[3], "default") {
+			s.limits.clearOverride(agentID)
+			return "OK"
+		}
+
+		limits, err := ParseAgentLimits(cmd[3], s.limits.limitsFor(agentID))
+		if err != nil {
+			return err
+		}
+		s.limits.setOverride(agentID, limits)
+		return "OK"
+
+	default:
+		return fmt.Errorf("unknown CONFIG subcommand: %s", cmd[1])
+	}
+}
+
+// processClientCommand handles CLIENT LIST | KILL | SETNAME | GETNAME
+func (s *RedisServer) processClientCommand(ci *connInfo, cmd []string) interface{} {
+	if len(cmd) < 2 {
+		return errWrongArgs("CLIENT")
+	}
+
+	switch strings.ToUpper(cmd[1]) {
+	case "LIST":
+		var sb strings.Builder
+		for _, conn := range s.conns.list() {
+			sb.WriteString(conn.String())
+			sb.WriteString("\n")
+		}
+		return bulkString(sb.String())
+
+	case "KILL":
+		// CLIENT KILL ID <id> | CLIENT KILL ADDR <addr>
+		if len(cmd) < 4 {
+			return errWrongArgs("CLIENT|KILL")
+		}
+
+		var match func(*connInfo) bool
+		switch strings.ToUpper(cmd[2]) {
+		case "ID":
+			id, err := strconv.ParseInt(cmd[3], 10, 64)
+			if err != nil {
+				return fmt.Errorf("invalid client ID: %v", err)
+			}
+			match = func(c *connInfo) bool { return c.id == id }
+		case "ADDR":
+			addr := cmd[3]
+			match = func(c *connInfo) bool { return c.addr == addr }
+		default:
+			return fmt.Errorf("unknown CLIENT KILL filter: %s", cmd[2])
+		}
+
+		return s.conns.kill(match)
+
+	case "SETNAME":
+		if len(cmd) != 3 {
+			return errWrongArgs("CLIENT|SETNAME")
+		}
+		if err := validateClientName(cmd[2]); err != nil {
+			return err
+		}
+		ci.setName(cmd[2])
+		return "OK"
+
+	case "GETNAME":
+		name := ci.getName()
+		if name == "" {
+			return nil
+		}
+		return bulkString(name)
+
+	default:
+		return fmt.Errorf("unknown CLIENT subcommand: %s", cmd[1])
+	}
+}
+
+// commandAgent returns the agent ID a command operates on, or "" for server commands
+func commandAgent(cmd []string) string {
+	if len(cmd) < 2 {
+		return ""
 	}
 
-	newClient.SetVerbose(false) // Disable verbose logging for Redis mode
-	s.clients[agentID] = newClient
+	switch strings.ToUpper(cmd[0]) {
+	case "HSET", "HSEARCH", "HSIMILAR", "HINSERT", "HGET", "HKEYS", "HEXISTS", "HDEL", "HCLEAR", "DEL", "EXISTS", "COPY", "HDUMP", "HRESTORE", "HCONFIG":
+		return cmd[1]
+	case "HAGENT":
+		// HAGENT GET | SET agent_id ...
+		if len(cmd) > 2 {
+			return cmd[2]
+		}
+	}
+	return ""
+}
+
+// agentCreated sets up the state kept for an agent once it is created or
+// loaded, reading its search profile from the data directory
+func (s *RedisServer) agentCreated(agentID string) {
+	s.access.created(agentID)
+	s.profiles.load(agentID)
+}
+
+// forgetAgent drops the settings and state kept for an agent once it is gone
+func (s *RedisServer) forgetAgent(agentID string) {
+	s.limits.forget(agentID)
+	s.access.forget(agentID)
+	s.profiles.forget(agentID)
+	s.agentEmbedders.forget(agentID)
+	s.forgetAttached(agentID)
+}
+
+// startLoading marks an agent as being loaded, returning false if it already is
+func (s *RedisServer) startLoading(agentID string) bool {
+	return s.agents.StartLoading(agentID)
+}
+
+func (s *RedisServer) finishLoading(agentID string) {
+	s.agents.FinishLoading(agentID)
+}
+
+func (s *RedisServer) isLoading(agentID string) bool {
+	return s.agents.IsLoading(agentID)
+}
+
+func (s *RedisServer) getOrCreateClient(agentID string) (*client.Client, error) {
+	c, err := s.agents.GetOrCreate(context.Background(), agentID)
+	return c, loadingReply(err)
+}
 
-	return newClient, nil
+// newClient creates an agent client, not yet registered. Its storage is the
+// agent's file when a data directory is set, otherwise in memory.
+func (s *RedisServer) newClient(agentID string) (*client.Client, error) {
+	return s.agents.NewClient(agentID)
 }
 
+// embedderFor returns the embedder set for the agent with HAGENT SET, or the
+// server's
+func (s *RedisServer) embedderFor(agentID string) embedding.EmbeddingService {
+	if ae, ok := s.agentEmbedders.get(agentID); ok {
+		return ae.embedder
+	}
+	return s.embedder
+}
+
+// Stop closes every listener, removes the Unix socket file, saves agents
+// stored in the data directory or to the persistence directory and flushes
+// the access log. /readyz fails from the moment it is called; with
+// WithShutdownDelay the listeners stay open for the delay first.
 func (s *RedisServer) Stop() error {
-	if s.listener != nil {
-		return s.listener.Close()
+	if !s.draining.Swap(true) && s.shutdownDelay > 0 {
+		log.Printf("Not ready; closing listeners in %s", s.shutdownDelay)
+		time.Sleep(s.shutdownDelay)
 	}
-	return nil
+
+	s.listenersMu.Lock()
+	defer s.listenersMu.Unlock()
+
+	if s.stopped {
+		return nil
+	}
+	s.stopped = true
+
+	var firstErr error
+	for _, listener := range s.listeners {
+		if err := listener.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	if s.unixSocket != "" {
+		if err := os.Remove(s.unixSocket); err != nil && !os.IsNotExist(err) && firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	s.stopSnapshots()
+	s.agents.StopEviction()
+
+	if err := s.flushAgents(); err != nil && firstErr == nil {
+		firstErr = err
+	}
+	if s.persistenceDir != "" {
+		if err := s.SaveAllAgents(s.persistenceDir); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	if s.accessLog != nil {
+		s.accessLog.Close()
+	}
+
+	s.stopHealthLocked()
+
+	return firstErr
 }
@@ -0,0 +1,445 @@
+package redis
+
+import (
+	"Hippocampus/src/agents"
+	"Hippocampus/src/embedding"
+	"Hippocampus/src/storage"
+	"Hippocampus/src/types"
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newTestServer returns a server on the mock embedder that isn't listening;
+// commands go through do
+func newTestServer(t testing.TB, opts ...Option) *RedisServer {
+	t.Helper()
+	s := NewRedisServer("", embedding.NewMockEmbedder(), time.Minute, opts...)
+	t.Cleanup(func() { s.Stop() })
+	return s
+}
+
+// do runs a command as a client connection would
+func do(s *RedisServer, args ...string) interface{} {
+	raw := make([][]byte, len(args))
+	for i, arg := range args {
+		raw[i] = []byte(arg)
+	}
+	return s.processCommand(&connInfo{}, args, raw)
+}
+
+// mustOK fails the test if a command replied with an error
+func mustOK(t *testing.T, s *RedisServer, args ...string) interface{} {
+	t.Helper()
+	reply := do(s, args...)
+	if err, ok := reply.(error); ok {
+		t.Fatalf("%s: %v", strings.Join(args, " "), err)
+	}
+	return reply
+}
+
+// replyCode returns the error code a reply is sent with, or "" if it isn't an error
+func replyCode(reply interface{}) string {
+	err, ok := reply.(error)
+	if !ok {
+		return ""
+	}
+	code, _, _ := strings.Cut(toReplyError(err).Error(), " ")
+	return code
+}
+
+// writeAgentFile stores an agent with one memory in dir, as a server with
+// that data directory would
+func writeAgentFile(t *testing.T, dir, agentID string) string {
+	t.Helper()
+	path, err := agents.FilePath(dir, agentID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tree := types.NewTree()
+	var key [512]float32
+	key[0] = 1
+	tree.Insert(key, "stored on disk")
+	if err := storage.NewFileStorage(path).Save(tree); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestFlushAllRefusesAgentOnlyOnDisk(t *testing.T) {
+	dir := t.TempDir()
+	path := writeAgentFile(t, dir, "alice")
+	s := newTestServer(t, WithDataDir(dir))
+
+	reply := do(s, "FLUSHALL")
+	err, ok := reply.(error)
+	if !ok || !strings.Contains(err.Error(), "persistent agent alice") {
+		t.Fatalf("FLUSHALL = %v, want an error naming alice", reply)
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Fatalf("agent file after refused FLUSHALL: %v", err)
+	}
+}
+
+func TestFlushAllRefusesLoadedFileAgent(t *testing.T) {
+	dir := t.TempDir()
+	s := newTestServer(t, WithDataDir(dir))
+	mustOK(t, s, "HSET", "bob", "k", "some text")
+
+	if _, ok := do(s, "FLUSHALL").(error); !ok {
+		t.Fatal("FLUSHALL deleted a data-directory agent without -enable-flushall")
+	}
+	if got := do(s, "EXISTS", "bob"); got != 1 {
+		t.Fatalf("EXISTS bob = %v, want 1", got)
+	}
+}
+
+func TestFlushAllDropsMemoryAgents(t *testing.T) {
+	s := newTestServer(t)
+	mustOK(t, s, "HSET", "a", "k", "text a")
+	mustOK(t, s, "HSET", "b", "k", "text b")
+
+	if got := do(s, "FLUSHALL"); got != "OK" {
+		t.Fatalf("FLUSHALL = %v, want OK", got)
+	}
+	for _, agentID := range []string{"a", "b"} {
+		if got := do(s, "EXISTS", agentID); got != 0 {
+			t.Errorf("EXISTS %s = %v after FLUSHALL, want 0", agentID, got)
+		}
+	}
+}
+
+func TestFlushAllEnabledDeletesFiles(t *testing.T) {
+	dir := t.TempDir()
+	path := writeAgentFile(t, dir, "alice")
+	s := newTestServer(t, WithDataDir(dir), WithFlushAll(true))
+
+	if got := do(s, "FLUSHALL"); got != "OK" {
+		t.Fatalf("FLUSHALL = %v, want OK", got)
+	}
+	if _, err := os.Stat(path); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("agent file after FLUSHALL: %v, want it removed", err)
+	}
+}
+
+// readArgs parses one request with readCommand
+func readArgs(s *RedisServer, request string) ([]string, *argBuffer, error) {
+	var ab argBuffer
+	raw, err := s.readCommand(bufio.NewReader(strings.NewReader(request)), &ab)
+	if err != nil {
+		return nil, &ab, err
+	}
+	args := make([]string, len(raw))
+	for i, arg := range raw {
+		args[i] = string(arg)
+	}
+	return args, &ab, nil
+}
+
+func TestReadCommand(t *testing.T) {
+	s := newTestServer(t)
+	tests := []struct {
+		name    string
+		request string
+		want    []string
+	}{
+		{"array", "*3\r\n$4\r\nHSET\r\n$1\r\na\r\n$5\r\nhello\r\n", []string{"HSET", "a", "hello"}},
+		{"empty argument", "*2\r\n$4\r\nECHO\r\n$0\r\n\r\n", []string{"ECHO", ""}},
+		{"binary argument", "*2\r\n$4\r\nECHO\r\n$4\r\na\r\nb\r\n", []string{"ECHO", "a\r\nb"}},
+		{"empty array", "*0\r\n", []string{}},
+		{"inline", "PING hello\r\n", []string{"PING", "hello"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, _, err := readArgs(s, tt.request)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Fatalf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadCommandLargeArgument(t *testing.T) {
+	s := newTestServer(t)
+	payload := strings.Repeat("x", 3*bulkReadChunk+17)
+	got, _, err := readArgs(s, fmt.Sprintf("*2\r\n$4\r\nECHO\r\n$%d\r\n%s\r\n", len(payload), payload))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 || got[1] != payload {
+		t.Fatalf("payload of %d bytes read back as %d", len(payload), len(got[len(got)-1]))
+	}
+}
+
+func TestReadCommandRejectsBadLengths(t *testing.T) {
+	s := newTestServer(t)
+	tests := []struct {
+		name    string
+		request string
+	}{
+		{"negative count", "*-1\r\n"},
+		{"non-numeric count", "*abc\r\n"},
+		{"huge count", fmt.Sprintf("*%d\r\n", maxMultiBulkLen+1)},
+		{"count overflowing int", "*99999999999999999999\r\n"},
+		{"negative bulk length", "*1\r\n$-1\r\n"},
+		{"huge bulk length", fmt.Sprintf("*1\r\n$%d\r\n", protoMaxBulkLen+1)},
+		{"non-numeric bulk length", "*1\r\n$x\r\n"},
+		{"missing bulk header", "*1\r\nPING\r\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, err := readArgs(s, tt.request)
+			var protoErr *protocolError
+			if !errors.As(err, &protoErr) {
+				t.Fatalf("err = %v, want a protocol error", err)
+			}
+		})
+	}
+}
+
+func TestReadCommandRejectsBadFraming(t *testing.T) {
+	s := newTestServer(t)
+	tests := []struct {
+		name    string
+		request string
+	}{
+		{"bulk longer than its length", "*2\r\n$4\r\nECHO\r\n$3\r\nabcd\r\n"},
+		{"bulk shorter than its length", "*2\r\n$4\r\nECHO\r\n$5\r\nabc\r\n*1\r\n"},
+		{"bulk ending in a bare newline", "*1\r\n$4\r\nPING\n*1\r\n"},
+		{"inline line too long", strings.Repeat("x", maxInlineLen+1) + "\r\n"},
+		{"inline line without newline", strings.Repeat("x", 2*maxInlineLen)},
+		{"bulk header too long", "*1\r\n$" + strings.Repeat("1", maxInlineLen) + "\r\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, err := readArgs(s, tt.request)
+			var protoErr *protocolError
+			if !errors.As(err, &protoErr) {
+				t.Fatalf("err = %v, want a protocol error", err)
+			}
+		})
+	}
+}
+
+func TestReadCommandLongInlineLine(t *testing.T) {
+	s := newTestServer(t)
+	arg := strings.Repeat("x", maxInlineLen-100)
+	got, _, err := readArgs(s, "ECHO "+arg+"\r\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 || got[1] != arg {
+		t.Fatalf("read %d arguments", len(got))
+	}
+}
+
+// BenchmarkReadCommand50MB parses a command with a 50MB argument, the size
+// of a large HRESTORE payload
+func BenchmarkReadCommand50MB(b *testing.B) {
+	s := newTestServer(b)
+	payload := bytes.Repeat([]byte("x"), 50<<20)
+	request := append([]byte(fmt.Sprintf("*2\r\n$4\r\nECHO\r\n$%d\r\n", len(payload))), payload...)
+	request = append(request, "\r\n"...)
+
+	b.SetBytes(int64(len(request)))
+	b.ReportAllocs()
+	var ab argBuffer
+	for range b.N {
+		args, err := s.readCommand(bufio.NewReader(bytes.NewReader(request)), &ab)
+		if err != nil {
+			b.Fatal(err)
+		}
+		if len(args[1]) != len(payload) {
+			b.Fatalf("read %d bytes of %d", len(args[1]), len(payload))
+		}
+		ab.release()
+	}
+}
+
+func TestReadCommandMaxBulkLenFollowsMaxDumpSize(t *testing.T) {
+	s := newTestServer(t, WithMaxDumpSize(protoMaxBulkLen+1024))
+	if got := s.maxBulkLen(); got != protoMaxBulkLen+1024 {
+		t.Fatalf("maxBulkLen = %d, want %d", got, protoMaxBulkLen+1024)
+	}
+}
+
+func TestReadCommandDoesNotPreallocateUnsentPayload(t *testing.T) {
+	s := newTestServer(t)
+	// Promises 100MB but sends 10 bytes
+	_, ab, err := readArgs(s, "*1\r\n$104857600\r\n0123456789")
+	if err == nil {
+		t.Fatal("truncated payload parsed")
+	}
+	if cap(ab.buf) > 2*bulkReadChunk {
+		t.Fatalf("buffer grew to %d bytes for 10 received", cap(ab.buf))
+	}
+}
+
+func TestMalformedRequestGetsProtocolError(t *testing.T) {
+	s := newTestServer(t)
+	server, conn := net.Pipe()
+	s.activeConns.Add(1)
+	go s.handleConnection(server)
+	defer conn.Close()
+
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	if _, err := conn.Write([]byte("*-1\r\n")); err != nil {
+		t.Fatal(err)
+	}
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(line, "-ERR Protocol error: invalid multibulk length") {
+		t.Fatalf("reply %q, want a protocol error", line)
+	}
+
+	// The server goes on serving others
+	if got := do(s, "PING"); got != "PONG" {
+		t.Fatalf("PING = %v", got)
+	}
+}
+
+func TestAttachDetachConcurrentWithEviction(t *testing.T) {
+	dir := t.TempDir()
+	s := newTestServer(t, WithAttachDir(dir), WithManagerOptions(agents.WithIdleEviction(time.Nanosecond)))
+
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+	loop := func(f func(n int)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for n := 0; ; n++ {
+				select {
+				case <-stop:
+					return
+				default:
+					f(n)
+				}
+			}
+		}()
+	}
+	for i := range 4 {
+		agentID := fmt.Sprintf("agent%d", i)
+		loop(func(n int) {
+			do(s, "HSET", agentID, fmt.Sprintf("k%d", n), "text")
+			if n%2 == 0 {
+				do(s, "HAGENT", "ATTACH", agentID, agentID+".hippo")
+			} else {
+				do(s, "HAGENT", "DETACH", agentID)
+			}
+		})
+	}
+	loop(func(n int) { do(s, "COPY", "agent0", "copy", "REPLACE") })
+	loop(func(n int) { s.agents.EvictIdle() })
+	loop(func(n int) { s.agents.Flush() })
+
+	time.Sleep(500 * time.Millisecond)
+	close(stop)
+	wg.Wait()
+
+	// Every attached file still belongs to a loaded agent stored in it
+	for path, agentID := range s.attached {
+		c, ok := s.agents.Loaded(agentID)
+		if !ok {
+			t.Errorf("%s attached to %s, which was unloaded", path, agentID)
+			continue
+		}
+		fs, ok := c.CurrentStorage().(*storage.FileStorage)
+		if !ok || fs.Path() != path {
+			t.Errorf("%s attached to %s, which isn't stored there", path, agentID)
+		}
+	}
+}
+
+func TestHSetSameTextUnderTwoKeys(t *testing.T) {
+	s := newTestServer(t)
+	mustOK(t, s, "HSET", "alice", "k1", "same text")
+	mustOK(t, s, "HSET", "alice", "k2", "same text")
+
+	keys, ok := do(s, "HKEYS", "alice").([]string)
+	if !ok || len(keys) != 2 {
+		t.Fatalf("HKEYS = %v, want k1 and k2", do(s, "HKEYS", "alice"))
+	}
+}
+
+func TestHSetOverwritesKey(t *testing.T) {
+	s := newTestServer(t)
+	mustOK(t, s, "HSET", "alice", "k", "old text")
+	mustOK(t, s, "HSET", "alice", "k", "new text")
+
+	keys, ok := do(s, "HKEYS", "alice").([]string)
+	if !ok || len(keys) != 1 {
+		t.Fatalf("HKEYS = %v, want only k", do(s, "HKEYS", "alice"))
+	}
+	c, err := s.getOrCreateClient("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if node, _, _ := c.Get("k"); node.Value != "new text" {
+		t.Fatalf("k holds %q, want the new text", node.Value)
+	}
+
+	// The same text again is a duplicate, not an overwrite
+	if reply := do(s, "HSET", "alice", "k", "new text"); !errors.Is(asError(reply), types.ErrDuplicateKey) {
+		t.Fatalf("HSET of the stored text = %v, want a duplicate error", reply)
+	}
+}
+
+// asError returns reply as an error, or nil if it isn't one
+func asError(reply interface{}) error {
+	err, _ := reply.(error)
+	return err
+}
+
+func TestCopyConcurrentWithWriters(t *testing.T) {
+	s := newTestServer(t)
+	for i := range 20 {
+		mustOK(t, s, "HSET", "src", fmt.Sprintf("k%d", i), fmt.Sprintf("memory %d", i))
+	}
+
+	for round := range 100 {
+		dstID := fmt.Sprintf("dst%d", round)
+		var wg sync.WaitGroup
+		var copied interface{}
+		wg.Add(3)
+		go func() {
+			defer wg.Done()
+			copied = do(s, "COPY", "src", dstID)
+		}()
+		go func() {
+			defer wg.Done()
+			mustOK(t, s, "HSET", dstID, "own", fmt.Sprintf("written to %s", dstID))
+		}()
+		go func() {
+			defer wg.Done()
+			mustOK(t, s, "HSET", "src", fmt.Sprintf("late%d", round), fmt.Sprintf("late memory %d", round))
+		}()
+		wg.Wait()
+
+		keys, _ := do(s, "HKEYS", dstID).([]string)
+		if !slices.Contains(keys, "own") {
+			t.Fatalf("round %d: write to %s lost to COPY (keys %v)", round, dstID, keys)
+		}
+		if copied != 1 {
+			continue
+		}
+		for i := range 20 {
+			if !slices.Contains(keys, fmt.Sprintf("k%d", i)) {
+				t.Fatalf("round %d: COPY returned 1 but %s lacks k%d (keys %v)", round, dstID, i, keys)
+			}
+		}
+	}
+}
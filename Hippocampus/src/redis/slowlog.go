@@ -0,0 +1,141 @@
+package redis
+
+import (
+	"fmt"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	// Arguments are truncated so a huge HINSERT payload can't bloat the log
+	slowLogMaxArgs   = 32
+	slowLogMaxArgLen = 64
+)
+
+// SlowLogEntry records a single command that exceeded the slowlog threshold
+type SlowLogEntry struct {
+	ID         int64
+	Timestamp  time.Time
+	Duration   time.Duration
+	Agent      string
+	Args       []string
+	ClientAddr string
+}
+
+// SlowLog is a bounded, concurrency-safe ring buffer of slow commands
+type SlowLog struct {
+	mu        sync.Mutex
+	threshold time.Duration
+	entries   []SlowLogEntry // Ring buffer, len(entries) == capacity once full
+	tail      int            // Next slot to overwrite once full, i.e. the oldest entry
+	nextID    int64
+	maxLen    int
+}
+
+// NewSlowLog creates a slow log. A negative threshold disables logging and a
+// zero threshold logs every command.
+func NewSlowLog(threshold time.Duration, maxLen int) *SlowLog {
+	if maxLen < 1 {
+		maxLen = 1
+	}
+	return &SlowLog{
+		threshold: threshold,
+		entries:   make([]SlowLogEntry, 0, maxLen),
+		maxLen:    maxLen,
+	}
+}
+
+// Record adds the command to the log if it ran for at least the threshold
+func (sl *SlowLog) Record(args [][]byte, agent string, clientAddr string, duration time.Duration) {
+	if sl.threshold < 0 || duration < sl.threshold {
+		return
+	}
+
+	entry := SlowLogEntry{
+		Timestamp:  time.Now(),
+		Duration:   duration,
+		Agent:      agent,
+		Args:       truncateArgs(args),
+		ClientAddr: clientAddr,
+	}
+
+	sl.mu.Lock()
+	defer sl.mu.Unlock()
+
+	entry.ID = sl.nextID
+	sl.nextID++
+
+	if len(sl.entries) < sl.maxLen {
+		sl.entries = append(sl.entries, entry)
+		return
+	}
+
+	// Buffer is full: overwrite the oldest entry
+	sl.entries[sl.tail] = entry
+	sl.tail = (sl.tail + 1) % sl.maxLen
+}
+
+// Get returns up to n entries, newest first. A negative n returns all entries.
+func (sl *SlowLog) Get(n int) []SlowLogEntry {
+	sl.mu.Lock()
+	defer sl.mu.Unlock()
+
+	if n < 0 || n > len(sl.entries) {
+		n = len(sl.entries)
+	}
+
+	result := make([]SlowLogEntry, 0, n)
+	for i := 0; i < n; i++ {
+		// Walk backwards from the newest entry
+		idx := (sl.tail - 1 - i + 2*len(sl.entries)) % len(sl.entries)
+		result = append(result, sl.entries[idx])
+	}
+	return result
+}
+
+// Len returns the number of entries currently in the log
+func (sl *SlowLog) Len() int {
+	sl.mu.Lock()
+	defer sl.mu.Unlock()
+	return len(sl.entries)
+}
+
+// Reset clears the log. Entry IDs keep increasing across resets, as in Redis.
+func (sl *SlowLog) Reset() {
+	sl.mu.Lock()
+	defer sl.mu.Unlock()
+	sl.entries = sl.entries[:0]
+	sl.tail = 0
+}
+
+// truncateArgs copies args into strings, truncating long ones. Only the kept
+// prefix of each argument is copied.
+func truncateArgs(args [][]byte) []string {
+	count := len(args)
+	if count > slowLogMaxArgs {
+		count = slowLogMaxArgs
+	}
+
+	truncated := make([]string, 0, count)
+	for i := 0; i < count; i++ {
+		// The last slot summarises how many arguments were dropped
+		if i == slowLogMaxArgs-1 && len(args) > slowLogMaxArgs {
+			truncated = append(truncated, fmt.Sprintf("... (%d more arguments)", len(args)-i))
+			break
+		}
+
+		arg := args[i]
+		if len(arg) > slowLogMaxArgLen {
+			// Back up to a rune boundary so multi-byte text isn't split
+			cut := slowLogMaxArgLen
+			for cut > 0 && !utf8.RuneStart(arg[cut]) {
+				cut--
+			}
+			truncated = append(truncated, fmt.Sprintf("%s... (%d more bytes)", arg[:cut], len(arg)-cut))
+			continue
+		}
+		truncated = append(truncated, string(arg))
+	}
+	return truncated
+}
@@ -0,0 +1,121 @@
+package redis
+
+import (
+	"Hippocampus/src/snapshot"
+	"context"
+	"fmt"
+	"strings"
+)
+
+// snapshotState groups the RedisServer fields for scheduled snapshots
+type snapshotState struct {
+	snapshotStore  snapshot.Store // Optional, set by WithSnapshots
+	snapshotConfig snapshot.Config
+	snapshots      *snapshot.Scheduler
+
+	snapshotCancel context.CancelFunc
+	snapshotDone   chan struct{}
+}
+
+// WithSnapshots backs every agent up to store on cfg's schedule, see
+// package snapshot. SNAPSHOT takes one at any time.
+func WithSnapshots(store snapshot.Store, cfg snapshot.Config) Option {
+	return func(s *RedisServer) {
+		s.snapshotStore = store
+		s.snapshotConfig = cfg
+	}
+}
+
+// startSnapshots runs the scheduler until stopSnapshots
+func (s *RedisServer) startSnapshots() {
+	if s.snapshots == nil || s.snapshotConfig.Interval <= 0 {
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	s.snapshotCancel = cancel
+	s.snapshotDone = make(chan struct{})
+	go func() {
+		defer close(s.snapshotDone)
+		s.snapshots.Run(ctx)
+	}()
+}
+
+// stopSnapshots stops the scheduler, abandoning a snapshot in progress
+func (s *RedisServer) stopSnapshots() {
+	if s.snapshotCancel == nil {
+		return
+	}
+	s.snapshotCancel()
+	<-s.snapshotDone
+}
+
+// processSnapshot handles SNAPSHOT [NOW] | SNAPSHOT LIST
+func (s *RedisServer) processSnapshot(cmd []string) interface{} {
+	if s.snapshots == nil {
+		return fmt.Errorf("snapshots are disabled (start the server with -snapshot-dir or -snapshot-s3)")
+	}
+
+	sub := "NOW"
+	if len(cmd) > 1 {
+		sub = strings.ToUpper(cmd[1])
+	}
+	if len(cmd) > 2 {
+		return errWrongArgs("SNAPSHOT")
+	}
+
+	switch sub {
+	case "NOW":
+		manifest, err := s.snapshots.Take(context.Background())
+		if err != nil {
+			return err
+		}
+		return manifest.Set
+
+	case "LIST":
+		sets, err := snapshot.Sets(context.Background(), s.snapshots.Store())
+		if err != nil {
+			return err
+		}
+		reply := make([]interface{}, len(sets))
+		for i, set := range sets {
+			reply[i] = set
+		}
+		return reply
+
+	default:
+		return fmt.Errorf("unknown SNAPSHOT subcommand: %s", cmd[1])
+	}
+}
+
+// writeSnapshotInfo appends the snapshots section of INFO
+func (s *RedisServer) writeSnapshotInfo(sb *strings.Builder) {
+	if s.snapshots == nil {
+		return
+	}
+	stats := s.snapshots.Stats()
+
+	sb.WriteString("# Snapshots\r\n")
+	fmt.Fprintf(sb, "snapshot_target:%s\r\n", s.snapshots.Store())
+	fmt.Fprintf(sb, "snapshot_interval_seconds:%d\r\n", int64(s.snapshotConfig.Interval.Seconds()))
+	fmt.Fprintf(sb, "snapshot_keep:%d\r\n", s.snapshotConfig.Keep)
+	fmt.Fprintf(sb, "snapshot_max_age_seconds:%d\r\n", int64(s.snapshotConfig.MaxAge.Seconds()))
+	fmt.Fprintf(sb, "snapshot_in_progress:%d\r\n", boolInt(stats.InProgress))
+	fmt.Fprintf(sb, "snapshots_taken:%d\r\n", stats.Taken)
+	fmt.Fprintf(sb, "snapshots_failed:%d\r\n", stats.Failed)
+	fmt.Fprintf(sb, "snapshot_last_set:%s\r\n", stats.LastSet)
+	if !stats.LastTime.IsZero() {
+		fmt.Fprintf(sb, "snapshot_last_time:%d\r\n", stats.LastTime.Unix())
+	}
+	fmt.Fprintf(sb, "snapshot_last_duration_ms:%.3f\r\n", float64(stats.LastDuration.Microseconds())/1000)
+	fmt.Fprintf(sb, "snapshot_last_agents:%d\r\n", stats.LastAgents)
+	fmt.Fprintf(sb, "snapshot_last_written:%d\r\n", stats.LastWritten)
+	fmt.Fprintf(sb, "snapshot_last_bytes:%d\r\n", stats.LastBytes)
+	sb.WriteString("\r\n")
+}
+
+func boolInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
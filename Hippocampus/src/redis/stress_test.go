@@ -0,0 +1,199 @@
+package redis
+
+import (
+	"Hippocampus/src/embedding"
+	"context"
+	"fmt"
+	"math/rand"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// slowEmbedder delays every embedding, standing in for a remote model, and
+// blocks texts starting with "block" until release is closed
+type slowEmbedder struct {
+	mock    *embedding.MockEmbedder
+	delay   time.Duration
+	release chan struct{}
+}
+
+func (e *slowEmbedder) GetEmbedding(ctx context.Context, text string) ([]float32, error) {
+	if strings.HasPrefix(text, "block") {
+		<-e.release
+	}
+	time.Sleep(e.delay)
+	return e.mock.GetEmbedding(ctx, text)
+}
+
+// seeded is how many memories seedAgents stores in each agent
+const seeded = 5
+
+// seedAgents stores seeded memories in each of agents agents, returning the
+// keys of each
+func seedAgents(t testing.TB, s *RedisServer, agents int) map[string][]string {
+	want := make(map[string][]string, agents)
+	for a := range agents {
+		agentID := fmt.Sprintf("agent%d", a)
+		for k := range seeded {
+			key := fmt.Sprintf("seed%d", k)
+			if err, ok := do(s, "HSET", agentID, key, fmt.Sprintf("seeded %d of %s", k, agentID)).(error); ok {
+				t.Fatal(err)
+			}
+			want[agentID] = append(want[agentID], key)
+		}
+	}
+	return want
+}
+
+// mixedLoad runs n random commands spread over the seeded agents from 100
+// goroutines at once, checking each reply, and updates want to the keys
+// each agent should hold afterwards. Some seeded keys get deleted.
+func mixedLoad(t testing.TB, s *RedisServer, want map[string][]string, n int) {
+	agents := len(want)
+
+	// Each command is fixed up front so the expected state is known; no two
+	// commands write the same key
+	type command struct {
+		args    []string
+		deletes string // Seeded key an HDEL removes
+		inserts string // Key an HSET adds
+	}
+	rng := rand.New(rand.NewSource(1))
+	commands := make([]command, n)
+	deleted := make(map[string]bool)
+	for i := range commands {
+		agentID := fmt.Sprintf("agent%d", rng.Intn(agents))
+		switch op := rng.Intn(6); op {
+		case 0, 1:
+			key := fmt.Sprintf("k%d", i)
+			commands[i] = command{args: []string{"HSET", agentID, key, fmt.Sprintf("memory %d of %s", i, agentID)}, inserts: key}
+		case 2:
+			key := fmt.Sprintf("seed%d", rng.Intn(seeded))
+			if deleted[agentID+"/"+key] {
+				commands[i] = command{args: []string{"HEXISTS", agentID, "seed0"}}
+				continue
+			}
+			deleted[agentID+"/"+key] = true
+			commands[i] = command{args: []string{"HDEL", agentID, key}, deletes: key}
+		case 3:
+			commands[i] = command{args: []string{"HSEARCH", agentID, fmt.Sprintf("seeded %d of %s", rng.Intn(seeded), agentID), "0.3", "0", "3"}}
+		case 4:
+			commands[i] = command{args: []string{"HKEYS", agentID}}
+		case 5:
+			commands[i] = command{args: []string{"EXISTS", agentID}}
+		}
+	}
+
+	var wg sync.WaitGroup
+	next := make(chan command)
+	for range 100 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for cmd := range next {
+				reply := do(s, cmd.args...)
+				if err, ok := reply.(error); ok {
+					t.Errorf("%s: %v", strings.Join(cmd.args, " "), err)
+				}
+				if cmd.deletes != "" && reply != 1 {
+					t.Errorf("%s = %v, want 1", strings.Join(cmd.args, " "), reply)
+				}
+			}
+		}()
+	}
+	for _, cmd := range commands {
+		next <- cmd
+	}
+	close(next)
+	wg.Wait()
+
+	for _, cmd := range commands {
+		agentID := cmd.args[1]
+		if cmd.inserts != "" {
+			want[agentID] = append(want[agentID], cmd.inserts)
+		}
+		if cmd.deletes != "" {
+			want[agentID] = slices.DeleteFunc(want[agentID], func(k string) bool { return k == cmd.deletes })
+		}
+	}
+}
+
+// Run with -race: 1000 mixed commands against 100 agents at once
+func TestStressManyAgents(t *testing.T) {
+	s := newTestServer(t)
+	want := seedAgents(t, s, 100)
+	mixedLoad(t, s, want, 1000)
+
+	for agentID, keys := range want {
+		got, _ := do(s, "HKEYS", agentID).([]string)
+		got = slices.Clone(got)
+		slices.Sort(got)
+		slices.Sort(keys)
+		if !slices.Equal(got, keys) {
+			t.Errorf("%s holds %v, want %v", agentID, got, keys)
+		}
+	}
+}
+
+func TestSlowAgentDoesNotBlockOthers(t *testing.T) {
+	e := &slowEmbedder{mock: embedding.NewMockEmbedder(), release: make(chan struct{})}
+	s := NewRedisServer("", e, time.Minute)
+	t.Cleanup(func() { s.Stop() })
+	mustOK(t, s, "HSET", "alice", "a1", "alice likes tea")
+	mustOK(t, s, "HSET", "bob", "b1", "bob likes coffee")
+
+	// alice's insert stalls in the embedder
+	stalled := make(chan interface{})
+	go func() { stalled <- do(s, "HSET", "alice", "a2", "block until released") }()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		commands := [][]string{
+			{"HSET", "bob", "b2", "bob has a dog"},
+			{"HSEARCH", "bob", "bob likes coffee"},
+			// Commands for alice that need no embedding aren't held up either
+			{"HEXISTS", "alice", "a1"},
+			{"HKEYS", "alice"},
+		}
+		for _, args := range commands {
+			if err, ok := do(s, args...).(error); ok {
+				t.Errorf("%s: %v", strings.Join(args, " "), err)
+			}
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("commands waited for another agent's slow insert")
+	}
+
+	close(e.release)
+	if reply := <-stalled; reply != "OK" {
+		t.Fatalf("stalled HSET = %v", reply)
+	}
+}
+
+// BenchmarkMixedCommands runs 1000 mixed commands on 100 goroutines with a
+// 1ms embedder, spread over 1 or 100 agents. With per-agent locking the
+// rate is the same or better with more agents.
+func BenchmarkMixedCommands(b *testing.B) {
+	for _, agents := range []int{1, 100} {
+		b.Run(fmt.Sprintf("agents=%d", agents), func(b *testing.B) {
+			for range b.N {
+				b.StopTimer()
+				e := &slowEmbedder{mock: embedding.NewMockEmbedder(), delay: time.Millisecond}
+				s := NewRedisServer("", e, time.Minute)
+				want := seedAgents(b, s, agents)
+				b.StartTimer()
+				mixedLoad(b, s, want, 1000)
+				b.StopTimer()
+				s.Stop()
+				b.StartTimer()
+			}
+		})
+	}
+}
@@ -0,0 +1,51 @@
+package redis
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"time"
+)
+
+// tlsHandshakeTimeout bounds how long a TLS client may take to complete the handshake
+const tlsHandshakeTimeout = 10 * time.Second
+
+// LoadTLSConfig builds the server TLS configuration from PEM files. When caFile
+// is set, clients must present a certificate signed by it (mutual TLS);
+// connections without one fail during the handshake, before any command runs.
+func LoadTLSConfig(certFile, keyFile, caFile string) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load TLS certificate: %w", err)
+	}
+
+	config := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	if caFile != "" {
+		caPEM, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read TLS CA: %w", err)
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caPEM) {
+			return nil, fmt.Errorf("no certificates found in TLS CA %s", caFile)
+		}
+
+		config.ClientCAs = pool
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	return config, nil
+}
+
+// WithTLS serves the TCP listener over TLS. The Unix socket, if any, stays plaintext.
+func WithTLS(config *tls.Config) Option {
+	return func(s *RedisServer) {
+		s.tlsConfig = config
+	}
+}
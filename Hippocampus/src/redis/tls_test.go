@@ -0,0 +1,177 @@
+package redis
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testCert is a certificate with its key, signing others when it is a CA
+type testCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	der  []byte
+}
+
+// newCert creates a certificate signed by parent, or self-signed when parent
+// is nil
+func newCert(t *testing.T, name string, parent *testCert, isCA bool) *testCert {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serial, _ := rand.Int(rand.Reader, big.NewInt(1<<62))
+	tmpl := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+	}
+	if isCA {
+		tmpl.IsCA = true
+		tmpl.BasicConstraintsValid = true
+		tmpl.KeyUsage |= x509.KeyUsageCertSign
+	}
+
+	signer, signerKey := tmpl, key
+	if parent != nil {
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCert{cert: cert, key: key, der: der}
+}
+
+// tlsCert returns c for tls.Config.Certificates
+func (c *testCert) tlsCert() tls.Certificate {
+	return tls.Certificate{Certificate: [][]byte{c.der}, PrivateKey: c.key}
+}
+
+// writePEM writes c's certificate and key to dir, returning their paths
+func (c *testCert) writePEM(t *testing.T, dir, name string) (certFile, keyFile string) {
+	t.Helper()
+	keyDER, err := x509.MarshalECPrivateKey(c.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile = filepath.Join(dir, name+".crt")
+	keyFile = filepath.Join(dir, name+".key")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile
+}
+
+// tlsPing connects with the given client certificates and sends PING,
+// returning the first reply line or the error that prevented it
+func tlsPing(addr string, ca *testCert, certs ...tls.Certificate) (string, error) {
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	conn, err := tls.DialWithDialer(&net.Dialer{Timeout: 5 * time.Second}, "tcp", addr, &tls.Config{
+		RootCAs:      roots,
+		Certificates: certs,
+	})
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	if _, err := conn.Write([]byte("*1\r\n$4\r\nPING\r\n")); err != nil {
+		return "", err
+	}
+	// With TLS 1.3 the server checks the client certificate after the
+	// client's side of the handshake is done, so a refusal shows up here
+	return bufio.NewReader(conn).ReadString('\n')
+}
+
+func TestMutualTLS(t *testing.T) {
+	dir := t.TempDir()
+	ca := newCert(t, "test CA", nil, true)
+	certFile, keyFile := newCert(t, "server", ca, false).writePEM(t, dir, "server")
+	caFile, _ := ca.writePEM(t, dir, "ca")
+
+	config, err := LoadTLSConfig(certFile, keyFile, caFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, addr := startTestServer(t, WithTLS(config))
+
+	signed := newCert(t, "client", ca, false)
+	if reply, err := tlsPing(addr, ca, signed.tlsCert()); err != nil || reply != "+PONG\r\n" {
+		t.Fatalf("client signed by the CA: %q, %v", reply, err)
+	}
+
+	// A certificate the CA didn't sign, even one claiming to be a CA, and
+	// no certificate at all are refused in the handshake
+	rogueCA := newCert(t, "test CA", nil, true)
+	for name, certs := range map[string][]tls.Certificate{
+		"self-signed":          {newCert(t, "client", nil, false).tlsCert()},
+		"signed by another CA": {newCert(t, "client", rogueCA, false).tlsCert()},
+		"none":                 nil,
+	} {
+		if reply, err := tlsPing(addr, ca, certs...); err == nil {
+			t.Errorf("client certificate %s: got %q, want the handshake to fail", name, reply)
+		}
+	}
+}
+
+func TestTLSWithoutClientCA(t *testing.T) {
+	dir := t.TempDir()
+	ca := newCert(t, "test CA", nil, true)
+	certFile, keyFile := newCert(t, "server", ca, false).writePEM(t, dir, "server")
+
+	config, err := LoadTLSConfig(certFile, keyFile, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.ClientAuth != tls.NoClientCert {
+		t.Fatalf("ClientAuth = %v without a CA", config.ClientAuth)
+	}
+	_, addr := startTestServer(t, WithTLS(config))
+
+	if reply, err := tlsPing(addr, ca); err != nil || reply != "+PONG\r\n" {
+		t.Fatalf("client without a certificate: %q, %v", reply, err)
+	}
+}
+
+func TestLoadTLSConfigErrors(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := newCert(t, "server", nil, false).writePEM(t, dir, "server")
+	notPEM := filepath.Join(dir, "empty.pem")
+	os.WriteFile(notPEM, []byte("not a certificate"), 0o600)
+
+	if _, err := LoadTLSConfig(filepath.Join(dir, "missing.crt"), keyFile, ""); err == nil {
+		t.Error("missing certificate accepted")
+	}
+	if _, err := LoadTLSConfig(certFile, keyFile, filepath.Join(dir, "missing.pem")); err == nil {
+		t.Error("missing CA accepted")
+	}
+	if _, err := LoadTLSConfig(certFile, keyFile, notPEM); err == nil {
+		t.Error("CA file without certificates accepted")
+	}
+}
@@ -0,0 +1,108 @@
+package redis
+
+import (
+	"Hippocampus/src/embedding"
+	"bufio"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// socketPath returns a Unix socket path in a fresh directory. t.TempDir
+// can exceed the 104-byte limit some systems put on socket paths.
+func socketPath(t *testing.T) string {
+	t.Helper()
+	dir, err := os.MkdirTemp("", "hippo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	return filepath.Join(dir, "hippocampus.sock")
+}
+
+// sendOver sends a command on a new connection to addr over network and
+// returns the first line of the reply
+func sendOver(t *testing.T, network, addr string, request string) string {
+	t.Helper()
+	conn, err := net.DialTimeout(network, addr, 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	if _, err := conn.Write([]byte(request)); err != nil {
+		t.Fatal(err)
+	}
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	return line
+}
+
+func TestUnixSocketAlongsideTCP(t *testing.T) {
+	path := socketPath(t)
+	s, addr := startTestServer(t, WithUnixSocket(path, 0o600))
+	waitFor(t, "the Unix socket", func() bool {
+		s.listenersMu.Lock()
+		defer s.listenersMu.Unlock()
+		return len(s.listeners) == 2
+	})
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode()&os.ModeSocket == 0 || info.Mode().Perm() != 0o600 {
+		t.Fatalf("socket file mode %v, want a socket with 0600", info.Mode())
+	}
+
+	// Both listeners share the server's agents
+	if reply := sendOver(t, "unix", path, "*4\r\n$4\r\nHSET\r\n$5\r\nalice\r\n$1\r\nk\r\n$5\r\nhello\r\n"); reply != "+OK\r\n" {
+		t.Fatalf("HSET over the socket = %q", reply)
+	}
+	if reply := sendOver(t, "tcp", addr, "*3\r\n$7\r\nHEXISTS\r\n$5\r\nalice\r\n$1\r\nk\r\n"); reply != ":1\r\n" {
+		t.Fatalf("HEXISTS over TCP = %q", reply)
+	}
+
+	if err := s.Stop(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("socket file left behind by Stop: %v", err)
+	}
+	if _, err := net.DialTimeout("unix", path, time.Second); err == nil {
+		t.Fatal("socket still accepting after Stop")
+	}
+}
+
+func TestUnixSocketOnly(t *testing.T) {
+	path := socketPath(t)
+	// A stale file from a crashed server is replaced
+	if err := os.WriteFile(path, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	s := NewRedisServer("", embedding.NewMockEmbedder(), time.Minute, WithUnixSocket(path, 0o660))
+	go s.Start()
+	t.Cleanup(func() { s.Stop() })
+	waitFor(t, "the Unix socket", func() bool {
+		s.listenersMu.Lock()
+		defer s.listenersMu.Unlock()
+		return len(s.listeners) == 1
+	})
+
+	if reply := sendOver(t, "unix", path, "*1\r\n$4\r\nPING\r\n"); reply != "+PONG\r\n" {
+		t.Fatalf("PING = %q", reply)
+	}
+}
+
+func TestStartWithoutListeners(t *testing.T) {
+	s := NewRedisServer("", embedding.NewMockEmbedder(), time.Minute)
+	if err := s.Start(); err == nil {
+		t.Fatal("started with neither an address nor a socket")
+	}
+}
@@ -0,0 +1,395 @@
+// Package redisclient is a Go client for the Hippocampus Redis protocol server
+// (src/redis). It speaks the RESP subset the server uses, pools connections and
+// retries commands that failed on transient network errors.
+package redisclient
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+const (
+	defaultPoolSize   = 10
+	defaultTimeout    = 5 * time.Second
+	defaultMaxRetries = 2
+	retryBackoff      = 50 * time.Millisecond // Doubled after each retry
+)
+
+// Server search defaults, used for fields left zero in a non-zero SearchOptions
+const (
+	defaultEpsilon   = 0.3
+	defaultThreshold = 0.5
+	defaultTopK      = 5
+)
+
+// SearchOptions are the HSEARCH parameters. The zero value uses the agent's
+// HCONFIG profile; otherwise fields left zero take the server defaults
+// (epsilon 0.3, threshold 0.5, top 5).
+type SearchOptions struct {
+	Epsilon   float32
+	Threshold float32
+	TopK      int
+}
+
+// Result is one search hit
+type Result struct {
+	Key   string
+	Value string
+	Score float32 // 0.0-1.0, 1.0 is an exact match
+}
+
+// Client is safe for concurrent use
+type Client struct {
+	addr       string
+	tlsConfig  *tls.Config
+	timeout    time.Duration
+	maxRetries int
+
+	idle chan *conn // Pooled idle connections
+}
+
+type Option func(*Client)
+
+// WithPoolSize sets how many idle connections are kept for reuse
+func WithPoolSize(n int) Option {
+	return func(c *Client) {
+		if n < 1 {
+			n = 1
+		}
+		c.idle = make(chan *conn, n)
+	}
+}
+
+// WithTimeout bounds dialing and each command when the context has no earlier deadline
+func WithTimeout(d time.Duration) Option {
+	return func(c *Client) {
+		c.timeout = d
+	}
+}
+
+// WithMaxRetries sets how many times a command is retried after a transient
+// network error. 0 disables retries.
+func WithMaxRetries(n int) Option {
+	return func(c *Client) {
+		c.maxRetries = n
+	}
+}
+
+// WithTLS connects over TLS, for servers started with -tls-cert
+func WithTLS(config *tls.Config) Option {
+	return func(c *Client) {
+		c.tlsConfig = config
+	}
+}
+
+// New returns a client for the server at addr (host:port). Connections are
+// opened on first use.
+func New(addr string, opts ...Option) *Client {
+	c := &Client{
+		addr:       addr,
+		timeout:    defaultTimeout,
+		maxRetries: defaultMaxRetries,
+		idle:       make(chan *conn, defaultPoolSize),
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// Close closes the idle connections. Connections in use are closed when returned.
+func (c *Client) Close() error {
+	for {
+		select {
+		case cn := <-c.idle:
+			cn.close()
+		default:
+			return nil
+		}
+	}
+}
+
+// Ping checks that the server is reachable
+func (c *Client) Ping(ctx context.Context) error {
+	_, err := c.do(ctx, true, "PING")
+	return err
+}
+
+// Insert stores text under key for the agent
+func (c *Client) Insert(ctx context.Context, agentID, key, text string) error {
+	_, err := c.do(ctx, false, "HSET", agentID, key, text)
+	return err
+}
+
+// Search returns the agent's memories closest to query, best first
+func (c *Client) Search(ctx context.Context, agentID, query string, opts SearchOptions) ([]Result, error) {
+	args := []string{"HSEARCH", agentID, query}
+	if opts != (SearchOptions{}) {
+		if opts.Epsilon == 0 {
+			opts.Epsilon = defaultEpsilon
+		}
+		if opts.Threshold == 0 {
+			opts.Threshold = defaultThreshold
+		}
+		if opts.TopK == 0 {
+			opts.TopK = defaultTopK
+		}
+		args = append(args,
+			strconv.FormatFloat(float64(opts.Epsilon), 'f', -1, 32),
+			strconv.FormatFloat(float64(opts.Threshold), 'f', -1, 32),
+			strconv.Itoa(opts.TopK))
+	}
+	args = append(args, "WITHSCORES")
+
+	reply, err := c.do(ctx, true, args...)
+	if err != nil {
+		return nil, err
+	}
+	return parseHits("HSEARCH", reply)
+}
+
+// SimilarTo returns the topK memories closest to the one stored under key,
+// best first, leaving it out, searching with the agent's HCONFIG epsilon and
+// threshold. Scores are on Search's scale. It fails if there is no memory
+// under key.
+func (c *Client) SimilarTo(ctx context.Context, agentID, key string, topK int) ([]Result, error) {
+	reply, err := c.do(ctx, true, "HSIMILAR", agentID, key, strconv.Itoa(topK))
+	if err != nil {
+		return nil, err
+	}
+	return parseHits("HSIMILAR", reply)
+}
+
+// parseHits decodes the [key, value, score] arrays of a search reply
+func parseHits(command string, reply interface{}) ([]Result, error) {
+	hits, ok := reply.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("redisclient: unexpected %s reply %T", command, reply)
+	}
+
+	results := make([]Result, len(hits))
+	for i, hit := range hits {
+		// Each hit is [key, value, score]
+		fields, ok := hit.([]interface{})
+		if !ok || len(fields) != 3 {
+			return nil, fmt.Errorf("redisclient: unexpected %s hit %v", command, hit)
+		}
+		key, _ := fields[0].(string)
+		value, _ := fields[1].(string)
+		scoreText, _ := fields[2].(string)
+		score, err := strconv.ParseFloat(scoreText, 32)
+		if err != nil {
+			return nil, fmt.Errorf("redisclient: invalid score %q", scoreText)
+		}
+		results[i] = Result{Key: key, Value: value, Score: float32(score)}
+	}
+	return results, nil
+}
+
+// Delete removes the memory stored under key, reporting whether it existed
+func (c *Client) Delete(ctx context.Context, agentID, key string) (bool, error) {
+	reply, err := c.do(ctx, false, "HDEL", agentID, key)
+	if err != nil {
+		return false, err
+	}
+	n, ok := reply.(int64)
+	if !ok {
+		return false, fmt.Errorf("redisclient: unexpected HDEL reply %T", reply)
+	}
+	return n > 0, nil
+}
+
+// DeleteMany removes the memories stored under keys with one HDEL,
+// returning how many existed
+func (c *Client) DeleteMany(ctx context.Context, agentID string, keys ...string) (int, error) {
+	if len(keys) == 0 {
+		return 0, nil
+	}
+	reply, err := c.do(ctx, false, append([]string{"HDEL", agentID}, keys...)...)
+	if err != nil {
+		return 0, err
+	}
+	n, ok := reply.(int64)
+	if !ok {
+		return 0, fmt.Errorf("redisclient: unexpected HDEL reply %T", reply)
+	}
+	return int(n), nil
+}
+
+// Keys returns the keys of every memory stored for the agent
+func (c *Client) Keys(ctx context.Context, agentID string) ([]string, error) {
+	reply, err := c.do(ctx, true, "HKEYS", agentID)
+	if err != nil {
+		return nil, err
+	}
+
+	elems, ok := reply.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("redisclient: unexpected HKEYS reply %T", reply)
+	}
+	keys := make([]string, len(elems))
+	for i, elem := range elems {
+		keys[i], _ = elem.(string)
+	}
+	return keys, nil
+}
+
+// Exists reports whether the agent has a memory stored under key
+func (c *Client) Exists(ctx context.Context, agentID, key string) (bool, error) {
+	reply, err := c.do(ctx, true, "HEXISTS", agentID, key)
+	if err != nil {
+		return false, err
+	}
+
+	n, ok := reply.(int64)
+	if !ok {
+		return false, fmt.Errorf("redisclient: unexpected HEXISTS reply %T", reply)
+	}
+	return n == 1, nil
+}
+
+// Do sends an arbitrary command and returns the decoded reply. It is not
+// retried once sent, since the command may not be idempotent.
+func (c *Client) Do(ctx context.Context, args ...string) (interface{}, error) {
+	return c.do(ctx, false, args...)
+}
+
+// do runs one command, retrying after transient network errors. Commands that
+// may have reached the server are retried only when idempotent.
+func (c *Client) do(ctx context.Context, idempotent bool, args ...string) (interface{}, error) {
+	backoff := retryBackoff
+
+	for attempt := 0; ; attempt++ {
+		reply, sent, err := c.roundTrip(ctx, args)
+		if err == nil {
+			if replyErr, ok := reply.(*ReplyError); ok {
+				return nil, replyErr
+			}
+			return reply, nil
+		}
+
+		if attempt >= c.maxRetries || !isTransient(err) || (sent && !idempotent) {
+			return nil, err
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// roundTrip sends args on a pooled connection and reads the reply. sent
+// reports whether the command was fully written before a failure.
+func (c *Client) roundTrip(ctx context.Context, args []string) (reply interface{}, sent bool, err error) {
+	cn, err := c.get(ctx)
+	if err != nil {
+		return nil, false, err
+	}
+
+	deadline := time.Now().Add(c.timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	cn.netConn.SetDeadline(deadline)
+
+	// Unblock reads and writes when the context is cancelled
+	stop := context.AfterFunc(ctx, func() {
+		cn.netConn.SetDeadline(time.Now())
+	})
+	defer stop()
+
+	if err := writeCommand(cn.writer, args); err != nil {
+		cn.close()
+		return nil, false, contextErr(ctx, err)
+	}
+	reply, err = readReply(cn.reader)
+	if err != nil {
+		cn.close()
+		return nil, true, contextErr(ctx, err)
+	}
+
+	c.put(cn)
+	return reply, true, nil
+}
+
+// contextErr prefers the context's error when it caused the failure
+func contextErr(ctx context.Context, err error) error {
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return ctxErr
+	}
+	return err
+}
+
+// isTransient reports whether err is a network failure worth retrying
+func isTransient(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, syscall.EPIPE) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
+
+// conn is one pooled server connection
+type conn struct {
+	netConn net.Conn
+	reader  *bufio.Reader
+	writer  *bufio.Writer
+}
+
+func (cn *conn) close() {
+	cn.netConn.Close()
+}
+
+// get returns an idle connection, or dials a new one
+func (c *Client) get(ctx context.Context) (*conn, error) {
+	select {
+	case cn := <-c.idle:
+		return cn, nil
+	default:
+	}
+
+	dialer := &net.Dialer{Timeout: c.timeout}
+	var netConn net.Conn
+	var err error
+	if c.tlsConfig != nil {
+		tlsDialer := &tls.Dialer{NetDialer: dialer, Config: c.tlsConfig}
+		netConn, err = tlsDialer.DialContext(ctx, "tcp", c.addr)
+	} else {
+		netConn, err = dialer.DialContext(ctx, "tcp", c.addr)
+	}
+	if err != nil {
+		return nil, contextErr(ctx, err)
+	}
+
+	return &conn{
+		netConn: netConn,
+		reader:  bufio.NewReader(netConn),
+		writer:  bufio.NewWriter(netConn),
+	}, nil
+}
+
+// put returns a healthy connection to the pool, closing it if the pool is full
+func (c *Client) put(cn *conn) {
+	cn.netConn.SetDeadline(time.Time{})
+	select {
+	case c.idle <- cn:
+	default:
+		cn.close()
+	}
+}
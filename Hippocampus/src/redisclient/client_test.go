@@ -0,0 +1,270 @@
+package redisclient
+
+import (
+	"Hippocampus/src/embedding"
+	"Hippocampus/src/redis"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"slices"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// startServer starts a Redis protocol server on a free localhost port,
+// returning its address once it answers PING
+func startServer(t *testing.T, embedder embedding.EmbeddingService) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+
+	s := redis.NewRedisServer(addr, embedder, time.Minute)
+	go s.Start()
+	t.Cleanup(func() { s.Stop() })
+
+	c := New(addr, WithMaxRetries(0))
+	defer c.Close()
+	for deadline := time.Now().Add(5 * time.Second); c.Ping(context.Background()) != nil; time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for the server to listen")
+		}
+	}
+	return addr
+}
+
+// newClient returns a client for addr, closed when the test ends
+func newClient(t *testing.T, addr string, opts ...Option) *Client {
+	t.Helper()
+	c := New(addr, opts...)
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+// proxy forwards connections to a server until they are cut, standing in
+// for the network between client and server
+type proxy struct {
+	listener net.Listener
+	target   string
+
+	mu    sync.Mutex
+	conns []net.Conn
+}
+
+func startProxy(t *testing.T, target string) *proxy {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &proxy{listener: l, target: target}
+	t.Cleanup(func() { l.Close(); p.cut() })
+
+	go func() {
+		for {
+			client, err := l.Accept()
+			if err != nil {
+				return
+			}
+			server, err := net.Dial("tcp", target)
+			if err != nil {
+				client.Close()
+				continue
+			}
+			p.mu.Lock()
+			p.conns = append(p.conns, client, server)
+			p.mu.Unlock()
+			go io.Copy(server, client)
+			go io.Copy(client, server)
+		}
+	}()
+	return p
+}
+
+func (p *proxy) addr() string {
+	return p.listener.Addr().String()
+}
+
+// cut closes every connection made through the proxy so far
+func (p *proxy) cut() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, conn := range p.conns {
+		conn.Close()
+	}
+	p.conns = nil
+}
+
+func TestInsertSearchDelete(t *testing.T) {
+	ctx := context.Background()
+	c := newClient(t, startServer(t, embedding.NewMockEmbedder()))
+
+	if err := c.Ping(ctx); err != nil {
+		t.Fatal(err)
+	}
+	for key, text := range map[string]string{
+		"k1": "the user prefers tea",
+		"k2": "the user lives in Perth",
+		"k3": "the user has a cat",
+	} {
+		if err := c.Insert(ctx, "alice", key, text); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	keys, err := c.Keys(ctx, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	slices.Sort(keys)
+	if !slices.Equal(keys, []string{"k1", "k2", "k3"}) {
+		t.Fatalf("Keys = %q", keys)
+	}
+	if ok, err := c.Exists(ctx, "alice", "k2"); err != nil || !ok {
+		t.Fatalf("Exists(k2) = %t, %v", ok, err)
+	}
+	if ok, err := c.Exists(ctx, "alice", "k9"); err != nil || ok {
+		t.Fatalf("Exists(k9) = %t, %v", ok, err)
+	}
+
+	results, err := c.Search(ctx, "alice", "the user lives in Perth", SearchOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) == 0 {
+		t.Fatal("no results for a stored text")
+	}
+	if got := results[0]; got.Key != "k2" || got.Value != "the user lives in Perth" || got.Score < 0.99 {
+		t.Fatalf("best result %+v, want k2 scoring 1", got)
+	}
+	for i := 1; i < len(results); i++ {
+		if results[i].Score > results[i-1].Score {
+			t.Fatalf("results not best first: %+v", results)
+		}
+	}
+
+	if ok, err := c.Delete(ctx, "alice", "k2"); err != nil || !ok {
+		t.Fatalf("Delete(k2) = %t, %v", ok, err)
+	}
+	if ok, err := c.Delete(ctx, "alice", "k2"); err != nil || ok {
+		t.Fatalf("Delete(k2) again = %t, %v", ok, err)
+	}
+	if n, err := c.DeleteMany(ctx, "alice", "k1", "k2", "k3"); err != nil || n != 2 {
+		t.Fatalf("DeleteMany = %d, %v, want 2", n, err)
+	}
+	if keys, err := c.Keys(ctx, "alice"); err != nil || len(keys) != 0 {
+		t.Fatalf("Keys after deleting all = %q, %v", keys, err)
+	}
+}
+
+func TestSimilarTo(t *testing.T) {
+	ctx := context.Background()
+	embedder := embedding.NewMockEmbedder()
+	near := func(i int, by float32) []float32 {
+		e := make([]float32, 512)
+		e[i] = 1
+		e[(i+1)%512] = by
+		return e
+	}
+	embedder.SetResponse("tea", near(0, 0))
+	embedder.SetResponse("green tea", near(0, 0.1))
+	embedder.SetResponse("black tea", near(0, 0.2))
+	embedder.SetResponse("cats", near(300, 0))
+	c := newClient(t, startServer(t, embedder))
+
+	for _, text := range []string{"tea", "green tea", "black tea", "cats"} {
+		if err := c.Insert(ctx, "alice", text, text); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	results, err := c.SimilarTo(ctx, "alice", "tea", 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var keys []string
+	for _, r := range results {
+		keys = append(keys, r.Key)
+	}
+	if !slices.Equal(keys, []string{"green tea", "black tea"}) {
+		t.Fatalf("SimilarTo(tea) = %q, want the other teas, closest first", keys)
+	}
+
+	if _, err := c.SimilarTo(ctx, "alice", "coffee", 5); err == nil {
+		t.Fatal("SimilarTo succeeded for a missing key")
+	}
+}
+
+func TestReplyError(t *testing.T) {
+	c := newClient(t, startServer(t, embedding.NewMockEmbedder()))
+
+	_, err := c.Do(context.Background(), "NOPE")
+	var replyErr *ReplyError
+	if !errors.As(err, &replyErr) {
+		t.Fatalf("Do(NOPE) = %v, want a ReplyError", err)
+	}
+	if replyErr.Code != "ERR" {
+		t.Fatalf("code %q, want ERR", replyErr.Code)
+	}
+
+	// An error reply leaves the connection usable
+	if err := c.Ping(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestContextCanceled(t *testing.T) {
+	c := newClient(t, startServer(t, embedding.NewMockEmbedder()))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := c.Ping(ctx); !errors.Is(err, context.Canceled) {
+		t.Fatalf("Ping with a canceled context = %v", err)
+	}
+}
+
+func TestRetryAfterDroppedConnection(t *testing.T) {
+	ctx := context.Background()
+	p := startProxy(t, startServer(t, embedding.NewMockEmbedder()))
+	c := newClient(t, p.addr(), WithPoolSize(1))
+
+	// Leave a pooled connection for the proxy to drop
+	if err := c.Insert(ctx, "alice", "k1", "hello"); err != nil {
+		t.Fatal(err)
+	}
+	p.cut()
+
+	// Reads are retried on a new connection
+	if ok, err := c.Exists(ctx, "alice", "k1"); err != nil || !ok {
+		t.Fatalf("Exists after the connection dropped = %t, %v", ok, err)
+	}
+	p.cut()
+
+	// A write that may have reached the server is not
+	if err := c.Insert(ctx, "alice", "k2", "world"); err == nil {
+		t.Fatal("Insert retried after the connection dropped")
+	}
+	if ok, err := c.Exists(ctx, "alice", "k2"); err != nil || ok {
+		t.Fatalf("Exists(k2) = %t, %v", ok, err)
+	}
+}
+
+func TestNoServer(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+
+	c := newClient(t, addr, WithMaxRetries(1))
+	if err := c.Ping(context.Background()); !errors.Is(err, syscall.ECONNREFUSED) {
+		t.Fatalf("Ping with no server = %v, want connection refused", err)
+	}
+}
@@ -0,0 +1,95 @@
+package redisclient
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// ReplyError is an error reply from the server, e.g. "-OOM max memories
+// reached". Code is the first word, so callers can branch on it.
+type ReplyError struct {
+	Code    string
+	Message string
+}
+
+func (e *ReplyError) Error() string {
+	return e.Code + " " + e.Message
+}
+
+func parseReplyError(line string) *ReplyError {
+	code, msg, _ := strings.Cut(line, " ")
+	return &ReplyError{Code: code, Message: msg}
+}
+
+// writeCommand writes args as a RESP array of bulk strings
+func writeCommand(w *bufio.Writer, args []string) error {
+	fmt.Fprintf(w, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(w, "$%d\r\n", len(arg))
+		w.WriteString(arg)
+		w.WriteString("\r\n")
+	}
+	return w.Flush()
+}
+
+// readReply reads one reply. Simple and bulk strings are returned as string,
+// integers as int64, arrays as []interface{}, nil bulk strings as nil and
+// error replies as *ReplyError.
+func readReply(r *bufio.Reader) (interface{}, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 || !strings.HasSuffix(line, "\r\n") {
+		return nil, errors.New("redisclient: malformed reply")
+	}
+	line = line[:len(line)-2]
+
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+
+	case '-':
+		return parseReplyError(line[1:]), nil
+
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+
+	case '$':
+		length, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, fmt.Errorf("redisclient: invalid bulk length: %w", err)
+		}
+		if length < 0 {
+			return nil, nil
+		}
+		buf := make([]byte, length+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		return string(buf[:length]), nil
+
+	case '*':
+		count, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, fmt.Errorf("redisclient: invalid array length: %w", err)
+		}
+		if count < 0 {
+			return nil, nil
+		}
+		elems := make([]interface{}, count)
+		for i := range elems {
+			if elems[i], err = readReply(r); err != nil {
+				return nil, err
+			}
+		}
+		return elems, nil
+
+	default:
+		return nil, fmt.Errorf("redisclient: unexpected reply type %q", line[0])
+	}
+}
@@ -0,0 +1,90 @@
+package serve
+
+import (
+	"Hippocampus/src/agents"
+	"Hippocampus/src/cdc"
+	"Hippocampus/src/embedding"
+	"Hippocampus/src/httpapi"
+	"Hippocampus/src/webhook"
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// RunHTTPWithParser parses args as HTTP server flags with parse, then serves
+// the REST API until SIGINT or SIGTERM, returning once requests in flight are
+// done and agents are saved
+func RunHTTPWithParser(name string, args []string, parse func(fs *flag.FlagSet, args []string)) error {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	addr := fs.String("addr", ":8081", "HTTP listen address")
+	embedFlags := embedding.RegisterFlags(fs)
+	dataDir := fs.String("data-dir", "", "Store each agent in a file in this directory (default: in memory)")
+	shutdownTimeout := fs.Duration("shutdown-timeout", 10*time.Second, "How long to wait for requests in flight at shutdown")
+	webhookFlags := webhook.RegisterFlags(fs)
+	cdcFlags := cdc.RegisterFlags(fs)
+	agentFlags := agents.RegisterFlags(fs)
+	parse(fs, args)
+
+	embedder, err := embedFlags.New()
+	if err != nil {
+		return err
+	}
+	log.Printf("Using %s", embedFlags)
+
+	if *dataDir != "" {
+		if err := os.MkdirAll(*dataDir, 0755); err != nil {
+			return fmt.Errorf("failed to create data directory: %w", err)
+		}
+	}
+	managerOpts, err := agentFlags.Options()
+	if err != nil {
+		return err
+	}
+	if agentFlags.MaxAgents > 0 || agentFlags.IdleEvict > 0 {
+		log.Printf("Keeping %s", agentFlags)
+	}
+	managerOpts = append(managerOpts, agents.WithDataDir(*dataDir))
+	hooks, err := webhookFlags.New()
+	if err != nil {
+		return err
+	}
+	if hooks != nil {
+		log.Printf("Sending %s", webhookFlags)
+		managerOpts = append(managerOpts, agents.WithChangeHook(hooks.Notify))
+		defer closeWebhook(hooks)
+	}
+	changeLog, err := cdcFlags.New()
+	if err != nil {
+		return err
+	}
+	if changeLog != nil {
+		log.Printf("Writing %s", cdcFlags)
+		managerOpts = append(managerOpts, agents.WithChangeHook(changeLog.Hook()))
+		defer closeChangeLog(changeLog)
+	}
+	manager := agents.NewManager(embedder, managerOpts...)
+	server := httpapi.NewServer(*addr, manager)
+	manager.StartEviction()
+
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM)
+	stopped := make(chan error, 1)
+	go func() {
+		sig := <-sigCh
+		log.Printf("Received %s, shutting down", sig)
+		ctx, cancel := context.WithTimeout(context.Background(), *shutdownTimeout)
+		defer cancel()
+		stopped <- server.Shutdown(ctx)
+	}()
+
+	if err := server.Start(); err != nil {
+		return err
+	}
+	// Start returns once Shutdown stops the listener; wait for it to finish
+	return <-stopped
+}
@@ -0,0 +1,98 @@
+package serve
+
+import (
+	"Hippocampus/src/agents"
+	"Hippocampus/src/client"
+	"Hippocampus/src/embedding"
+	"Hippocampus/src/mcp"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// RunMCPWithParser parses args as MCP server flags with parse, then serves
+// MCP over stdin and stdout until stdin closes or SIGINT or SIGTERM arrives,
+// returning once the memories are saved. Logs go to stderr, which MCP
+// clients keep apart from the protocol. With -sse-addr, MCP is served over
+// HTTP with server-sent events instead, until a signal arrives.
+func RunMCPWithParser(name string, args []string, parse func(fs *flag.FlagSet, args []string)) error {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	binary := fs.String("binary", "tree.bin", "Database file, used when -agent is not set")
+	agent := fs.String("agent", "", "Serve this agent's file in -data-dir instead of -binary")
+	dataDir := fs.String("data-dir", ".", "Directory of agent files for -agent")
+	sseAddr := fs.String("sse-addr", "", "Serve the HTTP+SSE transport on this address instead of stdio, e.g. localhost:8082")
+	embedFlags := embedding.RegisterFlags(fs)
+	parse(fs, args)
+
+	path, label := *binary, *binary
+	if *agent != "" {
+		var err error
+		if path, err = agents.FilePath(*dataDir, *agent); err != nil {
+			return err
+		}
+		if err := os.MkdirAll(*dataDir, 0755); err != nil {
+			return fmt.Errorf("failed to create data directory: %w", err)
+		}
+		label = *agent
+	}
+
+	embedder, err := embedFlags.New()
+	if err != nil {
+		return err
+	}
+	log.Printf("Using %s", embedFlags)
+
+	c, err := client.NewWithFileStorage(path, embedder)
+	if err != nil {
+		return fmt.Errorf("failed to create client: %w", err)
+	}
+	// Stdout carries the protocol
+	c.SetVerbose(false)
+	c.SetLogOutput(os.Stderr)
+
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM)
+	done := make(chan error, 1)
+	server := mcp.NewServer(c, label)
+
+	var httpServer *http.Server
+	if *sseAddr != "" {
+		listener, err := net.Listen("tcp", *sseAddr)
+		if err != nil {
+			return fmt.Errorf("failed to start MCP SSE listener: %w", err)
+		}
+		httpServer = &http.Server{Handler: server.SSEHandler(), ReadHeaderTimeout: 10 * time.Second}
+		go func() {
+			if err := httpServer.Serve(listener); !errors.Is(err, http.ErrServerClosed) {
+				done <- err
+			}
+		}()
+		log.Printf("Serving MCP over SSE on http://%s/sse for %s", listener.Addr(), path)
+	} else {
+		go func() {
+			done <- server.Serve(os.Stdin, os.Stdout)
+		}()
+		log.Printf("Serving MCP on stdio for %s", path)
+	}
+
+	select {
+	case err = <-done:
+	case sig := <-sigCh:
+		log.Printf("Received %s, shutting down", sig)
+	}
+	// Event streams never finish on their own, so they are cut rather than drained
+	if httpServer != nil {
+		httpServer.Close()
+	}
+	if flushErr := c.Flush(); err == nil {
+		err = flushErr
+	}
+	return err
+}
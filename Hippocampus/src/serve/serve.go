@@ -0,0 +1,245 @@
+// Package serve runs the Redis protocol server from command-line flags. It
+// backs both the standalone hippocampus-server binary and "hippocampus serve".
+package serve
+
+import (
+	"Hippocampus/src/agents"
+	"Hippocampus/src/cdc"
+	"Hippocampus/src/embedding"
+	"Hippocampus/src/redis"
+	"Hippocampus/src/snapshot"
+	"Hippocampus/src/webhook"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Run parses args as server flags under the command name, then serves until
+// SIGINT or SIGTERM, returning once agents are saved
+func Run(name string, args []string) error {
+	return RunWithParser(name, args, func(fs *flag.FlagSet, args []string) { fs.Parse(args) })
+}
+
+// RunWithParser is Run with the flag parsing done by parse, so a caller can
+// fill flags from other sources such as a config file
+func RunWithParser(name string, args []string, parse func(fs *flag.FlagSet, args []string)) error {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	addr := fs.String("addr", ":6379", "Redis server address (default :6379, empty disables TCP)")
+	unixSocket := fs.String("unixsocket", "", "Also listen on this Unix domain socket path")
+	unixSocketPerm := fs.String("unixsocketperm", "700", "Unix socket permissions (octal)")
+	embedFlags := embedding.RegisterFlags(fs)
+	ttl := fs.Duration("ttl", 5*time.Minute, "Data TTL (default 5m)")
+	slowlogThreshold := fs.Duration("slowlog-threshold", 10*time.Millisecond, "Log commands slower than this (negative disables)")
+	slowlogMaxLen := fs.Int("slowlog-max-len", 128, "Maximum number of slowlog entries kept")
+	agentRate := fs.Float64("agent-rate-limit", 0, "Max commands/sec per agent (0 = unlimited)")
+	agentBurst := fs.Int("agent-rate-burst", 0, "Per-agent burst size (default: the rate limit)")
+	agentMaxNodes := fs.Int("agent-max-nodes", 0, "Max memories stored per agent (0 = unlimited)")
+	maxDumpSize := fs.Int64("max-dump-size", 256<<20, "Largest HDUMP reply / HRESTORE payload in bytes")
+	broadcastMaxAgents := fs.Int("broadcast-max-agents", 1000, "Most agents one BROADCAST SEARCH searches (0 = all)")
+	embedConcurrency := fs.Int("embed-concurrency", 0, "Max concurrent embedding calls across all agents (0 = unlimited)")
+	embedQueue := fs.Int("embed-queue", 1000, "Embedding calls that may wait for a slot before commands fail with -BUSY")
+	maxConnections := fs.Int("max-connections", 1000, "Maximum concurrent client connections (0 = unlimited)")
+	tlsCert := fs.String("tls-cert", "", "TLS certificate file (PEM); enables TLS on the TCP listener")
+	tlsKey := fs.String("tls-key", "", "TLS private key file (PEM)")
+	tlsCA := fs.String("tls-ca", "", "CA file (PEM) for verifying client certificates; enables mutual TLS")
+	accessLogPath := fs.String("access-log", "", "Write a JSON line per command to this file (\"-\" for stdout)")
+	accessLogQueue := fs.Int("access-log-queue", 4096, "Access log events buffered before dropping")
+	tcpKeepAlive := fs.Duration("tcp-keepalive", 60*time.Second, "TCP keep-alive period for detecting dead clients (0 disables)")
+	healthAddr := fs.String("health-addr", "", "Serve HTTP /healthz, /livez and /readyz on this address (empty disables)")
+	shutdownDelay := fs.Duration("shutdown-delay", 0, "On SIGINT/SIGTERM, fail /readyz and keep serving this long before closing listeners")
+	dataDir := fs.String("data-dir", "", "Store each agent in a file in this directory (default: in memory with -ttl)")
+	preload := fs.String("preload", "lazy", "Agents to load from -data-dir at startup: lazy, all or recent=N")
+	attachDir := fs.String("attach-dir", "", "Allow HAGENT ATTACH to store agents in files under this directory")
+	persistenceDir := fs.String("persistence-dir", "", "Save in-memory agents to this directory at shutdown and load them at startup")
+	replicaOf := fs.String("replicaof", "", "Run as a read-only replica of the primary at host:port")
+	clusterNodes := fs.String("cluster-nodes", "", "Shard agents across these nodes (comma-separated host:port)")
+	clusterSelf := fs.String("cluster-self", "", "This server's own entry in -cluster-nodes; empty runs a proxy that stores no agents")
+	clusterMode := fs.String("cluster-mode", "proxy", "How to handle agents on other nodes: proxy or redirect (-MOVED)")
+	enableFlushAll := fs.Bool("enable-flushall", false, "Allow FLUSHALL to delete persistent agent files")
+	enableDebug := fs.Bool("enable-debug-commands", false, "Allow DEBUG SLEEP/OBJECT/RELOAD (for testing only)")
+	webhookFlags := webhook.RegisterFlags(fs)
+	snapshotFlags := snapshot.RegisterFlags(fs)
+	cdcFlags := cdc.RegisterFlags(fs)
+	agentFlags := agents.RegisterFlags(fs)
+
+	parse(fs, args)
+
+	perm, err := strconv.ParseUint(*unixSocketPerm, 8, 32)
+	if err != nil {
+		return fmt.Errorf("invalid -unixsocketperm %q: %w", *unixSocketPerm, err)
+	}
+
+	preloadPolicy, err := redis.ParsePreloadPolicy(*preload)
+	if err != nil {
+		return fmt.Errorf("invalid -preload: %w", err)
+	}
+
+	opts := []redis.Option{
+		redis.WithSlowLog(*slowlogThreshold, *slowlogMaxLen),
+		redis.WithFlushAll(*enableFlushAll),
+		redis.WithDebugCommands(*enableDebug),
+		redis.WithUnixSocket(*unixSocket, os.FileMode(perm)),
+		redis.WithMaxDumpSize(*maxDumpSize),
+		redis.WithBroadcastMaxAgents(*broadcastMaxAgents),
+		redis.WithMaxConnections(*maxConnections),
+		redis.WithEmbedConcurrency(*embedConcurrency, *embedQueue),
+		redis.WithTCPKeepAlive(*tcpKeepAlive),
+		redis.WithHealthAddr(*healthAddr),
+		redis.WithShutdownDelay(*shutdownDelay),
+		redis.WithReplicaOf(*replicaOf),
+		redis.WithDataDir(*dataDir),
+		redis.WithPreload(preloadPolicy),
+		redis.WithPersistenceDir(*persistenceDir),
+		redis.WithAttachDir(*attachDir),
+		redis.WithEmbedderFactory(embedFlags.NewAt),
+		redis.WithAgentLimits(redis.AgentLimits{
+			Rate:     *agentRate,
+			Burst:    *agentBurst,
+			MaxNodes: *agentMaxNodes,
+		}),
+	}
+
+	if *tlsCert != "" || *tlsKey != "" || *tlsCA != "" {
+		if *tlsCert == "" || *tlsKey == "" {
+			return fmt.Errorf("-tls-cert and -tls-key are required for TLS")
+		}
+		tlsConfig, err := redis.LoadTLSConfig(*tlsCert, *tlsKey, *tlsCA)
+		if err != nil {
+			return fmt.Errorf("invalid TLS configuration: %w", err)
+		}
+		opts = append(opts, redis.WithTLS(tlsConfig))
+	}
+
+	if *accessLogPath != "" {
+		var w io.Writer = os.Stdout
+		if *accessLogPath != "-" {
+			f, err := os.OpenFile(*accessLogPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0640)
+			if err != nil {
+				return fmt.Errorf("failed to open access log: %w", err)
+			}
+			defer f.Close()
+			w = f
+		}
+		opts = append(opts, redis.WithAccessLog(w, *accessLogQueue))
+	}
+
+	if *clusterNodes != "" {
+		var nodes []string
+		for _, node := range strings.Split(*clusterNodes, ",") {
+			if node = strings.TrimSpace(node); node != "" {
+				nodes = append(nodes, node)
+			}
+		}
+		if *clusterSelf != "" && !slices.Contains(nodes, *clusterSelf) {
+			return fmt.Errorf("-cluster-self %s is not in -cluster-nodes", *clusterSelf)
+		}
+		if *clusterMode != "proxy" && *clusterMode != "redirect" {
+			return fmt.Errorf("invalid -cluster-mode %q (expected proxy or redirect)", *clusterMode)
+		}
+		opts = append(opts, redis.WithCluster(nodes, *clusterSelf, *clusterMode == "redirect"))
+	}
+
+	embedder, err := embedFlags.New()
+	if err != nil {
+		return err
+	}
+	log.Printf("Using %s", embedFlags)
+
+	hooks, err := webhookFlags.New()
+	if err != nil {
+		return err
+	}
+	if hooks != nil {
+		log.Printf("Sending %s", webhookFlags)
+		opts = append(opts, redis.WithWebhook(hooks))
+		defer closeWebhook(hooks)
+	}
+
+	changeLog, err := cdcFlags.New()
+	if err != nil {
+		return err
+	}
+	if changeLog != nil {
+		log.Printf("Writing %s", cdcFlags)
+		opts = append(opts, redis.WithChangeLog(changeLog))
+		defer closeChangeLog(changeLog)
+	}
+
+	snapshots, err := snapshotFlags.Store()
+	if err != nil {
+		return err
+	}
+	if snapshots != nil {
+		cfg, err := snapshotFlags.Config()
+		if err != nil {
+			return err
+		}
+		if cfg.Interval > 0 {
+			log.Printf("Snapshotting agents to %s every %s", snapshots, cfg.Interval)
+		} else {
+			log.Printf("Snapshotting agents to %s on SNAPSHOT only", snapshots)
+		}
+		opts = append(opts, redis.WithSnapshots(snapshots, cfg))
+	}
+
+	agentOpts, err := agentFlags.Options()
+	if err != nil {
+		return err
+	}
+	if agentFlags.MaxAgents > 0 || agentFlags.IdleEvict > 0 {
+		log.Printf("Keeping %s", agentFlags)
+	}
+	opts = append(opts, redis.WithManagerOptions(agentOpts...))
+
+	server := redis.NewRedisServer(*addr, embedder, *ttl, opts...)
+
+	// Close listeners (and remove the Unix socket file) on SIGINT/SIGTERM
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM)
+	stopped := make(chan struct{})
+	go func() {
+		sig := <-sigCh
+		log.Printf("Received %s, shutting down", sig)
+		server.Stop()
+		close(stopped)
+	}()
+
+	log.Printf("Starting Hippocampus Redis server on %s with TTL=%s", *addr, *ttl)
+	if err := server.Start(); err != nil {
+		return err
+	}
+	// Start returns once Stop closes the listeners; wait for it to save agents
+	<-stopped
+	return nil
+}
+
+// closeChangeLog syncs and closes the change log, logging where it ended
+func closeChangeLog(w *cdc.Writer) {
+	if err := w.Close(); err != nil {
+		log.Printf("Closing change log failed: %v", err)
+	}
+	log.Printf("Change log closed at sequence %d", w.Stats().Seq)
+}
+
+// webhookDrainTimeout is how long shutdown waits for queued webhooks
+const webhookDrainTimeout = 10 * time.Second
+
+// closeWebhook delivers the webhooks still queued, giving up after
+// webhookDrainTimeout, and logs the totals
+func closeWebhook(d *webhook.Dispatcher) {
+	ctx, cancel := context.WithTimeout(context.Background(), webhookDrainTimeout)
+	defer cancel()
+	d.Close(ctx)
+
+	stats := d.Stats()
+	log.Printf("Webhooks: %d delivered, %d failed, %d dropped", stats.Delivered, stats.Failed, stats.Dropped)
+}
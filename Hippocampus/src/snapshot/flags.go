@@ -0,0 +1,69 @@
+package snapshot
+
+import (
+	"flag"
+	"fmt"
+	"time"
+)
+
+// TargetFlags choose the store snapshots are written to and restored from
+type TargetFlags struct {
+	Dir        string
+	S3         string
+	S3Endpoint string
+}
+
+// RegisterTargetFlags adds -snapshot-dir, -snapshot-s3 and
+// -snapshot-s3-endpoint to fs
+func RegisterTargetFlags(fs *flag.FlagSet) *TargetFlags {
+	t := &TargetFlags{}
+	t.register(fs)
+	return t
+}
+
+func (t *TargetFlags) register(fs *flag.FlagSet) {
+	fs.StringVar(&t.Dir, "snapshot-dir", "", "Write snapshots to this directory")
+	fs.StringVar(&t.S3, "snapshot-s3", "", "Write snapshots to this S3 bucket or bucket/prefix")
+	fs.StringVar(&t.S3Endpoint, "snapshot-s3-endpoint", "", "S3-compatible endpoint URL for -snapshot-s3 (default: AWS)")
+}
+
+// Store opens the store the flags name, or returns nil if neither
+// -snapshot-dir nor -snapshot-s3 is set
+func (t *TargetFlags) Store() (Store, error) {
+	switch {
+	case t.Dir != "" && t.S3 != "":
+		return nil, fmt.Errorf("-snapshot-dir and -snapshot-s3 are mutually exclusive")
+	case t.Dir != "":
+		return NewDirStore(t.Dir), nil
+	case t.S3 != "":
+		return NewS3Store(t.S3, t.S3Endpoint)
+	}
+	return nil, nil
+}
+
+// Flags are the snapshot flags of the Redis server
+type Flags struct {
+	TargetFlags
+	Interval time.Duration
+	Keep     int
+	MaxAge   time.Duration
+}
+
+// RegisterFlags adds the target flags and -snapshot-interval,
+// -snapshot-keep and -snapshot-max-age to fs
+func RegisterFlags(fs *flag.FlagSet) *Flags {
+	f := &Flags{}
+	f.register(fs)
+	fs.DurationVar(&f.Interval, "snapshot-interval", 15*time.Minute, "Time between snapshots (0 takes them only on SNAPSHOT)")
+	fs.IntVar(&f.Keep, "snapshot-keep", 96, "Snapshot sets kept (0 keeps all)")
+	fs.DurationVar(&f.MaxAge, "snapshot-max-age", 0, "Prune snapshot sets older than this (0 keeps them)")
+	return f
+}
+
+// Config returns the schedule and retention the flags describe
+func (f *Flags) Config() (Config, error) {
+	if f.Interval < 0 || f.Keep < 0 || f.MaxAge < 0 {
+		return Config{}, fmt.Errorf("-snapshot-interval, -snapshot-keep and -snapshot-max-age must be non-negative")
+	}
+	return Config{Interval: f.Interval, Keep: f.Keep, MaxAge: f.MaxAge}, nil
+}
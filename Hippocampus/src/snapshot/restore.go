@@ -0,0 +1,98 @@
+package snapshot
+
+import (
+	"Hippocampus/src/agents"
+	"Hippocampus/src/storage"
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// Latest names the newest set in Restore
+const Latest = "latest"
+
+// Restore rebuilds dir from a set, writing each agent's file and removing
+// the files of agents not in it. Every object is read and checked before
+// dir is touched. A dir already holding agent files is refused unless
+// replace is set. The server using dir must be stopped.
+func Restore(ctx context.Context, store Store, set, dir string, replace bool) (*Manifest, error) {
+	if set == "" || set == Latest {
+		sets, err := Sets(ctx, store)
+		if err != nil {
+			return nil, err
+		}
+		if len(sets) == 0 {
+			return nil, fmt.Errorf("no snapshots in %s", store)
+		}
+		set = sets[0]
+	}
+	manifest, err := ReadManifest(ctx, store, set)
+	if err != nil {
+		return nil, fmt.Errorf("set %s: %w", set, err)
+	}
+
+	existing, err := agents.Files(dir)
+	if err != nil {
+		return nil, err
+	}
+	if len(existing) > 0 && !replace {
+		return nil, fmt.Errorf("%s already holds %d agents (restore with replace to overwrite them)", dir, len(existing))
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	// Stage every agent next to its file, so nothing is replaced unless all
+	// of them arrive intact
+	staged := make(map[string]string, len(manifest.Agents))
+	defer func() {
+		for _, tmp := range staged {
+			os.Remove(tmp)
+		}
+	}()
+	for _, entry := range manifest.Agents {
+		path, err := agents.FilePath(dir, entry.Agent)
+		if err != nil {
+			return nil, err
+		}
+		data, err := store.Get(ctx, entry.Object)
+		if err != nil {
+			return nil, fmt.Errorf("agent %s: %w", entry.Agent, err)
+		}
+		if _, err := storage.ReadTree(bytes.NewReader(data)); err != nil {
+			return nil, fmt.Errorf("agent %s: %s is damaged: %w", entry.Agent, entry.Object, err)
+		}
+		if err := os.WriteFile(path+".restore", data, 0644); err != nil {
+			return nil, err
+		}
+		staged[entry.Agent] = path + ".restore"
+	}
+
+	for agentID, tmp := range staged {
+		path, _ := agents.FilePath(dir, agentID)
+		if err := os.Rename(tmp, path); err != nil {
+			return nil, err
+		}
+		delete(staged, agentID)
+		os.Remove(storage.IndexPath(path)) // Stale: it indexes the replaced file
+	}
+
+	restoredIDs := make(map[string]bool, len(manifest.Agents))
+	for _, entry := range manifest.Agents {
+		restoredIDs[entry.Agent] = true
+	}
+	for _, a := range existing {
+		if restoredIDs[a.ID] {
+			continue
+		}
+		path := filepath.Join(dir, a.ID+agents.FileExt)
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+		os.Remove(storage.IndexPath(path))
+	}
+	return manifest, nil
+}
@@ -0,0 +1,169 @@
+package snapshot
+
+import (
+	"Hippocampus/src/awsauth"
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// S3Store keeps objects in an S3 bucket under a prefix. Requests are signed
+// with the credentials of the standard AWS environment variables.
+type S3Store struct {
+	Bucket   string
+	Prefix   string // Without a trailing slash; may be empty
+	Region   string
+	Endpoint string // Empty for AWS; otherwise an S3-compatible server, addressed path-style
+	Creds    awsauth.Credentials
+
+	HTTPClient *http.Client
+}
+
+// NewS3Store parses "bucket" or "bucket/prefix". An empty endpoint means
+// AWS, in the region of AWS_REGION or AWS_DEFAULT_REGION (default us-east-1).
+func NewS3Store(location, endpoint string) (*S3Store, error) {
+	bucket, prefix, _ := strings.Cut(location, "/")
+	if bucket == "" {
+		return nil, fmt.Errorf("invalid S3 location %q (expected bucket or bucket/prefix)", location)
+	}
+	creds, err := awsauth.FromEnv()
+	if err != nil {
+		return nil, fmt.Errorf("S3 snapshots: %w", err)
+	}
+	return &S3Store{
+		Bucket:     bucket,
+		Prefix:     strings.Trim(prefix, "/"),
+		Region:     awsauth.RegionFromEnv(),
+		Endpoint:   strings.TrimSuffix(endpoint, "/"),
+		Creds:      creds,
+		HTTPClient: &http.Client{Timeout: 5 * time.Minute},
+	}, nil
+}
+
+// key returns the S3 key of an object
+func (s3 *S3Store) key(name string) string {
+	if s3.Prefix == "" {
+		return name
+	}
+	return s3.Prefix + "/" + name
+}
+
+// url returns the URL of key, or of the bucket when key is empty
+func (s3 *S3Store) url(key string) string {
+	segments := strings.Split(key, "/")
+	for i, segment := range segments {
+		segments[i] = awsauth.URIEncode(segment)
+	}
+	path := "/" + strings.Join(segments, "/")
+	if key == "" {
+		path = "/"
+	}
+
+	if s3.Endpoint != "" {
+		return s3.Endpoint + "/" + awsauth.URIEncode(s3.Bucket) + strings.TrimSuffix(path, "/")
+	}
+	return "https://" + s3.Bucket + ".s3." + s3.Region + ".amazonaws.com" + path
+}
+
+// do sends a signed request, returning the body of a 2xx response
+func (s3 *S3Store) do(ctx context.Context, method, rawURL string, body []byte) ([]byte, int, error) {
+	req, err := http.NewRequestWithContext(ctx, method, rawURL, bytes.NewReader(body))
+	if err != nil {
+		return nil, 0, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/octet-stream")
+	}
+	awsauth.Sign(req, body, "s3", s3.Region, s3.Creds, time.Now())
+
+	resp, err := s3.HTTPClient.Do(req)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, resp.StatusCode, err
+	}
+	if resp.StatusCode/100 != 2 {
+		return nil, resp.StatusCode, fmt.Errorf("S3 %s %s: status %d: %s", method, req.URL.Path, resp.StatusCode, bytes.TrimSpace(data))
+	}
+	return data, resp.StatusCode, nil
+}
+
+func (s3 *S3Store) Put(ctx context.Context, name string, data []byte) error {
+	if data == nil {
+		data = []byte{}
+	}
+	_, _, err := s3.do(ctx, "PUT", s3.url(s3.key(name)), data)
+	return err
+}
+
+func (s3 *S3Store) Get(ctx context.Context, name string) ([]byte, error) {
+	data, status, err := s3.do(ctx, "GET", s3.url(s3.key(name)), nil)
+	if status == http.StatusNotFound {
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, name)
+	}
+	return data, err
+}
+
+// listResult is the part of a ListObjectsV2 response used
+type listResult struct {
+	Contents []struct {
+		Key string `xml:"Key"`
+	} `xml:"Contents"`
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+}
+
+// List pages through ListObjectsV2 under the prefix
+func (s3 *S3Store) List(ctx context.Context) ([]string, error) {
+	prefix := ""
+	if s3.Prefix != "" {
+		prefix = s3.Prefix + "/"
+	}
+
+	var names []string
+	token := ""
+	for {
+		query := url.Values{"list-type": {"2"}, "prefix": {prefix}}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+		data, _, err := s3.do(ctx, "GET", s3.url("")+"?"+query.Encode(), nil)
+		if err != nil {
+			return nil, err
+		}
+
+		var result listResult
+		if err := xml.Unmarshal(data, &result); err != nil {
+			return nil, fmt.Errorf("invalid S3 list response: %w", err)
+		}
+		for _, obj := range result.Contents {
+			names = append(names, strings.TrimPrefix(obj.Key, prefix))
+		}
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			return names, nil
+		}
+		token = result.NextContinuationToken
+	}
+}
+
+func (s3 *S3Store) Delete(ctx context.Context, name string) error {
+	_, status, err := s3.do(ctx, "DELETE", s3.url(s3.key(name)), nil)
+	if status == http.StatusNotFound {
+		return nil
+	}
+	return err
+}
+
+func (s3 *S3Store) String() string {
+	return "s3://" + s3.Bucket + "/" + s3.Prefix
+}
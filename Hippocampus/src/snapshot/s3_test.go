@@ -0,0 +1,323 @@
+package snapshot
+
+import (
+	"Hippocampus/src/agents"
+	"Hippocampus/src/embedding"
+	"Hippocampus/src/storage"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// s3Stub is an in-memory S3 bucket served path-style, enough of the API for
+// S3Store: PUT, GET and DELETE of objects, and ListObjectsV2 paged pageSize
+// keys at a time
+type s3Stub struct {
+	t        *testing.T
+	bucket   string
+	pageSize int
+
+	mu      sync.Mutex
+	objects map[string][]byte
+}
+
+func startS3Stub(t *testing.T, bucket string) (*s3Stub, string) {
+	stub := &s3Stub{t: t, bucket: bucket, pageSize: 2, objects: make(map[string][]byte)}
+	srv := httptest.NewServer(stub)
+	t.Cleanup(srv.Close)
+	return stub, srv.URL
+}
+
+func (s *s3Stub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+
+	// Every request is signed for S3, with the payload hash S3 checks
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "AWS4-HMAC-SHA256 Credential=AKID/") || !strings.Contains(auth, "/us-east-1/s3/aws4_request") {
+		s.t.Errorf("%s %s: Authorization %q", r.Method, r.URL, auth)
+		http.Error(w, "AccessDenied", http.StatusForbidden)
+		return
+	}
+	sum := sha256.Sum256(body)
+	if r.Header.Get("X-Amz-Content-Sha256") != hex.EncodeToString(sum[:]) {
+		http.Error(w, "XAmzContentSHA256Mismatch", http.StatusBadRequest)
+		return
+	}
+
+	bucket, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	if bucket != s.bucket {
+		http.Error(w, "NoSuchBucket", http.StatusNotFound)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch {
+	case r.Method == "GET" && key == "":
+		s.list(w, r)
+	case r.Method == "PUT":
+		s.objects[key] = body
+	case r.Method == "GET":
+		data, ok := s.objects[key]
+		if !ok {
+			http.Error(w, "NoSuchKey", http.StatusNotFound)
+			return
+		}
+		w.Write(data)
+	case r.Method == "DELETE":
+		delete(s.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "MethodNotAllowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *s3Stub) list(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	if query.Get("list-type") != "2" {
+		http.Error(w, "only ListObjectsV2", http.StatusBadRequest)
+		return
+	}
+	var keys []string
+	for key := range s.objects {
+		if strings.HasPrefix(key, query.Get("prefix")) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	start, _ := strconv.Atoi(query.Get("continuation-token"))
+	end := min(start+s.pageSize, len(keys))
+
+	var result listResult
+	for _, key := range keys[start:end] {
+		result.Contents = append(result.Contents, struct {
+			Key string `xml:"Key"`
+		}{key})
+	}
+	if end < len(keys) {
+		result.IsTruncated = true
+		result.NextContinuationToken = strconv.Itoa(end)
+	}
+	xml.NewEncoder(w).Encode(struct {
+		XMLName xml.Name `xml:"ListBucketResult"`
+		listResult
+	}{listResult: result})
+}
+
+// keys returns the object keys stored, sorted
+func (s *s3Stub) keys() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var keys []string
+	for key := range s.objects {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func newS3Store(t *testing.T, location, endpoint string) *S3Store {
+	t.Helper()
+	t.Setenv("AWS_ACCESS_KEY_ID", "AKID")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+	t.Setenv("AWS_SESSION_TOKEN", "")
+	t.Setenv("AWS_REGION", "us-east-1")
+	store, err := NewS3Store(location, endpoint)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return store
+}
+
+func TestS3Store(t *testing.T) {
+	ctx := context.Background()
+	stub, endpoint := startS3Stub(t, "snaps")
+	store := newS3Store(t, "snaps/prod/", endpoint+"/")
+
+	for _, name := range []string{"set1/a b.bin", "set1/manifest.json", "set2/a.bin"} {
+		if err := store.Put(ctx, name, []byte("data "+name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Objects live under the prefix, names escaped in the path
+	if keys := stub.keys(); !slices.Equal(keys, []string{"prod/set1/a b.bin", "prod/set1/manifest.json", "prod/set2/a.bin"}) {
+		t.Fatalf("bucket holds %q", keys)
+	}
+
+	// Listing pages through the results and strips the prefix
+	names, err := store.List(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(names, []string{"set1/a b.bin", "set1/manifest.json", "set2/a.bin"}) {
+		t.Fatalf("List = %q", names)
+	}
+
+	if data, err := store.Get(ctx, "set1/a b.bin"); err != nil || string(data) != "data set1/a b.bin" {
+		t.Fatalf("Get = %q, %v", data, err)
+	}
+	if _, err := store.Get(ctx, "set9/a.bin"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Get of a missing object = %v, want ErrNotFound", err)
+	}
+	if err := store.Delete(ctx, "set2/a.bin"); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Delete(ctx, "set2/a.bin"); err != nil {
+		t.Fatalf("deleting a missing object: %v", err)
+	}
+	if keys := stub.keys(); len(keys) != 2 {
+		t.Fatalf("bucket holds %q after Delete", keys)
+	}
+}
+
+func TestS3StoreURL(t *testing.T) {
+	aws := newS3Store(t, "snaps/a/b", "")
+	if got := aws.url(aws.key("set/x.bin")); got != "https://snaps.s3.us-east-1.amazonaws.com/a/b/set/x.bin" {
+		t.Errorf("AWS object URL %s", got)
+	}
+	if got := aws.url(""); got != "https://snaps.s3.us-east-1.amazonaws.com/" {
+		t.Errorf("AWS bucket URL %s", got)
+	}
+	if got := aws.String(); got != "s3://snaps/a/b" {
+		t.Errorf("String() = %s", got)
+	}
+
+	if _, err := NewS3Store("/prefix", ""); err == nil {
+		t.Error("NewS3Store accepted a location without a bucket")
+	}
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "")
+	if _, err := NewS3Store("snaps", ""); err == nil {
+		t.Error("NewS3Store succeeded without credentials")
+	}
+}
+
+// insertInto stores memories in an agent, creating it if needed
+func insertInto(t *testing.T, m *agents.Manager, agentID string, keys ...string) {
+	t.Helper()
+	c, err := m.GetOrCreate(context.Background(), agentID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range keys {
+		if err := c.Insert(key, "memory "+key+" of "+agentID); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// agentKeys returns the keys stored in the agent file of dir, sorted
+func agentKeys(t *testing.T, dir, agentID string) []string {
+	t.Helper()
+	tree, err := storage.NewFileStorage(filepath.Join(dir, agentID+agents.FileExt)).Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids := tree.IDs()
+	sort.Strings(ids)
+	return ids
+}
+
+func TestSnapshotRoundTripThroughS3(t *testing.T) {
+	ctx := context.Background()
+	stub, endpoint := startS3Stub(t, "snaps")
+	store := newS3Store(t, "snaps/prod", endpoint)
+
+	m := agents.NewManager(embedding.NewMockEmbedder(), agents.WithDataDir(t.TempDir()))
+	insertInto(t, m, "alice", "a1", "a2")
+	insertInto(t, m, "bob", "b1")
+	s := NewScheduler(m, store, Config{Keep: 1})
+
+	first, err := s.Take(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(first.Agents) != 2 {
+		t.Fatalf("first set %+v", first)
+	}
+
+	// Only the changed agent is uploaded again; bob's entry points into
+	// the first set, which pruning keeps for it
+	insertInto(t, m, "alice", "a3")
+	second, err := s.Take(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats := s.Stats(); stats.LastWritten != 1 || stats.LastAgents != 2 {
+		t.Fatalf("stats %+v, want 1 of 2 agents written", stats)
+	}
+	for _, entry := range second.Agents {
+		wantSet := map[string]string{"alice": second.Set, "bob": first.Set}[entry.Agent]
+		if !strings.HasPrefix(entry.Object, wantSet+"/") {
+			t.Errorf("%s is stored as %s, want it in set %s", entry.Agent, entry.Object, wantSet)
+		}
+	}
+	want := []string{
+		"prod/" + first.Set + "/bob" + agents.FileExt,
+		"prod/" + second.Set + "/alice" + agents.FileExt,
+		"prod/" + second.Set + "/" + ManifestName,
+	}
+	sort.Strings(want)
+	if keys := stub.keys(); !slices.Equal(keys, want) {
+		t.Fatalf("bucket holds %q after pruning, want %q", keys, want)
+	}
+
+	dir := t.TempDir()
+	restored, err := Restore(ctx, store, Latest, dir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if restored.Set != second.Set {
+		t.Fatalf("restored set %s, want the latest, %s", restored.Set, second.Set)
+	}
+	if keys := agentKeys(t, dir, "alice"); !slices.Equal(keys, []string{"a1", "a2", "a3"}) {
+		t.Errorf("alice restored with %q", keys)
+	}
+	if keys := agentKeys(t, dir, "bob"); !slices.Equal(keys, []string{"b1"}) {
+		t.Errorf("bob restored with %q", keys)
+	}
+
+	// A directory already holding agents is refused without replace
+	if _, err := Restore(ctx, store, Latest, dir, false); err == nil {
+		t.Fatal("Restore overwrote agents without replace")
+	}
+	if _, err := Restore(ctx, store, Latest, dir, true); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRestoreRefusesDamagedObject(t *testing.T) {
+	ctx := context.Background()
+	stub, endpoint := startS3Stub(t, "snaps")
+	store := newS3Store(t, "snaps", endpoint)
+
+	m := agents.NewManager(embedding.NewMockEmbedder())
+	insertInto(t, m, "alice", "a1")
+	manifest, err := NewScheduler(m, store, Config{}).Take(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stub.mu.Lock()
+	stub.objects[manifest.Agents[0].Object] = []byte("not a tree")
+	stub.mu.Unlock()
+
+	dir := t.TempDir()
+	if _, err := Restore(ctx, store, manifest.Set, dir, false); err == nil || !strings.Contains(err.Error(), "damaged") {
+		t.Fatalf("Restore of a damaged object = %v", err)
+	}
+	if files, _ := agents.Files(dir); len(files) != 0 {
+		t.Fatalf("a failed restore wrote %v", files)
+	}
+}
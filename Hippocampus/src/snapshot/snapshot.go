@@ -0,0 +1,442 @@
+// Package snapshot backs up every agent of an agents.Manager to a Store on a
+// schedule. Each run writes a set named by its UTC time: the agents changed
+// since the last run, and a manifest listing every agent with the object
+// holding its latest copy, which may be in an earlier set. A set is complete
+// once its manifest is written, and any set can be restored on its own.
+package snapshot
+
+import (
+	"Hippocampus/src/agents"
+	"Hippocampus/src/client"
+	"Hippocampus/src/storage"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// errAgentGone is returned by serialize for an agent deleted since the
+// snapshot listed it
+var errAgentGone = errors.New("agent deleted")
+
+// ManifestName is the object in each set listing its agents
+const ManifestName = "manifest.json"
+
+// setTimeFormat names sets; names sort in time order
+const setTimeFormat = "20060102T150405Z"
+
+// Manifest lists the agents of a set
+type Manifest struct {
+	Set    string          `json:"set"`
+	Time   time.Time       `json:"time"`
+	Agents []ManifestEntry `json:"agents"`
+}
+
+// ManifestEntry is one agent in a set
+type ManifestEntry struct {
+	Agent  string `json:"agent"`
+	Object string `json:"object"` // Store name of the agent's file, in this set or an earlier one
+	Bytes  int64  `json:"bytes"`
+}
+
+// Config controls the schedule and retention. Zero values disable the limit.
+type Config struct {
+	Interval time.Duration
+	Keep     int           // Sets kept; older ones are pruned
+	MaxAge   time.Duration // Sets older than this are pruned
+}
+
+// Stats describe the snapshots taken since the scheduler started
+type Stats struct {
+	Taken        int64
+	Failed       int64
+	InProgress   bool
+	LastSet      string
+	LastTime     time.Time
+	LastDuration time.Duration
+	LastAgents   int   // Agents in the last set
+	LastWritten  int   // Of those, agents changed since the set before
+	LastBytes    int64 // Bytes written by the last set, manifest included
+}
+
+// agentCopy is the latest stored copy of an agent
+type agentCopy struct {
+	object  string
+	bytes   int64
+	version string // Changes whenever the agent may have
+}
+
+// Scheduler takes snapshots of a manager's agents into a store
+type Scheduler struct {
+	agents *agents.Manager
+	store  Store
+	cfg    Config
+
+	mu     sync.Mutex // Held for a whole snapshot, so runs don't overlap
+	copies map[string]agentCopy
+	last   *Manifest // Newest set written
+
+	statsMu sync.Mutex
+	stats   Stats
+}
+
+func NewScheduler(m *agents.Manager, store Store, cfg Config) *Scheduler {
+	return &Scheduler{
+		agents: m,
+		store:  store,
+		cfg:    cfg,
+		copies: make(map[string]agentCopy),
+	}
+}
+
+// Store returns where snapshots are written
+func (s *Scheduler) Store() Store {
+	return s.store
+}
+
+func (s *Scheduler) Config() Config {
+	return s.cfg
+}
+
+func (s *Scheduler) Stats() Stats {
+	s.statsMu.Lock()
+	defer s.statsMu.Unlock()
+	return s.stats
+}
+
+// Run takes a snapshot every interval until ctx is done, skipping intervals
+// in which no agent changed. Failures are logged and retried at the next
+// tick.
+func (s *Scheduler) Run(ctx context.Context) {
+	if s.cfg.Interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(s.cfg.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := s.take(ctx, false); err != nil && ctx.Err() == nil {
+				log.Printf("Snapshot failed: %v", err)
+			}
+		}
+	}
+}
+
+// Take writes a set now, even if no agent changed, then prunes old sets,
+// returning the manifest
+func (s *Scheduler) Take(ctx context.Context) (*Manifest, error) {
+	return s.take(ctx, true)
+}
+
+// take writes a set unless nothing changed since the last one and force is
+// unset, in which case it returns a nil manifest
+func (s *Scheduler) take(ctx context.Context, force bool) (*Manifest, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.statsMu.Lock()
+	s.stats.InProgress = true
+	s.statsMu.Unlock()
+
+	start := time.Now()
+	manifest, written, bytesWritten, err := s.write(ctx, start, force)
+	duration := time.Since(start)
+
+	s.statsMu.Lock()
+	s.stats.InProgress = false
+	if err == nil && manifest == nil {
+		s.statsMu.Unlock()
+		return nil, nil
+	}
+	if err != nil {
+		s.stats.Failed++
+	} else {
+		s.stats.Taken++
+		s.stats.LastSet = manifest.Set
+		s.stats.LastTime = manifest.Time
+		s.stats.LastDuration = duration
+		s.stats.LastAgents = len(manifest.Agents)
+		s.stats.LastWritten = written
+		s.stats.LastBytes = bytesWritten
+	}
+	s.statsMu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("Snapshot %s: %d agents, %d written (%d bytes) in %s",
+		manifest.Set, len(manifest.Agents), written, bytesWritten, duration.Round(time.Millisecond))
+
+	if err := s.prune(ctx, start); err != nil {
+		log.Printf("Pruning snapshots failed: %v", err)
+	}
+	return manifest, nil
+}
+
+// write stores the changed agents and the manifest of a new set
+func (s *Scheduler) write(ctx context.Context, now time.Time, force bool) (*Manifest, int, int64, error) {
+	set := now.UTC().Format(setTimeFormat)
+	if s.last != nil && set <= s.last.Set {
+		// Two sets within a second; keep names unique and ordered
+		base, n, _ := strings.Cut(s.last.Set, "-")
+		seq, _ := strconv.Atoi(n)
+		set = fmt.Sprintf("%s-%d", base, seq+1)
+	}
+
+	loaded := make(map[string]*client.Client)
+	s.agents.Range(func(agentID string, c *client.Client) bool {
+		loaded[agentID] = c
+		return true
+	})
+	onDisk, err := s.agents.DiskAgents()
+	if err != nil {
+		return nil, 0, 0, fmt.Errorf("failed to list agent files: %w", err)
+	}
+	diskTimes := make(map[string]time.Time, len(onDisk))
+	for _, a := range onDisk {
+		diskTimes[a.ID] = a.Modified
+	}
+
+	ids := make([]string, 0, len(loaded)+len(onDisk))
+	for agentID := range loaded {
+		ids = append(ids, agentID)
+	}
+	for _, a := range onDisk {
+		if _, ok := loaded[a.ID]; !ok {
+			ids = append(ids, a.ID)
+		}
+	}
+	sort.Strings(ids)
+
+	manifest := &Manifest{Set: set, Time: now.UTC()}
+	copies := make(map[string]agentCopy, len(ids))
+	written := 0
+	var bytesWritten int64
+	for _, agentID := range ids {
+		if err := ctx.Err(); err != nil {
+			return nil, 0, 0, err
+		}
+		if _, err := agents.FilePath("", agentID); err != nil {
+			log.Printf("Snapshot: skipping agent %q: %v", agentID, err)
+			continue
+		}
+
+		c := loaded[agentID]
+		modTime, hasFile := diskTimes[agentID]
+		version := "file:" + modTime.Format(time.RFC3339Nano)
+		if c != nil && (!c.LastModified().IsZero() || !hasFile) {
+			version = "client:" + c.LastModified().Format(time.RFC3339Nano)
+		}
+
+		if prev, ok := s.copies[agentID]; ok && prev.version == version {
+			copies[agentID] = prev
+			manifest.Agents = append(manifest.Agents, ManifestEntry{Agent: agentID, Object: prev.object, Bytes: prev.bytes})
+			continue
+		}
+
+		data, err := s.serialize(ctx, agentID, c, strings.HasPrefix(version, "file:"))
+		if errors.Is(err, errAgentGone) {
+			continue
+		}
+		if err != nil {
+			return nil, 0, 0, fmt.Errorf("agent %s: %w", agentID, err)
+		}
+		object := set + "/" + agentID + agents.FileExt
+		if err := s.store.Put(ctx, object, data); err != nil {
+			return nil, 0, 0, fmt.Errorf("agent %s: %w", agentID, err)
+		}
+		written++
+		bytesWritten += int64(len(data))
+
+		copies[agentID] = agentCopy{object: object, bytes: int64(len(data)), version: version}
+		manifest.Agents = append(manifest.Agents, ManifestEntry{Agent: agentID, Object: object, Bytes: int64(len(data))})
+	}
+
+	if !force && written == 0 && s.last != nil && sameAgents(manifest, s.last) {
+		return nil, 0, 0, nil
+	}
+
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	if err := s.store.Put(ctx, set+"/"+ManifestName, data); err != nil {
+		return nil, 0, 0, fmt.Errorf("manifest: %w", err)
+	}
+	bytesWritten += int64(len(data))
+
+	s.copies = copies
+	s.last = manifest
+	return manifest, written, bytesWritten, nil
+}
+
+// sameAgents reports whether two sets hold the same copies of the same agents
+func sameAgents(a, b *Manifest) bool {
+	if len(a.Agents) != len(b.Agents) {
+		return false
+	}
+	for i := range a.Agents {
+		if a.Agents[i] != b.Agents[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// serialize returns an agent in the storage file format. A loaded agent is
+// copied under its tree's lock, so the copy is consistent while writes go
+// on; an unchanged agent file is read as it is, and checked, since a save of
+// the agent could be rewriting it.
+func (s *Scheduler) serialize(ctx context.Context, agentID string, c *client.Client, fromFile bool) ([]byte, error) {
+	if fromFile {
+		path, err := s.agents.Path(agentID)
+		if err != nil {
+			return nil, err
+		}
+		data, err := os.ReadFile(path)
+		if err == nil {
+			if _, err = storage.ReadTree(bytes.NewReader(data)); err == nil {
+				return data, nil
+			}
+		}
+		// Fall back on the agent's client, loading it if need be
+		if c == nil {
+			loadedClient, exists, loadErr := s.agents.Get(ctx, agentID)
+			if loadErr != nil {
+				return nil, loadErr
+			}
+			if !exists {
+				return nil, errAgentGone
+			}
+			c = loadedClient
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := c.Snapshot(&buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// prune deletes the sets beyond Keep or older than MaxAge, always keeping
+// the newest, then every agent file no kept manifest refers to
+func (s *Scheduler) prune(ctx context.Context, now time.Time) error {
+	if s.cfg.Keep <= 0 && s.cfg.MaxAge <= 0 {
+		return nil
+	}
+
+	names, err := s.store.List(ctx)
+	if err != nil {
+		return err
+	}
+	sets := setsIn(names)
+	if len(sets) == 0 {
+		return nil
+	}
+
+	var keep, drop []string
+	for i, set := range sets { // Newest first
+		expired := s.cfg.Keep > 0 && i >= s.cfg.Keep
+		if s.cfg.MaxAge > 0 && i > 0 {
+			if t, err := setTime(set); err == nil && now.Sub(t) > s.cfg.MaxAge {
+				expired = true
+			}
+		}
+		if expired {
+			drop = append(drop, set)
+		} else {
+			keep = append(keep, set)
+		}
+	}
+	if len(drop) == 0 {
+		return nil
+	}
+
+	// Manifests first: a set without one is no longer restorable, so a
+	// prune cut short never leaves a set missing files
+	for _, set := range drop {
+		if err := s.store.Delete(ctx, set+"/"+ManifestName); err != nil {
+			return err
+		}
+	}
+
+	referenced := make(map[string]bool)
+	for _, set := range keep {
+		manifest, err := ReadManifest(ctx, s.store, set)
+		if err != nil {
+			return fmt.Errorf("set %s: %w", set, err)
+		}
+		for _, entry := range manifest.Agents {
+			referenced[entry.Object] = true
+		}
+	}
+
+	newest := sets[0]
+	removed := 0
+	for _, name := range names {
+		set, _, ok := strings.Cut(name, "/")
+		if !ok || set > newest || referenced[name] || strings.HasSuffix(name, "/"+ManifestName) {
+			continue
+		}
+		if err := s.store.Delete(ctx, name); err != nil {
+			return err
+		}
+		removed++
+	}
+	log.Printf("Pruned %d snapshot sets (%d objects)", len(drop), removed)
+	return nil
+}
+
+// setsIn returns the sets with a manifest among names, newest first
+func setsIn(names []string) []string {
+	var sets []string
+	for _, name := range names {
+		if set, ok := strings.CutSuffix(name, "/"+ManifestName); ok && !strings.Contains(set, "/") {
+			sets = append(sets, set)
+		}
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(sets)))
+	return sets
+}
+
+// setTime parses the time a set was taken from its name
+func setTime(set string) (time.Time, error) {
+	if len(set) < len(setTimeFormat) {
+		return time.Time{}, fmt.Errorf("invalid set name %q", set)
+	}
+	return time.Parse(setTimeFormat, set[:len(setTimeFormat)])
+}
+
+// Sets returns the complete sets in a store, newest first
+func Sets(ctx context.Context, store Store) ([]string, error) {
+	names, err := store.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return setsIn(names), nil
+}
+
+// ReadManifest reads the manifest of a set
+func ReadManifest(ctx context.Context, store Store, set string) (*Manifest, error) {
+	data, err := store.Get(ctx, set+"/"+ManifestName)
+	if err != nil {
+		return nil, err
+	}
+	var manifest Manifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, fmt.Errorf("invalid manifest: %w", err)
+	}
+	return &manifest, nil
+}
@@ -0,0 +1,129 @@
+package snapshot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ErrNotFound is returned by Store.Get for a missing object
+var ErrNotFound = errors.New("snapshot object not found")
+
+// Store holds snapshot objects under slash-separated names such as
+// "20250102T150405Z/customer_123.bin"
+type Store interface {
+	Put(ctx context.Context, name string, data []byte) error
+	Get(ctx context.Context, name string) ([]byte, error)
+	List(ctx context.Context) ([]string, error) // Every object name
+	Delete(ctx context.Context, name string) error
+	String() string // For logs and INFO
+}
+
+// DirStore keeps objects as files under a local directory
+type DirStore struct {
+	Dir string
+}
+
+func NewDirStore(dir string) *DirStore {
+	return &DirStore{Dir: dir}
+}
+
+func (ds *DirStore) path(name string) (string, error) {
+	if name == "" || strings.Contains(name, "..") || strings.HasPrefix(name, "/") {
+		return "", fmt.Errorf("invalid snapshot object name %q", name)
+	}
+	return filepath.Join(ds.Dir, filepath.FromSlash(name)), nil
+}
+
+// Put writes through a temporary file, so a failed write leaves no partial
+// object behind
+func (ds *DirStore) Put(ctx context.Context, name string, data []byte) error {
+	path, err := ds.path(name)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	f, err := os.CreateTemp(filepath.Dir(path), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	if err := os.Rename(f.Name(), path); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return nil
+}
+
+func (ds *DirStore) Get(ctx context.Context, name string) ([]byte, error) {
+	path, err := ds.path(name)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, name)
+	}
+	return data, err
+}
+
+func (ds *DirStore) List(ctx context.Context) ([]string, error) {
+	var names []string
+	err := filepath.WalkDir(ds.Dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && path == ds.Dir {
+				return filepath.SkipDir
+			}
+			return err
+		}
+		if d.IsDir() || strings.HasPrefix(d.Name(), ".tmp-") {
+			return nil
+		}
+		rel, err := filepath.Rel(ds.Dir, path)
+		if err != nil {
+			return err
+		}
+		names = append(names, filepath.ToSlash(rel))
+		return nil
+	})
+	return names, err
+}
+
+// Delete removes an object, and its directory once that is empty
+func (ds *DirStore) Delete(ctx context.Context, name string) error {
+	path, err := ds.path(name)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if dir := filepath.Dir(path); dir != filepath.Clean(ds.Dir) {
+		os.Remove(dir) // Fails while other objects remain
+	}
+	return nil
+}
+
+func (ds *DirStore) String() string {
+	return ds.Dir
+}
@@ -0,0 +1,216 @@
+package storage
+
+import (
+	"Hippocampus/src/types"
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sync"
+)
+
+// ErrImmutable is returned for deletes and overwrites of append-only storage
+var ErrImmutable = errors.New("storage is append-only: memories can't be deleted or overwritten")
+
+// logMagic is "HIPL" read as a little-endian uint32; it starts an append-only
+// log of nodes encoded as in tree format version 3
+const logMagic uint32 = 0x4C504948
+
+// versionedLogMagic is "HIPM" read as a little-endian uint32; it starts an
+// append-only log followed by the uint32 tree format version of its nodes
+const versionedLogMagic uint32 = 0x4D504948
+
+// AppendOnlyFileStorage keeps a tree as a log: a header, then nodes (encoded
+// as in the tree format version the header names) one after another with no
+// count. New logs use the current version; logs started before version 4
+// keep appending version 3 nodes, which have no metadata.
+// Save only ever appends, so once written a node stays in the file. Removing
+// a node from the tree doesn't remove it from the log; Delete reports
+// ErrImmutable so clients refuse such changes up front. One process should
+// write a log at a time.
+type AppendOnlyFileStorage struct {
+	path string
+
+	mu      sync.Mutex
+	written map[[16]byte]bool // Hashes of the nodes in the log; nil until read
+	version uint32            // Tree format version of the nodes in the log; 0 until read
+	size    int64             // Bytes of the header and complete records
+	torn    bool              // The log ends in an incomplete record, cut off by Save
+}
+
+func NewAppendOnlyFileStorage(path string) *AppendOnlyFileStorage {
+	return &AppendOnlyFileStorage{path: path}
+}
+
+// Path returns the log file
+func (as *AppendOnlyFileStorage) Path() string {
+	return as.path
+}
+
+// Delete always fails: nothing is removed from an append-only log
+func (as *AppendOnlyFileStorage) Delete(id string) error {
+	return ErrImmutable
+}
+
+// Load replays the log from the beginning. A record cut short, e.g. by a
+// crash during Save, is ignored and cut off by the next Save.
+func (as *AppendOnlyFileStorage) Load() (*types.Tree, error) {
+	as.mu.Lock()
+	defer as.mu.Unlock()
+
+	t := &types.Tree{
+		Nodes: []types.Node{},
+		Index: [512][]int32{},
+	}
+	written := make(map[[16]byte]bool)
+	err := as.replay(func(n *types.Node) {
+		t.Nodes = append(t.Nodes, *n)
+		written[types.NodeHash(n)] = true
+	})
+	if err != nil {
+		return nil, err
+	}
+	as.written = written
+	return t, nil
+}
+
+// replay calls fn for every complete record and sets as.size and as.torn
+func (as *AppendOnlyFileStorage) replay(fn func(n *types.Node)) error {
+	as.size, as.torn, as.version = 0, false, 0
+	f, err := os.Open(as.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	br := bufio.NewReader(f)
+	var magic uint32
+	if err := binary.Read(br, binary.LittleEndian, &magic); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			// Empty, or cut short before the magic was written
+			as.torn = err == io.ErrUnexpectedEOF
+			return nil
+		}
+		return err
+	}
+	version, offset := FormatV3, int64(4)
+	switch magic {
+	case logMagic:
+	case versionedLogMagic:
+		if err := binary.Read(br, binary.LittleEndian, &version); err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				as.torn = true
+				return nil
+			}
+			return err
+		}
+		if version < FormatV4 || version > CurrentFormatVersion {
+			return fmt.Errorf("%s: unsupported format version %d", as.path, version)
+		}
+		offset += 4
+	default:
+		return fmt.Errorf("%s is not an append-only log", as.path)
+	}
+	as.version = version
+
+	for {
+		if _, err := br.Peek(1); err == io.EOF {
+			break
+		}
+		var n types.Node
+		if err := readNode(br, &n, version); err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				log.Printf("%s: ignoring an incomplete record at offset %d", as.path, offset)
+				as.torn = true
+				break
+			}
+			return fmt.Errorf("record at offset %d: %w", offset, err)
+		}
+		offset += encodedNodeSize(&n, version)
+		fn(&n)
+	}
+	as.size = offset
+	return nil
+}
+
+// Save appends the nodes of t not already in the log, identified by
+// embedding hash, and syncs the file
+func (as *AppendOnlyFileStorage) Save(t *types.Tree) error {
+	as.mu.Lock()
+	defer as.mu.Unlock()
+
+	f, err := os.OpenFile(as.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	// Read the log on first Save, or again if it changed since it was read
+	if as.written == nil || (info.Size() != as.size && !as.torn) {
+		written := make(map[[16]byte]bool)
+		if err := as.replay(func(n *types.Node) { written[types.NodeHash(n)] = true }); err != nil {
+			return err
+		}
+		as.written = written
+	}
+
+	var fresh []types.Node
+	var hashes [][16]byte
+	t.Each(func(n *types.Node) error {
+		if hash := types.NodeHash(n); !as.written[hash] {
+			fresh = append(fresh, *n)
+			hashes = append(hashes, hash)
+		}
+		return nil
+	})
+	if len(fresh) == 0 {
+		return nil
+	}
+
+	if as.torn {
+		// Drop the incomplete record a crash left, so the log stays readable
+		if err := f.Truncate(as.size); err != nil {
+			return err
+		}
+		as.torn = false
+	}
+	if as.size == 0 {
+		header := []uint32{versionedLogMagic, CurrentFormatVersion}
+		if err := binary.Write(f, binary.LittleEndian, header); err != nil {
+			return err
+		}
+		as.size, as.version = 8, CurrentFormatVersion
+	}
+
+	bw := bufio.NewWriter(f)
+	for i := range fresh {
+		if as.version < FormatV4 && fresh[i].Metadata != "" {
+			log.Printf("%s predates node metadata; the metadata of %s is not saved", as.path, fresh[i].ID)
+		}
+		if err := writeNode(bw, &fresh[i], as.version); err != nil {
+			return err
+		}
+	}
+	if err := bw.Flush(); err != nil {
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		return err
+	}
+
+	for i := range fresh {
+		as.written[hashes[i]] = true
+		as.size += encodedNodeSize(&fresh[i], as.version)
+	}
+	return nil
+}
@@ -0,0 +1,90 @@
+package storage
+
+import (
+	"Hippocampus/src/types"
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestAppendOnlySavesSameEmbeddingUnderTwoKeys(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "agent.log")
+	var key [512]float32
+	key[0] = 1
+
+	tree := types.NewTree()
+	tree.InsertNode(types.Node{Key: key, ID: "a", Value: "same text"})
+	if err := NewAppendOnlyFileStorage(path).Save(tree); err != nil {
+		t.Fatal(err)
+	}
+	tree.InsertNode(types.Node{Key: key, ID: "b", Value: "same text"})
+	if err := NewAppendOnlyFileStorage(path).Save(tree); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := NewAppendOnlyFileStorage(path).Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.Len() != 2 {
+		t.Fatalf("log holds %d nodes, want 2", loaded.Len())
+	}
+}
+
+func TestAppendOnlySavesMetadata(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "agent.log")
+	tree := types.NewTree()
+	tree.InsertNode(types.Node{ID: "a", Value: "text", Metadata: `{"source":"call"}`})
+	if err := NewAppendOnlyFileStorage(path).Save(tree); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := NewAppendOnlyFileStorage(path).Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, _ := loaded.GetID("a"); n.Metadata != `{"source":"call"}` {
+		t.Fatalf("metadata read back as %q", n.Metadata)
+	}
+}
+
+func TestAppendOnlyAppendsToLogFromBeforeV4(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "agent.log")
+
+	// A log as written before versioned headers: the magic, then v3 nodes
+	var old bytes.Buffer
+	binary.Write(&old, binary.LittleEndian, logMagic)
+	first := types.Node{ID: "a", Value: "first"}
+	first.Key[0] = 1
+	if err := writeNode(&old, &first, FormatV3); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, old.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	as := NewAppendOnlyFileStorage(path)
+	tree, err := as.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	second := types.Node{ID: "b", Value: "second", Metadata: `{"n":1}`}
+	second.Key[1] = 1
+	tree.InsertNode(second)
+	if err := as.Save(tree); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := NewAppendOnlyFileStorage(path).Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.Len() != 2 {
+		t.Fatalf("log holds %d nodes, want 2", loaded.Len())
+	}
+	if n, _ := loaded.GetID("b"); n.Value != "second" || n.Metadata != "" {
+		t.Fatalf("appended node read back as %v with metadata %q, want v3 without", n, n.Metadata)
+	}
+}
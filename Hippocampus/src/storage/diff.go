@@ -0,0 +1,101 @@
+package storage
+
+import (
+	"Hippocampus/src/types"
+)
+
+// DiffResult lists the nodes that differ between two trees
+type DiffResult struct {
+	OnlyInA []types.Node
+	OnlyInB []types.Node
+	InBoth  int
+}
+
+// Diff compares two trees by exact embedding (Node.Key). Repeated embeddings
+// are matched one for one, so a node stored twice in a and once in b shows up
+// once in OnlyInA.
+func Diff(a, b *types.Tree) DiffResult {
+	aNodes := a.DeepCopy().Nodes
+	bNodes := b.DeepCopy().Nodes
+
+	// Count the unmatched occurrences of each embedding in b
+	inB := make(map[[512]float32]int, len(bNodes))
+	for i := range bNodes {
+		inB[bNodes[i].Key]++
+	}
+
+	var result DiffResult
+	for i := range aNodes {
+		if inB[aNodes[i].Key] > 0 {
+			inB[aNodes[i].Key]--
+			result.InBoth++
+			continue
+		}
+		result.OnlyInA = append(result.OnlyInA, aNodes[i])
+	}
+
+	// Whatever is left unmatched in b, in b's order
+	for i := range bNodes {
+		if inB[bNodes[i].Key] > 0 {
+			inB[bNodes[i].Key]--
+			result.OnlyInB = append(result.OnlyInB, bNodes[i])
+		}
+	}
+
+	return result
+}
+
+// ConflictPolicy decides which node Merge keeps when both trees have a node
+// with the same embedding or the same non-empty ID
+type ConflictPolicy int
+
+const (
+	PreferBase    ConflictPolicy = iota // Keep the base node, drop the overlay's
+	PreferOverlay                       // Replace the base node with the overlay's
+)
+
+// Merge returns a new tree holding base plus the nodes of overlay. A node in
+// overlay conflicts with a base node when it has the same embedding or the
+// same non-empty ID; policy picks which one is kept. Neither input is modified.
+func Merge(base, overlay *types.Tree, policy ConflictPolicy) *types.Tree {
+	merged := base.DeepCopy()
+
+	byKey := make(map[[512]float32]int, len(merged.Nodes))
+	byID := make(map[string]int, len(merged.Nodes))
+	for i := range merged.Nodes {
+		byKey[merged.Nodes[i].Key] = i
+		if merged.Nodes[i].ID != "" {
+			byID[merged.Nodes[i].ID] = i
+		}
+	}
+
+	overlayNodes := overlay.DeepCopy().Nodes
+	for _, node := range overlayNodes {
+		i, conflict := byKey[node.Key]
+		if !conflict && node.ID != "" {
+			i, conflict = byID[node.ID]
+		}
+
+		if !conflict {
+			merged.Nodes = append(merged.Nodes, node)
+			i = len(merged.Nodes) - 1
+		} else if policy == PreferOverlay {
+			old := merged.Nodes[i]
+			delete(byKey, old.Key)
+			if old.ID != "" {
+				delete(byID, old.ID)
+			}
+			merged.Nodes[i] = node
+		} else {
+			continue
+		}
+
+		byKey[node.Key] = i
+		if node.ID != "" {
+			byID[node.ID] = i
+		}
+	}
+
+	merged.RebuildIndex()
+	return merged
+}
@@ -0,0 +1,302 @@
+package storage
+
+import (
+	"Hippocampus/src/types"
+	hippoversion "Hippocampus/src/version"
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// Binary format versions.
+//
+// Version 1 (legacy) has no header: node count (int64) followed by nodes of
+// key ([512]float32) + value length (int64) + value bytes. Nodes read from
+// it get their value as ID.
+//
+// Version 2 starts with the magic number and a uint32 version, then the node
+// count, then nodes of key + ID length/bytes + value length/bytes.
+//
+// Version 3 adds the writer, the build that wrote the file (see
+// version.Writer), as a length-prefixed string between the version and the
+// node count. Nodes are as in version 2.
+//
+// Version 4 adds the node's metadata length/bytes after its value; the
+// header is as in version 3.
+const (
+	FormatV1 uint32 = 1
+	FormatV2 uint32 = 2
+	FormatV3 uint32 = 3
+	FormatV4 uint32 = 4
+
+	CurrentFormatVersion = FormatV4
+)
+
+// SupportedFormatVersions lists the format versions that can be read and written
+var SupportedFormatVersions = []uint32{FormatV1, FormatV2, FormatV3, FormatV4}
+
+// maxWriterLength bounds the writer string read from a header, so a corrupt
+// length can't cause a huge allocation
+const maxWriterLength = 1024
+
+// Allocations made up front for counts and lengths read from a file, which
+// may be corrupt. Larger ones grow with the data actually read.
+const (
+	maxPreallocNodes  = 1 << 16
+	maxPreallocString = 1 << 20
+)
+
+// fileHeader is the start of a tree file
+type fileHeader struct {
+	Version   uint32
+	Writer    string // Empty before version 3
+	NodeCount int64
+	Size      int64 // Bytes the header takes
+}
+
+// formatMagic is "HIPO" read as a little-endian uint32. A legacy file would
+// need over a billion nodes for its count to collide with it.
+const formatMagic uint32 = 0x4F504948
+
+// WriteTree writes t to w in the current binary format. The caller must
+// ensure t is not modified concurrently, e.g. by passing a DeepCopy.
+func WriteTree(w io.Writer, t *types.Tree) error {
+	return WriteTreeVersion(w, t, CurrentFormatVersion)
+}
+
+// WriteTreeVersion writes t in the given format version. Version 1 has no
+// node IDs and versions before 4 no metadata, so those are lost.
+func WriteTreeVersion(w io.Writer, t *types.Tree, version uint32) error {
+	if version < FormatV1 || version > CurrentFormatVersion {
+		return fmt.Errorf("unsupported format version %d", version)
+	}
+
+	bw := bufio.NewWriter(w)
+
+	if version >= FormatV2 {
+		if err := binary.Write(bw, binary.LittleEndian, formatMagic); err != nil {
+			return err
+		}
+		if err := binary.Write(bw, binary.LittleEndian, version); err != nil {
+			return err
+		}
+	}
+	if version >= FormatV3 {
+		if err := writeString(bw, hippoversion.Writer()); err != nil {
+			return err
+		}
+	}
+	if err := binary.Write(bw, binary.LittleEndian, int64(len(t.Nodes))); err != nil {
+		return err
+	}
+
+	for i := range t.Nodes {
+		if err := writeNode(bw, &t.Nodes[i], version); err != nil {
+			return err
+		}
+	}
+
+	return bw.Flush()
+}
+
+// ReadTree reads a tree in any supported format version. The index is not rebuilt.
+func ReadTree(r io.Reader) (*types.Tree, error) {
+	br := bufio.NewReader(r)
+
+	header, err := readHeader(br)
+	if err != nil {
+		return nil, err
+	}
+	return readNodes(br, header.NodeCount, header.Version)
+}
+
+// readHeader reads the format version, writer and node count at the start
+// of a file
+func readHeader(r io.Reader) (fileHeader, error) {
+	// The first 8 bytes are either magic+version or a legacy node count
+	var head [8]byte
+	if _, err := io.ReadFull(r, head[:]); err != nil {
+		return fileHeader{}, err
+	}
+
+	header := fileHeader{
+		Version:   FormatV1,
+		NodeCount: int64(binary.LittleEndian.Uint64(head[:])),
+		Size:      8,
+	}
+	if binary.LittleEndian.Uint32(head[:4]) != formatMagic {
+		return header, nil
+	}
+
+	header.Version = binary.LittleEndian.Uint32(head[4:])
+	if header.Version > CurrentFormatVersion {
+		return fileHeader{}, fmt.Errorf("unsupported format version %d (newest supported is %d)", header.Version, CurrentFormatVersion)
+	}
+	if header.Version >= FormatV3 {
+		var length int64
+		if err := binary.Read(r, binary.LittleEndian, &length); err != nil {
+			return fileHeader{}, err
+		}
+		if length < 0 || length > maxWriterLength {
+			return fileHeader{}, fmt.Errorf("corrupt header: writer length %d", length)
+		}
+		writer := make([]byte, length)
+		if _, err := io.ReadFull(r, writer); err != nil {
+			return fileHeader{}, err
+		}
+		header.Writer = string(writer)
+		header.Size += 8 + length
+	}
+	if err := binary.Read(r, binary.LittleEndian, &header.NodeCount); err != nil {
+		return fileHeader{}, err
+	}
+	header.Size += 8
+	return header, nil
+}
+
+// ReadLegacyTree reads a version 1 file without looking for a header, for
+// legacy files whose node count could be mistaken for the magic number
+func ReadLegacyTree(r io.Reader) (*types.Tree, error) {
+	br := bufio.NewReader(r)
+
+	var nodeCount int64
+	if err := binary.Read(br, binary.LittleEndian, &nodeCount); err != nil {
+		return nil, err
+	}
+	return readNodes(br, nodeCount, FormatV1)
+}
+
+func readNodes(r io.Reader, nodeCount int64, version uint32) (*types.Tree, error) {
+	if nodeCount < 0 {
+		return nil, fmt.Errorf("corrupt file: negative node count %d", nodeCount)
+	}
+
+	// A corrupt count could ask for any amount of memory, so nodes beyond
+	// the first maxPreallocNodes are only allocated as they are read
+	t := &types.Tree{
+		Nodes: make([]types.Node, 0, min(nodeCount, maxPreallocNodes)),
+		Index: [512][]int32{},
+	}
+
+	for i := int64(0); i < nodeCount; i++ {
+		t.Nodes = append(t.Nodes, types.Node{})
+		if err := readNode(r, &t.Nodes[i], version); err != nil {
+			return nil, err
+		}
+	}
+
+	return t, nil
+}
+
+// EncodedNodeSize is the number of bytes writeNode uses for a node with this
+// ID, value and metadata in the current format
+func EncodedNodeSize(id, value, metadata string) int64 {
+	return encodedNodeSize(&types.Node{ID: id, Value: value, Metadata: metadata}, CurrentFormatVersion)
+}
+
+// encodedNodeSize is the number of bytes writeNode uses for n in version
+func encodedNodeSize(n *types.Node, version uint32) int64 {
+	size := 512*4 + 8 + int64(len(n.Value))
+	if version >= FormatV2 {
+		size += 8 + int64(len(n.ID))
+	}
+	if version >= FormatV4 {
+		size += 8 + int64(len(n.Metadata))
+	}
+	return size
+}
+
+func writeNode(w io.Writer, n *types.Node, version uint32) error {
+	if err := binary.Write(w, binary.LittleEndian, n.Key); err != nil {
+		return err
+	}
+
+	if version >= FormatV2 {
+		if err := writeString(w, n.ID); err != nil {
+			return err
+		}
+	}
+
+	if err := writeString(w, n.Value); err != nil {
+		return err
+	}
+
+	if version >= FormatV4 {
+		return writeString(w, n.Metadata)
+	}
+	return nil
+}
+
+func readNode(r io.Reader, n *types.Node, version uint32) error {
+	if err := binary.Read(r, binary.LittleEndian, &n.Key); err != nil {
+		return err
+	}
+
+	if version >= FormatV2 {
+		id, err := readString(r)
+		if err != nil {
+			return err
+		}
+		n.ID = id
+	}
+
+	value, err := readString(r)
+	if err != nil {
+		return err
+	}
+	n.Value = value
+
+	// Version 1 stores no ID; the text was the only handle on a node
+	if version < FormatV2 {
+		n.ID = value
+	}
+
+	n.Metadata = ""
+	if version >= FormatV4 {
+		metadata, err := readString(r)
+		if err != nil {
+			return err
+		}
+		n.Metadata = metadata
+	}
+	return nil
+}
+
+func writeString(w io.Writer, s string) error {
+	if err := binary.Write(w, binary.LittleEndian, int64(len(s))); err != nil {
+		return err
+	}
+
+	_, err := io.WriteString(w, s)
+	return err
+}
+
+func readString(r io.Reader) (string, error) {
+	var length int64
+	if err := binary.Read(r, binary.LittleEndian, &length); err != nil {
+		return "", err
+	}
+	if length < 0 {
+		return "", fmt.Errorf("corrupt file: negative string length %d", length)
+	}
+
+	if length > maxPreallocString {
+		// Too long to trust a possibly corrupt length: grow as data arrives
+		var buf bytes.Buffer
+		if _, err := io.CopyN(&buf, r, length); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return "", err
+		}
+		return buf.String(), nil
+	}
+
+	buf := make([]byte, length)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return "", err
+	}
+	return string(buf), nil
+}
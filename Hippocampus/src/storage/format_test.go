@@ -0,0 +1,175 @@
+package storage
+
+import (
+	"Hippocampus/src/types"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// testTree returns a tree of n nodes with distinct embeddings, IDs and texts,
+// the even ones with metadata
+func testTree(n int) *types.Tree {
+	tree := types.NewTree()
+	for i := range n {
+		node := types.Node{ID: fmt.Sprintf("key%d", i), Value: fmt.Sprintf("text %d", i)}
+		node.Key[i%512] = float32(i + 1)
+		if i%2 == 0 {
+			node.Metadata = fmt.Sprintf(`{"i":%d}`, i)
+		}
+		tree.InsertNode(node)
+	}
+	return tree
+}
+
+func TestMetadataRoundTrip(t *testing.T) {
+	tree := testTree(4)
+	var buf bytes.Buffer
+	if err := WriteTree(&buf, tree); err != nil {
+		t.Fatal(err)
+	}
+	got, err := ReadTree(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, want := range tree.Nodes {
+		if got.Nodes[i] != want {
+			t.Errorf("node %d = %v %q, want %v %q", i, got.Nodes[i], got.Nodes[i].Metadata, want, want.Metadata)
+		}
+	}
+}
+
+func TestMetadataDroppedBeforeV4(t *testing.T) {
+	tree := testTree(2)
+	var buf bytes.Buffer
+	if err := WriteTreeVersion(&buf, tree, FormatV3); err != nil {
+		t.Fatal(err)
+	}
+	got, err := ReadTree(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range got.Nodes {
+		if got.Nodes[i].Metadata != "" || got.Nodes[i].ID != tree.Nodes[i].ID {
+			t.Errorf("node %d read back from v3 as %v %q", i, got.Nodes[i], got.Nodes[i].Metadata)
+		}
+	}
+}
+
+// legacyFile encodes nodes the way version 1 wrote them: a node count, then
+// each node's embedding and value, with no header
+func legacyFile(t *testing.T, nodes []types.Node) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.LittleEndian, int64(len(nodes)))
+	for i := range nodes {
+		binary.Write(&buf, binary.LittleEndian, nodes[i].Key)
+		binary.Write(&buf, binary.LittleEndian, int64(len(nodes[i].Value)))
+		buf.WriteString(nodes[i].Value)
+	}
+	return buf.Bytes()
+}
+
+func TestV1RoundTrip(t *testing.T) {
+	tree := testTree(5)
+	var buf bytes.Buffer
+	if err := WriteTreeVersion(&buf, tree, FormatV1); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf.Bytes(), legacyFile(t, tree.Nodes)) {
+		t.Fatal("v1 encoding differs from the legacy layout")
+	}
+
+	got, err := ReadTree(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got.Nodes) != len(tree.Nodes) {
+		t.Fatalf("read %d nodes, want %d", len(got.Nodes), len(tree.Nodes))
+	}
+	for i, want := range tree.Nodes {
+		// v1 has no IDs or metadata; values stand in for IDs
+		want.ID, want.Metadata = want.Value, ""
+		if got.Nodes[i] != want {
+			t.Errorf("node %d = %v, want %v", i, got.Nodes[i], want)
+		}
+	}
+}
+
+func TestV2RoundTrip(t *testing.T) {
+	tree := testTree(5)
+	var buf bytes.Buffer
+	if err := WriteTreeVersion(&buf, tree, FormatV2); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(buf.Bytes(), []byte("HIPO\x02\x00\x00\x00")) {
+		t.Fatalf("v2 header % x", buf.Bytes()[:8])
+	}
+
+	got, err := ReadTree(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got.Nodes) != len(tree.Nodes) {
+		t.Fatalf("read %d nodes, want %d", len(got.Nodes), len(tree.Nodes))
+	}
+	for i, want := range tree.Nodes {
+		// v2 keeps IDs but has no metadata
+		want.Metadata = ""
+		if got.Nodes[i] != want {
+			t.Errorf("node %d = %v, want %v", i, got.Nodes[i], want)
+		}
+	}
+}
+
+func TestLegacyFileUpgradedOnSave(t *testing.T) {
+	tree := testTree(3)
+	path := filepath.Join(t.TempDir(), "tree.bin")
+	if err := os.WriteFile(path, legacyFile(t, tree.Nodes), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	fs := NewFileStorage(path)
+	loaded, err := fs.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.Len() != 3 || loaded.Nodes[1].ID != "text 1" {
+		t.Fatalf("loaded v1 file as %v", loaded.Nodes)
+	}
+	if err := fs.Save(loaded); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	header, err := readHeader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if header.Version != CurrentFormatVersion || header.NodeCount != 3 {
+		t.Fatalf("saved header %+v, want version %d with 3 nodes", header, CurrentFormatVersion)
+	}
+}
+
+func TestUnsupportedVersion(t *testing.T) {
+	if err := WriteTreeVersion(io.Discard, testTree(1), CurrentFormatVersion+1); err == nil {
+		t.Error("WriteTreeVersion accepted a future version")
+	}
+
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.LittleEndian, formatMagic)
+	binary.Write(&buf, binary.LittleEndian, CurrentFormatVersion+1)
+	binary.Write(&buf, binary.LittleEndian, int64(0))
+	if _, err := ReadTree(&buf); err == nil || !strings.Contains(err.Error(), "unsupported format version") {
+		t.Errorf("ReadTree of a future version = %v", err)
+	}
+}
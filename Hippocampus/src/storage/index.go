@@ -0,0 +1,171 @@
+package storage
+
+import (
+	"Hippocampus/src/types"
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+)
+
+// Index file layout: magic "HIDX", version, node count, then 512 slices of
+// (length int64 + int32 node indices), then a CRC32 of everything before it.
+const (
+	indexMagic   uint32 = 0x58444948 // "HIDX" little-endian
+	indexVersion uint32 = 1
+)
+
+// indexPath returns the .idx file stored next to a tree file
+func indexPath(path string) string {
+	return path + ".idx"
+}
+
+// IndexPath returns the .idx file of a tree file, for callers replacing the
+// tree file behind its storage's back, which makes the index stale
+func IndexPath(path string) string {
+	return indexPath(path)
+}
+
+// SaveWithIndex saves the tree and also persists its sorted indices to
+// path+".idx", so the next Load can skip RebuildIndex
+func (fs *FileStorage) SaveWithIndex(t *types.Tree) error {
+	snapshot := t.DeepCopy()
+	snapshot.RebuildIndex()
+
+	if err := fs.save(snapshot); err != nil {
+		return err
+	}
+
+	if err := writeIndexFile(indexPath(fs.path), snapshot); err != nil {
+		os.Remove(indexPath(fs.path))
+		return fmt.Errorf("index write error: %w", err)
+	}
+
+	// Matching mtimes tie the index to this exact version of the tree file
+	info, err := os.Stat(fs.path)
+	if err != nil {
+		return err
+	}
+	return os.Chtimes(indexPath(fs.path), info.ModTime(), info.ModTime())
+}
+
+func writeIndexFile(path string, t *types.Tree) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	crc := crc32.NewIEEE()
+	bw := bufio.NewWriter(io.MultiWriter(f, crc))
+
+	header := []interface{}{indexMagic, indexVersion, int64(len(t.Nodes))}
+	for _, field := range header {
+		if err := binary.Write(bw, binary.LittleEndian, field); err != nil {
+			return err
+		}
+	}
+
+	for dim := 0; dim < 512; dim++ {
+		if err := binary.Write(bw, binary.LittleEndian, int64(len(t.Index[dim]))); err != nil {
+			return err
+		}
+		if err := binary.Write(bw, binary.LittleEndian, t.Index[dim]); err != nil {
+			return err
+		}
+	}
+
+	if err := bw.Flush(); err != nil {
+		return err
+	}
+
+	if err := binary.Write(f, binary.LittleEndian, crc.Sum32()); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// loadIndexFile installs the persisted index into t if the .idx file exists,
+// has the same mtime as the tree file and matches the tree's node count.
+// It reports whether the index was installed.
+func (fs *FileStorage) loadIndexFile(t *types.Tree, treeInfo os.FileInfo) bool {
+	path := indexPath(fs.path)
+
+	info, err := os.Stat(path)
+	if err != nil || !info.ModTime().Equal(treeInfo.ModTime()) {
+		return false
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+
+	index, err := readIndexFile(f, len(t.Nodes))
+	if err != nil {
+		return false
+	}
+
+	t.SetIndex(index)
+	return true
+}
+
+func readIndexFile(r io.Reader, nodeCount int) ([512][]int32, error) {
+	var index [512][]int32
+
+	crc := crc32.NewIEEE()
+	br := bufio.NewReader(r)
+	tr := io.TeeReader(br, crc)
+
+	var magic, version uint32
+	var count int64
+	if err := binary.Read(tr, binary.LittleEndian, &magic); err != nil {
+		return index, err
+	}
+	if err := binary.Read(tr, binary.LittleEndian, &version); err != nil {
+		return index, err
+	}
+	if err := binary.Read(tr, binary.LittleEndian, &count); err != nil {
+		return index, err
+	}
+
+	if magic != indexMagic || version != indexVersion {
+		return index, fmt.Errorf("not an index file")
+	}
+	if count != int64(nodeCount) {
+		return index, fmt.Errorf("stale index: %d nodes, tree has %d", count, nodeCount)
+	}
+
+	for dim := 0; dim < 512; dim++ {
+		var length int64
+		if err := binary.Read(tr, binary.LittleEndian, &length); err != nil {
+			return index, err
+		}
+		if length != count {
+			return index, fmt.Errorf("stale index: dimension %d has %d entries", dim, length)
+		}
+
+		index[dim] = make([]int32, length)
+		if err := binary.Read(tr, binary.LittleEndian, index[dim]); err != nil {
+			return index, err
+		}
+		for _, nodeIdx := range index[dim] {
+			if nodeIdx < 0 || int64(nodeIdx) >= count {
+				return index, fmt.Errorf("corrupt index: node %d out of range", nodeIdx)
+			}
+		}
+	}
+
+	var checksum uint32
+	if err := binary.Read(br, binary.LittleEndian, &checksum); err != nil {
+		return index, err
+	}
+	if checksum != crc.Sum32() {
+		return index, fmt.Errorf("index checksum mismatch")
+	}
+
+	return index, nil
+}
@@ -0,0 +1,127 @@
+package storage
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// saveWithIndex writes testTree(n) to a new file with its .idx, returning
+// the file's path
+func saveWithIndex(t *testing.T, n int) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "tree.bin")
+	if err := NewFileStorage(path).SaveWithIndex(testTree(n)); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// loadedIndexEntries loads path and returns how many index entries the tree
+// came with: 512 per node from a current .idx, none when it must be rebuilt
+func loadedIndexEntries(t *testing.T, path string) int {
+	t.Helper()
+	tree, err := NewFileStorage(path).Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tree.IndexEntries()
+}
+
+func TestLoadUsesIndexFile(t *testing.T) {
+	path := saveWithIndex(t, 10)
+
+	treeInfo, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	idxInfo, err := os.Stat(IndexPath(path))
+	if err != nil {
+		t.Fatalf("no .idx file: %v", err)
+	}
+	if !idxInfo.ModTime().Equal(treeInfo.ModTime()) {
+		t.Fatalf(".idx mtime %v, tree file %v", idxInfo.ModTime(), treeInfo.ModTime())
+	}
+
+	tree, err := NewFileStorage(path).Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := tree.IndexEntries(); got != 512*10 {
+		t.Fatalf("loaded with %d index entries, want the .idx file's %d", got, 512*10)
+	}
+
+	// The persisted index is the one a rebuild gives
+	persisted := tree.Index
+	tree.RebuildIndex()
+	for dim := range persisted {
+		for i := range persisted[dim] {
+			if tree.Nodes[persisted[dim][i]].Key[dim] != tree.Nodes[tree.Index[dim][i]].Key[dim] {
+				t.Fatalf("dimension %d out of order at %d", dim, i)
+			}
+		}
+	}
+}
+
+func TestLoadIgnoresStaleIndexFile(t *testing.T) {
+	tests := []struct {
+		name  string
+		spoil func(t *testing.T, path string)
+	}{
+		{"tree file modified since", func(t *testing.T, path string) {
+			later := time.Now().Add(time.Hour)
+			if err := os.Chtimes(path, later, later); err != nil {
+				t.Fatal(err)
+			}
+		}},
+		{"index of another tree", func(t *testing.T, path string) {
+			other := saveWithIndex(t, 3)
+			data, err := os.ReadFile(IndexPath(other))
+			if err != nil {
+				t.Fatal(err)
+			}
+			writeWithTreeMtime(t, path, data)
+		}},
+		{"corrupt index", func(t *testing.T, path string) {
+			data, err := os.ReadFile(IndexPath(path))
+			if err != nil {
+				t.Fatal(err)
+			}
+			data[len(data)/2] ^= 0xFF
+			writeWithTreeMtime(t, path, data)
+		}},
+		{"truncated index", func(t *testing.T, path string) {
+			data, err := os.ReadFile(IndexPath(path))
+			if err != nil {
+				t.Fatal(err)
+			}
+			writeWithTreeMtime(t, path, data[:len(data)-10])
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := saveWithIndex(t, 10)
+			tt.spoil(t, path)
+			if got := loadedIndexEntries(t, path); got != 0 {
+				t.Fatalf("loaded with %d index entries from a stale .idx file", got)
+			}
+		})
+	}
+}
+
+// writeWithTreeMtime replaces path's .idx file with data, keeping the mtime
+// that marks it current
+func writeWithTreeMtime(t *testing.T, path string, data []byte) {
+	t.Helper()
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(IndexPath(path), data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(IndexPath(path), info.ModTime(), info.ModTime()); err != nil {
+		t.Fatal(err)
+	}
+}
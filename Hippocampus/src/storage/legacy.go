@@ -0,0 +1,68 @@
+package storage
+
+import (
+	"Hippocampus/src/types"
+	"os"
+)
+
+// LegacyFileStorage reads and writes the original headerless format
+// (FormatV1). It is meant for migrating old files; new data should use
+// FileStorage. Version 1 has no node IDs, so Save drops them.
+type LegacyFileStorage struct {
+	path string
+}
+
+func NewLegacyFileStorage(path string) *LegacyFileStorage {
+	return &LegacyFileStorage{path: path}
+}
+
+// Path returns the file the storage reads and writes
+func (ls *LegacyFileStorage) Path() string {
+	return ls.path
+}
+
+func (ls *LegacyFileStorage) Save(t *types.Tree) error {
+	snapshot := t.DeepCopy()
+
+	f, err := os.Create(ls.path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return WriteTreeVersion(f, snapshot, FormatV1)
+}
+
+// Load reads the file as version 1 without checking for a magic number
+func (ls *LegacyFileStorage) Load() (*types.Tree, error) {
+	f, err := os.Open(ls.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return &types.Tree{
+				Nodes: []types.Node{},
+				Index: [512][]int32{},
+			}, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if info.Size() == 0 {
+		return &types.Tree{
+			Nodes: []types.Node{},
+			Index: [512][]int32{},
+		}, nil
+	}
+
+	t, err := ReadLegacyTree(f)
+	if err != nil {
+		return nil, err
+	}
+
+	// Indexed on first search
+	return t, nil
+}
@@ -0,0 +1,326 @@
+package storage
+
+import (
+	"Hippocampus/src/types"
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"unicode/utf8"
+)
+
+// VerifyReport is the result of Verify
+type VerifyReport struct {
+	Version   uint32
+	Writer    string   // Build that wrote the file; empty before format version 3
+	NodeCount int64    // Declared in the header
+	NodesRead int      // Nodes read before the end of the file or a fatal problem
+	Index     string   // "none", "stale (ignored)", "ok" or "bad"
+	Problems  []string // Empty if the file is sound
+}
+
+// OK reports whether Verify found no problems
+func (r VerifyReport) OK() bool {
+	return len(r.Problems) == 0
+}
+
+func (r *VerifyReport) problemf(format string, args ...any) {
+	r.Problems = append(r.Problems, fmt.Sprintf(format, args...))
+}
+
+// SalvageReport is the result of Salvage
+type SalvageReport struct {
+	NodeCount int64  // Declared in the header
+	Salvaged  int    // Nodes recovered
+	Dropped   int    // Nodes read but left out: bad embedding or duplicate
+	StoppedBy string // Why reading ended early, empty if the whole file was read
+}
+
+// CompactReport is the result of Compact
+type CompactReport struct {
+	SizeBefore int64
+	SizeAfter  int64
+	Nodes      int
+	Duplicates int  // Nodes dropped because an earlier node has the same ID, or embedding if they have none
+	Upgraded   bool // The file was rewritten from an older format version
+}
+
+// nodeReader reads nodes one at a time, checking every length against the
+// bytes left in the file rather than trusting it
+type nodeReader struct {
+	r         io.Reader
+	remaining int64
+	version   uint32
+}
+
+// minNodeSize is the smallest encoding of a node: the embedding plus one
+// length per string
+func minNodeSize(version uint32) int64 {
+	return encodedNodeSize(&types.Node{}, version)
+}
+
+func (nr *nodeReader) read(n *types.Node) error {
+	if nr.remaining < 512*4 {
+		return fmt.Errorf("truncated embedding (%d bytes left)", nr.remaining)
+	}
+	if err := binary.Read(nr.r, binary.LittleEndian, &n.Key); err != nil {
+		return err
+	}
+	nr.remaining -= 512 * 4
+
+	n.ID = ""
+	if nr.version >= FormatV2 {
+		id, err := nr.readString("ID")
+		if err != nil {
+			return err
+		}
+		n.ID = id
+	}
+
+	value, err := nr.readString("value")
+	if err != nil {
+		return err
+	}
+	n.Value = value
+	if nr.version < FormatV2 {
+		n.ID = value
+	}
+
+	n.Metadata = ""
+	if nr.version >= FormatV4 {
+		metadata, err := nr.readString("metadata")
+		if err != nil {
+			return err
+		}
+		n.Metadata = metadata
+	}
+	return nil
+}
+
+func (nr *nodeReader) readString(what string) (string, error) {
+	if nr.remaining < 8 {
+		return "", fmt.Errorf("truncated %s length", what)
+	}
+	var length int64
+	if err := binary.Read(nr.r, binary.LittleEndian, &length); err != nil {
+		return "", err
+	}
+	nr.remaining -= 8
+
+	if length < 0 || length > nr.remaining {
+		return "", fmt.Errorf("%s length %d is outside the %d bytes left", what, length, nr.remaining)
+	}
+	buf := make([]byte, length)
+	if _, err := io.ReadFull(nr.r, buf); err != nil {
+		return "", err
+	}
+	nr.remaining -= length
+	return string(buf), nil
+}
+
+// checkNode returns what is wrong with the contents of a node that parsed
+func checkNode(n *types.Node) string {
+	for dim, v := range n.Key {
+		if math.IsNaN(float64(v)) || math.IsInf(float64(v), 0) {
+			return fmt.Sprintf("embedding dimension %d is %v", dim, v)
+		}
+	}
+	if !utf8.ValidString(n.ID) {
+		return "ID is not valid UTF-8"
+	}
+	if !utf8.ValidString(n.Value) {
+		return "value is not valid UTF-8"
+	}
+	if n.Metadata != "" && !json.Valid([]byte(n.Metadata)) {
+		return "metadata is not valid JSON"
+	}
+	return ""
+}
+
+// openNodes opens path and reads its header, returning a nodeReader
+// positioned at the first node
+func openNodes(path string) (*os.File, *nodeReader, fileHeader, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil, fileHeader{}, err
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, nil, fileHeader{}, err
+	}
+
+	br := bufio.NewReader(f)
+	header, err := readHeader(br)
+	if err != nil {
+		f.Close()
+		return nil, nil, fileHeader{}, fmt.Errorf("bad header: %w", err)
+	}
+	return f, &nodeReader{r: br, remaining: info.Size() - header.Size, version: header.Version}, header, nil
+}
+
+// Verify checks the tree file at path, and its .idx file if current, without
+// modifying either. The tree format carries no checksum, so nodes are
+// checked for consistent lengths, finite embeddings and valid UTF-8 text.
+// The error is only set if the file can't be opened.
+func Verify(path string) (VerifyReport, error) {
+	var report VerifyReport
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return report, err
+	}
+	if info.Size() == 0 {
+		// Load treats an empty file as an empty tree
+		report.Version = CurrentFormatVersion
+		report.Index = "none"
+		return report, nil
+	}
+
+	f, nr, header, err := openNodes(path)
+	if err != nil {
+		if os.IsNotExist(err) || os.IsPermission(err) {
+			return report, err
+		}
+		report.problemf("%v", err)
+		return report, nil
+	}
+	defer f.Close()
+
+	nodeCount := header.NodeCount
+	report.Version = nr.version
+	report.Writer = header.Writer
+	report.NodeCount = nodeCount
+	if nodeCount < 0 || nodeCount > nr.remaining/minNodeSize(nr.version) {
+		report.problemf("header declares %d nodes but only %d bytes follow", nodeCount, nr.remaining)
+	}
+
+	var n types.Node
+	for i := int64(0); i < nodeCount; i++ {
+		if err := nr.read(&n); err != nil {
+			report.problemf("node %d: %v", i, err)
+			break
+		}
+		if problem := checkNode(&n); problem != "" {
+			report.problemf("node %d (%q): %s", i, n.ID, problem)
+		}
+		report.NodesRead++
+	}
+	if report.NodesRead == int(nodeCount) && nr.remaining > 0 {
+		report.problemf("%d unexpected bytes after the last node", nr.remaining)
+	}
+
+	report.Index = "none"
+	if idx, err := os.Stat(indexPath(path)); err == nil {
+		report.Index = "stale (ignored)"
+		if idx.ModTime().Equal(info.ModTime()) {
+			report.Index = "ok"
+			if err := verifyIndexFile(indexPath(path), report.NodesRead); err != nil {
+				report.Index = "bad"
+				report.problemf("index %s: %v", indexPath(path), err)
+			}
+		}
+	}
+
+	return report, nil
+}
+
+func verifyIndexFile(path string, nodeCount int) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = readIndexFile(f, nodeCount)
+	return err
+}
+
+// Salvage reads as many nodes as it can from a possibly corrupt tree file.
+// Reading stops at the first node whose lengths don't fit in the file;
+// nodes with a bad embedding or repeating an earlier node are dropped.
+func Salvage(path string) (*types.Tree, SalvageReport, error) {
+	var report SalvageReport
+	tree := types.NewTree()
+
+	f, nr, header, err := openNodes(path)
+	if err != nil {
+		return nil, report, err
+	}
+	defer f.Close()
+	report.NodeCount = header.NodeCount
+
+	// The declared count may be the corrupt part, so read to the end of the file
+	var n types.Node
+	for i := 0; nr.remaining > 0; i++ {
+		if err := nr.read(&n); err != nil {
+			report.StoppedBy = fmt.Sprintf("node %d: %v", i, err)
+			break
+		}
+		if checkNode(&n) != "" {
+			report.Dropped++
+			continue
+		}
+		if err := tree.InsertNode(n); err != nil {
+			report.Dropped++
+			continue
+		}
+		report.Salvaged++
+	}
+
+	return tree, report, nil
+}
+
+// Compact rewrites the tree file at path in the current format, dropping
+// nodes whose ID repeats an earlier node's (their embedding, for nodes
+// without one), and writes a fresh .idx file. The new files replace the old
+// ones by rename, so a failure leaves the original in place.
+func Compact(path string) (CompactReport, error) {
+	var report CompactReport
+
+	stat, err := NewFileStorage(path).Stat()
+	if err != nil {
+		return report, err
+	}
+	report.SizeBefore = stat.Size
+	report.Upgraded = stat.Version < CurrentFormatVersion
+
+	old, err := NewFileStorage(path).Load()
+	if err != nil {
+		return report, err
+	}
+
+	// InsertNode skips the repeated nodes that a plain Load keeps
+	tree := types.NewTree()
+	for i := range old.Nodes {
+		if err := tree.InsertNode(old.Nodes[i]); err != nil {
+			report.Duplicates++
+		}
+	}
+	report.Nodes = tree.Len()
+
+	tmp := path + ".compact"
+	if err := NewFileStorage(tmp).SaveWithIndex(tree); err != nil {
+		os.Remove(tmp)
+		os.Remove(indexPath(tmp))
+		return report, err
+	}
+
+	// The index goes last: until it is renamed, the old one's mtime no
+	// longer matches and Load ignores it
+	if err := os.Rename(tmp, path); err != nil {
+		return report, err
+	}
+	if err := os.Rename(indexPath(tmp), indexPath(path)); err != nil {
+		return report, err
+	}
+
+	if info, err := os.Stat(path); err == nil {
+		report.SizeAfter = info.Size()
+	}
+	return report, nil
+}
@@ -0,0 +1,122 @@
+package storage
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// MigrateReport describes what MigrateFile did
+type MigrateReport struct {
+	Nodes       int
+	FromVersion uint32
+	ToVersion   uint32
+	Added       []string // Features the output has that the input lacked
+	Unchanged   bool     // In place on a current file: nothing was written
+}
+
+// OpenVersion returns the storage that reads and writes path in the given
+// format version
+func OpenVersion(path string, version uint32) Storage {
+	if version == FormatV1 {
+		return NewLegacyFileStorage(path)
+	}
+	return NewFileStorageVersion(path, version)
+}
+
+// MigrateFile writes the tree file in to out in format version to. in is
+// read as version from, or as its header says if from is 0. With withIndex,
+// a .idx file is written as well (version 2 and later only).
+//
+// The output is written next to out, read back and checked before it is
+// renamed into place, so out may equal in. Migrating a file in place to the
+// version it already has, with the index it already has, writes nothing.
+func MigrateFile(in, out string, from, to uint32, withIndex bool) (MigrateReport, error) {
+	report := MigrateReport{FromVersion: from, ToVersion: to}
+	if to < FormatV1 || to > CurrentFormatVersion {
+		return report, fmt.Errorf("unsupported format version %d", to)
+	}
+	withIndex = withIndex && to >= FormatV2
+
+	hadIndex := false
+	if from == 0 {
+		stat, err := NewFileStorage(in).Stat()
+		if err != nil {
+			return report, err
+		}
+		report.FromVersion = stat.Version
+		hadIndex = stat.IndexSize > 0
+	} else if _, err := os.Stat(in); err != nil {
+		return report, err
+	}
+
+	tree, err := OpenVersion(in, report.FromVersion).Load()
+	if err != nil {
+		return report, fmt.Errorf("failed to read %s as v%d: %w", in, report.FromVersion, err)
+	}
+	report.Nodes = tree.Len()
+
+	sameFile := filepath.Clean(in) == filepath.Clean(out)
+	if sameFile && report.FromVersion == to && (hadIndex || !withIndex) {
+		report.Unchanged = true
+		return report, nil
+	}
+
+	if report.FromVersion < FormatV2 && to >= FormatV2 {
+		report.Added = append(report.Added, "node IDs (format v2 header)")
+	}
+	if report.FromVersion < FormatV3 && to >= FormatV3 {
+		report.Added = append(report.Added, "writer version (format v3 header)")
+	}
+	if report.FromVersion < FormatV4 && to >= FormatV4 {
+		report.Added = append(report.Added, "node metadata (format v4)")
+	}
+	if withIndex && !hadIndex {
+		report.Added = append(report.Added, "persisted search index (.idx)")
+	}
+
+	// Write next to the output and rename, so an in-place migration never
+	// leaves a half-written file
+	tmp := filepath.Join(filepath.Dir(out), "."+filepath.Base(out)+".migrate")
+	cleanup := func() {
+		os.Remove(tmp)
+		os.Remove(indexPath(tmp))
+	}
+
+	if withIndex {
+		err = NewFileStorageVersion(tmp, to).SaveWithIndex(tree)
+	} else {
+		err = OpenVersion(tmp, to).Save(tree)
+	}
+	if err != nil {
+		cleanup()
+		return report, fmt.Errorf("failed to write %s: %w", out, err)
+	}
+
+	// Read the result back before replacing anything
+	check, err := OpenVersion(tmp, to).Load()
+	if err != nil {
+		cleanup()
+		return report, fmt.Errorf("verification of migrated file failed: %w", err)
+	}
+	if check.Len() != tree.Len() {
+		cleanup()
+		return report, fmt.Errorf("verification of migrated file failed: read %d of %d nodes", check.Len(), tree.Len())
+	}
+
+	if err := os.Rename(tmp, out); err != nil {
+		cleanup()
+		return report, fmt.Errorf("failed to write %s: %w", out, err)
+	}
+	if withIndex {
+		// Renamed last, like Compact: until then Load ignores the old index
+		if err := os.Rename(indexPath(tmp), indexPath(out)); err != nil {
+			return report, fmt.Errorf("failed to write %s: %w", indexPath(out), err)
+		}
+	} else {
+		// An index left from before no longer matches
+		os.Remove(indexPath(out))
+	}
+
+	return report, nil
+}
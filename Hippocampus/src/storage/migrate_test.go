@@ -0,0 +1,115 @@
+package storage
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// writeV1 writes a version 1 file of testTree(n) to a new path in dir
+func writeV1(t *testing.T, dir string, n int) string {
+	t.Helper()
+	path := filepath.Join(dir, "old.bin")
+	if err := os.WriteFile(path, legacyFile(t, testTree(n).Nodes), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestMigrateV1ToV2(t *testing.T) {
+	dir := t.TempDir()
+	in := writeV1(t, dir, 5)
+	out := filepath.Join(dir, "new.bin")
+
+	report, err := MigrateFile(in, out, FormatV1, FormatV2, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Nodes != 5 || report.FromVersion != FormatV1 || report.ToVersion != FormatV2 || report.Unchanged {
+		t.Fatalf("report %+v", report)
+	}
+	if !slices.Equal(report.Added, []string{"node IDs (format v2 header)"}) {
+		t.Fatalf("added %q", report.Added)
+	}
+
+	stat, err := NewFileStorage(out).Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stat.Version != FormatV2 || stat.NodeCount != 5 {
+		t.Fatalf("migrated file is v%d with %d nodes, want v2 with 5", stat.Version, stat.NodeCount)
+	}
+
+	// The v2 file loads through FileStorage, v1 values standing in for IDs
+	tree, err := NewFileStorage(out).Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, want := range testTree(5).Nodes {
+		want.ID, want.Metadata = want.Value, ""
+		if tree.Nodes[i] != want {
+			t.Errorf("node %d = %v, want %v", i, tree.Nodes[i], want)
+		}
+	}
+	if i := tree.FindID("text 3"); i != 3 {
+		t.Errorf("FindID(text 3) = %d, want 3", i)
+	}
+
+	// The input is left as it was
+	if old, err := os.ReadFile(in); err != nil || string(old) != string(legacyFile(t, testTree(5).Nodes)) {
+		t.Fatalf("input changed: %v", err)
+	}
+}
+
+func TestMigrateInPlace(t *testing.T) {
+	dir := t.TempDir()
+	path := writeV1(t, dir, 3)
+
+	// A version of 0 is read from the file
+	report, err := MigrateFile(path, path, 0, CurrentFormatVersion, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.FromVersion != FormatV1 || report.Nodes != 3 {
+		t.Fatalf("report %+v", report)
+	}
+	stat, err := NewFileStorage(path).Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stat.Version != CurrentFormatVersion || stat.IndexSize == 0 {
+		t.Fatalf("migrated file is v%d with a %d byte index", stat.Version, stat.IndexSize)
+	}
+
+	// Nothing is written when the file is already current
+	if report, err := MigrateFile(path, path, 0, CurrentFormatVersion, true); err != nil || !report.Unchanged {
+		t.Fatalf("second migration = %+v, %v", report, err)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range entries {
+		if e.Name() != "old.bin" && e.Name() != "old.bin.idx" {
+			t.Errorf("left %s behind", e.Name())
+		}
+	}
+}
+
+func TestMigrateErrors(t *testing.T) {
+	dir := t.TempDir()
+	in := writeV1(t, dir, 2)
+	out := filepath.Join(dir, "new.bin")
+
+	if _, err := MigrateFile(in, out, FormatV1, CurrentFormatVersion+1, false); err == nil {
+		t.Error("migrated to a future version")
+	}
+	if _, err := MigrateFile(filepath.Join(dir, "missing.bin"), out, FormatV1, FormatV2, false); err == nil {
+		t.Error("migrated a missing file")
+	}
+	if _, err := os.Stat(out); !os.IsNotExist(err) {
+		t.Errorf("failed migrations wrote %s: %v", out, err)
+	}
+}
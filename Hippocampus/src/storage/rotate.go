@@ -0,0 +1,48 @@
+package storage
+
+import (
+	"Hippocampus/src/types"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Rotate moves the storage file into archiveDir as tree_YYYYMMDD_HHMMSS.bin,
+// with its .idx file if it has one, and starts a new empty file at the
+// original path. archiveDir is created if needed and must be on the same
+// filesystem, since the file is renamed rather than copied.
+func (fs *FileStorage) Rotate(archiveDir string) error {
+	if _, err := os.Stat(fs.path); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(archiveDir, 0755); err != nil {
+		return err
+	}
+
+	archive := archivePath(archiveDir, time.Now())
+	if err := os.Rename(fs.path, archive); err != nil {
+		return fmt.Errorf("failed to archive %s: %w", fs.path, err)
+	}
+	// Renaming keeps the mtime, so the index stays current for the archive
+	if _, err := os.Stat(indexPath(fs.path)); err == nil {
+		if err := os.Rename(indexPath(fs.path), indexPath(archive)); err != nil {
+			return fmt.Errorf("failed to archive %s: %w", indexPath(fs.path), err)
+		}
+	}
+
+	return fs.Save(types.NewTree())
+}
+
+// archivePath names an archive after t, adding a counter if two rotations
+// fall in the same second
+func archivePath(dir string, t time.Time) string {
+	base := "tree_" + t.Format("20060102_150405")
+	path := filepath.Join(dir, base+".bin")
+	for n := 2; ; n++ {
+		if _, err := os.Lstat(path); os.IsNotExist(err) {
+			return path
+		}
+		path = filepath.Join(dir, fmt.Sprintf("%s_%d.bin", base, n))
+	}
+}
@@ -0,0 +1,234 @@
+package storage
+
+import (
+	"Hippocampus/src/types"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// ShardedFileStorage spreads a tree across shardCount files in dir
+// (shard_0.bin, shard_1.bin, ...). Node i lives in shard i % shardCount, so
+// merging the shards back in round-robin order restores the original order.
+type ShardedFileStorage struct {
+	dir        string
+	shardCount int
+
+	mu     sync.Mutex
+	shards []*types.Tree // Loaded shards, nil until first Search or Insert
+}
+
+func NewShardedFileStorage(dir string, shardCount int) *ShardedFileStorage {
+	if shardCount < 1 {
+		shardCount = 1
+	}
+	return &ShardedFileStorage{
+		dir:        dir,
+		shardCount: shardCount,
+	}
+}
+
+// ShardPath returns the file holding shard i
+func (ss *ShardedFileStorage) ShardPath(i int) string {
+	return filepath.Join(ss.dir, fmt.Sprintf("shard_%d.bin", i))
+}
+
+// Save splits t into shards and writes them in parallel
+func (ss *ShardedFileStorage) Save(t *types.Tree) error {
+	snapshot := t.DeepCopy()
+
+	shards := make([]*types.Tree, ss.shardCount)
+	for i := range shards {
+		shards[i] = &types.Tree{
+			Nodes: make([]types.Node, 0, len(snapshot.Nodes)/ss.shardCount+1),
+			Index: [512][]int32{},
+		}
+	}
+	for i := range snapshot.Nodes {
+		shard := shards[i%ss.shardCount]
+		shard.Nodes = append(shard.Nodes, snapshot.Nodes[i])
+	}
+
+	if err := ss.writeShards(shards); err != nil {
+		return err
+	}
+
+	ss.mu.Lock()
+	ss.shards = nil // Re-read on next Search so it sees what was saved
+	ss.mu.Unlock()
+	return nil
+}
+
+func (ss *ShardedFileStorage) writeShards(shards []*types.Tree) error {
+	if err := os.MkdirAll(ss.dir, 0755); err != nil {
+		return err
+	}
+
+	errs := make([]error, len(shards))
+	var wg sync.WaitGroup
+	for i, shard := range shards {
+		wg.Add(1)
+		go func(i int, shard *types.Tree) {
+			defer wg.Done()
+			errs[i] = NewFileStorage(ss.ShardPath(i)).Save(shard)
+		}(i, shard)
+	}
+	wg.Wait()
+
+	for i, err := range errs {
+		if err != nil {
+			return fmt.Errorf("shard %d: %w", i, err)
+		}
+	}
+	return nil
+}
+
+// loadShards reads every shard in parallel. Missing shard files load as empty trees.
+func (ss *ShardedFileStorage) loadShards() ([]*types.Tree, error) {
+	shards := make([]*types.Tree, ss.shardCount)
+	errs := make([]error, ss.shardCount)
+
+	var wg sync.WaitGroup
+	for i := 0; i < ss.shardCount; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			shards[i], errs[i] = NewFileStorage(ss.ShardPath(i)).Load()
+		}(i)
+	}
+	wg.Wait()
+
+	for i, err := range errs {
+		if err != nil {
+			return nil, fmt.Errorf("shard %d: %w", i, err)
+		}
+	}
+	return shards, nil
+}
+
+// Load reads all shards and merges them into a single tree
+func (ss *ShardedFileStorage) Load() (*types.Tree, error) {
+	shards, err := ss.loadShards()
+	if err != nil {
+		return nil, err
+	}
+
+	total := 0
+	for _, shard := range shards {
+		total += len(shard.Nodes)
+	}
+
+	t := &types.Tree{
+		Nodes: make([]types.Node, 0, total),
+		Index: [512][]int32{},
+	}
+
+	// Round-robin across shards restores the original node order
+	for row := 0; len(t.Nodes) < total; row++ {
+		for _, shard := range shards {
+			if row < len(shard.Nodes) {
+				t.Nodes = append(t.Nodes, shard.Nodes[row])
+			}
+		}
+	}
+
+	// Indexed on first search
+	return t, nil
+}
+
+// Each calls fn for every stored node, shard by shard, stopping at and
+// returning the first error. Shards not already loaded are read one at a
+// time, so the whole tree is never in memory at once.
+func (ss *ShardedFileStorage) Each(fn func(n *types.Node) error) error {
+	ss.mu.Lock()
+	cached := ss.shards
+	ss.mu.Unlock()
+
+	for i := 0; i < ss.shardCount; i++ {
+		var shard *types.Tree
+		if cached != nil {
+			shard = cached[i]
+		} else {
+			var err error
+			if shard, err = NewFileStorage(ss.ShardPath(i)).Load(); err != nil {
+				return fmt.Errorf("shard %d: %w", i, err)
+			}
+		}
+		if err := shard.Each(fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// cachedShardsLocked returns the loaded shards, loading them on first use.
+// Callers must hold ss.mu.
+func (ss *ShardedFileStorage) cachedShardsLocked() ([]*types.Tree, error) {
+	if ss.shards == nil {
+		shards, err := ss.loadShards()
+		if err != nil {
+			return nil, err
+		}
+		ss.shards = shards
+	}
+	return ss.shards, nil
+}
+
+// Search queries every shard in parallel and merges the hits, closest first
+func (ss *ShardedFileStorage) Search(query [512]float32, opts types.SearchOptions) ([]types.ScoredNode, error) {
+	ss.mu.Lock()
+	shards, err := ss.cachedShardsLocked()
+	ss.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([][]types.ScoredNode, len(shards))
+	var wg sync.WaitGroup
+	for i, shard := range shards {
+		wg.Add(1)
+		go func(i int, shard *types.Tree) {
+			defer wg.Done()
+			results[i] = shard.SearchScored(query, opts)
+		}(i, shard)
+	}
+	wg.Wait()
+
+	var merged []types.ScoredNode
+	for _, r := range results {
+		merged = append(merged, r...)
+	}
+	sort.Slice(merged, func(i, j int) bool {
+		return merged[i].Distance < merged[j].Distance
+	})
+
+	if opts.TopK >= 0 && len(merged) > opts.TopK {
+		merged = merged[:opts.TopK]
+	}
+	return merged, nil
+}
+
+// Insert routes a node to shard (total node count % shardCount) and rewrites that shard's file
+func (ss *ShardedFileStorage) Insert(node types.Node) error {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	shards, err := ss.cachedShardsLocked()
+	if err != nil {
+		return err
+	}
+
+	total := 0
+	for _, shard := range shards {
+		total += shard.Len()
+	}
+
+	i := total % ss.shardCount
+	if err := shards[i].InsertNode(node); err != nil {
+		return err
+	}
+
+	return NewFileStorage(ss.ShardPath(i)).Save(shards[i])
+}
@@ -0,0 +1,183 @@
+package storage
+
+import (
+	"Hippocampus/src/types"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"time"
+)
+
+// FileStat describes a tree file from its header, without loading the nodes
+type FileStat struct {
+	Size      int64
+	ModTime   time.Time
+	Version   uint32
+	Writer    string // Build that wrote the file; empty before format version 3
+	NodeCount int64
+	IndexSize int64 // Size of the .idx file, 0 when there is no current one
+}
+
+// Stat reads the header of the storage file. A missing file is reported as an
+// error satisfying os.IsNotExist, unlike Load which treats it as an empty tree.
+func (fs *FileStorage) Stat() (FileStat, error) {
+	f, err := os.Open(fs.path)
+	if err != nil {
+		return FileStat{}, err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return FileStat{}, err
+	}
+
+	stat := FileStat{
+		Size:    info.Size(),
+		ModTime: info.ModTime(),
+		Version: CurrentFormatVersion,
+	}
+
+	// Load reads an empty file as an empty tree
+	if info.Size() > 0 {
+		header, err := readHeader(f)
+		if err != nil {
+			return FileStat{}, err
+		}
+		stat.Version, stat.Writer, stat.NodeCount = header.Version, header.Writer, header.NodeCount
+	}
+
+	// Same staleness rule as loadIndexFile
+	if idx, err := os.Stat(indexPath(fs.path)); err == nil && idx.ModTime().Equal(info.ModTime()) {
+		stat.IndexSize = idx.Size()
+	}
+
+	return stat, nil
+}
+
+// StorageStats describes what a storage backend holds, for monitoring,
+// without loading the tree through a client
+type StorageStats struct {
+	NodeCount    int64
+	SizeBytes    int64     // Bytes on disk, or estimated bytes in memory for MemoryStorage
+	LastModified time.Time // Zero if nothing was saved yet
+	Backend      string    // "file", "memory", "legacy-file", "append-only" or "sharded"
+	IsCompressed bool      // No backend compresses yet; HDUMP COMPRESS is separate
+}
+
+// Stats reads the file's header. A missing file is reported as empty, as Load
+// treats it.
+func (fs *FileStorage) Stats(ctx context.Context) (StorageStats, error) {
+	if err := ctx.Err(); err != nil {
+		return StorageStats{}, err
+	}
+	stats := StorageStats{Backend: "file"}
+	st, err := fs.Stat()
+	if os.IsNotExist(err) {
+		return stats, nil
+	}
+	if err != nil {
+		return StorageStats{}, err
+	}
+	stats.NodeCount, stats.SizeBytes, stats.LastModified = st.NodeCount, st.Size, st.ModTime
+	return stats, nil
+}
+
+// Stats reports the stored tree's node count and estimated memory, or an
+// empty tree once it has expired
+func (ms *MemoryStorage) Stats(ctx context.Context) (StorageStats, error) {
+	if err := ctx.Err(); err != nil {
+		return StorageStats{}, err
+	}
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	stats := StorageStats{Backend: "memory", LastModified: ms.saved}
+	if !time.Now().After(ms.expireTime) {
+		stats.NodeCount = int64(ms.tree.Len())
+		stats.SizeBytes = ms.tree.MemoryUsage()
+	}
+	return stats, nil
+}
+
+// Stats reads the node count at the start of the file
+func (ls *LegacyFileStorage) Stats(ctx context.Context) (StorageStats, error) {
+	if err := ctx.Err(); err != nil {
+		return StorageStats{}, err
+	}
+	stats := StorageStats{Backend: "legacy-file"}
+	f, err := os.Open(ls.path)
+	if os.IsNotExist(err) {
+		return stats, nil
+	}
+	if err != nil {
+		return StorageStats{}, err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return StorageStats{}, err
+	}
+	stats.SizeBytes, stats.LastModified = info.Size(), info.ModTime()
+	if info.Size() > 0 {
+		if err := binary.Read(f, binary.LittleEndian, &stats.NodeCount); err != nil {
+			return StorageStats{}, err
+		}
+	}
+	return stats, nil
+}
+
+// Stats counts the records in the log, reading it only if it changed since
+// it was last read
+func (as *AppendOnlyFileStorage) Stats(ctx context.Context) (StorageStats, error) {
+	if err := ctx.Err(); err != nil {
+		return StorageStats{}, err
+	}
+	as.mu.Lock()
+	defer as.mu.Unlock()
+
+	stats := StorageStats{Backend: "append-only"}
+	info, err := os.Stat(as.path)
+	if os.IsNotExist(err) {
+		return stats, nil
+	}
+	if err != nil {
+		return StorageStats{}, err
+	}
+	// Same rule as Save for when the log must be read again
+	if as.written == nil || (info.Size() != as.size && !as.torn) {
+		written := make(map[[16]byte]bool)
+		if err := as.replay(func(n *types.Node) { written[types.NodeHash(n)] = true }); err != nil {
+			return StorageStats{}, err
+		}
+		as.written = written
+	}
+	stats.NodeCount = int64(len(as.written))
+	stats.SizeBytes, stats.LastModified = info.Size(), info.ModTime()
+	return stats, nil
+}
+
+// Stats adds up the shard files' headers; LastModified is the newest shard's
+func (ss *ShardedFileStorage) Stats(ctx context.Context) (StorageStats, error) {
+	stats := StorageStats{Backend: "sharded"}
+	for i := 0; i < ss.shardCount; i++ {
+		if err := ctx.Err(); err != nil {
+			return StorageStats{}, err
+		}
+		st, err := NewFileStorage(ss.ShardPath(i)).Stat()
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return StorageStats{}, fmt.Errorf("shard %d: %w", i, err)
+		}
+		stats.NodeCount += st.NodeCount
+		stats.SizeBytes += st.Size
+		if st.ModTime.After(stats.LastModified) {
+			stats.LastModified = st.ModTime
+		}
+	}
+	return stats, nil
+}
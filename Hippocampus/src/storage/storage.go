@@ -2,9 +2,11 @@ package storage
 
 import (
 	"Hippocampus/src/types"
-	"encoding/binary"
-	"io"
+	"context"
+	"errors"
+	"fmt"
 	"os"
+	"path/filepath"
 	"sync"
 	"time"
 )
@@ -13,28 +15,48 @@ import (
 type Storage interface {
 	Save(t *types.Tree) error
 	Load() (*types.Tree, error)
+	Stats(ctx context.Context) (StorageStats, error)
 }
 
 // FileStorage - file-based storage
 type FileStorage struct {
-	path string
+	path    string
+	version uint32 // Format version Save writes
 }
 
 func NewFileStorage(path string) *FileStorage {
-	return &FileStorage{path: path}
+	return &FileStorage{path: path, version: CurrentFormatVersion}
+}
+
+// NewFileStorageVersion creates a file storage that saves in an older format
+// version, from FormatV2 on (see LegacyFileStorage for FormatV1). It reads
+// every version.
+func NewFileStorageVersion(path string, version uint32) *FileStorage {
+	return &FileStorage{path: path, version: version}
+}
+
+// Path returns the file the storage reads and writes
+func (fs *FileStorage) Path() string {
+	return fs.path
 }
 
 // Deprecated: Use NewFileStorage instead
 func New(path string) *FileStorage {
-	return &FileStorage{path: path}
+	return NewFileStorage(path)
 }
 
-// MemoryStorage - in-memory storage with TTL
+// MemoryStorage - in-memory storage with TTL.
+//
+// Consistency model: Save stores the caller's tree by reference, so the saving
+// client keeps writing to the tree it already holds. Load returns a DeepCopy,
+// so a tree obtained from Load never changes underneath its holder because of
+// a later Save, and a reader always sees every write saved before its Load.
 type MemoryStorage struct {
 	mu         sync.RWMutex
 	tree       *types.Tree
 	expireTime time.Time
 	ttl        time.Duration
+	saved      time.Time // Last Save, zero if never saved
 }
 
 func NewMemoryStorage() *MemoryStorage {
@@ -59,12 +81,27 @@ func NewMemoryStorageWithTTL(ttl time.Duration) *MemoryStorage {
 	}
 }
 
+// NewMemoryStorageFromTree creates a storage already holding t, expiring
+// after ttl. Like Save, it keeps t by reference.
+func NewMemoryStorageFromTree(t *types.Tree, ttl time.Duration) *MemoryStorage {
+	return &MemoryStorage{
+		tree:       t,
+		ttl:        ttl,
+		expireTime: time.Now().Add(ttl),
+		saved:      time.Now(),
+	}
+}
+
+// Save stores t and restarts the TTL, so data expires ttl after the last
+// save. Every caller saves on behalf of a user write (Client.Flush, COPY);
+// nothing in the tree saves internally.
 func (ms *MemoryStorage) Save(t *types.Tree) error {
 	ms.mu.Lock()
 	defer ms.mu.Unlock()
 
 	ms.tree = t
-	ms.expireTime = time.Now().Add(ms.ttl)
+	ms.saved = time.Now()
+	ms.expireTime = ms.saved.Add(ms.ttl)
 	return nil
 }
 
@@ -81,7 +118,25 @@ func (ms *MemoryStorage) Load() (*types.Tree, error) {
 		}, nil
 	}
 
-	return ms.tree, nil
+	// Return a snapshot: handing out ms.tree itself would alias it with the
+	// caller's cache, which a concurrent Save could silently replace
+	return ms.tree.DeepCopy(), nil
+}
+
+// ExportToFile writes the stored tree to path in the file format, so it
+// outlives the process; an expired tree is written empty
+func (ms *MemoryStorage) ExportToFile(path string) error {
+	ms.mu.RLock()
+	t := ms.tree
+	if time.Now().After(ms.expireTime) {
+		t = &types.Tree{
+			Nodes: []types.Node{},
+			Index: [512][]int32{},
+		}
+	}
+	ms.mu.RUnlock()
+
+	return NewFileStorage(path).Save(t)
 }
 
 func (ms *MemoryStorage) SetTTL(ttl time.Duration) {
@@ -104,22 +159,45 @@ func (ms *MemoryStorage) Expire() {
 }
 
 func (fs *FileStorage) Save(t *types.Tree) error {
-	f, err := os.Create(fs.path)
+	// Snapshot first so the tree's lock is only held for the copy,
+	// not for the duration of the disk write
+	return fs.save(t.DeepCopy())
+}
+
+// save writes snapshot, a tree no one else holds, to a temporary file that
+// is synced and then renamed over the old one, so a crash mid-write leaves
+// the previous version whole
+func (fs *FileStorage) save(snapshot *types.Tree) error {
+	f, err := os.CreateTemp(filepath.Dir(fs.path), filepath.Base(fs.path)+".tmp-*")
 	if err != nil {
 		return err
 	}
-	defer f.Close()
-
-	if err := binary.Write(f, binary.LittleEndian, int64(len(t.Nodes))); err != nil {
+	fail := func(err error) error {
+		f.Close()
+		os.Remove(f.Name())
 		return err
 	}
 
-	for i := range t.Nodes {
-		if err := writeNode(f, &t.Nodes[i]); err != nil {
-			return err
-		}
+	if err := WriteTreeVersion(f, snapshot, fs.version); err != nil {
+		return fail(err)
+	}
+	if err := f.Chmod(0644); err != nil {
+		return fail(err)
+	}
+	if err := f.Sync(); err != nil {
+		return fail(err)
+	}
+	if err := f.Close(); err != nil {
+		return fail(err)
+	}
+	if err := os.Rename(f.Name(), fs.path); err != nil {
+		return fail(err)
 	}
 
+	// The tree changed, so any persisted index is stale
+	if err := os.Remove(indexPath(fs.path)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("removing stale index: %w", err)
+	}
 	return nil
 }
 
@@ -148,56 +226,15 @@ func (fs *FileStorage) Load() (*types.Tree, error) {
 		}, nil
 	}
 
-	var nodeCount int64
-	if err := binary.Read(f, binary.LittleEndian, &nodeCount); err != nil {
+	t, err := ReadTree(f)
+	if err != nil {
 		return nil, err
 	}
 
-	t := &types.Tree{
-		Nodes: make([]types.Node, nodeCount),
-		Index: [512][]int32{},
-	}
-
-	for i := range t.Nodes {
-		if err := readNode(f, &t.Nodes[i]); err != nil {
-			return nil, err
-		}
-	}
-
-	t.RebuildIndex()
+	// A valid .idx file from SaveWithIndex saves the O(N x 512) rebuild.
+	// Without one the index is built on first search (see Tree.EnsureIndexed),
+	// so loads that only write or copy never pay for it.
+	fs.loadIndexFile(t, info)
 
 	return t, nil
 }
-
-func writeNode(w io.Writer, n *types.Node) error {
-	if err := binary.Write(w, binary.LittleEndian, n.Key); err != nil {
-		return err
-	}
-
-	valueBytes := []byte(n.Value)
-	if err := binary.Write(w, binary.LittleEndian, int64(len(valueBytes))); err != nil {
-		return err
-	}
-
-	_, err := w.Write(valueBytes)
-	return err
-}
-
-func readNode(r io.Reader, n *types.Node) error {
-	if err := binary.Read(r, binary.LittleEndian, &n.Key); err != nil {
-		return err
-	}
-
-	var valueLen int64
-	if err := binary.Read(r, binary.LittleEndian, &valueLen); err != nil {
-		return err
-	}
-
-	valueBytes := make([]byte, valueLen)
-	if _, err := io.ReadFull(r, valueBytes); err != nil {
-		return err
-	}
-
-	n.Value = string(valueBytes)
-	return nil
-}
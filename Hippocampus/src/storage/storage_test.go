@@ -0,0 +1,97 @@
+package storage
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// dirNames returns the names of the entries of dir
+func dirNames(t *testing.T, dir string) []string {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	return names
+}
+
+func TestSaveReplacesFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "tree.bin")
+	fs := NewFileStorage(path)
+
+	for _, n := range []int{3, 5} {
+		if err := fs.Save(testTree(n)); err != nil {
+			t.Fatal(err)
+		}
+		tree, err := fs.Load()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tree.Len() != n {
+			t.Fatalf("loaded %d nodes, want %d", tree.Len(), n)
+		}
+	}
+
+	if names := dirNames(t, dir); len(names) != 1 || names[0] != "tree.bin" {
+		t.Fatalf("directory holds %q, want only tree.bin", names)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mode := info.Mode().Perm(); mode != 0644 {
+		t.Fatalf("file mode %v, want 0644", mode)
+	}
+}
+
+func TestFailedSaveKeepsOldFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "tree.bin")
+	if err := NewFileStorage(path).Save(testTree(3)); err != nil {
+		t.Fatal(err)
+	}
+
+	// The write fails after the temporary file is created
+	if err := NewFileStorageVersion(path, CurrentFormatVersion+1).Save(testTree(5)); err == nil {
+		t.Fatal("Save in a future version succeeded")
+	}
+	tree, err := NewFileStorage(path).Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tree.Len() != 3 {
+		t.Fatalf("loaded %d nodes after a failed save, want the old 3", tree.Len())
+	}
+	if names := dirNames(t, dir); len(names) != 1 {
+		t.Fatalf("failed save left %q behind", names)
+	}
+
+	if err := NewFileStorage(filepath.Join(dir, "missing", "tree.bin")).Save(testTree(1)); err == nil {
+		t.Fatal("Save into a missing directory succeeded")
+	}
+}
+
+func TestSaveRemovesStaleIndex(t *testing.T) {
+	path := saveWithIndex(t, 4)
+	if err := NewFileStorage(path).Save(testTree(6)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(IndexPath(path)); !os.IsNotExist(err) {
+		t.Fatalf(".idx file still there after Save: %v", err)
+	}
+
+	// An index that can't be removed is an error, not a stale index left
+	// for Load to trust
+	if err := os.MkdirAll(filepath.Join(IndexPath(path), "busy"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := NewFileStorage(path).Save(testTree(2)); err == nil {
+		t.Fatal("Save ignored an index it couldn't remove")
+	}
+}
@@ -0,0 +1,165 @@
+package types
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"runtime"
+	"sort"
+	"testing"
+)
+
+// randomKey returns an embedding with every dimension in [0, 1)
+func randomKey(rng *rand.Rand) [512]float32 {
+	var key [512]float32
+	for dim := range key {
+		key[dim] = rng.Float32()
+	}
+	return key
+}
+
+// randomTree returns a tree of n nodes with random embeddings, its index not
+// built yet
+func randomTree(n int) *Tree {
+	rng := rand.New(rand.NewPCG(1, uint64(n)))
+	nodes := make([]Node, n)
+	for i := range nodes {
+		nodes[i] = Node{Key: randomKey(rng), ID: fmt.Sprintf("node%d", i)}
+	}
+	return &Tree{Nodes: nodes}
+}
+
+func BenchmarkRebuildIndex(b *testing.B) {
+	for _, n := range []int{10_000, 50_000, 100_000} {
+		b.Run(fmt.Sprintf("nodes=%d", n), func(b *testing.B) {
+			tree := randomTree(n)
+			b.ResetTimer()
+			for range b.N {
+				tree.RebuildIndex()
+			}
+		})
+	}
+}
+
+// indexedTree is randomTree with its index built
+func indexedTree(b *testing.B, n int) *Tree {
+	b.Helper()
+	tree := randomTree(n)
+	tree.RebuildIndex()
+	return tree
+}
+
+// BenchmarkSearchGCPressure searches the way clients do, with the query in
+// an array from GetKeyArray, and reports the garbage collections per search
+// next to the allocations. "unpooled" takes the pooled candidate set and
+// query array out of play, as before the pools.
+func BenchmarkSearchGCPressure(b *testing.B) {
+	tree := indexedTree(b, 10_000)
+	query := tree.Nodes[0].Key
+
+	for _, pooled := range []bool{true, false} {
+		name := "pooled"
+		if !pooled {
+			name = "unpooled"
+		}
+		b.Run(name, func(b *testing.B) {
+			b.ReportAllocs()
+			var before, after runtime.MemStats
+			runtime.GC()
+			runtime.ReadMemStats(&before)
+			b.ResetTimer()
+
+			for range b.N {
+				var key *[512]float32
+				if pooled {
+					key = GetKeyArray()
+				} else {
+					key = new([512]float32)
+					getCandidateSet() // Dropped, so the search makes a new one
+				}
+				*key = query
+				if hits := tree.Search(*key, 0.05, 0.5, 10); len(hits) == 0 {
+					b.Fatal("no hits")
+				}
+				if pooled {
+					PutKeyArray(key)
+				}
+			}
+
+			b.StopTimer()
+			runtime.ReadMemStats(&after)
+			b.ReportMetric(float64(after.NumGC-before.NumGC)/float64(b.N), "gcs/op")
+		})
+	}
+}
+
+// BenchmarkSearchParallel compares one worker with four at 100k nodes
+func BenchmarkSearchParallel(b *testing.B) {
+	tree := indexedTree(b, 100_000)
+	query := tree.Nodes[0].Key
+
+	for _, workers := range []int{1, 4} {
+		b.Run(fmt.Sprintf("workers=%d", workers), func(b *testing.B) {
+			for range b.N {
+				if hits := tree.SearchParallel(query, 0.05, 0.5, 10, workers); len(hits) == 0 {
+					b.Fatal("no hits")
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkTopK selects the best topK of 10000 hits with a SearchHeap and by
+// sorting them all
+func BenchmarkTopK(b *testing.B) {
+	rng := rand.New(rand.NewPCG(1, 2))
+	hits := make([]ScoredNode, 10_000)
+	for i := range hits {
+		hits[i].Distance = rng.Float32()
+	}
+
+	for _, topK := range []int{10, 1000} {
+		b.Run(fmt.Sprintf("heap/topK=%d", topK), func(b *testing.B) {
+			for range b.N {
+				best := NewSearchHeap(topK)
+				for _, hit := range hits {
+					best.Offer(hit)
+				}
+				best.Results()
+			}
+		})
+		b.Run(fmt.Sprintf("sort/topK=%d", topK), func(b *testing.B) {
+			sorted := make([]ScoredNode, len(hits))
+			for range b.N {
+				copy(sorted, hits)
+				sort.Slice(sorted, func(i, j int) bool { return sorted[i].Distance < sorted[j].Distance })
+				_ = sorted[:topK]
+			}
+		})
+	}
+}
+
+// BenchmarkNearestNeighbor compares NearestNeighbor with Search for topK 1
+// at 50k nodes. The query is near, not on, a node, so the scan can't stop
+// at an exact match.
+func BenchmarkNearestNeighbor(b *testing.B) {
+	tree := indexedTree(b, 50_000)
+	query := tree.Nodes[len(tree.Nodes)/2].Key
+	for dim := range query {
+		query[dim] += 0.001
+	}
+
+	b.Run("nearest", func(b *testing.B) {
+		for range b.N {
+			if node, _ := tree.NearestNeighbor(query, Euclidean); node == nil {
+				b.Fatal("no node")
+			}
+		}
+	})
+	b.Run("search", func(b *testing.B) {
+		for range b.N {
+			if hits := tree.Search(query, 0.05, 0.5, 1); len(hits) != 1 {
+				b.Fatal("no hit")
+			}
+		}
+	})
+}
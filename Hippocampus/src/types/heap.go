@@ -0,0 +1,66 @@
+package types
+
+import (
+	"container/heap"
+	"sort"
+)
+
+// SearchHeap keeps the K best-scoring hits offered to it, so a search holds
+// K candidates instead of every hit above the threshold. It implements
+// heap.Interface with the worst kept hit at the root.
+type SearchHeap struct {
+	items []ScoredNode
+	k     int
+}
+
+// NewSearchHeap returns a heap keeping at most k hits
+func NewSearchHeap(k int) *SearchHeap {
+	if k < 0 {
+		k = 0
+	}
+	return &SearchHeap{items: make([]ScoredNode, 0, min(k, 64)), k: k}
+}
+
+func (h *SearchHeap) Len() int { return len(h.items) }
+
+// Less puts the farthest hit (lowest score) at the root
+func (h *SearchHeap) Less(i, j int) bool { return h.items[i].Distance > h.items[j].Distance }
+
+func (h *SearchHeap) Swap(i, j int) { h.items[i], h.items[j] = h.items[j], h.items[i] }
+
+func (h *SearchHeap) Push(x any) { h.items = append(h.items, x.(ScoredNode)) }
+
+func (h *SearchHeap) Pop() any {
+	last := h.items[len(h.items)-1]
+	h.items = h.items[:len(h.items)-1]
+	return last
+}
+
+// Accepts reports whether a hit at distance would be kept by Offer, so
+// callers can skip building it
+func (h *SearchHeap) Accepts(distance float32) bool {
+	return len(h.items) < h.k || (h.k > 0 && distance < h.items[0].Distance)
+}
+
+// Offer adds n if fewer than K hits are kept, or replaces the worst kept hit
+// when n scores higher
+func (h *SearchHeap) Offer(n ScoredNode) {
+	switch {
+	case len(h.items) < h.k:
+		heap.Push(h, n)
+	case h.k > 0 && n.Distance < h.items[0].Distance:
+		h.items[0] = n
+		heap.Fix(h, 0)
+	}
+}
+
+// Results returns the kept hits best first (descending score). The heap is
+// left empty.
+func (h *SearchHeap) Results() []ScoredNode {
+	results := h.items
+	h.items = nil
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].Distance < results[j].Distance
+	})
+	return results
+}
@@ -0,0 +1,154 @@
+package types
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"slices"
+	"sort"
+	"testing"
+)
+
+// hit returns a scored node named after its distance
+func hit(distance float32) ScoredNode {
+	return ScoredNode{Node: Node{ID: fmt.Sprint(distance)}, Distance: distance}
+}
+
+func TestSearchHeapKeepsBestK(t *testing.T) {
+	tests := []struct {
+		name      string
+		k         int
+		distances []float32
+		want      []string
+	}{
+		{"fewer than k", 5, []float32{3, 1, 2}, []string{"1", "2", "3"}},
+		{"exactly k", 3, []float32{2, 3, 1}, []string{"1", "2", "3"}},
+		{"evicts the farthest", 3, []float32{5, 4, 3, 2, 1, 6}, []string{"1", "2", "3"}},
+		{"best arrive first", 2, []float32{1, 2, 3, 4}, []string{"1", "2"}},
+		{"k of 1", 1, []float32{4, 2, 9, 3}, []string{"2"}},
+		{"k of 0", 0, []float32{1, 2}, []string{}},
+		{"negative k", -3, []float32{1, 2}, []string{}},
+		{"nothing offered", 4, nil, []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := NewSearchHeap(tt.k)
+			for _, d := range tt.distances {
+				h.Offer(hit(d))
+			}
+			got := resultIDs(h.Results())
+			if !slices.Equal(got, tt.want) {
+				t.Fatalf("Results = %v, want %v", got, tt.want)
+			}
+			if h.Len() != 0 {
+				t.Fatalf("Len = %d after Results, want 0", h.Len())
+			}
+		})
+	}
+}
+
+func TestSearchHeapAccepts(t *testing.T) {
+	h := NewSearchHeap(2)
+	if !h.Accepts(100) {
+		t.Fatal("an empty heap refused a hit")
+	}
+	h.Offer(hit(1))
+	h.Offer(hit(5))
+
+	// Full: only hits closer than the worst kept one get in
+	for _, tt := range []struct {
+		distance float32
+		want     bool
+	}{{4, true}, {5, false}, {6, false}} {
+		if got := h.Accepts(tt.distance); got != tt.want {
+			t.Errorf("Accepts(%g) = %v, want %v", tt.distance, got, tt.want)
+		}
+	}
+	if NewSearchHeap(0).Accepts(0) {
+		t.Error("a heap of 0 accepted a hit")
+	}
+}
+
+func TestSearchHeapMatchesSort(t *testing.T) {
+	rng := rand.New(rand.NewPCG(3, 3))
+	distances := make([]float32, 1000)
+	for i := range distances {
+		distances[i] = rng.Float32()
+	}
+	sorted := slices.Clone(distances)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	for _, k := range []int{1, 10, 999, 1000, 2000} {
+		h := NewSearchHeap(k)
+		for _, d := range distances {
+			h.Offer(hit(d))
+		}
+		results := h.Results()
+		want := sorted[:min(k, len(sorted))]
+		if len(results) != len(want) {
+			t.Fatalf("k=%d: %d results, want %d", k, len(results), len(want))
+		}
+		for i := range want {
+			if results[i].Distance != want[i] {
+				t.Fatalf("k=%d: result %d at %g, want %g", k, i, results[i].Distance, want[i])
+			}
+		}
+	}
+}
+
+func TestSearchScoredOrderAndTopK(t *testing.T) {
+	// Nodes at distance 0.1, 0.2, ... 0.9 from the origin along dimension 0
+	tree := NewTree()
+	for i := 9; i >= 1; i-- {
+		var key [512]float32
+		key[0] = float32(i) / 10
+		if err := tree.InsertNode(Node{Key: key, ID: fmt.Sprintf("n%d", i), Value: fmt.Sprintf("v%d", i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var origin [512]float32
+
+	tests := []struct {
+		name string
+		opts SearchOptions
+		want []string
+	}{
+		{"closest first", SearchOptions{Epsilon: 1, TopK: 9}, []string{"n1", "n2", "n3", "n4", "n5", "n6", "n7", "n8", "n9"}},
+		{"top K keeps the closest", SearchOptions{Epsilon: 1, TopK: 3}, []string{"n1", "n2", "n3"}},
+		{"top K above the hits", SearchOptions{Epsilon: 1, TopK: 50}, []string{"n1", "n2", "n3", "n4", "n5", "n6", "n7", "n8", "n9"}},
+		{"top K of 0", SearchOptions{Epsilon: 1, TopK: 0}, nil},
+		{"epsilon bounds dimension 0", SearchOptions{Epsilon: 0.45, TopK: 9}, []string{"n1", "n2", "n3", "n4"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hits := tree.SearchScored(origin, tt.opts)
+			if got := resultIDs(hits); !slices.Equal(got, tt.want) {
+				t.Fatalf("SearchScored = %v, want %v", got, tt.want)
+			}
+			for i := 1; i < len(hits); i++ {
+				if hits[i].Score > hits[i-1].Score {
+					t.Fatalf("score of hit %d is %g, above %g before it", i, hits[i].Score, hits[i-1].Score)
+				}
+			}
+		})
+	}
+
+	// Scores fall from 1 at the query to 0 at the corner of the box
+	diagonal := float32(0.5) * 22.627417 // 0.5 * sqrt(512)
+	hits := tree.SearchScored(origin, SearchOptions{Epsilon: 0.5, TopK: 1})
+	if want := 1 - 0.1/diagonal; len(hits) != 1 || hits[0].Distance != 0.1 || !approx(hits[0].Score, want) {
+		t.Fatalf("SearchScored = %+v, want n1 at distance 0.1 scoring %g", hits, want)
+	}
+
+	// A threshold drops hits beyond its share of the diagonal
+	cutoff := 0.25 / diagonal
+	hits = tree.SearchScored(origin, SearchOptions{Epsilon: 0.5, Threshold: 1 - cutoff, TopK: 9})
+	if got := resultIDs(hits); !slices.Equal(got, []string{"n1", "n2"}) {
+		t.Fatalf("threshold kept %v, want [n1 n2]", got)
+	}
+}
+
+// approx compares scores computed in float32
+func approx(a, b float32) bool {
+	d := a - b
+	return d < 1e-5 && d > -1e-5
+}
@@ -0,0 +1,114 @@
+package types
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+)
+
+// nodeJSON is the JSON form of a Node. The embedding is base64 of its 512
+// little-endian float32s, the same bytes as in a database file, which is
+// about half the size of a JSON array of numbers and round-trips exactly.
+type nodeJSON struct {
+	ID       string          `json:"id"`
+	Value    string          `json:"value"`
+	Metadata json.RawMessage `json:"metadata,omitempty"`
+	Key      string          `json:"key"`
+}
+
+// newNodeJSON returns the JSON form of n
+func newNodeJSON(n *Node) nodeJSON {
+	j := nodeJSON{ID: n.ID, Value: n.Value, Key: EncodeKey(n.Key)}
+	if n.Metadata != "" {
+		j.Metadata = json.RawMessage(n.Metadata)
+	}
+	return j
+}
+
+// EncodeKey returns an embedding as base64 of its little-endian float32s
+func EncodeKey(key [512]float32) string {
+	var buf [512 * 4]byte
+	for i, f := range key {
+		binary.LittleEndian.PutUint32(buf[i*4:], math.Float32bits(f))
+	}
+	return base64.StdEncoding.EncodeToString(buf[:])
+}
+
+// DecodeKey reverses EncodeKey
+func DecodeKey(s string) ([512]float32, error) {
+	var key [512]float32
+	buf, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return key, fmt.Errorf("invalid embedding: %w", err)
+	}
+	if len(buf) != len(key)*4 {
+		return key, fmt.Errorf("invalid embedding: %d bytes, expected %d", len(buf), len(key)*4)
+	}
+	for i := range key {
+		key[i] = math.Float32frombits(binary.LittleEndian.Uint32(buf[i*4:]))
+	}
+	return key, nil
+}
+
+func (n Node) MarshalJSON() ([]byte, error) {
+	return json.Marshal(newNodeJSON(&n))
+}
+
+func (n *Node) UnmarshalJSON(data []byte) error {
+	var j nodeJSON
+	if err := json.Unmarshal(data, &j); err != nil {
+		return err
+	}
+	node := Node{ID: j.ID, Value: j.Value}
+	if string(j.Metadata) != "null" {
+		node.Metadata = string(j.Metadata)
+	}
+	if j.Key != "" {
+		key, err := DecodeKey(j.Key)
+		if err != nil {
+			return err
+		}
+		node.Key = key
+	}
+	*n = node
+	return nil
+}
+
+// String describes a node for logs: its ID and the start of its value,
+// without the embedding
+func (n Node) String() string {
+	const maxValue = 60
+	value := []rune(n.Value)
+	if len(value) > maxValue {
+		return fmt.Sprintf("%q: %q...", n.ID, string(value[:maxValue]))
+	}
+	return fmt.Sprintf("%q: %q", n.ID, n.Value)
+}
+
+// MarshalJSON writes a search hit as its node's fields plus score and
+// distance
+func (s ScoredNode) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		nodeJSON
+		Score    float32 `json:"score"`
+		Distance float32 `json:"distance"`
+	}{newNodeJSON(&s.Node), s.Score, s.Distance})
+}
+
+func (s *ScoredNode) UnmarshalJSON(data []byte) error {
+	var node Node
+	if err := json.Unmarshal(data, &node); err != nil {
+		return err
+	}
+	var j struct {
+		Score    float32 `json:"score"`
+		Distance float32 `json:"distance"`
+	}
+	if err := json.Unmarshal(data, &j); err != nil {
+		return err
+	}
+	*s = ScoredNode{Node: node, Score: j.Score, Distance: j.Distance}
+	return nil
+}
@@ -0,0 +1,85 @@
+package types
+
+import "math"
+
+// nearestBlock is how many dimensions NearestNeighbor sums between checks
+// against the best distance so far
+const nearestBlock = 64
+
+// NearestNeighbor returns a copy of the node closest to key and its
+// distance, or nil if the tree is empty. Unlike Search with topK 1 there is
+// no bounding box or threshold, so any non-empty tree gives a node. It
+// scans every node, keeping only the best so far; a node is given up on as
+// soon as its partial distance exceeds the best, and the scan stops at an
+// exact match.
+func (t *Tree) NearestNeighbor(key [512]float32, metric Metric) (*Node, float32) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	if len(t.Nodes) == 0 {
+		return nil, 0
+	}
+
+	var best int
+	var distance float32
+	switch metric {
+	case Euclidean:
+		best, distance = t.nearestEuclideanLocked(&key)
+	case Cosine:
+		best, distance = t.nearestCosineLocked(&key)
+	default:
+		return nil, 0
+	}
+
+	node := t.Nodes[best]
+	return &node, distance
+}
+
+// nearestEuclideanLocked compares squared distances, taking the root once
+func (t *Tree) nearestEuclideanLocked(key *[512]float32) (int, float32) {
+	best := -1
+	bestSquares := float32(math.Inf(1))
+
+nodes:
+	for i := range t.Nodes {
+		nodeKey := &t.Nodes[i].Key
+		var sumSquares float32
+		for start := 0; start < 512; start += nearestBlock {
+			for dim := start; dim < start+nearestBlock; dim++ {
+				diff := key[dim] - nodeKey[dim]
+				sumSquares += diff * diff
+			}
+			if sumSquares >= bestSquares {
+				continue nodes
+			}
+		}
+
+		best, bestSquares = i, sumSquares
+		if sumSquares == 0 {
+			break
+		}
+	}
+
+	if best < 0 {
+		// Every distance overflowed to +Inf; any node is as near as another
+		return 0, float32(math.Inf(1))
+	}
+	return best, float32(math.Sqrt(float64(bestSquares)))
+}
+
+// nearestCosineLocked has no early exit per node, since a partial dot
+// product doesn't bound the angle
+func (t *Tree) nearestCosineLocked(key *[512]float32) (int, float32) {
+	best := 0
+	bestDistance := float32(math.Inf(1))
+	for i := range t.Nodes {
+		distance := cosineDistance(key, &t.Nodes[i].Key)
+		if distance < bestDistance {
+			best, bestDistance = i, distance
+			if distance <= 0 {
+				break
+			}
+		}
+	}
+	return best, bestDistance
+}
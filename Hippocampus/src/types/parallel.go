@@ -0,0 +1,127 @@
+package types
+
+import (
+	"math"
+	"sync"
+)
+
+// SearchParallel is Search with the per-dimension range scans and the
+// distance checks spread over workers goroutines
+func (t *Tree) SearchParallel(query [512]float32, epsilon float32, threshold float32, topK int, workers int) []Node {
+	scored := t.SearchParallelScored(query, SearchOptions{
+		Epsilon:   epsilon,
+		Threshold: threshold,
+		TopK:      topK,
+	}, workers)
+	if scored == nil {
+		return nil
+	}
+
+	results := make([]Node, len(scored))
+	for i := range scored {
+		results[i] = scored[i].Node
+	}
+	return results
+}
+
+// SearchParallelScored is SearchScored split over workers goroutines. Each
+// worker scans a contiguous group of dimensions into its own counts; a node
+// is a candidate when the counts sum to 512. Candidates are then split
+// between the workers for the distance checks. Results match SearchScored.
+func (t *Tree) SearchParallelScored(query [512]float32, opts SearchOptions, workers int) []ScoredNode {
+	if workers < 1 {
+		workers = 1
+	}
+	if workers > 512 {
+		workers = 512
+	}
+
+	t.ensureIndex()
+
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	t.rlockShards()
+	defer t.runlockShards()
+
+	nodeCount := len(t.Nodes)
+	if nodeCount == 0 {
+		return nil
+	}
+
+	// Per-worker counts of the dimensions whose bounding box holds each node.
+	// A worker covers at most 512 dimensions, so uint16 is enough.
+	counts := make([][]uint16, workers)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		first, last := w*512/workers, (w+1)*512/workers
+		counts[w] = make([]uint16, nodeCount)
+
+		wg.Add(1)
+		go func(local []uint16) {
+			defer wg.Done()
+			for dim := first; dim < last; dim++ {
+				epsilon := opts.epsilonFor(dim)
+				start, end := t.dimRangeLocked(dim, query[dim]-epsilon, query[dim]+epsilon)
+				for _, nodeIdx := range t.Index[dim][start:end] {
+					local[nodeIdx]++
+				}
+			}
+		}(counts[w])
+	}
+	wg.Wait()
+
+	var candidateIdx []int32
+	for i := 0; i < nodeCount; i++ {
+		total := 0
+		for w := range counts {
+			total += int(counts[w][i])
+		}
+		if total == 512 {
+			candidateIdx = append(candidateIdx, int32(i))
+		}
+	}
+
+	var epsilonSquares float32
+	for dim := 0; dim < 512; dim++ {
+		epsilon := opts.epsilonFor(dim)
+		epsilonSquares += epsilon * epsilon
+	}
+	diagonal := float32(math.Sqrt(float64(epsilonSquares)))
+	maxAllowedDistance := diagonal * (1.0 - opts.Threshold)
+
+	// Score the candidates in parallel, each worker keeping its own top K
+	found := make([]*SearchHeap, workers)
+	for w := 0; w < workers; w++ {
+		first, last := w*len(candidateIdx)/workers, (w+1)*len(candidateIdx)/workers
+		found[w] = NewSearchHeap(opts.TopK)
+
+		wg.Add(1)
+		go func(best *SearchHeap) {
+			defer wg.Done()
+			for _, nodeIdx := range candidateIdx[first:last] {
+				distance := euclidean(&query, &t.Nodes[nodeIdx].Key)
+				if distance > maxAllowedDistance || !best.Accepts(distance) {
+					continue
+				}
+				score := float32(1.0)
+				if diagonal > 0 {
+					score = 1.0 - distance/diagonal
+				}
+				best.Offer(ScoredNode{
+					Node:     t.Nodes[nodeIdx],
+					Distance: distance,
+					Score:    score,
+				})
+			}
+		}(found[w])
+	}
+	wg.Wait()
+
+	best := NewSearchHeap(opts.TopK)
+	for _, h := range found {
+		for _, n := range h.items {
+			best.Offer(n)
+		}
+	}
+	return best.Results()
+}
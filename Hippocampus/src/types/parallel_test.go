@@ -0,0 +1,60 @@
+package types
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"slices"
+	"testing"
+)
+
+// resultIDs returns the IDs of hits in order
+func resultIDs(hits []ScoredNode) []string {
+	ids := make([]string, len(hits))
+	for i, hit := range hits {
+		ids[i] = hit.Node.ID
+	}
+	return ids
+}
+
+func TestSearchParallelMatchesSearchScored(t *testing.T) {
+	rng := rand.New(rand.NewPCG(7, 7))
+	hits := 0
+	for _, n := range []int{0, 1, 50, 777} {
+		tree := randomTree(n)
+		tree.RebuildIndex()
+
+		queries := [][512]float32{randomKey(rng)}
+		if n > 0 {
+			// A stored key, so threshold 1 still has an exact hit
+			queries = append(queries, tree.Nodes[n/2].Key)
+		}
+		var narrow SearchOptions
+		for dim := range narrow.DimensionEpsilons {
+			narrow.DimensionEpsilons[dim] = 0.6 + float32(dim%3)/10
+		}
+		narrow.TopK = 20
+
+		for qi, query := range queries {
+			for _, opts := range []SearchOptions{
+				{Epsilon: 1, Threshold: 0, TopK: 10},
+				{Epsilon: 1, Threshold: 0.6, TopK: n + 1},
+				{Epsilon: 1, Threshold: 1, TopK: 5},
+				{Epsilon: 1, Threshold: 0, TopK: 0},
+				narrow,
+			} {
+				want := resultIDs(tree.SearchScored(query, opts))
+				hits += len(want)
+				for _, workers := range []int{-1, 1, 2, 3, 8, 600} {
+					name := fmt.Sprintf("n=%d/query%d/eps=%g,thr=%g,k=%d/workers=%d", n, qi, opts.Epsilon, opts.Threshold, opts.TopK, workers)
+					got := resultIDs(tree.SearchParallelScored(query, opts, workers))
+					if !slices.Equal(got, want) {
+						t.Errorf("%s: got %v, want %v", name, got, want)
+					}
+				}
+			}
+		}
+	}
+	if hits == 0 {
+		t.Fatal("no search found anything to compare")
+	}
+}
@@ -0,0 +1,40 @@
+package types
+
+import "sync"
+
+// keyArrayPool recycles embedding-sized arrays for callers that would
+// otherwise allocate one per query
+var keyArrayPool = sync.Pool{
+	New: func() any { return new([512]float32) },
+}
+
+// GetKeyArray returns a zeroed [512]float32 from the pool. Return it with
+// PutKeyArray once nothing references it.
+func GetKeyArray() *[512]float32 {
+	k := keyArrayPool.Get().(*[512]float32)
+	*k = [512]float32{}
+	return k
+}
+
+// PutKeyArray returns an array obtained from GetKeyArray to the pool
+func PutKeyArray(k *[512]float32) {
+	if k != nil {
+		keyArrayPool.Put(k)
+	}
+}
+
+// candidatePool recycles the per-search candidate counts. A search allocates
+// one entry per node inside any dimension's bounding box, so the map is by
+// far the largest per-search allocation.
+var candidatePool = sync.Pool{
+	New: func() any { return make(map[int32]int) },
+}
+
+func getCandidateSet() map[int32]int {
+	return candidatePool.Get().(map[int32]int)
+}
+
+func putCandidateSet(m map[int32]int) {
+	clear(m)
+	candidatePool.Put(m)
+}
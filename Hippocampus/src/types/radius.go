@@ -0,0 +1,143 @@
+package types
+
+import (
+	"fmt"
+	"math"
+	"sort"
+)
+
+// Metric is how SearchRadius measures the distance between two keys
+type Metric int
+
+const (
+	// Euclidean is the straight-line distance, as used by SearchScored
+	Euclidean Metric = iota
+	// Cosine is 1 minus the cosine similarity, from 0 (same direction) to 2
+	// (opposite directions)
+	Cosine
+)
+
+func (m Metric) String() string {
+	switch m {
+	case Euclidean:
+		return "euclidean"
+	case Cosine:
+		return "cosine"
+	}
+	return fmt.Sprintf("Metric(%d)", int(m))
+}
+
+// ParseMetric parses a metric name as printed by Metric.String
+func ParseMetric(s string) (Metric, error) {
+	switch s {
+	case "euclidean":
+		return Euclidean, nil
+	case "cosine":
+		return Cosine, nil
+	}
+	return 0, fmt.Errorf("unknown metric %q (expected euclidean or cosine)", s)
+}
+
+// SearchRadius returns every node within radius of key, closest first.
+// Unlike Search there is no top-K cap, so a large radius can return the
+// whole tree.
+func (t *Tree) SearchRadius(key [512]float32, radius float32, metric Metric) []Node {
+	scored := t.SearchRadiusScored(key, radius, metric)
+	nodes := make([]Node, len(scored))
+	for i := range scored {
+		nodes[i] = scored[i].Node
+	}
+	return nodes
+}
+
+// SearchRadiusScored is SearchRadius returning each node's distance. Score is
+// 1 at the key falling to 0 at the radius.
+func (t *Tree) SearchRadiusScored(key [512]float32, radius float32, metric Metric) []ScoredNode {
+	if radius < 0 || math.IsNaN(float64(radius)) {
+		return nil
+	}
+
+	var results []ScoredNode
+	switch metric {
+	case Euclidean:
+		results = t.radiusEuclidean(&key, radius)
+	case Cosine:
+		results = t.radiusCosine(&key, radius)
+	default:
+		return nil
+	}
+
+	for i := range results {
+		results[i].Score = 1
+		if radius > 0 {
+			results[i].Score = 1 - results[i].Distance/radius
+		}
+	}
+	sort.Slice(results, func(i, j int) bool { return results[i].Distance < results[j].Distance })
+	return results
+}
+
+// radiusEuclidean narrows the search with the index: a node within radius is
+// within radius of key in every dimension, so it lies in all 512 ranges
+func (t *Tree) radiusEuclidean(key *[512]float32, radius float32) []ScoredNode {
+	t.ensureIndex()
+
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	t.rlockShards()
+	defer t.runlockShards()
+
+	if len(t.Nodes) == 0 {
+		return nil
+	}
+
+	candidateSet := getCandidateSet()
+	defer putCandidateSet(candidateSet)
+
+	for dim := 0; dim < 512; dim++ {
+		startIdx, endIdx := t.dimRangeLocked(dim, key[dim]-radius, key[dim]+radius)
+		for i := startIdx; i < endIdx; i++ {
+			candidateSet[t.Index[dim][i]]++
+		}
+	}
+
+	var results []ScoredNode
+	for nodeIdx, count := range candidateSet {
+		if count != 512 {
+			continue
+		}
+		if distance := euclidean(key, &t.Nodes[nodeIdx].Key); distance <= radius {
+			results = append(results, ScoredNode{Node: t.Nodes[nodeIdx], Distance: distance})
+		}
+	}
+	return results
+}
+
+// radiusCosine scans every node; per-dimension ranges don't bound an angle
+func (t *Tree) radiusCosine(key *[512]float32, radius float32) []ScoredNode {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	var results []ScoredNode
+	for i := range t.Nodes {
+		if distance := cosineDistance(key, &t.Nodes[i].Key); distance <= radius {
+			results = append(results, ScoredNode{Node: t.Nodes[i], Distance: distance})
+		}
+	}
+	return results
+}
+
+// cosineDistance returns 1 minus the cosine similarity of a and b, treating
+// a zero vector as orthogonal to everything
+func cosineDistance(a, b *[512]float32) float32 {
+	var dot, normA, normB float64
+	for i := 0; i < 512; i++ {
+		dot += float64(a[i]) * float64(b[i])
+		normA += float64(a[i]) * float64(a[i])
+		normB += float64(b[i]) * float64(b[i])
+	}
+	if normA == 0 || normB == 0 {
+		return 1
+	}
+	return float32(1 - dot/(math.Sqrt(normA)*math.Sqrt(normB)))
+}
@@ -0,0 +1,126 @@
+package types
+
+import (
+	"math"
+	"slices"
+	"testing"
+)
+
+// radiusTree holds "origin" at the origin, "a" at 0.5 and "b" at 2 along
+// dimension 0, and "c" at 0.5 along dimension 0 and 1
+func radiusTree(t *testing.T) *Tree {
+	t.Helper()
+	tree := NewTree()
+	for _, n := range []struct {
+		id     string
+		d0, d1 float32
+	}{{"origin", 0, 0}, {"a", 0.5, 0}, {"b", 2, 0}, {"c", 0.5, 0.5}} {
+		var key [512]float32
+		key[0], key[1] = n.d0, n.d1
+		if err := tree.InsertNode(Node{Key: key, ID: n.id, Value: n.id}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return tree
+}
+
+func TestSearchRadiusEuclidean(t *testing.T) {
+	tree := radiusTree(t)
+	var origin [512]float32
+	distC := float32(math.Sqrt(0.5))
+
+	tests := []struct {
+		name   string
+		radius float32
+		want   []string
+	}{
+		{"zero radius finds the exact key", 0, []string{"origin"}},
+		{"just inside", 0.5, []string{"origin", "a"}},
+		{"just outside", math.Nextafter32(0.5, 0), []string{"origin"}},
+		{"on the diagonal", distC, []string{"origin", "a", "c"}},
+		{"just short of the diagonal", math.Nextafter32(distC, 0), []string{"origin", "a"}},
+		{"everything", 10, []string{"origin", "a", "c", "b"}},
+		{"negative", -1, nil},
+		{"NaN", float32(math.NaN()), nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hits := tree.SearchRadiusScored(origin, tt.radius, Euclidean)
+			if got := resultIDs(hits); !slices.Equal(got, tt.want) {
+				t.Fatalf("SearchRadius(%g) = %v, want %v", tt.radius, got, tt.want)
+			}
+			for _, h := range hits {
+				if h.Distance > tt.radius || h.Score < 0 || h.Score > 1 {
+					t.Fatalf("hit %s at %g scoring %g", h.Node.ID, h.Distance, h.Score)
+				}
+			}
+		})
+	}
+
+	// The score runs from 1 at the key to 0 at the radius
+	hits := tree.SearchRadiusScored(origin, 0.5, Euclidean)
+	if hits[0].Score != 1 || hits[1].Score != 0 {
+		t.Fatalf("scores %g and %g, want 1 and 0", hits[0].Score, hits[1].Score)
+	}
+	if hits := tree.SearchRadiusScored(origin, 0, Euclidean); hits[0].Score != 1 {
+		t.Fatalf("exact hit at zero radius scored %g, want 1", hits[0].Score)
+	}
+	if got := tree.SearchRadius(origin, 0.5, Euclidean); len(got) != 2 || got[1].ID != "a" {
+		t.Fatalf("SearchRadius = %v", got)
+	}
+}
+
+func TestSearchRadiusCosine(t *testing.T) {
+	tree := radiusTree(t)
+	var query [512]float32
+	query[0] = 3
+
+	// a and b point the same way as the query; c is 45 degrees off it, and
+	// the origin counts as orthogonal
+	distC := float32(1 - math.Sqrt(0.5))
+	tests := []struct {
+		name   string
+		radius float32
+		want   []string
+	}{
+		{"zero radius finds the same direction", 0, []string{"a", "b"}},
+		{"just inside", distC, []string{"a", "b", "c"}},
+		{"just outside", math.Nextafter32(distC, 0), []string{"a", "b"}},
+		{"orthogonal", 1, []string{"a", "b", "c", "origin"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := resultIDs(tree.SearchRadiusScored(query, tt.radius, Cosine))
+			slices.Sort(got[:min(2, len(got))]) // a and b tie at 0
+			if !slices.Equal(got, tt.want) {
+				t.Fatalf("SearchRadius(%g) = %v, want %v", tt.radius, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSearchRadiusEmpty(t *testing.T) {
+	var origin [512]float32
+	for _, metric := range []Metric{Euclidean, Cosine} {
+		if got := NewTree().SearchRadiusScored(origin, 1, metric); len(got) != 0 {
+			t.Errorf("%s search of an empty tree found %v", metric, got)
+		}
+		if got := NewTree().SearchRadius(origin, 1, metric); len(got) != 0 {
+			t.Errorf("%s SearchRadius of an empty tree found %v", metric, got)
+		}
+	}
+	if got := radiusTree(t).SearchRadiusScored(origin, 1, Metric(9)); got != nil {
+		t.Errorf("unknown metric found %v", got)
+	}
+}
+
+func TestParseMetric(t *testing.T) {
+	for _, m := range []Metric{Euclidean, Cosine} {
+		if got, err := ParseMetric(m.String()); got != m || err != nil {
+			t.Errorf("ParseMetric(%q) = %v, %v", m.String(), got, err)
+		}
+	}
+	if _, err := ParseMetric("manhattan"); err == nil {
+		t.Error("ParseMetric accepted manhattan")
+	}
+}
@@ -1,151 +1,1014 @@
 package types
 
 import (
+	"crypto/md5"
+	"encoding/binary"
+	"errors"
 	"math"
+	"runtime"
 	"sort"
+	"sync"
+	"sync/atomic"
+	"unsafe"
 )
 
+// ErrDuplicateKey is returned by Insert when a node with the same ID is
+// already stored, or for nodes without an ID, one with an identical embedding
+var ErrDuplicateKey = errors.New("duplicate embedding already stored under this key")
+
+// dedupQuantum is the precision embeddings are rounded to before hashing, so
+// float noise below it doesn't defeat duplicate detection
+const dedupQuantum = 1e-6
+
 type Node struct {
-	Key   [512]float32
-	Value string
+	Key      [512]float32
+	ID       string // Caller-provided key
+	Value    string
+	Metadata string // Optional JSON supplied with the memory, stored as is
 }
 
 type Tree struct {
-	Nodes []Node
-	Index [512][]int32
+	Nodes      []Node
+	Index      [512][]int32
 	indexDirty bool // Track if indices need rebuilding
+
+	// DedupIndex maps the hash of a node's ID and quantized embedding to its
+	// node index
+	DedupIndex     map[[16]byte]int32
+	duplicateCount int // Inserts rejected as duplicates
+
+	// ids maps each non-empty ID to its node index, so lookups by ID don't
+	// scan the nodes. Built with DedupIndex; nil until then.
+	ids map[string]int32
+
+	// shadowed counts nodes whose ID or dedup hash an earlier node already
+	// holds, which only files from before IDs were unique contain. Removals
+	// patch the lookup maps in place unless there are any.
+	shadowed int
+
+	// mu guards Nodes, DedupIndex, ids, shadowed, duplicateCount and
+	// indexDirty. Index is split into indexShards shards of indexShardDims
+	// dimensions, each guarded by its shardMu, so concurrent inserts patch
+	// different shards in parallel instead of queueing for the whole tree.
+	// Inserts hold structMu shared throughout; operations that renumber
+	// nodes or replace the whole index hold it exclusively, and may then
+	// touch Index under mu alone. Lock order: structMu, mu, shardMu in
+	// shard order.
+	structMu sync.RWMutex
+	mu       sync.RWMutex
+	shardMu  [indexShards]sync.RWMutex
+
+	// view is Nodes as of the latest append, read by inserts patching the
+	// index without holding mu. Every node an index entry refers to is in it.
+	view atomic.Pointer[[]Node]
 }
 
+const (
+	indexShards    = 16
+	indexShardDims = 512 / indexShards
+)
+
 func NewTree() *Tree {
 	return &Tree{
-		Nodes: make([]Node, 0, 1000), // Preallocate for 1000 nodes
-		Index: [512][]int32{},
+		Nodes:      make([]Node, 0, 1000), // Preallocate for 1000 nodes
+		Index:      [512][]int32{},
 		indexDirty: false,
+		DedupIndex: make(map[[16]byte]int32),
+		ids:        make(map[string]int32),
+	}
+}
+
+// NodeHash identifies a node the way the tree deduplicates them: nodes with
+// the same ID and embeddings equal after quantization hash the same. The
+// same text stored under two keys is two nodes.
+func NodeHash(n *Node) [16]byte {
+	return nodeHash(&n.Key, n.ID)
+}
+
+// nodeHash returns the MD5 of the embedding quantized to dedupQuantum,
+// followed by the ID
+func nodeHash(key *[512]float32, id string) [16]byte {
+	var buf [512 * 4]byte
+	for dim := 0; dim < 512; dim++ {
+		quantized := int32(math.Round(float64(key[dim]) / dedupQuantum))
+		binary.LittleEndian.PutUint32(buf[dim*4:], uint32(quantized))
+	}
+	h := md5.New()
+	h.Write(buf[:])
+	h.Write([]byte(id))
+	var sum [16]byte
+	h.Sum(sum[:0])
+	return sum
+}
+
+// rebuildLookupsLocked recomputes DedupIndex and the ID index from Nodes.
+// Files written before IDs were unique may repeat one; the first node wins.
+func (t *Tree) rebuildLookupsLocked() {
+	t.DedupIndex = make(map[[16]byte]int32, len(t.Nodes))
+	t.ids = make(map[string]int32, len(t.Nodes))
+	t.shadowed = 0
+	for i := range t.Nodes {
+		hash := NodeHash(&t.Nodes[i])
+		_, hashTaken := t.DedupIndex[hash]
+		if !hashTaken {
+			t.DedupIndex[hash] = int32(i)
+		}
+		id := t.Nodes[i].ID
+		_, idTaken := t.ids[id]
+		if id != "" && !idTaken {
+			t.ids[id] = int32(i)
+		}
+		if hashTaken || idTaken {
+			t.shadowed++
+		}
+	}
+}
+
+// lookupsPatchable reports whether a removal can patch the lookup maps with
+// forgetLocked and renumberLookupsLocked. Otherwise removed nodes may have
+// shadowed others, which must take their place, so the maps are rebuilt.
+func (t *Tree) lookupsPatchable() bool {
+	return t.DedupIndex != nil && t.ids != nil && t.shadowed == 0
+}
+
+// forgetLocked drops n, about to be removed, from the lookup maps
+func (t *Tree) forgetLocked(n *Node) {
+	delete(t.DedupIndex, NodeHash(n))
+	if n.ID != "" {
+		delete(t.ids, n.ID)
+	}
+}
+
+// renumberLookupsLocked moves the lookup map entries to the positions
+// newPos gives the remaining nodes after a removal, hashing nothing
+func (t *Tree) renumberLookupsLocked(newPos func(old int32) int32) {
+	for hash, i := range t.DedupIndex {
+		t.DedupIndex[hash] = newPos(i)
+	}
+	for id, i := range t.ids {
+		t.ids[id] = newPos(i)
+	}
+}
+
+// ensureLookups builds DedupIndex and the ID index for trees whose Nodes
+// were filled directly, e.g. by a storage load, so readers holding mu
+// shared can use them
+func (t *Tree) ensureLookups() {
+	t.mu.RLock()
+	built := t.DedupIndex != nil && t.ids != nil
+	t.mu.RUnlock()
+	if built {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.DedupIndex == nil || t.ids == nil {
+		t.rebuildLookupsLocked()
 	}
 }
 
-func (t *Tree) Insert(key [512]float32, value string) {
-	nodeIdx := int32(len(t.Nodes))
-	node := Node{
+// Insert adds a node without an ID, returning ErrDuplicateKey if another
+// without one has an identical embedding
+func (t *Tree) Insert(key [512]float32, value string) error {
+	return t.InsertNode(Node{
 		Key:   key,
 		Value: value,
+	})
+}
+
+// InsertNode adds a fully populated node, returning ErrDuplicateKey if a
+// node with the same ID is already stored; UpsertNode replaces it instead.
+// Nodes without an ID are duplicates when their embeddings are identical.
+// The node is appended under mu, then
+// a built index is patched one shard at a time, so concurrent inserts only
+// serialize on the append and on shards being patched at the same moment.
+func (t *Tree) InsertNode(node Node) error {
+	hash := NodeHash(&node)
+
+	t.structMu.RLock()
+	defer t.structMu.RUnlock()
+
+	t.mu.Lock()
+	nodeIdx, patch, err := t.appendLocked(node, hash)
+	t.mu.Unlock()
+	if err != nil || !patch {
+		return err
+	}
+	t.patchShards(nodeIdx)
+	return nil
+}
+
+// patchShards adds the node at nodeIdx to a built index one shard at a
+// time. Callers hold structMu shared, and appended the node under mu.
+func (t *Tree) patchShards(nodeIdx int32) {
+	for shard := 0; shard < indexShards; shard++ {
+		t.shardMu[shard].Lock()
+		// Loaded under the shard lock, so it holds every node the shard refers to
+		nodes := *t.view.Load()
+		for dim := shard * indexShardDims; dim < (shard+1)*indexShardDims; dim++ {
+			t.Index[dim] = insertIndexEntry(t.Index[dim], nodes, dim, nodeIdx)
+		}
+		t.shardMu[shard].Unlock()
+	}
+}
+
+// insertLocked is InsertNode for callers holding structMu and mu exclusively
+func (t *Tree) insertLocked(node Node) error {
+	nodeIdx, patch, err := t.appendLocked(node, NodeHash(&node))
+	if err != nil || !patch {
+		return err
+	}
+	for dim := 0; dim < 512; dim++ {
+		t.Index[dim] = insertIndexEntry(t.Index[dim], t.Nodes, dim, nodeIdx)
+	}
+	return nil
+}
+
+// appendLocked adds node, which hashes to hash, to Nodes. patch
+// reports whether the caller must add it to a built index; otherwise the
+// index is marked for rebuilding on the next search. Callers hold mu.
+func (t *Tree) appendLocked(node Node, hash [16]byte) (nodeIdx int32, patch bool, err error) {
+	// Trees built from struct literals start without lookup maps
+	if t.DedupIndex == nil || t.ids == nil {
+		t.rebuildLookupsLocked()
+	}
+
+	_, exists := t.DedupIndex[hash]
+	if !exists && node.ID != "" {
+		_, exists = t.ids[node.ID]
+	}
+	if exists {
+		t.duplicateCount++
+		return 0, false, ErrDuplicateKey
+	}
+
+	nodeIdx = int32(len(t.Nodes))
+	t.DedupIndex[hash] = nodeIdx
+	if node.ID != "" {
+		t.ids[node.ID] = nodeIdx
 	}
 	t.Nodes = append(t.Nodes, node)
+	nodes := t.Nodes
+	t.view.Store(&nodes)
 
 	// If indices exist, update them incrementally
+	t.shardMu[0].RLock()
+	built := len(t.Index[0]) > 0
+	t.shardMu[0].RUnlock()
+	if built && !t.indexDirty {
+		return nodeIdx, true, nil
+	}
+
+	// Mark indices as dirty - will rebuild on next search
+	t.indexDirty = true
+	return nodeIdx, false, nil
+}
+
+// insertIndexEntry adds nodeIdx to index, the entries of dim sorted by
+// value, after any entries with an equal value
+func insertIndexEntry(index []int32, nodes []Node, dim int, nodeIdx int32) []int32 {
+	value := nodes[nodeIdx].Key[dim]
+	insertPos := sort.Search(len(index), func(i int) bool {
+		return nodes[index[i]].Key[dim] > value
+	})
+	index = append(index, 0)
+	copy(index[insertPos+1:], index[insertPos:])
+	index[insertPos] = nodeIdx
+	return index
+}
+
+// ReplaceID gives the node with the given ID a new embedding and value,
+// keeping its ID and metadata; the node moves to the end. Returns false if
+// there is no such node, and ErrDuplicateKey if another node has the ID and
+// embedding.
+func (t *Tree) ReplaceID(id string, key [512]float32, value string) (bool, error) {
+	return t.replace(Node{Key: key, ID: id, Value: value}, true)
+}
+
+// ReplaceNode is ReplaceID replacing the metadata too, with node's
+func (t *Tree) ReplaceNode(node Node) (bool, error) {
+	return t.replace(node, false)
+}
+
+func (t *Tree) replace(node Node, keepMetadata bool) (bool, error) {
+	t.structMu.Lock()
+	defer t.structMu.Unlock()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.DedupIndex == nil || t.ids == nil {
+		t.rebuildLookupsLocked()
+	}
+	i := t.findIDLocked(node.ID)
+	if i < 0 {
+		return false, nil
+	}
+	return true, t.replaceLocked(i, node, keepMetadata)
+}
+
+// UpsertNode is InsertNode replacing the node already stored under node's
+// ID, metadata included, instead of failing, and reports whether it did.
+// ErrDuplicateKey is returned only when that node has the same embedding.
+// A node with a new ID is inserted like InsertNode, holding the tree
+// exclusively only to replace one.
+func (t *Tree) UpsertNode(node Node) (replaced bool, err error) {
+	if inserted, err := t.insertNew(node); inserted || err != nil {
+		return false, err
+	}
+
+	t.structMu.Lock()
+	defer t.structMu.Unlock()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.DedupIndex == nil || t.ids == nil {
+		t.rebuildLookupsLocked()
+	}
+	i := -1
+	if node.ID != "" {
+		i = t.findIDLocked(node.ID)
+	}
+	if i < 0 {
+		return false, t.insertLocked(node)
+	}
+	if NodeHash(&t.Nodes[i]) == NodeHash(&node) {
+		t.duplicateCount++
+		return false, ErrDuplicateKey
+	}
+	return true, t.replaceLocked(i, node, false)
+}
+
+// insertNew inserts node if no node has its ID, reporting whether it did
+func (t *Tree) insertNew(node Node) (inserted bool, err error) {
+	hash := NodeHash(&node)
+
+	t.structMu.RLock()
+	defer t.structMu.RUnlock()
+
+	t.mu.Lock()
+	if t.DedupIndex == nil || t.ids == nil {
+		t.rebuildLookupsLocked()
+	}
+	if node.ID != "" && t.findIDLocked(node.ID) >= 0 {
+		t.mu.Unlock()
+		return false, nil
+	}
+	nodeIdx, patch, err := t.appendLocked(node, hash)
+	t.mu.Unlock()
+	if err != nil || !patch {
+		return err == nil, err
+	}
+	t.patchShards(nodeIdx)
+	return true, nil
+}
+
+// replaceLocked swaps node in for the node at i. Callers hold structMu and
+// mu exclusively, with the lookup maps built.
+func (t *Tree) replaceLocked(i int, node Node, keepMetadata bool) error {
+	if j, exists := t.DedupIndex[NodeHash(&node)]; exists && int(j) != i {
+		t.duplicateCount++
+		return ErrDuplicateKey
+	}
+
+	if keepMetadata {
+		node.Metadata = t.Nodes[i].Metadata
+	}
+	t.removeLocked(i)
+	return t.insertLocked(node)
+}
+
+// Remove deletes the node at index i, shifting later nodes down one place.
+// A built index is patched in place rather than rebuilt. Returns false if i
+// is out of range.
+func (t *Tree) Remove(i int) bool {
+	t.structMu.Lock()
+	defer t.structMu.Unlock()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.removeLocked(i)
+}
+
+// RemoveID deletes the node with the given ID, returning false if there is none
+func (t *Tree) RemoveID(id string) bool {
+	t.structMu.Lock()
+	defer t.structMu.Unlock()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.DedupIndex == nil || t.ids == nil {
+		t.rebuildLookupsLocked()
+	}
+	return t.removeLocked(t.findIDLocked(id))
+}
+
+func (t *Tree) removeLocked(i int) bool {
+	if i < 0 || i >= len(t.Nodes) {
+		return false
+	}
+
+	patchLookups := t.lookupsPatchable()
+	if patchLookups {
+		t.forgetLocked(&t.Nodes[i])
+	}
+	t.Nodes = append(t.Nodes[:i], t.Nodes[i+1:]...)
+
+	if len(t.Index[0]) > 0 && !t.indexDirty {
+		removed := int32(i)
+		for dim := 0; dim < 512; dim++ {
+			kept := t.Index[dim][:0]
+			for _, idx := range t.Index[dim] {
+				switch {
+				case idx == removed:
+					continue
+				case idx > removed:
+					idx--
+				}
+				kept = append(kept, idx)
+			}
+			t.Index[dim] = kept
+		}
+	}
+
+	// Dedup and ID entries hold node positions, which just shifted
+	if patchLookups {
+		t.renumberLookupsLocked(func(old int32) int32 {
+			if old > int32(i) {
+				return old - 1
+			}
+			return old
+		})
+	} else {
+		t.rebuildLookupsLocked()
+	}
+	return true
+}
+
+// RemoveMany deletes the nodes at the given indices in a single pass,
+// patching a built index and the dedup map once rather than per node.
+// Indices out of range or repeated are ignored. Returns the number of nodes
+// removed.
+func (t *Tree) RemoveMany(nodeIndices []int) int {
+	t.structMu.Lock()
+	defer t.structMu.Unlock()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.removeManyLocked(nodeIndices)
+}
+
+// RemoveIDs is RemoveMany for the nodes with the given IDs, returning the
+// IDs removed in the order given. IDs with no node, or repeated, are ignored.
+func (t *Tree) RemoveIDs(ids []string) []string {
+	t.structMu.Lock()
+	defer t.structMu.Unlock()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.DedupIndex == nil || t.ids == nil {
+		t.rebuildLookupsLocked()
+	}
+	var indices []int
+	var removed []string
+	seen := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+		if i := t.findIDLocked(id); i >= 0 {
+			indices = append(indices, i)
+			removed = append(removed, id)
+		}
+	}
+	t.removeManyLocked(indices)
+	return removed
+}
+
+func (t *Tree) removeManyLocked(nodeIndices []int) int {
+	// newPos maps each old node position to its new one, -1 for removed nodes
+	newPos := make([]int32, len(t.Nodes))
+	for _, i := range nodeIndices {
+		if i >= 0 && i < len(newPos) {
+			newPos[i] = -1
+		}
+	}
+	patchLookups := t.lookupsPatchable()
+	kept := 0
+	for i := range t.Nodes {
+		if newPos[i] < 0 {
+			if patchLookups {
+				t.forgetLocked(&t.Nodes[i])
+			}
+			continue
+		}
+		newPos[i] = int32(kept)
+		t.Nodes[kept] = t.Nodes[i]
+		kept++
+	}
+	removed := len(t.Nodes) - kept
+	if removed == 0 {
+		return 0
+	}
+	clear(t.Nodes[kept:])
+	t.Nodes = t.Nodes[:kept]
+
 	if len(t.Index[0]) > 0 && !t.indexDirty {
 		for dim := 0; dim < 512; dim++ {
-			insertPos := sort.Search(len(t.Index[dim]), func(i int) bool {
-				return t.Nodes[t.Index[dim][i]].Key[dim] >= key[dim]
-			})
-			t.Index[dim] = append(t.Index[dim], 0)
-			copy(t.Index[dim][insertPos+1:], t.Index[dim][insertPos:])
-			t.Index[dim][insertPos] = nodeIdx
+			entries := t.Index[dim][:0]
+			for _, idx := range t.Index[dim] {
+				if pos := newPos[idx]; pos >= 0 {
+					entries = append(entries, pos)
+				}
+			}
+			t.Index[dim] = entries
 		}
+	}
+
+	if patchLookups {
+		t.renumberLookupsLocked(func(old int32) int32 { return newPos[old] })
 	} else {
-		// Mark indices as dirty - will rebuild on next search
-		t.indexDirty = true
+		t.rebuildLookupsLocked()
 	}
+	return removed
+}
+
+// Each calls fn for every node in order, stopping at and returning the
+// first error. The tree is read-locked throughout, so fn must not modify
+// it, and n is only valid during the call.
+func (t *Tree) Each(fn func(n *Node) error) error {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	for i := range t.Nodes {
+		if err := fn(&t.Nodes[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// IDs returns the ID of every node, in node order
+func (t *Tree) IDs() []string {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	ids := make([]string, len(t.Nodes))
+	for i := range t.Nodes {
+		ids[i] = t.Nodes[i].ID
+	}
+	return ids
+}
+
+// FindID returns the index of the node with the given ID, or -1
+func (t *Tree) FindID(id string) int {
+	t.ensureLookups()
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.findIDLocked(id)
+}
+
+// GetID returns a copy of the node with the given ID
+func (t *Tree) GetID(id string) (Node, bool) {
+	t.ensureLookups()
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	i := t.findIDLocked(id)
+	if i < 0 {
+		return Node{}, false
+	}
+	return t.Nodes[i], true
+}
+
+// HasID reports whether a node has the given ID, without copying it
+func (t *Tree) HasID(id string) bool {
+	t.ensureLookups()
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	return t.findIDLocked(id) >= 0
+}
+
+// findIDLocked looks id up in the ID index, which callers have built
+func (t *Tree) findIDLocked(id string) int {
+	if i, ok := t.ids[id]; ok {
+		return int(i)
+	}
+	return -1
+}
+
+// First returns a copy of the first node, if any
+func (t *Tree) First() (Node, bool) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	if len(t.Nodes) == 0 {
+		return Node{}, false
+	}
+	return t.Nodes[0], true
+}
+
+// Len returns the number of nodes
+func (t *Tree) Len() int {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return len(t.Nodes)
+}
+
+// DuplicateCount returns how many inserts were rejected as duplicates
+func (t *Tree) DuplicateCount() int {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.duplicateCount
+}
+
+// MemoryUsage estimates the bytes held by the tree: nodes with their strings,
+// the per-dimension index and the lookup maps
+func (t *Tree) MemoryUsage() int64 {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	t.rlockShards()
+	defer t.runlockShards()
+
+	const nodeSize = int64(unsafe.Sizeof(Node{}))
+	usage := int64(cap(t.Nodes)) * nodeSize
+	for i := range t.Nodes {
+		usage += int64(len(t.Nodes[i].ID) + len(t.Nodes[i].Value))
+	}
+	for dim := range t.Index {
+		usage += int64(cap(t.Index[dim])) * 4
+	}
+	usage += int64(len(t.DedupIndex)) * (16 + 4)
+	for id := range t.ids {
+		usage += int64(len(id)) + int64(unsafe.Sizeof(id)) + 4
+	}
+	return usage
+}
+
+// rlockShards read-locks every index shard, for reading Index under mu
+// while inserts may be patching it
+func (t *Tree) rlockShards() {
+	for shard := range t.shardMu {
+		t.shardMu[shard].RLock()
+	}
+}
+
+func (t *Tree) runlockShards() {
+	for shard := range t.shardMu {
+		t.shardMu[shard].RUnlock()
+	}
+}
+
+// IndexEntries returns the number of entries across the per-dimension index
+func (t *Tree) IndexEntries() int {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	t.rlockShards()
+	defer t.runlockShards()
+
+	entries := 0
+	for dim := range t.Index {
+		entries += len(t.Index[dim])
+	}
+	return entries
 }
 
 func (t *Tree) RebuildIndex() {
+	t.structMu.Lock()
+	defer t.structMu.Unlock()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.rebuildIndexLocked()
+}
+
+func (t *Tree) rebuildIndexLocked() {
 	nodeCount := len(t.Nodes)
-	for dim := 0; dim < 512; dim++ {
-		t.Index[dim] = make([]int32, nodeCount)
-		for i := 0; i < nodeCount; i++ {
-			t.Index[dim][i] = int32(i)
+
+	// Dimensions are sorted independently, a block at a time, so spread the
+	// blocks over the CPUs
+	blocks := make(chan int, 512/indexBlockDims)
+	for dim := 0; dim < 512; dim += indexBlockDims {
+		blocks <- dim
+	}
+	close(blocks)
+
+	workers := runtime.NumCPU()
+	if nodeCount < parallelIndexThreshold {
+		workers = 1
+	}
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			// Sorting (key, index) pairs keeps the work in contiguous buffers
+			// instead of jumping across 2KB nodes. One pass over the nodes
+			// fills a whole block, reading a cache line per node.
+			var entries [indexBlockDims][]indexEntry
+			for d := range entries {
+				entries[d] = make([]indexEntry, nodeCount)
+			}
+			scratch := make([]indexEntry, nodeCount)
+
+			for first := range blocks {
+				for i := range t.Nodes {
+					key := (*[indexBlockDims]float32)(t.Nodes[i].Key[first:])
+					for d := range entries {
+						entries[d][i] = indexEntry{key: sortableBits(key[d]), idx: int32(i)}
+					}
+				}
+
+				for d := range entries {
+					radixSort(entries[d], scratch)
+
+					index := make([]int32, nodeCount)
+					for i := range entries[d] {
+						index[i] = entries[d][i].idx
+					}
+					t.Index[first+d] = index
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	t.indexDirty = false
+
+	t.rebuildLookupsLocked()
+}
+
+// indexBlockDims is how many dimensions rebuildIndexLocked gathers per pass
+// over the nodes; 16 float32s fill one 64-byte cache line
+const indexBlockDims = 16
+
+// parallelIndexThreshold is the node count below which rebuildIndexLocked
+// sorts on a single goroutine, as starting workers would cost more than it saves
+const parallelIndexThreshold = 1024
+
+// indexEntry is one node's value in the dimension being sorted
+type indexEntry struct {
+	key uint32 // sortableBits of the value
+	idx int32
+}
+
+// sortableBits maps a float32 to a uint32 with the same ordering, so values
+// can be radix sorted: negatives have every bit flipped, positives the sign bit
+func sortableBits(f float32) uint32 {
+	bits := math.Float32bits(f)
+	if bits&0x80000000 != 0 {
+		return ^bits
+	}
+	return bits | 0x80000000
+}
+
+// radixSort sorts entries by key with an LSD radix sort, one byte per pass.
+// It is stable, so nodes with equal values stay in insertion order. scratch
+// must be at least as long as entries.
+func radixSort(entries, scratch []indexEntry) {
+	if len(entries) < 2 {
+		return
+	}
+
+	src, dst := entries, scratch[:len(entries)]
+	for shift := 0; shift < 32; shift += 8 {
+		var counts [256]int
+		for i := range src {
+			counts[byte(src[i].key>>shift)]++
 		}
-		sort.Slice(t.Index[dim], func(i, j int) bool {
-			return t.Nodes[t.Index[dim][i]].Key[dim] < t.Nodes[t.Index[dim][j]].Key[dim]
-		})
+		// Every key has the same byte here, nothing to move
+		if counts[byte(src[0].key>>shift)] == len(src) {
+			continue
+		}
+
+		offset := 0
+		for b := range counts {
+			offset, counts[b] = offset+counts[b], offset
+		}
+		for i := range src {
+			b := byte(src[i].key >> shift)
+			dst[counts[b]] = src[i]
+			counts[b]++
+		}
+		src, dst = dst, src
+	}
+
+	if &src[0] != &entries[0] {
+		copy(entries, src)
 	}
+}
+
+// SetIndex installs a previously built index (e.g. loaded from disk) instead of
+// rebuilding it. Each slice must hold every node index sorted by that dimension.
+func (t *Tree) SetIndex(index [512][]int32) {
+	t.structMu.Lock()
+	defer t.structMu.Unlock()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.Index = index
 	t.indexDirty = false
 }
 
+// EnsureIndexed builds the search index now if it is missing or stale, e.g.
+// right after a load, instead of leaving it to the first search
+func (t *Tree) EnsureIndexed() {
+	t.ensureIndex()
+}
+
 // ensureIndex ensures indices are built before search
 func (t *Tree) ensureIndex() {
+	t.mu.RLock()
+	t.shardMu[0].RLock()
+	stale := t.indexDirty || len(t.Index[0]) == 0
+	t.shardMu[0].RUnlock()
+	t.mu.RUnlock()
+	if !stale {
+		return
+	}
+
+	t.structMu.Lock()
+	defer t.structMu.Unlock()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	// Double-check after acquiring write lock
 	if t.indexDirty || len(t.Index[0]) == 0 {
-		t.RebuildIndex()
+		t.rebuildIndexLocked()
 	}
 }
 
+// DeepCopy returns an independent snapshot of the tree. The copy shares no
+// memory with t, so later inserts or index rebuilds on either tree are not
+// visible in the other. Inserts wait for the copy, so it never holds a
+// node whose index entries are only partly added.
+func (t *Tree) DeepCopy() *Tree {
+	t.structMu.Lock()
+	defer t.structMu.Unlock()
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	cp := &Tree{
+		Nodes:          make([]Node, len(t.Nodes), cap(t.Nodes)),
+		indexDirty:     t.indexDirty,
+		duplicateCount: t.duplicateCount,
+		shadowed:       t.shadowed,
+	}
+	copy(cp.Nodes, t.Nodes)
+
+	if t.DedupIndex != nil {
+		cp.DedupIndex = make(map[[16]byte]int32, len(t.DedupIndex))
+		for hash, idx := range t.DedupIndex {
+			cp.DedupIndex[hash] = idx
+		}
+	}
+	if t.ids != nil {
+		cp.ids = make(map[string]int32, len(t.ids))
+		for id, idx := range t.ids {
+			cp.ids[id] = idx
+		}
+	}
+
+	for dim := 0; dim < 512; dim++ {
+		if t.Index[dim] == nil {
+			continue
+		}
+		cp.Index[dim] = make([]int32, len(t.Index[dim]))
+		copy(cp.Index[dim], t.Index[dim])
+	}
+
+	return cp
+}
+
+// SearchOptions controls the bounding box and filtering used by SearchWithOptions
+type SearchOptions struct {
+	Epsilon   float32
+	Threshold float32
+	TopK      int
+
+	// DimensionEpsilons overrides Epsilon per dimension for anisotropic
+	// embedding spaces. A zero entry falls back to Epsilon.
+	DimensionEpsilons [512]float32
+}
+
+// epsilonFor returns the bounding box half-width for a dimension
+func (o *SearchOptions) epsilonFor(dim int) float32 {
+	if o.DimensionEpsilons[dim] != 0 {
+		return o.DimensionEpsilons[dim]
+	}
+	return o.Epsilon
+}
+
 func (t *Tree) Search(query [512]float32, epsilon float32, threshold float32, topK int) []Node {
-	if len(t.Nodes) == 0 {
+	return t.SearchWithOptions(query, SearchOptions{
+		Epsilon:   epsilon,
+		Threshold: threshold,
+		TopK:      topK,
+	})
+}
+
+// ScoredNode is a search hit with its distance from the query. Score rescales
+// the distance to the threshold's 0.0-1.0 range: a node is returned when
+// Score >= threshold, and 1.0 is an exact match.
+type ScoredNode struct {
+	Node     Node
+	Distance float32
+	Score    float32
+}
+
+func (t *Tree) SearchWithOptions(query [512]float32, opts SearchOptions) []Node {
+	scored := t.SearchScored(query, opts)
+	if scored == nil {
 		return nil
 	}
 
+	results := make([]Node, len(scored))
+	for i := range scored {
+		results[i] = scored[i].Node
+	}
+	return results
+}
+
+// dimRangeLocked returns the span of t.Index[dim] holding nodes whose value in
+// that dimension lies in [minVal, maxVal]. Callers must hold t.mu and the
+// dimension's shard lock.
+func (t *Tree) dimRangeLocked(dim int, minVal, maxVal float32) (start, end int) {
+	start = sort.Search(len(t.Index[dim]), func(i int) bool {
+		return t.Nodes[t.Index[dim][i]].Key[dim] >= minVal
+	})
+	end = sort.Search(len(t.Index[dim]), func(i int) bool {
+		return t.Nodes[t.Index[dim][i]].Key[dim] > maxVal
+	})
+	return start, end
+}
+
+// euclidean returns the distance between two embeddings
+func euclidean(a, b *[512]float32) float32 {
+	var sumSquares float32
+	for dim := 0; dim < 512; dim++ {
+		diff := a[dim] - b[dim]
+		sumSquares += diff * diff
+	}
+	return float32(math.Sqrt(float64(sumSquares)))
+}
+
+// SearchScored is SearchWithOptions returning distances and scores, closest first
+func (t *Tree) SearchScored(query [512]float32, opts SearchOptions) []ScoredNode {
+	topK := opts.TopK
+	threshold := opts.Threshold
+
 	// Ensure indices are built
 	t.ensureIndex()
 
-	// Preallocate candidate set with estimated size
-	candidateSet := make(map[int32]int, len(t.Nodes)/10)
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	t.rlockShards()
+	defer t.runlockShards()
+
+	if len(t.Nodes) == 0 {
+		return nil
+	}
+
+	// Counts how many dimensions' bounding boxes each node falls in
+	candidateSet := getCandidateSet()
+	defer putCandidateSet(candidateSet)
 
+	// Sum of squared per-dimension epsilons; sqrt gives the bounding box diagonal
+	var epsilonSquares float32
 	for dim := 0; dim < 512; dim++ {
+		epsilon := opts.epsilonFor(dim)
+		epsilonSquares += epsilon * epsilon
+
 		minVal := query[dim] - epsilon
 		maxVal := query[dim] + epsilon
 
-		startIdx := sort.Search(len(t.Index[dim]), func(i int) bool {
-			return t.Nodes[t.Index[dim][i]].Key[dim] >= minVal
-		})
-
-		endIdx := sort.Search(len(t.Index[dim]), func(i int) bool {
-			return t.Nodes[t.Index[dim][i]].Key[dim] > maxVal
-		})
-
+		startIdx, endIdx := t.dimRangeLocked(dim, minVal, maxVal)
 		for i := startIdx; i < endIdx; i++ {
 			nodeIdx := t.Index[dim][i]
 			candidateSet[nodeIdx]++
 		}
 	}
 
-	type scoredNode struct {
-		node     Node
-		distance float32
-	}
-
-	// Preallocate candidates slice
-	candidates := make([]scoredNode, 0, topK*2)
-	maxAllowedDistance := epsilon * float32(math.Sqrt(512)) * (1.0 - threshold)
+	// Keep only the topK closest instead of sorting every hit
+	best := NewSearchHeap(topK)
+	diagonal := float32(math.Sqrt(float64(epsilonSquares)))
+	maxAllowedDistance := diagonal * (1.0 - threshold)
 
 	for nodeIdx, count := range candidateSet {
 		if count == 512 {
-			var sumSquares float32
-			for dim := 0; dim < 512; dim++ {
-				diff := query[dim] - t.Nodes[nodeIdx].Key[dim]
-				sumSquares += diff * diff
-			}
-			distance := float32(math.Sqrt(float64(sumSquares)))
+			distance := euclidean(&query, &t.Nodes[nodeIdx].Key)
 
-			if distance <= maxAllowedDistance {
-				candidates = append(candidates, scoredNode{
-					node:     t.Nodes[nodeIdx],
-					distance: distance,
+			if distance <= maxAllowedDistance && best.Accepts(distance) {
+				score := float32(1.0)
+				if diagonal > 0 {
+					score = 1.0 - distance/diagonal
+				}
+				best.Offer(ScoredNode{
+					Node:     t.Nodes[nodeIdx],
+					Distance: distance,
+					Score:    score,
 				})
 			}
 		}
 	}
 
-	// Sort only if we have more results than needed
-	if len(candidates) > topK {
-		sort.Slice(candidates, func(i, j int) bool {
-			return candidates[i].distance < candidates[j].distance
-		})
-	} else if len(candidates) > 1 {
-		// For small result sets, still sort
-		sort.Slice(candidates, func(i, j int) bool {
-			return candidates[i].distance < candidates[j].distance
-		})
-	}
-
-	limit := topK
-	if len(candidates) < topK {
-		limit = len(candidates)
-	}
-
-	results := make([]Node, limit)
-	for i := 0; i < limit; i++ {
-		results[i] = candidates[i].node
-	}
-
-	return results
+	return best.Results()
 }
@@ -0,0 +1,365 @@
+package types
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"sync"
+	"testing"
+)
+
+// embedding returns a key with a single non-zero dimension
+func embedding(dim int) [512]float32 {
+	var key [512]float32
+	key[dim] = 1
+	return key
+}
+
+func TestInsertSameEmbeddingUnderTwoKeys(t *testing.T) {
+	tree := NewTree()
+	if err := tree.InsertNode(Node{Key: embedding(0), ID: "a", Value: "same text"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := tree.InsertNode(Node{Key: embedding(0), ID: "b", Value: "same text"}); err != nil {
+		t.Fatalf("same text under a second key: %v", err)
+	}
+	if tree.Len() != 2 {
+		t.Fatalf("Len = %d, want 2", tree.Len())
+	}
+	for _, id := range []string{"a", "b"} {
+		if _, ok := tree.GetID(id); !ok {
+			t.Errorf("%s not stored", id)
+		}
+	}
+}
+
+func TestInsertDuplicateKeyAndEmbedding(t *testing.T) {
+	tree := NewTree()
+	if err := tree.InsertNode(Node{Key: embedding(0), ID: "a", Value: "text"}); err != nil {
+		t.Fatal(err)
+	}
+	// Float noise below the quantum still counts as the same embedding
+	key := embedding(0)
+	key[1] = dedupQuantum / 10
+	if err := tree.InsertNode(Node{Key: key, ID: "a", Value: "text"}); !errors.Is(err, ErrDuplicateKey) {
+		t.Fatalf("err = %v, want ErrDuplicateKey", err)
+	}
+	if tree.Len() != 1 || tree.DuplicateCount() != 1 {
+		t.Fatalf("Len = %d, DuplicateCount = %d, want 1 and 1", tree.Len(), tree.DuplicateCount())
+	}
+
+	// The same key with another embedding is still the same key
+	if err := tree.InsertNode(Node{Key: embedding(1), ID: "a", Value: "other text"}); !errors.Is(err, ErrDuplicateKey) {
+		t.Fatalf("err = %v for a new text under a stored key, want ErrDuplicateKey", err)
+	}
+	if tree.Len() != 1 {
+		t.Fatalf("Len = %d, want 1", tree.Len())
+	}
+}
+
+func TestUpsertNodeReplacesKey(t *testing.T) {
+	tree := NewTree()
+	tree.InsertNode(Node{Key: embedding(0), ID: "a", Value: "old", Metadata: `{"v":1}`})
+	tree.InsertNode(Node{Key: embedding(1), ID: "b", Value: "other"})
+
+	replaced, err := tree.UpsertNode(Node{Key: embedding(2), ID: "a", Value: "new", Metadata: `{"v":2}`})
+	if !replaced || err != nil {
+		t.Fatalf("UpsertNode = %v, %v", replaced, err)
+	}
+	if tree.Len() != 2 {
+		t.Fatalf("Len = %d, want 2", tree.Len())
+	}
+	if n, _ := tree.GetID("a"); n.Value != "new" || n.Metadata != `{"v":2}` || n.Key != embedding(2) {
+		t.Fatalf("GetID(a) = %q %q, want the new node", n.Value, n.Metadata)
+	}
+	if hits := tree.Search(embedding(0), 0.1, 0, 10); len(hits) != 0 {
+		t.Fatalf("old embedding still found: %v", hits[0].ID)
+	}
+	if hits := tree.Search(embedding(2), 0.1, 0, 10); len(hits) != 1 || hits[0].Value != "new" {
+		t.Fatalf("new embedding not found: %v", hits)
+	}
+
+	// The same node again is a duplicate, and a new ID is an insert
+	if _, err := tree.UpsertNode(Node{Key: embedding(2), ID: "a", Value: "new"}); !errors.Is(err, ErrDuplicateKey) {
+		t.Fatalf("err = %v, want ErrDuplicateKey", err)
+	}
+	if replaced, err := tree.UpsertNode(Node{Key: embedding(3), ID: "c", Value: "third"}); replaced || err != nil {
+		t.Fatalf("UpsertNode(c) = %v, %v", replaced, err)
+	}
+}
+
+func TestIDLookupsOnLoadedTree(t *testing.T) {
+	// Storages fill Nodes directly; files from before IDs were unique may
+	// repeat one, and the first node wins
+	tree := &Tree{Nodes: []Node{
+		{Key: embedding(0), ID: "a", Value: "first"},
+		{Key: embedding(1), ID: "b", Value: "second"},
+		{Key: embedding(2), ID: "a", Value: "stale"},
+	}}
+	if i := tree.FindID("b"); i != 1 {
+		t.Fatalf("FindID(b) = %d, want 1", i)
+	}
+	if n, _ := tree.GetID("a"); n.Value != "first" {
+		t.Fatalf("GetID(a) = %q, want first", n.Value)
+	}
+	if err := tree.InsertNode(Node{Key: embedding(3), ID: "b", Value: "again"}); !errors.Is(err, ErrDuplicateKey) {
+		t.Fatalf("err = %v, want ErrDuplicateKey", err)
+	}
+
+	// Removals shift positions; lookups follow
+	tree.RemoveID("a")
+	if i := tree.FindID("b"); i != 0 {
+		t.Fatalf("FindID(b) = %d after removing a, want 0", i)
+	}
+	if removed := tree.RemoveIDs([]string{"b", "missing", "b"}); len(removed) != 1 || removed[0] != "b" {
+		t.Fatalf("RemoveIDs = %v, want [b]", removed)
+	}
+	if tree.HasID("b") {
+		t.Fatal("b still found")
+	}
+}
+
+func TestReplaceIDOntoAnotherKeysEmbedding(t *testing.T) {
+	tree := NewTree()
+	tree.InsertNode(Node{Key: embedding(0), ID: "a", Value: "first"})
+	tree.InsertNode(Node{Key: embedding(1), ID: "b", Value: "second"})
+
+	found, err := tree.ReplaceID("b", embedding(0), "first")
+	if !found || err != nil {
+		t.Fatalf("ReplaceID = %v, %v", found, err)
+	}
+	if n, _ := tree.GetID("b"); n.Key != embedding(0) {
+		t.Fatal("b kept its old embedding")
+	}
+}
+
+func TestDedupSurvivesRemove(t *testing.T) {
+	tree := NewTree()
+	tree.InsertNode(Node{Key: embedding(0), ID: "a", Value: "text"})
+	tree.InsertNode(Node{Key: embedding(1), ID: "b", Value: "text"})
+	tree.RemoveID("a")
+
+	if err := tree.InsertNode(Node{Key: embedding(1), ID: "b", Value: "text"}); !errors.Is(err, ErrDuplicateKey) {
+		t.Fatalf("err = %v after removing another node, want ErrDuplicateKey", err)
+	}
+	if err := tree.InsertNode(Node{Key: embedding(0), ID: "a", Value: "text"}); err != nil {
+		t.Fatalf("reinserting a removed node: %v", err)
+	}
+}
+
+func TestDeepCopyIsolation(t *testing.T) {
+	tree := NewTree()
+	for i := range 10 {
+		tree.InsertNode(Node{Key: embedding(i), ID: fmt.Sprintf("k%d", i), Value: "v"})
+	}
+	tree.RebuildIndex()
+
+	cp := tree.DeepCopy()
+
+	// Writes to either side stay there
+	if err := tree.InsertNode(Node{Key: embedding(100), ID: "original", Value: "v"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := cp.InsertNode(Node{Key: embedding(100), ID: "copy", Value: "v"}); err != nil {
+		t.Fatalf("insert into copy: %v", err)
+	}
+	cp.RemoveID("k0")
+	cp.RebuildIndex()
+
+	if tree.Len() != 11 || cp.Len() != 10 {
+		t.Fatalf("lengths %d and %d, want 11 and 10", tree.Len(), cp.Len())
+	}
+	if _, ok := tree.GetID("k0"); !ok {
+		t.Error("delete from the copy removed k0 from the original")
+	}
+	if _, ok := tree.GetID("copy"); ok {
+		t.Error("insert into the copy reached the original")
+	}
+	if _, ok := cp.GetID("original"); ok {
+		t.Error("insert into the original reached the copy")
+	}
+
+	// Both indexes still find every node they hold
+	for _, tr := range []*Tree{tree, cp} {
+		for _, id := range tr.IDs() {
+			node, _ := tr.GetID(id)
+			got := tr.SearchWithOptions(node.Key, SearchOptions{Epsilon: 0.1, Threshold: 0, TopK: 1})
+			if len(got) != 1 || got[0].ID != id {
+				t.Errorf("search for %s found %v", id, got)
+			}
+		}
+	}
+}
+
+func TestDeepCopyConcurrentWithInserts(t *testing.T) {
+	// An empty index counts as unbuilt, so seed a node to have inserts
+	// update the index incrementally
+	tree := NewTree()
+	tree.InsertNode(Node{Key: embedding(0), ID: "seed", Value: "v"})
+	tree.RebuildIndex()
+
+	var wg sync.WaitGroup
+	for w := range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range 200 {
+				key := embedding((w*200 + i) % 512)
+				key[511-i%512] += float32(w + 2)
+				tree.InsertNode(Node{Key: key, ID: fmt.Sprintf("w%d-%d", w, i), Value: "v"})
+			}
+		}()
+	}
+
+	// Every copy taken mid-insert is consistent: each node is indexed in
+	// every dimension
+	for range 50 {
+		cp := tree.DeepCopy()
+		for dim := range 512 {
+			if len(cp.Index[dim]) != len(cp.Nodes) {
+				t.Fatalf("copy holds %d nodes but %d index entries in dimension %d", len(cp.Nodes), len(cp.Index[dim]), dim)
+			}
+		}
+	}
+	wg.Wait()
+}
+
+// Run with -race: upserts of new IDs patch a built index concurrently, and
+// replacements among them leave every node indexed once in each dimension
+func TestConcurrentUpsertsPatchIndex(t *testing.T) {
+	tree := NewTree()
+	tree.InsertNode(Node{Key: embedding(0), ID: "seed", Value: "v"})
+	tree.RebuildIndex()
+
+	var wg sync.WaitGroup
+	for w := range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range 100 {
+				key := embedding((w*100 + i) % 512)
+				key[511-i] += float32(w + 2)
+				if _, err := tree.UpsertNode(Node{Key: key, ID: fmt.Sprintf("w%d-%d", w, i%50), Value: "v"}); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if tree.Len() != 1+4*50 {
+		t.Fatalf("Len = %d, want %d", tree.Len(), 1+4*50)
+	}
+	if entries := tree.IndexEntries(); entries != 512*tree.Len() {
+		t.Fatalf("index holds %d entries, want %d", entries, 512*tree.Len())
+	}
+	for _, id := range tree.IDs() {
+		node, _ := tree.GetID(id)
+		got := tree.SearchWithOptions(node.Key, SearchOptions{Epsilon: 0.1, Threshold: 0, TopK: 1})
+		if len(got) != 1 || got[0].ID != id {
+			t.Fatalf("search for %s found %v", id, got)
+		}
+	}
+}
+
+// checkConsistent fails unless tree's index and lookup maps match what
+// rebuilding them from its nodes gives
+func checkConsistent(t *testing.T, tree *Tree) {
+	t.Helper()
+	want := &Tree{Nodes: slices.Clone(tree.Nodes)}
+	want.rebuildIndexLocked()
+
+	if !maps.Equal(tree.ids, want.ids) {
+		t.Fatalf("ID lookups %v, want %v", tree.ids, want.ids)
+	}
+	if !maps.Equal(tree.DedupIndex, want.DedupIndex) {
+		t.Fatalf("dedup lookups hold %d entries, want %d", len(tree.DedupIndex), len(want.DedupIndex))
+	}
+	if tree.indexDirty {
+		return
+	}
+	for dim := range tree.Index {
+		if !slices.Equal(tree.Index[dim], want.Index[dim]) {
+			t.Fatalf("index of dimension %d is %v, want %v", dim, tree.Index[dim], want.Index[dim])
+		}
+	}
+}
+
+func TestRemoveKeepsLookupsConsistent(t *testing.T) {
+	tree := randomTree(200)
+	tree.Nodes[7].ID = "" // Nodes without an ID are only in the dedup map
+	tree.RebuildIndex()
+
+	if removed := tree.RemoveMany([]int{0, 7, 8, 8, 100, 199, -1, 500}); removed != 5 {
+		t.Fatalf("RemoveMany removed %d, want 5", removed)
+	}
+	checkConsistent(t, tree)
+	if removed := tree.RemoveIDs([]string{"node50", "node150", "missing"}); len(removed) != 2 {
+		t.Fatalf("RemoveIDs = %v", removed)
+	}
+	checkConsistent(t, tree)
+	if !tree.RemoveID("node1") || !tree.Remove(tree.Len()-1) {
+		t.Fatal("single removal found nothing")
+	}
+	checkConsistent(t, tree)
+
+	if tree.Len() != 191 {
+		t.Fatalf("Len = %d, want 191", tree.Len())
+	}
+	for _, id := range []string{"node0", "node1", "node8", "node50", "node100", "node150", "node198", "node199"} {
+		if tree.HasID(id) {
+			t.Errorf("%s still found", id)
+		}
+	}
+	for i, id := range tree.IDs() {
+		if got := tree.FindID(id); got != i {
+			t.Fatalf("FindID(%s) = %d, want %d", id, got, i)
+		}
+		got := tree.SearchWithOptions(tree.Nodes[i].Key, SearchOptions{Epsilon: 1, Threshold: 1, TopK: 1})
+		if len(got) != 1 || got[0].ID != id {
+			t.Fatalf("search for %s found %v", id, got)
+		}
+	}
+
+	// Kept nodes are still duplicates; removed ones can come back
+	if err := tree.InsertNode(tree.Nodes[3]); !errors.Is(err, ErrDuplicateKey) {
+		t.Fatalf("reinserting a kept node: err = %v, want ErrDuplicateKey", err)
+	}
+	removed := randomTree(200).Nodes[100]
+	if err := tree.InsertNode(removed); err != nil {
+		t.Fatalf("reinserting a removed node: %v", err)
+	}
+	checkConsistent(t, tree)
+}
+
+func TestRemoveUncoversShadowedNode(t *testing.T) {
+	// A file from before IDs were unique: removing the node an ID resolves
+	// to leaves the next node with that ID in its place
+	tree := &Tree{Nodes: []Node{
+		{Key: embedding(0), ID: "a", Value: "first"},
+		{Key: embedding(1), ID: "b", Value: "second"},
+		{Key: embedding(2), ID: "a", Value: "stale"},
+		{Key: embedding(3), ID: "c", Value: "third"},
+	}}
+	tree.RebuildIndex()
+
+	tree.RemoveMany([]int{0})
+	checkConsistent(t, tree)
+	if n, _ := tree.GetID("a"); n.Value != "stale" {
+		t.Fatalf("GetID(a) = %q, want stale", n.Value)
+	}
+	tree.RemoveID("a")
+	checkConsistent(t, tree)
+
+	// With nothing shadowed any more, removals patch the maps in place
+	if tree.shadowed != 0 {
+		t.Fatalf("shadowed = %d, want 0", tree.shadowed)
+	}
+	tree.RemoveID("b")
+	checkConsistent(t, tree)
+	if i := tree.FindID("c"); i != 0 {
+		t.Fatalf("FindID(c) = %d, want 0", i)
+	}
+}
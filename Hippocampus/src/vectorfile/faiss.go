@@ -0,0 +1,163 @@
+package vectorfile
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+)
+
+// Metric is a FAISS metric type
+type Metric int32
+
+const (
+	MetricInnerProduct Metric = 0
+	MetricL2           Metric = 1
+)
+
+func (m Metric) String() string {
+	switch m {
+	case MetricInnerProduct:
+		return "inner product"
+	case MetricL2:
+		return "L2"
+	}
+	return fmt.Sprintf("metric %d", int32(m))
+}
+
+// FAISS index types, as the four characters faiss writes first
+const (
+	fourccFlatL2 = "IxF2"
+	fourccFlatIP = "IxFI"
+	fourccFlat   = "IxFl" // Flat with another metric
+	fourccIDMap  = "IxMp"
+	fourccIDMap2 = "IxM2"
+)
+
+// maxFAISSValues bounds the vector data read, so a damaged count can't
+// cause a huge allocation: 1<<31 floats is 8GB
+const maxFAISSValues = 1 << 31
+
+// ReadFAISS reads a flat index (IndexFlatL2, IndexFlatIP or IndexFlat),
+// optionally wrapped in an IndexIDMap, returning its vectors and metric.
+// Other index types hold compressed or partitioned vectors and are refused.
+func ReadFAISS(r io.Reader) (*Vectors, Metric, error) {
+	br := bufio.NewReader(r)
+	return readFAISSIndex(br)
+}
+
+func readFAISSIndex(r io.Reader) (*Vectors, Metric, error) {
+	var h [4]byte
+	if _, err := io.ReadFull(r, h[:]); err != nil {
+		return nil, 0, fmt.Errorf("reading FAISS index type: %w", err)
+	}
+
+	switch string(h[:]) {
+	case fourccFlatL2, fourccFlatIP, fourccFlat:
+		dim, total, metric, err := readFAISSHeader(r)
+		if err != nil {
+			return nil, 0, err
+		}
+		var count uint64
+		if err := binary.Read(r, binary.LittleEndian, &count); err != nil {
+			return nil, 0, fmt.Errorf("reading FAISS vector count: %w", err)
+		}
+		if count != uint64(dim)*uint64(total) {
+			return nil, 0, fmt.Errorf("FAISS index holds %d values, expected %d vectors of %d dimensions", count, total, dim)
+		}
+		if count > maxFAISSValues {
+			return nil, 0, fmt.Errorf("FAISS index too large: %d values", count)
+		}
+		data := make([]float32, count)
+		if err := binary.Read(r, binary.LittleEndian, data); err != nil {
+			return nil, 0, fmt.Errorf("reading FAISS vectors: %w", err)
+		}
+		return &Vectors{Dim: int(dim), Data: data}, metric, nil
+
+	case fourccIDMap, fourccIDMap2:
+		_, total, _, err := readFAISSHeader(r)
+		if err != nil {
+			return nil, 0, err
+		}
+		v, metric, err := readFAISSIndex(r)
+		if err != nil {
+			return nil, 0, err
+		}
+		var count uint64
+		if err := binary.Read(r, binary.LittleEndian, &count); err != nil {
+			return nil, 0, fmt.Errorf("reading FAISS ID map: %w", err)
+		}
+		if count != uint64(total) || int(count) != v.Len() {
+			return nil, 0, fmt.Errorf("FAISS ID map holds %d IDs for %d vectors", count, v.Len())
+		}
+		v.IDs = make([]int64, count)
+		if err := binary.Read(r, binary.LittleEndian, v.IDs); err != nil {
+			return nil, 0, fmt.Errorf("reading FAISS ID map: %w", err)
+		}
+		return v, metric, nil
+	}
+	return nil, 0, fmt.Errorf("unsupported FAISS index type %q (only flat indexes, optionally in an IndexIDMap, hold raw vectors)", h[:])
+}
+
+// readFAISSHeader reads the fields faiss writes for every index
+func readFAISSHeader(r io.Reader) (dim int32, total int64, metric Metric, err error) {
+	var header struct {
+		Dim       int32
+		Total     int64
+		Dummy1    int64
+		Dummy2    int64
+		IsTrained uint8
+		Metric    int32
+	}
+	if err := binary.Read(r, binary.LittleEndian, &header); err != nil {
+		return 0, 0, 0, fmt.Errorf("reading FAISS header: %w", err)
+	}
+	if header.Dim <= 0 || header.Total < 0 {
+		return 0, 0, 0, fmt.Errorf("invalid FAISS header: d=%d ntotal=%d", header.Dim, header.Total)
+	}
+	if header.Metric > 1 {
+		// Lp and other metrics carry an argument
+		var arg float32
+		if err := binary.Read(r, binary.LittleEndian, &arg); err != nil {
+			return 0, 0, 0, fmt.Errorf("reading FAISS header: %w", err)
+		}
+	}
+	return header.Dim, header.Total, Metric(header.Metric), nil
+}
+
+// WriteFAISSFlat writes v as an IndexFlatL2 or IndexFlatIP, readable with
+// faiss.read_index
+func WriteFAISSFlat(w io.Writer, v *Vectors, metric Metric) error {
+	fourcc := fourccFlatL2
+	switch metric {
+	case MetricL2:
+	case MetricInnerProduct:
+		fourcc = fourccFlatIP
+	default:
+		return fmt.Errorf("unsupported FAISS metric %s", metric)
+	}
+
+	bw := bufio.NewWriter(w)
+	bw.WriteString(fourcc)
+	header := struct {
+		Dim       int32
+		Total     int64
+		Dummy1    int64
+		Dummy2    int64
+		IsTrained uint8
+		Metric    int32
+	}{int32(v.Dim), int64(v.Len()), 1 << 20, 1 << 20, 1, int32(metric)}
+	if err := binary.Write(bw, binary.LittleEndian, header); err != nil {
+		return err
+	}
+	if err := binary.Write(bw, binary.LittleEndian, uint64(len(v.Data))); err != nil {
+		return err
+	}
+	var buf [4]byte
+	for _, f := range v.Data {
+		binary.LittleEndian.PutUint32(buf[:], math.Float32bits(f))
+		bw.Write(buf[:])
+	}
+	return bw.Flush()
+}
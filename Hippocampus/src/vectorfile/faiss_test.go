@@ -0,0 +1,92 @@
+package vectorfile
+
+import (
+	"bytes"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// The .faiss fixtures follow faiss's write_index layout: IndexFlatL2,
+// IndexFlatIP, an IndexIDMap around an IndexFlatL2, and the start of an
+// IndexIVFFlat
+
+func TestReadFAISS(t *testing.T) {
+	tests := []struct {
+		fixture string
+		metric  Metric
+		ids     []int64
+	}{
+		{"flat_l2.faiss", MetricL2, nil},
+		{"flat_ip.faiss", MetricInnerProduct, nil},
+		{"idmap.faiss", MetricL2, []int64{10, 20, 30}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.fixture, func(t *testing.T) {
+			v, metric, err := ReadFAISS(bytes.NewReader(readFixture(t, tt.fixture)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkVectors(t, v)
+			if metric != tt.metric {
+				t.Fatalf("metric %s, want %s", metric, tt.metric)
+			}
+			if !slices.Equal(v.IDs, tt.ids) {
+				t.Fatalf("IDs %v, want %v", v.IDs, tt.ids)
+			}
+		})
+	}
+}
+
+func TestReadFAISSInvalid(t *testing.T) {
+	flat := readFixture(t, "flat_l2.faiss")
+	tests := []struct {
+		name string
+		data []byte
+		want string
+	}{
+		{"IVF index", readFixture(t, "ivf.faiss"), "unsupported FAISS index type"},
+		{"empty", nil, "reading FAISS index type"},
+		{"truncated header", flat[:20], "reading FAISS header"},
+		{"truncated vectors", flat[:len(flat)-2], "reading FAISS vectors"},
+		{"truncated ID map", func() []byte {
+			idmap := readFixture(t, "idmap.faiss")
+			return idmap[:len(idmap)-4]
+		}(), "reading FAISS ID map"},
+		{"count mismatch", func() []byte {
+			data := bytes.Clone(flat)
+			data[4+4+8+8+8+1+4] = 11 // Value count, after the header
+			return data
+		}(), "expected 3 vectors of 4 dimensions"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, err := ReadFAISS(bytes.NewReader(tt.data))
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("ReadFAISS = %v, want an error containing %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestWriteFAISSFlat(t *testing.T) {
+	for _, tt := range []struct {
+		fixture string
+		metric  Metric
+	}{
+		{"flat_l2.faiss", MetricL2},
+		{"flat_ip.faiss", MetricInnerProduct},
+	} {
+		var buf bytes.Buffer
+		if err := WriteFAISSFlat(&buf, fixtureVectors, tt.metric); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(buf.Bytes(), readFixture(t, tt.fixture)) {
+			t.Errorf("%s index differs from testdata/%s", tt.metric, tt.fixture)
+		}
+	}
+
+	if err := WriteFAISSFlat(&bytes.Buffer{}, fixtureVectors, Metric(3)); err == nil {
+		t.Fatal("wrote an index with an unsupported metric")
+	}
+}
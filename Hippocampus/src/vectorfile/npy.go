@@ -0,0 +1,133 @@
+package vectorfile
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// npyMagic starts every .npy file, followed by the major and minor version
+const npyMagic = "\x93NUMPY"
+
+var (
+	npyDescr   = regexp.MustCompile(`'descr'\s*:\s*'([^']*)'`)
+	npyFortran = regexp.MustCompile(`'fortran_order'\s*:\s*(True|False)`)
+	npyShape   = regexp.MustCompile(`'shape'\s*:\s*\(([^)]*)\)`)
+)
+
+// ReadNPY reads a two-dimensional .npy array of little-endian float32 or
+// float64 values in C order, one vector per row
+func ReadNPY(r io.Reader) (*Vectors, error) {
+	br := bufio.NewReader(r)
+
+	var preamble [8]byte
+	if _, err := io.ReadFull(br, preamble[:]); err != nil {
+		return nil, fmt.Errorf("reading .npy header: %w", err)
+	}
+	if string(preamble[:6]) != npyMagic {
+		return nil, fmt.Errorf("not a .npy file")
+	}
+	var headerLen uint32
+	switch preamble[6] {
+	case 1:
+		var n uint16
+		if err := binary.Read(br, binary.LittleEndian, &n); err != nil {
+			return nil, fmt.Errorf("reading .npy header: %w", err)
+		}
+		headerLen = uint32(n)
+	case 2, 3:
+		if err := binary.Read(br, binary.LittleEndian, &headerLen); err != nil {
+			return nil, fmt.Errorf("reading .npy header: %w", err)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported .npy version %d.%d", preamble[6], preamble[7])
+	}
+	if headerLen > 1<<20 {
+		return nil, fmt.Errorf("invalid .npy header length %d", headerLen)
+	}
+	header := make([]byte, headerLen)
+	if _, err := io.ReadFull(br, header); err != nil {
+		return nil, fmt.Errorf("reading .npy header: %w", err)
+	}
+
+	descr := npyDescr.FindSubmatch(header)
+	fortran := npyFortran.FindSubmatch(header)
+	shape := npyShape.FindSubmatch(header)
+	if descr == nil || fortran == nil || shape == nil {
+		return nil, fmt.Errorf("invalid .npy header %q", bytes.TrimSpace(header))
+	}
+	if string(fortran[1]) == "True" {
+		return nil, fmt.Errorf(".npy array is in Fortran order; save it with numpy.ascontiguousarray")
+	}
+
+	var dims []int
+	for _, s := range strings.Split(string(shape[1]), ",") {
+		if s = strings.TrimSpace(s); s == "" {
+			continue
+		}
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid .npy shape (%s)", shape[1])
+		}
+		dims = append(dims, n)
+	}
+	if len(dims) != 2 {
+		return nil, fmt.Errorf(".npy array has %d dimensions, expected 2 (vectors x dimensions)", len(dims))
+	}
+	rows, dim := dims[0], dims[1]
+	if dim == 0 {
+		return nil, fmt.Errorf(".npy vectors have no dimensions")
+	}
+	if uint64(rows)*uint64(dim) > maxFAISSValues {
+		return nil, fmt.Errorf(".npy array too large: %d x %d", rows, dim)
+	}
+
+	data := make([]float32, rows*dim)
+	switch string(descr[1]) {
+	case "<f4":
+		if err := binary.Read(br, binary.LittleEndian, data); err != nil {
+			return nil, fmt.Errorf("reading .npy data: %w", err)
+		}
+	case "<f8":
+		var buf [8]byte
+		for i := range data {
+			if _, err := io.ReadFull(br, buf[:]); err != nil {
+				return nil, fmt.Errorf("reading .npy data: %w", err)
+			}
+			data[i] = float32(math.Float64frombits(binary.LittleEndian.Uint64(buf[:])))
+		}
+	default:
+		return nil, fmt.Errorf("unsupported .npy dtype %s (expected <f4 or <f8)", descr[1])
+	}
+	return &Vectors{Dim: dim, Data: data}, nil
+}
+
+// WriteNPY writes v as a version 1.0 .npy float32 array of shape
+// (vectors, dimensions), readable with numpy.load
+func WriteNPY(w io.Writer, v *Vectors) error {
+	header := fmt.Sprintf("{'descr': '<f4', 'fortran_order': False, 'shape': (%d, %d), }", v.Len(), v.Dim)
+	// Pad with spaces and a newline so the data starts 64-byte aligned
+	total := len(npyMagic) + 4 + len(header) + 1
+	header += strings.Repeat(" ", (64-total%64)%64) + "\n"
+	if len(header) > math.MaxUint16 {
+		return fmt.Errorf(".npy header too long")
+	}
+
+	bw := bufio.NewWriter(w)
+	bw.WriteString(npyMagic)
+	bw.Write([]byte{1, 0})
+	binary.Write(bw, binary.LittleEndian, uint16(len(header)))
+	bw.WriteString(header)
+	var buf [4]byte
+	for _, f := range v.Data {
+		binary.LittleEndian.PutUint32(buf[:], math.Float32bits(f))
+		bw.Write(buf[:])
+	}
+	return bw.Flush()
+}
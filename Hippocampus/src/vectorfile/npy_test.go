@@ -0,0 +1,56 @@
+package vectorfile
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+// The .npy fixtures follow numpy.save's layout for versions 1.0 and 2.0
+
+func TestReadNPY(t *testing.T) {
+	for _, fixture := range []string{"f4.npy", "f8.npy", "v2.npy"} {
+		t.Run(fixture, func(t *testing.T) {
+			v, err := ReadNPY(bytes.NewReader(readFixture(t, fixture)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkVectors(t, v)
+		})
+	}
+}
+
+func TestReadNPYInvalid(t *testing.T) {
+	f4 := readFixture(t, "f4.npy")
+	tests := []struct {
+		name string
+		data []byte
+		want string
+	}{
+		{"Fortran order", readFixture(t, "fortran.npy"), "Fortran order"},
+		{"one dimension", readFixture(t, "vector.npy"), "has 1 dimensions"},
+		{"integers", readFixture(t, "int64.npy"), "unsupported .npy dtype <i8"},
+		{"not .npy", []byte("PK\x03\x04 a zip file"), "not a .npy file"},
+		{"unknown version", append([]byte("\x93NUMPY\x04\x00"), f4[8:]...), "unsupported .npy version 4.0"},
+		{"truncated header", f4[:40], "reading .npy header"},
+		{"truncated data", f4[:len(f4)-2], "reading .npy data"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ReadNPY(bytes.NewReader(tt.data))
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("ReadNPY = %v, want an error containing %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestWriteNPY(t *testing.T) {
+	var buf bytes.Buffer
+	if err := WriteNPY(&buf, fixtureVectors); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf.Bytes(), readFixture(t, "f4.npy")) {
+		t.Fatalf("WriteNPY output differs from testdata/f4.npy:\n%q", buf.Bytes())
+	}
+}
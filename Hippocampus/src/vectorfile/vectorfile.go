@@ -0,0 +1,88 @@
+// Package vectorfile reads and writes the vector files of other vector
+// stores, so their data can move in and out of a tree without re-embedding:
+// FAISS flat indexes (as written by faiss.write_index) and NumPy .npy arrays.
+// The ID and text of each vector live in a JSONL sidecar, one line per
+// vector in the same order.
+package vectorfile
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// Vectors is a row-major matrix of float32 vectors
+type Vectors struct {
+	Dim  int
+	Data []float32 // Len() * Dim values
+	IDs  []int64   // FAISS IDs from an IndexIDMap; nil otherwise
+}
+
+// Len returns the number of vectors
+func (v *Vectors) Len() int {
+	if v.Dim == 0 {
+		return 0
+	}
+	return len(v.Data) / v.Dim
+}
+
+// Row returns vector i, sharing v's memory
+func (v *Vectors) Row(i int) []float32 {
+	return v.Data[i*v.Dim : (i+1)*v.Dim]
+}
+
+// Meta is one line of a sidecar
+type Meta struct {
+	ID   string `json:"id"`
+	Text string `json:"text"`
+}
+
+// metaLine accepts the field names of Hippocampus exports and of Chroma
+// (ids, documents) next to id and text
+type metaLine struct {
+	ID       string `json:"id"`
+	Key      string `json:"key"`
+	Text     string `json:"text"`
+	Value    string `json:"value"`
+	Document string `json:"document"`
+}
+
+// ReadMeta reads a JSONL sidecar. Each line has an "id" (or "key") and a
+// "text" (or "value" or "document").
+func ReadMeta(r io.Reader) ([]Meta, error) {
+	var metas []Meta
+	dec := json.NewDecoder(bufio.NewReader(r))
+	for line := 1; ; line++ {
+		var m metaLine
+		if err := dec.Decode(&m); err != nil {
+			if err == io.EOF {
+				return metas, nil
+			}
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		meta := Meta{ID: m.ID, Text: m.Text}
+		if meta.ID == "" {
+			meta.ID = m.Key
+		}
+		if meta.Text == "" {
+			meta.Text = m.Value
+		}
+		if meta.Text == "" {
+			meta.Text = m.Document
+		}
+		metas = append(metas, meta)
+	}
+}
+
+// WriteMeta writes a JSONL sidecar
+func WriteMeta(w io.Writer, metas []Meta) error {
+	bw := bufio.NewWriter(w)
+	enc := json.NewEncoder(bw)
+	for _, m := range metas {
+		if err := enc.Encode(m); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
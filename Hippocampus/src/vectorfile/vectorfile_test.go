@@ -0,0 +1,68 @@
+package vectorfile
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// fixtureVectors are the vectors every fixture in testdata holds
+var fixtureVectors = &Vectors{Dim: 4, Data: []float32{
+	1, 0, 0, 0,
+	0, 0.5, -0.5, 0,
+	0.25, 0.25, 0.25, 0.25,
+}}
+
+// readFixture returns the contents of testdata/name
+func readFixture(t *testing.T, name string) []byte {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// checkVectors fails the test unless got holds the fixture vectors
+func checkVectors(t *testing.T, got *Vectors) {
+	t.Helper()
+	if got.Dim != fixtureVectors.Dim || !slices.Equal(got.Data, fixtureVectors.Data) {
+		t.Fatalf("read %d x %d vectors %v, want %v", got.Len(), got.Dim, got.Data, fixtureVectors.Data)
+	}
+	if got.Len() != 3 || !slices.Equal(got.Row(1), []float32{0, 0.5, -0.5, 0}) {
+		t.Fatalf("Len %d, Row(1) %v", got.Len(), got.Row(1))
+	}
+}
+
+func TestReadMeta(t *testing.T) {
+	metas, err := ReadMeta(bytes.NewReader(readFixture(t, "meta.jsonl")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Meta{{"a", "first"}, {"b", "second"}, {"c", "third"}}
+	if !slices.Equal(metas, want) {
+		t.Fatalf("ReadMeta = %v, want %v", metas, want)
+	}
+
+	if _, err := ReadMeta(strings.NewReader("{\"id\": \"a\"}\n{\"id\": \n")); err == nil || !strings.HasPrefix(err.Error(), "line 2:") {
+		t.Fatalf("ReadMeta of a broken second line = %v", err)
+	}
+}
+
+func TestWriteMeta(t *testing.T) {
+	want := []Meta{{"a", "first"}, {"b", "second\nline"}}
+	var buf bytes.Buffer
+	if err := WriteMeta(&buf, want); err != nil {
+		t.Fatal(err)
+	}
+	metas, err := ReadMeta(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(metas, want) {
+		t.Fatalf("read back %v, want %v", metas, want)
+	}
+}
@@ -0,0 +1,59 @@
+// Package version holds the build's version details. They are set at link
+// time, e.g.
+//
+//	go build -ldflags "-X Hippocampus/src/version.Version=1.4.0 \
+//	    -X Hippocampus/src/version.Commit=$(git rev-parse --short HEAD) \
+//	    -X Hippocampus/src/version.BuildDate=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+//
+// which the makefile does. Without them, the commit falls back to the one
+// the Go toolchain stamped from the checkout, and the rest to "unknown".
+package version
+
+import (
+	"runtime"
+	"runtime/debug"
+)
+
+var (
+	Version   = "dev" // Semantic version, e.g. 1.4.0
+	Commit    = ""    // Git commit the build is from
+	BuildDate = ""    // RFC 3339 time of the build
+)
+
+// Info is the version details of the running binary
+type Info struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit"`
+	BuildDate string `json:"build_date"`
+	GoVersion string `json:"go_version"`
+}
+
+// Get returns the version details, taking the commit from the build info if
+// it wasn't set at link time
+func Get() Info {
+	info := Info{Version: Version, Commit: Commit, BuildDate: BuildDate, GoVersion: runtime.Version()}
+	if bi, ok := debug.ReadBuildInfo(); ok {
+		for _, s := range bi.Settings {
+			if s.Key == "vcs.revision" && info.Commit == "" {
+				info.Commit = s.Value
+				if len(info.Commit) > 12 {
+					info.Commit = info.Commit[:12]
+				}
+			}
+		}
+	}
+	if info.Commit == "" {
+		info.Commit = "unknown"
+	}
+	if info.BuildDate == "" {
+		info.BuildDate = "unknown"
+	}
+	return info
+}
+
+// Writer identifies this build in the header of the files it writes,
+// e.g. "hippocampus 1.4.0 (3f2a9c1)"
+func Writer() string {
+	info := Get()
+	return "hippocampus " + info.Version + " (" + info.Commit + ")"
+}
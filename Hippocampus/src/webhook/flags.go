@@ -0,0 +1,75 @@
+package webhook
+
+import (
+	"flag"
+	"fmt"
+	"net/url"
+	"os"
+)
+
+// DefaultSecretEnv is the variable the signing secret is read from unless
+// -webhook-secret-env names another
+const DefaultSecretEnv = "HIPPO_WEBHOOK_SECRET"
+
+// Flags are the webhook flags shared by the Redis and HTTP servers
+type Flags struct {
+	URL         string
+	Events      string
+	SecretEnv   string
+	IncludeText bool
+	Queue       int
+	Retries     int
+}
+
+// RegisterFlags adds -webhook-url, -webhook-events, -webhook-secret-env,
+// -webhook-include-text, -webhook-queue and -webhook-retries to fs
+func RegisterFlags(fs *flag.FlagSet) *Flags {
+	f := &Flags{}
+	fs.StringVar(&f.URL, "webhook-url", "", "POST a JSON event for each memory change to this URL")
+	fs.StringVar(&f.Events, "webhook-events", "insert,update,delete", "Changes to send: insert, update, delete and/or reset")
+	fs.StringVar(&f.SecretEnv, "webhook-secret-env", DefaultSecretEnv, "Environment variable holding the HMAC signing secret (unset sends unsigned requests)")
+	fs.BoolVar(&f.IncludeText, "webhook-include-text", false, "Send memory text instead of its SHA-256")
+	fs.IntVar(&f.Queue, "webhook-queue", defaultQueueSize, "Webhook events buffered before dropping")
+	fs.IntVar(&f.Retries, "webhook-retries", defaultMaxRetries, "Retries of a failed webhook delivery, with exponential backoff")
+	return f
+}
+
+// New starts the dispatcher the flags describe, or returns nil if
+// -webhook-url is unset
+func (f *Flags) New() (*Dispatcher, error) {
+	if f.URL == "" {
+		return nil, nil
+	}
+	if u, err := url.Parse(f.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid -webhook-url %q: expected an http or https URL", f.URL)
+	}
+	events, err := ParseEvents(f.Events)
+	if err != nil {
+		return nil, fmt.Errorf("invalid -webhook-events: %w", err)
+	}
+	if f.Retries < 0 {
+		return nil, fmt.Errorf("-webhook-retries must be non-negative")
+	}
+
+	retries := f.Retries
+	if retries == 0 {
+		retries = -1 // Config treats 0 as the default
+	}
+	return New(Config{
+		URL:         f.URL,
+		Secret:      []byte(os.Getenv(f.SecretEnv)),
+		Events:      events,
+		IncludeText: f.IncludeText,
+		QueueSize:   f.Queue,
+		MaxRetries:  retries,
+	}), nil
+}
+
+// String describes the webhook for the startup log
+func (f *Flags) String() string {
+	signed := "unsigned"
+	if os.Getenv(f.SecretEnv) != "" {
+		signed = "signed"
+	}
+	return fmt.Sprintf("webhook %s (%s, %s)", f.URL, f.Events, signed)
+}
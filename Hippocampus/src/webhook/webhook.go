@@ -0,0 +1,274 @@
+// Package webhook POSTs memory changes to an external URL. Delivery runs in
+// the background from a bounded queue, so a slow or failing endpoint never
+// delays the command that made the change.
+package webhook
+
+import (
+	"Hippocampus/src/client"
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// SignatureHeader carries "sha256=" and the hex HMAC-SHA256 of the body,
+// keyed with the secret, when a secret is set
+const SignatureHeader = "X-Hippocampus-Signature"
+
+const (
+	defaultQueueSize  = 1000
+	defaultMaxRetries = 3
+	defaultTimeout    = 10 * time.Second
+	firstBackoff      = 500 * time.Millisecond
+)
+
+// Config configures a Dispatcher. Zero values take the defaults.
+type Config struct {
+	URL         string
+	Secret      []byte                   // Signs each body when set
+	Events      map[client.ChangeOp]bool // Ops to send; nil sends all
+	IncludeText bool                     // Send the full text rather than its SHA-256
+	QueueSize   int                      // Events waiting for delivery before new ones are dropped
+	MaxRetries  int                      // Further attempts after a failed delivery; negative means none
+	Timeout     time.Duration            // Per attempt
+	HTTPClient  *http.Client
+}
+
+// Event is the JSON body of a webhook request. Text is set instead of
+// TextSHA256 with Config.IncludeText; deletes and resets carry neither.
+type Event struct {
+	Event      client.ChangeOp `json:"event"`
+	Agent      string          `json:"agent"`
+	Key        string          `json:"key,omitempty"`
+	Text       string          `json:"text,omitempty"`
+	TextSHA256 string          `json:"text_sha256,omitempty"`
+	Timestamp  time.Time       `json:"timestamp"`
+}
+
+// Stats counts events since the dispatcher started
+type Stats struct {
+	Queued    int   // Waiting for delivery
+	Delivered int64 // Accepted with a 2xx
+	Failed    int64 // Given up on after the last retry
+	Dropped   int64 // Discarded because the queue was full
+}
+
+// Dispatcher delivers events one at a time, in order, from a bounded queue
+type Dispatcher struct {
+	cfg Config
+
+	mu     sync.Mutex // Guards closed against sends on the closed queue
+	closed bool
+	events chan Event
+
+	ctx    context.Context // Cancelled when Close gives up waiting
+	cancel context.CancelFunc
+	done   chan struct{}
+
+	delivered atomic.Int64
+	failed    atomic.Int64
+	dropped   atomic.Int64
+}
+
+func New(cfg Config) *Dispatcher {
+	if cfg.QueueSize < 1 {
+		cfg.QueueSize = defaultQueueSize
+	}
+	if cfg.MaxRetries == 0 {
+		cfg.MaxRetries = defaultMaxRetries
+	} else if cfg.MaxRetries < 0 {
+		cfg.MaxRetries = 0
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = defaultTimeout
+	}
+	if cfg.HTTPClient == nil {
+		cfg.HTTPClient = &http.Client{}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	d := &Dispatcher{
+		cfg:    cfg,
+		events: make(chan Event, cfg.QueueSize),
+		ctx:    ctx,
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+	go d.run()
+	return d
+}
+
+// Notify queues a change to an agent, dropping it if the queue is full. It
+// never blocks, so it can be used as a client.OnChange hook.
+func (d *Dispatcher) Notify(agentID string, change client.ChangeEvent) {
+	if d.cfg.Events != nil && !d.cfg.Events[change.Op] {
+		return
+	}
+
+	event := Event{Event: change.Op, Agent: agentID, Key: change.Key, Timestamp: change.Time}
+	if change.Value != "" {
+		if d.cfg.IncludeText {
+			event.Text = change.Value
+		} else {
+			sum := sha256.Sum256([]byte(change.Value))
+			event.TextSHA256 = hex.EncodeToString(sum[:])
+		}
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.closed {
+		return
+	}
+	select {
+	case d.events <- event:
+	default:
+		d.dropped.Add(1)
+	}
+}
+
+func (d *Dispatcher) Stats() Stats {
+	return Stats{
+		Queued:    len(d.events),
+		Delivered: d.delivered.Load(),
+		Failed:    d.failed.Load(),
+		Dropped:   d.dropped.Load(),
+	}
+}
+
+// Close stops accepting events and waits for queued ones to be delivered.
+// Once ctx is done, deliveries in progress are abandoned and the rest of the
+// queue counts as failed.
+func (d *Dispatcher) Close(ctx context.Context) {
+	d.mu.Lock()
+	if !d.closed {
+		d.closed = true
+		close(d.events)
+	}
+	d.mu.Unlock()
+
+	select {
+	case <-d.done:
+	case <-ctx.Done():
+		d.cancel()
+		<-d.done
+	}
+	d.cancel()
+}
+
+func (d *Dispatcher) run() {
+	defer close(d.done)
+	abandoned := 0
+	for event := range d.events {
+		if d.ctx.Err() != nil {
+			d.failed.Add(1)
+			abandoned++
+			continue
+		}
+		if err := d.deliver(event); err != nil {
+			d.failed.Add(1)
+			log.Printf("Webhook %s for agent %s failed: %v", event.Event, event.Agent, err)
+			continue
+		}
+		d.delivered.Add(1)
+	}
+	if abandoned > 0 {
+		log.Printf("Webhook dispatcher closed with %d events undelivered", abandoned)
+	}
+}
+
+// deliver posts an event, retrying network errors, 429s and 5xx replies with
+// exponential backoff
+func (d *Dispatcher) deliver(event Event) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	backoff := firstBackoff
+	for attempt := 0; ; attempt++ {
+		retry, err := d.post(body, event.Event)
+		if err == nil {
+			return nil
+		}
+		if !retry || attempt >= d.cfg.MaxRetries {
+			return err
+		}
+
+		timer := time.NewTimer(backoff)
+		select {
+		case <-timer.C:
+		case <-d.ctx.Done():
+			timer.Stop()
+			return fmt.Errorf("%w (shutting down)", err)
+		}
+		backoff *= 2
+	}
+}
+
+// post makes one attempt, reporting whether a failure is worth retrying
+func (d *Dispatcher) post(body []byte, op client.ChangeOp) (retry bool, err error) {
+	ctx, cancel := context.WithTimeout(d.ctx, d.cfg.Timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, "POST", d.cfg.URL, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Hippocampus-Event", string(op))
+	if len(d.cfg.Secret) > 0 {
+		req.Header.Set(SignatureHeader, Sign(d.cfg.Secret, body))
+	}
+
+	resp, err := d.cfg.HTTPClient.Do(req)
+	if err != nil {
+		return d.ctx.Err() == nil, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10)) // Lets the connection be reused
+
+	if resp.StatusCode/100 == 2 {
+		return false, nil
+	}
+	retry = resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+	return retry, fmt.Errorf("status %d", resp.StatusCode)
+}
+
+// Sign returns the SignatureHeader value for body, for receivers checking
+// requests with hmac.Equal
+func Sign(secret, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// ParseEvents parses a comma-separated list of ops to send
+func ParseEvents(s string) (map[client.ChangeOp]bool, error) {
+	events := make(map[client.ChangeOp]bool)
+	for _, name := range strings.Split(s, ",") {
+		op := client.ChangeOp(strings.ToLower(strings.TrimSpace(name)))
+		switch op {
+		case "":
+			continue
+		case client.OpInsert, client.OpUpdate, client.OpDelete, client.OpReset:
+			events[op] = true
+		default:
+			return nil, fmt.Errorf("unknown webhook event %q (expected insert, update, delete or reset)", name)
+		}
+	}
+	if len(events) == 0 {
+		return nil, fmt.Errorf("no webhook events given")
+	}
+	return events, nil
+}
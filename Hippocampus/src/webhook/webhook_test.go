@@ -0,0 +1,275 @@
+package webhook
+
+import (
+	"Hippocampus/src/client"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// request is what the receiver saw of one delivery attempt
+type request struct {
+	header http.Header
+	body   []byte
+}
+
+// receiver records each request and replies with the next status of
+// statuses, then 200 once they run out
+type receiver struct {
+	mu       sync.Mutex
+	statuses []int
+	requests []request
+}
+
+func startReceiver(t *testing.T, statuses ...int) (*receiver, string) {
+	r := &receiver{statuses: statuses}
+	srv := httptest.NewServer(r)
+	t.Cleanup(srv.Close)
+	return r, srv.URL
+}
+
+func (r *receiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	body, _ := io.ReadAll(req.Body)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.requests = append(r.requests, request{header: req.Header.Clone(), body: body})
+	if len(r.statuses) > 0 {
+		w.WriteHeader(r.statuses[0])
+		r.statuses = r.statuses[1:]
+	}
+}
+
+func (r *receiver) received() []request {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]request(nil), r.requests...)
+}
+
+// closeWithin closes d, failing the test if queued events take too long
+func closeWithin(t *testing.T, d *Dispatcher, timeout time.Duration) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	d.Close(ctx)
+	if ctx.Err() != nil {
+		t.Fatalf("Close took over %s", timeout)
+	}
+}
+
+func TestDeliverySignedEvents(t *testing.T) {
+	r, url := startReceiver(t)
+	secret := []byte("s3cret")
+	d := New(Config{URL: url, Secret: secret, Events: map[client.ChangeOp]bool{client.OpInsert: true, client.OpDelete: true}})
+
+	at := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	d.Notify("alice", client.ChangeEvent{Op: client.OpInsert, Key: "k1", Value: "tea", Time: at})
+	d.Notify("alice", client.ChangeEvent{Op: client.OpUpdate, Key: "k1", Value: "green tea", Time: at})
+	d.Notify("bob", client.ChangeEvent{Op: client.OpDelete, Key: "k2", Time: at})
+	closeWithin(t, d, 5*time.Second)
+
+	// Updates are filtered out; the rest arrive in order
+	requests := r.received()
+	if len(requests) != 2 {
+		t.Fatalf("%d requests, want 2", len(requests))
+	}
+	sum := sha256.Sum256([]byte("tea"))
+	want := []Event{
+		{Event: client.OpInsert, Agent: "alice", Key: "k1", TextSHA256: hex.EncodeToString(sum[:]), Timestamp: at},
+		{Event: client.OpDelete, Agent: "bob", Key: "k2", Timestamp: at},
+	}
+	for i, req := range requests {
+		var got Event
+		if err := json.Unmarshal(req.body, &got); err != nil {
+			t.Fatal(err)
+		}
+		if got != want[i] {
+			t.Errorf("event %d = %+v, want %+v", i, got, want[i])
+		}
+		if op := req.header.Get("X-Hippocampus-Event"); op != string(want[i].Event) {
+			t.Errorf("X-Hippocampus-Event %q, want %q", op, want[i].Event)
+		}
+		if ct := req.header.Get("Content-Type"); ct != "application/json" {
+			t.Errorf("Content-Type %q", ct)
+		}
+
+		// Receivers check the signature with their own HMAC of the body
+		mac := hmac.New(sha256.New, secret)
+		mac.Write(req.body)
+		sig := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+		if got := req.header.Get(SignatureHeader); !hmac.Equal([]byte(got), []byte(sig)) {
+			t.Errorf("%s %q, want %q", SignatureHeader, got, sig)
+		}
+	}
+	if stats := d.Stats(); stats.Delivered != 2 || stats.Failed != 0 || stats.Dropped != 0 {
+		t.Fatalf("stats %+v", stats)
+	}
+}
+
+func TestDeliveryOptions(t *testing.T) {
+	r, url := startReceiver(t)
+	d := New(Config{URL: url, IncludeText: true})
+	d.Notify("alice", client.ChangeEvent{Op: client.OpReset})
+	d.Notify("alice", client.ChangeEvent{Op: client.OpInsert, Key: "k1", Value: "tea"})
+	closeWithin(t, d, 5*time.Second)
+
+	requests := r.received()
+	if len(requests) != 2 {
+		t.Fatalf("%d requests with no event filter, want 2", len(requests))
+	}
+	var got Event
+	if err := json.Unmarshal(requests[1].body, &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Text != "tea" || got.TextSHA256 != "" {
+		t.Errorf("event %+v, want the text itself", got)
+	}
+	if sig := requests[1].header.Get(SignatureHeader); sig != "" {
+		t.Errorf("unsigned dispatcher sent %s %q", SignatureHeader, sig)
+	}
+}
+
+func TestDeliveryRetries(t *testing.T) {
+	tests := []struct {
+		name          string
+		statuses      []int
+		maxRetries    int
+		wantAttempts  int
+		wantDelivered int64
+	}{
+		{"5xx then success", []int{503}, 1, 2, 1},
+		{"429 then success", []int{429}, 1, 2, 1},
+		{"gives up after max retries", []int{500, 502, 504}, 1, 2, 0},
+		{"no retries", []int{500}, -1, 1, 0},
+		{"4xx is not retried", []int{400}, 3, 1, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, url := startReceiver(t, tt.statuses...)
+			d := New(Config{URL: url, MaxRetries: tt.maxRetries})
+			d.Notify("alice", client.ChangeEvent{Op: client.OpInsert, Key: "k1", Value: "tea"})
+			closeWithin(t, d, 5*time.Second)
+
+			requests := r.received()
+			if len(requests) != tt.wantAttempts {
+				t.Fatalf("%d attempts, want %d", len(requests), tt.wantAttempts)
+			}
+			// Every attempt sends the same body
+			for _, req := range requests[1:] {
+				if string(req.body) != string(requests[0].body) {
+					t.Fatalf("retry sent %s, first attempt %s", req.body, requests[0].body)
+				}
+			}
+			stats := d.Stats()
+			if stats.Delivered != tt.wantDelivered || stats.Failed != 1-tt.wantDelivered {
+				t.Fatalf("stats %+v", stats)
+			}
+		})
+	}
+}
+
+func TestQueueOverflowDrops(t *testing.T) {
+	arrived := make(chan struct{})
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		arrived <- struct{}{}
+		<-release
+	}))
+	defer srv.Close()
+
+	d := New(Config{URL: srv.URL, QueueSize: 2})
+	d.Notify("alice", client.ChangeEvent{Op: client.OpInsert, Key: "k0"})
+	<-arrived
+
+	// The first event is in flight, so two fit in the queue and the rest
+	// are dropped without blocking
+	for _, key := range []string{"k1", "k2", "k3", "k4"} {
+		d.Notify("alice", client.ChangeEvent{Op: client.OpInsert, Key: key})
+	}
+	if stats := d.Stats(); stats.Queued != 2 || stats.Dropped != 2 {
+		t.Fatalf("stats %+v, want 2 queued and 2 dropped", stats)
+	}
+
+	go func() {
+		for range arrived {
+		}
+	}()
+	close(release)
+	closeWithin(t, d, 5*time.Second)
+	close(arrived)
+	if stats := d.Stats(); stats.Delivered != 3 || stats.Dropped != 2 || stats.Queued != 0 {
+		t.Fatalf("stats %+v after Close, want 3 delivered", stats)
+	}
+
+	// Events after Close are ignored
+	d.Notify("alice", client.ChangeEvent{Op: client.OpInsert, Key: "late"})
+	if stats := d.Stats(); stats.Dropped != 2 || stats.Queued != 0 {
+		t.Fatalf("stats %+v after a late event", stats)
+	}
+}
+
+func TestCloseAbandonsQueue(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer srv.Close()
+	defer close(release)
+
+	d := New(Config{URL: srv.URL})
+	for _, key := range []string{"k1", "k2", "k3"} {
+		d.Notify("alice", client.ChangeEvent{Op: client.OpInsert, Key: key})
+	}
+
+	// A stuck endpoint doesn't hold up Close past its deadline
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	d.Close(ctx)
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("Close took %s", elapsed)
+	}
+	if stats := d.Stats(); stats.Failed != 3 || stats.Delivered != 0 {
+		t.Fatalf("stats %+v, want all 3 failed", stats)
+	}
+}
+
+func TestFlagsNew(t *testing.T) {
+	tests := []struct {
+		name    string
+		flags   Flags
+		wantErr bool
+	}{
+		{"unset", Flags{}, false},
+		{"valid", Flags{URL: "https://example.com/hook", Events: "insert, delete"}, false},
+		{"not http", Flags{URL: "ftp://example.com", Events: "insert"}, true},
+		{"no host", Flags{URL: "http://", Events: "insert"}, true},
+		{"unknown event", Flags{URL: "http://example.com", Events: "insert,upsert"}, true},
+		{"no events", Flags{URL: "http://example.com", Events: " , "}, true},
+		{"negative retries", Flags{URL: "http://example.com", Events: "insert", Retries: -1}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d, err := tt.flags.New()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if d != nil {
+				d.Close(context.Background())
+			}
+			if (d != nil) != (tt.flags.URL != "" && !tt.wantErr) {
+				t.Fatalf("dispatcher = %v", d)
+			}
+		})
+	}
+}
@@ -113,7 +113,8 @@ class HippocampusClient:
         """Delete agent's knowledge base"""
         try:
             response = self._send_command("DEL", agent_id)
-            return "+OK" in response
+            # DEL replies with the number of agents removed (:0 or :1)
+            return response.startswith(":")
         except Exception as e:
             print(f"Error deleting: {e}")
             return False
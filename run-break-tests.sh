@@ -36,7 +36,7 @@ echo -e "${GREEN}✓ Ollama is running${NC}"
 # Check Hippocampus
 if ! check_port 6379; then
     echo -e "${RED}✗ Hippocampus is not running${NC}"
-    echo -e "${YELLOW}Start it with: ./Hippocampus/bin/hippocampus-server -addr :6379 -mock=true -ttl 30m${NC}"
+    echo -e "${YELLOW}Start it with: ./Hippocampus/bin/hippocampus-server -addr :6379 -embedder mock -ttl 30m${NC}"
     exit 1
 fi
 echo -e "${GREEN}✓ Hippocampus is running${NC}\n"
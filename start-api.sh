@@ -73,7 +73,7 @@ if check_port 6379; then
     echo -e "${GREEN}✓ Hippocampus is already running${NC}\n"
 else
     echo -e "${YELLOW}Starting Hippocampus Redis server...${NC}"
-    ./Hippocampus/bin/hippocampus-server -addr :6379 -mock=true -ttl 30m > /tmp/hippocampus.log 2>&1 &
+    ./Hippocampus/bin/hippocampus-server -addr :6379 -embedder mock -ttl 30m > /tmp/hippocampus.log 2>&1 &
     HIPPO_PID=$!
     echo $HIPPO_PID > /tmp/hippocampus.pid
 